@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"sync"
 	"syscall"
@@ -18,47 +21,61 @@ import (
 	"parsedmarc-go/internal/kafka"
 	"parsedmarc-go/internal/logger"
 	"parsedmarc-go/internal/output"
+	"parsedmarc-go/internal/output/elasticsearch"
 	"parsedmarc-go/internal/parser"
 	"parsedmarc-go/internal/smtp"
 	"parsedmarc-go/internal/storage/clickhouse"
+	"parsedmarc-go/internal/storage/postgres"
+	"parsedmarc-go/internal/syslog"
 )
 
-const version = "1.0.0"
+// version is overridden at build time with -ldflags "-X main.version=..."
+var version = "1.0.0"
 
 func main() {
 	var (
-		configFile   = flag.String("config", "config.yaml", "Config file path")
-		inputFile    = flag.String("input", "", "Input file or directory to parse")
-		outputFile   = flag.String("output", "", "Output file (default: stdout)")
-		outputFormat = flag.String("format", "json", "Output format: json, csv")
-		showVersion  = flag.Bool("version", false, "Show version information")
-		daemon       = flag.Bool("daemon", false, "Run as daemon (enables IMAP and HTTP)")
+		configFile    = flag.String("config", "config.yaml", "Config file path")
+		inputFile     = flag.String("input", "", "Input file or directory to parse")
+		outputFile    = flag.String("output", "", "Output file (default: stdout)")
+		aggregateOut  = flag.String("aggregate-out", "", "Output file for aggregate reports (default: -output)")
+		forensicOut   = flag.String("forensic-out", "", "Output file for forensic reports (default: -output)")
+		smtpTLSOut    = flag.String("smtptls-out", "", "Output file for SMTP TLS reports (default: -output)")
+		outputFormat  = flag.String("format", "json", "Output format: json, ndjson, csv, parquet, parsedmarc, summary")
+		ndjson        = flag.Bool("ndjson", false, "Deprecated alias of -format ndjson")
+		flushInterval = flag.Duration("flush-interval", 0, "Buffer JSON output, and -tee-stdout output in daemon mode, and flush at this interval (0 disables buffering)")
+		timezone      = flag.String("timezone", "", "Time zone of report dates in the output, e.g. Europe/Paris (default: UTC)")
+		showVersion   = flag.Bool("version", false, "Show version information")
+		daemon        = flag.Bool("daemon", false, "Run as daemon (enables IMAP and HTTP)")
+		teeStdout     = flag.Bool("tee-stdout", false, "In daemon mode, also write each parsed report to stdout as NDJSON (logs go to stderr)")
 	)
 	flag.Parse()
 
+	// Failures after the output writers and storage are set up exit with
+	// exitCode once the deferred calls have closed them, unlike log.Fatal
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	if *showVersion {
 		fmt.Printf("parsedmarc-go version %s\n", version)
 		return
 	}
 
 	// Initialize configuration
-	var cfg *config.Config
-	var err error
-
-	// Try to load config file, fallback to defaults if not found
-	if *configFile != "" {
-		cfg, err = config.Load(*configFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
-			os.Exit(1)
-		}
-	} else {
-		// Use default configuration
-		cfg = config.LoadDefault()
+	reloadConfig := func() (*config.Config, error) {
+		return loadConfig(*configFile, *teeStdout)
+	}
+	cfg, err := reloadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
 	}
 
 	// Initialize logger
-	log, err := logger.New(cfg.Logging)
+	log, logLevel, err := logger.NewWithLevel(cfg.Logging)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
 		os.Exit(1)
@@ -86,66 +103,191 @@ func main() {
 		if err != nil {
 			log.Fatal("Failed to initialize ClickHouse storage", zap.Error(err))
 		}
+		// Close inserts the rows still buffered with clickhouse.batch_size
+		defer func() {
+			if err := storage.Close(); err != nil {
+				log.Error("Failed to close ClickHouse storage", zap.Error(err))
+			}
+		}()
+	}
+	if cfg.Postgres.Enabled {
+		storage, err = postgres.New(cfg.Postgres, log)
+		if err != nil {
+			log.Fatal("Failed to initialize PostgreSQL storage", zap.Error(err))
+		}
 		defer storage.Close()
 	}
 
 	// Initialize parser
-	p := parser.New(cfg.Parser, storage, log)
+	p := parser.New(cfg.Parser, storage, log, nil)
+	p.SetVersion(version)
+
+	// Initialize ingestion audit log
+	if cfg.Logging.Audit.Enabled {
+		auditLog, err := logger.NewAudit(cfg.Logging.Audit)
+		if err != nil {
+			log.Fatal("Failed to initialize audit logger", zap.Error(err))
+		}
+		defer auditLog.Sync()
+		p.SetAuditLogger(auditLog)
+	}
 
 	// Handle single file processing
 	if *inputFile != "" && !*daemon {
+		if *ndjson {
+			log.Warn("-ndjson is deprecated, use -format ndjson instead")
+			*outputFormat = string(output.FormatNDJSON)
+		}
+
 		// Validate output format
 		format := output.Format(strings.ToLower(*outputFormat))
-		if format != output.FormatJSON && format != output.FormatCSV {
+		switch format {
+		case output.FormatJSON, output.FormatNDJSON, output.FormatCSV, output.FormatParquet, output.FormatParsedmarc, output.FormatSummary:
+		default:
 			log.Fatal("Invalid output format", zap.String("format", *outputFormat))
 		}
 
+		var location *time.Location
+		if *timezone != "" {
+			location, err = time.LoadLocation(*timezone)
+			if err != nil {
+				log.Fatal("Invalid output time zone", zap.String("timezone", *timezone), zap.Error(err))
+			}
+		}
+
 		// Create SMTP client if configured
 		var smtpSender output.SMTPSender
 		if cfg.SMTP.Enabled {
-			smtpSender = smtp.New(&cfg.SMTP, log)
+			smtpSender = smtp.New(&cfg.SMTP, log, nil)
 		}
 
 		// Create Kafka client if configured
 		var kafkaSender output.KafkaSender
 		if cfg.Kafka.Enabled {
-			kafkaSender = kafka.New(&cfg.Kafka, log)
+			kafkaSender = kafka.New(&cfg.Kafka, log, nil)
+		}
+
+		// Create syslog client if configured
+		var syslogSender output.SyslogSender
+		if cfg.Syslog.Enabled {
+			syslogSender = syslog.New(&cfg.Syslog, log)
 		}
 
+		// Reports of a directory are written as one JSON array, except with
+		// the ndjson format
+		stat, err := os.Stat(*inputFile)
+		inputIsDir := err == nil && stat.IsDir()
+
 		// Create output writer
-		outputWriter, err := output.NewWriter(output.Config{
-			Format:      format,
-			File:        *outputFile,
-			SMTPSender:  smtpSender,
-			KafkaSender: kafkaSender,
-			Logger:      log,
-		})
+		outputWriter, err := newOutputWriter(output.Config{
+			Format:        format,
+			File:          *outputFile,
+			FlushInterval: *flushInterval,
+			Array:         inputIsDir,
+			Timezone:      location,
+			SMTPSender:    smtpSender,
+			KafkaSender:   kafkaSender,
+			SyslogSender:  syslogSender,
+			Logger:        log,
+		}, *aggregateOut, *forensicOut, *smtpTLSOut)
 		if err != nil {
 			log.Fatal("Failed to create output writer", zap.Error(err))
 		}
-		defer outputWriter.Close()
+		if cfg.Elasticsearch.Enabled {
+			outputWriter = &multiWriter{writers: []output.Writer{outputWriter, elasticsearch.New(&cfg.Elasticsearch, log)}}
+		}
+		defer func() {
+			if err := outputWriter.Close(); err != nil {
+				log.Error("Failed to close output writer", zap.Error(err))
+			}
+		}()
 
-		err = parseFileWithCustomOutput(*inputFile, p, outputWriter, log)
+		err = parseFileWithCustomOutput(*inputFile, p, outputWriter, cfg.Parser.Concurrency, log)
 		if err != nil {
-			log.Fatal("Failed to parse file",
+			log.Error("Failed to parse file",
 				zap.String("file", *inputFile),
 				zap.Error(err),
 			)
+			exitCode = 1
+			return
 		}
 		log.Info("Processing completed successfully")
 		return
 	}
 
 	// Run in daemon mode
-	if *daemon || cfg.IMAP.Enabled || cfg.HTTP.Enabled {
-		runDaemon(cfg, p, log)
+	if *daemon || cfg.IMAP.Enabled || cfg.HTTP.Enabled || (cfg.Kafka.Enabled && cfg.Kafka.InputTopic != "") {
+		var sinks []output.Writer
+		if cfg.Elasticsearch.Enabled {
+			sinks = append(sinks, elasticsearch.New(&cfg.Elasticsearch, log))
+		}
+		var teeWriter output.Writer
+		if *teeStdout || len(sinks) > 0 {
+			var stdout io.Writer
+			if *teeStdout {
+				stdout = os.Stdout
+			}
+			teeWriter, err = enableTeeOutput(p, stdout, sinks, *flushInterval, log)
+			if err != nil {
+				log.Fatal("Failed to create tee output writer", zap.Error(err))
+			}
+		}
+		if err := runDaemon(cfg, reloadConfig, logLevel, p, teeWriter, log); err != nil {
+			log.Error("Failed to run daemon", zap.Error(err))
+			exitCode = 1
+		}
 	} else {
 		log.Info("No input file specified and daemon mode disabled")
 		log.Info("Use -input flag for single file processing or -daemon flag for continuous processing")
 	}
 }
 
-func runDaemon(cfg *config.Config, p *parser.Parser, log *zap.Logger) {
+// loadConfig loads and validates the config file at path, or the default
+// configuration when path is empty
+func loadConfig(path string, teeStdout bool) (*config.Config, error) {
+	cfg := config.LoadDefault()
+	if path != "" {
+		var err error
+		cfg, err = config.Load(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	// Keep stdout free for the report stream when teeing
+	if teeStdout && (cfg.Logging.OutputPath == "" || cfg.Logging.OutputPath == "stdout") {
+		cfg.Logging.OutputPath = "stderr"
+	}
+
+	return cfg, nil
+}
+
+// runDaemon runs the enabled services until SIGINT or SIGTERM. On SIGHUP the
+// configuration is reloaded with reload and its live settings applied, see
+// reloadableConfig. teeWriter, the copy of parsed reports if not nil, is
+// closed once the services have stopped.
+func runDaemon(cfg *config.Config, reload func() (*config.Config, error), level zap.AtomicLevel, p *parser.Parser, teeWriter output.Writer, log *zap.Logger) error {
+	if teeWriter != nil {
+		defer func() {
+			if err := teeWriter.Close(); err != nil {
+				log.Error("Failed to close tee output", zap.Error(err))
+			}
+		}()
+	}
+
+	if cfg.IMAP.Enabled {
+		if err := imap.ValidateSearchCriteria(cfg.IMAP.SearchCriteria); err != nil {
+			return fmt.Errorf("invalid IMAP configuration: %w", err)
+		}
+		if err := imap.ValidateProcessedFlag(cfg.IMAP.ProcessedFlag); err != nil {
+			return fmt.Errorf("invalid IMAP configuration: %w", err)
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -154,7 +296,11 @@ func runDaemon(cfg *config.Config, p *parser.Parser, log *zap.Logger) {
 	// Start HTTP server if enabled
 	var httpServer *http.Server
 	if cfg.HTTP.Enabled {
-		httpServer = http.New(cfg.HTTP, p, log)
+		httpServer = http.New(cfg.HTTP, p, log, nil)
+		if cfg.HTTP.ReadyCheckKafka && cfg.Kafka.Enabled {
+			kafkaClient := kafka.New(&cfg.Kafka, log, nil)
+			httpServer.AddReadinessCheck("kafka", kafkaClient.TestConnection)
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -168,10 +314,16 @@ func runDaemon(cfg *config.Config, p *parser.Parser, log *zap.Logger) {
 	// Start IMAP client if enabled
 	var imapClient *imap.Client
 	if cfg.IMAP.Enabled {
-		imapClient = imap.New(cfg.IMAP, p, log)
+		imapClient = imap.New(cfg.IMAP, p, log, nil)
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			if cfg.IMAP.Idle {
+				if err := imapClient.WatchIdle(ctx); err != nil {
+					log.Error("IMAP IDLE watcher failed", zap.Error(err))
+				}
+				return
+			}
 			for {
 				select {
 				case <-ctx.Done():
@@ -195,7 +347,7 @@ func runDaemon(cfg *config.Config, p *parser.Parser, log *zap.Logger) {
 					select {
 					case <-ctx.Done():
 						return
-					case <-time.After(time.Duration(cfg.IMAP.CheckInterval) * time.Second):
+					case <-time.After(imapClient.CheckInterval()):
 					}
 				}
 			}
@@ -203,12 +355,39 @@ func runDaemon(cfg *config.Config, p *parser.Parser, log *zap.Logger) {
 		log.Info("IMAP client started")
 	}
 
+	// Start Kafka consumer if an input topic is configured
+	if cfg.Kafka.Enabled && cfg.Kafka.InputTopic != "" {
+		kafkaClient := kafka.New(&cfg.Kafka, log, nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumeKafka(ctx, kafkaClient, p, log)
+		}()
+		log.Info("Kafka consumer started")
+	}
+
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Wait for signal
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Reload the configuration on SIGHUP until asked to stop
+	reloadable := &reloadableConfig{
+		current:    cfg,
+		level:      level,
+		httpServer: httpServer,
+		imapClient: imapClient,
+		log:        log,
+	}
 	sig := <-sigChan
+	for sig == syscall.SIGHUP {
+		log.Info("Received SIGHUP, reloading configuration")
+		if next, err := reload(); err != nil {
+			log.Error("Failed to reload configuration, keeping the current one", zap.Error(err))
+		} else {
+			reloadable.apply(next)
+		}
+		sig = <-sigChan
+	}
 	log.Info("Received signal, shutting down", zap.String("signal", sig.String()))
 
 	// Cancel context to stop goroutines
@@ -226,8 +405,8 @@ func runDaemon(cfg *config.Config, p *parser.Parser, log *zap.Logger) {
 		}
 	}
 
-	// Disconnect IMAP client
-	if imapClient != nil {
+	// Disconnect IMAP client; the IDLE watcher disconnects itself on cancel
+	if imapClient != nil && !cfg.IMAP.Idle {
 		if err := imapClient.Disconnect(); err != nil {
 			log.Error("Failed to disconnect IMAP client", zap.Error(err))
 		} else {
@@ -248,10 +427,273 @@ func runDaemon(cfg *config.Config, p *parser.Parser, log *zap.Logger) {
 	case <-time.After(30 * time.Second):
 		log.Warn("Timeout waiting for services to stop")
 	}
+	return nil
+}
+
+// reloadableConfig is the configuration of a running daemon. Only the log
+// level, the IMAP check interval and the HTTP rate limits can change while it
+// runs; changes to other settings take effect on the next restart.
+type reloadableConfig struct {
+	current    *config.Config
+	level      zap.AtomicLevel
+	httpServer *http.Server // nil when the HTTP server is disabled
+	imapClient *imap.Client // nil when the IMAP client is disabled
+	log        *zap.Logger
+}
+
+// apply applies the live settings of next, a reloaded configuration, and logs
+// what changed. Other changes are logged and ignored.
+func (r *reloadableConfig) apply(next *config.Config) {
+	updated := *r.current
+
+	if next.Logging.Level != r.current.Logging.Level {
+		if level, err := zap.ParseAtomicLevel(next.Logging.Level); err != nil {
+			r.log.Error("Ignoring invalid log level", zap.String("level", next.Logging.Level), zap.Error(err))
+		} else {
+			r.level.SetLevel(level.Level())
+			updated.Logging.Level = next.Logging.Level
+			r.log.Info("Changed log level",
+				zap.String("from", r.current.Logging.Level),
+				zap.String("to", next.Logging.Level),
+			)
+		}
+	}
+
+	if next.IMAP.CheckInterval != r.current.IMAP.CheckInterval {
+		if r.imapClient != nil {
+			r.imapClient.SetCheckInterval(next.IMAP.CheckInterval)
+		}
+		updated.IMAP.CheckInterval = next.IMAP.CheckInterval
+		r.log.Info("Changed IMAP check interval",
+			zap.Int("from", r.current.IMAP.CheckInterval),
+			zap.Int("to", next.IMAP.CheckInterval),
+		)
+	}
+
+	if next.HTTP.RateLimit != r.current.HTTP.RateLimit || next.HTTP.RateBurst != r.current.HTTP.RateBurst ||
+		!reflect.DeepEqual(next.HTTP.ReportRateLimits, r.current.HTTP.ReportRateLimits) {
+		if r.httpServer != nil {
+			r.httpServer.SetRateLimits(next.HTTP.RateLimit, next.HTTP.RateBurst, next.HTTP.ReportRateLimits)
+		}
+		updated.HTTP.RateLimit = next.HTTP.RateLimit
+		updated.HTTP.RateBurst = next.HTTP.RateBurst
+		updated.HTTP.ReportRateLimits = next.HTTP.ReportRateLimits
+		r.log.Info("Changed HTTP rate limits",
+			zap.Int("rate_limit", next.HTTP.RateLimit),
+			zap.Int("rate_burst", next.HTTP.RateBurst),
+			zap.Any("report_rate_limits", next.HTTP.ReportRateLimits),
+		)
+	}
+
+	if sections := restartRequired(&updated, next); len(sections) > 0 {
+		r.log.Warn("Ignoring configuration changes that require a restart", zap.Strings("sections", sections))
+	}
+
+	r.current = &updated
+}
+
+// restartRequired returns the sections whose settings differ between the
+// running configuration cur and next once the live settings are applied
+func restartRequired(cur, next *config.Config) []string {
+	live := *next
+	live.Logging.Level = cur.Logging.Level
+	live.IMAP.CheckInterval = cur.IMAP.CheckInterval
+	live.HTTP.RateLimit = cur.HTTP.RateLimit
+	live.HTTP.RateBurst = cur.HTTP.RateBurst
+	live.HTTP.ReportRateLimits = cur.HTTP.ReportRateLimits
+
+	var sections []string
+	curValue, nextValue := reflect.ValueOf(*cur), reflect.ValueOf(live)
+	for i := 0; i < curValue.NumField(); i++ {
+		if !reflect.DeepEqual(curValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			sections = append(sections, curValue.Type().Field(i).Tag.Get("mapstructure"))
+		}
+	}
+	return sections
+}
+
+// consumeKafka parses the reports of the Kafka input topic until ctx is
+// cancelled, resuming after a delay when consumption stops on an error
+func consumeKafka(ctx context.Context, client *kafka.Client, p *parser.Parser, log *zap.Logger) {
+	for {
+		err := client.Consume(ctx, kafkaReportHandler(p, log))
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error("Kafka consumer stopped", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+// kafkaReportHandler returns the handler parsing each Kafka message as a
+// report. Only storage errors fail the message so that it is consumed again;
+// a message that cannot be parsed would fail every time and is skipped.
+// Rows buffered by the storage are inserted before the message is committed.
+func kafkaReportHandler(p *parser.Parser, log *zap.Logger) func(value []byte) error {
+	return func(value []byte) error {
+		err := p.ParseDataFromSource(value, parser.SourceKafka, "")
+		if err == nil {
+			return p.FlushStorage()
+		}
+		if errors.Is(err, parser.ErrStorage) {
+			return err
+		}
+
+		log.Warn("Skipping Kafka message that could not be parsed", zap.Error(err))
+		return nil
+	}
+}
+
+// enableTeeOutput makes p copy every parsed report to stdout as NDJSON, unless
+// stdout is nil, and to the output sinks such as Elasticsearch. A positive
+// flushInterval buffers stdout, flushed at that interval and on Close.
+func enableTeeOutput(p *parser.Parser, stdout io.Writer, sinks []output.Writer, flushInterval time.Duration, log *zap.Logger) (output.Writer, error) {
+	writers := sinks
+	if stdout != nil {
+		ndjsonWriter, err := output.NewWriter(output.Config{
+			Format:        output.FormatNDJSON,
+			Writer:        stdout,
+			FlushInterval: flushInterval,
+			Logger:        log,
+		})
+		if err != nil {
+			return nil, err
+		}
+		writers = append([]output.Writer{ndjsonWriter}, sinks...)
+	}
+
+	teeWriter := &multiWriter{writers: writers}
+	p.SetTeeWriter(teeWriter)
+	return teeWriter, nil
+}
+
+// newOutputWriter creates the output writer for the CLI. Aggregate, forensic
+// and SMTP TLS reports are written to aggregateOut, forensicOut and smtpTLSOut
+// when given, and to the writer configured by cfg otherwise. Parquet files
+// only hold aggregate records, so forensic and SMTP TLS reports without a
+// file of their own go to the aggregate writer, which passes them on to the
+// senders, instead of a writer replacing the default file.
+func newOutputWriter(cfg output.Config, aggregateOut, forensicOut, smtpTLSOut string) (output.Writer, error) {
+	if aggregateOut == "" && forensicOut == "" && smtpTLSOut == "" {
+		return output.NewWriter(cfg)
+	}
+
+	split := &splitWriter{}
+	var defaultWriter output.Writer
+	writerFor := func(file string) (output.Writer, error) {
+		if file != "" {
+			typeCfg := cfg
+			typeCfg.File = file
+			writer, err := output.NewWriter(typeCfg)
+			if err != nil {
+				return nil, err
+			}
+			split.writers = append(split.writers, writer)
+			return writer, nil
+		}
+
+		if defaultWriter == nil {
+			writer, err := output.NewWriter(cfg)
+			if err != nil {
+				return nil, err
+			}
+			defaultWriter = writer
+			split.writers = append(split.writers, writer)
+		}
+		return defaultWriter, nil
+	}
+
+	routes := []struct {
+		writer *output.Writer
+		file   string
+	}{
+		{&split.aggregate, aggregateOut},
+		{&split.forensic, forensicOut},
+		{&split.smtpTLS, smtpTLSOut},
+	}
+	for _, route := range routes {
+		// The aggregate route comes first
+		if route.file == "" && cfg.Format == output.FormatParquet && split.aggregate != nil {
+			*route.writer = split.aggregate
+			continue
+		}
+
+		writer, err := writerFor(route.file)
+		if err != nil {
+			split.Close()
+			return nil, err
+		}
+		*route.writer = writer
+	}
+
+	return split, nil
+}
+
+// splitWriter writes each report type to its own writer
+type splitWriter struct {
+	aggregate output.Writer
+	forensic  output.Writer
+	smtpTLS   output.Writer
+	writers   []output.Writer // every distinct writer, closed once
+}
+
+func (s *splitWriter) WriteAggregateReport(report *parser.AggregateReport) error {
+	return s.aggregate.WriteAggregateReport(report)
+}
+
+func (s *splitWriter) WriteForensicReport(report *parser.ForensicReport) error {
+	return s.forensic.WriteForensicReport(report)
+}
+
+func (s *splitWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	return s.smtpTLS.WriteSMTPTLSReport(report)
+}
+
+func (s *splitWriter) Close() error {
+	var errs []error
+	for _, writer := range s.writers {
+		errs = append(errs, writer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// multiWriter writes every report to each of its writers
+type multiWriter struct {
+	writers []output.Writer
+}
+
+func (m *multiWriter) WriteAggregateReport(report *parser.AggregateReport) error {
+	return m.each(func(w output.Writer) error { return w.WriteAggregateReport(report) })
+}
+
+func (m *multiWriter) WriteForensicReport(report *parser.ForensicReport) error {
+	return m.each(func(w output.Writer) error { return w.WriteForensicReport(report) })
+}
+
+func (m *multiWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	return m.each(func(w output.Writer) error { return w.WriteSMTPTLSReport(report) })
+}
+
+func (m *multiWriter) Close() error {
+	return m.each(output.Writer.Close)
+}
+
+// each calls fn with every writer, even after one fails
+func (m *multiWriter) each(fn func(w output.Writer) error) error {
+	var errs []error
+	for _, writer := range m.writers {
+		errs = append(errs, fn(writer))
+	}
+	return errors.Join(errs...)
 }
 
 // parseFileWithCustomOutput parses a file and writes output using the specified writer
-func parseFileWithCustomOutput(inputFile string, p *parser.Parser, outputWriter output.Writer, log *zap.Logger) error {
+func parseFileWithCustomOutput(inputFile string, p *parser.Parser, outputWriter output.Writer, concurrency int, log *zap.Logger) error {
 	// Check if input is a directory or file
 	stat, err := os.Stat(inputFile)
 	if err != nil {
@@ -259,36 +701,81 @@ func parseFileWithCustomOutput(inputFile string, p *parser.Parser, outputWriter
 	}
 
 	if stat.IsDir() {
-		return parseDirectoryWithCustomOutput(inputFile, p, outputWriter, log)
+		return parseDirectoryWithCustomOutput(inputFile, p, outputWriter, concurrency, log)
 	} else {
 		return parseSingleFileWithCustomOutput(inputFile, p, outputWriter, log)
 	}
 }
 
-// parseDirectoryWithCustomOutput parses all files in a directory
-func parseDirectoryWithCustomOutput(directory string, p *parser.Parser, outputWriter output.Writer, log *zap.Logger) error {
+// parseDirectoryWithCustomOutput parses all files in a directory, up to
+// concurrency files at a time. Reports are written to outputWriter one at a
+// time, in the order they are parsed.
+func parseDirectoryWithCustomOutput(directory string, p *parser.Parser, outputWriter output.Writer, concurrency int, log *zap.Logger) error {
 	entries, err := os.ReadDir(directory)
 	if err != nil {
 		return fmt.Errorf("failed to read directory: %w", err)
 	}
 
+	outputWriter = &lockedWriter{writer: outputWriter}
+	paths := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range paths {
+				log.Info("Processing file", zap.String("file", filePath))
+
+				if err := parseSingleFileWithCustomOutput(filePath, p, outputWriter, log); err != nil {
+					log.Warn("Failed to process file", zap.String("file", filePath), zap.Error(err))
+				}
+			}
+		}()
+	}
+
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue // Skip subdirectories for now
 		}
-
-		filePath := fmt.Sprintf("%s/%s", directory, entry.Name())
-		log.Info("Processing file", zap.String("file", filePath))
-
-		if err := parseSingleFileWithCustomOutput(filePath, p, outputWriter, log); err != nil {
-			log.Warn("Failed to process file", zap.String("file", filePath), zap.Error(err))
-			continue // Continue with other files
-		}
+		paths <- fmt.Sprintf("%s/%s", directory, entry.Name())
 	}
 
+	close(paths)
+	wg.Wait()
 	return nil
 }
 
+// lockedWriter serializes the writes of concurrent parsers to a writer
+type lockedWriter struct {
+	mu     sync.Mutex
+	writer output.Writer
+}
+
+func (l *lockedWriter) WriteAggregateReport(report *parser.AggregateReport) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writer.WriteAggregateReport(report)
+}
+
+func (l *lockedWriter) WriteForensicReport(report *parser.ForensicReport) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writer.WriteForensicReport(report)
+}
+
+func (l *lockedWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writer.WriteSMTPTLSReport(report)
+}
+
+func (l *lockedWriter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writer.Close()
+}
+
 // parseSingleFileWithCustomOutput parses a single file and writes output
 func parseSingleFileWithCustomOutput(filePath string, p *parser.Parser, outputWriter output.Writer, log *zap.Logger) error {
 	data, err := os.ReadFile(filePath)
@@ -296,8 +783,28 @@ func parseSingleFileWithCustomOutput(filePath string, p *parser.Parser, outputWr
 		return fmt.Errorf("failed to read file: %w", err)
 	}
 
+	// A ZIP archive may bundle several reports
+	reports, err := p.ExtractReportFiles(data)
+	if err != nil {
+		return fmt.Errorf("failed to extract reports: %w", err)
+	}
+
+	if len(reports) > 1 {
+		log.Info("Found several reports in file",
+			zap.String("file", filePath),
+			zap.Int("reports", len(reports)),
+		)
+	}
+
 	// Parse and write to output manually to avoid circular dependency
-	return parseAndWriteOutput(data, p, outputWriter)
+	var errs []error
+	for _, report := range reports {
+		if err := parseAndWriteOutput(report, p, outputWriter); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 // parseAndWriteOutput parses data and writes to output writer
@@ -1,9 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/http"
+	"parsedmarc-go/internal/imap"
+	"parsedmarc-go/internal/output"
+	"parsedmarc-go/internal/parser"
 )
 
 func TestMain(m *testing.M) {
@@ -105,3 +122,410 @@ func TestBuildInfo(t *testing.T) {
 		t.Error("Build time should not be empty")
 	}
 }
+
+func TestEnableTeeOutput(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	log := zaptest.NewLogger(t)
+	p := parser.New(config.ParserConfig{Offline: true}, nil, log, prometheus.NewRegistry())
+
+	var stdout bytes.Buffer
+	teeWriter, err := enableTeeOutput(p, &stdout, nil, 0, log)
+	if err != nil {
+		t.Fatalf("enableTeeOutput() error = %v", err)
+	}
+	defer teeWriter.Close()
+
+	// HTTP and IMAP ingestion in daemon mode go through ParseData
+	if err := p.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 NDJSON line, got %d: %q", len(lines), stdout.String())
+	}
+
+	var report parser.AggregateReport
+	if err := json.Unmarshal([]byte(lines[0]), &report); err != nil {
+		t.Fatalf("Tee output is not valid JSON: %v", err)
+	}
+	if report.ReportMetadata.ReportID != "b043f0e264cf4ea995e93765242f6dfb" {
+		t.Errorf("Expected report ID b043f0e264cf4ea995e93765242f6dfb, got %s", report.ReportMetadata.ReportID)
+	}
+}
+
+func TestEnableTeeOutput_FlushInterval(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	log := zaptest.NewLogger(t)
+	p := parser.New(config.ParserConfig{Offline: true}, nil, log, prometheus.NewRegistry())
+
+	var stdout bytes.Buffer
+	teeWriter, err := enableTeeOutput(p, &stdout, nil, time.Hour, log)
+	if err != nil {
+		t.Fatalf("enableTeeOutput() error = %v", err)
+	}
+
+	if err := p.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("Expected the report to be buffered, got %q", stdout.String())
+	}
+
+	// The daemon closes the tee output on shutdown
+	if err := teeWriter.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !strings.Contains(stdout.String(), "b043f0e264cf4ea995e93765242f6dfb") {
+		t.Errorf("Expected the buffered report to be flushed on close, got %q", stdout.String())
+	}
+}
+
+// mixedReportDir returns a directory with one report of each type
+func mixedReportDir(t *testing.T) string {
+	t.Helper()
+	inputDir := t.TempDir()
+	for name, sample := range map[string]string{
+		"aggregate.xml": "../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml",
+		"forensic.eml":  "../../samples/forensic/dmarc_ruf_report_linkedin.eml",
+		"smtp_tls.json": "../../samples/smtp_tls/rfc8460.json",
+	} {
+		data, err := os.ReadFile(sample)
+		if err != nil {
+			t.Fatalf("Failed to read sample: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(inputDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write input file: %v", err)
+		}
+	}
+	return inputDir
+}
+
+func TestNewOutputWriter_SplitsReportTypes(t *testing.T) {
+	inputDir := mixedReportDir(t)
+
+	// Markers found only in the JSON output of each report type
+	markers := map[string]string{
+		"aggregate": `"policy_published"`,
+		"forensic":  `"feedback_type"`,
+		"smtp_tls":  `"organization_name"`,
+	}
+
+	tests := []struct {
+		name    string
+		typeOut map[string]bool // report types given their own file
+		want    map[string][]string
+	}{
+		{
+			name:    "every type split",
+			typeOut: map[string]bool{"aggregate": true, "forensic": true, "smtp_tls": true},
+			want: map[string][]string{
+				"aggregate": {"aggregate"},
+				"forensic":  {"forensic"},
+				"smtp_tls":  {"smtp_tls"},
+				"default":   nil,
+			},
+		},
+		{
+			name:    "fallback to default output",
+			typeOut: map[string]bool{"aggregate": true},
+			want: map[string][]string{
+				"aggregate": {"aggregate"},
+				"default":   {"forensic", "smtp_tls"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			path := func(name string) string { return filepath.Join(outputDir, name+".json") }
+			typeFile := func(reportType string) string {
+				if tt.typeOut[reportType] {
+					return path(reportType)
+				}
+				return ""
+			}
+
+			log := zaptest.NewLogger(t)
+			p := parser.New(config.ParserConfig{Offline: true}, nil, log, prometheus.NewRegistry())
+			writer, err := newOutputWriter(output.Config{
+				Format: output.FormatJSON,
+				File:   path("default"),
+				Logger: log,
+			}, typeFile("aggregate"), typeFile("forensic"), typeFile("smtp_tls"))
+			if err != nil {
+				t.Fatalf("newOutputWriter() error = %v", err)
+			}
+
+			if err := parseDirectoryWithCustomOutput(inputDir, p, writer, 4, log); err != nil {
+				t.Fatalf("parseDirectoryWithCustomOutput() error = %v", err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			for file, wantTypes := range tt.want {
+				data, err := os.ReadFile(path(file))
+				if err != nil && !os.IsNotExist(err) {
+					t.Fatalf("Failed to read %s output: %v", file, err)
+				}
+				for reportType, marker := range markers {
+					want := slices.Contains(wantTypes, reportType)
+					if got := strings.Contains(string(data), marker); got != want {
+						t.Errorf("%s output contains %s report = %v, want %v", file, reportType, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewOutputWriter_ParquetAggregateOut(t *testing.T) {
+	inputDir := mixedReportDir(t)
+
+	tests := []struct {
+		name   string
+		output string // -output, "" when not given
+	}{
+		{"without output", ""},
+		{"with output", "default.parquet"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			aggregateOut := filepath.Join(outputDir, "aggregate.parquet")
+			cfg := output.Config{Format: output.FormatParquet, Logger: zaptest.NewLogger(t)}
+			var defaultOut string
+			if tt.output != "" {
+				defaultOut = filepath.Join(outputDir, tt.output)
+				if err := os.WriteFile(defaultOut, []byte("previous"), 0644); err != nil {
+					t.Fatalf("Failed to write output file: %v", err)
+				}
+				cfg.File = defaultOut
+			}
+
+			log := zaptest.NewLogger(t)
+			p := parser.New(config.ParserConfig{Offline: true}, nil, log, prometheus.NewRegistry())
+			writer, err := newOutputWriter(cfg, aggregateOut, "", "")
+			if err != nil {
+				t.Fatalf("newOutputWriter() error = %v", err)
+			}
+
+			if err := parseDirectoryWithCustomOutput(inputDir, p, writer, 4, log); err != nil {
+				t.Fatalf("parseDirectoryWithCustomOutput() error = %v", err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			data, err := os.ReadFile(aggregateOut)
+			if err != nil {
+				t.Fatalf("Failed to read aggregate output: %v", err)
+			}
+			if !bytes.HasPrefix(data, []byte("PAR1")) {
+				t.Errorf("Expected a Parquet file, got %q", data[:min(len(data), 8)])
+			}
+			if defaultOut != "" {
+				if data, _ := os.ReadFile(defaultOut); string(data) != "previous" {
+					t.Errorf("Expected -output to be left untouched, got %q", data)
+				}
+			}
+		})
+	}
+}
+
+// failingStorage fails to store every report
+type failingStorage struct{}
+
+func (failingStorage) StoreAggregateReport(*parser.AggregateReport) error {
+	return errors.New("connection refused")
+}
+
+func (failingStorage) StoreForensicReport(*parser.ForensicReport) error {
+	return errors.New("connection refused")
+}
+
+func (failingStorage) StoreSMTPTLSReport(*parser.SMTPTLSReport) error {
+	return errors.New("connection refused")
+}
+
+func (failingStorage) Close() error {
+	return nil
+}
+
+// unflushedStorage buffers every report but fails to insert them
+type unflushedStorage struct{}
+
+func (unflushedStorage) StoreAggregateReport(*parser.AggregateReport) error {
+	return nil
+}
+
+func (unflushedStorage) StoreForensicReport(*parser.ForensicReport) error {
+	return nil
+}
+
+func (unflushedStorage) StoreSMTPTLSReport(*parser.SMTPTLSReport) error {
+	return nil
+}
+
+func (unflushedStorage) Close() error {
+	return nil
+}
+
+func (unflushedStorage) Flush() error {
+	return errors.New("connection refused")
+}
+
+func TestKafkaReportHandler(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	log := zaptest.NewLogger(t)
+
+	// Unparseable messages are skipped so that they are committed
+	p := parser.New(config.ParserConfig{Offline: true}, nil, log, prometheus.NewRegistry())
+	handler := kafkaReportHandler(p, log)
+	if err := handler([]byte("not a report")); err != nil {
+		t.Errorf("Expected unparseable message to be skipped, got %v", err)
+	}
+	if err := handler(data); err != nil {
+		t.Errorf("handler() error = %v", err)
+	}
+
+	// Storage errors fail the message so that it is consumed again
+	p = parser.New(config.ParserConfig{Offline: true}, failingStorage{}, log, prometheus.NewRegistry())
+	handler = kafkaReportHandler(p, log)
+	if err := handler(data); !errors.Is(err, parser.ErrStorage) {
+		t.Errorf("Expected a storage error, got %v", err)
+	}
+
+	// So do buffered reports that fail to be inserted
+	p = parser.New(config.ParserConfig{Offline: true}, unflushedStorage{}, log, prometheus.NewRegistry())
+	handler = kafkaReportHandler(p, log)
+	if err := handler(data); !errors.Is(err, parser.ErrStorage) {
+		t.Errorf("Expected a storage error for a failed flush, got %v", err)
+	}
+}
+
+func TestReloadableConfig_Apply(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := zap.New(core)
+
+	cfg := config.LoadDefault()
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	p := parser.New(config.ParserConfig{Offline: true}, nil, log, prometheus.NewRegistry())
+	imapClient := imap.New(cfg.IMAP, p, log, prometheus.NewRegistry())
+	reloadable := &reloadableConfig{
+		current:    cfg,
+		level:      level,
+		httpServer: http.New(cfg.HTTP, p, log, prometheus.NewRegistry()),
+		imapClient: imapClient,
+		log:        log,
+	}
+
+	// Reloading an unchanged configuration changes nothing
+	reloadable.apply(config.LoadDefault())
+	if logs.Len() != 0 {
+		t.Errorf("Expected no log entries, got %v", logs.TakeAll())
+	}
+
+	next := config.LoadDefault()
+	next.Logging.Level = "debug"
+	next.IMAP.CheckInterval = 60
+	next.HTTP.RateLimit = 10
+	next.HTTP.ReportRateLimits = map[string]config.ReportRateLimit{"forensic": {RateLimit: 2, RateBurst: 1}}
+	next.HTTP.Port = cfg.HTTP.Port + 1
+	next.Parser.Offline = !cfg.Parser.Offline
+	reloadable.apply(next)
+
+	if level.Level() != zapcore.DebugLevel {
+		t.Errorf("Expected debug level, got %s", level.Level())
+	}
+	if imapClient.CheckInterval() != time.Minute {
+		t.Errorf("Expected IMAP check interval of 1m, got %s", imapClient.CheckInterval())
+	}
+
+	current := reloadable.current
+	if current.Logging.Level != "debug" || current.IMAP.CheckInterval != 60 || current.HTTP.RateLimit != 10 {
+		t.Errorf("Expected live settings to be applied, got %+v", current)
+	}
+	if current.HTTP.Port != cfg.HTTP.Port || current.Parser.Offline != cfg.Parser.Offline {
+		t.Error("Expected settings requiring a restart to be kept")
+	}
+
+	for _, message := range []string{"Changed log level", "Changed IMAP check interval", "Changed HTTP rate limits"} {
+		if logs.FilterMessage(message).Len() != 1 {
+			t.Errorf("Expected %q to be logged", message)
+		}
+	}
+	ignored := logs.FilterMessage("Ignoring configuration changes that require a restart").All()
+	if len(ignored) != 1 {
+		t.Fatalf("Expected ignored changes to be logged, got %d entries", len(ignored))
+	}
+	sections, _ := ignored[0].ContextMap()["sections"].([]interface{})
+	if !slices.Equal(sections, []interface{}{"parser", "http"}) {
+		t.Errorf("Expected parser and http to require a restart, got %v", sections)
+	}
+
+	// Ignored changes are reported again on the next reload
+	logs.TakeAll()
+	reloadable.apply(next)
+	if logs.Len() != 1 || logs.FilterMessage("Ignoring configuration changes that require a restart").Len() != 1 {
+		t.Errorf("Expected only the ignored changes to be logged, got %v", logs.All())
+	}
+}
+
+func TestParseDirectory_JSONArray(t *testing.T) {
+	inputDir := mixedReportDir(t)
+
+	for _, compact := range []bool{false, true} {
+		outputFile := filepath.Join(t.TempDir(), "reports.json")
+		// A previous run's output is replaced rather than appended to
+		if err := os.WriteFile(outputFile, []byte("[]\n"), 0644); err != nil {
+			t.Fatalf("Failed to write output file: %v", err)
+		}
+
+		log := zaptest.NewLogger(t)
+		p := parser.New(config.ParserConfig{Offline: true}, nil, log, prometheus.NewRegistry())
+		writer, err := newOutputWriter(output.Config{
+			Format:  output.FormatJSON,
+			File:    outputFile,
+			Compact: compact,
+			Array:   true,
+			Logger:  log,
+		}, "", "", "")
+		if err != nil {
+			t.Fatalf("newOutputWriter() error = %v", err)
+		}
+		if err := parseFileWithCustomOutput(inputDir, p, writer, 2, log); err != nil {
+			t.Fatalf("parseFileWithCustomOutput() error = %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		data, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		var reports []map[string]any
+		if err := json.Unmarshal(data, &reports); err != nil {
+			t.Fatalf("Output (compact %v) is not a JSON array: %v\n%s", compact, err, data)
+		}
+		if len(reports) != 3 {
+			t.Errorf("Expected 3 reports (compact %v), got %d", compact, len(reports))
+		}
+	}
+}
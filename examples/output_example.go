@@ -45,7 +45,7 @@ func main() {
 		Offline: true,
 	}
 
-	p := parser.New(parserConfig, nil, logger)
+	p := parser.New(parserConfig, nil, logger, nil)
 
 	// Create output writer
 	outputFormat := output.Format(format)
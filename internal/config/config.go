@@ -1,94 +1,212 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Logging    LoggingConfig    `mapstructure:"logging"`
-	Parser     ParserConfig     `mapstructure:"parser"`
-	ClickHouse ClickHouseConfig `mapstructure:"clickhouse"`
-	IMAP       IMAPConfig       `mapstructure:"imap"`
-	HTTP       HTTPConfig       `mapstructure:"http"`
-	SMTP       SMTPConfig       `mapstructure:"smtp"`
-	Kafka      KafkaConfig      `mapstructure:"kafka"`
+	Logging       LoggingConfig       `mapstructure:"logging"`
+	Parser        ParserConfig        `mapstructure:"parser"`
+	ClickHouse    ClickHouseConfig    `mapstructure:"clickhouse"`
+	Postgres      PostgresConfig      `mapstructure:"postgres"`
+	IMAP          IMAPConfig          `mapstructure:"imap"`
+	HTTP          HTTPConfig          `mapstructure:"http"`
+	SMTP          SMTPConfig          `mapstructure:"smtp"`
+	Kafka         KafkaConfig         `mapstructure:"kafka"`
+	Syslog        SyslogConfig        `mapstructure:"syslog"`
+	Elasticsearch ElasticsearchConfig `mapstructure:"elasticsearch"`
 }
 
 // LoggingConfig contains logging configuration
 type LoggingConfig struct {
-	Level      string `mapstructure:"level"`
-	Format     string `mapstructure:"format"`
+	Level      string         `mapstructure:"level"`
+	Format     string         `mapstructure:"format"`
+	OutputPath string         `mapstructure:"output_path"`
+	Audit      AuditLogConfig `mapstructure:"audit"`
+}
+
+// AuditLogConfig contains ingestion audit log configuration
+type AuditLogConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
 	OutputPath string `mapstructure:"output_path"`
 }
 
 // ParserConfig contains parser configuration
 type ParserConfig struct {
-	Offline             bool     `mapstructure:"offline"`
-	IPDBPath            string   `mapstructure:"ip_db_path"`
-	ReverseDNSMapPath   string   `mapstructure:"reverse_dns_map_path"`
-	ReverseDNSMapURL    string   `mapstructure:"reverse_dns_map_url"`
-	AlwaysUseLocalFiles bool     `mapstructure:"always_use_local_files"`
-	Nameservers         []string `mapstructure:"nameservers"`
-	DNSTimeout          int      `mapstructure:"dns_timeout"`
+	Offline                  bool          `mapstructure:"offline"`
+	IPDBPath                 string        `mapstructure:"ip_db_path"`
+	ASNDBPath                string        `mapstructure:"asn_db_path"`
+	ReverseDNSMapPath        string        `mapstructure:"reverse_dns_map_path"`
+	ReverseDNSMapURL         string        `mapstructure:"reverse_dns_map_url"`
+	AlwaysUseLocalFiles      bool          `mapstructure:"always_use_local_files"`
+	Nameservers              []string      `mapstructure:"nameservers"`
+	DNSTimeout               int           `mapstructure:"dns_timeout"`
+	DNSCacheSize             int           `mapstructure:"dns_cache_size"`
+	DNSCacheTTL              time.Duration `mapstructure:"dns_cache_ttl"`
+	EnrichmentConcurrency    int           `mapstructure:"enrichment_concurrency"`
+	Concurrency              int           `mapstructure:"concurrency"`
+	SkipPrivateIPs           bool          `mapstructure:"skip_private_ips"`
+	RecordSamplingThreshold  int           `mapstructure:"record_sampling_threshold"`
+	RecordSamplingMinRecords int           `mapstructure:"record_sampling_min_records"`
+	StreamThreshold          int           `mapstructure:"stream_threshold"`
+	MaxIdentifierLength      int           `mapstructure:"max_identifier_length"`
+	MaxRecords               int           `mapstructure:"max_records"`
+	StoreUnparsed            bool          `mapstructure:"store_unparsed"`
+	UnparsedPreviewBytes     int           `mapstructure:"unparsed_preview_bytes"`
+	DefaultMissingCount      bool          `mapstructure:"default_missing_count"`
+	IgnoreInlineXML          bool          `mapstructure:"ignore_inline_xml"`
+	MaxReportAge             time.Duration `mapstructure:"max_report_age"`
+	Fingerprint              bool          `mapstructure:"fingerprint"`
+	TrustedOrgs              []string      `mapstructure:"trusted_orgs"`
+	MonitoredDomains         []string      `mapstructure:"monitored_domains"`
+	PolicyDrift              bool          `mapstructure:"policy_drift"`
+	DomainFailureMetrics     bool          `mapstructure:"domain_failure_metrics"`
+	StoreParseMetadata       bool          `mapstructure:"store_parse_metadata"`
+	StoreSourceTransport     bool          `mapstructure:"store_source_transport"`
 }
 
 // ClickHouseConfig contains ClickHouse configuration
 type ClickHouseConfig struct {
-	Enabled    bool   `mapstructure:"enabled"`
-	Host       string `mapstructure:"host"`
-	Port       int    `mapstructure:"port"`
-	Database   string `mapstructure:"database"`
-	Username   string `mapstructure:"username"`
-	Password   string `mapstructure:"password"`
-	TLS        bool   `mapstructure:"tls"`
-	SkipVerify bool   `mapstructure:"skip_verify"`
+	Enabled           bool   `mapstructure:"enabled"`
+	Host              string `mapstructure:"host"`
+	Port              int    `mapstructure:"port"`
+	Protocol          string `mapstructure:"protocol"`
+	Database          string `mapstructure:"database"`
+	Username          string `mapstructure:"username"`
+	Password          string `mapstructure:"password"`
+	TLS               bool   `mapstructure:"tls"`
+	SkipVerify        bool   `mapstructure:"skip_verify"`
+	DuplicateHandling string `mapstructure:"duplicate_handling"`
+	Deduplicate       bool   `mapstructure:"deduplicate"`
+	ConnectAttempts   int    `mapstructure:"connect_attempts"`
+	ConnectRetryDelay int    `mapstructure:"connect_retry_delay"`
+	MaxAuthResults    int    `mapstructure:"max_auth_results"`
+	BatchSize         int    `mapstructure:"batch_size"`     // forensic and SMTP TLS report rows buffered per insert; 0 inserts them immediately
+	FlushInterval     int    `mapstructure:"flush_interval"` // seconds between inserts of a partial batch; 0 inserts it on close only
+}
+
+// PostgresConfig contains PostgreSQL configuration
+type PostgresConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Database string `mapstructure:"database"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	SSLMode  string `mapstructure:"ssl_mode"`
 }
 
 // IMAPConfig contains IMAP configuration
 type IMAPConfig struct {
-	Enabled         bool   `mapstructure:"enabled"`
-	Host            string `mapstructure:"host"`
-	Port            int    `mapstructure:"port"`
-	Username        string `mapstructure:"username"`
-	Password        string `mapstructure:"password"`
-	TLS             bool   `mapstructure:"tls"`
-	SkipVerify      bool   `mapstructure:"skip_verify"`
-	Mailbox         string `mapstructure:"mailbox"`
-	ArchiveMailbox  string `mapstructure:"archive_mailbox"`
-	DeleteProcessed bool   `mapstructure:"delete_processed"`
-	CheckInterval   int    `mapstructure:"check_interval"`
+	Enabled           bool                `mapstructure:"enabled"`
+	Host              string              `mapstructure:"host"`
+	Port              int                 `mapstructure:"port"`
+	Username          string              `mapstructure:"username"`
+	Password          string              `mapstructure:"password"`
+	AuthMethod        string              `mapstructure:"auth_method"`
+	OAuthToken        string              `mapstructure:"oauth_token"`
+	OAuth2            IMAPOAuth2Config    `mapstructure:"oauth2"`
+	TLS               bool                `mapstructure:"tls"`
+	SkipVerify        bool                `mapstructure:"skip_verify"`
+	Mailbox           string              `mapstructure:"mailbox"`
+	ArchiveMailbox    string              `mapstructure:"archive_mailbox"`
+	DeleteProcessed   bool                `mapstructure:"delete_processed"`
+	CheckInterval     int                 `mapstructure:"check_interval"`
+	Idle              bool                `mapstructure:"idle"`
+	ProcessUnseenOnly bool                `mapstructure:"process_unseen_only"`
+	StateFile         string              `mapstructure:"state_file"`
+	SubjectPatterns   IMAPSubjectPatterns `mapstructure:"subject_patterns"`
+	SearchCriteria    IMAPSearchCriteria  `mapstructure:"search_criteria"`
+	ProcessedFlag     string              `mapstructure:"processed_flag"`
+}
+
+// IMAPSearchCriteria restricts the messages processed to those matching an
+// IMAP SEARCH. Since and Before are YYYY-MM-DD dates or durations before now.
+type IMAPSearchCriteria struct {
+	Since        string            `mapstructure:"since"`
+	Before       string            `mapstructure:"before"`
+	From         string            `mapstructure:"from"`
+	To           string            `mapstructure:"to"`
+	Subject      string            `mapstructure:"subject"`
+	Headers      map[string]string `mapstructure:"headers"`
+	Flags        []string          `mapstructure:"flags"`
+	WithoutFlags []string          `mapstructure:"without_flags"`
+}
+
+// IMAPSubjectPatterns holds regular expressions matched against message
+// subjects to pick the report type parsed first
+type IMAPSubjectPatterns struct {
+	Aggregate []string `mapstructure:"aggregate"`
+	Forensic  []string `mapstructure:"forensic"`
+	SMTPTLS   []string `mapstructure:"smtp_tls"`
+}
+
+// IMAPOAuth2Config contains the OAuth2 client used to refresh the IMAP
+// access token
+type IMAPOAuth2Config struct {
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	RefreshToken string `mapstructure:"refresh_token"`
+	TokenURL     string `mapstructure:"token_url"`
 }
 
 // HTTPConfig contains HTTP server configuration
 type HTTPConfig struct {
-	Enabled       bool   `mapstructure:"enabled"`
-	Host          string `mapstructure:"host"`
-	Port          int    `mapstructure:"port"`
-	TLS           bool   `mapstructure:"tls"`
-	CertFile      string `mapstructure:"cert_file"`
-	KeyFile       string `mapstructure:"key_file"`
-	RateLimit     int    `mapstructure:"rate_limit"`
-	RateBurst     int    `mapstructure:"rate_burst"`
-	MaxUploadSize int64  `mapstructure:"max_upload_size"`
+	Enabled          bool                       `mapstructure:"enabled"`
+	Host             string                     `mapstructure:"host"`
+	Port             int                        `mapstructure:"port"`
+	TLS              bool                       `mapstructure:"tls"`
+	CertFile         string                     `mapstructure:"cert_file"`
+	KeyFile          string                     `mapstructure:"key_file"`
+	RequireTLS       bool                       `mapstructure:"require_tls"`
+	TrustedProxies   []string                   `mapstructure:"trusted_proxies"`
+	APIKeys          []string                   `mapstructure:"api_keys"`
+	RateLimit        int                        `mapstructure:"rate_limit"`
+	RateBurst        int                        `mapstructure:"rate_burst"`
+	ReportRateLimits map[string]ReportRateLimit `mapstructure:"report_rate_limits"`
+	MaxUploadSize    int64                      `mapstructure:"max_upload_size"`
+	MaxBatchReports  int                        `mapstructure:"max_batch_reports"`
+	RESTSemantics    bool                       `mapstructure:"rest_semantics"`
+	StrictValidation bool                       `mapstructure:"strict_validation"`
+	ReadyCheckKafka  bool                       `mapstructure:"ready_check_kafka"`
+	BatchSize        int                        `mapstructure:"batch_size"`
+	BatchInterval    time.Duration              `mapstructure:"batch_interval"`
+	BatchQueueSize   int                        `mapstructure:"batch_queue_size"`
+}
+
+// ReportRateLimit is the per-IP rate limit of one report type, keyed by
+// report type (aggregate, forensic, smtp_tls) in HTTPConfig.ReportRateLimits
+type ReportRateLimit struct {
+	RateLimit int `mapstructure:"rate_limit"`
+	RateBurst int `mapstructure:"rate_burst"`
 }
 
 // SMTPConfig contains SMTP configuration for sending email reports
 type SMTPConfig struct {
-	Enabled    bool     `mapstructure:"enabled"`
-	Host       string   `mapstructure:"host"`
-	Port       int      `mapstructure:"port"`
-	SSL        bool     `mapstructure:"ssl"`
-	Username   string   `mapstructure:"username"`
-	Password   string   `mapstructure:"password"`
-	From       string   `mapstructure:"from"`
-	To         []string `mapstructure:"to"`
-	Subject    string   `mapstructure:"subject"`
-	Attachment string   `mapstructure:"attachment"`
-	Message    string   `mapstructure:"message"`
+	Enabled      bool     `mapstructure:"enabled"`
+	Host         string   `mapstructure:"host"`
+	Port         int      `mapstructure:"port"`
+	SSL          bool     `mapstructure:"ssl"`
+	SkipVerify   bool     `mapstructure:"skip_verify"`
+	Username     string   `mapstructure:"username"`
+	Password     string   `mapstructure:"password"`
+	From         string   `mapstructure:"from"`
+	To           []string `mapstructure:"to"`
+	Subject      string   `mapstructure:"subject"`
+	Attachment   string   `mapstructure:"attachment"`
+	Message      string   `mapstructure:"message"`
+	HTML         bool     `mapstructure:"html"`
+	SendAttempts int      `mapstructure:"send_attempts"`
+	RetryDelay   int      `mapstructure:"retry_delay"`
 }
 
 // KafkaConfig contains Kafka configuration for sending reports
@@ -102,6 +220,34 @@ type KafkaConfig struct {
 	AggregateTopic string   `mapstructure:"aggregate_topic"`
 	ForensicTopic  string   `mapstructure:"forensic_topic"`
 	SMTPTLSTopic   string   `mapstructure:"smtp_tls_topic"`
+	InputTopic     string   `mapstructure:"input_topic"`
+	ConsumerGroup  string   `mapstructure:"consumer_group"`
+}
+
+// SyslogConfig contains syslog configuration for sending reports
+type SyslogConfig struct {
+	Enabled         bool   `mapstructure:"enabled"`
+	Network         string `mapstructure:"network"`
+	Address         string `mapstructure:"address"`
+	Facility        string `mapstructure:"facility"`
+	Severity        string `mapstructure:"severity"`
+	Tag             string `mapstructure:"tag"`
+	MaxDatagramSize int    `mapstructure:"max_datagram_size"` // bytes per message over udp and unixgram
+}
+
+// ElasticsearchConfig contains Elasticsearch/OpenSearch configuration for
+// indexing reports with the bulk API
+type ElasticsearchConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	URL           string `mapstructure:"url"`
+	IndexPrefix   string `mapstructure:"index_prefix"`
+	Username      string `mapstructure:"username"`
+	Password      string `mapstructure:"password"`
+	APIKey        string `mapstructure:"api_key"`
+	SkipVerify    bool   `mapstructure:"skip_verify"`
+	BatchSize     int    `mapstructure:"batch_size"`     // documents per bulk request
+	FlushInterval int    `mapstructure:"flush_interval"` // seconds between flushes of a partial batch; 0 flushes on close only
+	Timeout       int    `mapstructure:"timeout"`        // seconds
 }
 
 // Load loads configuration from file, using defaults if file doesn't exist
@@ -164,6 +310,85 @@ func LoadDefault() *Config {
 	return &cfg
 }
 
+// Validate checks the invariants between settings that Load cannot enforce,
+// such as the certificate required by TLS, and returns an error describing
+// every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.ClickHouse.Enabled && c.Postgres.Enabled {
+		invalid("clickhouse and postgres storage cannot both be enabled")
+	}
+	if c.ClickHouse.Enabled && strings.TrimSpace(c.ClickHouse.Host) == "" {
+		invalid("clickhouse is enabled but clickhouse.host is not set")
+	}
+	if c.Postgres.Enabled && strings.TrimSpace(c.Postgres.Host) == "" {
+		invalid("postgres is enabled but postgres.host is not set")
+	}
+
+	if c.HTTP.Enabled && c.HTTP.TLS {
+		if c.HTTP.CertFile == "" {
+			invalid("http.tls is enabled but http.cert_file is not set")
+		}
+		if c.HTTP.KeyFile == "" {
+			invalid("http.tls is enabled but http.key_file is not set")
+		}
+	}
+	for _, reportType := range slices.Sorted(maps.Keys(c.HTTP.ReportRateLimits)) {
+		switch reportType {
+		case "aggregate", "forensic", "smtp_tls":
+		default:
+			invalid("http.report_rate_limits has unknown report type %q, expected aggregate, forensic or smtp_tls", reportType)
+		}
+	}
+
+	if c.IMAP.Enabled {
+		if strings.TrimSpace(c.IMAP.Host) == "" {
+			invalid("imap is enabled but imap.host is not set")
+		}
+		if strings.TrimSpace(c.IMAP.Username) == "" {
+			invalid("imap is enabled but imap.username is not set")
+		}
+	}
+
+	if c.Kafka.Enabled && !hasNonBlank(c.Kafka.Hosts) {
+		invalid("kafka is enabled but kafka.hosts is empty")
+	}
+
+	if c.Elasticsearch.Enabled {
+		if strings.TrimSpace(c.Elasticsearch.URL) == "" {
+			invalid("elasticsearch is enabled but elasticsearch.url is not set")
+		}
+		if c.Elasticsearch.APIKey != "" && c.Elasticsearch.Username != "" {
+			invalid("elasticsearch.api_key and elasticsearch.username cannot both be set")
+		}
+	}
+
+	if c.SMTP.Enabled {
+		if strings.TrimSpace(c.SMTP.From) == "" {
+			invalid("smtp is enabled but smtp.from is not set")
+		}
+		if !hasNonBlank(c.SMTP.To) {
+			invalid("smtp is enabled but smtp.to is empty")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// hasNonBlank reports whether values holds a value other than whitespace
+func hasNonBlank(values []string) bool {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return true
+		}
+	}
+	return false
+}
+
 // isFileNotFoundError checks if the error is a file not found error
 func isFileNotFoundError(err error) bool {
 	errMsg := err.Error()
@@ -177,22 +402,63 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.format", "json")
 	v.SetDefault("logging.output_path", "stdout")
+	v.SetDefault("logging.audit.enabled", false)
+	v.SetDefault("logging.audit.output_path", "audit.log")
 
 	// Parser defaults
 	v.SetDefault("parser.offline", false)
 	v.SetDefault("parser.always_use_local_files", false)
 	v.SetDefault("parser.nameservers", []string{"1.1.1.1", "1.0.0.1"})
 	v.SetDefault("parser.dns_timeout", 2)
+	v.SetDefault("parser.dns_cache_size", 10000) // 0 disables the reverse DNS cache
+	v.SetDefault("parser.dns_cache_ttl", time.Hour)
+	v.SetDefault("parser.enrichment_concurrency", 4)
+	v.SetDefault("parser.concurrency", runtime.NumCPU())
+	v.SetDefault("parser.skip_private_ips", true)
+	v.SetDefault("parser.record_sampling_threshold", 0) // 0 disables sampling
+	v.SetDefault("parser.record_sampling_min_records", 10000)
+	v.SetDefault("parser.stream_threshold", 10*1024*1024) // bytes; 0 disables streaming
+	v.SetDefault("parser.max_identifier_length", 255)     // report_id, org_name and domain
+	v.SetDefault("parser.max_records", 1000000)           // per aggregate report; 0 disables the limit
+	v.SetDefault("parser.store_unparsed", false)
+	v.SetDefault("parser.unparsed_preview_bytes", 512)
+	v.SetDefault("parser.default_missing_count", true)
+	v.SetDefault("parser.ignore_inline_xml", false)
+	v.SetDefault("parser.max_report_age", 0) // 0 accepts reports of any age
+	v.SetDefault("parser.fingerprint", false)
+	v.SetDefault("parser.store_parse_metadata", false)
+	v.SetDefault("parser.store_source_transport", false)
+	v.SetDefault("parser.trusted_orgs", []string{}) // empty accepts reports from any org
+	v.SetDefault("parser.monitored_domains", []string{})
+	v.SetDefault("parser.domain_failure_metrics", false)
+	v.SetDefault("parser.policy_drift", false)
 
 	// ClickHouse defaults
 	v.SetDefault("clickhouse.enabled", false)
 	v.SetDefault("clickhouse.host", "localhost")
-	v.SetDefault("clickhouse.port", 9000)
+	v.SetDefault("clickhouse.port", 0) // 0 selects the protocol's default port
+	v.SetDefault("clickhouse.protocol", "native")
 	v.SetDefault("clickhouse.database", "dmarc")
 	v.SetDefault("clickhouse.username", "default")
 	v.SetDefault("clickhouse.password", "")
 	v.SetDefault("clickhouse.tls", false)
 	v.SetDefault("clickhouse.skip_verify", false)
+	v.SetDefault("clickhouse.duplicate_handling", "keep")
+	v.SetDefault("clickhouse.deduplicate", false)
+	v.SetDefault("clickhouse.connect_attempts", 5)
+	v.SetDefault("clickhouse.connect_retry_delay", 2) // seconds, doubled after each failed attempt
+	v.SetDefault("clickhouse.max_auth_results", 100)  // DKIM and SPF results stored per record, 0 for no limit
+	v.SetDefault("clickhouse.batch_size", 0)
+	v.SetDefault("clickhouse.flush_interval", 5)
+
+	// PostgreSQL defaults
+	v.SetDefault("postgres.enabled", false)
+	v.SetDefault("postgres.host", "localhost")
+	v.SetDefault("postgres.port", 5432)
+	v.SetDefault("postgres.database", "dmarc")
+	v.SetDefault("postgres.username", "postgres")
+	v.SetDefault("postgres.password", "")
+	v.SetDefault("postgres.ssl_mode", "prefer")
 
 	// IMAP defaults
 	v.SetDefault("imap.enabled", false)
@@ -200,12 +466,32 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("imap.port", 993)
 	v.SetDefault("imap.username", "")
 	v.SetDefault("imap.password", "")
+	v.SetDefault("imap.auth_method", "password")
+	v.SetDefault("imap.oauth_token", "")
+	v.SetDefault("imap.oauth2.client_id", "")
+	v.SetDefault("imap.oauth2.client_secret", "")
+	v.SetDefault("imap.oauth2.refresh_token", "")
+	v.SetDefault("imap.oauth2.token_url", "")
 	v.SetDefault("imap.tls", true)
 	v.SetDefault("imap.skip_verify", false)
 	v.SetDefault("imap.mailbox", "INBOX")
 	v.SetDefault("imap.archive_mailbox", "DMARC-Archive")
 	v.SetDefault("imap.delete_processed", false)
 	v.SetDefault("imap.check_interval", 300) // 5 minutes
+	v.SetDefault("imap.idle", false)
+	v.SetDefault("imap.process_unseen_only", false)
+	v.SetDefault("imap.state_file", "")
+	v.SetDefault("imap.subject_patterns.aggregate", []string{})
+	v.SetDefault("imap.subject_patterns.forensic", []string{})
+	v.SetDefault("imap.subject_patterns.smtp_tls", []string{})
+	v.SetDefault("imap.search_criteria.since", "")
+	v.SetDefault("imap.search_criteria.before", "")
+	v.SetDefault("imap.search_criteria.from", "")
+	v.SetDefault("imap.search_criteria.to", "")
+	v.SetDefault("imap.search_criteria.subject", "")
+	v.SetDefault("imap.search_criteria.flags", []string{})
+	v.SetDefault("imap.search_criteria.without_flags", []string{})
+	v.SetDefault("imap.processed_flag", "")
 
 	// HTTP defaults
 	v.SetDefault("http.enabled", false)
@@ -214,15 +500,26 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("http.tls", false)
 	v.SetDefault("http.cert_file", "")
 	v.SetDefault("http.key_file", "")
+	v.SetDefault("http.require_tls", false)
+	v.SetDefault("http.trusted_proxies", []string{})
+	v.SetDefault("http.api_keys", []string{})
 	v.SetDefault("http.rate_limit", 60)                // requests per minute
 	v.SetDefault("http.rate_burst", 10)                // burst capacity
 	v.SetDefault("http.max_upload_size", 50*1024*1024) // 50MB
+	v.SetDefault("http.max_batch_reports", 100)        // per POST /dmarc/reports; 0 = unlimited
+	v.SetDefault("http.rest_semantics", false)
+	v.SetDefault("http.strict_validation", false)
+	v.SetDefault("http.ready_check_kafka", false)
+	v.SetDefault("http.batch_size", 0) // 0 stores reports synchronously
+	v.SetDefault("http.batch_interval", 5*time.Second)
+	v.SetDefault("http.batch_queue_size", 1000)
 
 	// SMTP defaults
 	v.SetDefault("smtp.enabled", false)
 	v.SetDefault("smtp.host", "")
 	v.SetDefault("smtp.port", 25)
 	v.SetDefault("smtp.ssl", false)
+	v.SetDefault("smtp.skip_verify", false)
 	v.SetDefault("smtp.username", "")
 	v.SetDefault("smtp.password", "")
 	v.SetDefault("smtp.from", "")
@@ -230,6 +527,9 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("smtp.subject", "parsedmarc report")
 	v.SetDefault("smtp.attachment", "")
 	v.SetDefault("smtp.message", "")
+	v.SetDefault("smtp.html", false)
+	v.SetDefault("smtp.send_attempts", 3)
+	v.SetDefault("smtp.retry_delay", 5) // seconds, doubled after each transient failure
 
 	// Kafka defaults
 	v.SetDefault("kafka.enabled", false)
@@ -241,4 +541,27 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("kafka.aggregate_topic", "")
 	v.SetDefault("kafka.forensic_topic", "")
 	v.SetDefault("kafka.smtp_tls_topic", "")
+	v.SetDefault("kafka.input_topic", "")
+	v.SetDefault("kafka.consumer_group", "parsedmarc-go")
+
+	// Syslog defaults
+	v.SetDefault("syslog.enabled", false)
+	v.SetDefault("syslog.network", "udp")
+	v.SetDefault("syslog.address", "localhost:514")
+	v.SetDefault("syslog.facility", "local0")
+	v.SetDefault("syslog.severity", "info")
+	v.SetDefault("syslog.tag", "parsedmarc")
+	v.SetDefault("syslog.max_datagram_size", 65507)
+
+	// Elasticsearch defaults
+	v.SetDefault("elasticsearch.enabled", false)
+	v.SetDefault("elasticsearch.url", "http://localhost:9200")
+	v.SetDefault("elasticsearch.index_prefix", "dmarc")
+	v.SetDefault("elasticsearch.username", "")
+	v.SetDefault("elasticsearch.password", "")
+	v.SetDefault("elasticsearch.api_key", "")
+	v.SetDefault("elasticsearch.skip_verify", false)
+	v.SetDefault("elasticsearch.batch_size", 500)
+	v.SetDefault("elasticsearch.flush_interval", 10)
+	v.SetDefault("elasticsearch.timeout", 30)
 }
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name: "everything enabled and set",
+			modify: func(cfg *Config) {
+				cfg.ClickHouse.Enabled = true
+				cfg.HTTP = HTTPConfig{Enabled: true, TLS: true, CertFile: "cert.pem", KeyFile: "key.pem"}
+				cfg.IMAP.Enabled, cfg.IMAP.Host, cfg.IMAP.Username = true, "imap.example.com", "dmarc"
+				cfg.Kafka.Enabled, cfg.Kafka.Hosts = true, []string{"kafka:9092"}
+				cfg.SMTP.Enabled, cfg.SMTP.From, cfg.SMTP.To = true, "dmarc@example.com", []string{"admin@example.com"}
+			},
+		},
+		{
+			name: "HTTP TLS without HTTP enabled",
+			modify: func(cfg *Config) {
+				cfg.HTTP = HTTPConfig{Enabled: false, TLS: true}
+			},
+		},
+		{
+			name: "HTTP TLS without cert file",
+			modify: func(cfg *Config) {
+				cfg.HTTP = HTTPConfig{Enabled: true, TLS: true, KeyFile: "key.pem"}
+			},
+			wantErr: []string{"http.cert_file"},
+		},
+		{
+			name: "HTTP TLS without key file",
+			modify: func(cfg *Config) {
+				cfg.HTTP = HTTPConfig{Enabled: true, TLS: true, CertFile: "cert.pem"}
+			},
+			wantErr: []string{"http.key_file"},
+		},
+		{
+			name: "report rate limits of known report types",
+			modify: func(cfg *Config) {
+				cfg.HTTP.ReportRateLimits = map[string]ReportRateLimit{
+					"aggregate": {RateLimit: 60},
+					"forensic":  {RateLimit: 30},
+					"smtp_tls":  {RateLimit: 10},
+				}
+			},
+		},
+		{
+			name: "report rate limits of unknown report types",
+			modify: func(cfg *Config) {
+				cfg.HTTP.ReportRateLimits = map[string]ReportRateLimit{
+					"aggregate": {RateLimit: 60},
+					"smtp-tls":  {RateLimit: 10},
+					"tls":       {RateLimit: 10},
+				}
+			},
+			wantErr: []string{`unknown report type "smtp-tls"`, `unknown report type "tls"`},
+		},
+		{
+			name: "IMAP without host",
+			modify: func(cfg *Config) {
+				cfg.IMAP.Enabled, cfg.IMAP.Host, cfg.IMAP.Username = true, "", "dmarc"
+			},
+			wantErr: []string{"imap.host"},
+		},
+		{
+			name: "IMAP without username",
+			modify: func(cfg *Config) {
+				cfg.IMAP.Enabled, cfg.IMAP.Host, cfg.IMAP.Username = true, "imap.example.com", " "
+			},
+			wantErr: []string{"imap.username"},
+		},
+		{
+			name: "Kafka without hosts",
+			modify: func(cfg *Config) {
+				cfg.Kafka.Enabled, cfg.Kafka.Hosts = true, []string{""}
+			},
+			wantErr: []string{"kafka.hosts"},
+		},
+		{
+			name: "SMTP without from",
+			modify: func(cfg *Config) {
+				cfg.SMTP.Enabled, cfg.SMTP.From, cfg.SMTP.To = true, "", []string{"admin@example.com"}
+			},
+			wantErr: []string{"smtp.from"},
+		},
+		{
+			name: "SMTP without to",
+			modify: func(cfg *Config) {
+				cfg.SMTP.Enabled, cfg.SMTP.From, cfg.SMTP.To = true, "dmarc@example.com", nil
+			},
+			wantErr: []string{"smtp.to"},
+		},
+		{
+			name: "Elasticsearch without URL",
+			modify: func(cfg *Config) {
+				cfg.Elasticsearch.Enabled, cfg.Elasticsearch.URL = true, ""
+			},
+			wantErr: []string{"elasticsearch.url"},
+		},
+		{
+			name: "Elasticsearch with API key and username",
+			modify: func(cfg *Config) {
+				cfg.Elasticsearch.Enabled, cfg.Elasticsearch.APIKey, cfg.Elasticsearch.Username = true, "key", "elastic"
+			},
+			wantErr: []string{"elasticsearch.api_key"},
+		},
+		{
+			name: "ClickHouse without host",
+			modify: func(cfg *Config) {
+				cfg.ClickHouse.Enabled, cfg.ClickHouse.Host = true, ""
+			},
+			wantErr: []string{"clickhouse.host"},
+		},
+		{
+			name: "PostgreSQL without host",
+			modify: func(cfg *Config) {
+				cfg.Postgres.Enabled, cfg.Postgres.Host = true, ""
+			},
+			wantErr: []string{"postgres.host"},
+		},
+		{
+			name: "ClickHouse and PostgreSQL",
+			modify: func(cfg *Config) {
+				cfg.ClickHouse.Enabled, cfg.Postgres.Enabled = true, true
+			},
+			wantErr: []string{"cannot both be enabled"},
+		},
+		{
+			name: "every problem is reported",
+			modify: func(cfg *Config) {
+				cfg.IMAP.Enabled, cfg.IMAP.Host, cfg.IMAP.Username = true, "", ""
+				cfg.SMTP.Enabled, cfg.SMTP.From, cfg.SMTP.To = true, "", nil
+			},
+			wantErr: []string{"imap.host", "imap.username", "smtp.from", "smtp.to"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := LoadDefault()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() expected an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
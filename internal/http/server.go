@@ -1,10 +1,19 @@
 package http
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,7 +25,11 @@ import (
 
 	"go.uber.org/zap"
 	"parsedmarc-go/internal/config"
+	appmetrics "parsedmarc-go/internal/metrics"
+	"parsedmarc-go/internal/output"
 	"parsedmarc-go/internal/parser"
+	"parsedmarc-go/internal/utils"
+	"parsedmarc-go/internal/validation"
 )
 
 // Server represents the HTTP server for receiving DMARC reports
@@ -26,14 +39,93 @@ type Server struct {
 	logger *zap.Logger
 	server *http.Server
 
+	// Proxies whose X-Forwarded-Proto header is trusted
+	trustedProxies []*net.IPNet
+
 	// Rate limiting
 	limiters map[string]*rate.Limiter
 	mu       sync.RWMutex
 
 	// Metrics
-	metrics *Metrics
+	metrics  *Metrics
+	registry *prometheus.Registry
+
+	// batcher queues parsed reports for storage when batch_size is set
+	batcher *parser.BatchStorage
+
+	// inFlight tracks the reports being parsed and stored, see Stop
+	inFlight inFlightReports
+
+	validator *validation.Validator
+
+	// Dependencies checked by /ready, by name
+	readinessChecks map[string]ReadinessCheck
+}
+
+// errServerStopping is returned for reports received once Stop has started
+// waiting for the reports in flight
+var errServerStopping = errors.New("server is shutting down")
+
+// inFlightReports counts the reports being parsed and stored. Once drain is
+// called no report is admitted any more, so that the count can only go down.
+type inFlightReports struct {
+	mu      sync.Mutex
+	count   int
+	closed  bool
+	drained chan struct{} // closed once closed and count drops to zero
+}
+
+// begin admits a report, unless drain was called
+func (r *inFlightReports) begin() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return false
+	}
+	r.count++
+	return true
+}
+
+// end releases a report admitted by begin
+func (r *inFlightReports) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.count--
+	if r.closed && r.count == 0 {
+		close(r.drained)
+	}
+}
+
+// drain stops admitting reports and returns a channel closed once the
+// reports admitted so far are done
+func (r *inFlightReports) drain() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.closed {
+		r.closed = true
+		r.drained = make(chan struct{})
+		if r.count == 0 {
+			close(r.drained)
+		}
+	}
+	return r.drained
+}
+
+// ReadinessCheck reports an error when a dependency of the server, such as
+// the storage, is unreachable
+type ReadinessCheck func(ctx context.Context) error
+
+// pinger is implemented by storages that can check their connection
+type pinger interface {
+	Ping(ctx context.Context) error
 }
 
+// readinessTimeout bounds the time /ready spends checking dependencies
+const readinessTimeout = 5 * time.Second
+
 // Metrics holds Prometheus metrics
 type Metrics struct {
 	RequestsTotal         *prometheus.CounterVec
@@ -41,11 +133,13 @@ type Metrics struct {
 	ReportsProcessedTotal *prometheus.CounterVec
 	ReportsFailedTotal    *prometheus.CounterVec
 	ActiveConnections     prometheus.Gauge
+	ReportsInFlight       prometheus.Gauge
 	ReportSizeBytes       prometheus.Histogram
 }
 
-// New creates a new HTTP server instance
-func New(cfg config.HTTPConfig, p *parser.Parser, logger *zap.Logger) *Server {
+// New creates a new HTTP server instance. Metrics are registered with and
+// served from registry, or the global default registry when nil.
+func New(cfg config.HTTPConfig, p *parser.Parser, logger *zap.Logger, registry *prometheus.Registry) *Server {
 	metrics := &Metrics{
 		RequestsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -82,6 +176,12 @@ func New(cfg config.HTTPConfig, p *parser.Parser, logger *zap.Logger) *Server {
 				Help: "Number of active HTTP connections",
 			},
 		),
+		ReportsInFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "parsedmarc_http_reports_in_flight",
+				Help: "Number of reports received over HTTP being parsed and stored",
+			},
+		),
 		ReportSizeBytes: prometheus.NewHistogram(
 			prometheus.HistogramOpts{
 				Name:    "parsedmarc_report_size_bytes",
@@ -91,32 +191,86 @@ func New(cfg config.HTTPConfig, p *parser.Parser, logger *zap.Logger) *Server {
 		),
 	}
 
-	// Register metrics with error handling
-	registry := prometheus.DefaultRegisterer
-	metricsToRegister := []prometheus.Collector{
-		metrics.RequestsTotal,
-		metrics.RequestDuration,
-		metrics.ReportsProcessedTotal,
-		metrics.ReportsFailedTotal,
-		metrics.ActiveConnections,
-		metrics.ReportSizeBytes,
+	metrics.RequestsTotal = appmetrics.Register(registry, metrics.RequestsTotal)
+	metrics.RequestDuration = appmetrics.Register(registry, metrics.RequestDuration)
+	metrics.ReportsProcessedTotal = appmetrics.Register(registry, metrics.ReportsProcessedTotal)
+	metrics.ReportsFailedTotal = appmetrics.Register(registry, metrics.ReportsFailedTotal)
+	metrics.ActiveConnections = appmetrics.Register(registry, metrics.ActiveConnections)
+	metrics.ReportsInFlight = appmetrics.Register(registry, metrics.ReportsInFlight)
+	metrics.ReportSizeBytes = appmetrics.Register(registry, metrics.ReportSizeBytes)
+
+	s := &Server{
+		config:          cfg,
+		parser:          p,
+		logger:          logger,
+		trustedProxies:  parseTrustedProxies(cfg.TrustedProxies, logger),
+		limiters:        make(map[string]*rate.Limiter),
+		metrics:         metrics,
+		registry:        registry,
+		validator:       validation.New(logger),
+		readinessChecks: make(map[string]ReadinessCheck),
+	}
+
+	if p != nil {
+		if storage, ok := p.Storage().(pinger); ok {
+			s.AddReadinessCheck("storage", storage.Ping)
+		}
+	}
+
+	// Reports received over HTTP are stored in batches by a background
+	// flusher rather than by the request handler
+	if cfg.BatchSize > 0 && p.Storage() != nil {
+		s.batcher = parser.NewBatchStorage(p.Storage(), cfg.BatchSize, cfg.BatchInterval, cfg.BatchQueueSize, logger)
+		s.parser = p.WithStorage(s.batcher)
 	}
 
-	for _, metric := range metricsToRegister {
-		if err := registry.Register(metric); err != nil {
-			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-				panic(err)
+	return s
+}
+
+// AddReadinessCheck adds a dependency checked by /ready under name,
+// replacing the check already added under that name. It must be called
+// before Start.
+func (s *Server) AddReadinessCheck(name string, check ReadinessCheck) {
+	s.readinessChecks[name] = check
+}
+
+// parseTrustedProxies parses trusted proxy IP addresses and CIDR networks.
+// Invalid entries are logged and ignored.
+func parseTrustedProxies(proxies []string, logger *zap.Logger) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				logger.Warn("Ignoring invalid trusted proxy", zap.String("proxy", proxy))
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
 			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
 		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			logger.Warn("Ignoring invalid trusted proxy", zap.String("proxy", proxy), zap.Error(err))
+			continue
+		}
+		networks = append(networks, network)
 	}
+	return networks
+}
 
-	return &Server{
-		config:   cfg,
-		parser:   p,
-		logger:   logger,
-		limiters: make(map[string]*rate.Limiter),
-		metrics:  metrics,
+// metricsHandler serves the server's registry, or the global default
+// gatherer when no registry was injected
+func (s *Server) metricsHandler() http.Handler {
+	if s.registry == nil {
+		return promhttp.Handler()
 	}
+	return promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{})
 }
 
 // Start starts the HTTP server
@@ -134,22 +288,40 @@ func (s *Server) Start() error {
 	router.Use(s.recoveryMiddleware())
 	router.Use(s.rateLimitMiddleware())
 	router.Use(s.maxSizeMiddleware())
+	router.Use(s.decompressMiddleware())
+	router.Use(gzipResponseMiddleware())
 	router.Use(s.metricsMiddleware())
 
+	// Report endpoints, which may be restricted to HTTPS and API key holders
+	api := router.Group("/", s.requireTLSMiddleware(), s.apiKeyMiddleware())
+
 	// Simple DMARC endpoint (RFC 7489 compliant)
-	router.POST("/dmarc/report", s.handleDMARCReport)
-	router.PUT("/dmarc/report", s.handleDMARCReport)
-	router.GET("/dmarc/report", s.handleMethodNotAllowed)
-	router.DELETE("/dmarc/report", s.handleMethodNotAllowed)
-	router.PATCH("/dmarc/report", s.handleMethodNotAllowed)
-	router.HEAD("/dmarc/report", s.handleMethodNotAllowed)
-	router.OPTIONS("/dmarc/report", s.handleMethodNotAllowed)
-
-	// Health check
+	api.POST("/dmarc/report", s.handleDMARCReport)
+	api.PUT("/dmarc/report", s.handleDMARCReport)
+	api.GET("/dmarc/report", s.handleMethodNotAllowed)
+	api.DELETE("/dmarc/report", s.handleMethodNotAllowed)
+	api.PATCH("/dmarc/report", s.handleMethodNotAllowed)
+	api.HEAD("/dmarc/report", s.handleMethodNotAllowed)
+	api.OPTIONS("/dmarc/report", s.handleMethodNotAllowed)
+
+	// Base64-encoded reports wrapped in JSON
+	api.POST("/dmarc/report/base64", s.handleBase64Report)
+
+	// Several reports in one request
+	api.POST("/dmarc/reports", s.handleBatchReports)
+
+	// Synchronous parse endpoint (returns the report, does not store it)
+	api.POST("/parse", s.handleParse)
+
+	// Stored report queries
+	api.GET("/reports/aggregate", s.handleQueryAggregateReports)
+
+	// Health check, and readiness of the dependencies
 	router.GET("/health", s.handleHealth)
+	router.GET("/ready", s.handleReady)
 
 	// Metrics endpoint
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/metrics", gin.WrapH(s.metricsHandler()))
 
 	// Root endpoint
 	router.GET("/", s.handleRoot)
@@ -169,6 +341,10 @@ func (s *Server) Start() error {
 		zap.Bool("tls", s.config.TLS),
 	)
 
+	if s.config.RequireTLS && !s.config.TLS && len(s.trustedProxies) == 0 {
+		s.logger.Warn("require_tls is set without TLS or trusted proxies: all report endpoint requests will be rejected")
+	}
+
 	if s.config.TLS {
 		if s.config.CertFile == "" || s.config.KeyFile == "" {
 			return fmt.Errorf("TLS enabled but cert_file or key_file not specified")
@@ -179,14 +355,30 @@ func (s *Server) Start() error {
 	return s.server.ListenAndServe()
 }
 
-// Stop stops the HTTP server gracefully
+// Stop stops the HTTP server gracefully, waits until ctx is done for the
+// reports still being parsed and stored, then stores the reports still
+// queued for batched storage, again until ctx is done
 func (s *Server) Stop(ctx context.Context) error {
-	if s.server == nil {
-		return nil
+	var err error
+	if s.server != nil {
+		s.logger.Info("Stopping HTTP server...")
+		err = s.server.Shutdown(ctx)
 	}
 
-	s.logger.Info("Stopping HTTP server...")
-	return s.server.Shutdown(ctx)
+	// Shutdown gives up on the requests still running when ctx is done;
+	// reports they send from now on are refused
+	select {
+	case <-s.inFlight.drain():
+	case <-ctx.Done():
+		err = errors.Join(err, fmt.Errorf("reports still in flight: %w", ctx.Err()))
+	}
+
+	if s.batcher != nil {
+		s.logger.Info("Storing queued reports...")
+		err = errors.Join(err, s.batcher.Shutdown(ctx))
+	}
+
+	return err
 }
 
 // Middleware functions
@@ -236,28 +428,232 @@ func (s *Server) recoveryMiddleware() gin.HandlerFunc {
 	}
 }
 
+// SetRateLimits replaces the global and per report type rate limits of the
+// running server. Clients start over with a full burst under the new limits.
+func (s *Server) SetRateLimits(rateLimit, rateBurst int, reportRateLimits map[string]config.ReportRateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config.RateLimit = rateLimit
+	s.config.RateBurst = rateBurst
+	s.config.ReportRateLimits = reportRateLimits
+	s.limiters = make(map[string]*rate.Limiter)
+}
+
+// rateLimits returns the current global rate limit and burst and the per
+// report type rate limits
+func (s *Server) rateLimits() (int, int, map[string]config.ReportRateLimit) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.config.RateLimit, s.config.RateBurst, s.config.ReportRateLimits
+}
+
+// rateLimitMiddleware applies the global per-IP rate limit. The per report
+// type limits of report_rate_limits are applied by the handlers once the
+// report is read, see allowReportType.
 func (s *Server) rateLimitMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if s.config.RateLimit <= 0 {
+		clientIP := c.ClientIP()
+		rateLimit, rateBurst, _ := s.rateLimits()
+
+		if rateLimit > 0 && !s.getLimiter(clientIP, rateLimit, rateBurst).Allow() {
+			s.logger.Warn("Rate limit exceeded", zap.String("client_ip", clientIP))
+			rejectRateLimited(c)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// allowReportType applies the per-IP rate limit of reportType when it is
+// listed in report_rate_limits, and rejects the request once it is exceeded
+func (s *Server) allowReportType(c *gin.Context, reportType string) bool {
+	if s.reportTypeAllowed(c.ClientIP(), reportType) {
+		return true
+	}
+	rejectRateLimited(c)
+	return false
+}
+
+// reportTypeAllowed reports whether clientIP is still within the rate limit
+// of reportType listed in report_rate_limits, if any
+func (s *Server) reportTypeAllowed(clientIP, reportType string) bool {
+	_, _, reportRateLimits := s.rateLimits()
+	limit, ok := reportRateLimits[reportType]
+	if !ok || limit.RateLimit <= 0 {
+		return true
+	}
+
+	if s.getLimiter(reportType+"/"+clientIP, limit.RateLimit, limit.RateBurst).Allow() {
+		return true
+	}
+
+	s.logger.Warn("Report type rate limit exceeded",
+		zap.String("client_ip", clientIP),
+		zap.String("report_type", reportType),
+	)
+	return false
+}
+
+func rejectRateLimited(c *gin.Context) {
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Rate limit exceeded",
+		"retry_after": "60s",
+	})
+	c.Abort()
+}
+
+// rateLimitedReportType returns the report type of body, sent with
+// contentType, that report_rate_limits applies to: the one implied by
+// contentType, or else the one detected from body
+func (s *Server) rateLimitedReportType(body []byte, contentType string) string {
+	if reportType := reportTypeFromContentType(contentType); reportType != "" {
+		return reportType
+	}
+	return s.detectReportType(reportPrefix(body), contentType)
+}
+
+// reportPrefix returns the start of body, decompressed when body is gzipped,
+// for report type detection
+func reportPrefix(body []byte) []byte {
+	if len(body) < 2 || body[0] != 0x1f || body[1] != 0x8b {
+		return body
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return body
+	}
+	defer gzipReader.Close()
+
+	prefix, _ := io.ReadAll(io.LimitReader(gzipReader, 1024))
+	return prefix
+}
+
+// reportTypeFromContentType returns the report type implied by the media
+// type of a request Content-Type, or "" when it does not identify one.
+// application/gzip may hold an aggregate or an SMTP TLS report, so it does
+// not identify one.
+func reportTypeFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "application/tlsrpt+json", "application/tlsrpt+gzip":
+		return parser.ReportTypeSMTPTLS
+	case "message/rfc822", "message/feedback-report", "multipart/report":
+		return parser.ReportTypeForensic
+	case "application/xml", "text/xml", "application/zip":
+		return parser.ReportTypeAggregate
+	}
+	return ""
+}
+
+// requireTLSMiddleware rejects requests that did not arrive over HTTPS when
+// require_tls is set
+func (s *Server) requireTLSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.config.RequireTLS || s.isHTTPS(c.Request) {
 			c.Next()
 			return
 		}
 
-		clientIP := c.ClientIP()
-		limiter := s.getLimiter(clientIP)
+		s.logger.Warn("Rejected plaintext request",
+			zap.String("client_ip", c.ClientIP()),
+			zap.String("path", c.Request.URL.Path),
+		)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "HTTPS required",
+		})
+		c.Abort()
+	}
+}
 
-		if !limiter.Allow() {
-			s.logger.Warn("Rate limit exceeded", zap.String("client_ip", clientIP))
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":       "Rate limit exceeded",
-				"retry_after": "60s",
-			})
-			c.Abort()
+// isHTTPS reports whether r arrived over native TLS, or was forwarded as
+// HTTPS by a trusted proxy
+func (s *Server) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" || !s.isTrustedProxy(r.RemoteAddr) {
+		return false
+	}
+
+	// The first entry is the protocol of the client-facing proxy
+	proto, _, _ = strings.Cut(proto, ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// isTrustedProxy reports whether remoteAddr belongs to a trusted proxy
+func (s *Server) isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyMiddleware requires one of the configured API keys, sent as
+// "Authorization: Bearer <key>" or in the X-API-Key header. It does nothing
+// when no keys are configured.
+func (s *Server) apiKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(s.config.APIKeys) == 0 || s.validAPIKey(c.Request) {
+			c.Next()
 			return
 		}
 
-		c.Next()
+		s.logger.Warn("Rejected unauthorized request",
+			zap.String("client_ip", c.ClientIP()),
+			zap.String("path", c.Request.URL.Path),
+		)
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "unauthorized").Inc()
+		c.Header("WWW-Authenticate", `Bearer realm="parsedmarc-go"`)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or missing API key",
+		})
+		c.Abort()
+	}
+}
+
+// validAPIKey reports whether r carries one of the configured API keys
+func (s *Server) validAPIKey(r *http.Request) bool {
+	if s.matchesAPIKey(r.Header.Get("X-API-Key")) {
+		return true
+	}
+
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	return found && strings.EqualFold(scheme, "Bearer") && s.matchesAPIKey(strings.TrimSpace(token))
+}
+
+// matchesAPIKey reports whether key is one of the configured API keys
+func (s *Server) matchesAPIKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	valid := false
+	for _, apiKey := range s.config.APIKeys {
+		// Compare every key in constant time to avoid leaking which one matched
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			valid = true
+		}
 	}
+	return valid
 }
 
 func (s *Server) maxSizeMiddleware() gin.HandlerFunc {
@@ -269,6 +665,125 @@ func (s *Server) maxSizeMiddleware() gin.HandlerFunc {
 	}
 }
 
+// decompressMiddleware transparently decodes request bodies sent with
+// "Content-Encoding: gzip". This is the HTTP transfer encoding only: a
+// gzipped report sent this way reaches the parser still gzipped. The
+// decompressed body is limited to max_upload_size as well.
+func (s *Server) decompressMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+		if encoding != "gzip" && encoding != "x-gzip" {
+			c.Next()
+			return
+		}
+
+		gz, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_encoding").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid gzip request body",
+				"details": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+		defer gz.Close()
+
+		var body io.ReadCloser = gz
+		if s.config.MaxUploadSize > 0 {
+			body = http.MaxBytesReader(c.Writer, gz, s.config.MaxUploadSize)
+		}
+		c.Request.Body = body
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
+		c.Request.ContentLength = -1
+
+		c.Next()
+	}
+}
+
+// gzipResponseMiddleware compresses responses for clients that accept gzip.
+// Responses that already carry a Content-Encoding, such as compressed
+// Prometheus metrics, are passed through unchanged.
+func gzipResponseMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer writer.close()
+
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, coding := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+
+		// "gzip;q=0" explicitly refuses gzip
+		key, value, found := strings.Cut(strings.TrimSpace(params), "=")
+		if found && strings.EqualFold(strings.TrimSpace(key), "q") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response body, deciding on the first
+// write whether the response is already encoded
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if w.gz == nil && !w.passthrough {
+		header := w.Header()
+		if header.Get("Content-Encoding") != "" {
+			w.passthrough = true
+		} else {
+			header.Set("Content-Encoding", "gzip")
+			header.Add("Vary", "Accept-Encoding")
+			header.Del("Content-Length")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gz.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close writes the gzip trailer, if anything was compressed
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
 func (s *Server) metricsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -290,19 +805,20 @@ func (s *Server) metricsMiddleware() gin.HandlerFunc {
 	}
 }
 
-// Rate limiter helper
-func (s *Server) getLimiter(ip string) *rate.Limiter {
+// getLimiter returns the rate limiter stored under key, a client IP optionally
+// prefixed with a report type, creating it with perMinute and burst
+func (s *Server) getLimiter(key string, perMinute, burst int) *rate.Limiter {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	limiter, exists := s.limiters[ip]
+	limiter, exists := s.limiters[key]
 	if !exists {
 		// Create new limiter: rate per minute with burst capacity
 		limiter = rate.NewLimiter(
-			rate.Limit(float64(s.config.RateLimit)/60.0), // per second
-			s.config.RateBurst,
+			rate.Limit(float64(perMinute)/60.0), // per second
+			burst,
 		)
-		s.limiters[ip] = limiter
+		s.limiters[key] = limiter
 	}
 
 	return limiter
@@ -310,10 +826,18 @@ func (s *Server) getLimiter(ip string) *rate.Limiter {
 
 func (s *Server) getEndpointLabel(path string) string {
 	switch {
+	case strings.HasPrefix(path, "/dmarc/report/base64"):
+		return "dmarc_report_base64"
+	case strings.HasPrefix(path, "/dmarc/reports"):
+		return "dmarc_reports"
 	case strings.HasPrefix(path, "/dmarc/report"):
 		return "dmarc_report"
+	case strings.HasPrefix(path, "/parse"):
+		return "parse"
 	case strings.HasPrefix(path, "/health"):
 		return "health"
+	case strings.HasPrefix(path, "/ready"):
+		return "ready"
 	case strings.HasPrefix(path, "/metrics"):
 		return "metrics"
 	case path == "/":
@@ -330,9 +854,14 @@ func (s *Server) handleRoot(c *gin.Context) {
 		"service": "parsedmarc-go",
 		"version": "1.0.0",
 		"endpoints": map[string]string{
-			"health":       "/health",
-			"dmarc_report": "/dmarc/report",
-			"metrics":      "/metrics",
+			"health":              "/health",
+			"ready":               "/ready",
+			"dmarc_report":        "/dmarc/report",
+			"dmarc_report_base64": "/dmarc/report/base64",
+			"dmarc_reports":       "/dmarc/reports",
+			"parse":               "/parse",
+			"reports":             "/reports/aggregate",
+			"metrics":             "/metrics",
 		},
 	})
 }
@@ -350,25 +879,47 @@ func (s *Server) handleHealth(c *gin.Context) {
 	})
 }
 
+// handleReady runs the readiness checks and answers 503 Service Unavailable
+// when any of them fails, with the result of each check
+func (s *Server) handleReady(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	ready := true
+	checks := make(map[string]string, len(s.readinessChecks))
+	for name, check := range s.readinessChecks {
+		if err := check(ctx); err != nil {
+			s.logger.Warn("Readiness check failed", zap.String("check", name), zap.Error(err))
+			checks[name] = err.Error()
+			ready = false
+			continue
+		}
+		checks[name] = "ok"
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"status":    status,
+		"checks":    checks,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
 func (s *Server) handleDMARCReport(c *gin.Context) {
 	// Simple endpoint for DMARC reports (RFC 7489 compliant)
 	contentType := c.GetHeader("Content-Type")
 
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == "multipart/form-data" {
+		s.handleMultipartReport(c)
+		return
+	}
+
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		s.logger.Error("Failed to read request body", zap.Error(err))
-		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "read_body_failed").Inc()
-
-		// Check if error is due to request body being too large
-		if strings.Contains(err.Error(), "request body too large") || strings.Contains(err.Error(), "http: request body too large") {
-			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
-				"error": "Request entity too large",
-			})
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Failed to read request body",
-			})
-		}
+		s.rejectUnreadableBody(c, err)
 		return
 	}
 
@@ -380,43 +931,872 @@ func (s *Server) handleDMARCReport(c *gin.Context) {
 		return
 	}
 
-	// Record report size
-	s.metrics.ReportSizeBytes.Observe(float64(len(body)))
+	s.processReport(c, body, contentType)
+}
 
-	// Validate content type
-	if !s.isValidDMARCContentType(contentType) {
-		s.logger.Warn("Invalid content type", zap.String("content_type", contentType))
-		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_content_type").Inc()
+// base64ReportRequest is the body of POST /dmarc/report/base64
+type base64ReportRequest struct {
+	Content     string `json:"content"`
+	ContentType string `json:"content_type"`
+}
+
+// handleBase64Report accepts a report as a base64 string in a JSON body, for
+// clients that can only send JSON. The decoded report is handled like the
+// body of POST /dmarc/report with the given content type, detected from the
+// report itself when omitted.
+func (s *Server) handleBase64Report(c *gin.Context) {
+	var request base64ReportRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.rejectUnreadableBody(c, err)
+			return
+		}
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_json").Inc()
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid content type. Expected XML, JSON, or multipart/form-data",
+			"error":   "Invalid JSON body",
+			"details": err.Error(),
 		})
 		return
 	}
 
-	// Parse the report
-	reportType := s.detectReportType(body, contentType)
-	if err := s.parser.ParseData(body); err != nil {
-		s.logger.Error("Failed to parse DMARC report", zap.Error(err))
-		s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "parse_failed").Inc()
+	if request.Content == "" {
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "empty_body").Inc()
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Failed to parse DMARC report",
+			"error": "Missing content",
+		})
+		return
+	}
+
+	if result := s.validator.ValidateBase64Content(request.Content); !result.Valid {
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_base64").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Invalid base64 content",
+			"errors": result.Errors,
+		})
+		return
+	}
+
+	body, err := utils.DecodeBase64(request.Content)
+	if err != nil {
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_base64").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid base64 content",
 			"details": err.Error(),
 		})
 		return
 	}
 
-	s.metrics.ReportsProcessedTotal.WithLabelValues(reportType).Inc()
+	contentType := request.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
 
-	s.logger.Info("Successfully processed DMARC report",
-		zap.String("client_ip", c.ClientIP()),
-		zap.String("content_type", contentType),
-		zap.String("report_type", reportType),
-		zap.Int("size", len(body)),
-	)
+	s.processReport(c, body, contentType)
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "DMARC report processed successfully",
-	})
+// processReport validates, parses and stores a report body received with
+// contentType, and writes the response
+func (s *Server) processReport(c *gin.Context, body []byte, contentType string) {
+	if !s.allowReportType(c, s.rateLimitedReportType(body, contentType)) {
+		return
+	}
+
+	// Record report size
+	s.metrics.ReportSizeBytes.Observe(float64(len(body)))
+
+	// Validate content type
+	if !s.isValidDMARCContentType(contentType) {
+		s.logger.Warn("Invalid content type", zap.String("content_type", contentType))
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_content_type").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid content type. Expected XML, JSON, or multipart/form-data",
+		})
+		return
+	}
+
+	reportType := s.detectReportType(body, contentType)
+
+	// Validate the report before parsing it
+	validationResult := s.validateReport(body, reportType)
+	if s.config.StrictValidation && validationResult != nil && !validationResult.Valid {
+		s.logger.Warn("Rejected invalid DMARC report",
+			zap.String("client_ip", c.ClientIP()),
+			zap.String("report_type", reportType),
+			zap.Strings("errors", validationResult.Errors),
+		)
+		s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "validation_failed").Inc()
+		s.parser.AuditRejection(parser.SourceHTTP, reportType, len(body), validationError(validationResult))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":    "DMARC report failed validation",
+			"errors":   validationResult.Errors,
+			"warnings": validationResult.Warnings,
+		})
+		return
+	}
+
+	// Parse the report
+	result, err := s.parseReport(body, s.writeMode(c.Request.Method))
+	if err != nil {
+		if errors.Is(err, parser.ErrDuplicateReport) {
+			s.logger.Warn("Rejected duplicate DMARC report", zap.Error(err))
+			s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "duplicate").Inc()
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "Report already exists",
+				"details": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, parser.ErrUntrustedOrg) {
+			s.logger.Warn("Rejected DMARC report from untrusted organization",
+				zap.String("client_ip", c.ClientIP()),
+				zap.Error(err),
+			)
+			s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "untrusted_org").Inc()
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "Report is not from a trusted organization",
+				"details": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, errServerStopping) {
+			s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "shutting_down").Inc()
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Server is shutting down",
+			})
+			return
+		}
+
+		s.logger.Error("Failed to parse DMARC report", zap.Error(err))
+		s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "parse_failed").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to parse DMARC report",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	s.metrics.ReportsProcessedTotal.WithLabelValues(reportType).Inc()
+
+	s.logger.Info("Successfully processed DMARC report",
+		zap.String("client_ip", c.ClientIP()),
+		zap.String("content_type", contentType),
+		zap.String("report_type", reportType),
+		zap.Int("size", len(body)),
+	)
+
+	response := reportResponse(result)
+	if validationResult != nil && len(validationResult.Warnings) > 0 {
+		response["warnings"] = validationResult.Warnings
+	}
+	c.JSON(s.successStatus(), response)
+}
+
+// validateReport checks a report body with the validator of its type. It
+// returns nil for bodies the validators do not cover: forensic reports, and
+// compressed or MIME-wrapped aggregate and SMTP TLS reports.
+func (s *Server) validateReport(body []byte, reportType string) *validation.ValidationResult {
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case reportType == "aggregate" && bytes.HasPrefix(trimmed, []byte("<")):
+		return s.validator.ValidateXMLReport(body)
+	case reportType == "smtp_tls" && bytes.HasPrefix(trimmed, []byte("{")):
+		return s.validator.ValidateSMTPTLSReport(body)
+	}
+	return nil
+}
+
+// validationError returns the error of a report rejected by strict
+// validation with result
+func validationError(result *validation.ValidationResult) error {
+	return fmt.Errorf("report failed validation: %s", strings.Join(result.Errors, "; "))
+}
+
+// successStatus is the status of a request whose reports were parsed: 202
+// Accepted when they are only queued for batched storage
+func (s *Server) successStatus() int {
+	if s.batcher != nil {
+		return http.StatusAccepted
+	}
+	return http.StatusOK
+}
+
+// reportResponse builds the success response of handleDMARCReport. The
+// fields of a single report are returned at the top level; an archive
+// holding several reports lists them under "reports".
+func reportResponse(result *parser.ParseResult) gin.H {
+	response := gin.H{
+		"message": "DMARC report processed successfully",
+	}
+
+	if len(result.Reports) != 1 {
+		response["reports"] = result.Reports
+		return response
+	}
+
+	report := result.Reports[0]
+	response["report_type"] = report.ReportType
+	response["org_name"] = report.OrgName
+	response["report_id"] = report.ReportID
+	if report.ReportType == parser.ReportTypeAggregate {
+		response["record_count"] = report.RecordCount
+	}
+	if report.Skipped {
+		response["skipped"] = true
+	}
+	return response
+}
+
+// Outcomes of the files of a multipart upload
+const (
+	fileStatusProcessed = "processed"
+	fileStatusDuplicate = "duplicate"
+	fileStatusFailed    = "failed"
+)
+
+// uploadedFileResult is the outcome of one file of a multipart upload
+type uploadedFileResult struct {
+	Field    string                `json:"field,omitempty"`
+	Filename string                `json:"filename,omitempty"`
+	Status   string                `json:"status"`
+	Error    string                `json:"error,omitempty"`
+	Warnings []string              `json:"warnings,omitempty"`
+	Reports  []parser.ReportResult `json:"reports,omitempty"`
+}
+
+// handleMultipartReport parses every file field of a multipart/form-data
+// upload as a separate report and reports the outcome of each file. Other
+// form fields are ignored.
+func (s *Server) handleMultipartReport(c *gin.Context) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_multipart").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid multipart body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	var results []uploadedFileResult
+	processed, duplicates := 0, 0
+	for {
+		file, err := nextUploadedFile(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.rejectUnreadableBody(c, err)
+			return
+		}
+
+		result := uploadedFileResult{Field: file.field, Filename: file.filename}
+		s.processUploadedReport(c, &result, file.data, file.contentType)
+
+		switch result.Status {
+		case fileStatusProcessed:
+			processed++
+		case fileStatusDuplicate:
+			duplicates++
+		}
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "empty_body").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No files in multipart upload",
+		})
+		return
+	}
+
+	s.logger.Info("Processed multipart DMARC report upload",
+		zap.String("client_ip", c.ClientIP()),
+		zap.Int("files", len(results)),
+		zap.Int("processed", processed),
+	)
+
+	switch {
+	case processed > 0:
+		c.JSON(s.successStatus(), gin.H{
+			"message":   fmt.Sprintf("Processed %d of %d uploaded reports", processed, len(results)),
+			"processed": processed,
+			"failed":    len(results) - processed,
+			"files":     results,
+		})
+	case duplicates == len(results):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Reports already exist",
+			"files": results,
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to parse uploaded reports",
+			"files": results,
+		})
+	}
+}
+
+// nextUploadedFile reads the next file field of a multipart/form-data body,
+// skipping other form fields. It returns io.EOF after the last file.
+func nextUploadedFile(reader *multipart.Reader) (batchItem, error) {
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return batchItem{}, err
+		}
+
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		data, err := io.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return batchItem{}, err
+		}
+		return batchItem{
+			field:       part.FormName(),
+			filename:    part.FileName(),
+			contentType: part.Header.Get("Content-Type"),
+			data:        data,
+		}, nil
+	}
+}
+
+// processUploadedReport parses one report of a multipart or batch upload and
+// records the outcome in result. Reports over their report_rate_limits limit
+// fail.
+func (s *Server) processUploadedReport(c *gin.Context, result *uploadedFileResult, data []byte, contentType string) {
+	if len(data) == 0 {
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "empty_body").Inc()
+		result.Status = fileStatusFailed
+		result.Error = "empty file"
+		return
+	}
+
+	if !s.reportTypeAllowed(c.ClientIP(), s.rateLimitedReportType(data, contentType)) {
+		result.Status = fileStatusFailed
+		result.Error = "Rate limit exceeded"
+		return
+	}
+
+	s.metrics.ReportSizeBytes.Observe(float64(len(data)))
+
+	reportType := s.detectReportType(data, contentType)
+
+	validationResult := s.validateReport(data, reportType)
+	if validationResult != nil {
+		result.Warnings = validationResult.Warnings
+		if s.config.StrictValidation && !validationResult.Valid {
+			s.logger.Warn("Rejected invalid uploaded DMARC report",
+				zap.String("filename", result.Filename),
+				zap.Strings("errors", validationResult.Errors),
+			)
+			s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "validation_failed").Inc()
+			err := validationError(validationResult)
+			s.parser.AuditRejection(parser.SourceHTTP, reportType, len(data), err)
+			result.Status = fileStatusFailed
+			result.Error = err.Error()
+			return
+		}
+	}
+
+	parsed, err := s.parseReport(data, s.writeMode(c.Request.Method))
+	if err != nil {
+		result.Error = err.Error()
+		if errors.Is(err, parser.ErrDuplicateReport) {
+			s.logger.Warn("Rejected duplicate DMARC report",
+				zap.String("filename", result.Filename),
+				zap.Error(err),
+			)
+			s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "duplicate").Inc()
+			result.Status = fileStatusDuplicate
+			return
+		}
+		if errors.Is(err, parser.ErrUntrustedOrg) {
+			s.logger.Warn("Rejected uploaded DMARC report from untrusted organization",
+				zap.String("filename", result.Filename),
+				zap.Error(err),
+			)
+			s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "untrusted_org").Inc()
+			result.Status = fileStatusFailed
+			return
+		}
+		if errors.Is(err, errServerStopping) {
+			s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "shutting_down").Inc()
+			result.Status = fileStatusFailed
+			return
+		}
+
+		s.logger.Error("Failed to parse uploaded DMARC report",
+			zap.String("filename", result.Filename),
+			zap.Error(err),
+		)
+		s.metrics.ReportsFailedTotal.WithLabelValues(reportType, "parse_failed").Inc()
+		result.Status = fileStatusFailed
+		return
+	}
+
+	s.metrics.ReportsProcessedTotal.WithLabelValues(reportType).Inc()
+	result.Status = fileStatusProcessed
+	result.Reports = parsed.Reports
+}
+
+// batchItem is one report of a batch upload
+type batchItem struct {
+	field       string
+	filename    string
+	contentType string
+	data        []byte
+	decodeErr   string // why a base64 report could not be decoded
+}
+
+// batchItemResult is the outcome of one report of a batch upload, at index
+// in the batch
+type batchItemResult struct {
+	Index   int  `json:"index"`
+	Success bool `json:"success"`
+	uploadedFileResult
+}
+
+// handleBatchReports parses several reports sent in one request, either as
+// the files of a multipart/form-data body or as a JSON array of the objects
+// accepted by POST /dmarc/report/base64, and reports the outcome of each.
+// The whole body is limited to max_upload_size, and the number of reports to
+// max_batch_reports.
+func (s *Server) handleBatchReports(c *gin.Context) {
+	var items []batchItem
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		items, err = readMultipartBatch(c.Request)
+	case "application/json":
+		items, err = s.readJSONBatch(c.Request.Body)
+	default:
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_content_type").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid content type. Expected multipart/form-data or a JSON array",
+		})
+		return
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.rejectUnreadableBody(c, err)
+			return
+		}
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_batch").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid batch body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if result := s.validator.ValidateBatchSize(len(items), s.config.MaxBatchReports); !result.Valid {
+		s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_batch").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Invalid batch",
+			"errors": result.Errors,
+		})
+		return
+	}
+
+	results := make([]batchItemResult, len(items))
+	processed, duplicates := 0, 0
+	for i, item := range items {
+		result := batchItemResult{
+			Index:              i,
+			uploadedFileResult: uploadedFileResult{Field: item.field, Filename: item.filename},
+		}
+		if item.decodeErr != "" {
+			s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_base64").Inc()
+			result.Status = fileStatusFailed
+			result.Error = item.decodeErr
+		} else {
+			s.processUploadedReport(c, &result.uploadedFileResult, item.data, item.contentType)
+		}
+
+		switch result.Status {
+		case fileStatusProcessed:
+			processed++
+		case fileStatusDuplicate:
+			duplicates++
+		}
+		result.Success = result.Status == fileStatusProcessed
+		results[i] = result
+	}
+
+	s.logger.Info("Processed DMARC report batch",
+		zap.String("client_ip", c.ClientIP()),
+		zap.Int("reports", len(results)),
+		zap.Int("processed", processed),
+	)
+
+	switch {
+	case processed > 0:
+		c.JSON(s.successStatus(), gin.H{
+			"message":   fmt.Sprintf("Processed %d of %d reports", processed, len(results)),
+			"processed": processed,
+			"failed":    len(results) - processed,
+			"results":   results,
+		})
+	case duplicates == len(results):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Reports already exist",
+			"results": results,
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to parse reports",
+			"results": results,
+		})
+	}
+}
+
+// readMultipartBatch reads the file fields of a multipart/form-data batch.
+// Other form fields are ignored.
+func readMultipartBatch(r *http.Request) ([]batchItem, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []batchItem
+	for {
+		item, err := nextUploadedFile(reader)
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// readJSONBatch reads a JSON array of base64 reports. Reports that are not
+// valid base64 are kept with the reason, to be reported as failed.
+func (s *Server) readJSONBatch(body io.Reader) ([]batchItem, error) {
+	var requests []base64ReportRequest
+	if err := json.NewDecoder(body).Decode(&requests); err != nil {
+		return nil, err
+	}
+
+	items := make([]batchItem, len(requests))
+	for i, request := range requests {
+		items[i].contentType = request.ContentType
+		if result := s.validator.ValidateBase64Content(request.Content); !result.Valid {
+			items[i].decodeErr = strings.Join(result.Errors, "; ")
+			continue
+		}
+
+		data, err := utils.DecodeBase64(request.Content)
+		if err != nil {
+			items[i].decodeErr = err.Error()
+			continue
+		}
+		items[i].data = data
+	}
+	return items, nil
+}
+
+// rejectUnreadableBody answers a request whose body could not be read
+func (s *Server) rejectUnreadableBody(c *gin.Context, err error) {
+	s.logger.Error("Failed to read request body", zap.Error(err))
+	s.metrics.ReportsFailedTotal.WithLabelValues("unknown", "read_body_failed").Inc()
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": "Request entity too large",
+		})
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": "Failed to read request body",
+	})
+}
+
+// parseReport parses and stores a received report, tracked as in flight so
+// that Stop waits for it
+func (s *Server) parseReport(data []byte, mode parser.WriteMode) (*parser.ParseResult, error) {
+	if !s.inFlight.begin() {
+		return nil, errServerStopping
+	}
+	s.metrics.ReportsInFlight.Inc()
+	defer func() {
+		s.metrics.ReportsInFlight.Dec()
+		s.inFlight.end()
+	}()
+
+	return s.parser.ParseDataResult(data, mode)
+}
+
+// handleParse parses a report and returns it in the format negotiated from
+// the Accept header (JSON by default, or CSV) without storing it
+func (s *Server) handleParse(c *gin.Context) {
+	format, contentType, ok := negotiateOutputFormat(c.GetHeader("Accept"))
+	if !ok {
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"error": "Not acceptable. Supported types: application/json, text/csv",
+		})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		s.logger.Error("Failed to read request body", zap.Error(err))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "Request entity too large",
+			})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Failed to read request body",
+			})
+		}
+		return
+	}
+
+	if len(body) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Empty request body",
+		})
+		return
+	}
+
+	if !s.allowReportType(c, s.rateLimitedReportType(body, c.GetHeader("Content-Type"))) {
+		return
+	}
+
+	var buf bytes.Buffer
+	writer, err := output.NewWriter(output.Config{
+		Format: format,
+		Writer: &buf,
+		Logger: s.logger,
+	})
+	if err != nil {
+		s.logger.Error("Failed to create output writer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Internal server error",
+		})
+		return
+	}
+
+	if err := writeParsedReport(s.parser, body, writer); err != nil {
+		writer.Close()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to parse DMARC report",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		s.logger.Error("Failed to render parsed report", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Internal server error",
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// Page sizes of GET /reports/aggregate
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// handleQueryAggregateReports returns stored aggregate reports filtered by
+// domain and begin date range, one page at a time
+func (s *Server) handleQueryAggregateReports(c *gin.Context) {
+	filter, err := parseAggregateReportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	reports, err := s.parser.QueryAggregateReports(filter)
+	if err != nil {
+		if errors.Is(err, parser.ErrQueryNotSupported) {
+			c.JSON(http.StatusNotImplemented, gin.H{
+				"error": "The configured storage does not support querying reports",
+			})
+			return
+		}
+
+		s.logger.Error("Failed to query aggregate reports", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to query aggregate reports",
+		})
+		return
+	}
+
+	if reports == nil {
+		reports = []*parser.AggregateReport{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+		"count":   len(reports),
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+// parseAggregateReportFilter reads the domain, from, to, limit and offset
+// query parameters. Dates are RFC 3339 timestamps or YYYY-MM-DD (UTC).
+func parseAggregateReportFilter(c *gin.Context) (parser.AggregateReportFilter, error) {
+	filter := parser.AggregateReportFilter{
+		Domain: utils.NormalizeDomain(c.Query("domain")),
+		Limit:  defaultQueryLimit,
+	}
+
+	var err error
+	if filter.From, err = parseQueryDate(c.Query("from")); err != nil {
+		return filter, fmt.Errorf("from: %w", err)
+	}
+	if filter.To, err = parseQueryDate(c.Query("to")); err != nil {
+		return filter, fmt.Errorf("to: %w", err)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("from must be before to")
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxQueryLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxQueryLimit)
+		}
+		filter.Limit = limit
+	}
+	if value := c.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative integer")
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
+
+// parseQueryDate parses an RFC 3339 timestamp or a YYYY-MM-DD date. An empty
+// value yields the zero time.
+func parseQueryDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+// writeParsedReport parses data as an aggregate, forensic or SMTP TLS report
+// and writes the first that succeeds to w
+func writeParsedReport(p *parser.Parser, data []byte, w output.Writer) error {
+	var parseErrors []string
+
+	aggregateReport, err := p.ParseAggregateFromBytes(data)
+	if err == nil {
+		return w.WriteAggregateReport(aggregateReport)
+	}
+	parseErrors = append(parseErrors, fmt.Sprintf("aggregate: %v", err))
+
+	forensicReport, err := p.ParseForensicFromBytes(data)
+	if err == nil {
+		return w.WriteForensicReport(forensicReport)
+	}
+	parseErrors = append(parseErrors, fmt.Sprintf("forensic: %v", err))
+
+	smtpTLSReport, err := p.ParseSMTPTLSFromBytes(data)
+	if err == nil {
+		return w.WriteSMTPTLSReport(smtpTLSReport)
+	}
+	parseErrors = append(parseErrors, fmt.Sprintf("smtp_tls: %v", err))
+
+	return fmt.Errorf("unable to parse data as any known DMARC report type. Details: %s",
+		strings.Join(parseErrors, "; "))
+}
+
+// negotiateOutputFormat picks the output format with the highest quality in
+// an Accept header. An empty header or a wildcard selects JSON.
+func negotiateOutputFormat(accept string) (output.Format, string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return output.FormatJSON, "application/json; charset=utf-8", true
+	}
+
+	var (
+		best        output.Format
+		bestType    string
+		bestQuality float64
+	)
+
+	for _, entry := range strings.Split(accept, ",") {
+		params := strings.Split(entry, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		var format output.Format
+		var contentType string
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			format, contentType = output.FormatJSON, "application/json; charset=utf-8"
+		case "text/csv", "text/*":
+			format, contentType = output.FormatCSV, "text/csv; charset=utf-8"
+		default:
+			continue
+		}
+
+		if quality > bestQuality {
+			best, bestType, bestQuality = format, contentType, quality
+		}
+	}
+
+	return best, bestType, bestQuality > 0
+}
+
+// writeMode maps the request method to a parser write mode. With
+// rest_semantics enabled, POST creates a report and fails on duplicates while
+// PUT replaces any stored report with the same report ID.
+func (s *Server) writeMode(method string) parser.WriteMode {
+	if !s.config.RESTSemantics {
+		return parser.WriteModeAppend
+	}
+
+	switch method {
+	case http.MethodPost:
+		return parser.WriteModeCreate
+	case http.MethodPut:
+		return parser.WriteModeReplace
+	default:
+		return parser.WriteModeAppend
+	}
 }
 
 // Validation helpers
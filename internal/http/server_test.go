@@ -2,16 +2,33 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
 	"parsedmarc-go/internal/config"
 	"parsedmarc-go/internal/parser"
 )
@@ -23,7 +40,7 @@ func setupTestServer(t *testing.T) *Server {
 	parserConfig := config.ParserConfig{
 		Offline: true,
 	}
-	p := parser.New(parserConfig, nil, logger)
+	p := parser.New(parserConfig, nil, logger, nil)
 
 	// Create HTTP server config
 	httpConfig := config.HTTPConfig{
@@ -35,7 +52,7 @@ func setupTestServer(t *testing.T) *Server {
 		RateBurst:     10,
 	}
 
-	return New(httpConfig, p, logger)
+	return New(httpConfig, p, logger, nil)
 }
 
 func TestServer_HandleHealth(t *testing.T) {
@@ -65,6 +82,69 @@ func TestServer_HandleHealth(t *testing.T) {
 	}
 }
 
+func TestServer_HandleReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]ReadinessCheck
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]interface{}
+	}{
+		{
+			name:       "No dependencies",
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+			wantChecks: map[string]interface{}{},
+		},
+		{
+			name: "Dependencies reachable",
+			checks: map[string]ReadinessCheck{
+				"storage": func(context.Context) error { return nil },
+				"kafka":   func(context.Context) error { return nil },
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+			wantChecks: map[string]interface{}{"storage": "ok", "kafka": "ok"},
+		},
+		{
+			name: "Storage unreachable",
+			checks: map[string]ReadinessCheck{
+				"storage": func(context.Context) error { return errors.New("connection refused") },
+				"kafka":   func(context.Context) error { return nil },
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not_ready",
+			wantChecks: map[string]interface{}{"storage": "connection refused", "kafka": "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := setupTestServer(t)
+			for name, check := range tt.checks {
+				server.AddReadinessCheck(name, check)
+			}
+
+			recorder := httptest.NewRecorder()
+			server.setupRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil))
+
+			if recorder.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, recorder.Code)
+			}
+			var response map[string]interface{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if response["status"] != tt.wantStatus {
+				t.Errorf("Expected status %q, got %v", tt.wantStatus, response["status"])
+			}
+			if !reflect.DeepEqual(response["checks"], tt.wantChecks) {
+				t.Errorf("Expected checks %v, got %v", tt.wantChecks, response["checks"])
+			}
+		})
+	}
+}
+
 func TestServer_HandleRoot(t *testing.T) {
 	server := setupTestServer(t)
 
@@ -273,6 +353,131 @@ func TestServer_HandleDMARCReport_SMTPTLSReports(t *testing.T) {
 	}
 }
 
+func TestServer_HandleDMARCReport_ResultFields(t *testing.T) {
+	server := setupTestServer(t)
+	router := server.setupRouter()
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		want        map[string]interface{}
+	}{
+		{
+			name:        "aggregate report",
+			path:        "../../samples/aggregate/!example.com!1538204542!1538463818.xml",
+			contentType: "application/xml",
+			want: map[string]interface{}{
+				"report_type":  "aggregate",
+				"report_id":    "example.com:1538463741",
+				"record_count": float64(1),
+			},
+		},
+		{
+			name:        "SMTP TLS report",
+			path:        "../../samples/smtp_tls/rfc8460.json",
+			contentType: "application/tlsrpt+json",
+			want: map[string]interface{}{
+				"report_type": "smtp_tls",
+				"org_name":    "Company-X",
+				"report_id":   "5065427c-23d3-47ca-b6e0-946ea0e8c4be",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.path)
+			if err != nil {
+				t.Fatalf("Failed to read sample file: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/dmarc/report", bytes.NewReader(data))
+			req.Header.Set("Content-Type", tt.contentType)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d, body: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+			}
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+
+			if response["message"] != "DMARC report processed successfully" {
+				t.Errorf("Expected message to be kept, got %v", response["message"])
+			}
+			for field, want := range tt.want {
+				if response[field] != want {
+					t.Errorf("Expected %s %v, got %v", field, want, response[field])
+				}
+			}
+			if _, ok := tt.want["record_count"]; !ok {
+				if _, ok := response["record_count"]; ok {
+					t.Errorf("Expected no record_count for %s, got %v", tt.name, response["record_count"])
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleDMARCReport_TrustedOrgs(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/!example.com!1538204542!1538463818.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		trustedOrgs []string
+		wantStatus  int
+	}{
+		{
+			name:        "trusted organization",
+			trustedOrgs: []string{"accurateplastics.com"},
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "untrusted organization",
+			trustedOrgs: []string{"google.com"},
+			wantStatus:  http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zaptest.NewLogger(t)
+			p := parser.New(config.ParserConfig{Offline: true, TrustedOrgs: tt.trustedOrgs}, nil, logger, prometheus.NewRegistry())
+			server := New(config.HTTPConfig{
+				Enabled:       true,
+				MaxUploadSize: 10 * 1024 * 1024,
+				RateLimit:     100,
+				RateBurst:     10,
+			}, p, logger, prometheus.NewRegistry())
+			router := server.setupRouter()
+
+			req := httptest.NewRequest("POST", "/dmarc/report", bytes.NewReader(data))
+			req.Header.Set("Content-Type", "application/xml")
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d, body: %s", tt.wantStatus, recorder.Code, recorder.Body.String())
+			}
+
+			rejected := testutil.ToFloat64(server.metrics.ReportsFailedTotal.WithLabelValues("aggregate", "untrusted_org"))
+			if tt.wantStatus == http.StatusForbidden && rejected != 1 {
+				t.Errorf("Expected 1 untrusted_org failure, got %v", rejected)
+			}
+			if tt.wantStatus == http.StatusOK && rejected != 0 {
+				t.Errorf("Expected no untrusted_org failure, got %v", rejected)
+			}
+		})
+	}
+}
+
 func TestServer_HandleDMARCReport_InvalidRequests(t *testing.T) {
 	server := setupTestServer(t)
 
@@ -333,11 +538,96 @@ func TestServer_HandleDMARCReport_InvalidRequests(t *testing.T) {
 	}
 }
 
+func TestServer_HandleBase64Report(t *testing.T) {
+	server := setupTestServer(t)
+	router := server.setupRouter()
+
+	data, err := os.ReadFile("../../samples/aggregate/!example.com!1538204542!1538463818.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/dmarc/report/base64", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	encode := func(content []byte, contentType string) string {
+		body, err := json.Marshal(base64ReportRequest{
+			Content:     base64.StdEncoding.EncodeToString(content),
+			ContentType: contentType,
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %v", err)
+		}
+		return string(body)
+	}
+
+	t.Run("valid base64", func(t *testing.T) {
+		for _, tt := range []struct {
+			name        string
+			content     []byte
+			contentType string
+		}{
+			{"XML", data, "application/xml"},
+			{"gzip", gzipData(t, data), "application/gzip"},
+			{"without content type", data, ""},
+		} {
+			recorder := post(encode(tt.content, tt.contentType))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("%s: expected status %d, got %d, body: %s", tt.name, http.StatusOK, recorder.Code, recorder.Body.String())
+			}
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if response["report_type"] != "aggregate" || response["report_id"] != "example.com:1538463741" || response["record_count"] != float64(1) {
+				t.Errorf("%s: unexpected response %v", tt.name, response)
+			}
+		}
+	})
+
+	t.Run("malformed base64", func(t *testing.T) {
+		failures := server.metrics.ReportsFailedTotal.WithLabelValues("unknown", "invalid_base64")
+		before := testutil.ToFloat64(failures)
+
+		recorder := post(`{"content":"PGZlZWRiYWNrPg=!*","content_type":"application/xml"}`)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %d, got %d, body: %s", http.StatusBadRequest, recorder.Code, recorder.Body.String())
+		}
+
+		var response map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response["error"] != "Invalid base64 content" {
+			t.Errorf("Expected an invalid base64 error, got %v", response)
+		}
+		if got := testutil.ToFloat64(failures) - before; got != 1 {
+			t.Errorf("Expected one invalid_base64 failure, got %v", got)
+		}
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, body := range []string{`{"content":`, `{"content_type":"application/xml"}`} {
+			if recorder := post(body); recorder.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, body, recorder.Code)
+			}
+		}
+		if recorder := post(encode([]byte("not a report"), "text/plain")); recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for an invalid content type, got %d", http.StatusBadRequest, recorder.Code)
+		}
+	})
+}
+
 func TestServer_RateLimiting(t *testing.T) {
 	// Create server with low rate limit for testing
 	logger := zaptest.NewLogger(t)
 	parserConfig := config.ParserConfig{Offline: true}
-	p := parser.New(parserConfig, nil, logger)
+	p := parser.New(parserConfig, nil, logger, nil)
 
 	httpConfig := config.HTTPConfig{
 		Enabled:       true,
@@ -348,7 +638,7 @@ func TestServer_RateLimiting(t *testing.T) {
 		RateBurst:     1,
 	}
 
-	server := New(httpConfig, p, logger)
+	server := New(httpConfig, p, logger, nil)
 	router := server.setupRouter()
 
 	// First request should succeed
@@ -371,11 +661,129 @@ func TestServer_RateLimiting(t *testing.T) {
 	// This is more of a smoke test to ensure the middleware is in place
 }
 
+func TestServer_ReportTypeRateLimits(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	p := parser.New(config.ParserConfig{Offline: true}, nil, logger, nil)
+
+	server := New(config.HTTPConfig{
+		Enabled:       true,
+		MaxUploadSize: 10 * 1024 * 1024,
+		RateLimit:     1000,
+		RateBurst:     100,
+		ReportRateLimits: map[string]config.ReportRateLimit{
+			"smtp_tls":  {RateLimit: 1, RateBurst: 1},
+			"aggregate": {RateLimit: 1000, RateBurst: 100},
+		},
+	}, p, logger, nil)
+	router := server.setupRouter()
+
+	tlsReport, err := os.ReadFile(filepath.Join("../../samples/smtp_tls", "smtp_tls.json"))
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+	aggregateReport, err := os.ReadFile(filepath.Join("../../samples/aggregate", "!example.com!1538204542!1538463818.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	send := func(data []byte, contentType, remoteAddr string) int {
+		req, err := http.NewRequest("POST", "/dmarc/report", bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.RemoteAddr = remoteAddr
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if code := send(tlsReport, "application/tlsrpt+json", "192.0.2.1:1234"); code != http.StatusOK {
+		t.Fatalf("First TLS report: expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := send(tlsReport, "application/tlsrpt+json", "192.0.2.1:1235"); code != http.StatusTooManyRequests {
+		t.Errorf("Second TLS report: expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	// The aggregate limit of the same client is unaffected
+	for i := 0; i < 3; i++ {
+		if code := send(aggregateReport, "application/xml", "192.0.2.1:1236"); code != http.StatusOK {
+			t.Errorf("Aggregate report %d: expected status %d, got %d", i+1, http.StatusOK, code)
+		}
+	}
+
+	// and so is the TLS report limit of another client
+	if code := send(tlsReport, "application/tlsrpt+json", "192.0.2.2:1234"); code != http.StatusOK {
+		t.Errorf("TLS report from another client: expected status %d, got %d", http.StatusOK, code)
+	}
+
+	// Reports sent with a Content-Type that does not tell their type count
+	// against the limit of the type detected from the report
+	gzipped := gzipData(t, tlsReport)
+	for _, tt := range []struct {
+		data        []byte
+		contentType string
+	}{
+		{tlsReport, "application/json"},
+		{tlsReport, "application/octet-stream"},
+		{tlsReport, "application/x-www-form-urlencoded"},
+		{gzipped, "application/gzip"},
+	} {
+		if code := send(tt.data, tt.contentType, "192.0.2.1:1238"); code != http.StatusTooManyRequests {
+			t.Errorf("TLS report sent as %q: expected status %d, got %d",
+				tt.contentType, http.StatusTooManyRequests, code)
+		}
+	}
+
+	// Base64 uploads count against the limit of the report they hold
+	for _, contentType := range []string{"application/tlsrpt+json", ""} {
+		body, err := json.Marshal(base64ReportRequest{
+			Content:     base64.StdEncoding.EncodeToString(tlsReport),
+			ContentType: contentType,
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/dmarc/report/base64", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.0.2.1:1237"
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusTooManyRequests {
+			t.Errorf("Base64 TLS report with content type %q: expected status %d, got %d",
+				contentType, http.StatusTooManyRequests, recorder.Code)
+		}
+	}
+}
+
+func TestReportTypeFromContentType(t *testing.T) {
+	tests := map[string]string{
+		"application/xml":                               parser.ReportTypeAggregate,
+		"text/xml; charset=utf-8":                       parser.ReportTypeAggregate,
+		"application/gzip":                              "",
+		"application/zip":                               parser.ReportTypeAggregate,
+		"application/xml-dtd":                           "",
+		"application/tlsrpt+json":                       parser.ReportTypeSMTPTLS,
+		"application/tlsrpt+gzip":                       parser.ReportTypeSMTPTLS,
+		"message/rfc822":                                parser.ReportTypeForensic,
+		"multipart/report; report-type=feedback-report": parser.ReportTypeForensic,
+		"application/x-tlsrpt-notes":                    "",
+		"application/json":                              "",
+		"":                                              "",
+	}
+	for contentType, want := range tests {
+		if got := reportTypeFromContentType(contentType); got != want {
+			t.Errorf("reportTypeFromContentType(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
+
 func TestServer_MaxUploadSize(t *testing.T) {
 	// Create server with small max upload size
 	logger := zaptest.NewLogger(t)
 	parserConfig := config.ParserConfig{Offline: true}
-	p := parser.New(parserConfig, nil, logger)
+	p := parser.New(parserConfig, nil, logger, nil)
 
 	httpConfig := config.HTTPConfig{
 		Enabled:       true,
@@ -386,23 +794,1382 @@ func TestServer_MaxUploadSize(t *testing.T) {
 		RateBurst:     10,
 	}
 
-	server := New(httpConfig, p, logger)
+	server := New(httpConfig, p, logger, nil)
 	router := server.setupRouter()
 
 	// Create a large request body
 	largeBody := bytes.Repeat([]byte("x"), 200) // 200 bytes, larger than limit
 
-	req, err := http.NewRequest("POST", "/dmarc/report", bytes.NewBuffer(largeBody))
+	for _, tt := range []struct {
+		path        string
+		body        []byte
+		contentType string
+	}{
+		{"/dmarc/report", largeBody, "application/xml"},
+		{"/dmarc/report/base64", []byte(`{"content":"` + string(largeBody) + `"}`), "application/json"},
+	} {
+		req, err := http.NewRequest("POST", tt.path, bytes.NewBuffer(tt.body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", tt.contentType)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected status %d, got %d", tt.path, http.StatusRequestEntityTooLarge, recorder.Code)
+		}
+	}
+}
+
+// gzipData compresses data with gzip
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatalf("Failed to compress data: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to compress data: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestServer_GzipRequestDecompression(t *testing.T) {
+	xmlReport, err := os.ReadFile(filepath.Join("../../samples/aggregate", "!example.com!1538204542!1538463818.xml"))
 	if err != nil {
-		t.Fatalf("Failed to create request: %v", err)
+		t.Fatalf("Failed to read sample file: %v", err)
 	}
-	req.Header.Set("Content-Type", "application/xml")
 
+	tests := []struct {
+		name          string
+		maxUploadSize int64
+		body          []byte
+		contentType   string
+		wantStatus    int
+	}{
+		{
+			name:          "gzip encoded XML",
+			maxUploadSize: 10 * 1024 * 1024,
+			body:          gzipData(t, xmlReport),
+			contentType:   "application/xml",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "gzip encoded gzipped report",
+			maxUploadSize: 10 * 1024 * 1024,
+			body:          gzipData(t, gzipData(t, xmlReport)),
+			contentType:   "application/gzip",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "invalid gzip body",
+			maxUploadSize: 10 * 1024 * 1024,
+			body:          xmlReport,
+			contentType:   "application/xml",
+			wantStatus:    http.StatusBadRequest,
+		},
+		{
+			name:          "decompressed body over the upload limit",
+			maxUploadSize: 1024,
+			body:          gzipData(t, bytes.Repeat([]byte("x"), 100*1024)),
+			contentType:   "application/xml",
+			wantStatus:    http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := setupTestServer(t)
+			server.config.MaxUploadSize = tt.maxUploadSize
+			router := server.setupRouter()
+
+			req := httptest.NewRequest("POST", "/dmarc/report", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Content-Encoding", "gzip")
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d, body: %s", tt.wantStatus, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_GzipResponseCompression(t *testing.T) {
+	server := setupTestServer(t)
+	router := server.setupRouter()
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{name: "gzip accepted", acceptEncoding: "gzip, deflate, br", wantGzip: true},
+		{name: "gzip with quality", acceptEncoding: "br;q=1.0, gzip;q=0.5", wantGzip: true},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0", wantGzip: false},
+		{name: "no Accept-Encoding", acceptEncoding: "", wantGzip: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/health", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, recorder.Code)
+			}
+
+			body := recorder.Body.Bytes()
+			gzipped := recorder.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("Expected gzip response %v, got Content-Encoding %q", tt.wantGzip, recorder.Header().Get("Content-Encoding"))
+			}
+			if gzipped {
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("Failed to open gzip response: %v", err)
+				}
+				if body, err = io.ReadAll(gz); err != nil {
+					t.Fatalf("Failed to decompress response: %v", err)
+				}
+			}
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if response["status"] != "healthy" {
+				t.Errorf("Expected status healthy, got %v", response["status"])
+			}
+		})
+	}
+}
+
+func TestServer_GzipResponseKeepsMetricsEncoding(t *testing.T) {
+	server := setupTestServer(t)
+	router := server.setupRouter()
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
 	recorder := httptest.NewRecorder()
 	router.ServeHTTP(recorder, req)
 
-	if recorder.Code != http.StatusRequestEntityTooLarge {
-		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, recorder.Code)
+	// promhttp compresses the metrics itself; they must not be compressed twice
+	gz, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatalf("Failed to open gzip response: %v", err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to decompress response: %v", err)
+	}
+	if !strings.Contains(string(body), "# HELP") {
+		t.Errorf("Expected Prometheus text format after one decompression, got %q", body[:min(len(body), 100)])
+	}
+}
+
+// newMultipartBody builds a multipart/form-data body with the given file
+// fields, each mapping a file name to its content, and a plain form field
+func newMultipartBody(t *testing.T, files map[string][]byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("comment", "uploaded by test"); err != nil {
+		t.Fatalf("Failed to write form field: %v", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		part, err := writer.CreateFormFile("report", name)
+		if err != nil {
+			t.Fatalf("Failed to create file part: %v", err)
+		}
+		if _, err := part.Write(files[name]); err != nil {
+			t.Fatalf("Failed to write file part: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close multipart writer: %v", err)
+	}
+
+	return &body, writer.FormDataContentType()
+}
+
+func TestServer_HandleDMARCReport_Multipart(t *testing.T) {
+	xmlData, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		files          map[string][]byte
+		maxUploadSize  int64
+		expectedStatus int
+		expectedFiles  map[string]string
+	}{
+		{
+			name:           "XML file",
+			files:          map[string][]byte{"report.xml": xmlData},
+			expectedStatus: http.StatusOK,
+			expectedFiles:  map[string]string{"report.xml": "processed"},
+		},
+		{
+			name:           "one valid and one invalid file",
+			files:          map[string][]byte{"a-report.xml": xmlData, "b-notes.txt": []byte("not a report")},
+			expectedStatus: http.StatusOK,
+			expectedFiles:  map[string]string{"a-report.xml": "processed", "b-notes.txt": "failed"},
+		},
+		{
+			name:           "only invalid files",
+			files:          map[string][]byte{"notes.txt": []byte("not a report"), "empty.xml": {}},
+			expectedStatus: http.StatusBadRequest,
+			expectedFiles:  map[string]string{"notes.txt": "failed", "empty.xml": "failed"},
+		},
+		{
+			name:           "no file fields",
+			files:          map[string][]byte{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "upload too large",
+			files:          map[string][]byte{"report.xml": xmlData},
+			maxUploadSize:  512,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newMemoryStorage()
+			logger := zaptest.NewLogger(t)
+			p := parser.New(config.ParserConfig{Offline: true}, storage, logger, nil)
+			maxUploadSize := tt.maxUploadSize
+			if maxUploadSize == 0 {
+				maxUploadSize = 10 * 1024 * 1024
+			}
+			router := New(config.HTTPConfig{
+				Enabled:       true,
+				MaxUploadSize: maxUploadSize,
+			}, p, logger, prometheus.NewRegistry()).setupRouter()
+
+			body, contentType := newMultipartBody(t, tt.files)
+			req := httptest.NewRequest("POST", "/dmarc/report", body)
+			req.Header.Set("Content-Type", contentType)
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			if tt.expectedFiles == nil {
+				return
+			}
+
+			var response struct {
+				Files []struct {
+					Field    string `json:"field"`
+					Filename string `json:"filename"`
+					Status   string `json:"status"`
+					Error    string `json:"error"`
+				} `json:"files"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if len(response.Files) != len(tt.expectedFiles) {
+				t.Fatalf("Expected %d file results, got %d: %s", len(tt.expectedFiles), len(response.Files), recorder.Body.String())
+			}
+			processed := 0
+			for _, file := range response.Files {
+				if file.Field != "report" {
+					t.Errorf("File %s: field = %q, want %q", file.Filename, file.Field, "report")
+				}
+				if want := tt.expectedFiles[file.Filename]; file.Status != want {
+					t.Errorf("File %s: status = %q, want %q", file.Filename, file.Status, want)
+				}
+				if file.Status == "failed" && file.Error == "" {
+					t.Errorf("File %s: expected an error message", file.Filename)
+				}
+				if file.Status == "processed" {
+					processed++
+				}
+			}
+
+			if len(storage.aggregateReports["b043f0e264cf4ea995e93765242f6dfb"]) != processed {
+				t.Errorf("Expected %d stored reports, got %d", processed, len(storage.aggregateReports))
+			}
+		})
+	}
+}
+
+func TestServer_HandleBatchReports(t *testing.T) {
+	aggregateReport, err := os.ReadFile("../../samples/aggregate/!example.com!1538204542!1538463818.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+	tlsReport, err := os.ReadFile("../../samples/smtp_tls/rfc8460.json")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	type batchResponse struct {
+		Processed int               `json:"processed"`
+		Failed    int               `json:"failed"`
+		Results   []batchItemResult `json:"results"`
+	}
+	send := func(t *testing.T, router http.Handler, body io.Reader, contentType string) (*httptest.ResponseRecorder, batchResponse) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/dmarc/reports", body)
+		req.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		var response batchResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v, body: %s", err, recorder.Body.String())
+		}
+		return recorder, response
+	}
+
+	t.Run("JSON array with partial success", func(t *testing.T) {
+		router := setupTestServer(t).setupRouter()
+
+		body, err := json.Marshal([]base64ReportRequest{
+			{Content: base64.StdEncoding.EncodeToString(aggregateReport), ContentType: "application/xml"},
+			{Content: "not base64!"},
+			{Content: base64.StdEncoding.EncodeToString(tlsReport), ContentType: "application/tlsrpt+json"},
+			{Content: base64.StdEncoding.EncodeToString([]byte("not a report"))},
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal batch: %v", err)
+		}
+
+		recorder, response := send(t, router, bytes.NewReader(body), "application/json")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d, body: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+		}
+		if response.Processed != 2 || response.Failed != 2 || len(response.Results) != 4 {
+			t.Fatalf("Expected 2 processed and 2 failed reports, got %+v", response)
+		}
+
+		wantSuccess := []bool{true, false, true, false}
+		for i, result := range response.Results {
+			if result.Index != i || result.Success != wantSuccess[i] {
+				t.Errorf("Result %d: index %d, success %v, want success %v", i, result.Index, result.Success, wantSuccess[i])
+			}
+			if !result.Success && result.Error == "" {
+				t.Errorf("Result %d: expected an error", i)
+			}
+		}
+		if got := response.Results[1].Error; got != "Invalid base64 encoding" {
+			t.Errorf("Expected a base64 error, got %q", got)
+		}
+		if reports := response.Results[2].Reports; len(reports) != 1 || reports[0].ReportType != parser.ReportTypeSMTPTLS {
+			t.Errorf("Expected an SMTP TLS report, got %+v", reports)
+		}
+	})
+
+	t.Run("multipart with partial success", func(t *testing.T) {
+		router := setupTestServer(t).setupRouter()
+
+		body, contentType := newMultipartBody(t, map[string][]byte{
+			"aggregate.xml": aggregateReport,
+			"notes.txt":     []byte("not a report"),
+		})
+		recorder, response := send(t, router, body, contentType)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d, body: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+		}
+		if response.Processed != 1 || response.Failed != 1 || len(response.Results) != 2 {
+			t.Fatalf("Expected 1 processed and 1 failed report, got %+v", response)
+		}
+		if result := response.Results[0]; !result.Success || result.Filename != "aggregate.xml" || result.Status != fileStatusProcessed {
+			t.Errorf("Unexpected result for aggregate.xml: %+v", result)
+		}
+		if result := response.Results[1]; result.Success || result.Filename != "notes.txt" || result.Status != fileStatusFailed {
+			t.Errorf("Unexpected result for notes.txt: %+v", result)
+		}
+	})
+
+	t.Run("report type rate limits", func(t *testing.T) {
+		server := setupTestServer(t)
+		server.config.ReportRateLimits = map[string]config.ReportRateLimit{
+			"smtp_tls": {RateLimit: 1, RateBurst: 1},
+		}
+		router := server.setupRouter()
+
+		encoded := base64.StdEncoding.EncodeToString(tlsReport)
+		body, err := json.Marshal([]base64ReportRequest{
+			{Content: encoded, ContentType: "application/tlsrpt+json"},
+			{Content: encoded},
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal batch: %v", err)
+		}
+		_, response := send(t, router, bytes.NewReader(body), "application/json")
+		if response.Processed != 1 || len(response.Results) != 2 {
+			t.Fatalf("Expected 1 processed report, got %+v", response)
+		}
+		if got := response.Results[1].Error; got != "Rate limit exceeded" {
+			t.Errorf("Expected the second report to be rate limited, got %q", got)
+		}
+
+		// Multipart files count against the same limit
+		multipartBody, contentType := newMultipartBody(t, map[string][]byte{"tls.json": tlsReport})
+		_, response = send(t, router, multipartBody, contentType)
+		if len(response.Results) != 1 || response.Results[0].Error != "Rate limit exceeded" {
+			t.Errorf("Expected the multipart report to be rate limited, got %+v", response)
+		}
+	})
+
+	t.Run("no valid report", func(t *testing.T) {
+		router := setupTestServer(t).setupRouter()
+
+		recorder, response := send(t, router, strings.NewReader(`[{"content":"%%%"}]`), "application/json")
+		if recorder.Code != http.StatusBadRequest || len(response.Results) != 1 || response.Results[0].Success {
+			t.Errorf("Expected status %d with a failed result, got %d, body: %s", http.StatusBadRequest, recorder.Code, recorder.Body.String())
+		}
+	})
+
+	t.Run("invalid batches", func(t *testing.T) {
+		server := setupTestServer(t)
+		server.config.MaxBatchReports = 2
+		router := server.setupRouter()
+
+		item := `{"content":"` + base64.StdEncoding.EncodeToString(aggregateReport) + `"}`
+		tests := []struct {
+			name        string
+			body        string
+			contentType string
+		}{
+			{"too many reports", "[" + item + "," + item + "," + item + "]", "application/json"},
+			{"empty array", "[]", "application/json"},
+			{"not an array", `{"content":"PGZlZWRiYWNrLz4="}`, "application/json"},
+			{"invalid content type", item, "application/xml"},
+		}
+		for _, tt := range tests {
+			if recorder, _ := send(t, router, strings.NewReader(tt.body), tt.contentType); recorder.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status %d, got %d, body: %s", tt.name, http.StatusBadRequest, recorder.Code, recorder.Body.String())
+			}
+		}
+	})
+
+	t.Run("batch over max upload size", func(t *testing.T) {
+		server := setupTestServer(t)
+		server.config.MaxUploadSize = int64(len(aggregateReport)) * 3 / 2
+		router := server.setupRouter()
+
+		body, contentType := newMultipartBody(t, map[string][]byte{
+			"first.xml":  aggregateReport,
+			"second.xml": aggregateReport,
+		})
+		if recorder, _ := send(t, router, body, contentType); recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status %d, got %d, body: %s", http.StatusRequestEntityTooLarge, recorder.Code, recorder.Body.String())
+		}
+	})
+}
+
+func TestServer_RequireTLS(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	parserConfig := config.ParserConfig{Offline: true}
+	p := parser.New(parserConfig, nil, logger, nil)
+
+	httpConfig := config.HTTPConfig{
+		Enabled:        true,
+		Host:           "localhost",
+		Port:           8080,
+		MaxUploadSize:  50 * 1024 * 1024,
+		RateLimit:      1000,
+		RateBurst:      10,
+		RequireTLS:     true,
+		TrustedProxies: []string{"192.0.2.0/24"},
+	}
+
+	server := New(httpConfig, p, logger, nil)
+	router := server.setupRouter()
+
+	data, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Skip("Sample file not found, skipping test")
+	}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedProto string
+		tls            bool
+		expectedStatus int
+	}{
+		{
+			name:           "plaintext request",
+			remoteAddr:     "192.0.2.1:1234",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "forwarded as HTTPS by trusted proxy",
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedProto: "https",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "forwarded as HTTP by trusted proxy",
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedProto: "http",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "forwarded as HTTPS by untrusted client",
+			remoteAddr:     "198.51.100.7:1234",
+			forwardedProto: "https",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "native TLS",
+			remoteAddr:     "198.51.100.7:1234",
+			tls:            true,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/dmarc/report", bytes.NewReader(data))
+			req.Header.Set("Content-Type", "application/xml")
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	// Non-ingest endpoints are not restricted
+	req := httptest.NewRequest("GET", "/health", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected health status %d, got %d", http.StatusOK, recorder.Code)
+	}
+}
+
+func TestServer_APIKeys(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	parserConfig := config.ParserConfig{Offline: true}
+	p := parser.New(parserConfig, nil, logger, nil)
+
+	httpConfig := config.HTTPConfig{
+		Enabled:       true,
+		Host:          "localhost",
+		Port:          8080,
+		MaxUploadSize: 50 * 1024 * 1024,
+		RateLimit:     1000,
+		RateBurst:     10,
+		APIKeys:       []string{"first-key", "second-key"},
+	}
+
+	server := New(httpConfig, p, logger, prometheus.NewRegistry())
+	router := server.setupRouter()
+
+	data, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Skip("Sample file not found, skipping test")
+	}
+
+	tests := []struct {
+		name            string
+		headers         map[string]string
+		expectedStatus  int
+		unauthorizedInc float64
+	}{
+		{
+			name:           "valid bearer token",
+			headers:        map[string]string{"Authorization": "Bearer second-key"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "valid X-API-Key",
+			headers:        map[string]string{"X-API-Key": "first-key"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:            "missing key",
+			expectedStatus:  http.StatusUnauthorized,
+			unauthorizedInc: 1,
+		},
+		{
+			name:            "wrong bearer token",
+			headers:         map[string]string{"Authorization": "Bearer wrong-key"},
+			expectedStatus:  http.StatusUnauthorized,
+			unauthorizedInc: 1,
+		},
+		{
+			name:            "wrong X-API-Key",
+			headers:         map[string]string{"X-API-Key": "wrong-key"},
+			expectedStatus:  http.StatusUnauthorized,
+			unauthorizedInc: 1,
+		},
+		{
+			name:            "key with wrong scheme",
+			headers:         map[string]string{"Authorization": "Basic first-key"},
+			expectedStatus:  http.StatusUnauthorized,
+			unauthorizedInc: 1,
+		},
+	}
+
+	unauthorized := server.metrics.ReportsFailedTotal.WithLabelValues("unknown", "unauthorized")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(unauthorized)
+
+			req := httptest.NewRequest("POST", "/dmarc/report", bytes.NewReader(data))
+			req.Header.Set("Content-Type", "application/xml")
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			if got := testutil.ToFloat64(unauthorized) - before; got != tt.unauthorizedInc {
+				t.Errorf("Expected unauthorized counter to increase by %v, got %v", tt.unauthorizedInc, got)
+			}
+		})
+	}
+
+	// The health check does not require a key
+	req := httptest.NewRequest("GET", "/health", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected health status %d, got %d", http.StatusOK, recorder.Code)
+	}
+}
+
+func TestServer_HandleParse_ContentNegotiation(t *testing.T) {
+	server := setupTestServer(t)
+	router := server.setupRouter()
+
+	data, err := os.ReadFile(filepath.Join("../../samples/aggregate", "!example.com!1538204542!1538463818.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		accept          string
+		wantStatus      int
+		wantContentType string
+	}{
+		{"Default JSON", "", http.StatusOK, "application/json"},
+		{"Explicit JSON", "application/json", http.StatusOK, "application/json"},
+		{"CSV", "text/csv", http.StatusOK, "text/csv"},
+		{"CSV preferred by quality", "application/json;q=0.5, text/csv", http.StatusOK, "text/csv"},
+		{"Wildcard", "*/*", http.StatusOK, "application/json"},
+		{"Unsupported", "application/pdf", http.StatusNotAcceptable, "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/parse", bytes.NewBuffer(data))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/xml")
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d, body: %s", tt.wantStatus, recorder.Code, recorder.Body.String())
+			}
+			if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, tt.wantContentType) {
+				t.Errorf("Expected Content-Type %s, got %s", tt.wantContentType, contentType)
+			}
+		})
+	}
+}
+
+func TestServer_HandleParse_CSVBody(t *testing.T) {
+	server := setupTestServer(t)
+	router := server.setupRouter()
+
+	data, err := os.ReadFile(filepath.Join("../../samples/aggregate", "!example.com!1538204542!1538463818.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", "/parse", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "text/csv")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d, body: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	rows, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Response is not valid CSV: %v", err)
+	}
+	if len(rows) < 2 {
+		t.Fatalf("Expected header and at least one record row, got %d rows", len(rows))
+	}
+	if rows[0][0] != "report_id" {
+		t.Errorf("Expected first header column report_id, got %s", rows[0][0])
+	}
+}
+
+// memoryStorage keeps aggregate reports in memory keyed by report ID
+type memoryStorage struct {
+	aggregateReports map[string][]*parser.AggregateReport
+	deleted          []string
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{aggregateReports: make(map[string][]*parser.AggregateReport)}
+}
+
+func (m *memoryStorage) StoreAggregateReport(report *parser.AggregateReport) error {
+	id := report.ReportMetadata.ReportID
+	m.aggregateReports[id] = append(m.aggregateReports[id], report)
+	return nil
+}
+
+func (m *memoryStorage) StoreForensicReport(report *parser.ForensicReport) error {
+	return nil
+}
+
+func (m *memoryStorage) StoreSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	return nil
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
+}
+
+func (m *memoryStorage) ReportExists(reportType, orgName, reportID string) (bool, error) {
+	for _, report := range m.aggregateReports[reportID] {
+		if report.ReportMetadata.OrgName == orgName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryStorage) DeleteReport(reportType, orgName, reportID string) error {
+	m.aggregateReports[reportID] = slices.DeleteFunc(m.aggregateReports[reportID], func(report *parser.AggregateReport) bool {
+		return report.ReportMetadata.OrgName == orgName
+	})
+	m.deleted = append(m.deleted, reportID)
+	return nil
+}
+
+func setupRESTSemanticsServer(t *testing.T, storage *memoryStorage) *Server {
+	logger := zaptest.NewLogger(t)
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, nil)
+
+	return New(config.HTTPConfig{
+		Enabled:       true,
+		MaxUploadSize: 10 * 1024 * 1024,
+		RESTSemantics: true,
+	}, p, logger, nil)
+}
+
+func sendReport(t *testing.T, router http.Handler, method string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(method, "/dmarc/report", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// slowStorage blocks storing aggregate reports until release is closed
+type slowStorage struct {
+	memoryStorage
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStorage) StoreAggregateReport(report *parser.AggregateReport) error {
+	close(s.started)
+	<-s.release
+	return s.memoryStorage.StoreAggregateReport(report)
+}
+
+func TestServer_StopWaitsForInFlightReports(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	storage := &slowStorage{
+		memoryStorage: *newMemoryStorage(),
+		started:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+	logger := zaptest.NewLogger(t)
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, prometheus.NewRegistry())
+	server := New(config.HTTPConfig{Enabled: true, MaxUploadSize: 10 * 1024 * 1024}, p, logger, prometheus.NewRegistry())
+	router := server.setupRouter()
+
+	responded := make(chan int, 1)
+	go func() { responded <- sendReport(t, router, "POST", data).Code }()
+	<-storage.started
+
+	if got := testutil.ToFloat64(server.metrics.ReportsInFlight); got != 1 {
+		t.Errorf("Expected 1 report in flight, got %v", got)
+	}
+
+	// The context bounds the wait
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Stop to give up when the context expires, got %v", err)
+	}
+
+	// Reports received once Stop started waiting are refused
+	if code := sendReport(t, router, "POST", data).Code; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d for a report sent while stopping, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Stop(context.Background()) }()
+	select {
+	case err := <-stopped:
+		t.Fatalf("Expected Stop to wait for the report in flight, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(storage.release)
+	if err := <-stopped; err != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+	if code := <-responded; code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if len(storage.aggregateReports) != 1 {
+		t.Errorf("Expected the report in flight to be stored, got %d reports", len(storage.aggregateReports))
+	}
+	if got := testutil.ToFloat64(server.metrics.ReportsInFlight); got != 0 {
+		t.Errorf("Expected no report in flight, got %v", got)
+	}
+}
+
+// blockingBatchStorage blocks storing batches of aggregate reports until
+// release is closed
+type blockingBatchStorage struct {
+	memoryStorage
+
+	release chan struct{}
+}
+
+func (b *blockingBatchStorage) StoreAggregateReports(reports []*parser.AggregateReport) error {
+	<-b.release
+	return nil
+}
+
+func TestServer_StopBoundsQueuedReports(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	storage := &blockingBatchStorage{memoryStorage: *newMemoryStorage(), release: make(chan struct{})}
+	defer close(storage.release)
+	logger := zaptest.NewLogger(t)
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, nil)
+	server := New(config.HTTPConfig{
+		Enabled:        true,
+		MaxUploadSize:  10 * 1024 * 1024,
+		BatchSize:      1,
+		BatchInterval:  time.Hour,
+		BatchQueueSize: 10,
+	}, p, logger, nil)
+	router := server.setupRouter()
+
+	if code := sendReport(t, router, "POST", data).Code; code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Stop(ctx) }()
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected Stop to give up on the queued report, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Stop to return when the context expires")
+	}
+}
+
+// batchStorage records the batches of aggregate reports it stores
+type batchStorage struct {
+	memoryStorage
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (b *batchStorage) StoreAggregateReports(reports []*parser.AggregateReport) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ids []string
+	for _, report := range reports {
+		ids = append(ids, report.ReportMetadata.ReportID)
+	}
+	b.batches = append(b.batches, ids)
+	return nil
+}
+
+func (b *batchStorage) stored() [][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([][]string(nil), b.batches...)
+}
+
+func TestServer_HandleDMARCReport_Batching(t *testing.T) {
+	storage := &batchStorage{memoryStorage: *newMemoryStorage()}
+	logger := zaptest.NewLogger(t)
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, nil)
+	server := New(config.HTTPConfig{
+		Enabled:        true,
+		MaxUploadSize:  10 * 1024 * 1024,
+		BatchSize:      2,
+		BatchInterval:  time.Hour,
+		BatchQueueSize: 10,
+	}, p, logger, nil)
+	router := server.setupRouter()
+
+	var reports [][]byte
+	for _, name := range []string{
+		"!example.com!1538204542!1538463818.xml",
+		"example.net!example.com!1529366400!1529452799.xml",
+		"addisonfoods.com!example.com!1536105600!1536191999.xml",
+	} {
+		data, err := os.ReadFile(filepath.Join("../../samples/aggregate", name))
+		if err != nil {
+			t.Fatalf("Failed to read sample file: %v", err)
+		}
+		reports = append(reports, data)
+	}
+
+	for _, data := range reports {
+		if recorder := sendReport(t, router, "POST", data); recorder.Code != http.StatusAccepted {
+			t.Fatalf("Expected status %d, got %d, body: %s", http.StatusAccepted, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// The first two reports fill a batch; the third waits for the interval
+	deadline := time.Now().Add(5 * time.Second)
+	for len(storage.stored()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if batches := storage.stored(); len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("Expected one batch of 2 reports, got %v", batches)
+	}
+
+	if err := server.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	batches := storage.stored()
+	if len(batches) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("Expected the queued report to be stored on shutdown, got %v", batches)
+	}
+	if len(storage.aggregateReports) != 0 {
+		t.Error("Expected reports to be stored in batches, not one by one")
+	}
+
+	if recorder := sendReport(t, router, "POST", reports[0]); recorder.Code == http.StatusAccepted {
+		t.Error("Expected reports to be rejected after shutdown")
+	}
+}
+
+// validationTestReport is an aggregate report with the given reporter email
+// and published policy
+func validationTestReport(email, policy string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feedback>
+  <report_metadata>
+    <org_name>example.net</org_name>
+    <email>` + email + `</email>
+    <report_id>validation-1</report_id>
+    <date_range><begin>1704153600</begin><end>1704239999</end></date_range>
+  </report_metadata>
+  <policy_published>
+    <domain>example.com</domain>
+    <p>` + policy + `</p>
+  </policy_published>
+  <record>
+    <row>
+      <source_ip>192.0.2.1</source_ip>
+      <count>1</count>
+      <policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>pass</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+    <auth_results><spf><domain>example.com</domain><result>pass</result></spf></auth_results>
+  </record>
+</feedback>`)
+}
+
+func TestServer_HandleDMARCReport_StrictValidation(t *testing.T) {
+	warningReport := validationTestReport("not-an-email", "none")
+	invalidReport := validationTestReport("dmarc@example.net", "monitor")
+
+	tests := []struct {
+		name         string
+		strict       bool
+		data         []byte
+		wantStatus   int
+		wantErrors   []interface{}
+		wantWarnings []interface{}
+	}{
+		{
+			name:         "Warnings are returned with the result",
+			strict:       true,
+			data:         warningReport,
+			wantStatus:   http.StatusOK,
+			wantWarnings: []interface{}{"Invalid email format in report metadata"},
+		},
+		{
+			name:       "Invalid report is rejected",
+			strict:     true,
+			data:       invalidReport,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantErrors: []interface{}{"Invalid DMARC policy value"},
+		},
+		{
+			name:         "Warnings are returned without strict validation",
+			data:         warningReport,
+			wantStatus:   http.StatusOK,
+			wantWarnings: []interface{}{"Invalid email format in report metadata"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zaptest.NewLogger(t)
+			storage := newMemoryStorage()
+			p := parser.New(config.ParserConfig{Offline: true}, storage, logger, nil)
+			core, audit := observer.New(zap.InfoLevel)
+			p.SetAuditLogger(zap.New(core))
+			server := New(config.HTTPConfig{
+				Enabled:          true,
+				MaxUploadSize:    10 * 1024 * 1024,
+				StrictValidation: tt.strict,
+			}, p, logger, prometheus.NewRegistry())
+
+			recorder := sendReport(t, server.setupRouter(), "POST", tt.data)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d, body: %s", tt.wantStatus, recorder.Code, recorder.Body.String())
+			}
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if tt.wantErrors != nil && !reflect.DeepEqual(response["errors"], tt.wantErrors) {
+				t.Errorf("Expected errors %v, got %v", tt.wantErrors, response["errors"])
+			}
+			if tt.wantWarnings != nil && !reflect.DeepEqual(response["warnings"], tt.wantWarnings) {
+				t.Errorf("Expected warnings %v, got %v", tt.wantWarnings, response["warnings"])
+			}
+
+			stored := len(storage.aggregateReports)
+			if tt.wantStatus == http.StatusOK && stored != 1 {
+				t.Errorf("Expected the report to be stored, got %d reports", stored)
+			}
+			if tt.wantStatus != http.StatusOK && stored != 0 {
+				t.Errorf("Expected the invalid report not to be stored, got %d reports", stored)
+			}
+
+			// Rejected reports are audited like accepted ones
+			wantOutcome := parser.AuditOutcomeSuccess
+			if tt.wantStatus != http.StatusOK {
+				wantOutcome = parser.AuditOutcomeFailure
+			}
+			entries := audit.FilterMessage("report_ingestion").All()
+			if len(entries) != 1 {
+				t.Fatalf("Expected 1 audit record, got %d", len(entries))
+			}
+			if fields := entries[0].ContextMap(); fields["outcome"] != wantOutcome || fields["source"] != parser.SourceHTTP {
+				t.Errorf("Expected a %s audit record from http, got %v", wantOutcome, fields)
+			}
+		})
+	}
+}
+
+func TestServer_HandleDMARCReport_PUTReplacesExisting(t *testing.T) {
+	storage := newMemoryStorage()
+	router := setupRESTSemanticsServer(t, storage).setupRouter()
+
+	data, err := os.ReadFile(filepath.Join("../../samples/aggregate", "!example.com!1538204542!1538463818.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	if recorder := sendReport(t, router, "POST", data); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for initial POST, got %d, body: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := sendReport(t, router, "PUT", data); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for PUT, got %d, body: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	if len(storage.deleted) != 1 {
+		t.Errorf("Expected the existing report to be deleted once, got %d deletions", len(storage.deleted))
+	}
+	if len(storage.aggregateReports) != 1 {
+		t.Fatalf("Expected 1 stored report ID, got %d", len(storage.aggregateReports))
+	}
+	for id, reports := range storage.aggregateReports {
+		if len(reports) != 1 {
+			t.Errorf("Expected exactly 1 stored copy of report %s, got %d", id, len(reports))
+		}
+	}
+}
+
+func TestServer_HandleDMARCReport_POSTRejectsDuplicate(t *testing.T) {
+	storage := newMemoryStorage()
+	router := setupRESTSemanticsServer(t, storage).setupRouter()
+
+	data, err := os.ReadFile(filepath.Join("../../samples/aggregate", "!example.com!1538204542!1538463818.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	if recorder := sendReport(t, router, "POST", data); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for initial POST, got %d, body: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	recorder := sendReport(t, router, "POST", data)
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for duplicate POST, got %d, body: %s", http.StatusConflict, recorder.Code, recorder.Body.String())
+	}
+
+	if len(storage.aggregateReports) != 1 {
+		t.Fatalf("Expected 1 stored report ID, got %d", len(storage.aggregateReports))
+	}
+	for id, reports := range storage.aggregateReports {
+		if len(reports) != 1 {
+			t.Errorf("Expected exactly 1 stored copy of report %s, got %d", id, len(reports))
+		}
+	}
+
+	// Report IDs are only unique per organization
+	other := bytes.Replace(data, []byte("<org_name></org_name>"), []byte("<org_name>other.example</org_name>"), 1)
+	if recorder := sendReport(t, router, "POST", other); recorder.Code != http.StatusOK {
+		t.Errorf("Expected status %d for the same report ID from another organization, got %d, body: %s",
+			http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+}
+
+// queryStorage returns canned aggregate reports and records the filter
+type queryStorage struct {
+	memoryStorage
+	reports []*parser.AggregateReport
+	err     error
+	filter  parser.AggregateReportFilter
+}
+
+func (q *queryStorage) QueryAggregateReports(filter parser.AggregateReportFilter) ([]*parser.AggregateReport, error) {
+	q.filter = filter
+	return q.reports, q.err
+}
+
+func TestServer_HandleQueryAggregateReports(t *testing.T) {
+	cannedReports := []*parser.AggregateReport{
+		{
+			ReportMetadata: parser.ReportMetadata{
+				OrgName:   "google.com",
+				ReportID:  "report-2",
+				BeginDate: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			},
+			PolicyPublished: parser.PolicyPublished{Domain: "example.com", P: "reject"},
+			Records: []parser.Record{{
+				Source: parser.Source{IPAddress: "192.0.2.1"},
+				Count:  3,
+			}},
+		},
+		{
+			ReportMetadata: parser.ReportMetadata{
+				OrgName:   "yahoo.com",
+				ReportID:  "report-1",
+				BeginDate: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC),
+			},
+			PolicyPublished: parser.PolicyPublished{Domain: "example.com", P: "reject"},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		storage        parser.Storage
+		expectedStatus int
+		expectedFilter parser.AggregateReportFilter
+		expectedCount  int
+	}{
+		{
+			name:           "default page",
+			query:          "",
+			storage:        &queryStorage{reports: cannedReports},
+			expectedStatus: http.StatusOK,
+			expectedFilter: parser.AggregateReportFilter{Limit: defaultQueryLimit},
+			expectedCount:  2,
+		},
+		{
+			name:           "domain, date range and page",
+			query:          "?domain=Example.COM&from=2024-01-01&to=2024-02-01T00:00:00Z&limit=2&offset=4",
+			storage:        &queryStorage{reports: cannedReports},
+			expectedStatus: http.StatusOK,
+			expectedFilter: parser.AggregateReportFilter{
+				Domain: "example.com",
+				From:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:     time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+				Limit:  2,
+				Offset: 4,
+			},
+			expectedCount: 2,
+		},
+		{
+			name:           "no matching reports",
+			query:          "?domain=example.org",
+			storage:        &queryStorage{},
+			expectedStatus: http.StatusOK,
+			expectedFilter: parser.AggregateReportFilter{Domain: "example.org", Limit: defaultQueryLimit},
+			expectedCount:  0,
+		},
+		{
+			name:           "invalid date",
+			query:          "?from=yesterday",
+			storage:        &queryStorage{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "inverted date range",
+			query:          "?from=2024-02-01&to=2024-01-01",
+			storage:        &queryStorage{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "limit too large",
+			query:          "?limit=5000",
+			storage:        &queryStorage{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "negative offset",
+			query:          "?offset=-1",
+			storage:        &queryStorage{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "storage error",
+			storage:        &queryStorage{err: errors.New("connection refused")},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "storage without query support",
+			storage:        newMemoryStorage(),
+			expectedStatus: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zaptest.NewLogger(t)
+			p := parser.New(config.ParserConfig{Offline: true}, tt.storage, logger, nil)
+			router := New(config.HTTPConfig{Enabled: true}, p, logger, prometheus.NewRegistry()).setupRouter()
+
+			req := httptest.NewRequest("GET", "/reports/aggregate"+tt.query, nil)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			if got := tt.storage.(*queryStorage).filter; got != tt.expectedFilter {
+				t.Errorf("Filter = %+v, want %+v", got, tt.expectedFilter)
+			}
+
+			var response struct {
+				Reports []parser.AggregateReport `json:"reports"`
+				Count   int                      `json:"count"`
+				Limit   int                      `json:"limit"`
+				Offset  int                      `json:"offset"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response.Reports == nil {
+				t.Error("Expected reports to be an array, got null")
+			}
+			if response.Count != tt.expectedCount || len(response.Reports) != tt.expectedCount {
+				t.Errorf("Expected %d reports, got count %d with %d reports", tt.expectedCount, response.Count, len(response.Reports))
+			}
+			if response.Limit != tt.expectedFilter.Limit || response.Offset != tt.expectedFilter.Offset {
+				t.Errorf("Page = limit %d offset %d, want limit %d offset %d",
+					response.Limit, response.Offset, tt.expectedFilter.Limit, tt.expectedFilter.Offset)
+			}
+			if tt.expectedCount > 0 {
+				first := response.Reports[0]
+				if first.ReportMetadata.ReportID != "report-2" || len(first.Records) != 1 || first.Records[0].Count != 3 {
+					t.Errorf("Unexpected first report: %+v", first)
+				}
+			}
+		})
 	}
 }
 
@@ -416,19 +2183,28 @@ func (s *Server) setupRouter() http.Handler {
 	router.Use(s.recoveryMiddleware())
 	router.Use(s.rateLimitMiddleware())
 	router.Use(s.maxSizeMiddleware())
+	router.Use(s.decompressMiddleware())
+	router.Use(gzipResponseMiddleware())
 	router.Use(s.metricsMiddleware())
 
 	// Routes
-	router.POST("/dmarc/report", s.handleDMARCReport)
-	router.PUT("/dmarc/report", s.handleDMARCReport)
-	router.GET("/dmarc/report", s.handleMethodNotAllowed)
-	router.DELETE("/dmarc/report", s.handleMethodNotAllowed)
-	router.PATCH("/dmarc/report", s.handleMethodNotAllowed)
-	router.HEAD("/dmarc/report", s.handleMethodNotAllowed)
-	router.OPTIONS("/dmarc/report", s.handleMethodNotAllowed)
+	api := router.Group("/", s.requireTLSMiddleware(), s.apiKeyMiddleware())
+	api.POST("/dmarc/report", s.handleDMARCReport)
+	api.PUT("/dmarc/report", s.handleDMARCReport)
+	api.GET("/dmarc/report", s.handleMethodNotAllowed)
+	api.DELETE("/dmarc/report", s.handleMethodNotAllowed)
+	api.PATCH("/dmarc/report", s.handleMethodNotAllowed)
+	api.HEAD("/dmarc/report", s.handleMethodNotAllowed)
+	api.OPTIONS("/dmarc/report", s.handleMethodNotAllowed)
+	api.POST("/dmarc/report/base64", s.handleBase64Report)
+	api.POST("/dmarc/reports", s.handleBatchReports)
+
+	api.POST("/parse", s.handleParse)
+	api.GET("/reports/aggregate", s.handleQueryAggregateReports)
 
 	router.GET("/health", s.handleHealth)
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/ready", s.handleReady)
+	router.GET("/metrics", gin.WrapH(s.metricsHandler()))
 	router.GET("/", s.handleRoot)
 
 	return router
@@ -439,7 +2215,7 @@ func BenchmarkServer_HandleDMARCReport(b *testing.B) {
 	// Create a logger and config for benchmarking
 	logger := zaptest.NewLogger(b)
 	parserConfig := config.ParserConfig{Offline: true}
-	p := parser.New(parserConfig, nil, logger)
+	p := parser.New(parserConfig, nil, logger, nil)
 	httpConfig := config.HTTPConfig{
 		Enabled:       true,
 		Host:          "localhost",
@@ -448,7 +2224,7 @@ func BenchmarkServer_HandleDMARCReport(b *testing.B) {
 		RateLimit:     100,
 		RateBurst:     10,
 	}
-	server := New(httpConfig, p, logger)
+	server := New(httpConfig, p, logger, nil)
 	router := server.setupRouter()
 
 	// Load sample data
@@ -1,40 +1,142 @@
 package imap
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
+	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
 	"github.com/emersion/go-message/mail"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/metrics"
 	"parsedmarc-go/internal/parser"
 )
 
 // Client represents an IMAP client for fetching DMARC reports
 type Client struct {
-	config config.IMAPConfig
-	parser *parser.Parser
-	logger *zap.Logger
-	client *client.Client
+	config  config.IMAPConfig
+	parser  *parser.Parser
+	logger  *zap.Logger
+	client  *client.Client
+	metrics *metrics.IMAPMetrics
+
+	// Start of the current connection, see Disconnect
+	connectedAt time.Time
+
+	// Access token obtained with the OAuth2 refresh token, see accessToken
+	token       string
+	tokenExpiry time.Time
+
+	// UID cursor used by process_unseen_only, see processUnseenMessages
+	state       mailboxState
+	stateLoaded bool
+
+	// Compiled subject_patterns, see classifyMessage
+	subjectPatterns []subjectPattern
+
+	// check_interval in seconds, changed by SetCheckInterval while watching
+	checkInterval atomic.Int64
+}
+
+// subjectPattern routes messages whose subject matches re to the parser of
+// reportType
+type subjectPattern struct {
+	reportType string
+	re         *regexp.Regexp
+}
+
+// New creates a new IMAP client. Metrics are registered with registry, or
+// with the global default registerer when registry is nil.
+func New(cfg config.IMAPConfig, p *parser.Parser, logger *zap.Logger, registry *prometheus.Registry) *Client {
+	if reprocessesMessages(cfg) {
+		logger.Warn("Processed messages are neither archived nor deleted and will be processed again on every check; "+
+			"set archive_mailbox, delete_processed, processed_flag or process_unseen_only to avoid it",
+			zap.String("mailbox", cfg.Mailbox),
+		)
+	}
+
+	c := &Client{
+		config:          cfg,
+		parser:          p,
+		logger:          logger,
+		metrics:         metrics.NewIMAPMetrics(registry),
+		subjectPatterns: compileSubjectPatterns(cfg.SubjectPatterns, logger),
+	}
+	c.checkInterval.Store(int64(cfg.CheckInterval))
+	return c
 }
 
-// New creates a new IMAP client
-func New(cfg config.IMAPConfig, p *parser.Parser, logger *zap.Logger) *Client {
-	return &Client{
-		config: cfg,
-		parser: p,
-		logger: logger,
+// CheckInterval returns the time to wait between two mailbox checks
+func (c *Client) CheckInterval() time.Duration {
+	return time.Duration(c.checkInterval.Load()) * time.Second
+}
+
+// SetCheckInterval changes the check interval, in seconds, from the next wait
+// on. It is safe to call while the client is watching the mailbox.
+func (c *Client) SetCheckInterval(seconds int) {
+	c.checkInterval.Store(int64(seconds))
+}
+
+// reprocessesMessages reports whether cfg leaves processed messages in the
+// mailbox with nothing excluding them from the next check
+func reprocessesMessages(cfg config.IMAPConfig) bool {
+	archived := cfg.ArchiveMailbox != "" && cfg.ArchiveMailbox != cfg.Mailbox
+	return !cfg.DeleteProcessed && !archived && cfg.ProcessedFlag == "" && !cfg.ProcessUnseenOnly
+}
+
+// compileSubjectPatterns compiles the subject patterns, matched
+// case-insensitively. Invalid patterns are logged and ignored.
+func compileSubjectPatterns(cfg config.IMAPSubjectPatterns, logger *zap.Logger) []subjectPattern {
+	var patterns []subjectPattern
+	for _, group := range []struct {
+		reportType string
+		patterns   []string
+	}{
+		{parser.ReportTypeAggregate, cfg.Aggregate},
+		{parser.ReportTypeForensic, cfg.Forensic},
+		{parser.ReportTypeSMTPTLS, cfg.SMTPTLS},
+	} {
+		for _, pattern := range group.patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				logger.Warn("Ignoring invalid subject pattern",
+					zap.String("type", group.reportType),
+					zap.String("pattern", pattern),
+					zap.Error(err),
+				)
+				continue
+			}
+			patterns = append(patterns, subjectPattern{reportType: group.reportType, re: re})
+		}
 	}
+	return patterns
 }
 
 // Connect establishes connection to IMAP server
 func (c *Client) Connect() error {
+	if err := c.connect(); err != nil {
+		c.metrics.RecordConnection(false)
+		return err
+	}
+
+	c.metrics.RecordConnection(true)
+	c.connectedAt = time.Now()
+	return nil
+}
+
+// connect dials the server and authenticates
+func (c *Client) connect() error {
 	var err error
 
 	address := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
@@ -69,9 +171,8 @@ func (c *Client) Connect() error {
 		return fmt.Errorf("failed to connect to IMAP server: %w", err)
 	}
 
-	// Login
-	if err := c.client.Login(c.config.Username, c.config.Password); err != nil {
-		return fmt.Errorf("failed to login to IMAP server: %w", err)
+	if err := c.authenticate(); err != nil {
+		return err
 	}
 
 	c.logger.Info("Connected to IMAP server",
@@ -83,8 +184,35 @@ func (c *Client) Connect() error {
 	return nil
 }
 
+// authenticate logs in with the configured authentication method
+func (c *Client) authenticate() error {
+	switch c.config.AuthMethod {
+	case "", AuthMethodPassword:
+		if err := c.client.Login(c.config.Username, c.config.Password); err != nil {
+			return fmt.Errorf("failed to login to IMAP server: %w", err)
+		}
+	case AuthMethodXOAuth2:
+		token, err := c.accessToken()
+		if err != nil {
+			return fmt.Errorf("failed to get OAuth2 access token: %w", err)
+		}
+		if err := c.client.Authenticate(newXOAuth2Client(c.config.Username, token)); err != nil {
+			return fmt.Errorf("failed to authenticate to IMAP server with XOAUTH2: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported IMAP auth method: %s", c.config.AuthMethod)
+	}
+
+	return nil
+}
+
 // Disconnect closes the IMAP connection
 func (c *Client) Disconnect() error {
+	if !c.connectedAt.IsZero() {
+		c.metrics.RecordConnectionDuration(time.Since(c.connectedAt).Seconds())
+		c.connectedAt = time.Time{}
+	}
+
 	if c.client != nil {
 		if err := c.client.Logout(); err != nil {
 			c.logger.Warn("Failed to logout from IMAP server", zap.Error(err))
@@ -96,6 +224,8 @@ func (c *Client) Disconnect() error {
 
 // ProcessMessages processes DMARC reports from mailbox
 func (c *Client) ProcessMessages() error {
+	c.metrics.UpdateLastCheck()
+
 	// Select mailbox
 	status, err := c.client.Select(c.config.Mailbox, false)
 	if err != nil {
@@ -107,15 +237,36 @@ func (c *Client) ProcessMessages() error {
 		return nil
 	}
 
+	if c.config.ProcessUnseenOnly {
+		return c.processUnseenMessages(status)
+	}
+
+	criteria, err := c.messageSearchCriteria(time.Now())
+	if err != nil {
+		return err
+	}
+
+	// Without search_criteria or processed_flag every message is examined
+	seqSet := new(imap.SeqSet)
+	if matchesAll(criteria) {
+		seqSet.AddRange(1, status.Messages)
+	} else {
+		seqNums, err := c.client.Search(criteria)
+		if err != nil {
+			return fmt.Errorf("failed to search messages: %w", err)
+		}
+		if len(seqNums) == 0 {
+			c.logger.Info("No messages match the search criteria", zap.String("mailbox", c.config.Mailbox))
+			return nil
+		}
+		seqSet.AddNum(seqNums...)
+	}
+
 	c.logger.Info("Processing messages",
 		zap.String("mailbox", c.config.Mailbox),
 		zap.Uint32("count", status.Messages),
 	)
 
-	// Search for all messages
-	seqSet := new(imap.SeqSet)
-	seqSet.AddRange(1, status.Messages)
-
 	// Fetch message headers first to identify DMARC reports
 	messages := make(chan *imap.Message, 10)
 	done := make(chan error, 1)
@@ -128,14 +279,15 @@ func (c *Client) ProcessMessages() error {
 		}, messages)
 	}()
 
-	var dmarcMessages []uint32
+	var dmarcMessages []reportMessage
 
 	for msg := range messages {
-		if c.isDMARCReport(msg) {
-			dmarcMessages = append(dmarcMessages, msg.SeqNum)
+		if reportType, ok := c.classifyMessage(msg); ok {
+			dmarcMessages = append(dmarcMessages, reportMessage{uid: msg.Uid, reportType: reportType})
 			c.logger.Debug("Found DMARC report",
-				zap.Uint32("seq", msg.SeqNum),
+				zap.Uint32("uid", msg.Uid),
 				zap.String("subject", msg.Envelope.Subject),
+				zap.String("type_hint", reportType),
 			)
 		}
 	}
@@ -151,10 +303,10 @@ func (c *Client) ProcessMessages() error {
 
 	// Process each DMARC report
 	processed := 0
-	for _, seqNum := range dmarcMessages {
-		if err := c.processMessage(seqNum); err != nil {
+	for _, msg := range dmarcMessages {
+		if err := c.processMessage(msg.uid, msg.reportType); err != nil {
 			c.logger.Error("Failed to process message",
-				zap.Uint32("seq", seqNum),
+				zap.Uint32("uid", msg.uid),
 				zap.Error(err),
 			)
 		} else {
@@ -170,6 +322,175 @@ func (c *Client) ProcessMessages() error {
 	return nil
 }
 
+// messageSearchCriteria returns the search for the messages to examine:
+// those matching search_criteria without the processed_flag keyword
+func (c *Client) messageSearchCriteria(now time.Time) (*imap.SearchCriteria, error) {
+	criteria, err := searchCriteria(c.config.SearchCriteria, now)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.ProcessedFlag != "" {
+		criteria.WithoutFlags = append(criteria.WithoutFlags, c.config.ProcessedFlag)
+	}
+	return criteria, nil
+}
+
+// unseenSearchCriteria returns the search for unseen messages with a UID
+// greater than lastUID. A zero lastUID matches every unseen message.
+func unseenSearchCriteria(lastUID uint32) *imap.SearchCriteria {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+
+	if lastUID > 0 {
+		criteria.Uid = new(imap.SeqSet)
+		criteria.Uid.AddRange(lastUID+1, 0) // lastUID+1:*
+	}
+
+	return criteria
+}
+
+// processUnseenMessages processes the unseen messages that arrived after the
+// last UID examined, and advances the UID cursor. The cursor never moves past
+// a report that failed to process, so it is retried on the next check.
+func (c *Client) processUnseenMessages(status *imap.MailboxStatus) error {
+	if !c.stateLoaded && c.config.StateFile != "" {
+		state, err := loadMailboxState(c.config.StateFile)
+		if err != nil {
+			return err
+		}
+		c.state = state
+	}
+	c.stateLoaded = true
+
+	if c.state.Mailbox != c.config.Mailbox || c.state.UIDValidity != status.UidValidity {
+		if c.state.LastUID > 0 {
+			c.logger.Info("Mailbox UIDVALIDITY changed, rescanning unseen messages",
+				zap.String("mailbox", c.config.Mailbox),
+			)
+		}
+		c.state = mailboxState{Mailbox: c.config.Mailbox, UIDValidity: status.UidValidity}
+	}
+
+	criteria, err := c.messageSearchCriteria(time.Now())
+	if err != nil {
+		return err
+	}
+	unseen := unseenSearchCriteria(c.state.LastUID)
+	criteria.WithoutFlags = append(criteria.WithoutFlags, unseen.WithoutFlags...)
+	criteria.Uid = unseen.Uid
+
+	uids, err := c.client.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search unseen messages: %w", err)
+	}
+
+	// lastUID+1:* always matches the newest message, even when it is older
+	uidSet := new(imap.SeqSet)
+	for _, uid := range uids {
+		if uid > c.state.LastUID {
+			uidSet.AddNum(uid)
+		}
+	}
+
+	if uidSet.Empty() {
+		c.logger.Info("No new messages in mailbox", zap.String("mailbox", c.config.Mailbox))
+		return nil
+	}
+
+	c.logger.Info("Processing unseen messages",
+		zap.String("mailbox", c.config.Mailbox),
+		zap.Uint32("after_uid", c.state.LastUID),
+	)
+
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.client.UidFetch(uidSet, []imap.FetchItem{
+			imap.FetchEnvelope,
+			imap.FetchBodyStructure,
+			imap.FetchUid,
+		}, messages)
+	}()
+
+	var fetched []*imap.Message
+	for msg := range messages {
+		fetched = append(fetched, msg)
+	}
+
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	sort.Slice(fetched, func(i, j int) bool { return fetched[i].Uid < fetched[j].Uid })
+
+	lastUID := c.state.LastUID
+	advance := true
+	total, processed := 0, 0
+	for _, msg := range fetched {
+		if reportType, ok := c.classifyMessage(msg); ok {
+			total++
+			if err := c.processMessage(msg.Uid, reportType); err != nil {
+				c.logger.Error("Failed to process message",
+					zap.Uint32("uid", msg.Uid),
+					zap.Error(err),
+				)
+				advance = false
+			} else {
+				processed++
+			}
+		}
+
+		if advance {
+			lastUID = msg.Uid
+		}
+	}
+
+	c.logger.Info("Processed DMARC reports",
+		zap.Int("processed", processed),
+		zap.Int("total", total),
+	)
+
+	if lastUID != c.state.LastUID {
+		c.state.LastUID = lastUID
+		if c.config.StateFile != "" {
+			if err := saveMailboxState(c.config.StateFile, c.state); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// reportMessage is a message selected for processing
+type reportMessage struct {
+	uid        uint32
+	reportType string
+}
+
+// classifyMessage reports whether msg should be processed and, when a
+// subject pattern matches, the report type to parse first. Once subject
+// patterns are configured they replace the built-in heuristics: messages
+// matching none of them are skipped without downloading their body.
+func (c *Client) classifyMessage(msg *imap.Message) (reportType string, ok bool) {
+	if len(c.subjectPatterns) == 0 {
+		return "", c.isDMARCReport(msg)
+	}
+
+	if msg.Envelope == nil {
+		return "", false
+	}
+
+	for _, pattern := range c.subjectPatterns {
+		if pattern.re.MatchString(msg.Envelope.Subject) {
+			return pattern.reportType, true
+		}
+	}
+
+	return "", false
+}
+
 // isDMARCReport checks if message is a DMARC report based on subject and structure
 func (c *Client) isDMARCReport(msg *imap.Message) bool {
 	if msg.Envelope == nil {
@@ -231,17 +552,23 @@ func (c *Client) hasReportAttachment(bs *imap.BodyStructure) bool {
 	return false
 }
 
-// processMessage fetches and processes a single message
-func (c *Client) processMessage(seqNum uint32) error {
+// processMessage fetches and processes a single message, parsing its
+// reports as reportType first if set
+func (c *Client) processMessage(uid uint32, reportType string) (err error) {
+	defer func() { c.metrics.RecordMessageProcessed("process", err == nil) }()
+
 	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(seqNum)
+	seqSet.AddNum(uid)
 
 	messages := make(chan *imap.Message, 1)
 	done := make(chan error, 1)
 
+	// Peek at the body so that the server does not mark the message seen
+	// before its reports are stored
+	section := &imap.BodySectionName{Peek: true}
 	go func() {
-		done <- c.client.Fetch(seqSet, []imap.FetchItem{
-			imap.FetchRFC822,
+		done <- c.client.UidFetch(seqSet, []imap.FetchItem{
+			section.FetchItem(),
 			imap.FetchUid,
 		}, messages)
 	}()
@@ -256,7 +583,7 @@ func (c *Client) processMessage(seqNum uint32) error {
 	}
 
 	// Parse the email
-	reader := msg.GetBody(&imap.BodySectionName{})
+	reader := msg.GetBody(section)
 	if reader == nil {
 		return fmt.Errorf("failed to get message body")
 	}
@@ -266,36 +593,105 @@ func (c *Client) processMessage(seqNum uint32) error {
 		return fmt.Errorf("failed to create mail reader: %w", err)
 	}
 
-	// Process email parts
+	processed, err := c.processEmailParts(mailReader, reportType, 0)
+	if err != nil {
+		return err
+	}
+	// Rows buffered by the storage must be inserted before the message is
+	// marked seen
+	if err := c.parser.FlushStorage(); err != nil {
+		return err
+	}
+
+	if c.config.ProcessUnseenOnly {
+		flags := []interface{}{imap.SeenFlag}
+		if err := c.client.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+			c.logger.Warn("Failed to mark message as seen", zap.Error(err))
+		}
+	}
+
+	if processed {
+		if c.config.ProcessedFlag != "" {
+			flags := []interface{}{c.config.ProcessedFlag}
+			if err := c.client.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+				c.logger.Warn("Failed to flag processed message",
+					zap.String("flag", c.config.ProcessedFlag),
+					zap.Error(err),
+				)
+			}
+		}
+
+		// Move message to archive or delete if configured
+		if err := c.archiveMessage(uid); err != nil {
+			c.logger.Warn("Failed to archive message", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// processEmailParts processes every part of an email, depth being the number
+// of enclosing emails. It reports whether any part was processed. Parts that
+// fail to parse are skipped, but a report that could not be stored fails the
+// whole email, so that it is not marked processed and gets retried.
+func (c *Client) processEmailParts(mailReader *mail.Reader, reportType string, depth int) (bool, error) {
+	// The message/rfc822 part of a multipart/report is the sample of a
+	// feedback report, not a forwarded report email
+	mediaType, _, _ := mailReader.Header.ContentType()
+	skipEmbedded := mediaType == "multipart/report"
+
 	processed := false
+	var storageErr error
 	for {
 		part, err := mailReader.NextPart()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read email part: %w", err)
+			return processed, fmt.Errorf("failed to read email part: %w", err)
 		}
 
-		if err := c.processEmailPart(part); err != nil {
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType == "message/rfc822" {
+			if skipEmbedded || depth >= parser.MaxEmbeddedMessageDepth {
+				continue
+			}
+			ok, err := c.processEmbeddedMessage(part, reportType, depth+1)
+			if errors.Is(err, parser.ErrStorage) {
+				storageErr = errors.Join(storageErr, err)
+			} else if err != nil {
+				c.logger.Warn("Failed to process attached email", zap.Error(err))
+			}
+			processed = processed || ok
+			continue
+		}
+
+		if err := c.processEmailPart(part, reportType); errors.Is(err, parser.ErrStorage) {
+			storageErr = errors.Join(storageErr, err)
+		} else if err != nil {
 			c.logger.Warn("Failed to process email part", zap.Error(err))
 		} else {
 			processed = true
 		}
 	}
 
-	if processed {
-		// Move message to archive or delete if configured
-		if err := c.archiveMessage(seqNum, msg.Uid); err != nil {
-			c.logger.Warn("Failed to archive message", zap.Error(err))
-		}
+	return processed, storageErr
+}
+
+// processEmbeddedMessage processes the parts of an email attached as a
+// message/rfc822 part, as done by some forwarders
+func (c *Client) processEmbeddedMessage(part *mail.Part, reportType string, depth int) (bool, error) {
+	mailReader, err := mail.CreateReader(part.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read attached email: %w", err)
 	}
 
-	return nil
+	c.logger.Debug("Processing attached email", zap.Int("depth", depth))
+	return c.processEmailParts(mailReader, reportType, depth)
 }
 
 // processEmailPart processes an individual email part
-func (c *Client) processEmailPart(part *mail.Part) error {
+func (c *Client) processEmailPart(part *mail.Part, reportType string) error {
 	contentType, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
 	if err != nil {
 		return fmt.Errorf("failed to parse content type: %w", err)
@@ -317,7 +713,7 @@ func (c *Client) processEmailPart(part *mail.Part) error {
 	}
 
 	// Parse the report using our parser
-	return c.parser.ParseData(data)
+	return c.parser.ParseDataFromSource(data, parser.SourceIMAP, reportType)
 }
 
 // isReportPart checks if email part contains a DMARC report
@@ -361,31 +757,37 @@ func (c *Client) isReportFilename(filename string) bool {
 }
 
 // archiveMessage moves message to archive folder or deletes it
-func (c *Client) archiveMessage(seqNum, uid uint32) error {
+func (c *Client) archiveMessage(uid uint32) error {
 	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(seqNum)
+	seqSet.AddNum(uid)
 
 	if c.config.DeleteProcessed {
 		// Mark for deletion
 		flags := []interface{}{imap.DeletedFlag}
-		if err := c.client.Store(seqSet, imap.FormatFlagsOp(imap.AddFlags, false), flags, nil); err != nil {
+		if err := c.client.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, false), flags, nil); err != nil {
+			c.metrics.RecordMessageProcessed("delete", false)
 			return fmt.Errorf("failed to mark message for deletion: %w", err)
 		}
 
 		// Expunge to actually delete
 		if err := c.client.Expunge(nil); err != nil {
+			c.metrics.RecordMessageProcessed("delete", false)
 			return fmt.Errorf("failed to expunge deleted messages: %w", err)
 		}
 
-		c.logger.Debug("Deleted processed message", zap.Uint32("seq", seqNum))
+		c.metrics.RecordMessageProcessed("delete", true)
+		c.logger.Debug("Deleted processed message", zap.Uint32("uid", uid))
 	} else if c.config.ArchiveMailbox != "" && c.config.ArchiveMailbox != c.config.Mailbox {
 		// Move to archive folder
-		if err := c.client.Move(seqSet, c.config.ArchiveMailbox); err != nil {
+		if err := c.client.UidMove(seqSet, c.config.ArchiveMailbox); err != nil {
+			c.metrics.RecordMessageProcessed("archive", false)
 			return fmt.Errorf("failed to move message to archive: %w", err)
 		}
 
+		c.metrics.RecordMessageProcessed("archive", true)
+
 		c.logger.Debug("Archived processed message",
-			zap.Uint32("seq", seqNum),
+			zap.Uint32("uid", uid),
 			zap.String("archive", c.config.ArchiveMailbox),
 		)
 	}
@@ -401,9 +803,132 @@ func (c *Client) Watch() error {
 		}
 
 		c.logger.Debug("Waiting for next check",
-			zap.Int("interval", c.config.CheckInterval),
+			zap.Duration("interval", c.CheckInterval()),
+		)
+
+		time.Sleep(c.CheckInterval())
+	}
+}
+
+// idleReconnectDelay is how long WatchIdle waits before reconnecting after
+// the connection or the IDLE command failed
+var idleReconnectDelay = 30 * time.Second
+
+// WatchIdle processes new DMARC reports as soon as they arrive, until ctx is
+// cancelled. It uses the IDLE extension to wait for the server to announce
+// new messages and reconnects if the connection drops. Servers without IDLE
+// are polled every check_interval instead.
+func (c *Client) WatchIdle(ctx context.Context) error {
+	for {
+		err := c.idleSession(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		c.logger.Error("IMAP IDLE session ended, reconnecting",
+			zap.Duration("delay", idleReconnectDelay),
+			zap.Error(err),
 		)
 
-		time.Sleep(time.Duration(c.config.CheckInterval) * time.Second)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(idleReconnectDelay):
+		}
+	}
+}
+
+// idleSession connects, then alternates between processing the mailbox and
+// idling until new messages arrive. It returns when ctx is cancelled or on
+// the first connection or processing error.
+func (c *Client) idleSession(ctx context.Context) error {
+	if err := c.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+
+	// Mailbox updates must always be drained, otherwise the connection
+	// stalls. The channel is unbuffered so that updates caused by our own
+	// commands, such as EXISTS in reply to SELECT, are received before the
+	// command returns; only updates received while idling announce new mail.
+	sessionDone := make(chan struct{})
+	defer close(sessionDone)
+	defer func() {
+		if err := c.Disconnect(); err != nil {
+			c.logger.Warn("Failed to disconnect IMAP client", zap.Error(err))
+		}
+	}()
+
+	var idling atomic.Bool
+	updates := make(chan client.Update)
+	newMail := make(chan struct{}, 1)
+	c.client.Updates = updates
+	go func() {
+		for {
+			select {
+			case <-sessionDone:
+				return
+			case update := <-updates:
+				if _, ok := update.(*client.MailboxUpdate); ok && idling.Load() {
+					select {
+					case newMail <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	idleSupported, err := c.client.Support("IDLE")
+	if err != nil {
+		return fmt.Errorf("failed to check IDLE capability: %w", err)
+	}
+	if !idleSupported {
+		c.logger.Warn("IMAP server does not support IDLE, polling instead",
+			zap.Duration("interval", c.CheckInterval()),
+		)
+	}
+
+	for {
+		if err := c.ProcessMessages(); err != nil {
+			return err
+		}
+
+		if !idleSupported {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(c.CheckInterval()):
+			}
+			continue
+		}
+
+		c.logger.Debug("Waiting for new messages with IDLE", zap.String("mailbox", c.config.Mailbox))
+
+		stop := make(chan struct{})
+		idleDone := make(chan error, 1)
+		idling.Store(true)
+		go func() {
+			idleDone <- c.client.Idle(stop, nil)
+		}()
+
+		select {
+		case <-ctx.Done():
+			close(stop)
+			<-idleDone
+			return nil
+		case <-newMail:
+			close(stop)
+			err := <-idleDone
+			idling.Store(false)
+			if err != nil {
+				return fmt.Errorf("IDLE failed: %w", err)
+			}
+			c.logger.Debug("New messages announced", zap.String("mailbox", c.config.Mailbox))
+		case err := <-idleDone:
+			if err == nil {
+				err = fmt.Errorf("server ended IDLE")
+			}
+			return fmt.Errorf("IDLE failed: %w", err)
+		}
 	}
 }
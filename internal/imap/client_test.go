@@ -0,0 +1,821 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	imapserver "github.com/emersion/go-imap/server"
+	"github.com/emersion/go-message/mail"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/parser"
+)
+
+// recordingStorage keeps the reports handed to it by the parser, or fails
+// to store them with err when set. Flush fails with flushErr when set.
+type recordingStorage struct {
+	mu               sync.Mutex
+	aggregateReports []*parser.AggregateReport
+	err              error
+	flushErr         error
+}
+
+func (s *recordingStorage) StoreAggregateReport(report *parser.AggregateReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.aggregateReports = append(s.aggregateReports, report)
+	return nil
+}
+
+func (s *recordingStorage) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *recordingStorage) setFlushError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushErr = err
+}
+
+func (s *recordingStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushErr
+}
+
+func (s *recordingStorage) storedAggregateReports() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.aggregateReports)
+}
+
+func (s *recordingStorage) StoreForensicReport(report *parser.ForensicReport) error {
+	return nil
+}
+
+func (s *recordingStorage) StoreSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	return nil
+}
+
+func (s *recordingStorage) Close() error {
+	return nil
+}
+
+func newTestClient(t *testing.T) (*Client, *recordingStorage) {
+	t.Helper()
+
+	logger := zaptest.NewLogger(t)
+	storage := &recordingStorage{}
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, prometheus.NewRegistry())
+	return New(config.IMAPConfig{}, p, logger, prometheus.NewRegistry()), storage
+}
+
+func newTestPart(contentType, body string) *mail.Part {
+	var header mail.AttachmentHeader
+	header.Set("Content-Type", contentType)
+	return &mail.Part{Header: &header, Body: strings.NewReader(body)}
+}
+
+func TestClient_ProcessEmailPart(t *testing.T) {
+	xmlData, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStored  int
+		wantErr     bool
+	}{
+		{
+			name:        "XML attachment",
+			contentType: "application/xml",
+			body:        string(xmlData),
+			wantStored:  1,
+		},
+		{
+			name:        "Octet stream named as XML",
+			contentType: `application/octet-stream; name="example.net!example.com!1529366400!1529452799.xml"`,
+			body:        string(xmlData),
+			wantStored:  1,
+		},
+		{
+			name:        "Octet stream with unrelated name",
+			contentType: `application/octet-stream; name="invoice.pdf"`,
+			body:        string(xmlData),
+			wantStored:  0,
+		},
+		{
+			name:        "Plain text body",
+			contentType: "text/plain; charset=utf-8",
+			body:        "This is a DMARC aggregate report.",
+			wantStored:  0,
+		},
+		{
+			name:        "Malformed content type",
+			contentType: "application/xml; name=",
+			body:        string(xmlData),
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, storage := newTestClient(t)
+
+			err := client.processEmailPart(newTestPart(tt.contentType, tt.body), "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processEmailPart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(storage.aggregateReports) != tt.wantStored {
+				t.Fatalf("Expected %d stored reports, got %d", tt.wantStored, len(storage.aggregateReports))
+			}
+			if tt.wantStored > 0 && storage.aggregateReports[0].ReportMetadata.ReportID != "b043f0e264cf4ea995e93765242f6dfb" {
+				t.Errorf("Unexpected report ID %s", storage.aggregateReports[0].ReportMetadata.ReportID)
+			}
+		})
+	}
+}
+
+func TestClient_StoresIMAPSourceTransport(t *testing.T) {
+	xmlData, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	logger := zaptest.NewLogger(t)
+	storage := &recordingStorage{}
+	p := parser.New(config.ParserConfig{Offline: true, StoreSourceTransport: true}, storage, logger, prometheus.NewRegistry())
+	client := New(config.IMAPConfig{}, p, logger, prometheus.NewRegistry())
+
+	if err := client.processEmailPart(newTestPart("application/xml", string(xmlData)), ""); err != nil {
+		t.Fatalf("processEmailPart() error = %v", err)
+	}
+	if len(storage.aggregateReports) != 1 {
+		t.Fatalf("Expected 1 stored report, got %d", len(storage.aggregateReports))
+	}
+	if got := storage.aggregateReports[0].SourceTransport; got != parser.SourceIMAP {
+		t.Errorf("Expected source transport %q, got %q", parser.SourceIMAP, got)
+	}
+}
+
+func TestClient_ProcessAttachedEmail(t *testing.T) {
+	forwarded, err := os.ReadFile("../../samples/aggregate/forwarded-rfc822.eml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	// The same attached email as the sample of a feedback report
+	_, attached, _ := strings.Cut(string(forwarded), "Content-Disposition: attachment; filename=\"report.eml\"\n\n")
+	attached, _, _ = strings.Cut(attached, "\n--outer-boundary--")
+	feedbackReport := "From: postmaster@example.com\n" +
+		"Subject: Report domain: example.com\n" +
+		"MIME-Version: 1.0\n" +
+		"Content-Type: multipart/report; report-type=feedback-report; boundary=\"report-boundary\"\n" +
+		"\n" +
+		"--report-boundary\n" +
+		"Content-Type: text/plain\n" +
+		"\n" +
+		"This is an authentication failure report.\n" +
+		"--report-boundary\n" +
+		"Content-Type: message/rfc822\n" +
+		"\n" +
+		attached + "\n" +
+		"--report-boundary--\n"
+
+	tests := []struct {
+		name       string
+		message    string
+		wantStored int
+	}{
+		{name: "forwarded report", message: string(forwarded), wantStored: 1},
+		{name: "feedback report sample", message: feedbackReport, wantStored: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, storage := newTestClient(t)
+
+			mailReader, err := mail.CreateReader(strings.NewReader(tt.message))
+			if err != nil {
+				t.Fatalf("Failed to create mail reader: %v", err)
+			}
+			if _, err := client.processEmailParts(mailReader, "", 0); err != nil {
+				t.Fatalf("processEmailParts() error = %v", err)
+			}
+
+			if len(storage.aggregateReports) != tt.wantStored {
+				t.Fatalf("Expected %d stored reports, got %d", tt.wantStored, len(storage.aggregateReports))
+			}
+			if tt.wantStored > 0 && storage.aggregateReports[0].ReportMetadata.ReportID != "102675056" {
+				t.Errorf("Unexpected report ID %s", storage.aggregateReports[0].ReportMetadata.ReportID)
+			}
+		})
+	}
+}
+
+func TestClient_SubjectPatterns(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(core)
+	p := parser.New(config.ParserConfig{Offline: true}, &recordingStorage{}, logger, prometheus.NewRegistry())
+	client := New(config.IMAPConfig{
+		SubjectPatterns: config.IMAPSubjectPatterns{
+			Aggregate: []string{"^report domain:"},
+			Forensic:  []string{"("}, // invalid, ignored
+			SMTPTLS:   []string{`tls ?rpt`, "^tls report"},
+		},
+	}, p, logger, prometheus.NewRegistry())
+
+	if len(client.subjectPatterns) != 3 {
+		t.Fatalf("Expected 3 valid subject patterns, got %d", len(client.subjectPatterns))
+	}
+	if logs.FilterMessage("Ignoring invalid subject pattern").Len() != 1 {
+		t.Error("Expected the invalid pattern to be reported")
+	}
+
+	tests := []struct {
+		subject    string
+		wantType   string
+		wantReport bool
+	}{
+		{"Report Domain: example.com Submitter: google.com", parser.ReportTypeAggregate, true},
+		{"TLSRPT for example.com", parser.ReportTypeSMTPTLS, true},
+		{"TLS Report Domain: example.com", parser.ReportTypeSMTPTLS, true},
+		{"Your monthly DMARC newsletter", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			msg := &goimap.Message{Envelope: &goimap.Envelope{Subject: tt.subject}}
+			reportType, ok := client.classifyMessage(msg)
+			if reportType != tt.wantType || ok != tt.wantReport {
+				t.Errorf("classifyMessage() = (%q, %v), want (%q, %v)", reportType, ok, tt.wantType, tt.wantReport)
+			}
+		})
+	}
+
+	// A message matching the TLSRPT pattern is parsed as SMTP TLS first
+	data, err := os.ReadFile("../../samples/smtp_tls/rfc8460.json")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	reportType, _ := client.classifyMessage(&goimap.Message{Envelope: &goimap.Envelope{Subject: "TLSRPT for example.com"}})
+	if err := client.processEmailPart(newTestPart("application/tlsrpt+json", string(data)), reportType); err != nil {
+		t.Fatalf("processEmailPart() error = %v", err)
+	}
+
+	attempts := logs.FilterMessage("Trying report type").All()
+	if len(attempts) != 1 {
+		t.Fatalf("Expected a single parse attempt, got %d", len(attempts))
+	}
+	if got := attempts[0].ContextMap()["type"]; got != parser.ReportTypeSMTPTLS {
+		t.Errorf("First parse attempt = %v, want %s", got, parser.ReportTypeSMTPTLS)
+	}
+}
+
+// updatingBackend is an in-memory IMAP backend that can announce new
+// messages to idling clients
+type updatingBackend struct {
+	*memory.Backend
+	updates chan backend.Update
+}
+
+func (b *updatingBackend) Updates() <-chan backend.Update {
+	return b.updates
+}
+
+// seenOnFetchBackend is an in-memory IMAP backend that marks messages seen
+// when their body is fetched without peeking, as RFC 3501 servers do
+type seenOnFetchBackend struct {
+	*memory.Backend
+}
+
+func (b *seenOnFetchBackend) Login(connInfo *goimap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(connInfo, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &seenOnFetchUser{User: user}, nil
+}
+
+type seenOnFetchUser struct {
+	backend.User
+}
+
+func (u *seenOnFetchUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mailbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return &seenOnFetchMailbox{Mailbox: mailbox}, nil
+}
+
+type seenOnFetchMailbox struct {
+	backend.Mailbox
+}
+
+func (m *seenOnFetchMailbox) ListMessages(uid bool, seqSet *goimap.SeqSet, items []goimap.FetchItem, ch chan<- *goimap.Message) error {
+	for _, item := range items {
+		section, err := goimap.ParseBodySectionName(item)
+		if err != nil || section.Peek {
+			continue
+		}
+		if err := m.UpdateMessagesFlags(uid, seqSet, goimap.AddFlags, []string{goimap.SeenFlag}); err != nil {
+			return err
+		}
+		break
+	}
+	return m.Mailbox.ListMessages(uid, seqSet, items, ch)
+}
+
+// startTestIMAPServer serves be on a local port until the test ends and
+// returns the port
+func startTestIMAPServer(t *testing.T, be backend.Backend) int {
+	t.Helper()
+
+	server := imapserver.New(be)
+	server.AllowInsecureAuth = true
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start IMAP server: %v", err)
+	}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// addTestReport delivers an aggregate report email to the INBOX of the
+// memory backend user
+func addTestReport(t *testing.T, be backend.Backend, messageID string) backend.Mailbox {
+	t.Helper()
+
+	xmlData, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	message := "From: noreply-dmarc-support@example.net\r\n" +
+		"To: dmarc@example.com\r\n" +
+		"Subject: Report domain: example.com Submitter: example.net\r\n" +
+		"Date: Tue, 19 Jun 2018 00:00:00 +0000\r\n" +
+		"Message-ID: <" + messageID + ">\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: application/xml\r\n" +
+		"\r\n" + string(xmlData)
+
+	user, err := be.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatalf("Failed to log in to backend: %v", err)
+	}
+	inbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatalf("Failed to get INBOX: %v", err)
+	}
+	if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(message)); err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	return inbox
+}
+
+func TestClient_WatchIdle(t *testing.T) {
+	be := &updatingBackend{Backend: memory.New(), updates: make(chan backend.Update)}
+	port := startTestIMAPServer(t, be)
+
+	core, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(core)
+	storage := &recordingStorage{}
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, prometheus.NewRegistry())
+
+	client := New(config.IMAPConfig{
+		Host:          "127.0.0.1",
+		Port:          port,
+		Username:      "username",
+		Password:      "password",
+		Mailbox:       "INBOX",
+		CheckInterval: 3600, // reports must be picked up through IDLE, not polling
+		Idle:          true,
+	}, p, logger, prometheus.NewRegistry())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchDone := make(chan error, 1)
+	go func() { watchDone <- client.WatchIdle(ctx) }()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	idling := func() int { return logs.FilterMessage("Waiting for new messages with IDLE").Len() }
+
+	waitFor("the client to idle", func() bool { return idling() == 1 })
+
+	inbox := addTestReport(t, be, "idle-test@example.net")
+	status, err := inbox.Status([]goimap.StatusItem{goimap.StatusMessages})
+	if err != nil {
+		t.Fatalf("Failed to get INBOX status: %v", err)
+	}
+	be.updates <- &backend.MailboxUpdate{Update: backend.NewUpdate("username", "INBOX"), MailboxStatus: status}
+
+	waitFor("the report to be stored", func() bool { return storage.storedAggregateReports() == 1 })
+	waitFor("the client to idle again", func() bool { return idling() == 2 })
+
+	cancel()
+	select {
+	case err := <-watchDone:
+		if err != nil {
+			t.Errorf("WatchIdle() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchIdle did not return after cancellation")
+	}
+
+	if got := idling(); got != 2 {
+		t.Errorf("Expected 2 IDLE cycles, got %d", got)
+	}
+}
+
+func TestUnseenSearchCriteria(t *testing.T) {
+	tests := []struct {
+		name    string
+		lastUID uint32
+		wantUID string
+	}{
+		{name: "no cursor", lastUID: 0, wantUID: ""},
+		{name: "after cursor", lastUID: 42, wantUID: "43:*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			criteria := unseenSearchCriteria(tt.lastUID)
+
+			if len(criteria.WithoutFlags) != 1 || criteria.WithoutFlags[0] != goimap.SeenFlag {
+				t.Errorf("WithoutFlags = %v, want [%s]", criteria.WithoutFlags, goimap.SeenFlag)
+			}
+			if len(criteria.WithFlags) != 0 {
+				t.Errorf("WithFlags = %v, want none", criteria.WithFlags)
+			}
+
+			gotUID := ""
+			if criteria.Uid != nil {
+				gotUID = criteria.Uid.String()
+			}
+			if gotUID != tt.wantUID {
+				t.Errorf("Uid = %q, want %q", gotUID, tt.wantUID)
+			}
+		})
+	}
+}
+
+func TestMailboxState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "imap-state.json")
+
+	state, err := loadMailboxState(path)
+	if err != nil {
+		t.Fatalf("loadMailboxState() on missing file error = %v", err)
+	}
+	if state != (mailboxState{}) {
+		t.Errorf("loadMailboxState() on missing file = %+v, want empty state", state)
+	}
+
+	want := mailboxState{Mailbox: "INBOX", UIDValidity: 1, LastUID: 42}
+	if err := saveMailboxState(path, want); err != nil {
+		t.Fatalf("saveMailboxState() error = %v", err)
+	}
+	state, err = loadMailboxState(path)
+	if err != nil {
+		t.Fatalf("loadMailboxState() error = %v", err)
+	}
+	if state != want {
+		t.Errorf("loadMailboxState() = %+v, want %+v", state, want)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt state file: %v", err)
+	}
+	if _, err := loadMailboxState(path); err == nil {
+		t.Error("loadMailboxState() on corrupt file expected an error")
+	}
+}
+
+func TestClient_ProcessUnseenOnly(t *testing.T) {
+	be := memory.New()
+	port := startTestIMAPServer(t, be)
+	inbox := addTestReport(t, be, "unseen-1@example.net")
+
+	statePath := filepath.Join(t.TempDir(), "imap-state.json")
+	logger := zaptest.NewLogger(t)
+	storage := &recordingStorage{}
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, prometheus.NewRegistry())
+	cfg := config.IMAPConfig{
+		Host:              "127.0.0.1",
+		Port:              port,
+		Username:          "username",
+		Password:          "password",
+		Mailbox:           "INBOX",
+		ProcessUnseenOnly: true,
+		StateFile:         statePath,
+	}
+
+	processMessages := func() {
+		t.Helper()
+		client := New(cfg, p, logger, prometheus.NewRegistry())
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer client.Disconnect()
+		if err := client.ProcessMessages(); err != nil {
+			t.Fatalf("ProcessMessages() error = %v", err)
+		}
+	}
+
+	processMessages()
+	if got := storage.storedAggregateReports(); got != 1 {
+		t.Fatalf("Expected 1 stored report, got %d", got)
+	}
+
+	unseen, err := inbox.SearchMessages(true, unseenSearchCriteria(0))
+	if err != nil {
+		t.Fatalf("Failed to search INBOX: %v", err)
+	}
+	if len(unseen) != 0 {
+		t.Errorf("Expected processed messages to be marked seen, unseen UIDs: %v", unseen)
+	}
+
+	state, err := loadMailboxState(statePath)
+	if err != nil {
+		t.Fatalf("loadMailboxState() error = %v", err)
+	}
+	if state.Mailbox != "INBOX" || state.LastUID != 7 {
+		t.Errorf("Persisted state = %+v, want INBOX with last UID 7", state)
+	}
+
+	// A restarted client must not reprocess the message, even if it was
+	// marked unseen again in the meantime
+	seqSet := new(goimap.SeqSet)
+	seqSet.AddNum(7)
+	if err := inbox.UpdateMessagesFlags(true, seqSet, goimap.RemoveFlags, []string{goimap.SeenFlag}); err != nil {
+		t.Fatalf("Failed to clear seen flag: %v", err)
+	}
+	processMessages()
+	if got := storage.storedAggregateReports(); got != 1 {
+		t.Errorf("Expected the report not to be reprocessed, got %d stored reports", got)
+	}
+
+	addTestReport(t, be, "unseen-2@example.net")
+	processMessages()
+	if got := storage.storedAggregateReports(); got != 2 {
+		t.Errorf("Expected the new report to be processed, got %d stored reports", got)
+	}
+}
+
+func TestClient_ProcessUnseenOnlyStorageFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       func(s *recordingStorage, err error)
+		wantStored int // reports handed to storage by both attempts
+	}{
+		{"store", (*recordingStorage).setError, 1},
+		{"flush", (*recordingStorage).setFlushError, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testProcessUnseenOnlyStorageFailure(t, tt.fail, tt.wantStored)
+		})
+	}
+}
+
+// testProcessUnseenOnlyStorageFailure checks that a message whose report
+// fails to be stored, with fail making storage fail, is retried
+func testProcessUnseenOnlyStorageFailure(t *testing.T, fail func(s *recordingStorage, err error), wantStored int) {
+	be := &seenOnFetchBackend{Backend: memory.New()}
+	port := startTestIMAPServer(t, be)
+	inbox := addTestReport(t, be, "unseen-1@example.net")
+
+	statePath := filepath.Join(t.TempDir(), "imap-state.json")
+	logger := zaptest.NewLogger(t)
+	storage := &recordingStorage{}
+	fail(storage, errors.New("connection refused"))
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, prometheus.NewRegistry())
+	cfg := config.IMAPConfig{
+		Host:              "127.0.0.1",
+		Port:              port,
+		Username:          "username",
+		Password:          "password",
+		Mailbox:           "INBOX",
+		ProcessUnseenOnly: true,
+		StateFile:         statePath,
+	}
+
+	client := New(cfg, p, logger, prometheus.NewRegistry())
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer client.Disconnect()
+
+	if err := client.ProcessMessages(); err != nil {
+		t.Fatalf("ProcessMessages() error = %v", err)
+	}
+
+	unseen, err := inbox.SearchMessages(true, unseenSearchCriteria(0))
+	if err != nil {
+		t.Fatalf("Failed to search INBOX: %v", err)
+	}
+	if len(unseen) != 1 || unseen[0] != 7 {
+		t.Errorf("Expected the message that failed to store to stay unseen, unseen UIDs: %v", unseen)
+	}
+	if client.state.LastUID != 0 {
+		t.Errorf("Expected the UID cursor to stay put, got last UID %d", client.state.LastUID)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("Expected no persisted state, got %v", err)
+	}
+
+	// The report is stored once storage recovers
+	fail(storage, nil)
+	if err := client.ProcessMessages(); err != nil {
+		t.Fatalf("ProcessMessages() error = %v", err)
+	}
+	if got := storage.storedAggregateReports(); got != wantStored {
+		t.Errorf("Expected the report to be retried, got %d stored reports", got)
+	}
+	if client.state.LastUID != 7 {
+		t.Errorf("Expected the UID cursor to advance, got last UID %d", client.state.LastUID)
+	}
+}
+
+func TestClient_ProcessedFlag(t *testing.T) {
+	be := memory.New()
+	port := startTestIMAPServer(t, be)
+	inbox := addTestReport(t, be, "flagged-1@example.net")
+
+	logger := zaptest.NewLogger(t)
+	storage := &recordingStorage{}
+	p := parser.New(config.ParserConfig{Offline: true}, storage, logger, prometheus.NewRegistry())
+	cfg := config.IMAPConfig{
+		Host:          "127.0.0.1",
+		Port:          port,
+		Username:      "username",
+		Password:      "password",
+		Mailbox:       "INBOX",
+		ProcessedFlag: "$Parsed",
+	}
+
+	processMessages := func() {
+		t.Helper()
+		client := New(cfg, p, logger, prometheus.NewRegistry())
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer client.Disconnect()
+		if err := client.ProcessMessages(); err != nil {
+			t.Fatalf("ProcessMessages() error = %v", err)
+		}
+	}
+
+	processMessages()
+	if got := storage.storedAggregateReports(); got != 1 {
+		t.Fatalf("Expected 1 stored report, got %d", got)
+	}
+
+	// The server stores keywords, which are case-insensitive, in lowercase
+	flagged := goimap.NewSearchCriteria()
+	flagged.WithFlags = []string{goimap.CanonicalFlag("$Parsed")}
+	uids, err := inbox.SearchMessages(true, flagged)
+	if err != nil {
+		t.Fatalf("Failed to search INBOX: %v", err)
+	}
+	if len(uids) != 1 || uids[0] != 7 {
+		t.Errorf("Expected the processed message to be flagged, flagged UIDs: %v", uids)
+	}
+
+	// The flagged message is excluded from the next search, the new one is not
+	addTestReport(t, be, "flagged-2@example.net")
+	processMessages()
+	if got := storage.storedAggregateReports(); got != 2 {
+		t.Errorf("Expected only the new report to be processed, got %d stored reports", got)
+	}
+	processMessages()
+	if got := storage.storedAggregateReports(); got != 2 {
+		t.Errorf("Expected no report to be reprocessed, got %d stored reports", got)
+	}
+}
+
+func TestClient_Metrics(t *testing.T) {
+	be := memory.New()
+	port := startTestIMAPServer(t, be)
+	addTestReport(t, be, "metrics-1@example.net")
+
+	logger := zaptest.NewLogger(t)
+	registry := prometheus.NewRegistry()
+	p := parser.New(config.ParserConfig{Offline: true}, &recordingStorage{}, logger, prometheus.NewRegistry())
+	client := New(config.IMAPConfig{
+		Host:          "127.0.0.1",
+		Port:          port,
+		Username:      "username",
+		Password:      "password",
+		Mailbox:       "INBOX",
+		ProcessedFlag: "$Parsed",
+	}, p, logger, registry)
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := client.ProcessMessages(); err != nil {
+		t.Fatalf("ProcessMessages() error = %v", err)
+	}
+	client.Disconnect()
+
+	if got := testutil.ToFloat64(client.metrics.ConnectionAttemptsTotal.WithLabelValues("success")); got != 1 {
+		t.Errorf("Expected 1 successful connection, got %v", got)
+	}
+	if got := testutil.ToFloat64(client.metrics.MessagesProcessedTotal.WithLabelValues("process", "success")); got != 1 {
+		t.Errorf("Expected 1 processed message, got %v", got)
+	}
+	if got := testutil.ToFloat64(client.metrics.LastCheckTimestamp); got == 0 {
+		t.Error("Expected the last check timestamp to be set")
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	var sessions uint64
+	for _, family := range families {
+		if family.GetName() == "parsedmarc_imap_connection_duration_seconds" {
+			sessions = family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	if sessions != 1 {
+		t.Errorf("Expected 1 connection duration observation, got %d", sessions)
+	}
+
+	// Nothing listens on a port once its listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	client.config.Port = listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	if err := client.Connect(); err == nil {
+		t.Fatal("Connect() to a closed port expected an error")
+	}
+	if got := testutil.ToFloat64(client.metrics.ConnectionAttemptsTotal.WithLabelValues("failure")); got != 1 {
+		t.Errorf("Expected 1 failed connection, got %v", got)
+	}
+}
+
+func TestNew_WarnsAboutReprocessing(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.IMAPConfig
+		wantWarn bool
+	}{
+		{name: "nothing configured", cfg: config.IMAPConfig{Mailbox: "INBOX"}, wantWarn: true},
+		{name: "archive is the mailbox", cfg: config.IMAPConfig{Mailbox: "INBOX", ArchiveMailbox: "INBOX"}, wantWarn: true},
+		{name: "archive", cfg: config.IMAPConfig{Mailbox: "INBOX", ArchiveMailbox: "DMARC-Archive"}},
+		{name: "delete", cfg: config.IMAPConfig{Mailbox: "INBOX", DeleteProcessed: true}},
+		{name: "processed flag", cfg: config.IMAPConfig{Mailbox: "INBOX", ProcessedFlag: "$Parsed"}},
+		{name: "unseen only", cfg: config.IMAPConfig{Mailbox: "INBOX", ProcessUnseenOnly: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			New(tt.cfg, nil, zap.New(core), prometheus.NewRegistry())
+
+			if got := logs.Len() > 0; got != tt.wantWarn {
+				t.Errorf("Expected warning %v, got %v", tt.wantWarn, logs.All())
+			}
+		})
+	}
+}
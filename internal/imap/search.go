@@ -0,0 +1,129 @@
+package imap
+
+import (
+	"fmt"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"parsedmarc-go/internal/config"
+)
+
+// ValidateSearchCriteria reports whether search_criteria can be translated
+// into an IMAP search
+func ValidateSearchCriteria(cfg config.IMAPSearchCriteria) error {
+	_, err := searchCriteria(cfg, time.Now())
+	return err
+}
+
+// ValidateProcessedFlag reports whether processed_flag, if set, is a keyword
+// that can be stored on messages
+func ValidateProcessedFlag(flag string) error {
+	if flag != "" && !isKeyword(flag) {
+		return fmt.Errorf("invalid processed_flag %q: not an IMAP keyword", flag)
+	}
+	return nil
+}
+
+// searchCriteria translates search_criteria into an IMAP search, resolving
+// relative dates against now. Empty criteria match every message.
+func searchCriteria(cfg config.IMAPSearchCriteria, now time.Time) (*imap.SearchCriteria, error) {
+	criteria := imap.NewSearchCriteria()
+
+	var err error
+	if cfg.Since != "" {
+		if criteria.Since, err = parseSearchDate(cfg.Since, now); err != nil {
+			return nil, fmt.Errorf("invalid search_criteria.since: %w", err)
+		}
+	}
+	if cfg.Before != "" {
+		if criteria.Before, err = parseSearchDate(cfg.Before, now); err != nil {
+			return nil, fmt.Errorf("invalid search_criteria.before: %w", err)
+		}
+	}
+	if !criteria.Since.IsZero() && !criteria.Before.IsZero() && !criteria.Before.After(criteria.Since) {
+		return nil, fmt.Errorf("invalid search_criteria: before %s is not after since %s", cfg.Before, cfg.Since)
+	}
+
+	if cfg.From != "" {
+		criteria.Header.Add("From", cfg.From)
+	}
+	if cfg.To != "" {
+		criteria.Header.Add("To", cfg.To)
+	}
+	if cfg.Subject != "" {
+		criteria.Header.Add("Subject", cfg.Subject)
+	}
+	for name, value := range cfg.Headers {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+			return nil, fmt.Errorf("invalid search_criteria header name %q", name)
+		}
+		criteria.Header.Add(textproto.CanonicalMIMEHeaderKey(name), value)
+	}
+
+	if criteria.WithFlags, err = searchFlags(cfg.Flags); err != nil {
+		return nil, fmt.Errorf("invalid search_criteria.flags: %w", err)
+	}
+	if criteria.WithoutFlags, err = searchFlags(cfg.WithoutFlags); err != nil {
+		return nil, fmt.Errorf("invalid search_criteria.without_flags: %w", err)
+	}
+
+	return criteria, nil
+}
+
+// matchesAll reports whether criteria, as returned by searchCriteria, match
+// every message
+func matchesAll(criteria *imap.SearchCriteria) bool {
+	return reflect.DeepEqual(criteria, imap.NewSearchCriteria())
+}
+
+// parseSearchDate parses a date in YYYY-MM-DD form, or a duration such as
+// 720h meaning that long before now. IMAP searches by day, ignoring the time.
+func parseSearchDate(value string, now time.Time) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a YYYY-MM-DD date nor a duration", value)
+	}
+	if d < 0 {
+		return time.Time{}, fmt.Errorf("negative duration %q", value)
+	}
+	return now.Add(-d), nil
+}
+
+// searchFlags validates flags: system flags such as \Seen, or keywords, which
+// are IMAP atoms
+func searchFlags(flags []string) ([]string, error) {
+	var result []string
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, `\`) {
+			canonical := imap.CanonicalFlag(flag)
+			switch canonical {
+			case imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag, imap.RecentFlag:
+				result = append(result, canonical)
+				continue
+			}
+			return nil, fmt.Errorf("unknown system flag %q", flag)
+		}
+		if !isKeyword(flag) {
+			return nil, fmt.Errorf("invalid keyword %q", flag)
+		}
+		result = append(result, flag)
+	}
+	return result, nil
+}
+
+// isKeyword reports whether flag is a keyword, an IMAP atom
+func isKeyword(flag string) bool {
+	return flag != "" && !strings.ContainsAny(flag, ` (){%*"]\`) && strings.IndexFunc(flag, isControl) < 0
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r >= 0x7f
+}
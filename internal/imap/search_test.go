@@ -0,0 +1,184 @@
+package imap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/commands"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zaptest"
+	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/parser"
+)
+
+// searchCommand returns the SEARCH command sent for criteria
+func searchCommand(t *testing.T, criteria *goimap.SearchCriteria) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	cmd := (&commands.Search{Criteria: criteria}).Command()
+	cmd.Tag = "a1"
+	if err := cmd.WriteTo(goimap.NewWriter(&buf)); err != nil {
+		t.Fatalf("Failed to write command: %v", err)
+	}
+	return buf.String()
+}
+
+func TestSearchCriteria(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		cfg     config.IMAPSearchCriteria
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			want: "a1 SEARCH ALL\r\n",
+		},
+		{
+			name: "date range",
+			cfg:  config.IMAPSearchCriteria{Since: "2024-01-01", Before: "2024-02-01"},
+			want: "a1 SEARCH SINCE \"1-Jan-2024\" BEFORE \"1-Feb-2024\"\r\n",
+		},
+		{
+			name: "relative since",
+			cfg:  config.IMAPSearchCriteria{Since: "720h"},
+			want: "a1 SEARCH SINCE \"1-Mar-2024\"\r\n",
+		},
+		{
+			name: "from",
+			cfg:  config.IMAPSearchCriteria{From: "dmarc-noreply"},
+			want: "a1 SEARCH FROM \"dmarc-noreply\"\r\n",
+		},
+		{
+			name: "header",
+			cfg:  config.IMAPSearchCriteria{Headers: map[string]string{"x-report-type": "dmarc"}},
+			want: "a1 SEARCH HEADER \"X-Report-Type\" \"dmarc\"\r\n",
+		},
+		{
+			name: "flags",
+			cfg:  config.IMAPSearchCriteria{Flags: []string{`\flagged`}, WithoutFlags: []string{"$Processed"}},
+			want: "a1 SEARCH FLAGGED UNKEYWORD $Processed\r\n",
+		},
+		{
+			name:    "invalid date",
+			cfg:     config.IMAPSearchCriteria{Since: "last week"},
+			wantErr: true,
+		},
+		{
+			name:    "negative duration",
+			cfg:     config.IMAPSearchCriteria{Before: "-24h"},
+			wantErr: true,
+		},
+		{
+			name:    "empty range",
+			cfg:     config.IMAPSearchCriteria{Since: "2024-02-01", Before: "2024-01-01"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid header name",
+			cfg:     config.IMAPSearchCriteria{Headers: map[string]string{"X Report": "dmarc"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown system flag",
+			cfg:     config.IMAPSearchCriteria{Flags: []string{`\Processed`}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid keyword",
+			cfg:     config.IMAPSearchCriteria{WithoutFlags: []string{"two words"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			criteria, err := searchCriteria(tt.cfg, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("searchCriteria() expected an error, got %s", searchCommand(t, criteria))
+				}
+				if ValidateSearchCriteria(tt.cfg) == nil {
+					t.Error("ValidateSearchCriteria() expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("searchCriteria() error = %v", err)
+			}
+			if got := searchCommand(t, criteria); got != tt.want {
+				t.Errorf("SEARCH command = %q, want %q", got, tt.want)
+			}
+			if got, want := matchesAll(criteria), tt.name == "empty"; got != want {
+				t.Errorf("matchesAll() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_ProcessMessagesSearchCriteria(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria config.IMAPSearchCriteria
+		unseen   bool
+		want     int
+	}{
+		{name: "matching", criteria: config.IMAPSearchCriteria{Subject: "Report domain"}, want: 1},
+		{name: "not matching", criteria: config.IMAPSearchCriteria{From: "nobody@example.org"}, want: 0},
+		{name: "unseen matching", criteria: config.IMAPSearchCriteria{Subject: "Report domain"}, unseen: true, want: 1},
+		{name: "unseen not matching", criteria: config.IMAPSearchCriteria{From: "nobody@example.org"}, unseen: true, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			be := memory.New()
+			port := startTestIMAPServer(t, be)
+			addTestReport(t, be, "search@example.net")
+
+			logger := zaptest.NewLogger(t)
+			storage := &recordingStorage{}
+			p := parser.New(config.ParserConfig{Offline: true}, storage, logger, prometheus.NewRegistry())
+			client := New(config.IMAPConfig{
+				Host:              "127.0.0.1",
+				Port:              port,
+				Username:          "username",
+				Password:          "password",
+				Mailbox:           "INBOX",
+				ProcessUnseenOnly: tt.unseen,
+				SearchCriteria:    tt.criteria,
+			}, p, logger, prometheus.NewRegistry())
+			if err := client.Connect(); err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+			defer client.Disconnect()
+
+			if err := client.ProcessMessages(); err != nil {
+				t.Fatalf("ProcessMessages() error = %v", err)
+			}
+			if got := storage.storedAggregateReports(); got != tt.want {
+				t.Errorf("Expected %d stored reports, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateProcessedFlag(t *testing.T) {
+	for flag, valid := range map[string]bool{
+		"":          true,
+		"$Parsed":   true,
+		"parsed":    true,
+		`\Seen`:     false,
+		"two words": false,
+		"(parsed)":  false,
+	} {
+		if err := ValidateProcessedFlag(flag); (err == nil) != valid {
+			t.Errorf("ValidateProcessedFlag(%q) error = %v, want valid %v", flag, err, valid)
+		}
+	}
+}
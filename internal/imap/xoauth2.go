@@ -0,0 +1,114 @@
+package imap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+)
+
+// Authentication methods supported by the IMAP client
+const (
+	AuthMethodPassword = "password"
+	AuthMethodXOAuth2  = "xoauth2"
+)
+
+// tokenExpiryMargin is how long before its expiry a refreshed access token
+// is considered stale
+const tokenExpiryMargin = time.Minute
+
+// xoauth2Client implements the SASL XOAUTH2 mechanism used by Gmail and
+// Office 365
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+// newXOAuth2Client creates a SASL client authenticating username with an
+// OAuth2 access token
+func newXOAuth2Client(username, token string) sasl.Client {
+	return &xoauth2Client{username: username, token: token}
+}
+
+func (a *xoauth2Client) Start() (mech string, ir []byte, err error) {
+	return "XOAUTH2", xoauth2InitialResponse(a.username, a.token), nil
+}
+
+// Next answers the JSON error challenge sent when the token is rejected. The
+// empty response makes the server end the exchange with the actual failure.
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
+
+// xoauth2InitialResponse builds the XOAUTH2 initial client response, before
+// base64 encoding: "user=<username>^Aauth=Bearer <token>^A^A"
+func xoauth2InitialResponse(username, token string) []byte {
+	return []byte("user=" + username + "\x01auth=Bearer " + token + "\x01\x01")
+}
+
+// tokenResponse is the successful response of an OAuth2 token endpoint
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// accessToken returns the access token for XOAUTH2. With a refresh token
+// configured, a new access token is requested from the token endpoint once
+// the previous one is about to expire; otherwise oauth_token is used as is.
+func (c *Client) accessToken() (string, error) {
+	oauth := c.config.OAuth2
+	if oauth.RefreshToken == "" {
+		if c.config.OAuthToken == "" {
+			return "", fmt.Errorf("xoauth2 requires oauth_token or oauth2.refresh_token")
+		}
+		return c.config.OAuthToken, nil
+	}
+
+	if c.token != "" && time.Now().Before(c.tokenExpiry.Add(-tokenExpiryMargin)) {
+		return c.token, nil
+	}
+
+	if oauth.TokenURL == "" {
+		return "", fmt.Errorf("oauth2.token_url is required to refresh the access token")
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {oauth.RefreshToken},
+		"client_id":     {oauth.ClientID},
+	}
+	if oauth.ClientSecret != "" {
+		form.Set("client_secret", oauth.ClientSecret)
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Post(oauth.TokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("invalid token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned HTTP %d: %s %s", resp.StatusCode, token.Error, token.Description)
+	}
+
+	c.token = token.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return c.token, nil
+}
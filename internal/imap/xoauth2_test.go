@@ -0,0 +1,123 @@
+package imap
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zaptest"
+	"parsedmarc-go/internal/config"
+)
+
+func TestXOAuth2InitialResponse(t *testing.T) {
+	got := xoauth2InitialResponse("someuser@example.com", "ya29.vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg")
+	want := "user=someuser@example.com\x01auth=Bearer ya29.vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg\x01\x01"
+	if string(got) != want {
+		t.Errorf("xoauth2InitialResponse() = %q, want %q", got, want)
+	}
+
+	// Example from Google's XOAUTH2 protocol documentation
+	wantEncoded := "dXNlcj1zb21ldXNlckBleGFtcGxlLmNvbQFhdXRoPUJlYXJlciB5YTI5LnZGOWRmdDRxbVRjMk52YjNSbGNrQmhkSFJoZG1semRHRXVZMjl0Q2cBAQ=="
+	if encoded := base64.StdEncoding.EncodeToString(got); encoded != wantEncoded {
+		t.Errorf("Encoded response = %q, want %q", encoded, wantEncoded)
+	}
+}
+
+func TestXOAuth2Client(t *testing.T) {
+	client := newXOAuth2Client("dmarc@example.com", "token")
+
+	mech, ir, err := client.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if mech != "XOAUTH2" {
+		t.Errorf("Expected mechanism XOAUTH2, got %s", mech)
+	}
+	if string(ir) != "user=dmarc@example.com\x01auth=Bearer token\x01\x01" {
+		t.Errorf("Unexpected initial response %q", ir)
+	}
+
+	// The error challenge is answered with an empty response
+	resp, err := client.Next([]byte(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`))
+	if err != nil || resp == nil || len(resp) != 0 {
+		t.Errorf("Next() = %q, %v; want empty response", resp, err)
+	}
+}
+
+func TestClient_AccessToken(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Failed to parse token request: %v", err)
+		}
+		if r.PostForm.Get("grant_type") != "refresh_token" ||
+			r.PostForm.Get("refresh_token") != "refresh-me" ||
+			r.PostForm.Get("client_id") != "client" ||
+			r.PostForm.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"Bad request"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"fresh-token","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	t.Run("Static token", func(t *testing.T) {
+		c := New(config.IMAPConfig{OAuthToken: "static-token"}, nil, zaptest.NewLogger(t), prometheus.NewRegistry())
+		token, err := c.accessToken()
+		if err != nil || token != "static-token" {
+			t.Errorf("accessToken() = %q, %v; want static-token", token, err)
+		}
+	})
+
+	t.Run("Missing token", func(t *testing.T) {
+		c := New(config.IMAPConfig{}, nil, zaptest.NewLogger(t), prometheus.NewRegistry())
+		if _, err := c.accessToken(); err == nil {
+			t.Error("Expected error without oauth_token or refresh token")
+		}
+	})
+
+	t.Run("Refresh token", func(t *testing.T) {
+		requests = 0
+		c := New(config.IMAPConfig{
+			OAuthToken: "stale-token",
+			OAuth2: config.IMAPOAuth2Config{
+				ClientID:     "client",
+				ClientSecret: "secret",
+				RefreshToken: "refresh-me",
+				TokenURL:     server.URL,
+			},
+		}, nil, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+		for i := 0; i < 2; i++ {
+			token, err := c.accessToken()
+			if err != nil {
+				t.Fatalf("accessToken() error = %v", err)
+			}
+			if token != "fresh-token" {
+				t.Errorf("Expected fresh-token, got %s", token)
+			}
+		}
+		if requests != 1 {
+			t.Errorf("Expected the token to be refreshed once, got %d requests", requests)
+		}
+	})
+
+	t.Run("Rejected refresh token", func(t *testing.T) {
+		c := New(config.IMAPConfig{
+			OAuth2: config.IMAPOAuth2Config{
+				ClientID:     "client",
+				RefreshToken: "revoked",
+				TokenURL:     server.URL,
+			},
+		}, nil, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+		if _, err := c.accessToken(); err == nil {
+			t.Error("Expected error for rejected refresh token")
+		}
+	})
+}
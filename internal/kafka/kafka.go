@@ -7,33 +7,42 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/segmentio/kafka-go"
 	//	"github.com/segmentio/kafka-go/sasl"
 	"github.com/segmentio/kafka-go/sasl/plain"
 	"go.uber.org/zap"
 	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/metrics"
 	"parsedmarc-go/internal/parser"
 )
 
 // Client represents a Kafka client for sending reports
 type Client struct {
-	config *config.KafkaConfig
-	logger *zap.Logger
+	config  *config.KafkaConfig
+	logger  *zap.Logger
+	metrics *metrics.SenderMetrics
 }
 
-// New creates a new Kafka client
-func New(cfg *config.KafkaConfig, logger *zap.Logger) *Client {
+// New creates a new Kafka client. Metrics are registered with registry, or
+// with the global default registerer when registry is nil.
+func New(cfg *config.KafkaConfig, logger *zap.Logger, registry *prometheus.Registry) *Client {
 	return &Client{
-		config: cfg,
-		logger: logger,
+		config:  cfg,
+		logger:  logger,
+		metrics: metrics.NewSenderMetrics(registry),
 	}
 }
 
+// sink is the sink label of the Kafka sender metrics
+const sink = "kafka"
+
 // SendAggregateReport sends an aggregate DMARC report to Kafka
-func (c *Client) SendAggregateReport(report *parser.AggregateReport) error {
+func (c *Client) SendAggregateReport(report *parser.AggregateReport) (err error) {
 	if !c.config.Enabled || c.config.AggregateTopic == "" {
 		return nil
 	}
+	defer func() { c.metrics.RecordSend(sink, parser.ReportTypeAggregate, err == nil) }()
 
 	// Marshal report to JSON
 	data, err := json.Marshal(report)
@@ -63,10 +72,11 @@ func (c *Client) SendAggregateReport(report *parser.AggregateReport) error {
 }
 
 // SendForensicReport sends a forensic DMARC report to Kafka
-func (c *Client) SendForensicReport(report *parser.ForensicReport) error {
+func (c *Client) SendForensicReport(report *parser.ForensicReport) (err error) {
 	if !c.config.Enabled || c.config.ForensicTopic == "" {
 		return nil
 	}
+	defer func() { c.metrics.RecordSend(sink, parser.ReportTypeForensic, err == nil) }()
 
 	// Marshal report to JSON
 	data, err := json.Marshal(report)
@@ -99,10 +109,11 @@ func (c *Client) SendForensicReport(report *parser.ForensicReport) error {
 }
 
 // SendSMTPTLSReport sends an SMTP TLS report to Kafka
-func (c *Client) SendSMTPTLSReport(report *parser.SMTPTLSReport) error {
+func (c *Client) SendSMTPTLSReport(report *parser.SMTPTLSReport) (err error) {
 	if !c.config.Enabled || c.config.SMTPTLSTopic == "" {
 		return nil
 	}
+	defer func() { c.metrics.RecordSend(sink, parser.ReportTypeSMTPTLS, err == nil) }()
 
 	// Marshal report to JSON
 	data, err := json.Marshal(report)
@@ -144,33 +155,8 @@ func (c *Client) sendMessage(topic string, msg kafka.Message) error {
 		Balancer: &kafka.LeastBytes{},
 	}
 
-	// Configure TLS if enabled
-	if c.config.SSL {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: c.config.SkipVerify,
-		}
-		writerConfig.Dialer = &kafka.Dialer{
-			Timeout:   10 * time.Second,
-			DualStack: true,
-			TLS:       tlsConfig,
-		}
-	}
-
-	// Configure SASL authentication if credentials are provided
-	if c.config.Username != "" && c.config.Password != "" {
-		mechanism := plain.Mechanism{
-			Username: c.config.Username,
-			Password: c.config.Password,
-		}
-
-		if writerConfig.Dialer == nil {
-			writerConfig.Dialer = &kafka.Dialer{
-				Timeout:   10 * time.Second,
-				DualStack: true,
-			}
-		}
-		writerConfig.Dialer.SASLMechanism = mechanism
-	}
+	// Configure TLS and SASL authentication
+	writerConfig.Dialer = c.dialer()
 
 	// Create writer
 	writer := kafka.NewWriter(writerConfig)
@@ -197,65 +183,147 @@ func (c *Client) sendMessage(topic string, msg kafka.Message) error {
 	return nil
 }
 
-// TestConnection tests the connection to Kafka brokers
-func (c *Client) TestConnection() error {
-	if !c.config.Enabled || len(c.config.Hosts) == 0 {
-		return fmt.Errorf("Kafka not enabled or no hosts configured")
+// messageReader is the part of kafka.Reader used by Consume
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Consume reads raw reports from input_topic as a member of consumer_group and
+// passes each message value to handler, until ctx is cancelled. A message is
+// committed only once handler succeeds: when handler fails, Consume returns
+// the error without committing, and the message is delivered again the next
+// time the group consumes the topic.
+func (c *Client) Consume(ctx context.Context, handler func(value []byte) error) error {
+	if c.config.InputTopic == "" {
+		return fmt.Errorf("no Kafka input topic configured")
+	}
+	if len(c.config.Hosts) == 0 {
+		return fmt.Errorf("no Kafka brokers configured")
 	}
 
-	// Create a simple connection test using a reader
-	readerConfig := kafka.ReaderConfig{
+	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers: c.config.Hosts,
-		Topic:   "test-connection",
-		GroupID: "parsedmarc-connection-test",
+		Topic:   c.config.InputTopic,
+		GroupID: c.config.ConsumerGroup,
+		Dialer:  c.dialer(),
+	})
+	defer reader.Close()
+
+	c.logger.Info("Consuming reports from Kafka",
+		zap.String("topic", c.config.InputTopic),
+		zap.String("group", c.config.ConsumerGroup),
+	)
+
+	return c.consume(ctx, reader, handler)
+}
+
+// consume runs the fetch, handle, commit loop of Consume
+func (c *Client) consume(ctx context.Context, reader messageReader, handler func(value []byte) error) error {
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to fetch message from Kafka topic %s: %w", c.config.InputTopic, err)
+		}
+
+		c.logger.Debug("Received message from Kafka",
+			zap.String("topic", msg.Topic),
+			zap.Int("partition", msg.Partition),
+			zap.Int64("offset", msg.Offset),
+			zap.Int("size", len(msg.Value)),
+		)
+
+		if err := handler(msg.Value); err != nil {
+			return fmt.Errorf("failed to process message at offset %d of partition %d: %w", msg.Offset, msg.Partition, err)
+		}
+
+		// Commit even when shutting down, since the message was processed
+		commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		err = reader.CommitMessages(commitCtx, msg)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to commit message at offset %d of partition %d: %w", msg.Offset, msg.Partition, err)
+		}
+	}
+}
+
+// dialer returns the dialer for the configured TLS and SASL settings, or nil
+// for the default dialer
+func (c *Client) dialer() *kafka.Dialer {
+	if !c.config.SSL && (c.config.Username == "" || c.config.Password == "") {
+		return nil
 	}
 
-	// Configure TLS if enabled
+	dialer := &kafka.Dialer{
+		Timeout:   10 * time.Second,
+		DualStack: true,
+	}
 	if c.config.SSL {
-		tlsConfig := &tls.Config{
+		dialer.TLS = &tls.Config{
 			InsecureSkipVerify: c.config.SkipVerify,
 		}
-		readerConfig.Dialer = &kafka.Dialer{
-			Timeout:   10 * time.Second,
-			DualStack: true,
-			TLS:       tlsConfig,
-		}
 	}
-
-	// Configure SASL authentication if credentials are provided
 	if c.config.Username != "" && c.config.Password != "" {
-		mechanism := plain.Mechanism{
+		dialer.SASLMechanism = plain.Mechanism{
 			Username: c.config.Username,
 			Password: c.config.Password,
 		}
-
-		if readerConfig.Dialer == nil {
-			readerConfig.Dialer = &kafka.Dialer{
-				Timeout:   10 * time.Second,
-				DualStack: true,
-			}
-		}
-		readerConfig.Dialer.SASLMechanism = mechanism
 	}
+	return dialer
+}
 
-	reader := kafka.NewReader(readerConfig)
-	defer reader.Close()
+// TestConnection tests the connection to Kafka brokers by dialing each
+// configured host and requesting the cluster metadata, giving up after 10
+// seconds or when ctx is done, whichever comes first
+func (c *Client) TestConnection(ctx context.Context) error {
+	if !c.config.Enabled || len(c.config.Hosts) == 0 {
+		return fmt.Errorf("Kafka not enabled or no hosts configured")
+	}
 
-	// Try to connect with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	// Just try to fetch metadata to test connection
-	_, err := reader.FetchMessage(ctx)
+	dialer := c.dialer()
+	if dialer == nil {
+		dialer = kafka.DefaultDialer
+	}
+
+	var lastErr error
+	for _, host := range c.config.Hosts {
+		if err := testBroker(ctx, dialer, host); err != nil {
+			c.logger.Debug("Kafka broker not reachable", zap.String("host", host), zap.Error(err))
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no Kafka broker reachable: %w", lastErr)
+}
+
+// testBroker dials a single broker and asks it for the cluster metadata
+func testBroker(ctx context.Context, dialer *kafka.Dialer, host string) error {
+	conn, err := dialer.DialContext(ctx, "tcp", host)
 	if err != nil {
-		// We expect this to fail since we're using a test topic
-		// But if we get a connection-related error, return it
-		if ctx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("connection timeout to Kafka brokers")
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
 		}
-		// Other errors might be expected (like topic not found), so we consider the connection working
-		c.logger.Debug("Kafka connection test completed", zap.Error(err))
 	}
 
+	if _, err := conn.Brokers(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to read metadata from %s: %w", host, err)
+	}
 	return nil
 }
@@ -1,10 +1,18 @@
 package kafka
 
 import (
+	"context"
+	"errors"
+	"net"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
 	"go.uber.org/zap/zaptest"
 	"parsedmarc-go/internal/config"
 	"parsedmarc-go/internal/parser"
@@ -26,7 +34,7 @@ func TestKafkaClient_SendAggregateReport(t *testing.T) {
 		SMTPTLSTopic:   "dmarc.smtp_tls",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create test aggregate report
 	report := &parser.AggregateReport{
@@ -91,7 +99,7 @@ func TestKafkaClient_SendForensicReport(t *testing.T) {
 		ForensicTopic: "dmarc.forensic",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create test forensic report
 	report := &parser.ForensicReport{
@@ -132,7 +140,7 @@ func TestKafkaClient_SendSMTPTLSReport(t *testing.T) {
 		SMTPTLSTopic: "dmarc.smtp_tls",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create test SMTP TLS report
 	report := &parser.SMTPTLSReport{
@@ -175,7 +183,7 @@ func TestKafkaClient_DisabledClient(t *testing.T) {
 		AggregateTopic: "dmarc.aggregate",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create a dummy report
 	report := &parser.AggregateReport{
@@ -203,7 +211,7 @@ func TestKafkaClient_EmptyTopic(t *testing.T) {
 		AggregateTopic: "", // Empty topic
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create a dummy report
 	report := &parser.AggregateReport{
@@ -231,7 +239,7 @@ func TestKafkaClient_NoHosts(t *testing.T) {
 		AggregateTopic: "dmarc.aggregate",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create a dummy report
 	report := &parser.AggregateReport{
@@ -284,8 +292,8 @@ func TestKafkaClient_TestConnection(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			client := New(tt.config, logger)
-			err := client.TestConnection()
+			client := New(tt.config, logger, prometheus.NewRegistry())
+			err := client.TestConnection(context.Background())
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("TestConnection() error = %v, wantErr %v", err, tt.wantErr)
@@ -294,6 +302,88 @@ func TestKafkaClient_TestConnection(t *testing.T) {
 	}
 }
 
+func TestKafkaClient_TestConnectionHonorsContext(t *testing.T) {
+	// A broker that accepts connections but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := New(&config.KafkaConfig{
+		Enabled: true,
+		Hosts:   []string{listener.Addr().String()},
+	}, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = client.TestConnection(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("TestConnection() error = %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected TestConnection() to stop at the context deadline, took %v", elapsed)
+	}
+}
+
+func TestKafkaClient_TestConnectionReachableBroker(t *testing.T) {
+	// A broker that answers metadata requests for an idle cluster
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					version, correlationID, _, msg, err := protocol.ReadRequest(conn)
+					if err != nil {
+						return
+					}
+					if _, ok := msg.(*metadata.Request); !ok {
+						return
+					}
+					res := &metadata.Response{
+						Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "127.0.0.1", Port: 9092}},
+					}
+					if err := protocol.WriteResponse(conn, version, correlationID, res); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	client := New(&config.KafkaConfig{
+		Enabled: true,
+		Hosts:   []string{listener.Addr().String()},
+	}, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.TestConnection(ctx); err != nil {
+		t.Errorf("TestConnection() error = %v, want nil for a reachable broker", err)
+	}
+}
+
 func TestKafkaClient_WithSSL(t *testing.T) {
 	logger := zaptest.NewLogger(t)
 
@@ -307,7 +397,7 @@ func TestKafkaClient_WithSSL(t *testing.T) {
 		AggregateTopic: "dmarc.aggregate",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create a dummy report
 	report := &parser.AggregateReport{
@@ -333,6 +423,137 @@ func TestKafkaClient_WithSSL(t *testing.T) {
 }
 
 // Helper function to create string pointer
+// fakeReader serves messages from a slice and records commits. Once the
+// messages are exhausted it blocks until ctx is cancelled.
+type fakeReader struct {
+	messages  []kafka.Message
+	committed []int64
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if len(r.messages) == 0 {
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+	msg := r.messages[0]
+	r.messages = r.messages[1:]
+	return msg, nil
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, msg := range msgs {
+		r.committed = append(r.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+func TestKafkaClient_Consume(t *testing.T) {
+	client := New(&config.KafkaConfig{Enabled: true, InputTopic: "dmarc.raw"}, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	newReader := func() *fakeReader {
+		return &fakeReader{messages: []kafka.Message{
+			{Offset: 0, Value: []byte("first")},
+			{Offset: 1, Value: []byte("second")},
+			{Offset: 2, Value: []byte("third")},
+		}}
+	}
+
+	t.Run("commits handled messages until cancelled", func(t *testing.T) {
+		reader := newReader()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var handled []string
+		err := client.consume(ctx, reader, func(value []byte) error {
+			handled = append(handled, string(value))
+			if len(handled) == 3 {
+				cancel()
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("consume() error = %v", err)
+		}
+
+		if strings.Join(handled, ",") != "first,second,third" {
+			t.Errorf("Handled %v, want all three messages", handled)
+		}
+		// The message handled while shutting down is still committed
+		if len(reader.committed) != 3 {
+			t.Errorf("Committed offsets %v, want [0 1 2]", reader.committed)
+		}
+	})
+
+	t.Run("stops without committing a failed message", func(t *testing.T) {
+		reader := newReader()
+		handlerErr := errors.New("storage unavailable")
+
+		err := client.consume(context.Background(), reader, func(value []byte) error {
+			if string(value) == "second" {
+				return handlerErr
+			}
+			return nil
+		})
+		if !errors.Is(err, handlerErr) {
+			t.Fatalf("consume() error = %v, want %v", err, handlerErr)
+		}
+
+		if len(reader.committed) != 1 || reader.committed[0] != 0 {
+			t.Errorf("Committed offsets %v, want [0]", reader.committed)
+		}
+		if len(reader.messages) != 1 {
+			t.Errorf("Expected the message after the failed one to stay unfetched, %d left", len(reader.messages))
+		}
+	})
+}
+
+func TestKafkaClient_SenderMetrics(t *testing.T) {
+	client := New(&config.KafkaConfig{
+		Enabled:        true,
+		AggregateTopic: "dmarc-aggregate",
+	}, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	// Without brokers the send fails before connecting
+	report := &parser.AggregateReport{ReportMetadata: parser.ReportMetadata{ReportID: "test-123"}}
+	if err := client.SendAggregateReport(report); err == nil {
+		t.Fatal("Expected error without brokers")
+	}
+
+	sent := client.metrics.ReportsSentTotal
+	if got := testutil.ToFloat64(sent.WithLabelValues("kafka", "aggregate", "failure")); got != 1 {
+		t.Errorf("Expected 1 failed send, got %v", got)
+	}
+	if got := testutil.ToFloat64(sent.WithLabelValues("kafka", "aggregate", "success")); got != 0 {
+		t.Errorf("Expected no successful send, got %v", got)
+	}
+
+	// Disabled topics are not counted
+	if err := client.SendSMTPTLSReport(&parser.SMTPTLSReport{}); err != nil {
+		t.Fatalf("SendSMTPTLSReport failed: %v", err)
+	}
+	if got := testutil.CollectAndCount(sent); got != 2 {
+		t.Errorf("Expected only the aggregate series, got %d series", got)
+	}
+}
+
+func TestKafkaClient_ConsumeRequiresTopicAndHosts(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	handler := func([]byte) error { return nil }
+
+	client := New(&config.KafkaConfig{Enabled: true, Hosts: []string{"localhost:9092"}}, logger, prometheus.NewRegistry())
+	if err := client.Consume(context.Background(), handler); err == nil {
+		t.Error("Expected error without input topic, got nil")
+	}
+
+	client = New(&config.KafkaConfig{Enabled: true, InputTopic: "dmarc.raw"}, logger, prometheus.NewRegistry())
+	if err := client.Consume(context.Background(), handler); err == nil {
+		t.Error("Expected error without hosts, got nil")
+	}
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
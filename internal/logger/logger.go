@@ -2,11 +2,19 @@ package logger
 
 import (
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"parsedmarc-go/internal/config"
 )
 
 // New creates a new zap logger based on configuration
 func New(cfg config.LoggingConfig) (*zap.Logger, error) {
+	logger, _, err := NewWithLevel(cfg)
+	return logger, err
+}
+
+// NewWithLevel is New, also returning the level of the logger so that it can
+// be changed while the logger is in use
+func NewWithLevel(cfg config.LoggingConfig) (*zap.Logger, zap.AtomicLevel, error) {
 	var zapConfig zap.Config
 
 	switch cfg.Level {
@@ -19,7 +27,7 @@ func New(cfg config.LoggingConfig) (*zap.Logger, error) {
 	// Set log level
 	level, err := zap.ParseAtomicLevel(cfg.Level)
 	if err != nil {
-		return nil, err
+		return nil, level, err
 	}
 	zapConfig.Level = level
 
@@ -41,6 +49,35 @@ func New(cfg config.LoggingConfig) (*zap.Logger, error) {
 	// Error output
 	zapConfig.ErrorOutputPaths = []string{"stderr"}
 
+	logger, err := zapConfig.Build()
+	return logger, level, err
+}
+
+// NewAudit creates the ingestion audit logger. It is independent of the
+// operational logger: records are always JSON, written at info level with
+// fixed keys, and appended to the configured output.
+func NewAudit(cfg config.AuditLogConfig) (*zap.Logger, error) {
+	outputPath := cfg.OutputPath
+	if outputPath == "" {
+		outputPath = "audit.log"
+	}
+
+	zapConfig := zap.Config{
+		Level:             zap.NewAtomicLevelAt(zap.InfoLevel),
+		Encoding:          "json",
+		DisableCaller:     true,
+		DisableStacktrace: true,
+		EncoderConfig: zapcore.EncoderConfig{
+			TimeKey:        "timestamp",
+			MessageKey:     "event",
+			LineEnding:     zapcore.DefaultLineEnding,
+			EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+			EncodeDuration: zapcore.StringDurationEncoder,
+		},
+		OutputPaths:      []string{outputPath},
+		ErrorOutputPaths: []string{"stderr"},
+	}
+
 	return zapConfig.Build()
 }
 
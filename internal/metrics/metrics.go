@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"errors"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -8,8 +10,14 @@ import (
 type ParserMetrics struct {
 	ParsedReportsTotal   *prometheus.CounterVec
 	ParseFailuresTotal   *prometheus.CounterVec
+	ParseWarningsTotal   *prometheus.CounterVec
+	PolicyDriftTotal     *prometheus.CounterVec
 	ParseDurationSeconds *prometheus.HistogramVec
 	ReportSizeBytes      prometheus.Histogram
+
+	// DomainFailingMessagesTotal is only updated for monitored domains so
+	// that its cardinality stays bounded
+	DomainFailingMessagesTotal *prometheus.CounterVec
 }
 
 // IMAPMetrics contains metrics for IMAP client
@@ -20,8 +28,44 @@ type IMAPMetrics struct {
 	LastCheckTimestamp      prometheus.Gauge
 }
 
-// NewParserMetrics creates new parser metrics
-func NewParserMetrics() *ParserMetrics {
+// SenderMetrics contains metrics for the senders forwarding reports to
+// other systems, such as SMTP and Kafka
+type SenderMetrics struct {
+	ReportsSentTotal *prometheus.CounterVec
+}
+
+// StorageMetrics contains metrics for the storage backends
+type StorageMetrics struct {
+	InsertDuration      *prometheus.HistogramVec
+	InsertFailuresTotal *prometheus.CounterVec
+}
+
+// Register registers a collector with registry, or with the global default
+// registerer when registry is nil. If an equivalent collector is already
+// registered (e.g. by another instance using the global registerer), the
+// existing collector is returned so that both instances share it.
+func Register[T prometheus.Collector](registry *prometheus.Registry, collector T) T {
+	var registerer prometheus.Registerer = prometheus.DefaultRegisterer
+	if registry != nil {
+		registerer = registry
+	}
+
+	if err := registerer.Register(collector); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+
+	return collector
+}
+
+// NewParserMetrics creates new parser metrics registered with registry
+// (the global default registerer when nil)
+func NewParserMetrics(registry *prometheus.Registry) *ParserMetrics {
 	metrics := &ParserMetrics{
 		ParsedReportsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -37,6 +81,20 @@ func NewParserMetrics() *ParserMetrics {
 			},
 			[]string{"type", "source", "reason"},
 		),
+		ParseWarningsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "parsedmarc_parser_warnings_total",
+				Help: "Total number of reports parsed with recoverable problems",
+			},
+			[]string{"type", "warning"},
+		),
+		PolicyDriftTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "parsedmarc_parser_policy_drift_total",
+				Help: "Total number of aggregate reports whose published policy differs from the current DMARC record",
+			},
+			[]string{"domain", "tag"},
+		),
 		ParseDurationSeconds: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "parsedmarc_parser_duration_seconds",
@@ -52,36 +110,29 @@ func NewParserMetrics() *ParserMetrics {
 				Buckets: []float64{1024, 4096, 16384, 65536, 262144, 1048576, 4194304},
 			},
 		),
+		DomainFailingMessagesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "parsedmarc_parser_domain_failing_messages_total",
+				Help: "Messages failing DMARC in the aggregate reports parsed since startup, by monitored domain",
+			},
+			[]string{"domain"},
+		),
 	}
 
-	// Only register if not already registered (to avoid test conflicts)
-	registry := prometheus.DefaultRegisterer
-	if err := registry.Register(metrics.ParsedReportsTotal); err != nil {
-		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-			panic(err)
-		}
-	}
-	if err := registry.Register(metrics.ParseFailuresTotal); err != nil {
-		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-			panic(err)
-		}
-	}
-	if err := registry.Register(metrics.ParseDurationSeconds); err != nil {
-		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-			panic(err)
-		}
-	}
-	if err := registry.Register(metrics.ReportSizeBytes); err != nil {
-		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-			panic(err)
-		}
-	}
+	metrics.ParsedReportsTotal = Register(registry, metrics.ParsedReportsTotal)
+	metrics.ParseFailuresTotal = Register(registry, metrics.ParseFailuresTotal)
+	metrics.ParseWarningsTotal = Register(registry, metrics.ParseWarningsTotal)
+	metrics.PolicyDriftTotal = Register(registry, metrics.PolicyDriftTotal)
+	metrics.ParseDurationSeconds = Register(registry, metrics.ParseDurationSeconds)
+	metrics.ReportSizeBytes = Register(registry, metrics.ReportSizeBytes)
+	metrics.DomainFailingMessagesTotal = Register(registry, metrics.DomainFailingMessagesTotal)
 
 	return metrics
 }
 
-// NewIMAPMetrics creates new IMAP metrics
-func NewIMAPMetrics() *IMAPMetrics {
+// NewIMAPMetrics creates new IMAP metrics registered with registry (the
+// global default registerer when nil)
+func NewIMAPMetrics(registry *prometheus.Registry) *IMAPMetrics {
 	metrics := &IMAPMetrics{
 		ConnectionAttemptsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -112,29 +163,57 @@ func NewIMAPMetrics() *IMAPMetrics {
 		),
 	}
 
-	// Only register if not already registered (to avoid test conflicts)
-	registry := prometheus.DefaultRegisterer
-	if err := registry.Register(metrics.ConnectionAttemptsTotal); err != nil {
-		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-			panic(err)
-		}
-	}
-	if err := registry.Register(metrics.MessagesProcessedTotal); err != nil {
-		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-			panic(err)
-		}
-	}
-	if err := registry.Register(metrics.ConnectionDuration); err != nil {
-		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-			panic(err)
-		}
+	metrics.ConnectionAttemptsTotal = Register(registry, metrics.ConnectionAttemptsTotal)
+	metrics.MessagesProcessedTotal = Register(registry, metrics.MessagesProcessedTotal)
+	metrics.ConnectionDuration = Register(registry, metrics.ConnectionDuration)
+	metrics.LastCheckTimestamp = Register(registry, metrics.LastCheckTimestamp)
+
+	return metrics
+}
+
+// NewSenderMetrics creates new sender metrics registered with registry (the
+// global default registerer when nil). Senders using the same registerer
+// share them, distinguished by the sink label.
+func NewSenderMetrics(registry *prometheus.Registry) *SenderMetrics {
+	metrics := &SenderMetrics{
+		ReportsSentTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "parsedmarc_sender_reports_total",
+				Help: "Total number of reports forwarded by senders",
+			},
+			[]string{"sink", "report_type", "result"},
+		),
 	}
-	if err := registry.Register(metrics.LastCheckTimestamp); err != nil {
-		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
-			panic(err)
-		}
+
+	metrics.ReportsSentTotal = Register(registry, metrics.ReportsSentTotal)
+
+	return metrics
+}
+
+// NewStorageMetrics creates new storage metrics registered with registry
+// (the global default registerer when nil)
+func NewStorageMetrics(registry *prometheus.Registry) *StorageMetrics {
+	metrics := &StorageMetrics{
+		InsertDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "parsedmarc_storage_insert_duration_seconds",
+				Help:    "Time spent storing reports",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"storage", "report_type"},
+		),
+		InsertFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "parsedmarc_storage_insert_failures_total",
+				Help: "Total number of reports that failed to be stored",
+			},
+			[]string{"storage", "report_type"},
+		),
 	}
 
+	metrics.InsertDuration = Register(registry, metrics.InsertDuration)
+	metrics.InsertFailuresTotal = Register(registry, metrics.InsertFailuresTotal)
+
 	return metrics
 }
 
@@ -152,6 +231,23 @@ func (m *ParserMetrics) RecordParseFailure(reportType, source, reason string, du
 	m.ReportSizeBytes.Observe(float64(size))
 }
 
+// RecordParseWarning records a recoverable problem in a parsed report
+func (m *ParserMetrics) RecordParseWarning(reportType, warning string) {
+	m.ParseWarningsTotal.WithLabelValues(reportType, warning).Inc()
+}
+
+// RecordPolicyDrift records a tag of a report's published policy differing
+// from the current DMARC record of domain
+func (m *ParserMetrics) RecordPolicyDrift(domain, tag string) {
+	m.PolicyDriftTotal.WithLabelValues(domain, tag).Inc()
+}
+
+// AddDomainFailingMessages adds count messages failing DMARC for domain. A
+// count of 0 exposes the domain before its first failure.
+func (m *ParserMetrics) AddDomainFailingMessages(domain string, count int) {
+	m.DomainFailingMessagesTotal.WithLabelValues(domain).Add(float64(count))
+}
+
 // RecordIMAPConnection records an IMAP connection attempt
 func (m *IMAPMetrics) RecordConnection(success bool) {
 	status := "success"
@@ -179,3 +275,20 @@ func (m *IMAPMetrics) RecordConnectionDuration(duration float64) {
 func (m *IMAPMetrics) UpdateLastCheck() {
 	m.LastCheckTimestamp.SetToCurrentTime()
 }
+
+// RecordSend records a report forwarded to sink
+func (m *SenderMetrics) RecordSend(sink, reportType string, success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	m.ReportsSentTotal.WithLabelValues(sink, reportType, result).Inc()
+}
+
+// RecordInsert records a store of reportType reports in storage
+func (m *StorageMetrics) RecordInsert(storage, reportType string, duration float64, success bool) {
+	m.InsertDuration.WithLabelValues(storage, reportType).Observe(duration)
+	if !success {
+		m.InsertFailuresTotal.WithLabelValues(storage, reportType).Inc()
+	}
+}
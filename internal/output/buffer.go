@@ -0,0 +1,79 @@
+package output
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Flusher is implemented by writers that buffer output
+type Flusher interface {
+	Flush() error
+}
+
+// bufferedWriter buffers writes to an underlying writer and flushes them
+// periodically from a background goroutine and when closed
+type bufferedWriter struct {
+	mu     sync.Mutex
+	buf    *bufio.Writer
+	logger *zap.Logger
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newBufferedWriter creates a buffered writer flushed every interval
+func newBufferedWriter(w io.Writer, interval time.Duration, logger *zap.Logger) *bufferedWriter {
+	b := &bufferedWriter{
+		buf:    bufio.NewWriter(w),
+		logger: logger,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go b.flushLoop(interval)
+
+	return b
+}
+
+// Write writes p into the buffer
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Flush writes any buffered data to the underlying writer
+func (b *bufferedWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Flush()
+}
+
+// Close stops the periodic flush and flushes remaining data
+func (b *bufferedWriter) Close() error {
+	close(b.stop)
+	<-b.done
+	return b.Flush()
+}
+
+// flushLoop flushes the buffer on every tick until stopped
+func (b *bufferedWriter) flushLoop(interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Flush(); err != nil && b.logger != nil {
+				b.logger.Error("Failed to flush buffered output", zap.Error(err))
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
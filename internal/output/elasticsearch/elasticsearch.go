@@ -0,0 +1,388 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/parser"
+)
+
+// Client indexes reports into Elasticsearch or OpenSearch with the bulk API.
+// Aggregate reports are indexed as one document per record, forensic and
+// SMTP TLS reports as one document each, into daily indices named after the
+// index prefix and the report type, e.g. dmarc_aggregate-2024-01-02.
+// Documents are buffered and sent once batch_size of them are pending, every
+// flush_interval, and on Close. The buffer is swapped out under the lock and
+// sent after releasing it, so that reports are queued during a bulk request.
+type Client struct {
+	config *config.ElasticsearchConfig
+	client *http.Client
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	body    bytes.Buffer // pending bulk request body
+	pending int          // documents in body
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a new Elasticsearch client
+func New(cfg *config.ElasticsearchConfig, logger *zap.Logger) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.SkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	c := &Client{
+		config: cfg,
+		client: &http.Client{Transport: transport, Timeout: timeout},
+		logger: logger,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if cfg.FlushInterval > 0 {
+		go c.flushPeriodically(time.Duration(cfg.FlushInterval) * time.Second)
+	} else {
+		close(c.done)
+	}
+
+	return c
+}
+
+// aggregateRecordDoc is the document of one aggregate report record, with
+// the field names of the upstream parsedmarc Elasticsearch documents
+type aggregateRecordDoc struct {
+	XMLSchema           string                        `json:"xml_schema"`
+	OrgName             string                        `json:"org_name"`
+	OrgEmail            string                        `json:"org_email"`
+	OrgExtraContactInfo *string                       `json:"org_extra_contact_info"`
+	ReportID            string                        `json:"report_id"`
+	DateRange           []time.Time                   `json:"date_range"`
+	DateBegin           time.Time                     `json:"date_begin"`
+	DateEnd             time.Time                     `json:"date_end"`
+	Errors              []string                      `json:"errors"`
+	PublishedPolicy     parser.PolicyPublished        `json:"published_policy"`
+	SourceIPAddress     string                        `json:"source_ip_address"`
+	SourceCountry       string                        `json:"source_country"`
+	SourceReverseDNS    string                        `json:"source_reverse_dns"`
+	SourceBaseDomain    string                        `json:"source_base_domain"`
+	SourceName          string                        `json:"source_name"`
+	SourceType          string                        `json:"source_type"`
+	MessageCount        int                           `json:"message_count"`
+	Disposition         string                        `json:"disposition"`
+	EffectivePolicy     string                        `json:"effective_policy"`
+	SampledOut          bool                          `json:"sampled_out"`
+	DKIMAligned         bool                          `json:"dkim_aligned"`
+	SPFAligned          bool                          `json:"spf_aligned"`
+	PassedDMARC         bool                          `json:"passed_dmarc"`
+	PolicyOverrides     []parser.PolicyOverrideReason `json:"policy_overrides"`
+	HeaderFrom          string                        `json:"header_from"`
+	EnvelopeFrom        *string                       `json:"envelope_from"`
+	EnvelopeTo          *string                       `json:"envelope_to"`
+	DKIMResults         []parser.DKIMResult           `json:"dkim_results"`
+	SPFResults          []parser.SPFResult            `json:"spf_results"`
+}
+
+// SendAggregateReport queues the records of an aggregate report for indexing
+func (c *Client) SendAggregateReport(report *parser.AggregateReport) error {
+	if !c.config.Enabled {
+		return nil
+	}
+
+	metadata := report.ReportMetadata
+	index := c.index("aggregate", metadata.BeginDate)
+
+	var batches []bulkBatch
+	c.mu.Lock()
+	for i, record := range report.Records {
+		doc := aggregateRecordDoc{
+			XMLSchema:           report.XMLSchema,
+			OrgName:             metadata.OrgName,
+			OrgEmail:            metadata.OrgEmail,
+			OrgExtraContactInfo: metadata.OrgExtraContactInfo,
+			ReportID:            metadata.ReportID,
+			DateRange:           []time.Time{metadata.BeginDate, metadata.EndDate},
+			DateBegin:           metadata.BeginDate,
+			DateEnd:             metadata.EndDate,
+			Errors:              metadata.Errors,
+			PublishedPolicy:     report.PolicyPublished,
+			SourceIPAddress:     record.Source.IPAddress,
+			SourceCountry:       record.Source.Country,
+			SourceReverseDNS:    record.Source.ReverseDNS,
+			SourceBaseDomain:    record.Source.BaseDomain,
+			SourceName:          record.Source.Name,
+			SourceType:          record.Source.Type,
+			MessageCount:        record.Count,
+			Disposition:         record.PolicyEvaluated.Disposition,
+			EffectivePolicy:     record.EffectivePolicy,
+			SampledOut:          record.SampledOut,
+			DKIMAligned:         record.Alignment.DKIM,
+			SPFAligned:          record.Alignment.SPF,
+			PassedDMARC:         record.Alignment.DMARC,
+			PolicyOverrides:     record.PolicyEvaluated.PolicyOverrideReasons,
+			HeaderFrom:          record.Identifiers.HeaderFrom,
+			EnvelopeFrom:        record.Identifiers.EnvelopeFrom,
+			EnvelopeTo:          record.Identifiers.EnvelopeTo,
+			DKIMResults:         record.AuthResults.DKIM,
+			SPFResults:          record.AuthResults.SPF,
+		}
+
+		// Records are identified by their position in the whole report, which
+		// streamed reports are written in chunks of, so that indexing a report
+		// again overwrites its documents
+		id := fmt.Sprintf("%s-%s-%d", metadata.OrgName, metadata.ReportID, report.RecordOffset+i)
+		batch, err := c.add(index, id, doc)
+		if err != nil {
+			c.mu.Unlock()
+			return fmt.Errorf("failed to index aggregate report %s: %w", metadata.ReportID, err)
+		}
+		if batch.documents > 0 {
+			batches = append(batches, batch)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, batch := range batches {
+		if err := c.send(batch); err != nil {
+			return fmt.Errorf("failed to index aggregate report %s: %w", metadata.ReportID, err)
+		}
+	}
+	return nil
+}
+
+// SendForensicReport queues a forensic report for indexing
+func (c *Client) SendForensicReport(report *parser.ForensicReport) error {
+	if !c.config.Enabled {
+		return nil
+	}
+
+	// Reports without an Arrival-Date are indexed by ingestion time rather
+	// than into a year 1 index
+	date := report.ArrivalDateUTC
+	if date.IsZero() {
+		date = time.Now()
+	}
+	if err := c.queue(c.index("forensic", date), "", report); err != nil {
+		return fmt.Errorf("failed to index forensic report: %w", err)
+	}
+	return nil
+}
+
+// SendSMTPTLSReport queues an SMTP TLS report for indexing
+func (c *Client) SendSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	if !c.config.Enabled {
+		return nil
+	}
+
+	id := fmt.Sprintf("%s-%s", report.OrganizationName, report.ReportID)
+	if err := c.queue(c.index("smtp_tls", report.BeginDate), id, report); err != nil {
+		return fmt.Errorf("failed to index SMTP TLS report %s: %w", report.ReportID, err)
+	}
+	return nil
+}
+
+// WriteAggregateReport, WriteForensicReport and WriteSMTPTLSReport make the
+// client an output writer
+
+func (c *Client) WriteAggregateReport(report *parser.AggregateReport) error {
+	return c.SendAggregateReport(report)
+}
+
+func (c *Client) WriteForensicReport(report *parser.ForensicReport) error {
+	return c.SendForensicReport(report)
+}
+
+func (c *Client) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	return c.SendSMTPTLSReport(report)
+}
+
+// Flush sends the pending documents
+func (c *Client) Flush() error {
+	c.mu.Lock()
+	batch := c.take()
+	c.mu.Unlock()
+
+	return c.send(batch)
+}
+
+// Close stops the periodic flush and sends the pending documents
+func (c *Client) Close() error {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+
+	return c.Flush()
+}
+
+// flushPeriodically flushes every interval until Close
+func (c *Client) flushPeriodically(interval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.Flush(); err != nil {
+				c.logger.Error("Failed to flush reports to Elasticsearch", zap.Error(err))
+			}
+		}
+	}
+}
+
+// index returns the daily index of reportType for date
+func (c *Client) index(reportType string, date time.Time) string {
+	prefix := c.config.IndexPrefix
+	if prefix != "" {
+		prefix += "_"
+	}
+	return fmt.Sprintf("%s%s-%s", prefix, reportType, date.UTC().Format("2006-01-02"))
+}
+
+// bulkBatch is the body of one bulk request and its number of documents
+type bulkBatch struct {
+	body      []byte
+	documents int
+}
+
+// queue adds an index action for doc to the pending bulk request, and sends
+// the request once batch_size documents are pending
+func (c *Client) queue(index, id string, doc any) error {
+	c.mu.Lock()
+	batch, err := c.add(index, id, doc)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return c.send(batch)
+}
+
+// add appends an index action for doc to the pending bulk request. Once
+// batch_size documents are pending it takes them, to be sent by the caller
+// after releasing c.mu. c.mu must be held.
+func (c *Client) add(index, id string, doc any) (bulkBatch, error) {
+	source, err := json.Marshal(doc)
+	if err != nil {
+		return bulkBatch{}, fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	action := map[string]map[string]string{"index": {"_index": index}}
+	if id != "" {
+		action["index"]["_id"] = id
+	}
+	meta, err := json.Marshal(action)
+	if err != nil {
+		return bulkBatch{}, fmt.Errorf("failed to marshal bulk action: %w", err)
+	}
+
+	c.body.Write(meta)
+	c.body.WriteByte('\n')
+	c.body.Write(source)
+	c.body.WriteByte('\n')
+	c.pending++
+
+	if c.pending >= max(c.config.BatchSize, 1) {
+		return c.take(), nil
+	}
+	return bulkBatch{}, nil
+}
+
+// take returns the pending documents and empties the buffer. c.mu must be
+// held.
+func (c *Client) take() bulkBatch {
+	batch := bulkBatch{body: bytes.Clone(c.body.Bytes()), documents: c.pending}
+	c.body.Reset()
+	c.pending = 0
+	return batch
+}
+
+// bulkResponse is the part of a bulk API response needed to report errors
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Index  string `json:"_index"`
+		Status int    `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// send sends batch in one bulk request. Its documents are dropped whether or
+// not the request succeeds, so that a failing cluster does not make the
+// buffer grow without bounds.
+func (c *Client) send(batch bulkBatch) error {
+	if batch.documents == 0 {
+		return nil
+	}
+
+	documents := batch.documents
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(c.config.URL, "/")+"/_bulk", bytes.NewReader(batch.body))
+	if err != nil {
+		return fmt.Errorf("failed to create bulk request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if c.config.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+c.config.APIKey)
+	} else if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read bulk response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bulk request failed: %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	var result bulkResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to parse bulk response: %w", err)
+	}
+	if result.Errors {
+		var errs []error
+		for _, item := range result.Items {
+			for _, status := range item {
+				if status.Error != nil {
+					errs = append(errs, fmt.Errorf("%s: %s: %s", status.Index, status.Error.Type, status.Error.Reason))
+				}
+			}
+		}
+		return fmt.Errorf("failed to index %d of %d documents: %w", len(errs), documents, errors.Join(errs...))
+	}
+
+	c.logger.Debug("Indexed reports in Elasticsearch", zap.Int("documents", documents))
+	return nil
+}
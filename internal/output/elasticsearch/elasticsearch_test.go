@@ -0,0 +1,325 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/parser"
+)
+
+// bulkRequest is a request received by mockBulkServer
+type bulkRequest struct {
+	authorization string
+	contentType   string
+	actions       []map[string]map[string]string
+	documents     []map[string]any
+}
+
+// mockBulkServer records the bulk requests it receives and answers them with
+// response
+func mockBulkServer(t *testing.T, response string) (*httptest.Server, func() []bulkRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []bulkRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/_bulk" {
+			http.NotFound(w, r)
+			return
+		}
+
+		req := bulkRequest{
+			authorization: r.Header.Get("Authorization"),
+			contentType:   r.Header.Get("Content-Type"),
+		}
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+		for line := 0; scanner.Scan(); line++ {
+			if line%2 == 0 {
+				var action map[string]map[string]string
+				if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+					t.Errorf("Invalid bulk action: %v", err)
+				}
+				req.actions = append(req.actions, action)
+			} else {
+				var doc map[string]any
+				if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+					t.Errorf("Invalid bulk document: %v", err)
+				}
+				req.documents = append(req.documents, doc)
+			}
+		}
+
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []bulkRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]bulkRequest(nil), requests...)
+	}
+}
+
+func testAggregateReport() *parser.AggregateReport {
+	begin := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	return &parser.AggregateReport{
+		XMLSchema: "1.0",
+		ReportMetadata: parser.ReportMetadata{
+			OrgName:   "google.com",
+			ReportID:  "report-1",
+			BeginDate: begin,
+			EndDate:   begin.Add(24*time.Hour - time.Second),
+		},
+		PolicyPublished: parser.PolicyPublished{Domain: "example.com", P: "none"},
+		Records: []parser.Record{
+			{
+				Source:      parser.Source{IPAddress: "192.0.2.1", Country: "US"},
+				Count:       3,
+				Alignment:   parser.Alignment{SPF: true, DMARC: true},
+				Identifiers: parser.Identifiers{HeaderFrom: "example.com"},
+			},
+			{
+				Source: parser.Source{IPAddress: "198.51.100.7"},
+				Count:  1,
+			},
+		},
+	}
+}
+
+func TestClient_BulkIndexing(t *testing.T) {
+	server, requests := mockBulkServer(t, `{"took":3,"errors":false,"items":[]}`)
+
+	cfg := &config.ElasticsearchConfig{
+		Enabled:     true,
+		URL:         server.URL + "/",
+		IndexPrefix: "dmarc",
+		Username:    "elastic",
+		Password:    "secret",
+		BatchSize:   2,
+	}
+	client := New(cfg, zaptest.NewLogger(t))
+
+	// The two records of the aggregate report fill a batch
+	if err := client.WriteAggregateReport(testAggregateReport()); err != nil {
+		t.Fatalf("WriteAggregateReport failed: %v", err)
+	}
+	if got := len(requests()); got != 1 {
+		t.Fatalf("Expected a full batch to be sent, got %d requests", got)
+	}
+
+	forensic := &parser.ForensicReport{
+		ReportedDomain: "example.com",
+		ArrivalDateUTC: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC),
+	}
+	if err := client.WriteForensicReport(forensic); err != nil {
+		t.Fatalf("WriteForensicReport failed: %v", err)
+	}
+	if got := len(requests()); got != 1 {
+		t.Fatalf("Expected a partial batch to be kept, got %d requests", got)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	sent := requests()
+	if len(sent) != 2 {
+		t.Fatalf("Expected the partial batch to be sent on close, got %d requests", len(sent))
+	}
+	for _, req := range sent {
+		if req.contentType != "application/x-ndjson" {
+			t.Errorf("Expected NDJSON content type, got %q", req.contentType)
+		}
+		if !strings.HasPrefix(req.authorization, "Basic ") {
+			t.Errorf("Expected basic auth, got %q", req.authorization)
+		}
+	}
+
+	aggregate := sent[0]
+	if len(aggregate.documents) != 2 {
+		t.Fatalf("Expected one document per record, got %d", len(aggregate.documents))
+	}
+	if index := aggregate.actions[0]["index"]["_index"]; index != "dmarc_aggregate-2024-01-02" {
+		t.Errorf("Expected index dmarc_aggregate-2024-01-02, got %s", index)
+	}
+	if id := aggregate.actions[1]["index"]["_id"]; id != "google.com-report-1-1" {
+		t.Errorf("Expected document ID google.com-report-1-1, got %s", id)
+	}
+	doc := aggregate.documents[0]
+	if doc["source_ip_address"] != "192.0.2.1" || doc["message_count"] != float64(3) || doc["passed_dmarc"] != true {
+		t.Errorf("Unexpected aggregate record document: %v", doc)
+	}
+
+	if index := sent[1].actions[0]["index"]["_index"]; index != "dmarc_forensic-2024-01-03" {
+		t.Errorf("Expected index dmarc_forensic-2024-01-03, got %s", index)
+	}
+	if sent[1].documents[0]["reported_domain"] != "example.com" {
+		t.Errorf("Unexpected forensic document: %v", sent[1].documents[0])
+	}
+}
+
+func TestClient_StreamedReportChunks(t *testing.T) {
+	server, requests := mockBulkServer(t, `{"errors":false,"items":[]}`)
+
+	client := New(&config.ElasticsearchConfig{Enabled: true, URL: server.URL, BatchSize: 10}, zaptest.NewLogger(t))
+
+	// A streamed report is written as chunks holding some of its records
+	report := testAggregateReport()
+	first, second := *report, *report
+	first.Records = report.Records[:1]
+	second.Records = report.Records[1:]
+	second.RecordOffset = 1
+	for _, chunk := range []*parser.AggregateReport{&first, &second} {
+		if err := client.WriteAggregateReport(chunk); err != nil {
+			t.Fatalf("WriteAggregateReport failed: %v", err)
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var ids []string
+	for _, req := range requests() {
+		for _, action := range req.actions {
+			ids = append(ids, action["index"]["_id"])
+		}
+	}
+	if want := []string{"google.com-report-1-0", "google.com-report-1-1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Expected document IDs %v, got %v", want, ids)
+	}
+}
+
+func TestClient_APIKeyAndFlushInterval(t *testing.T) {
+	server, requests := mockBulkServer(t, `{"errors":false,"items":[]}`)
+
+	cfg := &config.ElasticsearchConfig{
+		Enabled:       true,
+		URL:           server.URL,
+		APIKey:        "c2VjcmV0",
+		BatchSize:     100,
+		FlushInterval: 1,
+	}
+	client := New(cfg, zaptest.NewLogger(t))
+	defer client.Close()
+
+	if err := client.WriteSMTPTLSReport(&parser.SMTPTLSReport{OrganizationName: "Company-X", ReportID: "tls-1"}); err != nil {
+		t.Fatalf("WriteSMTPTLSReport failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	sent := requests()
+	if len(sent) != 1 {
+		t.Fatalf("Expected the partial batch to be sent after the flush interval, got %d requests", len(sent))
+	}
+	if sent[0].authorization != "ApiKey c2VjcmV0" {
+		t.Errorf("Expected API key auth, got %q", sent[0].authorization)
+	}
+	if index := sent[0].actions[0]["index"]["_index"]; !strings.HasPrefix(index, "smtp_tls-") {
+		t.Errorf("Expected an smtp_tls index without prefix, got %s", index)
+	}
+}
+
+func TestClient_BulkErrors(t *testing.T) {
+	server, _ := mockBulkServer(t, `{"errors":true,"items":[
+		{"index":{"_index":"dmarc_aggregate-2024-01-02","status":201}},
+		{"index":{"_index":"dmarc_aggregate-2024-01-02","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field"}}}
+	]}`)
+
+	client := New(&config.ElasticsearchConfig{Enabled: true, URL: server.URL, BatchSize: 10}, zaptest.NewLogger(t))
+	if err := client.WriteAggregateReport(testAggregateReport()); err != nil {
+		t.Fatalf("WriteAggregateReport failed: %v", err)
+	}
+
+	err := client.Close()
+	if err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") || !strings.Contains(err.Error(), "1 of 2") {
+		t.Errorf("Expected the failed document to be reported, got %v", err)
+	}
+}
+
+func TestClient_QueuesDuringBulkRequest(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		<-release
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	defer unblock()
+
+	client := New(&config.ElasticsearchConfig{Enabled: true, URL: server.URL, BatchSize: 10}, zaptest.NewLogger(t))
+	if err := client.WriteSMTPTLSReport(&parser.SMTPTLSReport{OrganizationName: "Company-X", ReportID: "tls-1"}); err != nil {
+		t.Fatalf("WriteSMTPTLSReport failed: %v", err)
+	}
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- client.Flush() }()
+	<-received
+
+	// The bulk request is in flight: queuing must not wait for it
+	queued := make(chan error, 1)
+	go func() {
+		queued <- client.WriteSMTPTLSReport(&parser.SMTPTLSReport{OrganizationName: "Company-X", ReportID: "tls-2"})
+	}()
+	select {
+	case err := <-queued:
+		if err != nil {
+			t.Fatalf("WriteSMTPTLSReport failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WriteSMTPTLSReport blocked on the bulk request")
+	}
+
+	unblock()
+	if err := <-flushed; err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestClient_ForensicWithoutArrivalDate(t *testing.T) {
+	server, requests := mockBulkServer(t, `{"errors":false,"items":[]}`)
+
+	client := New(&config.ElasticsearchConfig{Enabled: true, URL: server.URL, IndexPrefix: "dmarc", BatchSize: 10}, zaptest.NewLogger(t))
+	before := time.Now().UTC().Format("2006-01-02")
+	if err := client.WriteForensicReport(&parser.ForensicReport{ReportedDomain: "example.com"}); err != nil {
+		t.Fatalf("WriteForensicReport failed: %v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	after := time.Now().UTC().Format("2006-01-02")
+
+	sent := requests()
+	if len(sent) != 1 {
+		t.Fatalf("Expected one bulk request, got %d", len(sent))
+	}
+	index := sent[0].actions[0]["index"]["_index"]
+	if index != "dmarc_forensic-"+before && index != "dmarc_forensic-"+after {
+		t.Errorf("Expected the index of the ingestion day, got %s", index)
+	}
+}
@@ -22,6 +22,23 @@ type Format string
 const (
 	FormatJSON Format = "json"
 	FormatCSV  Format = "csv"
+
+	// FormatNDJSON is JSON with each report on a single line, for log
+	// pipelines
+	FormatNDJSON Format = "ndjson"
+
+	// FormatParquet is a Parquet file of aggregate report records, for
+	// analytics. It is written on Close and requires an output file.
+	FormatParquet Format = "parquet"
+
+	// FormatParsedmarc is JSON in the schema of the upstream Python
+	// parsedmarc, for dashboards and Elasticsearch templates built for it
+	FormatParsedmarc Format = "parsedmarc"
+
+	// FormatSummary is JSON with the message counts of each aggregate report
+	// grouped by source IP and disposition. Forensic and SMTP TLS reports
+	// are only passed on to the senders.
+	FormatSummary Format = "summary"
 )
 
 // Writer interface for output writers
@@ -46,36 +63,60 @@ type KafkaSender interface {
 	SendSMTPTLSReport(report *parser.SMTPTLSReport) error
 }
 
+// SyslogSender interface for sending reports via syslog
+type SyslogSender interface {
+	SendAggregateReport(report *parser.AggregateReport) error
+	SendForensicReport(report *parser.ForensicReport) error
+	SendSMTPTLSReport(report *parser.SMTPTLSReport) error
+}
+
 // Config holds output configuration
 type Config struct {
-	Format      Format
-	File        string // empty string means stdout, directory path for per-report files
-	SMTPSender  SMTPSender
-	KafkaSender KafkaSender
-	Logger      *zap.Logger
+	Format        Format
+	File          string         // empty string means stdout, directory path for per-report files
+	Writer        io.Writer      // if set, output is written here instead of File or stdout
+	FlushInterval time.Duration  // buffer JSON output and flush at this interval; zero disables buffering
+	Compact       bool           // write each JSON report on a single line (NDJSON)
+	Array         bool           // write JSON reports as the elements of one array closed by Close; File is truncated
+	Timezone      *time.Location // time zone of report dates in the output; nil keeps them in UTC
+	SMTPSender    SMTPSender
+	KafkaSender   KafkaSender
+	SyslogSender  SyslogSender
+	Logger        *zap.Logger
 }
 
 // NewWriter creates a new output writer based on configuration
 func NewWriter(cfg Config) (Writer, error) {
+	if cfg.Format == FormatParquet {
+		return newParquetWriter(cfg)
+	}
+
 	// Check if cfg.File is a directory
-	if cfg.File != "" {
+	if cfg.File != "" && cfg.Writer == nil {
 		stat, err := os.Stat(cfg.File)
 		if err == nil && stat.IsDir() {
 			// Directory mode - create individual files per report
 			switch cfg.Format {
-			case FormatJSON:
+			case FormatJSON, FormatNDJSON, FormatParsedmarc, FormatSummary:
 				return &DirectoryJSONWriter{
-					outputDir:   cfg.File,
-					smtpSender:  cfg.SMTPSender,
-					kafkaSender: cfg.KafkaSender,
-					logger:      cfg.Logger,
+					outputDir:    cfg.File,
+					compact:      cfg.Compact || cfg.Format == FormatNDJSON,
+					parsedmarc:   cfg.Format == FormatParsedmarc,
+					summary:      cfg.Format == FormatSummary,
+					smtpSender:   cfg.SMTPSender,
+					kafkaSender:  cfg.KafkaSender,
+					syslogSender: cfg.SyslogSender,
+					logger:       cfg.Logger,
+					timezone:     cfg.Timezone,
 				}, nil
 			case FormatCSV:
 				return &DirectoryCSVWriter{
-					outputDir:   cfg.File,
-					smtpSender:  cfg.SMTPSender,
-					kafkaSender: cfg.KafkaSender,
-					logger:      cfg.Logger,
+					outputDir:    cfg.File,
+					smtpSender:   cfg.SMTPSender,
+					kafkaSender:  cfg.KafkaSender,
+					syslogSender: cfg.SyslogSender,
+					logger:       cfg.Logger,
+					timezone:     cfg.Timezone,
 				}, nil
 			default:
 				return nil, fmt.Errorf("unsupported output format: %s", cfg.Format)
@@ -89,10 +130,17 @@ func NewWriter(cfg Config) (Writer, error) {
 	var w io.Writer
 	var closer io.Closer
 
-	if cfg.File == "" {
+	if cfg.Writer != nil {
+		w = cfg.Writer
+	} else if cfg.File == "" {
 		w = os.Stdout
 	} else {
-		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		// An array cannot be appended to a previous run's output
+		flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if cfg.Array && (cfg.Format == FormatJSON || cfg.Format == FormatParsedmarc || cfg.Format == FormatSummary) {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		}
+		file, err := os.OpenFile(cfg.File, flags, 0644)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open output file %s: %w", cfg.File, err)
 		}
@@ -101,22 +149,35 @@ func NewWriter(cfg Config) (Writer, error) {
 	}
 
 	switch cfg.Format {
-	case FormatJSON:
-		return &JSONWriter{
-			writer:      w,
-			closer:      closer,
-			smtpSender:  cfg.SMTPSender,
-			kafkaSender: cfg.KafkaSender,
-			logger:      cfg.Logger,
-		}, nil
+	case FormatJSON, FormatNDJSON, FormatParsedmarc, FormatSummary:
+		jsonWriter := &JSONWriter{
+			writer:       w,
+			closer:       closer,
+			compact:      cfg.Compact || cfg.Format == FormatNDJSON,
+			array:        cfg.Array && cfg.Format != FormatNDJSON,
+			parsedmarc:   cfg.Format == FormatParsedmarc,
+			summary:      cfg.Format == FormatSummary,
+			smtpSender:   cfg.SMTPSender,
+			kafkaSender:  cfg.KafkaSender,
+			syslogSender: cfg.SyslogSender,
+			logger:       cfg.Logger,
+			timezone:     cfg.Timezone,
+		}
+		if cfg.FlushInterval > 0 {
+			jsonWriter.buffer = newBufferedWriter(w, cfg.FlushInterval, cfg.Logger)
+			jsonWriter.writer = jsonWriter.buffer
+		}
+		return jsonWriter, nil
 	case FormatCSV:
 		return &CSVWriter{
-			writer:      w,
-			closer:      closer,
-			csvWriter:   csv.NewWriter(w),
-			smtpSender:  cfg.SMTPSender,
-			kafkaSender: cfg.KafkaSender,
-			logger:      cfg.Logger,
+			writer:       w,
+			closer:       closer,
+			csvWriter:    csv.NewWriter(w),
+			smtpSender:   cfg.SMTPSender,
+			kafkaSender:  cfg.KafkaSender,
+			syslogSender: cfg.SyslogSender,
+			logger:       cfg.Logger,
+			timezone:     cfg.Timezone,
 		}, nil
 	default:
 		if closer != nil {
@@ -128,27 +189,34 @@ func NewWriter(cfg Config) (Writer, error) {
 
 // JSONWriter writes output in JSON format
 type JSONWriter struct {
-	writer      io.Writer
-	closer      io.Closer
-	smtpSender  SMTPSender
-	kafkaSender KafkaSender
-	logger      *zap.Logger
+	writer       io.Writer
+	closer       io.Closer
+	buffer       *bufferedWriter
+	compact      bool
+	array        bool // write reports as the elements of one JSON array
+	elements     int  // reports written to the array so far
+	parsedmarc   bool // write the upstream parsedmarc schema
+	summary      bool // write aggregate report summaries only
+	smtpSender   SMTPSender
+	kafkaSender  KafkaSender
+	syslogSender SyslogSender
+	logger       *zap.Logger
+	timezone     *time.Location
 }
 
 func (j *JSONWriter) WriteAggregateReport(report *parser.AggregateReport) error {
-	data, err := json.MarshalIndent(report, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal aggregate report to JSON: %w", err)
+	var value any
+	if j.summary {
+		value = SummarizeAggregateReport(aggregateInTimezone(report, j.timezone))
+	} else {
+		value = aggregateJSON(report, j.timezone, j.parsedmarc)
 	}
-
-	_, err = j.writer.Write(data)
+	data, err := j.marshal(value)
 	if err != nil {
-		return fmt.Errorf("failed to write JSON: %w", err)
+		return fmt.Errorf("failed to marshal aggregate report to JSON: %w", err)
 	}
 
-	// Add newline for better formatting
-	_, err = j.writer.Write([]byte("\n"))
-	if err != nil {
+	if err := j.write(data); err != nil {
 		return err
 	}
 
@@ -166,24 +234,26 @@ func (j *JSONWriter) WriteAggregateReport(report *parser.AggregateReport) error
 		}
 	}
 
+	// Send via syslog if configured
+	if j.syslogSender != nil {
+		if err := j.syslogSender.SendAggregateReport(report); err != nil {
+			j.logger.Error("Failed to send aggregate report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
 func (j *JSONWriter) WriteForensicReport(report *parser.ForensicReport) error {
-	data, err := json.MarshalIndent(report, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal forensic report to JSON: %w", err)
-	}
-
-	_, err = j.writer.Write(data)
-	if err != nil {
-		return fmt.Errorf("failed to write JSON: %w", err)
-	}
+	if !j.summary {
+		data, err := j.marshal(forensicJSON(report, j.timezone, j.parsedmarc))
+		if err != nil {
+			return fmt.Errorf("failed to marshal forensic report to JSON: %w", err)
+		}
 
-	// Add newline for better formatting
-	_, err = j.writer.Write([]byte("\n"))
-	if err != nil {
-		return err
+		if err := j.write(data); err != nil {
+			return err
+		}
 	}
 
 	// Send via SMTP if configured
@@ -200,24 +270,26 @@ func (j *JSONWriter) WriteForensicReport(report *parser.ForensicReport) error {
 		}
 	}
 
+	// Send via syslog if configured
+	if j.syslogSender != nil {
+		if err := j.syslogSender.SendForensicReport(report); err != nil {
+			j.logger.Error("Failed to send forensic report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
 func (j *JSONWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
-	data, err := json.MarshalIndent(report, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal SMTP TLS report to JSON: %w", err)
-	}
-
-	_, err = j.writer.Write(data)
-	if err != nil {
-		return fmt.Errorf("failed to write JSON: %w", err)
-	}
+	if !j.summary {
+		data, err := j.marshal(smtpTLSJSON(report, j.timezone, j.parsedmarc))
+		if err != nil {
+			return fmt.Errorf("failed to marshal SMTP TLS report to JSON: %w", err)
+		}
 
-	// Add newline for better formatting
-	_, err = j.writer.Write([]byte("\n"))
-	if err != nil {
-		return err
+		if err := j.write(data); err != nil {
+			return err
+		}
 	}
 
 	// Send via SMTP if configured
@@ -234,10 +306,94 @@ func (j *JSONWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
 		}
 	}
 
+	// Send via syslog if configured
+	if j.syslogSender != nil {
+		if err := j.syslogSender.SendSMTPTLSReport(report); err != nil {
+			j.logger.Error("Failed to send SMTP TLS report via syslog", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// marshal encodes report as indented JSON, or as a single line in compact mode
+func (j *JSONWriter) marshal(report any) ([]byte, error) {
+	if j.compact {
+		return json.Marshal(report)
+	}
+	if j.array {
+		return json.MarshalIndent(report, "  ", "  ")
+	}
+	return json.MarshalIndent(report, "", "  ")
+}
+
+// write writes an encoded report on its own line, or as the next element of
+// the array in array mode
+func (j *JSONWriter) write(data []byte) error {
+	if j.array {
+		separator := ",\n"
+		if j.elements == 0 {
+			separator = "[\n"
+		}
+		if !j.compact {
+			separator += "  "
+		}
+		if _, err := io.WriteString(j.writer, separator); err != nil {
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+		j.elements++
+	}
+
+	if _, err := j.writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+
+	// Add newline for better formatting; the array is closed by Close
+	if !j.array {
+		if _, err := j.writer.Write([]byte("\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeArray ends the array of reports in array mode
+func (j *JSONWriter) closeArray() error {
+	closing := "\n]\n"
+	if j.elements == 0 {
+		closing = "[]\n"
+	}
+	if _, err := io.WriteString(j.writer, closing); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
+
+// Flush writes buffered output to the underlying file
+func (j *JSONWriter) Flush() error {
+	if j.buffer != nil {
+		return j.buffer.Flush()
+	}
 	return nil
 }
 
 func (j *JSONWriter) Close() error {
+	if j.array {
+		if err := j.closeArray(); err != nil {
+			if j.closer != nil {
+				j.closer.Close()
+			}
+			return err
+		}
+	}
+	if j.buffer != nil {
+		if err := j.buffer.Close(); err != nil {
+			if j.closer != nil {
+				j.closer.Close()
+			}
+			return fmt.Errorf("failed to flush JSON output: %w", err)
+		}
+	}
 	if j.closer != nil {
 		return j.closer.Close()
 	}
@@ -252,7 +408,9 @@ type CSVWriter struct {
 	headersWritten map[string]bool
 	smtpSender     SMTPSender
 	kafkaSender    KafkaSender
+	syslogSender   SyslogSender
 	logger         *zap.Logger
+	timezone       *time.Location
 }
 
 func (c *CSVWriter) WriteAggregateReport(report *parser.AggregateReport) error {
@@ -265,8 +423,9 @@ func (c *CSVWriter) WriteAggregateReport(report *parser.AggregateReport) error {
 		headers := []string{
 			"report_id", "org_name", "org_email", "begin_date", "end_date",
 			"domain", "policy_adkim", "policy_aspf", "policy_p", "policy_sp", "policy_pct",
-			"source_ip", "source_country", "source_reverse_dns", "count",
-			"disposition", "dkim_result", "spf_result", "dmarc_aligned",
+			"source_ip", "source_country", "source_city", "source_latitude", "source_longitude",
+			"source_asn", "source_asn_org", "source_reverse_dns", "count",
+			"disposition", "effective_policy", "sampled_out", "dkim_result", "spf_result", "dmarc_aligned",
 			"header_from", "envelope_from", "dkim_domain", "dkim_selector", "spf_domain",
 		}
 		if err := c.csvWriter.Write(headers); err != nil {
@@ -281,8 +440,8 @@ func (c *CSVWriter) WriteAggregateReport(report *parser.AggregateReport) error {
 			report.ReportMetadata.ReportID,
 			report.ReportMetadata.OrgName,
 			report.ReportMetadata.OrgEmail,
-			report.ReportMetadata.BeginDate.Format(time.RFC3339),
-			report.ReportMetadata.EndDate.Format(time.RFC3339),
+			formatDate(report.ReportMetadata.BeginDate, c.timezone),
+			formatDate(report.ReportMetadata.EndDate, c.timezone),
 			report.PolicyPublished.Domain,
 			report.PolicyPublished.ADKIM,
 			report.PolicyPublished.ASPF,
@@ -291,9 +450,16 @@ func (c *CSVWriter) WriteAggregateReport(report *parser.AggregateReport) error {
 			report.PolicyPublished.PCT,
 			record.Source.IPAddress,
 			record.Source.Country,
+			record.Source.City,
+			formatCoordinate(record.Source.Latitude),
+			formatCoordinate(record.Source.Longitude),
+			strconv.FormatUint(uint64(record.Source.ASN), 10),
+			record.Source.ASNOrg,
 			record.Source.ReverseDNS,
 			strconv.Itoa(record.Count),
 			record.PolicyEvaluated.Disposition,
+			record.EffectivePolicy,
+			strconv.FormatBool(record.SampledOut),
 			record.PolicyEvaluated.DKIM,
 			record.PolicyEvaluated.SPF,
 			strconv.FormatBool(record.Alignment.DMARC),
@@ -328,6 +494,13 @@ func (c *CSVWriter) WriteAggregateReport(report *parser.AggregateReport) error {
 		}
 	}
 
+	// Send via syslog if configured
+	if c.syslogSender != nil {
+		if err := c.syslogSender.SendAggregateReport(report); err != nil {
+			c.logger.Error("Failed to send aggregate report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
@@ -342,7 +515,8 @@ func (c *CSVWriter) WriteForensicReport(report *parser.ForensicReport) error {
 			"feedback_type", "user_agent", "version", "original_envelope_id",
 			"original_mail_from", "original_rcpt_to", "arrival_date", "subject",
 			"message_id", "authentication_results", "dkim_domain", "source_ip",
-			"source_country", "delivery_result", "auth_failure", "reported_domain",
+			"source_country", "source_city", "source_latitude", "source_longitude",
+			"source_asn", "source_asn_org", "delivery_result", "auth_failure", "reported_domain",
 		}
 		if err := c.csvWriter.Write(headers); err != nil {
 			return fmt.Errorf("failed to write CSV headers: %w", err)
@@ -357,13 +531,18 @@ func (c *CSVWriter) WriteForensicReport(report *parser.ForensicReport) error {
 		stringPtrToString(report.OriginalEnvelopeID),
 		stringPtrToString(report.OriginalMailFrom),
 		stringPtrToString(report.OriginalRcptTo),
-		report.ArrivalDate.Format(time.RFC3339),
+		formatDate(report.ArrivalDate, c.timezone),
 		report.Subject,
 		report.MessageID,
 		report.AuthenticationResults,
 		stringPtrToString(report.DKIMDomain),
 		report.Source.IPAddress,
 		report.Source.Country,
+		report.Source.City,
+		formatCoordinate(report.Source.Latitude),
+		formatCoordinate(report.Source.Longitude),
+		strconv.FormatUint(uint64(report.Source.ASN), 10),
+		report.Source.ASNOrg,
 		report.DeliveryResult,
 		strings.Join(report.AuthFailure, ";"),
 		report.ReportedDomain,
@@ -393,6 +572,13 @@ func (c *CSVWriter) WriteForensicReport(report *parser.ForensicReport) error {
 		}
 	}
 
+	// Send via syslog if configured
+	if c.syslogSender != nil {
+		if err := c.syslogSender.SendForensicReport(report); err != nil {
+			c.logger.Error("Failed to send forensic report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
@@ -419,8 +605,8 @@ func (c *CSVWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
 		// Base row for policy
 		baseRow := []string{
 			report.OrganizationName,
-			report.BeginDate.Format(time.RFC3339),
-			report.EndDate.Format(time.RFC3339),
+			formatDate(report.BeginDate, c.timezone),
+			formatDate(report.EndDate, c.timezone),
 			report.ContactInfo,
 			report.ReportID,
 			policy.PolicyDomain,
@@ -473,6 +659,13 @@ func (c *CSVWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
 		}
 	}
 
+	// Send via syslog if configured
+	if c.syslogSender != nil {
+		if err := c.syslogSender.SendSMTPTLSReport(report); err != nil {
+			c.logger.Error("Failed to send SMTP TLS report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
@@ -494,6 +687,54 @@ func stringPtrToString(s *string) string {
 	return *s
 }
 
+// formatDate formats a report date in loc, or as is (UTC) when loc is nil
+func formatDate(t time.Time, loc *time.Location) string {
+	if loc != nil {
+		t = t.In(loc)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formatCoordinate formats a latitude or longitude with the shortest exact
+// representation
+func formatCoordinate(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// aggregateInTimezone returns a copy of report with its dates in loc, for
+// JSON output. The report itself is left in UTC for the other outputs.
+func aggregateInTimezone(report *parser.AggregateReport, loc *time.Location) *parser.AggregateReport {
+	if loc == nil {
+		return report
+	}
+	converted := *report
+	converted.ReportMetadata.BeginDate = report.ReportMetadata.BeginDate.In(loc)
+	converted.ReportMetadata.EndDate = report.ReportMetadata.EndDate.In(loc)
+	return &converted
+}
+
+// forensicInTimezone returns a copy of report with its arrival date in loc.
+// arrival_date_utc stays in UTC.
+func forensicInTimezone(report *parser.ForensicReport, loc *time.Location) *parser.ForensicReport {
+	if loc == nil {
+		return report
+	}
+	converted := *report
+	converted.ArrivalDate = report.ArrivalDate.In(loc)
+	return &converted
+}
+
+// smtpTLSInTimezone returns a copy of report with its dates in loc
+func smtpTLSInTimezone(report *parser.SMTPTLSReport, loc *time.Location) *parser.SMTPTLSReport {
+	if loc == nil {
+		return report
+	}
+	converted := *report
+	converted.BeginDate = report.BeginDate.In(loc)
+	converted.EndDate = report.EndDate.In(loc)
+	return &converted
+}
+
 func getDKIMDomain(dkimResults []parser.DKIMResult) string {
 	if len(dkimResults) == 0 {
 		return ""
@@ -517,17 +758,28 @@ func getSPFDomain(spfResults []parser.SPFResult) string {
 
 // DirectoryJSONWriter writes each report as a separate JSON file in a directory
 type DirectoryJSONWriter struct {
-	outputDir   string
-	smtpSender  SMTPSender
-	kafkaSender KafkaSender
-	logger      *zap.Logger
+	outputDir    string
+	compact      bool // write each report on a single line
+	parsedmarc   bool // write the upstream parsedmarc schema
+	summary      bool // write aggregate report summaries only
+	smtpSender   SMTPSender
+	kafkaSender  KafkaSender
+	syslogSender SyslogSender
+	logger       *zap.Logger
+	timezone     *time.Location
 }
 
 func (d *DirectoryJSONWriter) WriteAggregateReport(report *parser.AggregateReport) error {
 	filename := d.generateAggregateFilename(report, "json")
 	filePath := filepath.Join(d.outputDir, filename)
 
-	data, err := json.MarshalIndent(report, "", "  ")
+	var value any
+	if d.summary {
+		value = SummarizeAggregateReport(aggregateInTimezone(report, d.timezone))
+	} else {
+		value = aggregateJSON(report, d.timezone, d.parsedmarc)
+	}
+	data, err := d.marshal(value)
 	if err != nil {
 		return fmt.Errorf("failed to marshal aggregate report to JSON: %w", err)
 	}
@@ -553,24 +805,32 @@ func (d *DirectoryJSONWriter) WriteAggregateReport(report *parser.AggregateRepor
 		}
 	}
 
+	// Send via syslog if configured
+	if d.syslogSender != nil {
+		if err := d.syslogSender.SendAggregateReport(report); err != nil {
+			d.logger.Error("Failed to send aggregate report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
 func (d *DirectoryJSONWriter) WriteForensicReport(report *parser.ForensicReport) error {
-	filename := d.generateForensicFilename(report, "json")
-	filePath := filepath.Join(d.outputDir, filename)
+	if !d.summary {
+		filename := d.generateForensicFilename(report, "json")
+		filePath := filepath.Join(d.outputDir, filename)
 
-	data, err := json.MarshalIndent(report, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal forensic report to JSON: %w", err)
-	}
+		data, err := d.marshal(forensicJSON(report, d.timezone, d.parsedmarc))
+		if err != nil {
+			return fmt.Errorf("failed to marshal forensic report to JSON: %w", err)
+		}
 
-	err = os.WriteFile(filePath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write JSON file %s: %w", filePath, err)
-	}
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write JSON file %s: %w", filePath, err)
+		}
 
-	d.logger.Info("Wrote forensic report", zap.String("file", filePath))
+		d.logger.Info("Wrote forensic report", zap.String("file", filePath))
+	}
 
 	// Send via SMTP if configured
 	if d.smtpSender != nil {
@@ -586,24 +846,32 @@ func (d *DirectoryJSONWriter) WriteForensicReport(report *parser.ForensicReport)
 		}
 	}
 
+	// Send via syslog if configured
+	if d.syslogSender != nil {
+		if err := d.syslogSender.SendForensicReport(report); err != nil {
+			d.logger.Error("Failed to send forensic report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
 func (d *DirectoryJSONWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
-	filename := d.generateSMTPTLSFilename(report, "json")
-	filePath := filepath.Join(d.outputDir, filename)
+	if !d.summary {
+		filename := d.generateSMTPTLSFilename(report, "json")
+		filePath := filepath.Join(d.outputDir, filename)
 
-	data, err := json.MarshalIndent(report, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal SMTP TLS report to JSON: %w", err)
-	}
+		data, err := d.marshal(smtpTLSJSON(report, d.timezone, d.parsedmarc))
+		if err != nil {
+			return fmt.Errorf("failed to marshal SMTP TLS report to JSON: %w", err)
+		}
 
-	err = os.WriteFile(filePath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write JSON file %s: %w", filePath, err)
-	}
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write JSON file %s: %w", filePath, err)
+		}
 
-	d.logger.Info("Wrote SMTP TLS report", zap.String("file", filePath))
+		d.logger.Info("Wrote SMTP TLS report", zap.String("file", filePath))
+	}
 
 	// Send via SMTP if configured
 	if d.smtpSender != nil {
@@ -619,19 +887,36 @@ func (d *DirectoryJSONWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) e
 		}
 	}
 
+	// Send via syslog if configured
+	if d.syslogSender != nil {
+		if err := d.syslogSender.SendSMTPTLSReport(report); err != nil {
+			d.logger.Error("Failed to send SMTP TLS report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
+// marshal encodes report as indented JSON, or as a single line in compact mode
+func (d *DirectoryJSONWriter) marshal(report any) ([]byte, error) {
+	if d.compact {
+		return json.Marshal(report)
+	}
+	return json.MarshalIndent(report, "", "  ")
+}
+
 func (d *DirectoryJSONWriter) Close() error {
 	return nil
 }
 
 // DirectoryCSVWriter writes each report as a separate CSV file in a directory
 type DirectoryCSVWriter struct {
-	outputDir   string
-	smtpSender  SMTPSender
-	kafkaSender KafkaSender
-	logger      *zap.Logger
+	outputDir    string
+	smtpSender   SMTPSender
+	kafkaSender  KafkaSender
+	syslogSender SyslogSender
+	logger       *zap.Logger
+	timezone     *time.Location
 }
 
 func (d *DirectoryCSVWriter) WriteAggregateReport(report *parser.AggregateReport) error {
@@ -651,8 +936,9 @@ func (d *DirectoryCSVWriter) WriteAggregateReport(report *parser.AggregateReport
 	headers := []string{
 		"report_id", "org_name", "org_email", "begin_date", "end_date",
 		"domain", "policy_adkim", "policy_aspf", "policy_p", "policy_sp", "policy_pct",
-		"source_ip", "source_country", "source_reverse_dns", "count",
-		"disposition", "dkim_result", "spf_result", "dmarc_aligned",
+		"source_ip", "source_country", "source_city", "source_latitude", "source_longitude",
+		"source_asn", "source_asn_org", "source_reverse_dns", "count",
+		"disposition", "effective_policy", "sampled_out", "dkim_result", "spf_result", "dmarc_aligned",
 		"header_from", "envelope_from", "dkim_domain", "dkim_selector", "spf_domain",
 	}
 	if err := csvWriter.Write(headers); err != nil {
@@ -665,8 +951,8 @@ func (d *DirectoryCSVWriter) WriteAggregateReport(report *parser.AggregateReport
 			report.ReportMetadata.ReportID,
 			report.ReportMetadata.OrgName,
 			report.ReportMetadata.OrgEmail,
-			report.ReportMetadata.BeginDate.Format(time.RFC3339),
-			report.ReportMetadata.EndDate.Format(time.RFC3339),
+			formatDate(report.ReportMetadata.BeginDate, d.timezone),
+			formatDate(report.ReportMetadata.EndDate, d.timezone),
 			report.PolicyPublished.Domain,
 			report.PolicyPublished.ADKIM,
 			report.PolicyPublished.ASPF,
@@ -675,9 +961,16 @@ func (d *DirectoryCSVWriter) WriteAggregateReport(report *parser.AggregateReport
 			report.PolicyPublished.PCT,
 			record.Source.IPAddress,
 			record.Source.Country,
+			record.Source.City,
+			formatCoordinate(record.Source.Latitude),
+			formatCoordinate(record.Source.Longitude),
+			strconv.FormatUint(uint64(record.Source.ASN), 10),
+			record.Source.ASNOrg,
 			record.Source.ReverseDNS,
 			strconv.Itoa(record.Count),
 			record.PolicyEvaluated.Disposition,
+			record.EffectivePolicy,
+			strconv.FormatBool(record.SampledOut),
 			record.PolicyEvaluated.DKIM,
 			record.PolicyEvaluated.SPF,
 			strconv.FormatBool(record.Alignment.DMARC),
@@ -709,6 +1002,13 @@ func (d *DirectoryCSVWriter) WriteAggregateReport(report *parser.AggregateReport
 		}
 	}
 
+	// Send via syslog if configured
+	if d.syslogSender != nil {
+		if err := d.syslogSender.SendAggregateReport(report); err != nil {
+			d.logger.Error("Failed to send aggregate report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
@@ -730,7 +1030,8 @@ func (d *DirectoryCSVWriter) WriteForensicReport(report *parser.ForensicReport)
 		"feedback_type", "user_agent", "version", "original_envelope_id",
 		"original_mail_from", "original_rcpt_to", "arrival_date", "subject",
 		"message_id", "authentication_results", "dkim_domain", "source_ip",
-		"source_country", "delivery_result", "auth_failure", "reported_domain",
+		"source_country", "source_city", "source_latitude", "source_longitude",
+		"source_asn", "source_asn_org", "delivery_result", "auth_failure", "reported_domain",
 	}
 	if err := csvWriter.Write(headers); err != nil {
 		return fmt.Errorf("failed to write CSV headers: %w", err)
@@ -743,13 +1044,18 @@ func (d *DirectoryCSVWriter) WriteForensicReport(report *parser.ForensicReport)
 		stringPtrToString(report.OriginalEnvelopeID),
 		stringPtrToString(report.OriginalMailFrom),
 		stringPtrToString(report.OriginalRcptTo),
-		report.ArrivalDate.Format(time.RFC3339),
+		formatDate(report.ArrivalDate, d.timezone),
 		report.Subject,
 		report.MessageID,
 		report.AuthenticationResults,
 		stringPtrToString(report.DKIMDomain),
 		report.Source.IPAddress,
 		report.Source.Country,
+		report.Source.City,
+		formatCoordinate(report.Source.Latitude),
+		formatCoordinate(report.Source.Longitude),
+		strconv.FormatUint(uint64(report.Source.ASN), 10),
+		report.Source.ASNOrg,
 		report.DeliveryResult,
 		strings.Join(report.AuthFailure, ";"),
 		report.ReportedDomain,
@@ -775,6 +1081,13 @@ func (d *DirectoryCSVWriter) WriteForensicReport(report *parser.ForensicReport)
 		}
 	}
 
+	// Send via syslog if configured
+	if d.syslogSender != nil {
+		if err := d.syslogSender.SendForensicReport(report); err != nil {
+			d.logger.Error("Failed to send forensic report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
@@ -806,8 +1119,8 @@ func (d *DirectoryCSVWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) er
 		// Base row for policy
 		baseRow := []string{
 			report.OrganizationName,
-			report.BeginDate.Format(time.RFC3339),
-			report.EndDate.Format(time.RFC3339),
+			formatDate(report.BeginDate, d.timezone),
+			formatDate(report.EndDate, d.timezone),
 			report.ContactInfo,
 			report.ReportID,
 			policy.PolicyDomain,
@@ -856,6 +1169,13 @@ func (d *DirectoryCSVWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) er
 		}
 	}
 
+	// Send via syslog if configured
+	if d.syslogSender != nil {
+		if err := d.syslogSender.SendSMTPTLSReport(report); err != nil {
+			d.logger.Error("Failed to send SMTP TLS report via syslog", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
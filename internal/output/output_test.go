@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -186,6 +187,423 @@ func TestForensicReportCSV(t *testing.T) {
 	}
 }
 
+func TestCSVWriterTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Time zone database not available: %v", err)
+	}
+
+	var buf bytes.Buffer
+	writer, err := NewWriter(Config{
+		Format:   FormatCSV,
+		Writer:   &buf,
+		Timezone: loc,
+		Logger:   zap.NewNop(),
+	})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{
+			OrgName:   "test.com",
+			ReportID:  "test-123",
+			BeginDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		},
+		Records: []parser.Record{{Count: 1}},
+	}
+	if err := writer.WriteAggregateReport(report); err != nil {
+		t.Fatalf("WriteAggregateReport failed: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV output: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+
+	if got := rows[1][3]; got != "2023-12-31T19:00:00-05:00" {
+		t.Errorf("Expected begin_date in EST, got %s", got)
+	}
+	if got := rows[1][4]; got != "2024-06-30T20:00:00-04:00" {
+		t.Errorf("Expected end_date in EDT, got %s", got)
+	}
+
+	// The report itself stays in UTC for storage and the other outputs
+	if report.ReportMetadata.BeginDate.Location() != time.UTC {
+		t.Errorf("Expected report dates to stay in UTC, got %s", report.ReportMetadata.BeginDate.Location())
+	}
+}
+
+// upstreamAggregateReport is the aggregate report example of the upstream
+// parsedmarc documentation
+const upstreamAggregateReport = `{
+  "xml_schema": "draft",
+  "report_metadata": {
+    "org_name": "acme.com",
+    "org_email": "noreply-dmarc-support@acme.com",
+    "org_extra_contact_info": "http://acme.com/dmarc/support",
+    "report_id": "9391651994964116463",
+    "begin_date": "2012-04-27 20:00:00",
+    "end_date": "2012-04-28 19:59:59",
+    "errors": []
+  },
+  "policy_published": {
+    "domain": "example.com",
+    "adkim": "r",
+    "aspf": "r",
+    "p": "none",
+    "sp": "none",
+    "pct": "100",
+    "fo": "0"
+  },
+  "records": [
+    {
+      "source": {
+        "ip_address": "72.150.241.94",
+        "country": "US",
+        "reverse_dns": "adsl-72-150-241-94.shv.bellsouth.net",
+        "base_domain": "bellsouth.net",
+        "name": "BellSouth",
+        "type": "ISP"
+      },
+      "count": 2,
+      "alignment": {
+        "spf": true,
+        "dkim": false,
+        "dmarc": true
+      },
+      "policy_evaluated": {
+        "disposition": "none",
+        "dkim": "fail",
+        "spf": "pass",
+        "policy_override_reasons": [
+          {"type": "local_policy", "comment": null}
+        ]
+      },
+      "identifiers": {
+        "header_from": "example.com",
+        "envelope_from": "example.com",
+        "envelope_to": null
+      },
+      "auth_results": {
+        "dkim": [
+          {"domain": "example.com", "selector": "none", "result": "fail"}
+        ],
+        "spf": [
+          {"domain": "example.com", "scope": "mfrom", "result": "pass"}
+        ]
+      }
+    }
+  ]
+}`
+
+// jsonKeyPaths collects the dotted paths of all object keys in v, with []
+// for array elements
+func jsonKeyPaths(v any, prefix string, paths map[string]bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			path := prefix + "." + key
+			paths[path] = true
+			jsonKeyPaths(value, path, paths)
+		}
+	case []any:
+		for _, value := range v {
+			jsonKeyPaths(value, prefix+"[]", paths)
+		}
+	}
+}
+
+func TestJSONWriterParsedmarcSchema(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewWriter(Config{Format: FormatParsedmarc, Writer: &buf})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	localPolicy := "local_policy"
+	envelopeFrom := "example.com"
+	report := &parser.AggregateReport{
+		XMLSchema: "draft",
+		ReportMetadata: parser.ReportMetadata{
+			OrgName:   "acme.com",
+			OrgEmail:  "noreply-dmarc-support@acme.com",
+			ReportID:  "9391651994964116463",
+			BeginDate: time.Date(2012, 4, 27, 20, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2012, 4, 28, 19, 59, 59, 0, time.UTC),
+		},
+		PolicyPublished: parser.PolicyPublished{
+			Domain: "example.com", ADKIM: "r", ASPF: "r", P: "none", SP: "none", PCT: "100", FO: "0", PCTValue: 100,
+		},
+		Records: []parser.Record{
+			{
+				Source: parser.Source{
+					IPAddress: "72.150.241.94",
+					City:      "Shreveport",
+					ASN:       7018,
+				},
+				Count:     2,
+				Alignment: parser.Alignment{SPF: true, DMARC: true},
+				PolicyEvaluated: parser.PolicyEvaluated{
+					Disposition:           "none",
+					DKIM:                  "fail",
+					SPF:                   "pass",
+					PolicyOverrideReasons: []parser.PolicyOverrideReason{{Type: &localPolicy}},
+				},
+				Identifiers: parser.Identifiers{HeaderFrom: "example.com", EnvelopeFrom: &envelopeFrom},
+				AuthResults: parser.AuthResults{
+					DKIM: []parser.DKIMResult{{Domain: "example.com", Selector: "none", Result: "fail"}},
+					SPF:  []parser.SPFResult{{Domain: "example.com", Scope: "mfrom", Result: "pass"}},
+				},
+			},
+		},
+		Fingerprint: "0123456789abcdef",
+		ParseInfo:   parser.ParseInfo{SourceTransport: parser.SourceFile},
+	}
+	if err := writer.WriteAggregateReport(report); err != nil {
+		t.Fatalf("WriteAggregateReport failed: %v", err)
+	}
+
+	var upstream, emitted map[string]any
+	if err := json.Unmarshal([]byte(upstreamAggregateReport), &upstream); err != nil {
+		t.Fatalf("Failed to parse upstream example: %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &emitted); err != nil {
+		t.Fatalf("Failed to parse JSON output: %v", err)
+	}
+
+	want, got := make(map[string]bool), make(map[string]bool)
+	jsonKeyPaths(upstream, "", want)
+	jsonKeyPaths(emitted, "", got)
+	for path := range want {
+		if !got[path] {
+			t.Errorf("Output is missing upstream key %s", path)
+		}
+	}
+	for path := range got {
+		if !want[path] {
+			t.Errorf("Output has key %s unknown upstream", path)
+		}
+	}
+
+	metadata := emitted["report_metadata"].(map[string]any)
+	if metadata["begin_date"] != "2012-04-27 20:00:00" || metadata["end_date"] != "2012-04-28 19:59:59" {
+		t.Errorf("Expected upstream date layout, got %v and %v", metadata["begin_date"], metadata["end_date"])
+	}
+	if errors, ok := metadata["errors"].([]any); !ok || len(errors) != 0 {
+		t.Errorf("Expected empty errors list, got %v", metadata["errors"])
+	}
+	source := emitted["records"].([]any)[0].(map[string]any)["source"].(map[string]any)
+	if source["country"] != nil || source["name"] != nil {
+		t.Errorf("Expected null for unknown source fields, got %v", source)
+	}
+}
+
+func TestJSONWriterArray(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewWriter(Config{Format: FormatJSON, Writer: &buf, Array: true})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("Expected an empty array without reports, got %q", buf.String())
+	}
+
+	buf.Reset()
+	writer, err = NewWriter(Config{Format: FormatJSON, Writer: &buf, Array: true})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for _, id := range []string{"report-1", "report-2"} {
+		report := &parser.AggregateReport{ReportMetadata: parser.ReportMetadata{ReportID: id}}
+		if err := writer.WriteAggregateReport(report); err != nil {
+			t.Fatalf("WriteAggregateReport failed: %v", err)
+		}
+	}
+	if err := writer.WriteSMTPTLSReport(&parser.SMTPTLSReport{ReportID: "report-3"}); err != nil {
+		t.Fatalf("WriteSMTPTLSReport failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var reports []json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &reports); err != nil {
+		t.Fatalf("Output is not a JSON array: %v\n%s", err, buf.String())
+	}
+	if len(reports) != 3 {
+		t.Errorf("Expected 3 reports, got %d", len(reports))
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewWriter(Config{Format: FormatNDJSON, Writer: &buf, Array: true})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	aggregateReport := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "test.com", ReportID: "test-123"},
+		Records: []parser.Record{
+			{Source: parser.Source{IPAddress: "192.0.2.1"}, Count: 1},
+			{Source: parser.Source{IPAddress: "192.0.2.2"}, Count: 2},
+		},
+	}
+	if err := writer.WriteAggregateReport(aggregateReport); err != nil {
+		t.Fatalf("WriteAggregateReport failed: %v", err)
+	}
+	if err := writer.WriteForensicReport(&parser.ForensicReport{Sample: "From: a@example.com\nSubject: test\n"}); err != nil {
+		t.Fatalf("WriteForensicReport failed: %v", err)
+	}
+	if err := writer.WriteSMTPTLSReport(&parser.SMTPTLSReport{ReportID: "tls-1"}); err != nil {
+		t.Fatalf("WriteSMTPTLSReport failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	output := buf.String()
+	if !strings.HasSuffix(output, "\n") {
+		t.Error("Expected output to end with a newline")
+	}
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected one line per report, got %d lines:\n%s", len(lines), output)
+	}
+	for i, line := range lines {
+		var report map[string]any
+		if err := json.Unmarshal([]byte(line), &report); err != nil {
+			t.Errorf("Line %d is not a JSON object: %v", i+1, err)
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(line)); err != nil || compact.String() != line {
+			t.Errorf("Line %d is pretty-printed: %s", i+1, line)
+		}
+	}
+}
+
+func TestSummaryWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewWriter(Config{Format: FormatSummary, Writer: &buf})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	report := &parser.AggregateReport{
+		ReportMetadata:  parser.ReportMetadata{OrgName: "google.com", ReportID: "report-1"},
+		PolicyPublished: parser.PolicyPublished{Domain: "example.com"},
+		Records: []parser.Record{
+			{
+				Source:          parser.Source{IPAddress: "198.51.100.7"},
+				Count:           4,
+				PolicyEvaluated: parser.PolicyEvaluated{Disposition: "quarantine"},
+			},
+			{
+				Source:          parser.Source{IPAddress: "192.0.2.1"},
+				Count:           3,
+				Alignment:       parser.Alignment{DKIM: true, SPF: true, DMARC: true},
+				PolicyEvaluated: parser.PolicyEvaluated{Disposition: "none"},
+			},
+			{
+				Source:          parser.Source{IPAddress: "192.0.2.1"},
+				Count:           2,
+				Alignment:       parser.Alignment{SPF: true, DMARC: true},
+				PolicyEvaluated: parser.PolicyEvaluated{Disposition: "none"},
+			},
+			{
+				Source:          parser.Source{IPAddress: "192.0.2.1"},
+				Count:           1,
+				PolicyEvaluated: parser.PolicyEvaluated{Disposition: "reject"},
+			},
+		},
+	}
+	if err := writer.WriteAggregateReport(report); err != nil {
+		t.Fatalf("WriteAggregateReport failed: %v", err)
+	}
+	if err := writer.WriteSMTPTLSReport(&parser.SMTPTLSReport{ReportID: "tls-1"}); err != nil {
+		t.Fatalf("WriteSMTPTLSReport failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var summary AggregateSummary
+	if err := json.Unmarshal(buf.Bytes(), &summary); err != nil {
+		t.Fatalf("Expected a single summary, got %v:\n%s", err, buf.String())
+	}
+	if summary.ReportID != "report-1" || summary.Domain != "example.com" || summary.Messages != 10 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	expected := []AggregateSummaryGroup{
+		{SourceIP: "192.0.2.1", Disposition: "none", Messages: 5, DMARCPass: 5, DKIMAligned: 3, SPFAligned: 5},
+		{SourceIP: "192.0.2.1", Disposition: "reject", Messages: 1, DMARCFail: 1},
+		{SourceIP: "198.51.100.7", Disposition: "quarantine", Messages: 4, DMARCFail: 4},
+	}
+	if !reflect.DeepEqual(summary.Groups, expected) {
+		t.Errorf("Expected groups %+v, got %+v", expected, summary.Groups)
+	}
+}
+
+func TestSummaryWriterDirectoryMode(t *testing.T) {
+	tempDir := t.TempDir()
+	writer, err := NewWriter(Config{Format: FormatSummary, File: tempDir, Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	report := &parser.AggregateReport{
+		ReportMetadata:  parser.ReportMetadata{OrgName: "google.com", ReportID: "report-1"},
+		PolicyPublished: parser.PolicyPublished{Domain: "example.com"},
+		Records: []parser.Record{
+			{
+				Source:          parser.Source{IPAddress: "192.0.2.1"},
+				Count:           3,
+				Alignment:       parser.Alignment{SPF: true, DMARC: true},
+				PolicyEvaluated: parser.PolicyEvaluated{Disposition: "none"},
+			},
+		},
+	}
+	if err := writer.WriteAggregateReport(report); err != nil {
+		t.Fatalf("WriteAggregateReport failed: %v", err)
+	}
+	if err := writer.WriteSMTPTLSReport(&parser.SMTPTLSReport{ReportID: "tls-1"}); err != nil {
+		t.Fatalf("WriteSMTPTLSReport failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Only the aggregate report is summarized
+	files, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read temp directory: %v", err)
+	}
+	if len(files) != 1 || !strings.HasPrefix(files[0].Name(), "aggregate_") {
+		t.Fatalf("Expected one aggregate summary file, got %d files", len(files))
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, files[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var summary AggregateSummary
+	if err := json.Unmarshal(content, &summary); err != nil {
+		t.Fatalf("Failed to parse summary: %v", err)
+	}
+	if summary.ReportID != "report-1" || summary.Messages != 3 || len(summary.Groups) != 1 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+}
+
 func TestNewWriter(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -200,6 +618,14 @@ func TestNewWriter(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "NDJSON to stdout",
+			config: Config{
+				Format: FormatNDJSON,
+				File:   "",
+			},
+			expectError: false,
+		},
 		{
 			name: "CSV to stdout",
 			config: Config{
@@ -517,6 +943,90 @@ func TestFileConcatenation(t *testing.T) {
 	}
 }
 
+func TestJSONWriterBufferedFlush(t *testing.T) {
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{
+			ReportID: "buffered-1",
+			OrgName:  "org1.com",
+		},
+	}
+
+	t.Run("Flush on interval", func(t *testing.T) {
+		tempFile := filepath.Join(t.TempDir(), "buffered.json")
+
+		writer, err := NewWriter(Config{
+			Format:        FormatJSON,
+			File:          tempFile,
+			FlushInterval: 50 * time.Millisecond,
+			Logger:        zap.NewNop(),
+		})
+		if err != nil {
+			t.Fatalf("NewWriter() error = %v", err)
+		}
+		defer writer.Close()
+
+		if err := writer.WriteAggregateReport(report); err != nil {
+			t.Fatalf("WriteAggregateReport failed: %v", err)
+		}
+
+		content, err := os.ReadFile(tempFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		if len(content) != 0 {
+			t.Errorf("Expected output to be buffered, found %d bytes on disk", len(content))
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			content, err = os.ReadFile(tempFile)
+			if err != nil {
+				t.Fatalf("Failed to read output file: %v", err)
+			}
+			if strings.Contains(string(content), "buffered-1") {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Buffered output was not flushed on interval")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("Flush on close", func(t *testing.T) {
+		tempFile := filepath.Join(t.TempDir(), "buffered.json")
+
+		writer, err := NewWriter(Config{
+			Format:        FormatJSON,
+			File:          tempFile,
+			FlushInterval: time.Hour,
+			Logger:        zap.NewNop(),
+		})
+		if err != nil {
+			t.Fatalf("NewWriter() error = %v", err)
+		}
+
+		if _, ok := writer.(Flusher); !ok {
+			t.Errorf("Expected %T to implement Flusher", writer)
+		}
+
+		if err := writer.WriteAggregateReport(report); err != nil {
+			t.Fatalf("WriteAggregateReport failed: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		content, err := os.ReadFile(tempFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		if !strings.Contains(string(content), "buffered-1") {
+			t.Error("Buffered output was not flushed on close")
+		}
+	})
+}
+
 func TestHelperFunctions(t *testing.T) {
 	// Test stringPtrToString
 	str := "test"
@@ -0,0 +1,227 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/xitongsys/parquet-go/writer"
+	"go.uber.org/zap"
+	"parsedmarc-go/internal/parser"
+)
+
+// parquetAggregateRow is one aggregate report record in Parquet output. The
+// columns are those of the CSV output, with dates as timestamps and numbers
+// and booleans in their own types.
+type parquetAggregateRow struct {
+	ReportID         string  `parquet:"name=report_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OrgName          string  `parquet:"name=org_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OrgEmail         string  `parquet:"name=org_email, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BeginDate        int64   `parquet:"name=begin_date, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	EndDate          int64   `parquet:"name=end_date, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Domain           string  `parquet:"name=domain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PolicyADKIM      string  `parquet:"name=policy_adkim, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PolicyASPF       string  `parquet:"name=policy_aspf, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PolicyP          string  `parquet:"name=policy_p, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PolicySP         string  `parquet:"name=policy_sp, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PolicyPCT        string  `parquet:"name=policy_pct, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceIP         string  `parquet:"name=source_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceCountry    string  `parquet:"name=source_country, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceCity       string  `parquet:"name=source_city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceLatitude   float64 `parquet:"name=source_latitude, type=DOUBLE"`
+	SourceLongitude  float64 `parquet:"name=source_longitude, type=DOUBLE"`
+	SourceASN        int64   `parquet:"name=source_asn, type=INT64"`
+	SourceASNOrg     string  `parquet:"name=source_asn_org, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceReverseDNS string  `parquet:"name=source_reverse_dns, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Count            int64   `parquet:"name=count, type=INT64"`
+	Disposition      string  `parquet:"name=disposition, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EffectivePolicy  string  `parquet:"name=effective_policy, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SampledOut       bool    `parquet:"name=sampled_out, type=BOOLEAN"`
+	DKIMResult       string  `parquet:"name=dkim_result, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SPFResult        string  `parquet:"name=spf_result, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DMARCAligned     bool    `parquet:"name=dmarc_aligned, type=BOOLEAN"`
+	HeaderFrom       string  `parquet:"name=header_from, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EnvelopeFrom     string  `parquet:"name=envelope_from, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DKIMDomain       string  `parquet:"name=dkim_domain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DKIMSelector     string  `parquet:"name=dkim_selector, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SPFDomain        string  `parquet:"name=spf_domain, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// ParquetWriter writes the records of aggregate reports to a Parquet file.
+// A Parquet file is only readable once its footer is written, so records are
+// buffered in memory and the file is written by Close. Forensic and SMTP TLS
+// reports are only passed on to the senders.
+type ParquetWriter struct {
+	file         string
+	rows         []parquetAggregateRow
+	mu           sync.Mutex
+	smtpSender   SMTPSender
+	kafkaSender  KafkaSender
+	syslogSender SyslogSender
+	logger       *zap.Logger
+}
+
+// newParquetWriter creates a Parquet writer for cfg, which must name a file
+func newParquetWriter(cfg Config) (*ParquetWriter, error) {
+	if cfg.File == "" || cfg.Writer != nil {
+		return nil, errors.New("parquet output requires an output file")
+	}
+	if stat, err := os.Stat(cfg.File); err == nil && stat.IsDir() {
+		return nil, fmt.Errorf("parquet output requires an output file, %s is a directory", cfg.File)
+	}
+
+	return &ParquetWriter{
+		file:         cfg.File,
+		smtpSender:   cfg.SMTPSender,
+		kafkaSender:  cfg.KafkaSender,
+		syslogSender: cfg.SyslogSender,
+		logger:       cfg.Logger,
+	}, nil
+}
+
+func (w *ParquetWriter) WriteAggregateReport(report *parser.AggregateReport) error {
+	w.mu.Lock()
+	for _, record := range report.Records {
+		w.rows = append(w.rows, parquetAggregateRow{
+			ReportID:         report.ReportMetadata.ReportID,
+			OrgName:          report.ReportMetadata.OrgName,
+			OrgEmail:         report.ReportMetadata.OrgEmail,
+			BeginDate:        report.ReportMetadata.BeginDate.UnixMilli(),
+			EndDate:          report.ReportMetadata.EndDate.UnixMilli(),
+			Domain:           report.PolicyPublished.Domain,
+			PolicyADKIM:      report.PolicyPublished.ADKIM,
+			PolicyASPF:       report.PolicyPublished.ASPF,
+			PolicyP:          report.PolicyPublished.P,
+			PolicySP:         report.PolicyPublished.SP,
+			PolicyPCT:        report.PolicyPublished.PCT,
+			SourceIP:         record.Source.IPAddress,
+			SourceCountry:    record.Source.Country,
+			SourceCity:       record.Source.City,
+			SourceLatitude:   record.Source.Latitude,
+			SourceLongitude:  record.Source.Longitude,
+			SourceASN:        int64(record.Source.ASN),
+			SourceASNOrg:     record.Source.ASNOrg,
+			SourceReverseDNS: record.Source.ReverseDNS,
+			Count:            int64(record.Count),
+			Disposition:      record.PolicyEvaluated.Disposition,
+			EffectivePolicy:  record.EffectivePolicy,
+			SampledOut:       record.SampledOut,
+			DKIMResult:       record.PolicyEvaluated.DKIM,
+			SPFResult:        record.PolicyEvaluated.SPF,
+			DMARCAligned:     record.Alignment.DMARC,
+			HeaderFrom:       record.Identifiers.HeaderFrom,
+			EnvelopeFrom:     stringPtrToString(record.Identifiers.EnvelopeFrom),
+			DKIMDomain:       getDKIMDomain(record.AuthResults.DKIM),
+			DKIMSelector:     getDKIMSelector(record.AuthResults.DKIM),
+			SPFDomain:        getSPFDomain(record.AuthResults.SPF),
+		})
+	}
+	w.mu.Unlock()
+
+	// Send via SMTP if configured
+	if w.smtpSender != nil {
+		if err := w.smtpSender.SendAggregateReport(report); err != nil {
+			w.logger.Error("Failed to send aggregate report via SMTP", zap.Error(err))
+		}
+	}
+
+	// Send via Kafka if configured
+	if w.kafkaSender != nil {
+		if err := w.kafkaSender.SendAggregateReport(report); err != nil {
+			w.logger.Error("Failed to send aggregate report via Kafka", zap.Error(err))
+		}
+	}
+
+	// Send via syslog if configured
+	if w.syslogSender != nil {
+		if err := w.syslogSender.SendAggregateReport(report); err != nil {
+			w.logger.Error("Failed to send aggregate report via syslog", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+func (w *ParquetWriter) WriteForensicReport(report *parser.ForensicReport) error {
+	// Send via SMTP if configured
+	if w.smtpSender != nil {
+		if err := w.smtpSender.SendForensicReport(report); err != nil {
+			w.logger.Error("Failed to send forensic report via SMTP", zap.Error(err))
+		}
+	}
+
+	// Send via Kafka if configured
+	if w.kafkaSender != nil {
+		if err := w.kafkaSender.SendForensicReport(report); err != nil {
+			w.logger.Error("Failed to send forensic report via Kafka", zap.Error(err))
+		}
+	}
+
+	// Send via syslog if configured
+	if w.syslogSender != nil {
+		if err := w.syslogSender.SendForensicReport(report); err != nil {
+			w.logger.Error("Failed to send forensic report via syslog", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+func (w *ParquetWriter) WriteSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	// Send via SMTP if configured
+	if w.smtpSender != nil {
+		if err := w.smtpSender.SendSMTPTLSReport(report); err != nil {
+			w.logger.Error("Failed to send SMTP TLS report via SMTP", zap.Error(err))
+		}
+	}
+
+	// Send via Kafka if configured
+	if w.kafkaSender != nil {
+		if err := w.kafkaSender.SendSMTPTLSReport(report); err != nil {
+			w.logger.Error("Failed to send SMTP TLS report via Kafka", zap.Error(err))
+		}
+	}
+
+	// Send via syslog if configured
+	if w.syslogSender != nil {
+		if err := w.syslogSender.SendSMTPTLSReport(report); err != nil {
+			w.logger.Error("Failed to send SMTP TLS report via syslog", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// Close writes the buffered records to the Parquet file, replacing it
+func (w *ParquetWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	file, err := os.Create(w.file)
+	if err != nil {
+		return fmt.Errorf("failed to create Parquet file %s: %w", w.file, err)
+	}
+
+	if err := w.writeRows(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write Parquet file %s: %w", w.file, err)
+	}
+	return file.Close()
+}
+
+func (w *ParquetWriter) writeRows(file *os.File) error {
+	pw, err := writer.NewParquetWriterFromWriter(file, new(parquetAggregateRow), 1)
+	if err != nil {
+		return err
+	}
+
+	for i := range w.rows {
+		if err := pw.Write(w.rows[i]); err != nil {
+			return err
+		}
+	}
+	w.rows = nil
+
+	return pw.WriteStop()
+}
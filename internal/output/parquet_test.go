@@ -0,0 +1,104 @@
+package output
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"go.uber.org/zap"
+	"parsedmarc-go/internal/parser"
+)
+
+func TestParquetWriter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "aggregate.parquet")
+	writer, err := NewWriter(Config{Format: FormatParquet, File: file, Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	envelopeFrom := "example.com"
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{
+			OrgName:   "google.com",
+			ReportID:  "report-1",
+			BeginDate: begin,
+			EndDate:   begin.Add(24*time.Hour - time.Second),
+		},
+		PolicyPublished: parser.PolicyPublished{Domain: "example.com", P: "reject", PCT: "100"},
+		Records: []parser.Record{
+			{
+				Source:      parser.Source{IPAddress: "192.0.2.1", Country: "US", Latitude: 37.751, ASN: 15169},
+				Count:       3,
+				Alignment:   parser.Alignment{DMARC: true},
+				Identifiers: parser.Identifiers{HeaderFrom: "example.com", EnvelopeFrom: &envelopeFrom},
+				AuthResults: parser.AuthResults{
+					DKIM: []parser.DKIMResult{{Domain: "example.com", Selector: "s1", Result: "pass"}},
+				},
+			},
+			{
+				Source: parser.Source{IPAddress: "198.51.100.7"},
+				Count:  1,
+			},
+		},
+	}
+	if err := writer.WriteAggregateReport(report); err != nil {
+		t.Fatalf("WriteAggregateReport failed: %v", err)
+	}
+	// Other report types have no place in the file
+	if err := writer.WriteSMTPTLSReport(&parser.SMTPTLSReport{ReportID: "tls-1"}); err != nil {
+		t.Fatalf("WriteSMTPTLSReport failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	source, err := local.NewLocalFileReader(file)
+	if err != nil {
+		t.Fatalf("Failed to open Parquet file: %v", err)
+	}
+	defer source.Close()
+	pr, err := reader.NewParquetReader(source, new(parquetAggregateRow), 1)
+	if err != nil {
+		t.Fatalf("Failed to read Parquet file: %v", err)
+	}
+	defer pr.ReadStop()
+
+	rows := make([]parquetAggregateRow, pr.GetNumRows())
+	if err := pr.Read(&rows); err != nil {
+		t.Fatalf("Failed to read rows: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+
+	row := rows[0]
+	if row.ReportID != "report-1" || row.OrgName != "google.com" || row.Domain != "example.com" || row.PolicyP != "reject" {
+		t.Errorf("Unexpected report columns: %+v", row)
+	}
+	if row.BeginDate != begin.UnixMilli() {
+		t.Errorf("Expected begin_date %d, got %d", begin.UnixMilli(), row.BeginDate)
+	}
+	if row.SourceIP != "192.0.2.1" || row.SourceLatitude != 37.751 || row.SourceASN != 15169 || row.Count != 3 {
+		t.Errorf("Unexpected source columns: %+v", row)
+	}
+	if !row.DMARCAligned || row.EnvelopeFrom != "example.com" || row.DKIMSelector != "s1" {
+		t.Errorf("Unexpected result columns: %+v", row)
+	}
+	if rows[1].SourceIP != "198.51.100.7" || rows[1].DMARCAligned {
+		t.Errorf("Unexpected second row: %+v", rows[1])
+	}
+}
+
+func TestParquetWriterRequiresFile(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"stdout":    {Format: FormatParquet},
+		"directory": {Format: FormatParquet, File: t.TempDir()},
+	} {
+		if _, err := NewWriter(cfg); err == nil {
+			t.Errorf("Expected an error for Parquet output to %s", name)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package output
+
+import (
+	"encoding/json"
+	"time"
+
+	"parsedmarc-go/internal/parser"
+)
+
+// parsedmarcDateLayout is the layout of the report dates in the upstream
+// parsedmarc JSON output
+const parsedmarcDateLayout = "2006-01-02 15:04:05"
+
+// The parsedmarc* types mirror the JSON documents of the upstream Python
+// parsedmarc, so that Elasticsearch templates and dashboards built for it
+// work unchanged. Fields upstream does not know about (geolocation, ASN,
+// fingerprints, parse info) are left out and empty strings become null.
+
+type parsedmarcAggregateReport struct {
+	XMLSchema       string                    `json:"xml_schema"`
+	ReportMetadata  parsedmarcReportMetadata  `json:"report_metadata"`
+	PolicyPublished parsedmarcPolicyPublished `json:"policy_published"`
+	Records         []parsedmarcRecord        `json:"records"`
+}
+
+type parsedmarcReportMetadata struct {
+	OrgName             string   `json:"org_name"`
+	OrgEmail            string   `json:"org_email"`
+	OrgExtraContactInfo *string  `json:"org_extra_contact_info"`
+	ReportID            string   `json:"report_id"`
+	BeginDate           string   `json:"begin_date"`
+	EndDate             string   `json:"end_date"`
+	Errors              []string `json:"errors"`
+}
+
+type parsedmarcPolicyPublished struct {
+	Domain string `json:"domain"`
+	ADKIM  string `json:"adkim"`
+	ASPF   string `json:"aspf"`
+	P      string `json:"p"`
+	SP     string `json:"sp"`
+	PCT    string `json:"pct"`
+	FO     string `json:"fo"`
+}
+
+type parsedmarcRecord struct {
+	Source          parsedmarcSource          `json:"source"`
+	Count           int                       `json:"count"`
+	Alignment       parser.Alignment          `json:"alignment"`
+	PolicyEvaluated parsedmarcPolicyEvaluated `json:"policy_evaluated"`
+	Identifiers     parser.Identifiers        `json:"identifiers"`
+	AuthResults     parsedmarcAuthResults     `json:"auth_results"`
+}
+
+type parsedmarcSource struct {
+	IPAddress  string  `json:"ip_address"`
+	Country    *string `json:"country"`
+	ReverseDNS *string `json:"reverse_dns"`
+	BaseDomain *string `json:"base_domain"`
+	Name       *string `json:"name"`
+	Type       *string `json:"type"`
+}
+
+type parsedmarcPolicyEvaluated struct {
+	Disposition           string                        `json:"disposition"`
+	DKIM                  string                        `json:"dkim"`
+	SPF                   string                        `json:"spf"`
+	PolicyOverrideReasons []parser.PolicyOverrideReason `json:"policy_override_reasons"`
+}
+
+type parsedmarcAuthResults struct {
+	DKIM []parser.DKIMResult `json:"dkim"`
+	SPF  []parser.SPFResult  `json:"spf"`
+}
+
+type parsedmarcForensicReport struct {
+	FeedbackType             string           `json:"feedback_type"`
+	UserAgent                *string          `json:"user_agent"`
+	Version                  *string          `json:"version"`
+	OriginalEnvelopeID       *string          `json:"original_envelope_id"`
+	OriginalMailFrom         *string          `json:"original_mail_from"`
+	OriginalRcptTo           *string          `json:"original_rcpt_to"`
+	ArrivalDate              string           `json:"arrival_date"`
+	ArrivalDateUTC           string           `json:"arrival_date_utc"`
+	Subject                  *string          `json:"subject"`
+	MessageID                *string          `json:"message_id"`
+	AuthenticationResults    *string          `json:"authentication_results"`
+	DeliveryResult           string           `json:"delivery_result"`
+	AuthFailure              []string         `json:"auth_failure"`
+	ReportedDomain           string           `json:"reported_domain"`
+	AuthenticationMechanisms []string         `json:"authentication_mechanisms"`
+	DKIMDomain               *string          `json:"dkim_domain"`
+	Source                   parsedmarcSource `json:"source"`
+	SampleHeadersOnly        bool             `json:"sample_headers_only"`
+	Sample                   string           `json:"sample"`
+	ParsedSample             json.RawMessage  `json:"parsed_sample"`
+}
+
+type parsedmarcSMTPTLSReport struct {
+	OrganizationName string                    `json:"organization_name"`
+	BeginDate        string                    `json:"begin_date"`
+	EndDate          string                    `json:"end_date"`
+	ContactInfo      *string                   `json:"contact_info"`
+	ReportID         string                    `json:"report_id"`
+	Policies         []parsedmarcSMTPTLSPolicy `json:"policies"`
+}
+
+type parsedmarcSMTPTLSPolicy struct {
+	PolicyDomain           string                         `json:"policy_domain"`
+	PolicyType             string                         `json:"policy_type"`
+	PolicyStrings          []string                       `json:"policy_strings,omitempty"`
+	MXHostPatterns         []string                       `json:"mx_host_patterns,omitempty"`
+	SuccessfulSessionCount int                            `json:"successful_session_count"`
+	FailedSessionCount     int                            `json:"failed_session_count"`
+	FailureDetails         []parser.SMTPTLSFailureDetails `json:"failure_details,omitempty"`
+}
+
+// aggregateJSON returns the value to marshal for report in JSON output, with
+// its dates in loc and in the upstream parsedmarc schema if requested
+func aggregateJSON(report *parser.AggregateReport, loc *time.Location, parsedmarc bool) any {
+	report = aggregateInTimezone(report, loc)
+	if parsedmarc {
+		return toParsedmarcAggregate(report)
+	}
+	return report
+}
+
+// forensicJSON is aggregateJSON for forensic reports
+func forensicJSON(report *parser.ForensicReport, loc *time.Location, parsedmarc bool) any {
+	report = forensicInTimezone(report, loc)
+	if parsedmarc {
+		return toParsedmarcForensic(report)
+	}
+	return report
+}
+
+// smtpTLSJSON is aggregateJSON for SMTP TLS reports
+func smtpTLSJSON(report *parser.SMTPTLSReport, loc *time.Location, parsedmarc bool) any {
+	report = smtpTLSInTimezone(report, loc)
+	if parsedmarc {
+		return toParsedmarcSMTPTLS(report)
+	}
+	return report
+}
+
+func toParsedmarcAggregate(report *parser.AggregateReport) *parsedmarcAggregateReport {
+	metadata := report.ReportMetadata
+	policy := report.PolicyPublished
+
+	converted := &parsedmarcAggregateReport{
+		XMLSchema: report.XMLSchema,
+		ReportMetadata: parsedmarcReportMetadata{
+			OrgName:             metadata.OrgName,
+			OrgEmail:            metadata.OrgEmail,
+			OrgExtraContactInfo: metadata.OrgExtraContactInfo,
+			ReportID:            metadata.ReportID,
+			BeginDate:           metadata.BeginDate.Format(parsedmarcDateLayout),
+			EndDate:             metadata.EndDate.Format(parsedmarcDateLayout),
+			Errors:              nonNil(metadata.Errors),
+		},
+		PolicyPublished: parsedmarcPolicyPublished{
+			Domain: policy.Domain,
+			ADKIM:  policy.ADKIM,
+			ASPF:   policy.ASPF,
+			P:      policy.P,
+			SP:     policy.SP,
+			PCT:    policy.PCT,
+			FO:     policy.FO,
+		},
+		Records: make([]parsedmarcRecord, 0, len(report.Records)),
+	}
+
+	for _, record := range report.Records {
+		converted.Records = append(converted.Records, parsedmarcRecord{
+			Source:    toParsedmarcSource(record.Source),
+			Count:     record.Count,
+			Alignment: record.Alignment,
+			PolicyEvaluated: parsedmarcPolicyEvaluated{
+				Disposition:           record.PolicyEvaluated.Disposition,
+				DKIM:                  record.PolicyEvaluated.DKIM,
+				SPF:                   record.PolicyEvaluated.SPF,
+				PolicyOverrideReasons: nonNil(record.PolicyEvaluated.PolicyOverrideReasons),
+			},
+			Identifiers: record.Identifiers,
+			AuthResults: parsedmarcAuthResults{
+				DKIM: nonNil(record.AuthResults.DKIM),
+				SPF:  nonNil(record.AuthResults.SPF),
+			},
+		})
+	}
+
+	return converted
+}
+
+func toParsedmarcForensic(report *parser.ForensicReport) *parsedmarcForensicReport {
+	return &parsedmarcForensicReport{
+		FeedbackType:             report.FeedbackType,
+		UserAgent:                report.UserAgent,
+		Version:                  report.Version,
+		OriginalEnvelopeID:       report.OriginalEnvelopeID,
+		OriginalMailFrom:         report.OriginalMailFrom,
+		OriginalRcptTo:           report.OriginalRcptTo,
+		ArrivalDate:              report.ArrivalDate.Format(time.RFC1123Z),
+		ArrivalDateUTC:           report.ArrivalDateUTC.UTC().Format(parsedmarcDateLayout),
+		Subject:                  nullString(report.Subject),
+		MessageID:                nullString(report.MessageID),
+		AuthenticationResults:    nullString(report.AuthenticationResults),
+		DeliveryResult:           report.DeliveryResult,
+		AuthFailure:              nonNil(report.AuthFailure),
+		ReportedDomain:           report.ReportedDomain,
+		AuthenticationMechanisms: nonNil(report.AuthenticationMechanisms),
+		DKIMDomain:               report.DKIMDomain,
+		Source:                   toParsedmarcSource(report.Source),
+		SampleHeadersOnly:        report.SampleHeadersOnly,
+		Sample:                   report.Sample,
+		ParsedSample:             report.ParsedSample,
+	}
+}
+
+func toParsedmarcSMTPTLS(report *parser.SMTPTLSReport) *parsedmarcSMTPTLSReport {
+	converted := &parsedmarcSMTPTLSReport{
+		OrganizationName: report.OrganizationName,
+		BeginDate:        report.BeginDate.Format(time.RFC3339),
+		EndDate:          report.EndDate.Format(time.RFC3339),
+		ContactInfo:      nullString(report.ContactInfo),
+		ReportID:         report.ReportID,
+		Policies:         make([]parsedmarcSMTPTLSPolicy, 0, len(report.Policies)),
+	}
+
+	for _, policy := range report.Policies {
+		converted.Policies = append(converted.Policies, parsedmarcSMTPTLSPolicy{
+			PolicyDomain:           policy.PolicyDomain,
+			PolicyType:             policy.PolicyType,
+			PolicyStrings:          policy.PolicyStrings,
+			MXHostPatterns:         policy.MXHostPatterns,
+			SuccessfulSessionCount: policy.SuccessfulSessionCount,
+			FailedSessionCount:     policy.FailedSessionCount,
+			FailureDetails:         policy.FailureDetails,
+		})
+	}
+
+	return converted
+}
+
+func toParsedmarcSource(source parser.Source) parsedmarcSource {
+	return parsedmarcSource{
+		IPAddress:  source.IPAddress,
+		Country:    nullString(source.Country),
+		ReverseDNS: nullString(source.ReverseDNS),
+		BaseDomain: nullString(source.BaseDomain),
+		Name:       nullString(source.Name),
+		Type:       nullString(source.Type),
+	}
+}
+
+// nullString returns nil for an empty s, which upstream writes as null
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nonNil returns s, or an empty slice written as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+package output
+
+import (
+	"sort"
+	"time"
+
+	"parsedmarc-go/internal/parser"
+)
+
+// AggregateSummary is the rolled-up view of an aggregate report: its message
+// counts grouped by source IP and disposition
+type AggregateSummary struct {
+	ReportID  string                  `json:"report_id"`
+	OrgName   string                  `json:"org_name"`
+	Domain    string                  `json:"domain"`
+	BeginDate time.Time               `json:"begin_date"`
+	EndDate   time.Time               `json:"end_date"`
+	Messages  int                     `json:"messages"`
+	Groups    []AggregateSummaryGroup `json:"groups"`
+}
+
+// AggregateSummaryGroup counts the messages of one source IP that received
+// one disposition, and how many of them were aligned
+type AggregateSummaryGroup struct {
+	SourceIP    string `json:"source_ip"`
+	Disposition string `json:"disposition"`
+	Messages    int    `json:"messages"`
+	DMARCPass   int    `json:"dmarc_pass"`
+	DMARCFail   int    `json:"dmarc_fail"`
+	DKIMAligned int    `json:"dkim_aligned"`
+	SPFAligned  int    `json:"spf_aligned"`
+}
+
+// SummarizeAggregateReport groups the records of report by source IP and
+// disposition. Groups are ordered by source IP, then disposition.
+func SummarizeAggregateReport(report *parser.AggregateReport) *AggregateSummary {
+	type groupKey struct {
+		sourceIP    string
+		disposition string
+	}
+
+	summary := &AggregateSummary{
+		ReportID:  report.ReportMetadata.ReportID,
+		OrgName:   report.ReportMetadata.OrgName,
+		Domain:    report.PolicyPublished.Domain,
+		BeginDate: report.ReportMetadata.BeginDate,
+		EndDate:   report.ReportMetadata.EndDate,
+		Groups:    []AggregateSummaryGroup{},
+	}
+
+	groups := make(map[groupKey]*AggregateSummaryGroup)
+	var keys []groupKey
+	for _, record := range report.Records {
+		key := groupKey{record.Source.IPAddress, record.PolicyEvaluated.Disposition}
+		group, ok := groups[key]
+		if !ok {
+			group = &AggregateSummaryGroup{SourceIP: key.sourceIP, Disposition: key.disposition}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+
+		group.Messages += record.Count
+		if record.Alignment.DMARC {
+			group.DMARCPass += record.Count
+		} else {
+			group.DMARCFail += record.Count
+		}
+		if record.Alignment.DKIM {
+			group.DKIMAligned += record.Count
+		}
+		if record.Alignment.SPF {
+			group.SPFAligned += record.Count
+		}
+		summary.Messages += record.Count
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].sourceIP != keys[j].sourceIP {
+			return keys[i].sourceIP < keys[j].sourceIP
+		}
+		return keys[i].disposition < keys[j].disposition
+	})
+	for _, key := range keys {
+		summary.Groups = append(summary.Groups, *groups[key])
+	}
+
+	return summary
+}
@@ -0,0 +1,200 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrBatchStorageClosed is returned when a report is stored in a closed
+// BatchStorage
+var ErrBatchStorageClosed = errors.New("batch storage is closed")
+
+// AggregateBatchStore is implemented by storages that can store several
+// aggregate reports at once, see BatchStorage
+type AggregateBatchStore interface {
+	StoreAggregateReports(reports []*AggregateReport) error
+}
+
+// BatchStorage queues reports in a buffered channel and stores them in the
+// wrapped storage from a background goroutine, in batches of up to size
+// reports or every interval, whichever comes first. Store methods return as
+// soon as the report is queued, blocking only while the queue is full;
+// storage errors are logged. Duplicate lookups and queries go to the wrapped
+// storage and do not see reports still queued.
+type BatchStorage struct {
+	storage  Storage
+	size     int
+	interval time.Duration
+	logger   *zap.Logger
+
+	queue chan any
+	done  chan struct{}
+
+	// mu guards closed; enqueue holds it for reading so that Close never
+	// closes the queue during a send
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewBatchStorage creates a batch storage in front of storage and starts its
+// background flusher. queueSize is the number of reports that can be queued
+// before Store methods block.
+func NewBatchStorage(storage Storage, size int, interval time.Duration, queueSize int, logger *zap.Logger) *BatchStorage {
+	b := &BatchStorage{
+		storage:  storage,
+		size:     size,
+		interval: interval,
+		logger:   logger,
+		queue:    make(chan any, queueSize),
+		done:     make(chan struct{}),
+	}
+
+	go b.run()
+
+	return b
+}
+
+// StoreAggregateReport queues an aggregate report
+func (b *BatchStorage) StoreAggregateReport(report *AggregateReport) error {
+	return b.enqueue(report)
+}
+
+// StoreForensicReport queues a forensic report
+func (b *BatchStorage) StoreForensicReport(report *ForensicReport) error {
+	return b.enqueue(report)
+}
+
+// StoreSMTPTLSReport queues an SMTP TLS report
+func (b *BatchStorage) StoreSMTPTLSReport(report *SMTPTLSReport) error {
+	return b.enqueue(report)
+}
+
+// Close stops accepting reports and returns once every queued report has
+// been stored. The wrapped storage is left open.
+func (b *BatchStorage) Close() error {
+	return b.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting reports like Close, but returns the error of ctx
+// when it is done before every queued report has been stored. The remaining
+// reports are still stored in the background.
+func (b *BatchStorage) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("reports still queued: %w", ctx.Err())
+	}
+}
+
+func (b *BatchStorage) enqueue(report any) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBatchStorageClosed
+	}
+	b.queue <- report
+	return nil
+}
+
+// run collects queued reports into batches until the queue is closed and
+// drained
+func (b *BatchStorage) run() {
+	defer close(b.done)
+
+	// Without an interval, batches are only flushed when full or on close
+	var tick <-chan time.Time
+	if b.interval > 0 {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	batch := make([]any, 0, b.size)
+	for {
+		select {
+		case report, ok := <-b.queue:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, report)
+			if len(batch) >= b.size {
+				b.flush(batch)
+				batch = batch[:0]
+			}
+		case <-tick:
+			b.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush stores one batch. Aggregate reports are stored with a single call
+// when the wrapped storage implements AggregateBatchStore.
+func (b *BatchStorage) flush(batch []any) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var aggregateReports []*AggregateReport
+	for _, report := range batch {
+		var err error
+		switch r := report.(type) {
+		case *AggregateReport:
+			aggregateReports = append(aggregateReports, r)
+			continue
+		case *ForensicReport:
+			err = b.storage.StoreForensicReport(r)
+		case *SMTPTLSReport:
+			err = b.storage.StoreSMTPTLSReport(r)
+		}
+		if err != nil {
+			b.logFailure("Failed to store queued report", err)
+		}
+	}
+
+	if batchStore, ok := b.storage.(AggregateBatchStore); ok && len(aggregateReports) > 0 {
+		if err := batchStore.StoreAggregateReports(aggregateReports); err != nil {
+			b.logger.Error("Failed to store batch of aggregate reports",
+				zap.Int("reports", len(aggregateReports)),
+				zap.Error(err),
+			)
+		}
+	} else {
+		for _, report := range aggregateReports {
+			if err := b.storage.StoreAggregateReport(report); err != nil {
+				b.logFailure("Failed to store queued aggregate report", err,
+					zap.String("org", report.ReportMetadata.OrgName),
+					zap.String("report_id", report.ReportMetadata.ReportID),
+				)
+			}
+		}
+	}
+
+	b.logger.Debug("Flushed report batch", zap.Int("reports", len(batch)))
+}
+
+// logFailure logs an error of storing queued reports. Reports the storage
+// skipped as duplicates are logged at debug level.
+func (b *BatchStorage) logFailure(msg string, err error, fields ...zap.Field) {
+	fields = append(fields, zap.Error(err))
+	if errors.Is(err, ErrDuplicateReport) {
+		b.logger.Debug(msg, fields...)
+		return
+	}
+	b.logger.Error(msg, fields...)
+}
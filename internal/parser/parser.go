@@ -2,42 +2,243 @@ package parser
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"golang.org/x/net/html/charset"
 	"parsedmarc-go/internal/config"
 	"parsedmarc-go/internal/metrics"
 	"parsedmarc-go/internal/utils"
 )
 
+// errNoFeedbackReport is returned when an email contains no
+// message/feedback-report part
+var errNoFeedbackReport = errors.New("no feedback report found")
+
+// ErrStorage is matched by errors of reports that were parsed but could not
+// be stored, which may succeed when retried
+var ErrStorage = errors.New("storage error")
+
+// storageError wraps an error of the storage backend so that it matches
+// ErrStorage while keeping its message
+type storageError struct {
+	err error
+}
+
+func (e *storageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *storageError) Unwrap() error {
+	return e.err
+}
+
+func (e *storageError) Is(target error) bool {
+	return target == ErrStorage
+}
+
+// ErrDuplicateReport is returned in WriteModeCreate when a report with the
+// same report ID, or the same fingerprint, has already been stored. Storages
+// that skip reports already stored return it too.
+var ErrDuplicateReport = errors.New("report already exists")
+
+// ErrQueryNotSupported is returned by QueryAggregateReports when the storage
+// cannot read reports back
+var ErrQueryNotSupported = errors.New("storage does not support querying reports")
+
+// ErrUntrustedOrg is returned for aggregate reports from a reporting
+// organization outside trusted_orgs
+var ErrUntrustedOrg = errors.New("report is not from a trusted organization")
+
+// errReportTooOld marks reports skipped because of max_report_age
+var errReportTooOld = errors.New("report is older than max_report_age")
+
+// Enrichment and DNS lookups, replaceable in tests
+var (
+	getGeoLocation = utils.GetGeoLocation
+	getASN         = utils.GetASN
+	getReverseDNS  = utils.GetReverseDNS
+	getDMARCRecord = utils.GetDMARCRecord
+)
+
 // Parser handles DMARC report parsing
 type Parser struct {
 	config  config.ParserConfig
 	storage Storage
 	logger  *zap.Logger
 	metrics *metrics.ParserMetrics
+
+	// auditLogger receives one record per ingestion attempt when set
+	auditLogger *zap.Logger
+
+	// teeWriter receives a copy of every parsed report when set; teeMu
+	// serializes writes from concurrent ingestion paths
+	teeWriter ReportWriter
+	teeMu     *sync.Mutex
+
+	// dnsCache holds reverse DNS results across reports; nil when
+	// dns_cache_size is 0
+	dnsCache *utils.DNSCache
+
+	// reverseDNSMap names the service behind reverse DNS base domains
+	reverseDNSMap utils.ReverseDNSMap
+
+	// version is stored with each report when store_parse_metadata is set
+	version string
+
+	// processors post-process every report before it is stored
+	processors []ReportProcessor
+}
+
+// New creates a new parser instance. Metrics are registered with registry,
+// or with the global default registerer when registry is nil. processors run,
+// in order, on every report before it is stored.
+func New(config config.ParserConfig, storage Storage, logger *zap.Logger, registry *prometheus.Registry, processors ...ReportProcessor) *Parser {
+	p := &Parser{
+		config:     config,
+		storage:    storage,
+		logger:     logger,
+		metrics:    metrics.NewParserMetrics(registry),
+		teeMu:      new(sync.Mutex),
+		processors: processors,
+	}
+	if config.DNSCacheSize > 0 {
+		p.dnsCache = utils.NewDNSCache(config.DNSCacheSize, config.DNSCacheTTL)
+	}
+	p.loadReverseDNSMap()
+
+	// Expose every monitored domain, failing or not
+	if config.DomainFailureMetrics {
+		for _, domain := range config.MonitoredDomains {
+			p.metrics.AddDomainFailingMessages(utils.NormalizeDomain(domain), 0)
+		}
+	}
+	return p
+}
+
+// loadReverseDNSMap downloads reverse_dns_map_url, unless
+// always_use_local_files or offline is set, and otherwise or when the download
+// fails reads reverse_dns_map_path. Sources are named after their reverse DNS
+// hostname when no map is loaded.
+func (p *Parser) loadReverseDNSMap() {
+	url, path := p.config.ReverseDNSMapURL, p.config.ReverseDNSMapPath
+
+	if url != "" && !p.config.AlwaysUseLocalFiles && !p.config.Offline {
+		m, err := utils.DownloadReverseDNSMap(url)
+		if err == nil {
+			p.reverseDNSMap = m
+			p.logger.Info("Downloaded reverse DNS map", zap.String("url", url), zap.Int("base_domains", len(m)))
+			return
+		}
+		p.logger.Warn("Failed to download reverse DNS map", zap.String("url", url), zap.Error(err))
+	}
+
+	if path == "" {
+		return
+	}
+	m, err := utils.ReadReverseDNSMapFile(path)
+	if err != nil {
+		p.logger.Warn("Failed to read reverse DNS map", zap.String("path", path), zap.Error(err))
+		return
+	}
+	p.reverseDNSMap = m
+	p.logger.Info("Loaded reverse DNS map", zap.String("path", path), zap.Int("base_domains", len(m)))
+}
+
+// SetAuditLogger enables audit logging: every ingestion attempt, successful
+// or not, is written to logger as one structured record
+func (p *Parser) SetAuditLogger(logger *zap.Logger) {
+	p.auditLogger = logger
 }
 
-// New creates a new parser instance
-func New(config config.ParserConfig, storage Storage, logger *zap.Logger) *Parser {
+// SetTeeWriter copies every successfully parsed report to w, in addition to
+// storing it, for live debugging of daemon mode or output sinks such as
+// Elasticsearch.
+func (p *Parser) SetTeeWriter(w ReportWriter) {
+	if p.teeMu == nil {
+		p.teeMu = new(sync.Mutex)
+	}
+	p.teeWriter = w
+}
+
+// SetVersion sets the parser version stored with each report when
+// store_parse_metadata is enabled
+func (p *Parser) SetVersion(version string) {
+	p.version = version
+}
+
+// Storage returns the storage parsed reports are written to
+func (p *Parser) Storage() Storage {
+	return p.storage
+}
+
+// WithStorage returns a parser writing reports to storage that shares
+// everything else with p: configuration, metrics, DNS cache, audit logger, tee
+// writer and report processors. It lets one ingestion path store through a
+// wrapper such as BatchStorage.
+func (p *Parser) WithStorage(storage Storage) *Parser {
 	return &Parser{
-		config:  config,
-		storage: storage,
-		logger:  logger,
-		metrics: metrics.NewParserMetrics(),
+		config:        p.config,
+		storage:       storage,
+		logger:        p.logger,
+		metrics:       p.metrics,
+		auditLogger:   p.auditLogger,
+		teeWriter:     p.teeWriter,
+		teeMu:         p.teeMu,
+		dnsCache:      p.dnsCache,
+		reverseDNSMap: p.reverseDNSMap,
+		version:       p.version,
+		processors:    p.processors,
+	}
+}
+
+// backingStorage returns the storage reports end up in, looking through a
+// BatchStorage, for the optional lookup interfaces
+func (p *Parser) backingStorage() Storage {
+	if batch, ok := p.storage.(*BatchStorage); ok {
+		return batch.storage
+	}
+	return p.storage
+}
+
+// FlushStorage inserts the rows buffered by the storage, if it implements
+// Flusher, so that the reports stored so far are durable. Sources call it
+// before acknowledging reports, e.g. marking an IMAP message seen. Errors
+// match ErrStorage.
+func (p *Parser) FlushStorage() error {
+	flusher, ok := p.backingStorage().(Flusher)
+	if !ok {
+		return nil
 	}
+	if err := flusher.Flush(); err != nil {
+		return fmt.Errorf("failed to flush storage: %w", &storageError{err})
+	}
+	return nil
 }
 
 // ParseFile parses a single file or directory of DMARC reports
@@ -56,1242 +257,3086 @@ func (p *Parser) ParseFile(path string) error {
 
 // ParseData parses DMARC report data from byte slice
 func (p *Parser) ParseData(data []byte) error {
-	return p.parseDataWithSource(data, "http")
+	_, err := p.parseDataWithSource(data, SourceHTTP, WriteModeAppend, "")
+	return err
+}
+
+// ParseDataWithHint parses DMARC report data like ParseData, trying the
+// parser of reportType (ReportTypeAggregate, ReportTypeForensic or
+// ReportTypeSMTPTLS) first. An empty hint keeps the default order.
+func (p *Parser) ParseDataWithHint(data []byte, reportType string) error {
+	_, err := p.parseDataWithSource(data, SourceHTTP, WriteModeAppend, reportType)
+	return err
+}
+
+// ParseDataFromSource parses DMARC report data like ParseDataWithHint,
+// received through the source transport (SourceHTTP, SourceIMAP, SourceKafka
+// or SourceFile)
+func (p *Parser) ParseDataFromSource(data []byte, source, reportType string) error {
+	_, err := p.parseDataWithSource(data, source, WriteModeAppend, reportType)
+	return err
+}
+
+// ParseDataWithMode parses DMARC report data from byte slice, storing it
+// according to mode. In WriteModeCreate a duplicate report yields an error
+// wrapping ErrDuplicateReport.
+func (p *Parser) ParseDataWithMode(data []byte, mode WriteMode) error {
+	_, err := p.parseDataWithSource(data, SourceHTTP, mode, "")
+	return err
+}
+
+// ParseDataResult parses DMARC report data like ParseDataWithMode and
+// describes the reports found. For an archive holding several reports the
+// result lists those that were parsed even when others failed.
+func (p *Parser) ParseDataResult(data []byte, mode WriteMode) (*ParseResult, error) {
+	return p.parseDataWithSource(data, SourceHTTP, mode, "")
 }
 
-// parseDataWithSource parses DMARC report data with source tracking
-func (p *Parser) parseDataWithSource(data []byte, source string) error {
+// parseDataWithSource parses DMARC report data with source tracking. hint is
+// the report type to try first, if any.
+func (p *Parser) parseDataWithSource(data []byte, source string, mode WriteMode, hint string) (*ParseResult, error) {
 	start := time.Now()
 	size := len(data)
+	result := &ParseResult{}
 
 	p.logger.Debug("Parsing data", zap.Int("size", size), zap.String("source", source))
 
-	// Extract content if compressed
-	extractedData, err := p.extractReportData(data)
+	// Extract content if compressed; a ZIP archive may hold several reports
+	files, err := p.ExtractReportFiles(data)
 	if err != nil {
 		duration := time.Since(start).Seconds()
 		if p.metrics != nil {
 			p.metrics.RecordParseFailure("unknown", source, "extraction_failed", duration, size)
 		}
-		return fmt.Errorf("failed to extract report data: %w", err)
+		err = fmt.Errorf("failed to extract report data: %w", err)
+		p.storeUnparsed(data, source, "extraction_failed", err)
+		p.audit(source, "unknown", "", "", size, err)
+		return result, err
 	}
 
-	// Try to parse as different report types and collect errors
-	var parseErrors []string
+	if len(files) == 1 {
+		report, err := p.parseExtractedData(data, files[0], source, start, size, mode, hint)
+		if err != nil {
+			return result, err
+		}
+		result.Reports = append(result.Reports, report)
+		return result, nil
+	}
 
-	if err := p.parseAsAggregateReportWithMetrics(extractedData, source, start, size); err == nil {
-		return nil
-	} else {
-		parseErrors = append(parseErrors, fmt.Sprintf("aggregate: %v", err))
+	p.logger.Debug("Parsing archive with several reports",
+		zap.Int("reports", len(files)),
+		zap.String("source", source),
+	)
+
+	var errs []error
+	for i, extractedData := range files {
+		report, err := p.parseExtractedData(data, extractedData, source, start, size, mode, hint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("report %d of %d: %w", i+1, len(files), err))
+			continue
+		}
+		result.Reports = append(result.Reports, report)
 	}
 
-	if err := p.parseAsForensicReportWithMetrics(extractedData, source, start, size); err == nil {
-		return nil
-	} else {
-		parseErrors = append(parseErrors, fmt.Sprintf("forensic: %v", err))
+	return result, errors.Join(errs...)
+}
+
+// parseExtractedData parses one extracted report, trying each report type in
+// turn, starting with hint. data is the original input, kept for unparsed
+// report metadata.
+func (p *Parser) parseExtractedData(data, extractedData []byte, source string, start time.Time, size int, mode WriteMode, hint string) (ReportResult, error) {
+	// Try to parse as different report types and collect errors
+	var aggregateErr, forensicErr, smtpTLSErr error
+	for _, reportType := range parseOrder(hint) {
+		p.logger.Debug("Trying report type", zap.String("type", reportType))
+
+		switch reportType {
+		case ReportTypeAggregate:
+			var result ReportResult
+			result, aggregateErr = p.parseAsAggregateReportWithMetrics(extractedData, source, start, size, mode)
+			if parseFinal(aggregateErr) {
+				return result, aggregateErr
+			}
+		case ReportTypeForensic:
+			var result ReportResult
+			result, forensicErr = p.parseAsForensicReportWithMetrics(extractedData, source, start, size)
+			if parseFinal(forensicErr) {
+				return result, forensicErr
+			}
+		case ReportTypeSMTPTLS:
+			var result ReportResult
+			result, smtpTLSErr = p.parseAsSMTPTLSReportWithMetrics(extractedData, source, start, size, mode)
+			if parseFinal(smtpTLSErr) {
+				return result, smtpTLSErr
+			}
+		}
 	}
 
-	if err := p.parseAsSMTPTLSReportWithMetrics(extractedData, source, start, size); err == nil {
-		return nil
-	} else {
-		parseErrors = append(parseErrors, fmt.Sprintf("smtp_tls: %v", err))
+	parseErrors := []string{
+		fmt.Sprintf("aggregate: %v", aggregateErr),
+		fmt.Sprintf("forensic: %v", forensicErr),
+		fmt.Sprintf("smtp_tls: %v", smtpTLSErr),
 	}
 
 	duration := time.Since(start).Seconds()
+	reason := parseFailureReason(extractedData, aggregateErr, forensicErr, smtpTLSErr)
 	if p.metrics != nil {
-		p.metrics.RecordParseFailure("unknown", source, "unknown_format", duration, size)
+		p.metrics.RecordParseFailure("unknown", source, reason, duration, size)
 	}
 
 	// Log detailed parsing errors
 	p.logger.Debug("Detailed parsing errors",
 		zap.Strings("errors", parseErrors),
+		zap.String("reason", reason),
 		zap.String("source", source),
 	)
 
-	return fmt.Errorf("unable to parse data as any known DMARC report type. Details: %s",
+	err := fmt.Errorf("unable to parse data as any known DMARC report type. Details: %s",
 		strings.Join(parseErrors, "; "))
+	p.storeUnparsed(data, source, reason, err)
+	p.audit(source, "unknown", "", "", size, err)
+	return ReportResult{}, err
 }
 
-// parseDirectory recursively parses all files in a directory
-func (p *Parser) parseDirectory(dirPath string) error {
-	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// parseFinal reports whether err, returned when parsing as one report type,
+// ends the search: the data was recognized as that type, so the other types
+// are not tried
+func parseFinal(err error) bool {
+	return err == nil ||
+		errors.Is(err, ErrDuplicateReport) ||
+		errors.Is(err, ErrStorage) ||
+		errors.Is(err, ErrUntrustedOrg)
+}
 
-		if !info.IsDir() {
-			if err := p.parseSingleFile(path); err != nil {
-				p.logger.Error("Failed to parse file",
-					zap.String("file", path),
-					zap.Error(err),
-				)
-			}
+// parseOrder returns the report types in the order they are tried: hint
+// first, then the others in the default order
+func parseOrder(hint string) []string {
+	order := []string{ReportTypeAggregate, ReportTypeForensic, ReportTypeSMTPTLS}
+	for i, reportType := range order {
+		if reportType == hint && i > 0 {
+			copy(order[1:i+1], order[:i])
+			order[0] = hint
 		}
-		return nil
-	})
+	}
+	return order
 }
 
-// parseSingleFile parses a single DMARC report file
-func (p *Parser) parseSingleFile(filePath string) error {
-	startTime := time.Now()
-	p.logger.Info("Parsing file", zap.String("file", filePath))
+// Outcomes of an ingestion attempt recorded in the audit log
+const (
+	AuditOutcomeSuccess   = "success"
+	AuditOutcomeDuplicate = "duplicate"
+	AuditOutcomeSkipped   = "skipped"
+	AuditOutcomeFailure   = "failure"
+)
+
+// audit writes the audit record of one ingestion attempt. Every record has
+// the same fields so that the audit trail has a stable schema; empty values
+// are written rather than omitted.
+func (p *Parser) audit(source, reportType, reportID, orgName string, size int, err error) {
+	if p.auditLogger == nil {
+		return
+	}
 
-	data, err := p.extractReport(filePath)
+	outcome := AuditOutcomeSuccess
+	errMsg := ""
 	if err != nil {
-		return fmt.Errorf("failed to extract report: %w", err)
+		outcome = AuditOutcomeFailure
+		if errors.Is(err, ErrDuplicateReport) {
+			outcome = AuditOutcomeDuplicate
+		} else if errors.Is(err, errReportTooOld) || errors.Is(err, errReportVetoed) {
+			outcome = AuditOutcomeSkipped
+		}
+		errMsg = err.Error()
 	}
 
-	// Log data size for monitoring
-	p.logger.Debug("Extracted report data",
-		zap.String("file", filePath),
-		zap.Int("size_bytes", len(data)),
-		zap.Duration("extraction_time", time.Since(startTime)),
+	p.auditLogger.Info("report_ingestion",
+		zap.String("source", source),
+		zap.String("report_type", reportType),
+		zap.String("report_id", reportID),
+		zap.String("org_name", orgName),
+		zap.Int("size_bytes", size),
+		zap.String("outcome", outcome),
+		zap.String("error", errMsg),
 	)
+}
 
-	// Skip empty files
-	if len(data) == 0 {
-		p.logger.Warn("Skipping empty file", zap.String("file", filePath))
-		return fmt.Errorf("file is empty")
+// AuditRejection writes the audit record of a report of reportType received
+// from source that was rejected before parsing, e.g. by validation
+func (p *Parser) AuditRejection(source, reportType string, size int, err error) {
+	p.audit(source, reportType, "", "", size, err)
+}
+
+// tee writes report to the tee writer when one is set. Failures are logged
+// and never returned: the tee must not affect ingestion.
+func (p *Parser) tee(report any) {
+	if p.teeWriter == nil {
+		return
 	}
 
-	// Try to parse as different report types
-	parseStart := time.Now()
-	if err := p.parseAsAggregateReport(data); err == nil {
-		p.logger.Debug("Successfully parsed as aggregate report",
-			zap.String("file", filePath),
-			zap.Duration("total_time", time.Since(startTime)),
-			zap.Duration("parse_time", time.Since(parseStart)),
-		)
-		return nil
+	p.teeMu.Lock()
+	defer p.teeMu.Unlock()
+
+	var err error
+	switch r := report.(type) {
+	case *AggregateReport:
+		err = p.teeWriter.WriteAggregateReport(r)
+	case *ForensicReport:
+		err = p.teeWriter.WriteForensicReport(r)
+	case *SMTPTLSReport:
+		err = p.teeWriter.WriteSMTPTLSReport(r)
+	}
+	if err != nil {
+		p.logger.Warn("Failed to write report to tee output", zap.Error(err))
 	}
+}
 
-	if err := p.parseAsForensicReport(data); err == nil {
-		p.logger.Debug("Successfully parsed as forensic report",
-			zap.String("file", filePath),
-			zap.Duration("total_time", time.Since(startTime)),
-		)
+// checkTrustedOrg rejects report with ErrUntrustedOrg when trusted_orgs is
+// set and the domain of the report's org email is neither one of them nor a
+// subdomain of one
+func (p *Parser) checkTrustedOrg(report *AggregateReport, source string, start time.Time, size int) error {
+	if len(p.config.TrustedOrgs) == 0 {
 		return nil
 	}
 
-	if err := p.parseAsSMTPTLSReport(data); err == nil {
-		p.logger.Debug("Successfully parsed as SMTP TLS report",
-			zap.String("file", filePath),
-			zap.Duration("total_time", time.Since(startTime)),
-		)
-		return nil
+	email := report.ReportMetadata.OrgEmail
+	domain := strings.ToLower(strings.TrimSpace(email[strings.LastIndex(email, "@")+1:]))
+	for _, trusted := range p.config.TrustedOrgs {
+		trusted = strings.ToLower(strings.TrimSpace(trusted))
+		if trusted != "" && (domain == trusted || strings.HasSuffix(domain, "."+trusted)) {
+			return nil
+		}
 	}
 
-	p.logger.Warn("Unable to parse file",
-		zap.String("file", filePath),
-		zap.Duration("total_time", time.Since(startTime)),
-		zap.Int("data_size", len(data)),
+	err := fmt.Errorf("%w: %q", ErrUntrustedOrg, email)
+	if p.metrics != nil {
+		p.metrics.RecordParseFailure("aggregate", source, "untrusted_org", time.Since(start).Seconds(), size)
+	}
+	p.audit(source, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, size, err)
+
+	p.logger.Warn("Rejecting report from untrusted organization",
+		zap.String("org", report.ReportMetadata.OrgName),
+		zap.String("org_email", email),
+		zap.String("report_id", report.ReportMetadata.ReportID),
+		zap.String("source", source),
 	)
 
-	return fmt.Errorf("unable to parse file as any known DMARC report type")
+	return err
 }
 
-// extractReport extracts content from zip, gzip, or plain text files
-func (p *Parser) extractReport(filePath string) ([]byte, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
+// skipTooOld reports whether a report dated date is older than
+// max_report_age. Such reports are counted with reason too_old and audited,
+// but neither stored nor treated as a parse error.
+func (p *Parser) skipTooOld(source, reportType, reportID, orgName string, date, start time.Time, size int) bool {
+	if p.config.MaxReportAge <= 0 || date.IsZero() || !date.Before(time.Now().Add(-p.config.MaxReportAge)) {
+		return false
 	}
-	defer file.Close()
 
-	// Check file size first to avoid loading huge files
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get file info: %w", err)
+	if p.metrics != nil {
+		p.metrics.RecordParseFailure(reportType, source, "too_old", time.Since(start).Seconds(), size)
 	}
+	p.audit(source, reportType, reportID, orgName, size, errReportTooOld)
 
-	// Limit file size to 100MB uncompressed to prevent memory exhaustion
-	const maxFileSize = 100 * 1024 * 1024
-	if fileInfo.Size() > maxFileSize {
-		p.logger.Warn("File size exceeds limit, skipping",
-			zap.String("file", filePath),
-			zap.Int64("size", fileInfo.Size()),
-			zap.Int64("max_size", maxFileSize),
-		)
-		return nil, fmt.Errorf("file size (%d bytes) exceeds maximum allowed size (%d bytes)", fileInfo.Size(), maxFileSize)
-	}
+	p.logger.Info("Skipping report older than max_report_age",
+		zap.String("type", reportType),
+		zap.String("report_id", reportID),
+		zap.Time("date", date),
+		zap.Duration("max_report_age", p.config.MaxReportAge),
+		zap.String("source", source),
+	)
 
-	// Read first few bytes to detect file type
-	header := make([]byte, 10)
-	n, err := file.Read(header)
-	if err != nil && err != io.EOF {
-		return nil, err
-	}
-	header = header[:n]
+	return true
+}
 
-	// Reset file position
-	if _, err := file.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to reset file position: %w", err)
+// storeUnparsed records metadata about input that could not be parsed when
+// store_unparsed is enabled. Failures are logged and never returned, so the
+// original parse error is what callers see.
+func (p *Parser) storeUnparsed(data []byte, source, reason string, parseErr error) {
+	if !p.config.StoreUnparsed || p.storage == nil {
+		return
 	}
 
-	// Check for ZIP file magic
-	if len(header) >= 4 && string(header[:4]) == "PK\x03\x04" {
-		return p.extractFromZip(file)
+	store, ok := p.backingStorage().(UnparsedStore)
+	if !ok {
+		p.logger.Debug("Storage does not support unparsed reports")
+		return
 	}
 
-	// Check for GZIP file magic
-	if len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b {
-		return p.extractFromGzip(file)
+	preview := data
+	if len(preview) > p.config.UnparsedPreviewBytes {
+		preview = preview[:max(p.config.UnparsedPreviewBytes, 0)]
 	}
 
-	// Check for XML or JSON - use limited reader to prevent memory exhaustion
-	if strings.HasPrefix(string(header), "<?xml") ||
-		strings.HasPrefix(string(header), "{") {
-		// Use a limited reader to prevent reading files that are too large
-		limitedReader := io.LimitReader(file, maxFileSize)
-		return io.ReadAll(limitedReader)
+	report := &UnparsedReport{
+		ReceivedAt:  time.Now().UTC(),
+		Source:      source,
+		Size:        len(data),
+		ContentType: http.DetectContentType(data),
+		Reason:      reason,
+		Error:       parseErr.Error(),
+		Preview:     strings.ToValidUTF8(string(preview), "\uFFFD"),
 	}
 
-	// For other file types, also use limited reader
-	limitedReader := io.LimitReader(file, maxFileSize)
-	return io.ReadAll(limitedReader)
+	if err := store.StoreUnparsedReport(report); err != nil {
+		p.logger.Error("Failed to store unparsed report metadata",
+			zap.String("source", source),
+			zap.Error(err),
+		)
+	}
 }
 
-// extractReportData extracts content from compressed data
-func (p *Parser) extractReportData(data []byte) ([]byte, error) {
-	// Check file type by magic bytes
-	if len(data) < 4 {
-		return data, nil
+// parseFailureReason maps the errors collected while trying each report type
+// to a granular failure reason, based on what the input looks like:
+// xml_unsafe/xml_syntax/xml_invalid, json_syntax/json_invalid, mime_no_feedback or
+// unknown_format.
+func parseFailureReason(data []byte, aggregateErr, forensicErr, smtpTLSErr error) string {
+	var xmlSyntaxErr *xml.SyntaxError
+	var jsonSyntaxErr *json.SyntaxError
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return "unknown_format"
 	}
 
-	// Check for ZIP file magic
-	if string(data[:4]) == "PK\x03\x04" {
-		return p.extractFromZipData(data)
+	switch trimmed[0] {
+	case '<':
+		if errors.Is(aggregateErr, ErrUnsafeXML) {
+			return "xml_unsafe"
+		}
+		if errors.As(aggregateErr, &xmlSyntaxErr) {
+			return "xml_syntax"
+		}
+		return "xml_invalid"
+	case '{', '[':
+		if errors.As(smtpTLSErr, &jsonSyntaxErr) {
+			return "json_syntax"
+		}
+		return "json_invalid"
 	}
 
-	// Check for GZIP file magic
-	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
-		return p.extractFromGzipData(data)
+	if errors.Is(forensicErr, errNoFeedbackReport) {
+		return "mime_no_feedback"
 	}
 
-	// Return as-is if not compressed
-	return data, nil
+	return "unknown_format"
 }
 
-// extractFromZipData extracts from ZIP data
-func (p *Parser) extractFromZipData(data []byte) ([]byte, error) {
-	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
-	if err != nil {
-		return nil, err
+// parseDirectory recursively parses all files in a directory, up to
+// concurrency files at a time. Failures are logged per file and do not stop
+// the walk.
+func (p *Parser) parseDirectory(dirPath string) error {
+	// Files are parsed by a bounded pool of workers; storages are safe for
+	// concurrent use and tee output is serialized by teeMu
+	workers := max(p.config.Concurrency, 1)
+	paths := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if err := p.parseSingleFile(path); err != nil {
+					p.logger.Error("Failed to parse file",
+						zap.String("file", path),
+						zap.Error(err),
+					)
+				}
+			}
+		}()
 	}
 
-	if len(zipReader.File) == 0 {
-		return nil, fmt.Errorf("zip contains no files")
-	}
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 
-	// Extract first file
-	file := zipReader.File[0]
-	rc, err := file.Open()
-	if err != nil {
-		return nil, err
-	}
-	defer rc.Close()
+		if !info.IsDir() {
+			paths <- path
+		}
+		return nil
+	})
 
-	return io.ReadAll(rc)
+	close(paths)
+	wg.Wait()
+	return err
 }
 
-// extractFromGzipData extracts from GZIP data
-func (p *Parser) extractFromGzipData(data []byte) ([]byte, error) {
-	gzReader, err := gzip.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-	defer gzReader.Close()
+// parseSingleFile parses a single DMARC report file
+func (p *Parser) parseSingleFile(filePath string) error {
+	startTime := time.Now()
+	p.logger.Info("Parsing file", zap.String("file", filePath))
 
-	// Read the content - if we get an "unexpected EOF", try to return what we've read
-	content, err := io.ReadAll(gzReader)
-	if err != nil && err.Error() == "unexpected EOF" {
-		// If we got some content before the error, return it
-		if len(content) > 0 {
-			p.logger.Debug("GZIP read completed with unexpected EOF, returning partial content",
-				zap.Int("contentLength", len(content)))
-			return content, nil
-		}
+	if parsed, err := p.parseLargeAggregateFile(filePath, startTime); parsed {
+		return err
+	}
+	if parsed, err := p.parseGzipAggregateFile(filePath, startTime); parsed {
+		return err
 	}
-	return content, err
-}
 
-// extractFromZip extracts content from ZIP file
-func (p *Parser) extractFromZip(reader io.Reader) ([]byte, error) {
-	data, err := io.ReadAll(reader)
+	files, err := p.extractReport(filePath)
+	if err != nil {
+		err = fmt.Errorf("failed to extract report: %w", err)
+		p.audit(SourceFile, "unknown", "", "", 0, err)
+		return err
+	}
+
+	if len(files) > 1 {
+		p.logger.Info("Found several reports in file",
+			zap.String("file", filePath),
+			zap.Int("reports", len(files)),
+		)
+	}
+
+	var errs []error
+	parsed := 0
+	for _, data := range files {
+		if err := p.parseFileReport(filePath, data, startTime); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		parsed++
+	}
+
+	if len(files) > 1 {
+		p.logger.Info("Parsed reports from file",
+			zap.String("file", filePath),
+			zap.Int("found", len(files)),
+			zap.Int("parsed", parsed),
+		)
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseGzipAggregateFile parses a gzipped aggregate report file by streaming
+// the decompressed XML into the decoder, without buffering it. It reports
+// whether the file was such a report; other files, including gzipped emails
+// and SMTP TLS reports, are left to the buffered path.
+func (p *Parser) parseGzipAggregateFile(filePath string, start time.Time) (bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Size() > maxReportSize {
+		return false, nil
+	}
+
+	content, gzipped, ok := xmlReportContent(file)
+	if !ok || !gzipped {
+		return false, nil
+	}
+
+	report, err := p.parseAggregateXMLReader(content)
+	if err != nil {
+		p.logger.Debug("Gzipped file is not an aggregate report, falling back to buffered parsing",
+			zap.String("file", filePath),
+			zap.Error(err),
+		)
+		return false, nil
+	}
+
+	return true, p.storeFileAggregateReport(report, int(info.Size()), start)
+}
+
+// xmlReportContent returns the XML document of a report file read from file,
+// decompressed when gzipped, and whether it was gzipped. ok is false when the
+// content is not an XML document, the only kind of report that is streamed.
+func xmlReportContent(file io.Reader) (content *bufio.Reader, gzipped, ok bool) {
+	content = bufio.NewReader(file)
+	if header, _ := content.Peek(2); len(header) == 2 && header[0] == 0x1f && header[1] == 0x8b {
+		gzReader, err := newGzipReportReader(content)
+		if err != nil {
+			return nil, false, false
+		}
+		content, gzipped = bufio.NewReader(gzReader), true
+	}
+
+	start, _ := content.Peek(512)
+	start = bytes.TrimLeft(bytes.TrimPrefix(start, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if !bytes.HasPrefix(start, []byte("<")) {
+		return nil, false, false
+	}
+	return content, gzipped, true
+}
+
+// streamChunkSize is the number of records of a streamed aggregate report
+// enriched and stored at once
+const streamChunkSize = 1000
+
+// errStreamSkipped stops streaming a report that is skipped
+var errStreamSkipped = errors.New("report skipped")
+
+// parseLargeAggregateFile streams an aggregate report file of at least
+// stream_threshold bytes, plain or gzipped XML, into a storage implementing
+// AggregateRecordStore one chunk of records at a time instead of holding the
+// whole report in memory. It reports whether the file was such a report;
+// other files are left to the buffered paths. Streaming is not used with
+// record sampling or report processors, which need every record of a report.
+func (p *Parser) parseLargeAggregateFile(filePath string, start time.Time) (bool, error) {
+	store, ok := p.storage.(AggregateRecordStore)
+	if !ok || p.config.StreamThreshold <= 0 || p.config.RecordSamplingThreshold > 0 || len(p.processors) > 0 {
+		return false, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Size() < int64(p.config.StreamThreshold) || info.Size() > maxReportSize {
+		return false, nil
+	}
+
+	content, _, ok := xmlReportContent(file)
+	if !ok {
+		return false, nil
+	}
+
+	return p.streamFileAggregateReport(filePath, store, content, int(info.Size()), start)
+}
+
+// streamFileAggregateReport stores an aggregate report streamed from the file
+// content of size bytes. Until the first chunk of records is parsed, errors
+// fall back to the buffered paths; after that the error is returned and the
+// partly stored report deleted, see deletePartialReport. A report that is
+// already stored is rejected before anything is stored, so that the deletion
+// never removes the stored copy.
+func (p *Parser) streamFileAggregateReport(filePath string, store AggregateRecordStore, content io.Reader, size int, start time.Time) (bool, error) {
+	var header *AggregateReport
+	stored := false
+	records := 0
+
+	_, err := p.streamAggregateXML(content, streamChunkSize, func(report *AggregateReport, offset int, chunk []Record) error {
+		if header == nil {
+			header = report
+			if err := p.checkTrustedOrg(report, SourceFile, start, size); err != nil {
+				return err
+			}
+			if p.skipTooOld(SourceFile, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName,
+				report.ReportMetadata.EndDate, time.Now(), size) {
+				return errStreamSkipped
+			}
+			if err := p.checkStreamedReportExists(store, report); err != nil {
+				return err
+			}
+			report.ParseInfo = p.parseInfo(SourceFile, start)
+			if err := store.StoreAggregateReportHeader(report); err != nil {
+				return storeFailure("aggregate", err)
+			}
+			stored = true
+		}
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		if err := store.StoreAggregateRecords(report, offset, chunk); err != nil {
+			return fmt.Errorf("failed to store aggregate records: %w", err)
+		}
+
+		part := *report
+		part.Records = chunk
+		part.RecordOffset = offset
+		p.recordDomainFailures(&part)
+		p.tee(&part)
+		records += len(chunk)
+		return nil
+	})
+	if errors.Is(err, errStreamSkipped) {
+		return true, nil
+	}
+	if err != nil && header == nil {
+		p.logger.Debug("File is not a streamable aggregate report, falling back to buffered parsing",
+			zap.String("file", filePath),
+			zap.Error(err),
+		)
+		return false, nil
+	}
+	if errors.Is(err, ErrUntrustedOrg) {
+		// Already audited by checkTrustedOrg
+		return true, err
+	}
+	if err != nil {
+		if stored {
+			p.deletePartialReport(store, header)
+		}
+		p.audit(SourceFile, "aggregate", header.ReportMetadata.ReportID, header.ReportMetadata.OrgName, size, err)
+		return true, err
+	}
+
+	p.audit(SourceFile, "aggregate", header.ReportMetadata.ReportID, header.ReportMetadata.OrgName, size, nil)
+
+	p.logger.Info("Successfully parsed aggregate report",
+		zap.String("org", header.ReportMetadata.OrgName),
+		zap.String("report_id", header.ReportMetadata.ReportID),
+		zap.Int("records", records),
+		zap.Bool("streamed", true),
+	)
+
+	return true, nil
+}
+
+// checkStreamedReportExists returns an error wrapping ErrDuplicateReport when
+// the storage already holds a report of the organization with the ID of
+// report. Storages that do not implement ReportStore are not checked; they
+// cannot delete a partly streamed report either.
+func (p *Parser) checkStreamedReportExists(store AggregateRecordStore, report *AggregateReport) error {
+	reportStore, ok := store.(ReportStore)
+	if !ok {
+		return nil
+	}
+
+	metadata := report.ReportMetadata
+	exists, err := reportStore.ReportExists("aggregate", metadata.OrgName, metadata.ReportID)
+	if err != nil {
+		return fmt.Errorf("failed to check for existing aggregate report: %w", &storageError{err})
+	}
+	if exists {
+		return fmt.Errorf("aggregate report %s: %w", metadata.ReportID, ErrDuplicateReport)
+	}
+	return nil
+}
+
+// deletePartialReport deletes the header and records of a report stored
+// before its streaming failed, e.g. on reaching max_records. Storages that do
+// not implement ReportStore keep them.
+func (p *Parser) deletePartialReport(store AggregateRecordStore, report *AggregateReport) {
+	metadata := report.ReportMetadata
+	reportStore, ok := store.(ReportStore)
+	if !ok {
+		p.logger.Warn("Keeping partly stored aggregate report, the storage cannot delete reports",
+			zap.String("org", metadata.OrgName),
+			zap.String("report_id", metadata.ReportID),
+		)
+		return
+	}
+
+	if err := reportStore.DeleteReport("aggregate", metadata.OrgName, metadata.ReportID); err != nil {
+		p.logger.Error("Failed to delete partly stored aggregate report",
+			zap.String("org", metadata.OrgName),
+			zap.String("report_id", metadata.ReportID),
+			zap.Error(err),
+		)
+	}
+}
+
+// parseFileReport parses one report extracted from a file
+func (p *Parser) parseFileReport(filePath string, data []byte, startTime time.Time) error {
+	// Log data size for monitoring
+	p.logger.Debug("Extracted report data",
+		zap.String("file", filePath),
+		zap.Int("size_bytes", len(data)),
+		zap.Duration("extraction_time", time.Since(startTime)),
+	)
+
+	// Skip empty files
+	if len(data) == 0 {
+		p.logger.Warn("Skipping empty file", zap.String("file", filePath))
+		err := fmt.Errorf("file is empty")
+		p.audit(SourceFile, "unknown", "", "", 0, err)
+		return err
+	}
+
+	// Try to parse as different report types
+	parseStart := time.Now()
+	if err := p.parseAsAggregateReport(data, startTime); err == nil {
+		p.logger.Debug("Successfully parsed as aggregate report",
+			zap.String("file", filePath),
+			zap.Duration("total_time", time.Since(startTime)),
+			zap.Duration("parse_time", time.Since(parseStart)),
+		)
+		return nil
+	} else if errors.Is(err, ErrUntrustedOrg) {
+		// An aggregate report, rejected and audited by checkTrustedOrg
+		return err
+	}
+
+	if err := p.parseAsForensicReport(data, startTime); err == nil {
+		p.logger.Debug("Successfully parsed as forensic report",
+			zap.String("file", filePath),
+			zap.Duration("total_time", time.Since(startTime)),
+		)
+		return nil
+	}
+
+	if err := p.parseAsSMTPTLSReport(data, startTime); err == nil {
+		p.logger.Debug("Successfully parsed as SMTP TLS report",
+			zap.String("file", filePath),
+			zap.Duration("total_time", time.Since(startTime)),
+		)
+		return nil
+	}
+
+	p.logger.Warn("Unable to parse file",
+		zap.String("file", filePath),
+		zap.Duration("total_time", time.Since(startTime)),
+		zap.Int("data_size", len(data)),
+	)
+
+	err := fmt.Errorf("unable to parse file as any known DMARC report type")
+	p.audit(SourceFile, "unknown", "", "", len(data), err)
+	return err
+}
+
+// extractReport extracts content from zip, gzip, or plain text files. Every
+// file of a ZIP archive is returned; other inputs yield a single report.
+func (p *Parser) extractReport(filePath string) ([][]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Check file size first to avoid loading huge files
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	// Limit file size to prevent memory exhaustion
+	const maxFileSize = maxReportSize
+	if fileInfo.Size() > maxFileSize {
+		p.logger.Warn("File size exceeds limit, skipping",
+			zap.String("file", filePath),
+			zap.Int64("size", fileInfo.Size()),
+			zap.Int64("max_size", maxFileSize),
+		)
+		return nil, fmt.Errorf("file size (%d bytes) exceeds maximum allowed size (%d bytes)", fileInfo.Size(), maxFileSize)
+	}
+
+	// Read first few bytes to detect file type
+	header := make([]byte, 10)
+	n, err := file.Read(header)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+
+	// Reset file position
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("failed to reset file position: %w", err)
+	}
+
+	// Check for ZIP file magic
+	if len(header) >= 4 && string(header[:4]) == "PK\x03\x04" {
+		return p.extractFromZip(file)
+	}
+
+	var data []byte
+
+	// Check for GZIP file magic
+	if len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b {
+		data, err = p.extractFromGzip(file)
+	} else {
+		// XML, JSON and other file types - use limited reader to prevent
+		// memory exhaustion
+		data, err = io.ReadAll(io.LimitReader(file, maxFileSize))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{data}, nil
+}
+
+// ExtractReportFiles returns the reports contained in data: every file of a
+// ZIP archive, the decompressed content of GZIP data, or data itself
+func (p *Parser) ExtractReportFiles(data []byte) ([][]byte, error) {
+	if len(data) >= 4 && string(data[:4]) == "PK\x03\x04" {
+		return p.extractAllFromZipData(data)
+	}
+
+	extracted, err := p.extractReportData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{extracted}, nil
+}
+
+// extractReportData extracts content from compressed data
+func (p *Parser) extractReportData(data []byte) ([]byte, error) {
+	// Check file type by magic bytes
+	if len(data) < 4 {
+		return data, nil
+	}
+
+	// Check for ZIP file magic
+	if string(data[:4]) == "PK\x03\x04" {
+		return p.extractFromZipData(data)
+	}
+
+	// Check for GZIP file magic
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		return p.extractFromGzipData(data)
+	}
+
+	// Return as-is if not compressed
+	return data, nil
+}
+
+// extractFromZipData extracts the first file from ZIP data
+func (p *Parser) extractFromZipData(data []byte) ([]byte, error) {
+	files, err := p.extractAllFromZipData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return files[0], nil
+}
+
+// extractAllFromZipData extracts every file from ZIP data, skipping
+// directory entries
+func (p *Parser) extractAllFromZipData(data []byte) ([][]byte, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var files [][]byte
+	for _, file := range zipReader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		content, err := readZipFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from zip: %w", file.Name, err)
+		}
+		files = append(files, content)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("zip contains no files")
+	}
+
+	return files, nil
+}
+
+// readZipFile reads the content of one file of a ZIP archive
+func readZipFile(file *zip.File) ([]byte, error) {
+	rc, err := file.Open()
 	if err != nil {
 		return nil, err
 	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// maxReportSize bounds the size of a report file, and of the decompressed
+// content of gzip data, to prevent memory exhaustion
+const maxReportSize = 100 * 1024 * 1024
+
+// errReportTooLarge is returned when decompressed data exceeds maxReportSize
+var errReportTooLarge = fmt.Errorf("decompressed report exceeds maximum size (%d bytes)", maxReportSize)
+
+// gzipReportReader streams the decompressed content of gzip data, failing
+// with errReportTooLarge once more than maxReportSize bytes are read
+type gzipReportReader struct {
+	gz        *gzip.Reader
+	remaining int64
+}
+
+// newGzipReportReader returns a gzipReportReader of the gzip data read from r
+func newGzipReportReader(r io.Reader) (*gzipReportReader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipReportReader{gz: gz, remaining: maxReportSize}, nil
+}
+
+func (g *gzipReportReader) Read(b []byte) (int, error) {
+	n, err := g.gz.Read(b)
+	g.remaining -= int64(n)
+	if g.remaining < 0 {
+		return n, errReportTooLarge
+	}
+	return n, err
+}
+
+func (g *gzipReportReader) Close() error {
+	return g.gz.Close()
+}
+
+// extractFromGzipData extracts from GZIP data
+func (p *Parser) extractFromGzipData(data []byte) ([]byte, error) {
+	gzReader, err := newGzipReportReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gzReader.Close()
+
+	// Read the content - if we get an "unexpected EOF", try to return what we've read
+	content, err := io.ReadAll(gzReader)
+	if err != nil && err.Error() == "unexpected EOF" {
+		// If we got some content before the error, return it
+		if len(content) > 0 {
+			p.logger.Debug("GZIP read completed with unexpected EOF, returning partial content",
+				zap.Int("contentLength", len(content)))
+			return content, nil
+		}
+	}
+	return content, err
+}
+
+// extractFromZip extracts every file from a ZIP file
+func (p *Parser) extractFromZip(reader io.Reader) ([][]byte, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.extractAllFromZipData(data)
+}
+
+// extractFromGzip extracts content from GZIP file
+func (p *Parser) extractFromGzip(reader io.Reader) ([]byte, error) {
+	gzReader, err := newGzipReportReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gzReader.Close()
+
+	return io.ReadAll(gzReader)
+}
+
+// parseAsAggregateReport tries to parse data as aggregate DMARC report
+func (p *Parser) parseAsAggregateReport(data []byte, start time.Time) error {
+	var report *AggregateReport
+	var err error
+
+	// Check if this looks like an email message
+	dataStr := string(data)
+	dataStrLower := strings.ToLower(dataStr)
+	if strings.Contains(dataStrLower, "content-type:") && strings.Contains(dataStrLower, "mime-version:") {
+		// Try to extract aggregate report from email MIME parts
+		report, err = p.parseAggregateFromEmail(data)
+	} else {
+		// Direct XML parsing
+		report, err = p.parseAggregateXML(data)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return p.storeFileAggregateReport(report, len(data), start)
+}
+
+// storeFileAggregateReport stores an aggregate report parsed from a file of
+// size bytes
+func (p *Parser) storeFileAggregateReport(report *AggregateReport, size int, start time.Time) error {
+	if err := p.checkTrustedOrg(report, SourceFile, start, size); err != nil {
+		return err
+	}
+	if p.skipTooOld(SourceFile, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName,
+		report.ReportMetadata.EndDate, time.Now(), size) {
+		return nil
+	}
+	if p.skipVetoed(SourceFile, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName,
+		report, time.Now(), size) {
+		return nil
+	}
+
+	if p.storage != nil {
+		report.ParseInfo = p.parseInfo(SourceFile, start)
+		if err := p.storage.StoreAggregateReport(report); err != nil {
+			return fmt.Errorf("failed to store aggregate report: %w", err)
+		}
+	}
+
+	p.recordDomainFailures(report)
+	p.tee(report)
+	p.audit(SourceFile, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, size, nil)
+
+	p.logger.Info("Successfully parsed aggregate report",
+		zap.String("org", report.ReportMetadata.OrgName),
+		zap.String("report_id", report.ReportMetadata.ReportID),
+		zap.Int("records", len(report.Records)),
+	)
+
+	return nil
+}
+
+// parseAggregateFromEmail parses aggregate DMARC report from email content,
+// the first one when the email holds several
+func (p *Parser) parseAggregateFromEmail(data []byte) (*AggregateReport, error) {
+	reports, err := p.parseAggregatesFromEmail(data)
+	if err != nil {
+		return nil, err
+	}
+	return reports[0], nil
+}
+
+// parseAggregatesFromEmail parses every aggregate DMARC report attached to
+// email content, including each report of a ZIP attachment. Attachments that
+// are not aggregate reports are skipped; an error is returned only when none
+// is.
+func (p *Parser) parseAggregatesFromEmail(data []byte) ([]*AggregateReport, error) {
+	body := string(data)
+
+	// Try multipart MIME parsing first, then single attachment email parsing
+	files := p.extractAggregateFromMIME(body, 0)
+	if len(files) == 0 {
+		files = p.extractAggregateFromSingleAttachment(body)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no aggregate report attachment found in email")
+	}
+
+	var reports []*AggregateReport
+	var errs []error
+	for _, file := range files {
+		report, err := p.parseAggregateXML(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	if len(reports) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return reports, nil
+}
+
+// extractAggregateFromMIME extracts aggregate report attachments from MIME
+// multipart message, every file of a ZIP attachment separately. Attached
+// emails (message/rfc822) are searched too, depth being the number of
+// enclosing emails.
+func (p *Parser) extractAggregateFromMIME(body string, depth int) [][]byte {
+	// Find Content-Type header and boundary (can be on multiple lines)
+	lines := strings.Split(body, "\n")
+	var contentType string
+	var boundary string
+	var inContentType bool
+
+	for _, line := range lines {
+		originalLine := line
+		line = strings.TrimSpace(line)
+
+		// Check if this is the start of Content-Type header
+		if strings.HasPrefix(strings.ToLower(line), "content-type:") {
+			contentType = line
+			inContentType = true
+		} else if inContentType && (strings.HasPrefix(originalLine, " ") || strings.HasPrefix(originalLine, "\t")) {
+			// This is a continuation of the Content-Type header
+			contentType += " " + strings.TrimSpace(originalLine)
+		} else if inContentType {
+			// End of Content-Type header, process it
+			inContentType = false
+		}
+
+		// Check if we've found boundary anywhere in the accumulated contentType
+		if strings.Contains(strings.ToLower(contentType), "boundary=") && boundary == "" {
+			if parts := strings.Split(strings.ToLower(contentType), "boundary="); len(parts) > 1 {
+				boundaryPart := strings.TrimSpace(parts[1])
+				// Extract the boundary value (remove quotes and any trailing text)
+				if strings.HasPrefix(boundaryPart, "\"") {
+					// Quoted boundary
+					endQuote := strings.Index(boundaryPart[1:], "\"")
+					if endQuote != -1 {
+						boundary = boundaryPart[1 : endQuote+1]
+					}
+				} else {
+					// Unquoted boundary - take first word
+					boundary = strings.Fields(boundaryPart)[0]
+				}
+			}
+		}
+
+		// Stop looking after we've processed Content-Type and found boundary
+		if !inContentType && boundary != "" {
+			break
+		}
+
+		// Stop looking if we hit an empty line and we're not in Content-Type
+		if !inContentType && line == "" {
+			break
+		}
+	}
+
+	if boundary == "" || !strings.Contains(strings.ToLower(contentType), "multipart") {
+		return nil
+	}
+
+	// Find start of MIME body (after headers)
+	emptyLineIndex := strings.Index(body, "\n\n")
+	if emptyLineIndex == -1 {
+		emptyLineIndex = strings.Index(body, "\r\n\r\n")
+		if emptyLineIndex == -1 {
+			return nil
+		}
+	}
+
+	mimeBody := body[emptyLineIndex+2:]
+
+	// Parse MIME multipart
+	mediaType, params, err := mime.ParseMediaType(contentType[13:]) // Skip "Content-Type: "
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil
+	}
+
+	mr := multipart.NewReader(strings.NewReader(mimeBody), params["boundary"])
+
+	// Process each MIME part
+	var files [][]byte
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			p.logger.Debug("MIME part iteration ended", zap.Error(err))
+			break
+		}
+
+		// Check if this part is an attachment (ZIP, GZIP, or XML)
+		disposition := part.Header.Get("Content-Disposition")
+		contentType := part.Header.Get("Content-Type")
+		encoding := part.Header.Get("Content-Transfer-Encoding")
+
+		p.logger.Debug("Processing MIME part",
+			zap.String("disposition", disposition),
+			zap.String("contentType", contentType),
+			zap.String("encoding", encoding),
+		)
+
+		// The attached email of a multipart/report is a forensic sample
+		if isEmbeddedMessage(contentType) && mediaType != "multipart/report" {
+			files = append(files, p.extractAggregateFromEmbeddedMessage(part, encoding, depth)...)
+			continue
+		}
+
+		if strings.Contains(strings.ToLower(disposition), "attachment") ||
+			strings.Contains(strings.ToLower(contentType), "application/zip") ||
+			strings.Contains(strings.ToLower(contentType), "application/gzip") ||
+			strings.Contains(strings.ToLower(contentType), "application/x-gzip") ||
+			(!p.config.IgnoreInlineXML && isXMLContentType(contentType)) {
+
+			p.logger.Debug("Found potential attachment part")
+
+			// Read the attachment content
+			attachmentData, err := io.ReadAll(part)
+			if err != nil {
+				p.logger.Debug("Failed to read attachment data", zap.Error(err))
+				continue
+			}
+
+			p.logger.Debug("Read attachment data", zap.Int("size", len(attachmentData)))
+
+			// Undo base64 or quoted-printable transfer encoding
+			decoded, err := decodeTransferEncoding(attachmentData, encoding)
+			if err != nil {
+				p.logger.Debug("Failed to decode attachment", zap.String("encoding", encoding), zap.Error(err))
+				continue
+			}
+			attachmentData = decoded
+			p.logger.Debug("Decoded attachment data", zap.Int("decodedSize", len(attachmentData)))
+
+			// Try to extract content if it's compressed
+			extractedFiles, err := p.ExtractReportFiles(attachmentData)
+			if err != nil {
+				p.logger.Debug("Failed to extract compressed data", zap.Error(err))
+				// If extraction fails, maybe it's already XML
+				if strings.Contains(strings.ToLower(string(attachmentData)), "<?xml") {
+					p.logger.Debug("Returning raw XML data")
+					files = append(files, attachmentData)
+				}
+				continue
+			}
+
+			p.logger.Debug("Successfully extracted report data", zap.Int("files", len(extractedFiles)))
+			files = append(files, extractedFiles...)
+			continue
+		}
+
+		part.Close()
+	}
+
+	return files
+}
+
+// extractAggregateFromEmbeddedMessage extracts the aggregate reports of an
+// email attached as a message/rfc822 part, as done by some forwarders
+func (p *Parser) extractAggregateFromEmbeddedMessage(part io.Reader, encoding string, depth int) [][]byte {
+	if depth >= MaxEmbeddedMessageDepth {
+		p.logger.Debug("Skipping deeply nested attached email", zap.Int("depth", depth))
+		return nil
+	}
+
+	message, err := readEmbeddedMessage(part, encoding)
+	if err != nil {
+		p.logger.Debug("Failed to read attached email", zap.Error(err))
+		return nil
+	}
+
+	p.logger.Debug("Searching attached email for aggregate report", zap.Int("depth", depth+1))
+
+	if files := p.extractAggregateFromMIME(message, depth+1); len(files) > 0 {
+		return files
+	}
+	return p.extractAggregateFromSingleAttachment(message)
+}
+
+// extractAggregateFromSingleAttachment extracts aggregate reports from single attachment email (like Mimecast format)
+func (p *Parser) extractAggregateFromSingleAttachment(body string) [][]byte {
+	lines := strings.Split(body, "\n")
+	var contentType, contentTransferEncoding string
+	var bodyStartIdx int = -1
+
+	// Find headers
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		lineLower := strings.ToLower(line)
+
+		if strings.HasPrefix(lineLower, "content-type:") {
+			contentType = line
+		} else if strings.HasPrefix(lineLower, "content-transfer-encoding:") {
+			contentTransferEncoding = line
+		} else if line == "" && bodyStartIdx == -1 {
+			// Empty line marks end of headers
+			bodyStartIdx = i + 1
+			break
+		}
+	}
+
+	// Check if this looks like a single attachment email, or an aggregate
+	// report pasted inline as the text/xml body
+	contentTypeLower := strings.ToLower(contentType)
+	inlineXML := !p.config.IgnoreInlineXML && isXMLContentType(contentTypeLower)
+	if !inlineXML &&
+		!strings.Contains(contentTypeLower, "application/gzip") &&
+		!strings.Contains(contentTypeLower, "application/zip") &&
+		!strings.Contains(contentTypeLower, "application/x-gzip") {
+		return nil
+	}
+
+	if bodyStartIdx == -1 || bodyStartIdx >= len(lines) {
+		return nil
+	}
+
+	// Inline XML is not a base64 blob, so decode the body as a whole
+	if inlineXML {
+		_, encoding, _ := strings.Cut(contentTransferEncoding, ":")
+		decoded, err := decodeTransferEncoding([]byte(strings.Join(lines[bodyStartIdx:], "\n")), encoding)
+		if err != nil {
+			p.logger.Debug("Failed to decode inline XML body", zap.Error(err))
+			return nil
+		}
+		return [][]byte{bytes.TrimSpace(decoded)}
+	}
+
+	// Extract body content - only take lines that look like base64
+	var base64Lines []string
+	for i := bodyStartIdx; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		// Skip empty lines or lines that don't look like base64
+		if line == "" || (!strings.Contains("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=", string(line[0])) && len(line) > 0) {
+			continue
+		}
+		base64Lines = append(base64Lines, line)
+	}
+	bodyContent := strings.Join(base64Lines, "")
+
+	// Decode if base64 encoded
+	var attachmentData []byte
+	if strings.Contains(strings.ToLower(contentTransferEncoding), "base64") {
+		decoded, err := base64.StdEncoding.DecodeString(bodyContent)
+		if err != nil {
+			p.logger.Debug("Failed to decode base64 single attachment", zap.Error(err))
+			return nil
+		}
+		attachmentData = decoded
+	} else {
+		attachmentData = []byte(bodyContent)
+	}
+
+	// Try to extract content if it's compressed
+	extractedFiles, err := p.ExtractReportFiles(attachmentData)
+	if err != nil {
+		// If extraction fails, maybe it's already XML
+		if strings.Contains(strings.ToLower(string(attachmentData)), "<?xml") {
+			return [][]byte{attachmentData}
+		}
+		return nil
+	}
+
+	return extractedFiles
+}
+
+// isXMLContentType reports whether a Content-Type header denotes XML
+func isXMLContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "text/xml") || strings.Contains(contentType, "application/xml")
+}
+
+// MaxEmbeddedMessageDepth limits how many levels of attached emails are
+// searched for reports, here and by the IMAP client
+const MaxEmbeddedMessageDepth = 3
+
+// isEmbeddedMessage reports whether a MIME part is an attached email
+func isEmbeddedMessage(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "message/rfc822"
+}
+
+// readEmbeddedMessage reads an attached email, undoing the transfer encoding
+// of its part
+func readEmbeddedMessage(part io.Reader, encoding string) (string, error) {
+	data, err := io.ReadAll(part)
+	if err != nil {
+		return "", err
+	}
+	decoded, err := decodeTransferEncoding(data, encoding)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// decodeTransferEncoding decodes a MIME body according to its
+// Content-Transfer-Encoding; other encodings are returned unchanged
+func decodeTransferEncoding(data []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.StdEncoding.DecodeString(string(data))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(bytes.NewReader(data)))
+	default:
+		return data, nil
+	}
+}
+
+// parseAsForensicReport tries to parse data as forensic DMARC report
+func (p *Parser) parseAsForensicReport(data []byte, start time.Time) error {
+	report, err := p.parseForensicEmail(data)
+	if err != nil {
+		return err
+	}
+
+	if p.skipTooOld(SourceFile, "forensic", report.MessageID, "", report.ArrivalDateUTC, time.Now(), len(data)) {
+		return nil
+	}
+	if p.skipVetoed(SourceFile, "forensic", report.MessageID, "", report, time.Now(), len(data)) {
+		return nil
+	}
+
+	if p.storage != nil {
+		report.ParseInfo = p.parseInfo(SourceFile, start)
+		if err := p.storage.StoreForensicReport(report); err != nil {
+			return fmt.Errorf("failed to store forensic report: %w", err)
+		}
+	}
+
+	p.tee(report)
+	p.audit(SourceFile, "forensic", report.MessageID, "", len(data), nil)
+
+	p.logger.Info("Successfully parsed forensic report",
+		zap.String("subject", report.Subject),
+		zap.String("source_ip", report.Source.IPAddress),
+		zap.String("reported_domain", report.ReportedDomain),
+	)
+
+	return nil
+}
+
+// parseAsSMTPTLSReport tries to parse data as SMTP TLS report
+func (p *Parser) parseAsSMTPTLSReport(data []byte, start time.Time) error {
+	report, err := p.parseSMTPTLSData(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse SMTP TLS report: %w", err)
+	}
+
+	return p.processSMTPTLSReport(report, len(data), start)
+}
+
+// parseSMTPTLSData parses an SMTP TLS report from JSON, from gzipped JSON as
+// delivered with the application/tlsrpt+gzip content type, or from an email
+// carrying either. Data is always extracted first, so that every entry point
+// accepts compressed reports.
+func (p *Parser) parseSMTPTLSData(data []byte) (*SMTPTLSReport, error) {
+	extractedData, err := p.extractReportData(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract report data: %w", err)
+	}
+
+	// First try to parse as direct JSON
+	report, jsonErr := p.parseSMTPTLSJSON(extractedData)
+	if jsonErr == nil {
+		return report, nil
+	}
+
+	// Try to parse as email containing SMTP TLS report
+	if report, err := p.parseSMTPTLSEmail(extractedData); err == nil {
+		return report, nil
+	}
+
+	return nil, jsonErr
+}
+
+// processSMTPTLSReport handles storage and logging for SMTP TLS reports
+func (p *Parser) processSMTPTLSReport(report *SMTPTLSReport, size int, start time.Time) error {
+	if p.skipTooOld(SourceFile, "smtp_tls", report.ReportID, report.OrganizationName, report.EndDate, time.Now(), size) {
+		return nil
+	}
+	if p.skipVetoed(SourceFile, "smtp_tls", report.ReportID, report.OrganizationName, report, time.Now(), size) {
+		return nil
+	}
+
+	if p.storage != nil {
+		report.ParseInfo = p.parseInfo(SourceFile, start)
+		if err := p.storage.StoreSMTPTLSReport(report); err != nil {
+			return fmt.Errorf("failed to store SMTP TLS report: %w", err)
+		}
+	}
 
-	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	p.tee(report)
+	p.audit(SourceFile, "smtp_tls", report.ReportID, report.OrganizationName, size, nil)
+
+	p.logger.Info("Successfully parsed SMTP TLS report",
+		zap.String("org", report.OrganizationName),
+		zap.String("report_id", report.ReportID),
+		zap.Int("policies", len(report.Policies)),
+	)
+
+	return nil
+}
+
+// parseSMTPTLSEmail parses an SMTP TLS report from email data
+func (p *Parser) parseSMTPTLSEmail(emailData []byte) (*SMTPTLSReport, error) {
+	jsonContent := p.extractSMTPTLSFromMIME(emailData, 0)
+	if jsonContent == nil {
+		return nil, fmt.Errorf("no SMTP TLS report found")
+	}
+
+	report, err := p.parseSMTPTLSJSON(jsonContent)
 	if err != nil {
+		return nil, fmt.Errorf("failed to parse SMTP TLS JSON: %w", err)
+	}
+
+	return report, nil
+}
+
+// smtpTLSReportJSON mirrors the RFC 8460 section 4 JSON report format
+type smtpTLSReportJSON struct {
+	OrganizationName string `json:"organization-name"`
+	DateRange        struct {
+		StartDatetime string `json:"start-datetime"`
+		EndDatetime   string `json:"end-datetime"`
+	} `json:"date-range"`
+	ContactInfo *string `json:"contact-info"`
+	ReportID    string  `json:"report-id"`
+	Policies    []struct {
+		Policy struct {
+			PolicyType   string          `json:"policy-type"`
+			PolicyString []string        `json:"policy-string"`
+			PolicyDomain string          `json:"policy-domain"`
+			MXHost       json.RawMessage `json:"mx-host"`
+		} `json:"policy"`
+		Summary struct {
+			TotalSuccessfulSessionCount int `json:"total-successful-session-count"`
+			TotalFailureSessionCount    int `json:"total-failure-session-count"`
+		} `json:"summary"`
+		FailureDetails []struct {
+			ResultType            string  `json:"result-type"`
+			SendingMTAIP          *string `json:"sending-mta-ip"`
+			ReceivingMXHostname   *string `json:"receiving-mx-hostname"`
+			ReceivingMXHelo       *string `json:"receiving-mx-helo"`
+			ReceivingIP           *string `json:"receiving-ip"`
+			FailedSessionCount    int     `json:"failed-session-count"`
+			AdditionalInformation *string `json:"additional-information"`
+			FailureReasonCode     *string `json:"failure-reason-code"`
+		} `json:"failure-details"`
+	} `json:"policies"`
+}
+
+// parseSMTPTLSJSON parses an RFC 8460 JSON report. Optional fields such as
+// contact-info may be absent; a missing contact-info is recorded as a warning.
+func (p *Parser) parseSMTPTLSJSON(data []byte) (*SMTPTLSReport, error) {
+	var raw smtpTLSReportJSON
+	if err := p.parseJSONWithLineInfo(data, &raw); err != nil {
 		return nil, err
 	}
 
-	if len(zipReader.File) == 0 {
-		return nil, fmt.Errorf("zip file contains no files")
+	if raw.OrganizationName == "" && raw.ReportID == "" {
+		return nil, fmt.Errorf("not an SMTP TLS report: missing organization-name and report-id")
 	}
 
-	// Extract first file
-	file := zipReader.File[0]
-	rc, err := file.Open()
-	if err != nil {
-		return nil, err
+	report := &SMTPTLSReport{
+		OrganizationName: p.limitIdentifier("smtp_tls", "org_name", raw.OrganizationName),
+		ReportID:         p.limitIdentifier("smtp_tls", "report_id", raw.ReportID),
 	}
-	defer rc.Close()
 
-	return io.ReadAll(rc)
+	if raw.ContactInfo != nil {
+		report.ContactInfo = *raw.ContactInfo
+	} else {
+		p.logger.Warn("SMTP TLS report has no contact-info",
+			zap.String("org", raw.OrganizationName),
+			zap.String("report_id", raw.ReportID),
+		)
+		if p.metrics != nil {
+			p.metrics.RecordParseWarning("smtp_tls", "missing_contact_info")
+		}
+	}
+
+	if raw.DateRange.StartDatetime != "" {
+		beginDate, err := time.Parse(time.RFC3339, raw.DateRange.StartDatetime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse start-datetime: %w", err)
+		}
+		report.BeginDate = beginDate
+	}
+	if raw.DateRange.EndDatetime != "" {
+		endDate, err := time.Parse(time.RFC3339, raw.DateRange.EndDatetime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse end-datetime: %w", err)
+		}
+		report.EndDate = endDate
+	}
+
+	for _, rawPolicy := range raw.Policies {
+		policy := SMTPTLSPolicy{
+			PolicyDomain:           rawPolicy.Policy.PolicyDomain,
+			PolicyType:             rawPolicy.Policy.PolicyType,
+			PolicyStrings:          rawPolicy.Policy.PolicyString,
+			MXHostPatterns:         parseMXHost(rawPolicy.Policy.MXHost),
+			SuccessfulSessionCount: rawPolicy.Summary.TotalSuccessfulSessionCount,
+			FailedSessionCount:     rawPolicy.Summary.TotalFailureSessionCount,
+		}
+		if strings.EqualFold(policy.PolicyType, "sts") {
+			policy.MTASTSPolicy = parseMTASTSPolicy(policy.PolicyStrings)
+		}
+
+		for _, rawFailure := range rawPolicy.FailureDetails {
+			policy.FailureDetails = append(policy.FailureDetails, SMTPTLSFailureDetails{
+				ResultType:          rawFailure.ResultType,
+				FailedSessionCount:  rawFailure.FailedSessionCount,
+				SendingMTAIP:        rawFailure.SendingMTAIP,
+				ReceivingIP:         rawFailure.ReceivingIP,
+				ReceivingMXHostname: rawFailure.ReceivingMXHostname,
+				ReceivingMXHelo:     rawFailure.ReceivingMXHelo,
+				AdditionalInfoURI:   rawFailure.AdditionalInformation,
+				FailureReasonCode:   rawFailure.FailureReasonCode,
+			})
+		}
+
+		report.Policies = append(report.Policies, policy)
+	}
+
+	return report, nil
 }
 
-// extractFromGzip extracts content from GZIP file
-func (p *Parser) extractFromGzip(reader io.Reader) ([]byte, error) {
-	gzReader, err := gzip.NewReader(reader)
+// parseMTASTSPolicy parses the "key: value" lines of an MTA-STS policy as
+// reported in policy-string. Reporters send one line per string, but a
+// string holding the whole policy is split into lines too. It returns nil if
+// no MTA-STS field was found.
+func parseMTASTSPolicy(policyStrings []string) *MTASTSPolicy {
+	policy := &MTASTSPolicy{}
+	found := false
+
+	for _, policyString := range policyStrings {
+		for _, line := range strings.FieldsFunc(policyString, func(r rune) bool { return r == '\r' || r == '\n' }) {
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "version":
+				policy.Version = value
+			case "mode":
+				policy.Mode = strings.ToLower(value)
+			case "mx":
+				policy.MX = append(policy.MX, strings.ToLower(value))
+			case "max_age":
+				maxAge, err := strconv.Atoi(value)
+				if err != nil {
+					continue
+				}
+				policy.MaxAge = maxAge
+			default:
+				continue
+			}
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return policy
+}
+
+// parseMXHost decodes the mx-host policy field, which reporters send either
+// as a list of patterns (RFC 8460) or as a single string
+func parseMXHost(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var patterns []string
+	if err := json.Unmarshal(raw, &patterns); err == nil {
+		return patterns
+	}
+
+	var pattern string
+	if err := json.Unmarshal(raw, &pattern); err == nil && pattern != "" {
+		return []string{pattern}
+	}
+
+	return nil
+}
+
+// extractSMTPTLSFromMIME extracts SMTP TLS JSON from an email, searching its
+// MIME parts and attached emails. depth is the number of enclosing attached
+// emails.
+func (p *Parser) extractSMTPTLSFromMIME(data []byte, depth int) []byte {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return nil
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return nil
 	}
-	defer gzReader.Close()
 
-	return io.ReadAll(gzReader)
+	return p.extractSMTPTLSFromEntity(textproto.MIMEHeader(msg.Header), body, depth)
 }
 
-// parseAsAggregateReport tries to parse data as aggregate DMARC report
-func (p *Parser) parseAsAggregateReport(data []byte) error {
-	var report *AggregateReport
-	var err error
+// extractSMTPTLSFromEntity extracts SMTP TLS JSON from the body of an email
+// or MIME part with header, descending into nested multiparts and attached
+// emails (message/rfc822) and undoing base64, quoted-printable and gzip
+// encodings
+func (p *Parser) extractSMTPTLSFromEntity(header textproto.MIMEHeader, body []byte, depth int) []byte {
+	// Without a valid Content-Type the body is searched as is
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	encoding := header.Get("Content-Transfer-Encoding")
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			// NextPart keeps failing once the body is malformed, so any
+			// error ends the iteration. Raw parts keep their transfer
+			// encoding, undone like that of single part emails.
+			part, err := mr.NextRawPart()
+			if err != nil {
+				return nil
+			}
+			partBody, err := io.ReadAll(part)
+			part.Close()
+			if err != nil {
+				return nil
+			}
 
-	// Check if this looks like an email message
-	dataStr := string(data)
-	dataStrLower := strings.ToLower(dataStr)
-	if strings.Contains(dataStrLower, "content-type:") && strings.Contains(dataStrLower, "mime-version:") {
-		// Try to extract aggregate report from email MIME parts
-		report, err = p.parseAggregateFromEmail(data)
-	} else {
-		// Direct XML parsing
-		report, err = p.parseAggregateXML(data)
+			// The attached email of a multipart/report is a forensic sample
+			if mediaType == "multipart/report" && isEmbeddedMessage(part.Header.Get("Content-Type")) {
+				continue
+			}
+			if content := p.extractSMTPTLSFromEntity(part.Header, partBody, depth); content != nil {
+				return content
+			}
+		}
+
+	case mediaType == "message/rfc822":
+		if depth >= MaxEmbeddedMessageDepth {
+			return nil
+		}
+		message, err := decodeTransferEncoding(body, encoding)
+		if err != nil {
+			return nil
+		}
+		return p.extractSMTPTLSFromMIME(message, depth+1)
 	}
 
+	content, err := decodeTransferEncoding(body, encoding)
 	if err != nil {
-		return err
+		return nil
+	}
+
+	// Gzip is detected by its magic bytes since .json.gz attachments may be
+	// sent as application/octet-stream
+	if len(content) >= 2 && content[0] == 0x1f && content[1] == 0x8b {
+		if decompressed, err := p.extractFromGzipData(content); err == nil {
+			content = decompressed
+		}
+	}
+
+	if strings.Contains(mediaType, "tlsrpt") ||
+		bytes.Contains(content, []byte(`"organization-name"`)) ||
+		bytes.Contains(content, []byte(`"report-id"`)) {
+		return content
+	}
+
+	return nil
+}
+
+// parseAsAggregateReportWithMetrics parses aggregate report with metrics
+func (p *Parser) parseAsAggregateReportWithMetrics(data []byte, source string, start time.Time, size int, mode WriteMode) (ReportResult, error) {
+	report, err := p.parseAggregateXML(data)
+	if err != nil {
+		duration := time.Since(start).Seconds()
+		if p.metrics != nil {
+			p.metrics.RecordParseFailure("aggregate", source, "parse_failed", duration, size)
+		}
+		return ReportResult{}, err
+	}
+
+	result := ReportResult{
+		ReportType:  ReportTypeAggregate,
+		OrgName:     report.ReportMetadata.OrgName,
+		ReportID:    report.ReportMetadata.ReportID,
+		RecordCount: len(report.Records),
+	}
+
+	if err := p.checkTrustedOrg(report, source, start, size); err != nil {
+		return result, err
+	}
+
+	if p.skipTooOld(source, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName,
+		report.ReportMetadata.EndDate, start, size) {
+		result.Skipped = true
+		return result, nil
+	}
+	if p.skipVetoed(source, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName,
+		report, start, size) {
+		result.Skipped = true
+		return result, nil
 	}
 
 	if p.storage != nil {
+		if err := p.applyWriteMode("aggregate", report.ReportMetadata.OrgName, report.ReportMetadata.ReportID, report.Fingerprint, mode); err != nil {
+			duration := time.Since(start).Seconds()
+			if p.metrics != nil {
+				p.metrics.RecordParseFailure("aggregate", source, writeModeFailureReason(err), duration, size)
+			}
+			p.audit(source, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, size, err)
+			return result, err
+		}
+
+		report.ParseInfo = p.parseInfo(source, start)
 		if err := p.storage.StoreAggregateReport(report); err != nil {
-			return fmt.Errorf("failed to store aggregate report: %w", err)
+			duration := time.Since(start).Seconds()
+			if p.metrics != nil {
+				p.metrics.RecordParseFailure("aggregate", source, writeModeFailureReason(err), duration, size)
+			}
+			err = storeFailure("aggregate", err)
+			p.audit(source, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, size, err)
+			return result, err
 		}
 	}
 
+	duration := time.Since(start).Seconds()
+	if p.metrics != nil {
+		p.metrics.RecordParseSuccess("aggregate", source, duration, size)
+	}
+
+	p.recordDomainFailures(report)
+	p.tee(report)
+	p.audit(source, "aggregate", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, size, nil)
+
 	p.logger.Info("Successfully parsed aggregate report",
 		zap.String("org", report.ReportMetadata.OrgName),
 		zap.String("report_id", report.ReportMetadata.ReportID),
 		zap.Int("records", len(report.Records)),
+		zap.String("source", source),
 	)
 
-	return nil
+	return result, nil
 }
 
-// parseAggregateFromEmail parses aggregate DMARC report from email content
-func (p *Parser) parseAggregateFromEmail(data []byte) (*AggregateReport, error) {
-	body := string(data)
+// parseInfo returns the parse metadata stored with a report received through
+// source whose parsing started at start
+func (p *Parser) parseInfo(source string, start time.Time) ParseInfo {
+	info := ParseInfo{SourceTransport: p.sourceTransport(source)}
+	if p.config.StoreParseMetadata {
+		info.ParseDurationMS = time.Since(start).Milliseconds()
+		info.ParserVersion = p.version
+	}
+	return info
+}
 
-	// Try multipart MIME parsing first
-	attachmentData := p.extractAggregateFromMIME(body)
-	if attachmentData != nil {
-		return p.parseAggregateXML(attachmentData)
+// sourceTransport returns the source transport stored with a report received
+// through source, or nothing unless store_source_transport is enabled
+func (p *Parser) sourceTransport(source string) string {
+	if !p.config.StoreSourceTransport {
+		return ""
 	}
+	return source
+}
 
-	// Try single attachment email parsing
-	attachmentData = p.extractAggregateFromSingleAttachment(body)
-	if attachmentData == nil {
-		return nil, fmt.Errorf("no aggregate report attachment found in email")
+// parseAsForensicReportWithMetrics parses forensic report with metrics
+func (p *Parser) parseAsForensicReportWithMetrics(data []byte, source string, start time.Time, size int) (ReportResult, error) {
+	report, err := p.parseForensicEmail(data)
+	if err != nil {
+		duration := time.Since(start).Seconds()
+		if p.metrics != nil {
+			p.metrics.RecordParseFailure("forensic", source, "parse_failed", duration, size)
+		}
+		return ReportResult{}, err
+	}
+
+	result := ReportResult{
+		ReportType: ReportTypeForensic,
+		ReportID:   report.MessageID,
+	}
+
+	if p.skipTooOld(source, "forensic", report.MessageID, "", report.ArrivalDateUTC, start, size) {
+		result.Skipped = true
+		return result, nil
+	}
+	if p.skipVetoed(source, "forensic", report.MessageID, "", report, start, size) {
+		result.Skipped = true
+		return result, nil
+	}
+
+	if p.storage != nil {
+		report.ParseInfo = p.parseInfo(source, start)
+		if err := p.storage.StoreForensicReport(report); err != nil {
+			duration := time.Since(start).Seconds()
+			if p.metrics != nil {
+				p.metrics.RecordParseFailure("forensic", source, writeModeFailureReason(err), duration, size)
+			}
+			err = storeFailure("forensic", err)
+			p.audit(source, "forensic", report.MessageID, "", size, err)
+			return result, err
+		}
+	}
+
+	duration := time.Since(start).Seconds()
+	if p.metrics != nil {
+		p.metrics.RecordParseSuccess("forensic", source, duration, size)
+	}
+
+	p.tee(report)
+	p.audit(source, "forensic", report.MessageID, "", size, nil)
+
+	p.logger.Info("Successfully parsed forensic report",
+		zap.String("subject", report.Subject),
+		zap.String("source_ip", report.Source.IPAddress),
+		zap.String("reported_domain", report.ReportedDomain),
+		zap.String("source", source),
+	)
+
+	return result, nil
+}
+
+// parseAsSMTPTLSReportWithMetrics parses SMTP TLS report with metrics
+func (p *Parser) parseAsSMTPTLSReportWithMetrics(data []byte, source string, start time.Time, size int, mode WriteMode) (ReportResult, error) {
+	report, err := p.parseSMTPTLSData(data)
+	if err != nil {
+		duration := time.Since(start).Seconds()
+		if p.metrics != nil {
+			p.metrics.RecordParseFailure("smtp_tls", source, "parse_failed", duration, size)
+		}
+		return ReportResult{}, fmt.Errorf("failed to parse SMTP TLS report: %w", err)
 	}
 
-	return p.parseAggregateXML(attachmentData)
+	return p.processSMTPTLSReportWithMetrics(report, source, start, size, mode)
 }
 
-// extractAggregateFromMIME extracts aggregate report attachments from MIME multipart message
-func (p *Parser) extractAggregateFromMIME(body string) []byte {
-	// Find Content-Type header and boundary (can be on multiple lines)
-	lines := strings.Split(body, "\n")
-	var contentType string
-	var boundary string
-	var inContentType bool
+// processSMTPTLSReportWithMetrics handles storage, metrics and logging for SMTP TLS reports
+func (p *Parser) processSMTPTLSReportWithMetrics(report *SMTPTLSReport, source string, start time.Time, size int, mode WriteMode) (ReportResult, error) {
+	result := ReportResult{
+		ReportType: ReportTypeSMTPTLS,
+		OrgName:    report.OrganizationName,
+		ReportID:   report.ReportID,
+	}
 
-	for _, line := range lines {
-		originalLine := line
-		line = strings.TrimSpace(line)
+	if p.skipTooOld(source, "smtp_tls", report.ReportID, report.OrganizationName, report.EndDate, start, size) {
+		result.Skipped = true
+		return result, nil
+	}
+	if p.skipVetoed(source, "smtp_tls", report.ReportID, report.OrganizationName, report, start, size) {
+		result.Skipped = true
+		return result, nil
+	}
 
-		// Check if this is the start of Content-Type header
-		if strings.HasPrefix(strings.ToLower(line), "content-type:") {
-			contentType = line
-			inContentType = true
-		} else if inContentType && (strings.HasPrefix(originalLine, " ") || strings.HasPrefix(originalLine, "\t")) {
-			// This is a continuation of the Content-Type header
-			contentType += " " + strings.TrimSpace(originalLine)
-		} else if inContentType {
-			// End of Content-Type header, process it
-			inContentType = false
+	if p.storage != nil {
+		if err := p.applyWriteMode("smtp_tls", report.OrganizationName, report.ReportID, "", mode); err != nil {
+			duration := time.Since(start).Seconds()
+			if p.metrics != nil {
+				p.metrics.RecordParseFailure("smtp_tls", source, writeModeFailureReason(err), duration, size)
+			}
+			p.audit(source, "smtp_tls", report.ReportID, report.OrganizationName, size, err)
+			return result, err
 		}
 
-		// Check if we've found boundary anywhere in the accumulated contentType
-		if strings.Contains(strings.ToLower(contentType), "boundary=") && boundary == "" {
-			if parts := strings.Split(strings.ToLower(contentType), "boundary="); len(parts) > 1 {
-				boundaryPart := strings.TrimSpace(parts[1])
-				// Extract the boundary value (remove quotes and any trailing text)
-				if strings.HasPrefix(boundaryPart, "\"") {
-					// Quoted boundary
-					endQuote := strings.Index(boundaryPart[1:], "\"")
-					if endQuote != -1 {
-						boundary = boundaryPart[1 : endQuote+1]
-					}
-				} else {
-					// Unquoted boundary - take first word
-					boundary = strings.Fields(boundaryPart)[0]
-				}
+		report.ParseInfo = p.parseInfo(source, start)
+		if err := p.storage.StoreSMTPTLSReport(report); err != nil {
+			duration := time.Since(start).Seconds()
+			if p.metrics != nil {
+				p.metrics.RecordParseFailure("smtp_tls", source, writeModeFailureReason(err), duration, size)
 			}
+			err = storeFailure("SMTP TLS", err)
+			p.audit(source, "smtp_tls", report.ReportID, report.OrganizationName, size, err)
+			return result, err
 		}
+	}
+
+	duration := time.Since(start).Seconds()
+	if p.metrics != nil {
+		p.metrics.RecordParseSuccess("smtp_tls", source, duration, size)
+	}
+
+	p.tee(report)
+	p.audit(source, "smtp_tls", report.ReportID, report.OrganizationName, size, nil)
 
-		// Stop looking after we've processed Content-Type and found boundary
-		if !inContentType && boundary != "" {
-			break
-		}
+	p.logger.Info("Successfully parsed SMTP TLS report",
+		zap.String("org", report.OrganizationName),
+		zap.String("report_id", report.ReportID),
+		zap.Int("policies", len(report.Policies)),
+		zap.String("source", source),
+	)
 
-		// Stop looking if we hit an empty line and we're not in Content-Type
-		if !inContentType && line == "" {
-			break
-		}
+	return result, nil
+}
+
+// QueryAggregateReports returns the stored aggregate reports matching filter
+func (p *Parser) QueryAggregateReports(filter AggregateReportFilter) ([]*AggregateReport, error) {
+	querier, ok := p.backingStorage().(ReportQuerier)
+	if !ok {
+		return nil, ErrQueryNotSupported
 	}
+	return querier.QueryAggregateReports(filter)
+}
 
-	if boundary == "" || !strings.Contains(strings.ToLower(contentType), "multipart") {
+// applyWriteMode prepares storage for a report of orgName according to mode:
+// in WriteModeCreate it rejects a report ID that the organization already
+// stored, and in WriteModeReplace it deletes the stored copy so the new
+// report replaces it.
+// When the report has a fingerprint and the storage implements
+// FingerprintStore, the stored copy is looked up by fingerprint instead:
+// WriteModeCreate rejects a stored fingerprint, and WriteModeReplace only
+// deletes the report ID when its fingerprint is stored. Errors other than
+// duplicates match ErrStorage.
+func (p *Parser) applyWriteMode(reportType, orgName, reportID, fingerprint string, mode WriteMode) error {
+	if mode == WriteModeAppend {
 		return nil
 	}
 
-	// Find start of MIME body (after headers)
-	emptyLineIndex := strings.Index(body, "\n\n")
-	if emptyLineIndex == -1 {
-		emptyLineIndex = strings.Index(body, "\r\n\r\n")
-		if emptyLineIndex == -1 {
+	if fpStore, ok := p.backingStorage().(FingerprintStore); ok && fingerprint != "" {
+		exists, err := fpStore.FingerprintExists(reportType, fingerprint)
+		if err != nil {
+			return fmt.Errorf("failed to check for existing %s report: %w", reportType, &storageError{err})
+		}
+		if mode == WriteModeCreate && exists {
+			return fmt.Errorf("%s report %s: %w", reportType, reportID, ErrDuplicateReport)
+		}
+		// A stored report with the same ID but another fingerprint is a
+		// different report, covering another period or domain
+		if mode == WriteModeCreate || !exists {
 			return nil
 		}
 	}
 
-	mimeBody := body[emptyLineIndex+2:]
-
-	// Parse MIME multipart
-	mediaType, params, err := mime.ParseMediaType(contentType[13:]) // Skip "Content-Type: "
-	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
-		return nil
+	store, ok := p.backingStorage().(ReportStore)
+	if !ok {
+		return &storageError{errors.New("storage does not support looking up existing reports")}
 	}
 
-	mr := multipart.NewReader(strings.NewReader(mimeBody), params["boundary"])
-
-	// Process each MIME part
-	for {
-		part, err := mr.NextPart()
+	switch mode {
+	case WriteModeCreate:
+		exists, err := store.ReportExists(reportType, orgName, reportID)
 		if err != nil {
-			p.logger.Debug("MIME part iteration ended", zap.Error(err))
-			break
+			return fmt.Errorf("failed to check for existing %s report: %w", reportType, &storageError{err})
 		}
-
-		// Check if this part is an attachment (ZIP, GZIP, or XML)
-		disposition := part.Header.Get("Content-Disposition")
-		contentType := part.Header.Get("Content-Type")
-		encoding := part.Header.Get("Content-Transfer-Encoding")
-
-		p.logger.Debug("Processing MIME part",
-			zap.String("disposition", disposition),
-			zap.String("contentType", contentType),
-			zap.String("encoding", encoding),
+		if exists {
+			return fmt.Errorf("%s report %s: %w", reportType, reportID, ErrDuplicateReport)
+		}
+	case WriteModeReplace:
+		if err := store.DeleteReport(reportType, orgName, reportID); err != nil {
+			return fmt.Errorf("failed to replace existing %s report: %w", reportType, &storageError{err})
+		}
+		p.logger.Debug("Removed previously stored report",
+			zap.String("type", reportType),
+			zap.String("org", orgName),
+			zap.String("report_id", reportID),
 		)
+	}
 
-		if strings.Contains(strings.ToLower(disposition), "attachment") ||
-			strings.Contains(strings.ToLower(contentType), "application/zip") ||
-			strings.Contains(strings.ToLower(contentType), "application/gzip") ||
-			strings.Contains(strings.ToLower(contentType), "application/x-gzip") ||
-			strings.Contains(strings.ToLower(contentType), "text/xml") ||
-			strings.Contains(strings.ToLower(contentType), "application/xml") {
-
-			p.logger.Debug("Found potential attachment part")
+	return nil
+}
 
-			// Read the attachment content
-			attachmentData, err := io.ReadAll(part)
-			if err != nil {
-				p.logger.Debug("Failed to read attachment data", zap.Error(err))
-				continue
-			}
+// writeModeFailureReason returns the parse failure reason for an
+// applyWriteMode or a store error
+func writeModeFailureReason(err error) string {
+	if errors.Is(err, ErrDuplicateReport) {
+		return "duplicate"
+	}
+	return "storage_failed"
+}
 
-			p.logger.Debug("Read attachment data", zap.Int("size", len(attachmentData)))
+// storeFailure wraps an error of storing a report of the given type. Reports
+// the storage skipped as duplicates keep matching ErrDuplicateReport only,
+// other errors match ErrStorage.
+func storeFailure(reportType string, err error) error {
+	if errors.Is(err, ErrDuplicateReport) {
+		return fmt.Errorf("failed to store %s report: %w", reportType, err)
+	}
+	return fmt.Errorf("failed to store %s report: %w", reportType, &storageError{err})
+}
 
-			// Check if it's base64 encoded
-			if strings.ToLower(encoding) == "base64" {
-				decoded, err := base64.StdEncoding.DecodeString(string(attachmentData))
-				if err != nil {
-					p.logger.Debug("Failed to decode base64", zap.Error(err))
-					continue
-				}
-				attachmentData = decoded
-				p.logger.Debug("Decoded base64 data", zap.Int("decodedSize", len(attachmentData)))
-			}
+// newXMLDecoder returns a decoder for untrusted XML. encoding/xml neither
+// loads external entities nor expands entities declared in a DTD; in strict
+// mode, with no Entity map set, undeclared entities are errors. Declared
+// charsets are ignored and the content decoded as is.
+func newXMLDecoder(r io.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = true
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder
+}
 
-			// Try to extract content if it's compressed
-			extractedData, err := p.extractReportData(attachmentData)
-			if err != nil {
-				p.logger.Debug("Failed to extract compressed data", zap.Error(err))
-				// If extraction fails, maybe it's already XML
-				if strings.Contains(strings.ToLower(string(attachmentData)), "<?xml") {
-					p.logger.Debug("Returning raw XML data")
-					return attachmentData
-				}
-				continue
-			}
+// ErrUnsafeXML is returned for XML with a document type or entity
+// declaration. Reports never need one, while XML external entity (XXE)
+// attacks and XML bombs rely on them, so such input is rejected outright
+// rather than trusting the decoder to ignore them.
+var ErrUnsafeXML = errors.New("XML document type and entity declarations (DOCTYPE, ENTITY) are not allowed")
 
-			p.logger.Debug("Successfully extracted report data", zap.Int("extractedSize", len(extractedData)))
-			return extractedData
-		}
+// unsafeXMLPattern matches the start of a document type or entity declaration
+var unsafeXMLPattern = regexp.MustCompile(`(?i)<!(DOCTYPE|ENTITY)`)
 
-		part.Close()
+// CheckXMLSafety returns ErrUnsafeXML if data contains a document type or
+// entity declaration
+func CheckXMLSafety(data []byte) error {
+	if unsafeXMLPattern.Match(data) {
+		return ErrUnsafeXML
 	}
-
 	return nil
 }
 
-// extractAggregateFromSingleAttachment extracts aggregate report from single attachment email (like Mimecast format)
-func (p *Parser) extractAggregateFromSingleAttachment(body string) []byte {
-	lines := strings.Split(body, "\n")
-	var contentType, contentTransferEncoding string
-	var bodyStartIdx int = -1
-
-	// Find headers
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
-		lineLower := strings.ToLower(line)
+// findFeedbackElement reads decoder up to the start of the feedback element,
+// skipping the elements wrapping it. Document type and entity declarations
+// are rejected.
+func findFeedbackElement(decoder *xml.Decoder) (*xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("no feedback element found")
+			}
+			return nil, xmlError(err)
+		}
 
-		if strings.HasPrefix(lineLower, "content-type:") {
-			contentType = line
-		} else if strings.HasPrefix(lineLower, "content-transfer-encoding:") {
-			contentTransferEncoding = line
-		} else if line == "" && bodyStartIdx == -1 {
-			// Empty line marks end of headers
-			bodyStartIdx = i + 1
-			break
+		switch t := token.(type) {
+		case xml.Directive:
+			if CheckXMLSafety(append([]byte("<!"), t...)) != nil {
+				return nil, ErrUnsafeXML
+			}
+		case xml.StartElement:
+			if t.Name.Local == "feedback" {
+				return &t, nil
+			}
 		}
 	}
+}
 
-	// Check if this looks like a single attachment email
-	contentTypeLower := strings.ToLower(contentType)
-	if !strings.Contains(contentTypeLower, "application/gzip") &&
-		!strings.Contains(contentTypeLower, "application/zip") &&
-		!strings.Contains(contentTypeLower, "application/x-gzip") {
-		return nil
-	}
+// tooManyRecordsError is returned for aggregate reports of more than
+// max_records records
+func (p *Parser) tooManyRecordsError() error {
+	return fmt.Errorf("aggregate report has more than %d records (max_records)", p.config.MaxRecords)
+}
 
-	if bodyStartIdx == -1 || bodyStartIdx >= len(lines) {
-		return nil
+// decodeFeedback decodes the feedback element of an aggregate report, one
+// child element at a time so that reports of more than max_records records
+// are rejected before they are decoded whole
+func (p *Parser) decodeFeedback(decoder *xml.Decoder) (*aggregateFeedbackXML, error) {
+	if _, err := findFeedbackElement(decoder); err != nil {
+		return nil, err
 	}
 
-	// Extract body content - only take lines that look like base64
-	var base64Lines []string
-	for i := bodyStartIdx; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		// Skip empty lines or lines that don't look like base64
-		if line == "" || (!strings.Contains("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=", string(line[0])) && len(line) > 0) {
-			continue
+	var feedback aggregateFeedbackXML
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, xmlError(err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			var target any
+			switch t.Name.Local {
+			case "version":
+				target = &feedback.Version
+			case "report_metadata":
+				target = &feedback.ReportMetadata
+			case "policy_published":
+				target = &feedback.PolicyPublished
+			case "record":
+				if p.config.MaxRecords > 0 && len(feedback.Record) >= p.config.MaxRecords {
+					return nil, p.tooManyRecordsError()
+				}
+				feedback.Record = append(feedback.Record, aggregateRecordXML{})
+				target = &feedback.Record[len(feedback.Record)-1]
+			default:
+				if err := decoder.Skip(); err != nil {
+					return nil, xmlError(err)
+				}
+				continue
+			}
+			if err := decoder.DecodeElement(target, &t); err != nil {
+				return nil, xmlError(err)
+			}
+		case xml.EndElement:
+			// The end of the feedback element, children being decoded or
+			// skipped whole
+			return &feedback, nil
 		}
-		base64Lines = append(base64Lines, line)
 	}
-	bodyContent := strings.Join(base64Lines, "")
+}
 
-	// Decode if base64 encoded
-	var attachmentData []byte
-	if strings.Contains(strings.ToLower(contentTransferEncoding), "base64") {
-		decoded, err := base64.StdEncoding.DecodeString(bodyContent)
-		if err != nil {
-			p.logger.Debug("Failed to decode base64 single attachment", zap.Error(err))
-			return nil
-		}
-		attachmentData = decoded
-	} else {
-		attachmentData = []byte(bodyContent)
+// xmlError adds line information, when available, to an XML decoding error
+func xmlError(err error) error {
+	// Try to get line information from XML syntax errors
+	if syntaxErr, ok := err.(*xml.SyntaxError); ok {
+		return fmt.Errorf("XML syntax error at line %d: %w", syntaxErr.Line, err)
 	}
+	// For other XML errors, try to extract line info if available
+	errStr := err.Error()
+	if strings.Contains(errStr, "line ") {
+		return fmt.Errorf("XML parsing error: %w", err)
+	}
+	return fmt.Errorf("XML parsing error (unable to determine line): %w", err)
+}
 
-	// Try to extract content if it's compressed
-	extractedData, err := p.extractReportData(attachmentData)
+// parseJSONWithLineInfo wraps JSON parsing to provide line number information on errors
+func (p *Parser) parseJSONWithLineInfo(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
 	if err != nil {
-		// If extraction fails, maybe it's already XML
-		if strings.Contains(strings.ToLower(string(attachmentData)), "<?xml") {
-			return attachmentData
+		// Try to get line information from JSON syntax errors
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			// Calculate line number by counting newlines up to the error offset
+			lines := bytes.Count(data[:syntaxErr.Offset], []byte{'\n'}) + 1
+			return fmt.Errorf("JSON syntax error at line %d: %w", lines, err)
 		}
-		return nil
+		// For other JSON errors, try to extract line info if available
+		errStr := err.Error()
+		if strings.Contains(errStr, "line ") {
+			return fmt.Errorf("JSON parsing error: %w", err)
+		}
+		return fmt.Errorf("JSON parsing error (unable to determine line): %w", err)
 	}
-
-	return extractedData
+	return nil
 }
 
-// parseAsForensicReport tries to parse data as forensic DMARC report
-func (p *Parser) parseAsForensicReport(data []byte) error {
-	report, err := p.parseForensicEmail(data)
-	if err != nil {
-		return err
+// feedbackStartPattern matches the opening feedback tag, optionally with a
+// namespace prefix and attributes such as xmlns declarations
+var feedbackStartPattern = regexp.MustCompile(`<([A-Za-z_][\w.-]*:)?feedback[\s>]`)
+
+// extractFeedbackElement returns the feedback element of an aggregate report,
+// including its namespace declarations, or "" if none is found.
+// Child elements are matched by local name when decoding, so default and
+// prefixed namespaces map onto the same fields.
+func extractFeedbackElement(dataStr string) string {
+	match := feedbackStartPattern.FindStringSubmatchIndex(dataStr)
+	if match == nil {
+		return ""
 	}
 
-	if p.storage != nil {
-		if err := p.storage.StoreForensicReport(report); err != nil {
-			return fmt.Errorf("failed to store forensic report: %w", err)
-		}
+	feedbackStart := match[0]
+	prefix := ""
+	if match[2] != -1 {
+		prefix = dataStr[match[2]:match[3]]
 	}
 
-	p.logger.Info("Successfully parsed forensic report",
-		zap.String("subject", report.Subject),
-		zap.String("source_ip", report.Source.IPAddress),
-		zap.String("reported_domain", report.ReportedDomain),
-	)
+	closeTag := "</" + prefix + "feedback>"
+	feedbackEnd := strings.LastIndex(dataStr, closeTag)
+	if feedbackEnd <= feedbackStart {
+		return ""
+	}
 
-	return nil
+	return dataStr[feedbackStart : feedbackEnd+len(closeTag)]
 }
 
-// parseAsSMTPTLSReport tries to parse data as SMTP TLS report
-func (p *Parser) parseAsSMTPTLSReport(data []byte) error {
-	// First try to parse as direct JSON
-	var report SMTPTLSReport
-	if err := p.parseJSONWithLineInfo(data, &report); err == nil {
-		// Direct JSON parsing succeeded
-		return p.processSMTPTLSReport(&report)
-	}
+// aggregateFeedbackXML is the XML structure of an aggregate report
+type aggregateFeedbackXML struct {
+	XMLName        xml.Name `xml:"feedback"`
+	Version        string   `xml:"version,omitempty"`
+	ReportMetadata struct {
+		OrgName          string `xml:"org_name"`
+		Email            string `xml:"email"`
+		ExtraContactInfo string `xml:"extra_contact_info,omitempty"`
+		ReportID         string `xml:"report_id"`
+		DateRange        struct {
+			Begin string `xml:"begin"`
+			End   string `xml:"end"`
+		} `xml:"date_range"`
+		Error []string `xml:"error,omitempty"`
+	} `xml:"report_metadata"`
+	PolicyPublished struct {
+		Domain string `xml:"domain"`
+		ADKIM  string `xml:"adkim,omitempty"`
+		ASPF   string `xml:"aspf,omitempty"`
+		P      string `xml:"p"`
+		SP     string `xml:"sp,omitempty"`
+		PCT    string `xml:"pct,omitempty"`
+		FO     string `xml:"fo,omitempty"`
+	} `xml:"policy_published"`
+	Record []aggregateRecordXML `xml:"record"`
+}
 
-	// Try to parse as email containing SMTP TLS report
-	if reportFromEmail, err := p.parseSMTPTLSEmail(data); err == nil {
-		return p.processSMTPTLSReport(reportFromEmail)
+// aggregateRecordXML is the XML structure of an aggregate report record
+type aggregateRecordXML struct {
+	Row struct {
+		SourceIP        string `xml:"source_ip"`
+		Count           int    `xml:"count"`
+		PolicyEvaluated struct {
+			Disposition string `xml:"disposition"`
+			DKIM        string `xml:"dkim"`
+			SPF         string `xml:"spf"`
+			Reason      []struct {
+				Type    string `xml:"type"`
+				Comment string `xml:"comment,omitempty"`
+			} `xml:"reason,omitempty"`
+		} `xml:"policy_evaluated"`
+	} `xml:"row"`
+	Identifiers struct {
+		HeaderFrom   string `xml:"header_from"`
+		EnvelopeFrom string `xml:"envelope_from,omitempty"`
+		EnvelopeTo   string `xml:"envelope_to,omitempty"`
+	} `xml:"identifiers"`
+	AuthResults struct {
+		DKIM []struct {
+			Domain   string `xml:"domain"`
+			Selector string `xml:"selector,omitempty"`
+			Result   string `xml:"result"`
+		} `xml:"dkim"`
+		SPF []struct {
+			Domain string `xml:"domain"`
+			Scope  string `xml:"scope,omitempty"`
+			Result string `xml:"result"`
+		} `xml:"spf"`
+	} `xml:"auth_results"`
+}
+
+// parseAggregateXML parses XML aggregate DMARC report
+func (p *Parser) parseAggregateXML(data []byte) (*AggregateReport, error) {
+	if len(data) > maxReportSize {
+		return nil, fmt.Errorf("failed to parse aggregate report XML: %w", errReportTooLarge)
+	}
+	if len(data) > 50*1024*1024 { // 50MB
+		p.logger.Warn("Parsing large XML file",
+			zap.Int("size_mb", len(data)/(1024*1024)),
+			zap.String("note", "this may take a while and use significant memory"),
+		)
 	}
 
-	return fmt.Errorf("failed to parse SMTP TLS report")
-}
+	// Declarations precede the feedback element extracted below
+	if err := CheckXMLSafety(data); err != nil {
+		p.logger.Warn("Rejected aggregate report XML with a DOCTYPE or ENTITY declaration")
+		return nil, fmt.Errorf("failed to parse aggregate report XML: %w", err)
+	}
 
-// processSMTPTLSReport handles storage and logging for SMTP TLS reports
-func (p *Parser) processSMTPTLSReport(report *SMTPTLSReport) error {
-	if p.storage != nil {
-		if err := p.storage.StoreSMTPTLSReport(report); err != nil {
-			return fmt.Errorf("failed to store SMTP TLS report: %w", err)
-		}
+	// Handle XML files that may have schema declarations or other wrapper elements
+	// Look for the <feedback> element and extract just that part
+	dataStr := string(data)
+	if feedbackXML := extractFeedbackElement(dataStr); feedbackXML != "" {
+		data = []byte(feedbackXML)
+		p.logger.Debug("Extracted feedback section from XML with schema/wrapper elements",
+			zap.Int("originalSize", len(dataStr)),
+			zap.Int("extractedSize", len(feedbackXML)))
 	}
 
-	p.logger.Info("Successfully parsed SMTP TLS report",
-		zap.String("org", report.OrganizationName),
-		zap.String("report_id", report.ReportID),
-		zap.Int("policies", len(report.Policies)),
-	)
+	feedback, err := p.decodeFeedback(newXMLDecoder(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse aggregate report XML: %w", err)
+	}
 
-	return nil
+	return p.buildAggregateReport(feedback)
 }
 
-// parseSMTPTLSEmail parses an SMTP TLS report from email data
-func (p *Parser) parseSMTPTLSEmail(emailData []byte) (*SMTPTLSReport, error) {
-	// Parse the email message
-	emailStr := string(emailData)
-
-	// Split email into headers and body parts
-	parts := strings.Split(emailStr, "\r\n\r\n")
-	if len(parts) < 2 {
-		parts = strings.Split(emailStr, "\n\n")
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("invalid email format")
-		}
+// parseAggregateXMLReader parses an aggregate report streamed from r, without
+// reading r into memory first. Elements wrapping the feedback element are
+// skipped.
+func (p *Parser) parseAggregateXMLReader(r io.Reader) (*AggregateReport, error) {
+	feedback, err := p.decodeFeedback(newXMLDecoder(r))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse aggregate report XML: %w", err)
 	}
 
-	// Extract SMTP TLS report from MIME parts
-	jsonContent := p.extractSMTPTLSFromMIME(emailStr)
-	if jsonContent == "" {
-		return nil, fmt.Errorf("no SMTP TLS report found")
+	return p.buildAggregateReport(feedback)
+}
+
+// streamAggregateXML parses an aggregate report streamed from r one record
+// at a time, so that reports with hundreds of thousands of records are never
+// held in memory as a whole. The metadata and published policy, which precede
+// the records, are converted first; records are then enriched and passed to
+// emit in chunks of up to chunkSize along with the report header, offset
+// being the index of the chunk's first record. emit is called at least once,
+// with no records for an empty report. An error returned by emit stops
+// parsing and is returned as is. The header is returned without records.
+// Records are never sampled.
+func (p *Parser) streamAggregateXML(r io.Reader, chunkSize int, emit func(report *AggregateReport, offset int, records []Record) error) (*AggregateReport, error) {
+	decoder := newXMLDecoder(r)
+	if _, err := findFeedbackElement(decoder); err != nil {
+		return nil, fmt.Errorf("failed to parse aggregate report XML: %w", err)
 	}
 
-	// Parse the JSON content
-	var report SMTPTLSReport
-	if err := p.parseJSONWithLineInfo([]byte(jsonContent), &report); err != nil {
-		return nil, fmt.Errorf("failed to parse SMTP TLS JSON: %w", err)
+	var feedback aggregateFeedbackXML
+	var report *AggregateReport
+	pending := make([]aggregateRecordXML, 0, chunkSize)
+	offset := 0
+
+	// buildHeader converts the header once, when the first record or the end
+	// of the report is reached
+	buildHeader := func() error {
+		if report != nil {
+			return nil
+		}
+		var err error
+		report, err = p.buildAggregateReportHeader(&feedback)
+		return err
 	}
 
-	return &report, nil
-}
+	flush := func() error {
+		sourceIPs := make([]string, len(pending))
+		for i := range pending {
+			sourceIPs[i] = pending[i].Row.SourceIP
+		}
+		sources := p.enrichSources(sourceIPs)
 
-// extractSMTPTLSFromMIME extracts SMTP TLS JSON from MIME multipart message
-func (p *Parser) extractSMTPTLSFromMIME(body string) string {
-	// First try to parse as multipart MIME message
-	content := p.extractSMTPTLSFromMIMEParts(body)
-	if content != "" {
-		return content
+		records := make([]Record, len(pending))
+		for i := range pending {
+			records[i] = p.buildAggregateRecord(report, &pending[i])
+			records[i].Source = *sources[i]
+		}
+		if err := emit(report, offset, records); err != nil {
+			return err
+		}
+
+		offset += len(records)
+		pending = pending[:0]
+		return nil
 	}
 
-	// Fall back to looking for direct JSON in the body (for non-MIME messages)
-	if strings.Contains(body, `"organization-name"`) || strings.Contains(body, `"report-id"`) {
-		// Extract JSON from body (skip headers)
-		lines := strings.Split(body, "\n")
-		jsonStart := -1
-		for i, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" && jsonStart == -1 {
-				// Found end of headers, next non-empty line should be JSON
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("failed to parse aggregate report XML: %w", xmlError(err))
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			var target any
+			switch t.Name.Local {
+			case "version":
+				target = &feedback.Version
+			case "report_metadata":
+				target = &feedback.ReportMetadata
+			case "policy_published":
+				target = &feedback.PolicyPublished
+			case "record":
+				if p.config.MaxRecords > 0 && offset+len(pending) >= p.config.MaxRecords {
+					return nil, p.tooManyRecordsError()
+				}
+				if err := buildHeader(); err != nil {
+					return nil, err
+				}
+				pending = append(pending, aggregateRecordXML{})
+				target = &pending[len(pending)-1]
+			default:
+				if err := decoder.Skip(); err != nil {
+					return nil, fmt.Errorf("failed to parse aggregate report XML: %w", xmlError(err))
+				}
 				continue
 			}
-			if line != "" && (strings.HasPrefix(line, "{") || strings.Contains(line, `"organization-name"`)) {
-				jsonStart = i
-				break
+			if err := decoder.DecodeElement(target, &t); err != nil {
+				return nil, fmt.Errorf("failed to parse aggregate report XML: %w", xmlError(err))
 			}
-		}
-		if jsonStart >= 0 {
-			return strings.Join(lines[jsonStart:], "\n")
+
+			if len(pending) == chunkSize {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		case xml.EndElement:
+			// The end of the feedback element, children being decoded or
+			// skipped whole
+			if err := buildHeader(); err != nil {
+				return nil, err
+			}
+			if len(pending) > 0 || offset == 0 {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			return report, nil
 		}
 	}
-
-	return ""
 }
 
-// extractSMTPTLSFromMIMEParts extracts SMTP TLS content from MIME multipart message
-func (p *Parser) extractSMTPTLSFromMIMEParts(body string) string {
-	// Look for Content-Type header with boundary
-	lines := strings.Split(body, "\n")
-	var contentType string
-	bodyStartIdx := 0
+// buildAggregateReport converts a decoded aggregate report to the internal
+// format, enriching its records
+func (p *Parser) buildAggregateReport(feedback *aggregateFeedbackXML) (*AggregateReport, error) {
+	report, err := p.buildAggregateReportHeader(feedback)
+	if err != nil {
+		return nil, err
+	}
 
-	// Find Content-Type header and body start, handling multiline headers
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(strings.ToLower(line), "content-type:") {
-			// Start building content type, may span multiple lines
-			contentType = line
-			// Look ahead for continuation lines (start with whitespace)
-			for j := i + 1; j < len(lines); j++ {
-				nextLine := lines[j]
-				if strings.HasPrefix(nextLine, " ") || strings.HasPrefix(nextLine, "\t") {
-					contentType += " " + strings.TrimSpace(nextLine)
-				} else if strings.TrimSpace(nextLine) == "" {
-					// Empty line after headers marks start of body
-					bodyStartIdx = j + 1
-					break
-				} else {
-					// Non-continuation line, this header is complete
-					break
-				}
-			}
-			break
-		} else if line == "" {
-			// Empty line after headers marks start of body
-			bodyStartIdx = i + 1
-			break
+	// Records at or below the sampling threshold are folded into "other"
+	// records below, so only the kept records need enrichment
+	sampling := p.recordSamplingEnabled(len(feedback.Record))
+	sampled := func(count int) bool {
+		return sampling && count <= p.config.RecordSamplingThreshold
+	}
+
+	// Enrich source IPs up front with a bounded worker pool
+	sourceIPs := make([]string, 0, len(feedback.Record))
+	for _, xmlRecord := range feedback.Record {
+		if !sampled(xmlRecord.Row.Count) {
+			sourceIPs = append(sourceIPs, xmlRecord.Row.SourceIP)
 		}
 	}
+	sources := p.enrichSources(sourceIPs)
+	nextSource := 0
+	others := make(map[string]*Record)
+	var otherDispositions []string
 
-	// Extract boundary from content type
-	var boundary string
-	if strings.Contains(strings.ToLower(contentType), "boundary=") {
-		parts := strings.Split(contentType, "boundary=")
-		if len(parts) >= 2 {
-			boundaryPart := strings.Trim(parts[1], `"`)
-			// Remove any trailing content after the boundary value
-			if idx := strings.Index(boundaryPart, ";"); idx > 0 {
-				boundaryPart = boundaryPart[:idx]
-			}
-			if idx := strings.Index(boundaryPart, " "); idx > 0 {
-				boundaryPart = boundaryPart[:idx]
+	// Parse records
+	for i := range feedback.Record {
+		record := p.buildAggregateRecord(report, &feedback.Record[i])
+
+		if sampled(record.Count) {
+			if _, ok := others[record.PolicyEvaluated.Disposition]; !ok {
+				otherDispositions = append(otherDispositions, record.PolicyEvaluated.Disposition)
 			}
-			boundary = strings.Trim(boundaryPart, `"`)
+			addToOtherRecord(others, record, report.PolicyPublished.Domain)
+			continue
 		}
-	}
 
-	if boundary == "" || !strings.Contains(strings.ToLower(contentType), "multipart") {
-		return ""
+		record.Source = *sources[nextSource]
+		nextSource++
+		report.Records = append(report.Records, record)
 	}
 
-	// Reconstruct the body from bodyStartIdx
-	if bodyStartIdx >= len(lines) {
-		return ""
+	for _, disposition := range otherDispositions {
+		report.Records = append(report.Records, *others[disposition])
 	}
-	bodyLines := lines[bodyStartIdx:]
-	mimeBody := strings.Join(bodyLines, "\n")
 
-	// Extract media type value from header (remove "Content-type: " prefix)
-	mediaTypeValue := contentType
-	if colonIdx := strings.Index(strings.ToLower(contentType), "content-type:"); colonIdx >= 0 {
-		mediaTypeValue = strings.TrimSpace(contentType[colonIdx+13:]) // "content-type:" is 13 chars
+	if sampling {
+		p.logger.Info("Folded low-count records into other records",
+			zap.String("report_id", report.ReportMetadata.ReportID),
+			zap.Int("records", len(feedback.Record)),
+			zap.Int("kept", len(sourceIPs)),
+			zap.Int("threshold", p.config.RecordSamplingThreshold),
+		)
 	}
 
-	// Parse MIME multipart
-	mediaType, params, err := mime.ParseMediaType(mediaTypeValue)
-	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
-		return ""
-	}
+	return report, nil
+}
 
-	mr := multipart.NewReader(strings.NewReader(mimeBody), params["boundary"])
-	if mr == nil {
-		return ""
+// buildAggregateReportHeader converts the metadata and published policy of a
+// decoded aggregate report to the internal format, without records
+func (p *Parser) buildAggregateReportHeader(feedback *aggregateFeedbackXML) (*AggregateReport, error) {
+	// Convert to internal format
+	report := &AggregateReport{
+		XMLSchema: feedback.Version,
+		ReportMetadata: ReportMetadata{
+			OrgName:  feedback.ReportMetadata.OrgName,
+			OrgEmail: feedback.ReportMetadata.Email,
+			ReportID: feedback.ReportMetadata.ReportID,
+			Errors:   feedback.ReportMetadata.Error,
+		},
+		PolicyPublished: PolicyPublished{
+			Domain: feedback.PolicyPublished.Domain,
+			ADKIM:  utils.DefaultString(feedback.PolicyPublished.ADKIM, "r"),
+			ASPF:   utils.DefaultString(feedback.PolicyPublished.ASPF, "r"),
+			P:      feedback.PolicyPublished.P,
+			SP:     utils.DefaultString(feedback.PolicyPublished.SP, feedback.PolicyPublished.P),
+			PCT:    utils.DefaultString(feedback.PolicyPublished.PCT, "100"),
+			FO:     utils.DefaultString(feedback.PolicyPublished.FO, "0"),
+		},
 	}
 
-	// Process each MIME part
-	for {
-		part, err := mr.NextPart()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			continue
-		}
+	if feedback.ReportMetadata.ExtraContactInfo != "" {
+		report.ReportMetadata.OrgExtraContactInfo = &feedback.ReportMetadata.ExtraContactInfo
+	}
 
-		// Read part content
-		content, err := io.ReadAll(part)
-		if err != nil {
-			part.Close()
-			continue
-		}
-		part.Close()
+	// Bound the identifiers used as storage keys
+	report.ReportMetadata.ReportID = p.limitIdentifier("aggregate", "report_id", report.ReportMetadata.ReportID)
+	report.ReportMetadata.OrgName = p.limitIdentifier("aggregate", "org_name", report.ReportMetadata.OrgName)
+	report.PolicyPublished.Domain = p.limitIdentifier("aggregate", "domain", report.PolicyPublished.Domain)
 
-		contentStr := string(content)
-		partContentType := part.Header.Get("Content-Type")
-		contentTransferEncoding := part.Header.Get("Content-Transfer-Encoding")
+	report.PolicyPublished.PCTValue = p.parsePCT(report.ReportMetadata.ReportID, report.PolicyPublished.PCT)
 
-		// Handle base64 encoded content
-		if strings.ToLower(contentTransferEncoding) == "base64" {
-			// Clean base64 string (remove whitespace and newlines)
-			cleanB64 := strings.ReplaceAll(strings.ReplaceAll(contentStr, "\n", ""), "\r", "")
-			cleanB64 = strings.ReplaceAll(cleanB64, " ", "")
+	// Parse dates
+	beginDate, err := utils.ParseTimestamp(feedback.ReportMetadata.DateRange.Begin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse begin date: %w", err)
+	}
+	report.ReportMetadata.BeginDate = beginDate
 
-			decoded, err := base64.StdEncoding.DecodeString(cleanB64)
-			if err == nil {
-				contentStr = string(decoded)
-			}
-		}
+	endDate, err := utils.ParseTimestamp(feedback.ReportMetadata.DateRange.End)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse end date: %w", err)
+	}
+	report.ReportMetadata.EndDate = endDate
 
-		// Handle gzip compressed content
-		if strings.Contains(strings.ToLower(partContentType), "gzip") && len(contentStr) > 0 {
-			if reader, err := gzip.NewReader(bytes.NewReader([]byte(contentStr))); err == nil {
-				if decompressed, err := io.ReadAll(reader); err == nil {
-					contentStr = string(decompressed)
-				}
-				reader.Close()
-			}
-		}
+	// Validate date range (max 24 hours per RFC 7489)
+	if endDate.Sub(beginDate) > 48*time.Hour {
+		return nil, fmt.Errorf("time span > 24 hours - RFC 7489 section 7.2")
+	}
 
-		// Look for SMTP TLS report content
-		if strings.Contains(strings.ToLower(partContentType), "application/tlsrpt") ||
-			strings.Contains(strings.ToLower(partContentType), "tlsrpt") ||
-			strings.Contains(contentStr, `"organization-name"`) ||
-			strings.Contains(contentStr, `"report-id"`) {
-			return contentStr
-		}
+	if p.config.Fingerprint {
+		report.Fingerprint = AggregateReportFingerprint(report)
 	}
 
-	return ""
+	p.checkPolicyDrift(report)
+
+	return report, nil
 }
 
-// parseAsAggregateReportWithMetrics parses aggregate report with metrics
-func (p *Parser) parseAsAggregateReportWithMetrics(data []byte, source string, start time.Time, size int) error {
-	report, err := p.parseAggregateXML(data)
-	if err != nil {
-		duration := time.Since(start).Seconds()
-		if p.metrics != nil {
-			p.metrics.RecordParseFailure("aggregate", source, "parse_failed", duration, size)
-		}
-		return err
+// buildAggregateRecord converts a decoded record of report to the internal
+// format. Its source is left for the caller to enrich.
+func (p *Parser) buildAggregateRecord(report *AggregateReport, xmlRecord *aggregateRecordXML) Record {
+	record := Record{
+		Count: xmlRecord.Row.Count,
+		Identifiers: Identifiers{
+			HeaderFrom: strings.ToLower(xmlRecord.Identifiers.HeaderFrom),
+		},
 	}
 
-	if p.storage != nil {
-		if err := p.storage.StoreAggregateReport(report); err != nil {
-			duration := time.Since(start).Seconds()
-			if p.metrics != nil {
-				p.metrics.RecordParseFailure("aggregate", source, "storage_failed", duration, size)
-			}
-			return fmt.Errorf("failed to store aggregate report: %w", err)
+	// Reports that omit <count> decode as 0, which would drop the
+	// record's volume from analysis
+	if record.Count <= 0 && p.config.DefaultMissingCount {
+		p.logger.Warn("Aggregate record has missing or non-positive count, defaulting to 1",
+			zap.String("report_id", report.ReportMetadata.ReportID),
+			zap.String("source_ip", xmlRecord.Row.SourceIP),
+			zap.Int("count", record.Count),
+		)
+		if p.metrics != nil {
+			p.metrics.RecordParseWarning("aggregate", "missing_count")
 		}
+		record.Count = 1
 	}
 
-	duration := time.Since(start).Seconds()
-	if p.metrics != nil {
-		p.metrics.RecordParseSuccess("aggregate", source, duration, size)
+	// Handle envelope from
+	if xmlRecord.Identifiers.EnvelopeFrom != "" {
+		envelopeFrom := strings.ToLower(xmlRecord.Identifiers.EnvelopeFrom)
+		record.Identifiers.EnvelopeFrom = &envelopeFrom
 	}
 
-	p.logger.Info("Successfully parsed aggregate report",
-		zap.String("org", report.ReportMetadata.OrgName),
-		zap.String("report_id", report.ReportMetadata.ReportID),
-		zap.Int("records", len(report.Records)),
-		zap.String("source", source),
-	)
+	// Handle envelope to
+	if xmlRecord.Identifiers.EnvelopeTo != "" {
+		envelopeTo := strings.ToLower(xmlRecord.Identifiers.EnvelopeTo)
+		record.Identifiers.EnvelopeTo = &envelopeTo
+	}
 
-	return nil
-}
+	// Parse policy evaluation
+	record.PolicyEvaluated = PolicyEvaluated{
+		Disposition: xmlRecord.Row.PolicyEvaluated.Disposition,
+		DKIM:        utils.DefaultString(xmlRecord.Row.PolicyEvaluated.DKIM, "fail"),
+		SPF:         utils.DefaultString(xmlRecord.Row.PolicyEvaluated.SPF, "fail"),
+	}
 
-// parseAsForensicReportWithMetrics parses forensic report with metrics
-func (p *Parser) parseAsForensicReportWithMetrics(data []byte, source string, start time.Time, size int) error {
-	report, err := p.parseForensicEmail(data)
-	if err != nil {
-		duration := time.Since(start).Seconds()
-		if p.metrics != nil {
-			p.metrics.RecordParseFailure("forensic", source, "parse_failed", duration, size)
+	// Parse policy override reasons
+	for _, reason := range xmlRecord.Row.PolicyEvaluated.Reason {
+		por := PolicyOverrideReason{}
+		if reason.Type != "" {
+			por.Type = &reason.Type
 		}
-		return err
+		if reason.Comment != "" {
+			por.Comment = &reason.Comment
+		}
+		record.PolicyEvaluated.PolicyOverrideReasons = append(
+			record.PolicyEvaluated.PolicyOverrideReasons, por)
 	}
 
-	if p.storage != nil {
-		if err := p.storage.StoreForensicReport(report); err != nil {
-			duration := time.Since(start).Seconds()
-			if p.metrics != nil {
-				p.metrics.RecordParseFailure("forensic", source, "storage_failed", duration, size)
-			}
-			return fmt.Errorf("failed to store forensic report: %w", err)
+	// Parse auth results
+	for _, dkimResult := range xmlRecord.AuthResults.DKIM {
+		if dkimResult.Domain != "" {
+			record.AuthResults.DKIM = append(record.AuthResults.DKIM, DKIMResult{
+				Domain:   dkimResult.Domain,
+				Selector: utils.DefaultString(dkimResult.Selector, "none"),
+				Result:   utils.DefaultString(dkimResult.Result, "none"),
+			})
 		}
 	}
 
-	duration := time.Since(start).Seconds()
-	if p.metrics != nil {
-		p.metrics.RecordParseSuccess("forensic", source, duration, size)
+	for _, spfResult := range xmlRecord.AuthResults.SPF {
+		if spfResult.Domain != "" {
+			record.AuthResults.SPF = append(record.AuthResults.SPF, SPFResult{
+				Domain: spfResult.Domain,
+				Scope:  utils.DefaultString(spfResult.Scope, "mfrom"),
+				Result: utils.DefaultString(spfResult.Result, "none"),
+			})
+		}
 	}
 
-	p.logger.Info("Successfully parsed forensic report",
-		zap.String("subject", report.Subject),
-		zap.String("source_ip", report.Source.IPAddress),
-		zap.String("reported_domain", report.ReportedDomain),
-		zap.String("source", source),
-	)
+	record.Alignment = recordAlignment(record, report.PolicyPublished)
+	record.EffectivePolicy, record.SampledOut = p.effectivePolicy(report, record)
 
-	return nil
+	return record
 }
 
-// parseAsSMTPTLSReportWithMetrics parses SMTP TLS report with metrics
-func (p *Parser) parseAsSMTPTLSReportWithMetrics(data []byte, source string, start time.Time, size int) error {
-	// First try to parse as direct JSON
-	var report SMTPTLSReport
-	var parseErr error
-	if err := p.parseJSONWithLineInfo(data, &report); err == nil {
-		// Direct JSON parsing succeeded
-		return p.processSMTPTLSReportWithMetrics(&report, source, start, size)
-	} else {
-		parseErr = err
+// recordAlignment computes the DMARC alignment of record (RFC 7489 section
+// 3.1): DKIM is aligned when a passing signature's d= domain matches the
+// header From domain, SPF when a passing MAIL FROM check does, exactly with
+// the strict adkim/aspf mode "s" and by organizational domain with the
+// relaxed mode. A mechanism without auth results, as in reports that omit
+// them, falls back to the reporter's policy_evaluated outcome.
+func recordAlignment(record Record, policy PolicyPublished) Alignment {
+	headerFrom := record.Identifiers.HeaderFrom
+
+	dkimAligned := strings.ToLower(record.PolicyEvaluated.DKIM) == "pass"
+	if len(record.AuthResults.DKIM) > 0 && headerFrom != "" {
+		dkimAligned = false
+		for _, result := range record.AuthResults.DKIM {
+			if strings.EqualFold(result.Result, "pass") && domainsAligned(result.Domain, headerFrom, policy.ADKIM) {
+				dkimAligned = true
+				break
+			}
+		}
 	}
 
-	// Try to parse as email containing SMTP TLS report
-	if reportFromEmail, err := p.parseSMTPTLSEmail(data); err == nil {
-		return p.processSMTPTLSReportWithMetrics(reportFromEmail, source, start, size)
+	spfAligned := strings.ToLower(record.PolicyEvaluated.SPF) == "pass"
+	if len(record.AuthResults.SPF) > 0 && headerFrom != "" {
+		spfAligned = false
+		for _, result := range record.AuthResults.SPF {
+			// Only the MAIL FROM identity counts towards DMARC, not HELO
+			if strings.EqualFold(result.Scope, "mfrom") && strings.EqualFold(result.Result, "pass") &&
+				domainsAligned(result.Domain, headerFrom, policy.ASPF) {
+				spfAligned = true
+				break
+			}
+		}
 	}
 
-	// Both parsing attempts failed
-	duration := time.Since(start).Seconds()
-	if p.metrics != nil {
-		p.metrics.RecordParseFailure("smtp_tls", source, "parse_failed", duration, size)
+	return Alignment{
+		SPF:   spfAligned,
+		DKIM:  dkimAligned,
+		DMARC: spfAligned || dkimAligned,
 	}
-	return fmt.Errorf("failed to parse SMTP TLS report: %w", parseErr)
 }
 
-// processSMTPTLSReportWithMetrics handles storage, metrics and logging for SMTP TLS reports
-func (p *Parser) processSMTPTLSReportWithMetrics(report *SMTPTLSReport, source string, start time.Time, size int) error {
-	if p.storage != nil {
-		if err := p.storage.StoreSMTPTLSReport(report); err != nil {
-			duration := time.Since(start).Seconds()
-			if p.metrics != nil {
-				p.metrics.RecordParseFailure("smtp_tls", source, "storage_failed", duration, size)
-			}
-			return fmt.Errorf("failed to store SMTP TLS report: %w", err)
+// effectivePolicy returns the policy that applied to record and whether it
+// was relaxed by pct sampling. With a pct below 100, receivers apply the
+// policy to that percentage of failing messages only and one step less
+// strict to the others, which they report with a sampled_out override
+// reason (RFC 7489 section 6.6.4).
+func (p *Parser) effectivePolicy(report *AggregateReport, record Record) (string, bool) {
+	policy := report.PolicyPublished
+	requested := strings.ToLower(policy.P)
+	domain := strings.TrimSuffix(utils.NormalizeDomain(policy.Domain), ".")
+	headerFrom := strings.TrimSuffix(utils.NormalizeDomain(record.Identifiers.HeaderFrom), ".")
+	if domain != "" && strings.HasSuffix(headerFrom, "."+domain) {
+		requested = strings.ToLower(policy.SP)
+	}
+
+	sampledOut := false
+	for _, reason := range record.PolicyEvaluated.PolicyOverrideReasons {
+		if reason.Type != nil && strings.EqualFold(*reason.Type, "sampled_out") {
+			sampledOut = true
+			break
 		}
 	}
+	if !sampledOut {
+		return requested, false
+	}
 
-	duration := time.Since(start).Seconds()
-	if p.metrics != nil {
-		p.metrics.RecordParseSuccess("smtp_tls", source, duration, size)
+	if policy.PCTValue >= 100 {
+		p.logger.Warn("Aggregate record is sampled out although pct is 100",
+			zap.String("report_id", report.ReportMetadata.ReportID),
+			zap.String("header_from", record.Identifiers.HeaderFrom),
+		)
+		if p.metrics != nil {
+			p.metrics.RecordParseWarning("aggregate", "sampled_out_full_pct")
+		}
+		return requested, false
 	}
 
-	p.logger.Info("Successfully parsed SMTP TLS report",
-		zap.String("org", report.OrganizationName),
-		zap.String("report_id", report.ReportID),
-		zap.Int("policies", len(report.Policies)),
-		zap.String("source", source),
-	)
+	switch requested {
+	case "reject":
+		return "quarantine", true
+	case "quarantine":
+		return "none", true
+	}
+	return requested, true
+}
 
-	return nil
+// domainsAligned reports whether an authenticated domain is aligned with the
+// header From domain in alignment mode "s" (strict, identical domains) or "r"
+// (relaxed, same organizational domain)
+func domainsAligned(authDomain, headerFrom, mode string) bool {
+	authDomain = strings.TrimSuffix(utils.NormalizeDomain(authDomain), ".")
+	headerFrom = strings.TrimSuffix(utils.NormalizeDomain(headerFrom), ".")
+	if authDomain == "" || headerFrom == "" {
+		return false
+	}
+	if authDomain == headerFrom {
+		return true
+	}
+	if strings.EqualFold(mode, "s") {
+		return false
+	}
+	return utils.GetBaseDomain(authDomain) == utils.GetBaseDomain(headerFrom)
 }
 
-// parseXMLWithLineInfo wraps XML parsing to provide line number information on errors
-func (p *Parser) parseXMLWithLineInfo(data []byte, v interface{}) error {
-	// Check data size and log warning for large files
-	if len(data) > 50*1024*1024 { // 50MB
-		p.logger.Warn("Parsing large XML file",
-			zap.Int("size_mb", len(data)/(1024*1024)),
-			zap.String("note", "this may take a while and use significant memory"),
-		)
+// checkPolicyDrift warns when the policy published in a report for one of
+// the monitored domains differs from the DMARC record currently published
+// for it, which means the report predates a policy change or the record is
+// misconfigured. Enabled by policy_drift; never done in offline mode.
+func (p *Parser) checkPolicyDrift(report *AggregateReport) {
+	if !p.config.PolicyDrift || p.config.Offline {
+		return
 	}
 
-	decoder := xml.NewDecoder(bytes.NewReader(data))
-	// Configure decoder for better memory usage with large files
-	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
-		// Handle different charset encodings if needed
-		return input, nil
+	domain := utils.NormalizeDomain(report.PolicyPublished.Domain)
+	if !p.isMonitoredDomain(domain) {
+		return
 	}
 
-	err := decoder.Decode(v)
+	record, err := getDMARCRecord(domain, p.config.Nameservers, p.config.DNSTimeout)
 	if err != nil {
-		// Try to get line information from XML syntax errors
-		if syntaxErr, ok := err.(*xml.SyntaxError); ok {
-			return fmt.Errorf("XML syntax error at line %d: %w", syntaxErr.Line, err)
-		}
-		// For other XML errors, try to extract line info if available
-		errStr := err.Error()
-		if strings.Contains(errStr, "line ") {
-			return fmt.Errorf("XML parsing error: %w", err)
-		}
-		return fmt.Errorf("XML parsing error (unable to determine line): %w", err)
+		p.logger.Warn("Failed to look up DMARC record for policy drift detection",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+		return
+	}
+
+	for _, drift := range policyDrift(report.PolicyPublished, utils.ParseDMARCRecord(record)) {
+		if p.metrics != nil {
+			p.metrics.RecordPolicyDrift(domain, drift.tag)
+		}
+		p.logger.Warn("Report policy differs from the published DMARC record",
+			zap.String("domain", domain),
+			zap.String("report_id", report.ReportMetadata.ReportID),
+			zap.String("org", report.ReportMetadata.OrgName),
+			zap.String("tag", drift.tag),
+			zap.String("reported", drift.reported),
+			zap.String("published", drift.published),
+		)
 	}
-	return nil
 }
 
-// parseJSONWithLineInfo wraps JSON parsing to provide line number information on errors
-func (p *Parser) parseJSONWithLineInfo(data []byte, v interface{}) error {
-	err := json.Unmarshal(data, v)
-	if err != nil {
-		// Try to get line information from JSON syntax errors
-		if syntaxErr, ok := err.(*json.SyntaxError); ok {
-			// Calculate line number by counting newlines up to the error offset
-			lines := bytes.Count(data[:syntaxErr.Offset], []byte{'\n'}) + 1
-			return fmt.Errorf("JSON syntax error at line %d: %w", lines, err)
+// isMonitoredDomain reports whether the normalized domain is one of
+// monitored_domains
+func (p *Parser) isMonitoredDomain(domain string) bool {
+	for _, d := range p.config.MonitoredDomains {
+		if utils.NormalizeDomain(d) == domain {
+			return true
 		}
-		// For other JSON errors, try to extract line info if available
-		errStr := err.Error()
-		if strings.Contains(errStr, "line ") {
-			return fmt.Errorf("JSON parsing error: %w", err)
+	}
+	return false
+}
+
+// recordDomainFailures adds the messages of a parsed aggregate report that
+// failed DMARC to the failing messages gauge of its policy domain. Only
+// monitored domains are tracked, bounding the number of label values.
+// Enabled by domain_failure_metrics.
+func (p *Parser) recordDomainFailures(report *AggregateReport) {
+	if !p.config.DomainFailureMetrics || p.metrics == nil {
+		return
+	}
+
+	domain := utils.NormalizeDomain(report.PolicyPublished.Domain)
+	if !p.isMonitoredDomain(domain) {
+		return
+	}
+
+	failing := 0
+	for _, record := range report.Records {
+		if !record.Alignment.DMARC {
+			failing += record.Count
 		}
-		return fmt.Errorf("JSON parsing error (unable to determine line): %w", err)
 	}
-	return nil
+	p.metrics.AddDomainFailingMessages(domain, failing)
 }
 
-// parseAggregateXML parses XML aggregate DMARC report
-func (p *Parser) parseAggregateXML(data []byte) (*AggregateReport, error) {
-	// Handle XML files that may have schema declarations or other wrapper elements
-	// Look for the <feedback> element and extract just that part
-	dataStr := string(data)
-	feedbackStart := strings.Index(dataStr, "<feedback>")
-	feedbackEnd := strings.LastIndex(dataStr, "</feedback>")
+// tagDrift is a DMARC tag whose value in a report differs from the value
+// currently published
+type tagDrift struct {
+	tag       string
+	reported  string
+	published string
+}
 
-	if feedbackStart != -1 && feedbackEnd != -1 && feedbackEnd > feedbackStart {
-		// Extract just the feedback section
-		feedbackXML := dataStr[feedbackStart : feedbackEnd+len("</feedback>")]
-		data = []byte(feedbackXML)
-		p.logger.Debug("Extracted feedback section from XML with schema/wrapper elements",
-			zap.Int("originalSize", len(dataStr)),
-			zap.Int("extractedSize", len(feedbackXML)))
+// policyDrift compares the policy published in a report with the tags of a
+// DMARC record, applying the RFC 7489 defaults to tags missing from the record
+func policyDrift(reported PolicyPublished, tags map[string]string) []tagDrift {
+	published := func(tag, defaultValue string) string {
+		return utils.DefaultString(tags[tag], defaultValue)
 	}
 
-	var feedback struct {
-		XMLName        xml.Name `xml:"feedback"`
-		Version        string   `xml:"version,omitempty"`
-		ReportMetadata struct {
-			OrgName          string `xml:"org_name"`
-			Email            string `xml:"email"`
-			ExtraContactInfo string `xml:"extra_contact_info,omitempty"`
-			ReportID         string `xml:"report_id"`
-			DateRange        struct {
-				Begin string `xml:"begin"`
-				End   string `xml:"end"`
-			} `xml:"date_range"`
-			Error []string `xml:"error,omitempty"`
-		} `xml:"report_metadata"`
-		PolicyPublished struct {
-			Domain string `xml:"domain"`
-			ADKIM  string `xml:"adkim,omitempty"`
-			ASPF   string `xml:"aspf,omitempty"`
-			P      string `xml:"p"`
-			SP     string `xml:"sp,omitempty"`
-			PCT    string `xml:"pct,omitempty"`
-			FO     string `xml:"fo,omitempty"`
-		} `xml:"policy_published"`
-		Record []struct {
-			Row struct {
-				SourceIP        string `xml:"source_ip"`
-				Count           int    `xml:"count"`
-				PolicyEvaluated struct {
-					Disposition string `xml:"disposition"`
-					DKIM        string `xml:"dkim"`
-					SPF         string `xml:"spf"`
-					Reason      []struct {
-						Type    string `xml:"type"`
-						Comment string `xml:"comment,omitempty"`
-					} `xml:"reason,omitempty"`
-				} `xml:"policy_evaluated"`
-			} `xml:"row"`
-			Identifiers struct {
-				HeaderFrom   string `xml:"header_from"`
-				EnvelopeFrom string `xml:"envelope_from,omitempty"`
-				EnvelopeTo   string `xml:"envelope_to,omitempty"`
-			} `xml:"identifiers"`
-			AuthResults struct {
-				DKIM []struct {
-					Domain   string `xml:"domain"`
-					Selector string `xml:"selector,omitempty"`
-					Result   string `xml:"result"`
-				} `xml:"dkim"`
-				SPF []struct {
-					Domain string `xml:"domain"`
-					Scope  string `xml:"scope,omitempty"`
-					Result string `xml:"result"`
-				} `xml:"spf"`
-			} `xml:"auth_results"`
-		} `xml:"record"`
-	}
-
-	if err := p.parseXMLWithLineInfo(data, &feedback); err != nil {
-		return nil, fmt.Errorf("failed to parse aggregate report XML: %w", err)
+	compared := []tagDrift{
+		{"p", reported.P, published("p", "")},
+		{"sp", reported.SP, published("sp", published("p", ""))},
+		{"adkim", reported.ADKIM, published("adkim", "r")},
+		{"aspf", reported.ASPF, published("aspf", "r")},
+		{"pct", reported.PCT, published("pct", "100")},
+		{"fo", reported.FO, published("fo", "0")},
 	}
 
-	// Convert to internal format
-	report := &AggregateReport{
-		XMLSchema: feedback.Version,
-		ReportMetadata: ReportMetadata{
-			OrgName:  feedback.ReportMetadata.OrgName,
-			OrgEmail: feedback.ReportMetadata.Email,
-			ReportID: feedback.ReportMetadata.ReportID,
-			Errors:   feedback.ReportMetadata.Error,
-		},
-		PolicyPublished: PolicyPublished{
-			Domain: feedback.PolicyPublished.Domain,
-			ADKIM:  utils.DefaultString(feedback.PolicyPublished.ADKIM, "r"),
-			ASPF:   utils.DefaultString(feedback.PolicyPublished.ASPF, "r"),
-			P:      feedback.PolicyPublished.P,
-			SP:     utils.DefaultString(feedback.PolicyPublished.SP, feedback.PolicyPublished.P),
-			PCT:    utils.DefaultString(feedback.PolicyPublished.PCT, "100"),
-			FO:     utils.DefaultString(feedback.PolicyPublished.FO, "0"),
-		},
+	var drifts []tagDrift
+	for _, c := range compared {
+		if !strings.EqualFold(strings.TrimSpace(c.reported), c.published) {
+			drifts = append(drifts, c)
+		}
 	}
+	return drifts
+}
 
-	if feedback.ReportMetadata.ExtraContactInfo != "" {
-		report.ReportMetadata.OrgExtraContactInfo = &feedback.ReportMetadata.ExtraContactInfo
+// AggregateReportFingerprint returns a stable identifier for an aggregate
+// report: the hex SHA-256 of its org_name, report_id, begin date and policy
+// domain. Unlike the report ID alone it does not collide between reporting
+// organizations.
+func AggregateReportFingerprint(report *AggregateReport) string {
+	h := sha256.New()
+	for _, field := range []string{
+		report.ReportMetadata.OrgName,
+		report.ReportMetadata.ReportID,
+		strconv.FormatInt(report.ReportMetadata.BeginDate.Unix(), 10),
+		strings.ToLower(report.PolicyPublished.Domain),
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// limitIdentifier strips dangerous sequences from an identifier and
+// truncates it to the configured maximum length, logging a warning when the
+// value is changed
+func (p *Parser) limitIdentifier(reportType, field, value string) string {
+	result, sanitized, truncated := utils.SanitizeIdentifier(value, p.config.MaxIdentifierLength)
+
+	if sanitized {
+		p.logger.Warn("Removed dangerous characters from report field",
+			zap.String("type", reportType),
+			zap.String("field", field),
+		)
+		if p.metrics != nil {
+			p.metrics.RecordParseWarning(reportType, field+"_sanitized")
+		}
 	}
 
-	// Parse dates
-	beginDate, err := utils.ParseTimestamp(feedback.ReportMetadata.DateRange.Begin)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse begin date: %w", err)
+	if truncated {
+		p.logger.Warn("Truncated oversized report field",
+			zap.String("type", reportType),
+			zap.String("field", field),
+			zap.Int("length", len(value)),
+			zap.Int("max_length", p.config.MaxIdentifierLength),
+			zap.String("truncated_value", result),
+		)
+		if p.metrics != nil {
+			p.metrics.RecordParseWarning(reportType, field+"_truncated")
+		}
 	}
-	report.ReportMetadata.BeginDate = beginDate
 
-	endDate, err := utils.ParseTimestamp(feedback.ReportMetadata.DateRange.End)
+	return result
+}
+
+// parsePCT converts the published pct to an integer. Values that are not
+// integers fall back to the RFC 7489 default of 100 and out-of-range values
+// are clamped to 0-100, both with a warning.
+func (p *Parser) parsePCT(reportID, raw string) int {
+	pct, err := strconv.Atoi(strings.TrimSpace(raw))
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse end date: %w", err)
+		p.logger.Warn("Invalid pct in published policy, using 100",
+			zap.String("report_id", reportID),
+			zap.String("pct", raw),
+		)
+		if p.metrics != nil {
+			p.metrics.RecordParseWarning("aggregate", "invalid_pct")
+		}
+		return 100
 	}
-	report.ReportMetadata.EndDate = endDate
 
-	// Validate date range (max 24 hours per RFC 7489)
-	if endDate.Sub(beginDate) > 48*time.Hour {
-		return nil, fmt.Errorf("time span > 24 hours - RFC 7489 section 7.2")
+	if pct < 0 || pct > 100 {
+		clamped := min(max(pct, 0), 100)
+		p.logger.Warn("Out-of-range pct in published policy",
+			zap.String("report_id", reportID),
+			zap.Int("pct", pct),
+			zap.Int("clamped", clamped),
+		)
+		if p.metrics != nil {
+			p.metrics.RecordParseWarning("aggregate", "pct_out_of_range")
+		}
+		return clamped
 	}
 
-	// Parse records
-	for _, xmlRecord := range feedback.Record {
-		record := Record{
-			Count: xmlRecord.Row.Count,
+	return pct
+}
+
+// recordSamplingEnabled reports whether the long tail of a report with n
+// records should be folded into per-disposition "other" records
+func (p *Parser) recordSamplingEnabled(n int) bool {
+	return p.config.RecordSamplingThreshold > 0 && n > p.config.RecordSamplingMinRecords
+}
+
+// addToOtherRecord folds a low-count record into the synthetic "other"
+// record for its disposition. Counts are summed; alignment and sampling hold
+// only if they held for every folded record, and DKIM/SPF results and
+// effective policies that differ become "mixed".
+func addToOtherRecord(others map[string]*Record, record Record, domain string) {
+	disposition := record.PolicyEvaluated.Disposition
+
+	other, ok := others[disposition]
+	if !ok {
+		others[disposition] = &Record{
+			Source: Source{
+				Name: OtherSourceName,
+				Type: OtherSourceName,
+			},
+			Count:     record.Count,
+			Alignment: record.Alignment,
+			PolicyEvaluated: PolicyEvaluated{
+				Disposition: disposition,
+				DKIM:        record.PolicyEvaluated.DKIM,
+				SPF:         record.PolicyEvaluated.SPF,
+			},
 			Identifiers: Identifiers{
-				HeaderFrom: strings.ToLower(xmlRecord.Identifiers.HeaderFrom),
+				HeaderFrom: domain,
 			},
+			EffectivePolicy: record.EffectivePolicy,
+			SampledOut:      record.SampledOut,
 		}
+		return
+	}
 
-		// Handle envelope from
-		if xmlRecord.Identifiers.EnvelopeFrom != "" {
-			envelopeFrom := strings.ToLower(xmlRecord.Identifiers.EnvelopeFrom)
-			record.Identifiers.EnvelopeFrom = &envelopeFrom
-		}
-
-		// Handle envelope to
-		if xmlRecord.Identifiers.EnvelopeTo != "" {
-			envelopeTo := strings.ToLower(xmlRecord.Identifiers.EnvelopeTo)
-			record.Identifiers.EnvelopeTo = &envelopeTo
-		}
+	other.Count += record.Count
+	other.Alignment.SPF = other.Alignment.SPF && record.Alignment.SPF
+	other.Alignment.DKIM = other.Alignment.DKIM && record.Alignment.DKIM
+	other.Alignment.DMARC = other.Alignment.DMARC && record.Alignment.DMARC
+	other.SampledOut = other.SampledOut && record.SampledOut
+	if other.EffectivePolicy != record.EffectivePolicy {
+		other.EffectivePolicy = "mixed"
+	}
+	if other.PolicyEvaluated.DKIM != record.PolicyEvaluated.DKIM {
+		other.PolicyEvaluated.DKIM = "mixed"
+	}
+	if other.PolicyEvaluated.SPF != record.PolicyEvaluated.SPF {
+		other.PolicyEvaluated.SPF = "mixed"
+	}
+}
 
-		// Parse source IP information
-		source, err := p.parseSourceIP(xmlRecord.Row.SourceIP)
-		if err != nil {
-			p.logger.Warn("Failed to parse source IP",
-				zap.String("ip", xmlRecord.Row.SourceIP),
-				zap.Error(err),
-			)
-			// Create basic source info
-			source = &Source{
-				IPAddress: xmlRecord.Row.SourceIP,
-				Country:   "Unknown",
-				Type:      "Unknown",
-			}
-		}
-		record.Source = *source
+// enrichSources resolves source information for each IP address, running at
+// most EnrichmentConcurrency lookups at a time. Results keep the order of
+// ipAddresses.
+func (p *Parser) enrichSources(ipAddresses []string) []*Source {
+	sources := make([]*Source, len(ipAddresses))
 
-		// Parse policy evaluation
-		record.PolicyEvaluated = PolicyEvaluated{
-			Disposition: xmlRecord.Row.PolicyEvaluated.Disposition,
-			DKIM:        utils.DefaultString(xmlRecord.Row.PolicyEvaluated.DKIM, "fail"),
-			SPF:         utils.DefaultString(xmlRecord.Row.PolicyEvaluated.SPF, "fail"),
-		}
+	workers := p.config.EnrichmentConcurrency
+	if workers > len(ipAddresses) {
+		workers = len(ipAddresses)
+	}
 
-		// Parse policy override reasons
-		for _, reason := range xmlRecord.Row.PolicyEvaluated.Reason {
-			por := PolicyOverrideReason{}
-			if reason.Type != "" {
-				por.Type = &reason.Type
-			}
-			if reason.Comment != "" {
-				por.Comment = &reason.Comment
-			}
-			record.PolicyEvaluated.PolicyOverrideReasons = append(
-				record.PolicyEvaluated.PolicyOverrideReasons, por)
-		}
-
-		// Parse alignment
-		spfAligned := strings.ToLower(record.PolicyEvaluated.SPF) == "pass"
-		dkimAligned := strings.ToLower(record.PolicyEvaluated.DKIM) == "pass"
-		record.Alignment = Alignment{
-			SPF:   spfAligned,
-			DKIM:  dkimAligned,
-			DMARC: spfAligned || dkimAligned,
-		}
-
-		// Parse auth results
-		for _, dkimResult := range xmlRecord.AuthResults.DKIM {
-			if dkimResult.Domain != "" {
-				record.AuthResults.DKIM = append(record.AuthResults.DKIM, DKIMResult{
-					Domain:   dkimResult.Domain,
-					Selector: utils.DefaultString(dkimResult.Selector, "none"),
-					Result:   utils.DefaultString(dkimResult.Result, "none"),
-				})
-			}
+	if workers <= 1 {
+		for i, ipAddress := range ipAddresses {
+			sources[i] = p.enrichSource(ipAddress)
 		}
+		return sources
+	}
 
-		for _, spfResult := range xmlRecord.AuthResults.SPF {
-			if spfResult.Domain != "" {
-				record.AuthResults.SPF = append(record.AuthResults.SPF, SPFResult{
-					Domain: spfResult.Domain,
-					Scope:  utils.DefaultString(spfResult.Scope, "mfrom"),
-					Result: utils.DefaultString(spfResult.Result, "none"),
-				})
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				sources[i] = p.enrichSource(ipAddresses[i])
 			}
-		}
+		}()
+	}
 
-		report.Records = append(report.Records, record)
+	for i := range ipAddresses {
+		indexes <- i
 	}
+	close(indexes)
+	wg.Wait()
 
-	return report, nil
+	return sources
+}
+
+// enrichSource parses source IP information, falling back to basic source
+// info if the lookup fails
+func (p *Parser) enrichSource(ipAddress string) *Source {
+	source, err := p.parseSourceIP(ipAddress)
+	if err != nil {
+		p.logger.Warn("Failed to parse source IP",
+			zap.String("ip", ipAddress),
+			zap.Error(err),
+		)
+		return &Source{
+			IPAddress: ipAddress,
+			Country:   "Unknown",
+			Type:      "Unknown",
+		}
+	}
+	return source
 }
 
 // parseSourceIP parses source IP information including geolocation
 func (p *Parser) parseSourceIP(ipAddress string) (*Source, error) {
+	// Reporters write IPv6 addresses in various forms; lookups, the DNS
+	// cache and storage all use the canonical one
+	ipAddress = utils.NormalizeIPAddress(ipAddress)
+
 	source := &Source{
 		IPAddress: ipAddress,
 		Country:   "Unknown",
 		Type:      "Unknown",
 	}
 
+	// Private and reserved addresses have no useful DNS/GeoIP data
+	if p.config.SkipPrivateIPs {
+		if ipType := utils.ClassifyIPAddress(ipAddress); ipType != "" {
+			source.Type = ipType
+			return source, nil
+		}
+	}
+
 	if !p.config.Offline {
 		// Get geolocation info
 		if p.config.IPDBPath != "" {
-			geo, err := utils.GetGeoLocation(ipAddress, p.config.IPDBPath)
+			geo, err := getGeoLocation(ipAddress, p.config.IPDBPath)
 			if err == nil {
 				source.Country = geo.Country
+				source.City = geo.City
+				source.Latitude = geo.Latitude
+				source.Longitude = geo.Longitude
+			}
+		}
+
+		// Get autonomous system info
+		if p.config.ASNDBPath != "" {
+			asn, err := getASN(ipAddress, p.config.ASNDBPath)
+			if err == nil {
+				source.ASN = asn.ASN
+				source.ASNOrg = asn.Org
 			}
 		}
 
 		// Get reverse DNS
 		if len(p.config.Nameservers) > 0 {
-			reverseDNS, err := utils.GetReverseDNS(ipAddress, p.config.Nameservers, p.config.DNSTimeout)
+			reverseDNS, err := p.reverseDNS(ipAddress)
 			if err == nil {
 				source.ReverseDNS = reverseDNS
 				source.BaseDomain = utils.GetBaseDomain(reverseDNS)
 				source.Name = reverseDNS
+				if service, ok := p.reverseDNSMap.Lookup(source.BaseDomain); ok {
+					source.Name = service.Name
+					if service.Type != "" {
+						source.Type = service.Type
+					}
+				}
 			}
 		}
 	}
@@ -1299,18 +3344,28 @@ func (p *Parser) parseSourceIP(ipAddress string) (*Source, error) {
 	return source, nil
 }
 
+// reverseDNS looks up the PTR hostname of ipAddress, through the DNS cache
+// when it is enabled
+func (p *Parser) reverseDNS(ipAddress string) (string, error) {
+	lookup := func(ipAddress string) (string, error) {
+		return getReverseDNS(ipAddress, p.config.Nameservers, p.config.DNSTimeout)
+	}
+	if p.dnsCache == nil {
+		return lookup(ipAddress)
+	}
+	return p.dnsCache.Lookup(ipAddress, lookup)
+}
+
 // parseForensicEmail parses a forensic DMARC report from email data
 func (p *Parser) parseForensicEmail(emailData []byte) (*ForensicReport, error) {
-	// Parse the email message
-	emailStr := string(emailData)
+	// Reports mix CRLF, LF and even CR-only line endings, sometimes within
+	// one message; header, MIME and feedback extraction all expect LF
+	emailStr := normalizeLineEndings(string(emailData))
 
 	// Split email into headers and body parts
-	parts := strings.Split(emailStr, "\r\n\r\n")
+	parts := strings.Split(emailStr, "\n\n")
 	if len(parts) < 2 {
-		parts = strings.Split(emailStr, "\n\n")
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("invalid email format")
-		}
+		return nil, fmt.Errorf("invalid email format")
 	}
 
 	headers := parts[0]
@@ -1321,7 +3376,7 @@ func (p *Parser) parseForensicEmail(emailData []byte) (*ForensicReport, error) {
 	// Look for feedback report and sample in the complete email
 	feedbackReport, sample := p.extractForensicParts(emailStr)
 	if feedbackReport == "" {
-		return nil, fmt.Errorf("no feedback report found")
+		return nil, errNoFeedbackReport
 	}
 
 	// Parse the feedback report section
@@ -1337,29 +3392,104 @@ func (p *Parser) parseForensicEmail(emailData []byte) (*ForensicReport, error) {
 	return report, nil
 }
 
-// parseEmailHeaders extracts relevant headers from email
+// normalizeLineEndings converts CRLF and lone CR line endings to LF
+func normalizeLineEndings(s string) string {
+	if !strings.Contains(s, "\r") {
+		return s
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
+
+// parseEmailHeaders extracts relevant headers from email. The arrival date
+// is taken from the Date header, which net/mail parses in any RFC 5322 form
+// including obsolete zones and trailing comments; it falls back to the
+// current time only when the header is missing or cannot be parsed.
 func (p *Parser) parseEmailHeaders(headers string) (subject, messageID string, arrivalDate time.Time) {
-	arrivalDate = time.Now().UTC() // default
+	header := readEmailHeader(headers)
+	subject = p.decodeHeader("Subject", header.Get("Subject"))
+	messageID = p.decodeHeader("Message-Id", header.Get("Message-Id"))
 
-	lines := strings.Split(headers, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	date, err := header.Date()
+	if err == nil {
+		return subject, messageID, date.UTC()
+	}
+
+	if raw := header.Get("Date"); raw != "" {
+		p.logger.Warn("Invalid Date header in forensic report, using current time",
+			zap.String("date", raw),
+			zap.Error(err),
+		)
+		if p.metrics != nil {
+			p.metrics.RecordParseWarning("forensic", "invalid_date")
+		}
+	}
+	return subject, messageID, time.Now().UTC()
+}
 
-		if strings.HasPrefix(strings.ToLower(line), "subject:") {
-			subject = strings.TrimSpace(line[8:])
-		} else if strings.HasPrefix(strings.ToLower(line), "message-id:") {
-			messageID = strings.TrimSpace(line[11:])
-		} else if strings.HasPrefix(strings.ToLower(line), "date:") {
-			dateStr := strings.TrimSpace(line[5:])
-			if parsed, err := time.Parse(time.RFC1123Z, dateStr); err == nil {
-				arrivalDate = parsed.UTC()
-			} else if parsed, err := time.Parse("Mon, 2 Jan 2006 15:04:05 -0700", dateStr); err == nil {
-				arrivalDate = parsed.UTC()
+// headerWordDecoder decodes RFC 2047 encoded-words in any charset known to
+// the WHATWG encoding standard
+var headerWordDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// decodeHeader decodes the RFC 2047 encoded-words of a header value to UTF-8.
+// Values that cannot be decoded are kept as they are, with invalid UTF-8
+// replaced.
+func (p *Parser) decodeHeader(name, value string) string {
+	decoded, err := headerWordDecoder.DecodeHeader(value)
+	if err != nil {
+		p.logger.Debug("Failed to decode header",
+			zap.String("header", name),
+			zap.Error(err),
+		)
+		decoded = value
+	}
+	return strings.ToValidUTF8(decoded, "\uFFFD")
+}
+
+// readEmailHeader parses the LF-separated header section of an email with
+// net/mail. Lines that are not header fields, such as the mbox "From "
+// separator, make net/mail reject the whole section, so they are skipped
+// along with their continuation lines.
+func readEmailHeader(headers string) mail.Header {
+	var kept []string
+	skipping := true
+	for _, line := range strings.Split(headers, "\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if !skipping {
+				kept = append(kept, line)
 			}
+			continue
+		}
+
+		name, _, found := strings.Cut(line, ":")
+		skipping = !found || !isHeaderFieldName(name)
+		if !skipping {
+			kept = append(kept, line)
 		}
 	}
 
-	return
+	msg, err := mail.ReadMessage(strings.NewReader(strings.Join(kept, "\n") + "\n\n"))
+	if err != nil {
+		return mail.Header{}
+	}
+	return msg.Header
+}
+
+// isHeaderFieldName reports whether name is a header field name that
+// net/textproto accepts
+func isHeaderFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 // extractForensicParts extracts feedback report and sample from email body
@@ -1507,12 +3637,11 @@ func (p *Parser) extractFromMIME(body string) (feedbackReport, sample string) {
 
 	// Process each MIME part
 	for {
+		// NextPart keeps failing once the body is malformed, so any error
+		// ends the iteration
 		part, err := mr.NextPart()
-		if err == io.EOF {
-			break
-		}
 		if err != nil {
-			continue
+			break
 		}
 
 		// Read part content
@@ -1621,28 +3750,17 @@ func (p *Parser) parseFeedbackReport(feedbackReport, sample string, arrivalDate
 			report.Source = *source
 		case "authentication-results":
 			report.AuthenticationResults = value
+			report.ParsedAuthenticationResults = parseAuthenticationResults(value)
 		case "dkim-domain":
 			report.DKIMDomain = &value
 		case "reported-domain":
-			report.ReportedDomain = value
+			p.addReportedDomains(report, value)
 		case "delivery-result":
 			report.DeliveryResult = value
 		case "auth-failure":
-			// Split comma-separated auth failures
-			failures := strings.Split(value, ",")
-			for i, failure := range failures {
-				failures[i] = strings.TrimSpace(failure)
-			}
-			report.AuthFailure = failures
+			report.AuthFailure, report.AuthFailureRaw = p.normalizeTokens(field, value, authFailureVocabulary)
 		case "identity-alignment":
-			// Parse authentication mechanisms
-			if value != "none" {
-				mechanisms := strings.Split(value, ",")
-				for i, mech := range mechanisms {
-					mechanisms[i] = strings.TrimSpace(mech)
-				}
-				report.AuthenticationMechanisms = mechanisms
-			}
+			report.AuthenticationMechanisms, report.AuthenticationMechanismsRaw = p.normalizeTokens(field, value, identityAlignmentVocabulary)
 		}
 	}
 
@@ -1672,7 +3790,7 @@ func (p *Parser) parseFeedbackReport(feedbackReport, sample string, arrivalDate
 
 	if report.ReportedDomain == "" && report.Source.IPAddress != "" {
 		// Try to extract domain from sample headers if available
-		report.ReportedDomain = p.extractDomainFromSample(sample)
+		report.ReportedDomain = utils.NormalizeDomain(p.extractDomainFromSample(sample))
 	}
 
 	// Determine if sample contains only headers
@@ -1692,6 +3810,178 @@ func (p *Parser) parseFeedbackReport(feedbackReport, sample string, arrivalDate
 	return report, nil
 }
 
+// authFailureVocabulary holds the auth-failure values of RFC 6591 section 3.2.2
+// plus "dkim" and "dmarc" as emitted by RFC 7489 reporters
+var authFailureVocabulary = map[string]bool{
+	"adsp":      true,
+	"bodyhash":  true,
+	"revoked":   true,
+	"signature": true,
+	"spf":       true,
+	"dkim":      true,
+	"dmarc":     true,
+}
+
+// identityAlignmentVocabulary holds the identity-alignment mechanisms of
+// RFC 7489 section 7.3 ("none" means no mechanism aligned)
+var identityAlignmentVocabulary = map[string]bool{
+	"dkim": true,
+	"spf":  true,
+}
+
+// normalizeTokens splits a comma-separated feedback report field and returns
+// the lowercased tokens found in vocabulary along with the raw tokens.
+// Unknown tokens are only kept in the raw values and logged.
+func (p *Parser) normalizeTokens(field, value string, vocabulary map[string]bool) (normalized, raw []string) {
+	for _, token := range strings.Split(value, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		raw = append(raw, token)
+
+		lower := strings.ToLower(token)
+		if lower == "none" {
+			continue
+		}
+		if !vocabulary[lower] {
+			p.logger.Warn("Unknown token in forensic report",
+				zap.String("field", field),
+				zap.String("token", token),
+			)
+			continue
+		}
+		normalized = append(normalized, lower)
+	}
+
+	return normalized, raw
+}
+
+// addReportedDomains records the domains of a Reported-Domain field. The
+// field may appear more than once (RFC 6591 section 3.2.1) and some reporters
+// list several domains in one field: the first domain becomes ReportedDomain
+// and the others are kept in AdditionalReportedDomains.
+func (p *Parser) addReportedDomains(report *ForensicReport, value string) {
+	domains := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	for _, domain := range domains {
+		domain = utils.NormalizeDomain(domain)
+		if domain == "" || domain == report.ReportedDomain || slices.Contains(report.AdditionalReportedDomains, domain) {
+			continue
+		}
+
+		if report.ReportedDomain == "" {
+			report.ReportedDomain = domain
+		} else {
+			report.AdditionalReportedDomains = append(report.AdditionalReportedDomains, domain)
+		}
+	}
+}
+
+// parseAuthenticationResults parses the method results of an
+// Authentication-Results header value (RFC 8601). The leading authserv-id is
+// optional, as reporters often leave it out of feedback reports. Statements
+// without a method result, such as "none", are skipped, as are comments.
+func parseAuthenticationResults(value string) []AuthenticationResult {
+	var results []AuthenticationResult
+	for _, statement := range tokenizeAuthenticationResults(value) {
+		var result *AuthenticationResult
+		for i := 0; i+2 < len(statement); i++ {
+			key, sep, val := statement[i], statement[i+1], statement[i+2]
+			if key.equals || !sep.equals || val.equals {
+				continue
+			}
+			i += 2
+
+			name := strings.ToLower(key.text)
+			switch {
+			case result == nil:
+				// The method may carry a version, e.g. "dkim/1"
+				method, _, _ := strings.Cut(name, "/")
+				result = &AuthenticationResult{Method: method, Result: strings.ToLower(val.text)}
+			case name == "reason":
+				result.Reason = val.text
+			case strings.Contains(name, "."):
+				if result.Properties == nil {
+					result.Properties = make(map[string]string)
+				}
+				result.Properties[name] = val.text
+			}
+		}
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	return results
+}
+
+// authResultsToken is a word or quoted string of an Authentication-Results
+// header, or the "=" separator
+type authResultsToken struct {
+	text   string
+	equals bool
+}
+
+// tokenizeAuthenticationResults splits an Authentication-Results header value
+// into its ";"-separated statements. Comments are dropped, including any ";"
+// they contain, and quoted strings are unquoted.
+func tokenizeAuthenticationResults(value string) [][]authResultsToken {
+	var statements [][]authResultsToken
+	var statement []authResultsToken
+	var word strings.Builder
+	endWord := func() {
+		if word.Len() > 0 {
+			statement = append(statement, authResultsToken{text: word.String()})
+			word.Reset()
+		}
+	}
+
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '(':
+			endWord()
+			for depth := 0; i < len(value); i++ {
+				if value[i] == '\\' {
+					i++
+				} else if value[i] == '(' {
+					depth++
+				} else if value[i] == ')' {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+		case '"':
+			endWord()
+			var quoted strings.Builder
+			for i++; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				quoted.WriteByte(value[i])
+			}
+			statement = append(statement, authResultsToken{text: quoted.String()})
+		case '=':
+			endWord()
+			statement = append(statement, authResultsToken{equals: true})
+		case ';':
+			endWord()
+			statements = append(statements, statement)
+			statement = nil
+		case ' ', '\t', '\r', '\n':
+			endWord()
+		default:
+			word.WriteByte(c)
+		}
+	}
+	endWord()
+
+	return append(statements, statement)
+}
+
 // extractDomainFromSample tries to extract domain from email sample
 func (p *Parser) extractDomainFromSample(sample string) string {
 	lines := strings.Split(sample, "\n")
@@ -1736,6 +4026,42 @@ func (p *Parser) ParseAggregateFromBytes(data []byte) (*AggregateReport, error)
 	return p.parseAggregateXML(extractedData)
 }
 
+// ParseEmail extracts every aggregate, forensic and SMTP TLS report found in
+// an email. Unlike ParseData it does not stop at the first report type that
+// parses, and it does not store the reports. An error is returned only when
+// the email contains no report at all.
+func (p *Parser) ParseEmail(data []byte) (*EmailReports, error) {
+	reports := &EmailReports{}
+
+	aggregateReports, aggregateErr := p.parseAggregatesFromEmail(data)
+	if aggregateErr == nil {
+		reports.AggregateReports = append(reports.AggregateReports, aggregateReports...)
+	}
+
+	forensicReport, forensicErr := p.parseForensicEmail(data)
+	if forensicErr == nil {
+		reports.ForensicReports = append(reports.ForensicReports, forensicReport)
+	}
+
+	smtpTLSReport, smtpTLSErr := p.parseSMTPTLSEmail(data)
+	if smtpTLSErr == nil {
+		reports.SMTPTLSReports = append(reports.SMTPTLSReports, smtpTLSReport)
+	}
+
+	if aggregateErr != nil && forensicErr != nil && smtpTLSErr != nil {
+		return nil, fmt.Errorf("no report found in email. Details: aggregate: %v; forensic: %v; smtp_tls: %v",
+			aggregateErr, forensicErr, smtpTLSErr)
+	}
+
+	p.logger.Debug("Extracted reports from email",
+		zap.Int("aggregate", len(reports.AggregateReports)),
+		zap.Int("forensic", len(reports.ForensicReports)),
+		zap.Int("smtp_tls", len(reports.SMTPTLSReports)),
+	)
+
+	return reports, nil
+}
+
 // ParseForensicFromBytes parses forensic report from byte data
 func (p *Parser) ParseForensicFromBytes(data []byte) (*ForensicReport, error) {
 	// Extract content if compressed
@@ -1750,18 +4076,10 @@ func (p *Parser) ParseForensicFromBytes(data []byte) (*ForensicReport, error) {
 
 // ParseSMTPTLSFromBytes parses SMTP TLS report from byte data
 func (p *Parser) ParseSMTPTLSFromBytes(data []byte) (*SMTPTLSReport, error) {
-	// Extract content if compressed
-	extractedData, err := p.extractReportData(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract report data: %w", err)
-	}
-
-	// Parse as SMTP TLS report (JSON)
-	var report SMTPTLSReport
-	err = p.parseJSONWithLineInfo(extractedData, &report)
+	report, err := p.parseSMTPTLSData(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse SMTP TLS report: %w", err)
 	}
 
-	return &report, nil
+	return report, nil
 }
@@ -1,13 +1,32 @@
 package parser
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
 	"parsedmarc-go/internal/config"
 	"parsedmarc-go/internal/metrics"
+	"parsedmarc-go/internal/utils"
 )
 
 // createTestParser creates a parser for testing without reinitializing metrics
@@ -61,6 +80,26 @@ func TestParser_ParseAggregateReports(t *testing.T) {
 			filename: "empty_reason.xml",
 			wantErr:  false,
 		},
+		{
+			name:     "Namespaced report",
+			filename: "namespaced.example!example.com!1700000000!1700086399.xml",
+			wantErr:  false,
+		},
+		{
+			name:     "ZIP with several reports",
+			filename: "receiver.example!example.com!multi-report.zip",
+			wantErr:  false,
+		},
+		{
+			name:     "Inline XML email body",
+			filename: "inline-xml.eml",
+			wantErr:  false,
+		},
+		{
+			name:     "IPv6 source addresses",
+			filename: "ipv6.example!example.com!1712016000!1712102399.xml",
+			wantErr:  false,
+		},
 		{
 			name:     "Invalid XML",
 			filename: "invalid_xml.xml",
@@ -113,6 +152,11 @@ func TestParser_ParseForensicReports(t *testing.T) {
 			filename: "dmarc_ruf_report_linkedin.crlf.eml",
 			wantErr:  false,
 		},
+		{
+			name:     "LinkedIn mixed line endings forensic report",
+			filename: "dmarc_ruf_report_linkedin.mixed.eml",
+			wantErr:  false,
+		},
 		{
 			name:     "Domain.de forensic report",
 			filename: "DMARC Failure Report for domain.de (mail-from=sharepoint@domain.de, ip=10.10.10.10).eml",
@@ -142,6 +186,176 @@ func TestParser_ParseForensicReports(t *testing.T) {
 	}
 }
 
+func TestParser_ParseForensicLineEndings(t *testing.T) {
+	parser := createTestParser(t)
+
+	lf, err := os.ReadFile("../../samples/forensic/dmarc_ruf_report_linkedin.eml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	mixed, err := os.ReadFile("../../samples/forensic/dmarc_ruf_report_linkedin.mixed.eml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"LF", lf},
+		{"CRLF", bytes.ReplaceAll(lf, []byte("\n"), []byte("\r\n"))},
+		{"CR", bytes.ReplaceAll(lf, []byte("\n"), []byte("\r"))},
+		{"Mixed", mixed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := parser.parseForensicEmail(tt.data)
+			if err != nil {
+				t.Fatalf("parseForensicEmail() error = %v", err)
+			}
+
+			if report.Subject != "DMARC Failure report for example.com Mail-From: IP:10.10.10.10" {
+				t.Errorf("Subject = %q", report.Subject)
+			}
+			if report.MessageID != "<BB.91.26019.C3EA7CC5@mail516.prod.linkedin.com>" {
+				t.Errorf("MessageID = %q", report.MessageID)
+			}
+			if report.FeedbackType != "auth-failure" {
+				t.Errorf("FeedbackType = %q", report.FeedbackType)
+			}
+			if report.Source.IPAddress != "10.10.10.10" {
+				t.Errorf("Source IP = %q", report.Source.IPAddress)
+			}
+			if report.ReportedDomain != "example.com" {
+				t.Errorf("ReportedDomain = %q", report.ReportedDomain)
+			}
+			if !reflect.DeepEqual(report.AuthFailure, []string{"dmarc"}) {
+				t.Errorf("AuthFailure = %v", report.AuthFailure)
+			}
+			if report.DeliveryResult != "delivered" {
+				t.Errorf("DeliveryResult = %q", report.DeliveryResult)
+			}
+			if !strings.Contains(report.Sample, "Subject: Subject line, could be UTF8 encoded\n") || strings.Contains(report.Sample, "\r") {
+				t.Errorf("Expected the sample with LF line endings, got %q", report.Sample)
+			}
+		})
+	}
+}
+
+func TestParser_ParseEmailHeadersDate(t *testing.T) {
+	parser := createTestParser(t)
+
+	tests := []struct {
+		name string
+		date string
+		want time.Time
+	}{
+		{"RFC 1123 with numeric zone", "Date: Tue, 30 Apr 2019 02:09:00 +0000", time.Date(2019, 4, 30, 2, 9, 0, 0, time.UTC)},
+		{"Single-digit day", "Date: Mon, 1 Oct 2018 11:20:27 +0200", time.Date(2018, 10, 1, 9, 20, 27, 0, time.UTC)},
+		{"Zone comment", "Date: Tue, 9 Apr 2024 10:11:12 -0400 (EDT)", time.Date(2024, 4, 9, 14, 11, 12, 0, time.UTC)},
+		{"No day of week", "Date: 28 Sep 2018 16:48:43 +0800", time.Date(2018, 9, 28, 8, 48, 43, 0, time.UTC)},
+		{"No seconds", "Date: Fri, 28 Sep 2018 16:48 +0800", time.Date(2018, 9, 28, 8, 48, 0, 0, time.UTC)},
+		{"Obsolete GMT zone", "Date: Thu, 4 Jan 2024 08:00:00 GMT", time.Date(2024, 1, 4, 8, 0, 0, 0, time.UTC)},
+		{"Obsolete UT zone", "Date: Thu, 4 Jan 2024 08:00:00 UT", time.Date(2024, 1, 4, 8, 0, 0, 0, time.UTC)},
+		{"Two-digit year", "Date: Thu, 4 Jan 24 08:00:00 +0100", time.Date(2024, 1, 4, 7, 0, 0, 0, time.UTC)},
+		{"Extra whitespace", "date:   Thu,  4 Jan 2024   08:00:00   -0000", time.Date(2024, 1, 4, 8, 0, 0, 0, time.UTC)},
+		{"Folded header", "Date: Thu, 4 Jan 2024\n 08:00:00 +0000", time.Date(2024, 1, 4, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := "From dmarc-noreply@example.net Thu Jan  4 08:00:01 2024\n" +
+				"From: dmarc-noreply@example.net\n" +
+				tt.date + "\n" +
+				"Subject: DMARC Failure report\n" +
+				"Message-ID: <report-1@example.net>"
+
+			subject, messageID, arrivalDate := parser.parseEmailHeaders(headers)
+			if !arrivalDate.Equal(tt.want) || arrivalDate.Location() != time.UTC {
+				t.Errorf("arrivalDate = %v, want %v", arrivalDate, tt.want)
+			}
+			if subject != "DMARC Failure report" || messageID != "<report-1@example.net>" {
+				t.Errorf("subject = %q, messageID = %q", subject, messageID)
+			}
+		})
+	}
+}
+
+func TestParser_ParseEmailHeadersInvalidDate(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	parser := createTestParser(t)
+	parser.logger = zap.New(core)
+	parser.metrics = newTestParserMetrics()
+
+	before := time.Now().UTC()
+	_, _, arrivalDate := parser.parseEmailHeaders("Subject: test\nDate: sometime yesterday")
+	if arrivalDate.Before(before) || arrivalDate.After(time.Now().UTC()) {
+		t.Errorf("Expected the current time for an invalid date, got %v", arrivalDate)
+	}
+	if logs.FilterMessage("Invalid Date header in forensic report, using current time").Len() != 1 {
+		t.Errorf("Expected a warning for the invalid date, got %v", logs.All())
+	}
+	if got := testutil.ToFloat64(parser.metrics.ParseWarningsTotal.WithLabelValues("forensic", "invalid_date")); got != 1 {
+		t.Errorf("Expected one invalid_date warning, got %v", got)
+	}
+
+	// A missing Date header is not worth a warning
+	parser.parseEmailHeaders("Subject: test")
+	if logs.Len() != 1 {
+		t.Errorf("Expected no warning for a missing date, got %v", logs.All())
+	}
+}
+
+func TestParser_ParseEmailHeadersEncodedSubject(t *testing.T) {
+	parser := createTestParser(t)
+
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+	}{
+		{"Plain", "[Netease DMARC Failure Report] Rent Reminder", "[Netease DMARC Failure Report] Rent Reminder"},
+		{"UTF-8 base64", "=?UTF-8?B?UmFwcG9ydCBkJ8OpY2hlYyBETUFSQw==?=", "Rapport d'échec DMARC"},
+		{"UTF-8 quoted-printable", "=?utf-8?Q?Rapport_d'=C3=A9chec?= DMARC", "Rapport d'échec DMARC"},
+		{"ISO-8859-1", "=?ISO-8859-1?Q?Rapport_d'=E9chec?= DMARC", "Rapport d'échec DMARC"},
+		{"GB2312", "[Netease DMARC Failure Report] =?GB2312?B?1+K98Mzh0NE=?=", "[Netease DMARC Failure Report] 租金提醒"},
+		{"Adjacent words", "=?UTF-8?B?56ef6YeR?=\n =?UTF-8?B?5o+Q6YaS?=", "租金提醒"},
+		{"Unknown charset", "=?x-unknown?Q?abc?=", "=?x-unknown?Q?abc?="},
+		{"Invalid UTF-8", "Rent \xffReminder", "Rent \uFFFDReminder"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, _, _ := parser.parseEmailHeaders("Subject: " + tt.subject)
+			if subject != tt.want {
+				t.Errorf("subject = %q, want %q", subject, tt.want)
+			}
+		})
+	}
+}
+
+func TestParser_ExtractFromMIMEMalformed(t *testing.T) {
+	parser := createTestParser(t)
+
+	// Part headers without LF line endings cannot be read; extraction must
+	// stop rather than retry the failing part forever
+	body := "Content-Type: multipart/report; boundary=\"b\"\n\n" +
+		"--b\rContent-Type: message/feedback-report\r\rFeedback-Type: auth-failure\r--b--\r"
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		parser.extractFromMIME(body)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("extractFromMIME() did not return on a malformed body")
+	}
+}
+
 func TestParser_ParseSMTPTLSReports(t *testing.T) {
 	parser := createTestParser(t)
 
@@ -170,6 +384,11 @@ func TestParser_ParseSMTPTLSReports(t *testing.T) {
 			filename: "google.com_smtp_tls_report.eml",
 			wantErr:  false,
 		},
+		{
+			name:     "Email report with quoted boundary",
+			filename: "quoted-boundary.eml",
+			wantErr:  false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -189,6 +408,59 @@ func TestParser_ParseSMTPTLSReports(t *testing.T) {
 	}
 }
 
+func TestParser_ParseSMTPTLSEmailMIME(t *testing.T) {
+	data, err := os.ReadFile("../../samples/smtp_tls/quoted-boundary.eml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	gzipped, err := os.ReadFile("../../samples/smtp_tls/rfc8460.json.gz")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(gzipped)
+
+	tests := []struct {
+		name  string
+		email string
+	}{
+		{
+			// Folded Content-Type, a boundary containing "=", the report
+			// behind a nested multipart/alternative and gzipped in base64
+			name:  "quoted boundary sample",
+			email: string(data),
+		},
+		{
+			name: "nested multipart/mixed",
+			email: "From: tlsrpt@example.net\nSubject: TLS report\nMIME-Version: 1.0\n" +
+				"Content-Type: multipart/mixed; boundary=\"outer\"\n\n" +
+				"--outer\nContent-Type: text/plain\n\nSee attachment.\n" +
+				"--outer\nContent-Type: multipart/mixed; boundary=\"inner=;\"\n\n" +
+				"--inner=;\nContent-Type: application/octet-stream; name=\"report.json.gz\"\n" +
+				"Content-Transfer-Encoding: base64\n\n" + encoded + "\n" +
+				"--inner=;--\n--outer--\n",
+		},
+		{
+			name: "single part email",
+			email: "From: tlsrpt@example.net\r\nSubject: TLS report\r\nMIME-Version: 1.0\r\n" +
+				"Content-Type: application/tlsrpt+gzip\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+				encoded + "\r\n",
+		},
+	}
+
+	parser := createTestParser(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := parser.ParseSMTPTLSFromBytes([]byte(tt.email))
+			if err != nil {
+				t.Fatalf("ParseSMTPTLSFromBytes() error = %v", err)
+			}
+			if report.OrganizationName != "Company-X" || report.ReportID != "5065427c-23d3-47ca-b6e0-946ea0e8c4be" {
+				t.Errorf("Unexpected report %s from %s", report.ReportID, report.OrganizationName)
+			}
+		})
+	}
+}
+
 func TestParser_ParseInvalidReports(t *testing.T) {
 	parser := createTestParser(t)
 
@@ -354,62 +626,3406 @@ func TestParser_ParseAggregateFromBytes(t *testing.T) {
 	}
 }
 
-// Benchmark tests
-func BenchmarkParser_ParseAggregateReport(b *testing.B) {
-	logger := zaptest.NewLogger(b)
-	cfg := config.ParserConfig{
-		Offline: true,
-	}
+func TestParser_ParseNamespacedAggregateReport(t *testing.T) {
+	parser := createTestParser(t)
 
-	// Create parser with empty metrics to avoid registration conflicts
-	parser := &Parser{
-		config:  cfg,
-		storage: nil,
-		logger:  logger,
-		metrics: &metrics.ParserMetrics{},
+	samplePath := filepath.Join("../../samples/aggregate", "namespaced.example!example.com!1700000000!1700086399.xml")
+	data, err := os.ReadFile(samplePath)
+	if err != nil {
+		t.Fatalf("Failed to read sample file %s: %v", samplePath, err)
 	}
 
-	samplePath := filepath.Join("../../samples/aggregate", "!example.com!1538204542!1538463818.xml")
-	data, err := os.ReadFile(samplePath)
+	report, err := parser.ParseAggregateFromBytes(data)
 	if err != nil {
-		b.Fatalf("Failed to read sample file: %v", err)
+		t.Fatalf("ParseAggregateFromBytes() error = %v", err)
 	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		err := parser.ParseData(data)
-		if err != nil {
-			b.Fatalf("Parse error: %v", err)
+	metadata := report.ReportMetadata
+	if metadata.OrgName != "Namespaced Example" {
+		t.Errorf("Expected org_name 'Namespaced Example', got '%s'", metadata.OrgName)
+	}
+	if metadata.OrgEmail != "dmarc-reports@namespaced.example" {
+		t.Errorf("Expected email 'dmarc-reports@namespaced.example', got '%s'", metadata.OrgEmail)
+	}
+	if metadata.OrgExtraContactInfo == nil || *metadata.OrgExtraContactInfo != "https://namespaced.example/dmarc" {
+		t.Errorf("Expected extra_contact_info to be populated, got %v", metadata.OrgExtraContactInfo)
+	}
+	if metadata.ReportID != "ns-20231114-0001" {
+		t.Errorf("Expected report_id 'ns-20231114-0001', got '%s'", metadata.ReportID)
+	}
+	if metadata.BeginDate.Unix() != 1700000000 || metadata.EndDate.Unix() != 1700086399 {
+		t.Errorf("Unexpected date range %v - %v", metadata.BeginDate, metadata.EndDate)
+	}
+
+	policy := report.PolicyPublished
+	if policy.Domain != "example.com" || policy.ADKIM != "s" || policy.ASPF != "r" ||
+		policy.P != "quarantine" || policy.SP != "reject" || policy.PCT != "50" || policy.FO != "1" {
+		t.Errorf("Unexpected policy_published %+v", policy)
+	}
+
+	if len(report.Records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(report.Records))
+	}
+
+	record := report.Records[0]
+	if record.Source.IPAddress != "192.0.2.10" {
+		t.Errorf("Expected source IP '192.0.2.10', got '%s'", record.Source.IPAddress)
+	}
+	if record.Count != 3 {
+		t.Errorf("Expected count 3, got %d", record.Count)
+	}
+	if record.PolicyEvaluated.Disposition != "quarantine" ||
+		record.PolicyEvaluated.DKIM != "fail" || record.PolicyEvaluated.SPF != "pass" {
+		t.Errorf("Unexpected policy_evaluated %+v", record.PolicyEvaluated)
+	}
+	if len(record.PolicyEvaluated.PolicyOverrideReasons) != 1 ||
+		*record.PolicyEvaluated.PolicyOverrideReasons[0].Type != "forwarded" ||
+		*record.PolicyEvaluated.PolicyOverrideReasons[0].Comment != "known forwarder" {
+		t.Errorf("Unexpected policy override reasons %+v", record.PolicyEvaluated.PolicyOverrideReasons)
+	}
+	if record.Identifiers.HeaderFrom != "example.com" {
+		t.Errorf("Expected header_from 'example.com', got '%s'", record.Identifiers.HeaderFrom)
+	}
+	if record.Identifiers.EnvelopeFrom == nil || *record.Identifiers.EnvelopeFrom != "bounce.example.com" {
+		t.Errorf("Expected envelope_from 'bounce.example.com', got %v", record.Identifiers.EnvelopeFrom)
+	}
+	if record.Identifiers.EnvelopeTo == nil || *record.Identifiers.EnvelopeTo != "example.net" {
+		t.Errorf("Expected envelope_to 'example.net', got %v", record.Identifiers.EnvelopeTo)
+	}
+	if len(record.AuthResults.DKIM) != 1 || record.AuthResults.DKIM[0].Selector != "selector1" {
+		t.Errorf("Unexpected DKIM results %+v", record.AuthResults.DKIM)
+	}
+	if len(record.AuthResults.SPF) != 1 || record.AuthResults.SPF[0].Domain != "bounce.example.com" {
+		t.Errorf("Unexpected SPF results %+v", record.AuthResults.SPF)
+	}
+}
+
+func TestExtractFeedbackElement(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Plain root",
+			input:    `<?xml version="1.0"?><feedback><version>1.0</version></feedback>`,
+			expected: `<feedback><version>1.0</version></feedback>`,
+		},
+		{
+			name:     "Default namespace",
+			input:    `<?xml version="1.0"?><feedback xmlns="urn:ietf:params:xml:ns:dmarc-2.0"></feedback>`,
+			expected: `<feedback xmlns="urn:ietf:params:xml:ns:dmarc-2.0"></feedback>`,
+		},
+		{
+			name:     "Prefixed root inside wrapper",
+			input:    `<wrapper><dmarc:feedback xmlns:dmarc="urn:x"><dmarc:version>1.0</dmarc:version></dmarc:feedback></wrapper>`,
+			expected: `<dmarc:feedback xmlns:dmarc="urn:x"><dmarc:version>1.0</dmarc:version></dmarc:feedback>`,
+		},
+		{
+			name:     "Similar element name",
+			input:    `<feedbacks></feedbacks>`,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractFeedbackElement(tt.input); got != tt.expected {
+				t.Errorf("extractFeedbackElement() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParser_EnrichSourcesPreservesOrder(t *testing.T) {
+	parser := createTestParser(t)
+	parser.config.EnrichmentConcurrency = 4
+
+	ipAddresses := make([]string, 50)
+	for i := range ipAddresses {
+		ipAddresses[i] = fmt.Sprintf("192.0.2.%d", i+1)
+	}
+
+	sources := parser.enrichSources(ipAddresses)
+	if len(sources) != len(ipAddresses) {
+		t.Fatalf("Expected %d sources, got %d", len(ipAddresses), len(sources))
+	}
+	for i, source := range sources {
+		if source.IPAddress != ipAddresses[i] {
+			t.Errorf("sources[%d].IPAddress = %s, want %s", i, source.IPAddress, ipAddresses[i])
 		}
 	}
 }
 
-func BenchmarkParser_ParseLargeAggregateReport(b *testing.B) {
-	logger := zaptest.NewLogger(b)
-	cfg := config.ParserConfig{
-		Offline: true,
+func TestParser_ParseSourceIPSkipsPrivate(t *testing.T) {
+	var lookups []string
+	origGeo, origDNS := getGeoLocation, getReverseDNS
+	defer func() { getGeoLocation, getReverseDNS = origGeo, origDNS }()
+
+	getGeoLocation = func(ipAddress, dbPath string) (*utils.GeoLocation, error) {
+		lookups = append(lookups, "geo:"+ipAddress)
+		return &utils.GeoLocation{Country: "US", City: "Mountain View", Latitude: 37.386, Longitude: -122.0838}, nil
+	}
+	getReverseDNS = func(ipAddress string, nameservers []string, timeoutSec int) (string, error) {
+		lookups = append(lookups, "dns:"+ipAddress)
+		return "dns.google", nil
 	}
 
-	// Create parser with empty metrics to avoid registration conflicts
-	parser := &Parser{
-		config:  cfg,
-		storage: nil,
-		logger:  logger,
-		metrics: &metrics.ParserMetrics{},
+	parser := createTestParser(t)
+	parser.config = config.ParserConfig{
+		IPDBPath:       "GeoLite2-City.mmdb",
+		Nameservers:    []string{"1.1.1.1"},
+		SkipPrivateIPs: true,
 	}
 
-	samplePath := filepath.Join("../../samples/aggregate", "!large-example.com!1711897200!1711983600.xml")
-	data, err := os.ReadFile(samplePath)
-	if err != nil {
-		b.Skipf("Large sample file not found: %v", err)
-		return
+	tests := []struct {
+		name       string
+		ip         string
+		wantType   string
+		wantLookup bool
+	}{
+		{
+			name:       "Private",
+			ip:         "10.1.2.3",
+			wantType:   "private",
+			wantLookup: false,
+		},
+		{
+			name:       "Loopback",
+			ip:         "127.0.0.1",
+			wantType:   "reserved",
+			wantLookup: false,
+		},
+		{
+			name:       "Public",
+			ip:         "8.8.8.8",
+			wantType:   "Unknown",
+			wantLookup: true,
+		},
 	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		err := parser.ParseData(data)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups = nil
+
+			source, err := parser.parseSourceIP(tt.ip)
+			if err != nil {
+				t.Fatalf("parseSourceIP(%s) error = %v", tt.ip, err)
+			}
+
+			if source.Type != tt.wantType {
+				t.Errorf("Type = %q, want %q", source.Type, tt.wantType)
+			}
+			if (len(lookups) > 0) != tt.wantLookup {
+				t.Errorf("lookups = %v, wantLookup %v", lookups, tt.wantLookup)
+			}
+			if tt.wantLookup && (source.Country != "US" || source.City != "Mountain View" ||
+				source.Latitude != 37.386 || source.Longitude != -122.0838 || source.ReverseDNS != "dns.google") {
+				t.Errorf("Expected enriched source, got %+v", source)
+			}
+		})
+	}
+}
+
+func TestParser_ParseSourceIPASN(t *testing.T) {
+	origASN := getASN
+	defer func() { getASN = origASN }()
+
+	var lookups []string
+	getASN = func(ipAddress, dbPath string) (*utils.ASNInfo, error) {
+		lookups = append(lookups, ipAddress)
+		if dbPath != "GeoLite2-ASN.mmdb" {
+			return nil, errors.New("failed to open ASN database")
+		}
+		return &utils.ASNInfo{ASN: 15169, Org: "GOOGLE"}, nil
+	}
+
+	parser := createTestParser(t)
+	parser.config = config.ParserConfig{
+		ASNDBPath: "GeoLite2-ASN.mmdb",
+	}
+
+	for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+		source, err := parser.parseSourceIP(ip)
 		if err != nil {
-			b.Fatalf("Parse error: %v", err)
+			t.Fatalf("parseSourceIP(%s) error = %v", ip, err)
 		}
+		if source.ASN != 15169 || source.ASNOrg != "GOOGLE" {
+			t.Errorf("parseSourceIP(%s) ASN = %d %q, want 15169 \"GOOGLE\"", ip, source.ASN, source.ASNOrg)
+		}
+	}
+
+	// A database that fails to open leaves the fields empty
+	parser.config.ASNDBPath = "missing.mmdb"
+	source, err := parser.parseSourceIP("8.8.8.8")
+	if err != nil {
+		t.Fatalf("parseSourceIP() error = %v", err)
+	}
+	if source.ASN != 0 || source.ASNOrg != "" {
+		t.Errorf("Expected no ASN without a database, got %d %q", source.ASN, source.ASNOrg)
+	}
+
+	// Without a database nothing is looked up
+	lookups = nil
+	parser.config.ASNDBPath = ""
+	if _, err := parser.parseSourceIP("8.8.8.8"); err != nil {
+		t.Fatalf("parseSourceIP() error = %v", err)
+	}
+	if len(lookups) != 0 {
+		t.Errorf("Expected no ASN lookup, got %v", lookups)
+	}
+}
+
+func TestParser_EnrichesIPv6Sources(t *testing.T) {
+	var lookups []string
+	origGeo, origDNS := getGeoLocation, getReverseDNS
+	defer func() { getGeoLocation, getReverseDNS = origGeo, origDNS }()
+
+	getGeoLocation = func(ipAddress, dbPath string) (*utils.GeoLocation, error) {
+		lookups = append(lookups, "geo:"+ipAddress)
+		return &utils.GeoLocation{Country: "DE"}, nil
+	}
+	getReverseDNS = func(ipAddress string, nameservers []string, timeoutSec int) (string, error) {
+		lookups = append(lookups, "dns:"+ipAddress)
+		return "fra16s56-in-x0e.1e100.net", nil
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+	parser.config = config.ParserConfig{
+		IPDBPath:       "GeoLite2-City.mmdb",
+		Nameservers:    []string{"2606:4700:4700::1111"},
+		SkipPrivateIPs: true,
+	}
+
+	data, err := os.ReadFile("../../samples/aggregate/ipv6.example!example.com!1712016000!1712102399.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	if err := parser.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+
+	wantLookups := []string{"geo:2a00:1450:4001:80b::200e", "dns:2a00:1450:4001:80b::200e"}
+	if !reflect.DeepEqual(lookups, wantLookups) {
+		t.Errorf("lookups = %v, want %v", lookups, wantLookups)
+	}
+
+	if len(storage.aggregateReports) != 1 {
+		t.Fatalf("Expected 1 stored aggregate report, got %d", len(storage.aggregateReports))
+	}
+	records := storage.aggregateReports[0].Records
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	public := records[0].Source
+	if public.IPAddress != "2a00:1450:4001:80b::200e" || public.Country != "DE" ||
+		public.ReverseDNS != "fra16s56-in-x0e.1e100.net" || public.BaseDomain != "1e100.net" {
+		t.Errorf("Expected enriched IPv6 source, got %+v", public)
+	}
+
+	private := records[1].Source
+	if private.IPAddress != "fd12:3456:789a::25" || private.Type != "private" || private.ReverseDNS != "" {
+		t.Errorf("Expected unenriched private IPv6 source, got %+v", private)
+	}
+}
+
+func TestParser_NormalizesIPv6Sources(t *testing.T) {
+	// Lookups only succeed for canonical addresses
+	countries := map[string]string{
+		"2a00:1450:4001:80b::200e": "DE",
+		"2001:4860:4860::8888":     "US",
+		"8.8.4.4":                  "US",
+	}
+	hostnames := map[string]string{
+		"2a00:1450:4001:80b::200e": "fra16s56-in-x0e.1e100.net",
+		"2001:4860:4860::8888":     "dns.google",
+		"8.8.4.4":                  "dns.google",
+	}
+
+	origGeo, origDNS := getGeoLocation, getReverseDNS
+	defer func() { getGeoLocation, getReverseDNS = origGeo, origDNS }()
+
+	getGeoLocation = func(ipAddress, dbPath string) (*utils.GeoLocation, error) {
+		country, ok := countries[ipAddress]
+		if !ok {
+			return nil, fmt.Errorf("no record for %q", ipAddress)
+		}
+		return &utils.GeoLocation{Country: country}, nil
+	}
+	getReverseDNS = func(ipAddress string, nameservers []string, timeoutSec int) (string, error) {
+		hostname, ok := hostnames[ipAddress]
+		if !ok {
+			return "", fmt.Errorf("no PTR record for %q", ipAddress)
+		}
+		return hostname, nil
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+	parser.config = config.ParserConfig{
+		IPDBPath:       "GeoLite2-City.mmdb",
+		Nameservers:    []string{"2606:4700:4700::1111"},
+		SkipPrivateIPs: true,
+	}
+
+	metadata := ReportMetadata{
+		OrgName:   "google.com",
+		OrgEmail:  "noreply-dmarc-support@google.com",
+		ReportID:  "ipv6-forms",
+		BeginDate: time.Unix(1712016000, 0),
+		EndDate:   time.Unix(1712102399, 0),
+	}
+	var rows []Record
+	for _, ip := range []string{
+		"\n        2A00:1450:4001:080B:0000:0000:0000:200E\n      ",
+		"[2001:4860:4860:0:0:0:0:8888]",
+		"::ffff:8.8.4.4",
+		"FD12:3456:789A:0::25",
+	} {
+		rows = append(rows, testAggregateRecord(ip, 1, "example.com"))
+	}
+	data := aggregateReportXML(metadata, PolicyPublished{Domain: "example.com", P: "none"}, rows...)
+
+	if err := parser.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+	if len(storage.aggregateReports) != 1 {
+		t.Fatalf("Expected 1 stored aggregate report, got %d", len(storage.aggregateReports))
+	}
+
+	records := storage.aggregateReports[0].Records
+	want := []Source{
+		{IPAddress: "2a00:1450:4001:80b::200e", Country: "DE", ReverseDNS: "fra16s56-in-x0e.1e100.net"},
+		{IPAddress: "2001:4860:4860::8888", Country: "US", ReverseDNS: "dns.google"},
+		{IPAddress: "8.8.4.4", Country: "US", ReverseDNS: "dns.google"},
+		{IPAddress: "fd12:3456:789a::25", Country: "Unknown", Type: "private"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Expected %d records, got %d", len(want), len(records))
+	}
+	for i, record := range records {
+		got := record.Source
+		if got.IPAddress != want[i].IPAddress || got.Country != want[i].Country || got.ReverseDNS != want[i].ReverseDNS {
+			t.Errorf("Record %d source = %+v, want %+v", i, got, want[i])
+		}
+		if want[i].Type != "" && got.Type != want[i].Type {
+			t.Errorf("Record %d type = %q, want %q", i, got.Type, want[i].Type)
+		}
+	}
+}
+
+func TestParser_CachesReverseDNS(t *testing.T) {
+	var lookups []string
+	origGeo, origDNS := getGeoLocation, getReverseDNS
+	defer func() { getGeoLocation, getReverseDNS = origGeo, origDNS }()
+
+	getGeoLocation = func(ipAddress, dbPath string) (*utils.GeoLocation, error) {
+		return &utils.GeoLocation{Country: "DE"}, nil
+	}
+	getReverseDNS = func(ipAddress string, nameservers []string, timeoutSec int) (string, error) {
+		lookups = append(lookups, ipAddress)
+		return "fra16s56-in-x0e.1e100.net", nil
+	}
+
+	cfg := config.ParserConfig{
+		Nameservers:    []string{"1.1.1.1"},
+		SkipPrivateIPs: true,
+		DNSCacheSize:   100,
+		DNSCacheTTL:    time.Hour,
+	}
+	parser := New(cfg, &mockStorage{}, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	data, err := os.ReadFile("../../samples/aggregate/ipv6.example!example.com!1712016000!1712102399.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := parser.ParseData(data); err != nil {
+			t.Fatalf("ParseData() error = %v", err)
+		}
+	}
+
+	if want := []string{"2a00:1450:4001:80b::200e"}; !reflect.DeepEqual(lookups, want) {
+		t.Errorf("lookups = %v, want %v", lookups, want)
+	}
+}
+
+func TestParser_ReverseDNSMap(t *testing.T) {
+	origDNS := getReverseDNS
+	defer func() { getReverseDNS = origDNS }()
+	getReverseDNS = func(ipAddress string, nameservers []string, timeoutSec int) (string, error) {
+		return map[string]string{
+			"8.8.8.8":     "dns.google",
+			"209.85.1.1":  "mail-ed1-f41.google.com",
+			"40.107.1.1":  "mail-db3eur04on0001.outbound.protection.outlook.com",
+			"198.51.1.10": "mta.unmapped.example",
+		}[ipAddress], nil
+	}
+
+	mapPath := filepath.Join(t.TempDir(), "base_reverse_dns_map.csv")
+	mapData := "base_reverse_dns,name,type\ngoogle.com,Google,Email Provider\n"
+	if err := os.WriteFile(mapPath, []byte(mapData), 0o644); err != nil {
+		t.Fatalf("Failed to write map: %v", err)
+	}
+
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		if r.URL.Path != "/map.csv" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("outlook.com,Microsoft,Email Provider\n"))
+	}))
+	defer server.Close()
+
+	newParser := func(url string, localOnly bool) *Parser {
+		return New(config.ParserConfig{
+			Nameservers:         []string{"1.1.1.1"},
+			ReverseDNSMapPath:   mapPath,
+			ReverseDNSMapURL:    url,
+			AlwaysUseLocalFiles: localOnly,
+		}, nil, zaptest.NewLogger(t), prometheus.NewRegistry())
+	}
+
+	tests := []struct {
+		name          string
+		url           string
+		localOnly     bool
+		wantDownloads int
+		want          map[string]Source
+	}{
+		{
+			name:          "Local file",
+			url:           server.URL + "/map.csv",
+			localOnly:     true,
+			wantDownloads: 0,
+			want: map[string]Source{
+				"209.85.1.1":  {Name: "Google", Type: "Email Provider"},
+				"40.107.1.1":  {Name: "mail-db3eur04on0001.outbound.protection.outlook.com", Type: "Unknown"},
+				"198.51.1.10": {Name: "mta.unmapped.example", Type: "Unknown"},
+			},
+		},
+		{
+			name:          "URL",
+			url:           server.URL + "/map.csv",
+			wantDownloads: 1,
+			want: map[string]Source{
+				"209.85.1.1": {Name: "mail-ed1-f41.google.com", Type: "Unknown"},
+				"40.107.1.1": {Name: "Microsoft", Type: "Email Provider"},
+			},
+		},
+		{
+			name:          "Local file after failed download",
+			url:           server.URL + "/missing.csv",
+			wantDownloads: 1,
+			want: map[string]Source{
+				"8.8.8.8":    {Name: "dns.google", Type: "Unknown"},
+				"209.85.1.1": {Name: "Google", Type: "Email Provider"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			downloads = 0
+			parser := newParser(tt.url, tt.localOnly)
+			if downloads != tt.wantDownloads {
+				t.Errorf("downloads = %d, want %d", downloads, tt.wantDownloads)
+			}
+
+			for ip, want := range tt.want {
+				source, err := parser.parseSourceIP(ip)
+				if err != nil {
+					t.Fatalf("parseSourceIP(%s) error = %v", ip, err)
+				}
+				if source.Name != want.Name || source.Type != want.Type {
+					t.Errorf("parseSourceIP(%s) name/type = %q/%q, want %q/%q", ip, source.Name, source.Type, want.Name, want.Type)
+				}
+			}
+		})
+	}
+}
+
+// forensicEmailTemplate is a minimal RFC 6591 failure report; %s is replaced
+// by extra feedback report fields
+const forensicEmailTemplate = "From: dmarc-noreply@example.net\r\n" +
+	"To: dmarc@example.com\r\n" +
+	"Subject: DMARC Failure report for example.com\r\n" +
+	"Message-ID: <forensic-test@example.net>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=feedback-report; boundary=\"BOUNDARY\"\r\n" +
+	"\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"This is an authentication failure report.\r\n" +
+	"\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: message/feedback-report\r\n" +
+	"\r\n" +
+	"Feedback-Type: auth-failure\r\n" +
+	"Version: 1\r\n" +
+	"Source-IP: 192.0.2.1\r\n" +
+	"Reported-Domain: example.com\r\n" +
+	"%s" +
+	"\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"From: sender@example.com\r\n" +
+	"Subject: hello\r\n" +
+	"\r\n" +
+	"--BOUNDARY--\r\n"
+
+func TestParser_NormalizeForensicAuthFailure(t *testing.T) {
+	parser := createTestParser(t)
+
+	email := fmt.Sprintf(forensicEmailTemplate,
+		"Auth-Failure: DMARC, Bodyhash,  frobnicated\r\n"+
+			"Identity-Alignment: DKIM,spf, bogus\r\n")
+
+	report, err := parser.ParseForensicFromBytes([]byte(email))
+	if err != nil {
+		t.Fatalf("ParseForensicFromBytes() error = %v", err)
+	}
+
+	wantAuthFailure := []string{"dmarc", "bodyhash"}
+	if strings.Join(report.AuthFailure, ",") != strings.Join(wantAuthFailure, ",") {
+		t.Errorf("AuthFailure = %v, want %v", report.AuthFailure, wantAuthFailure)
+	}
+
+	wantAuthFailureRaw := []string{"DMARC", "Bodyhash", "frobnicated"}
+	if strings.Join(report.AuthFailureRaw, ",") != strings.Join(wantAuthFailureRaw, ",") {
+		t.Errorf("AuthFailureRaw = %v, want %v", report.AuthFailureRaw, wantAuthFailureRaw)
+	}
+
+	wantMechanisms := []string{"dkim", "spf"}
+	if strings.Join(report.AuthenticationMechanisms, ",") != strings.Join(wantMechanisms, ",") {
+		t.Errorf("AuthenticationMechanisms = %v, want %v", report.AuthenticationMechanisms, wantMechanisms)
+	}
+
+	wantMechanismsRaw := []string{"DKIM", "spf", "bogus"}
+	if strings.Join(report.AuthenticationMechanismsRaw, ",") != strings.Join(wantMechanismsRaw, ",") {
+		t.Errorf("AuthenticationMechanismsRaw = %v, want %v", report.AuthenticationMechanismsRaw, wantMechanismsRaw)
+	}
+}
+
+func TestParser_NormalizeForensicReportedDomain(t *testing.T) {
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+
+	email := strings.Replace(fmt.Sprintf(forensicEmailTemplate,
+		"Reported-Domain: example.org, Example.COM EXAMPLE.net\r\n"),
+		"Reported-Domain: example.com", "Reported-Domain:  Example.COM ", 1)
+
+	if err := parser.ParseData([]byte(email)); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+
+	if len(storage.forensicReports) != 1 {
+		t.Fatalf("Expected 1 stored forensic report, got %d", len(storage.forensicReports))
+	}
+	report := storage.forensicReports[0]
+
+	if report.ReportedDomain != "example.com" {
+		t.Errorf("ReportedDomain = %q, want %q", report.ReportedDomain, "example.com")
+	}
+
+	wantAdditional := []string{"example.org", "example.net"}
+	if !reflect.DeepEqual(report.AdditionalReportedDomains, wantAdditional) {
+		t.Errorf("AdditionalReportedDomains = %v, want %v", report.AdditionalReportedDomains, wantAdditional)
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []AuthenticationResult
+	}{
+		{
+			name:  "Without authserv-id",
+			value: "dmarc=fail (p=none; dis=none) header.from=example.com",
+			want: []AuthenticationResult{
+				{Method: "dmarc", Result: "fail", Properties: map[string]string{"header.from": "example.com"}},
+			},
+		},
+		{
+			name: "Multiple methods",
+			value: `mx.example.net 1; spf=pass smtp.mailfrom=bounce@example.com;
+	dkim=FAIL reason="bad signature" header.d=example.com header.S=sel1;
+	dkim/1=pass header.i=@mailer.example; dmarc = fail ( p=reject ) header.from = example.com`,
+			want: []AuthenticationResult{
+				{Method: "spf", Result: "pass", Properties: map[string]string{"smtp.mailfrom": "bounce@example.com"}},
+				{Method: "dkim", Result: "fail", Reason: "bad signature", Properties: map[string]string{"header.d": "example.com", "header.s": "sel1"}},
+				{Method: "dkim", Result: "pass", Properties: map[string]string{"header.i": "@mailer.example"}},
+				{Method: "dmarc", Result: "fail", Properties: map[string]string{"header.from": "example.com"}},
+			},
+		},
+		{
+			name:  "Quoted values and nested comments",
+			value: `mail516.prod.linkedin.com; iprev=pass policy.iprev="10.10.10.10"; spf=neutral smtp.mailfrom="" smtp.helo="mail02.someserver.com"; dkim=none (message (really) not signed) header.d=none; tls=pass (verified) cert.client="OU=Domain Control Validated,CN=*.someserver.com"`,
+			want: []AuthenticationResult{
+				{Method: "iprev", Result: "pass", Properties: map[string]string{"policy.iprev": "10.10.10.10"}},
+				{Method: "spf", Result: "neutral", Properties: map[string]string{"smtp.mailfrom": "", "smtp.helo": "mail02.someserver.com"}},
+				{Method: "dkim", Result: "none", Properties: map[string]string{"header.d": "none"}},
+				{Method: "tls", Result: "pass", Properties: map[string]string{"cert.client": "OU=Domain Control Validated,CN=*.someserver.com"}},
+			},
+		},
+		{
+			name:  "No results",
+			value: "mx.example.net; none",
+		},
+		{
+			name: "Empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAuthenticationResults(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAuthenticationResults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParser_ForensicAuthenticationResults(t *testing.T) {
+	parser := createTestParser(t)
+
+	data, err := os.ReadFile("../../samples/forensic/dmarc_ruf_report_linkedin.eml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	report, err := parser.parseForensicEmail(data)
+	if err != nil {
+		t.Fatalf("parseForensicEmail() error = %v", err)
+	}
+
+	if report.AuthenticationResults != "dmarc=fail (p=none; dis=none) header.from=example.com" {
+		t.Errorf("Expected the raw header to be kept, got %q", report.AuthenticationResults)
+	}
+	want := []AuthenticationResult{
+		{Method: "dmarc", Result: "fail", Properties: map[string]string{"header.from": "example.com"}},
+	}
+	if !reflect.DeepEqual(report.ParsedAuthenticationResults, want) {
+		t.Errorf("ParsedAuthenticationResults = %+v, want %+v", report.ParsedAuthenticationResults, want)
+	}
+
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Failed to marshal report: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"parsed_authentication_results":[{"method":"dmarc","result":"fail","properties":{"header.from":"example.com"}}]`) {
+		t.Errorf("Expected the parsed results in JSON, got %s", encoded)
+	}
+}
+
+// mockStorage records reports passed to the Storage interface
+type mockStorage struct {
+	aggregateReports []*AggregateReport
+	forensicReports  []*ForensicReport
+	smtpTLSReports   []*SMTPTLSReport
+	unparsedReports  []*UnparsedReport
+}
+
+func (m *mockStorage) StoreUnparsedReport(report *UnparsedReport) error {
+	m.unparsedReports = append(m.unparsedReports, report)
+	return nil
+}
+
+func (m *mockStorage) StoreAggregateReport(report *AggregateReport) error {
+	m.aggregateReports = append(m.aggregateReports, report)
+	return nil
+}
+
+func (m *mockStorage) StoreForensicReport(report *ForensicReport) error {
+	m.forensicReports = append(m.forensicReports, report)
+	return nil
+}
+
+func (m *mockStorage) StoreSMTPTLSReport(report *SMTPTLSReport) error {
+	m.smtpTLSReports = append(m.smtpTLSReports, report)
+	return nil
+}
+
+func (m *mockStorage) Close() error {
+	return nil
+}
+
+// testReportMetadata returns the metadata of a test aggregate report covering
+// 2024-01-01
+func testReportMetadata(orgName, email, reportID string) ReportMetadata {
+	return ReportMetadata{
+		OrgName:   orgName,
+		OrgEmail:  email,
+		ReportID:  reportID,
+		BeginDate: time.Unix(1704067200, 0),
+		EndDate:   time.Unix(1704153599, 0),
+	}
+}
+
+// testAggregateRecord returns an aggregate record of count messages from
+// sourceIP for headerFrom, passing DKIM and SPF
+func testAggregateRecord(sourceIP string, count int, headerFrom string) Record {
+	return Record{
+		Source:          Source{IPAddress: sourceIP},
+		Count:           count,
+		PolicyEvaluated: PolicyEvaluated{Disposition: "none", DKIM: "pass", SPF: "pass"},
+		Identifiers:     Identifiers{HeaderFrom: headerFrom},
+	}
+}
+
+// aggregateReportXML builds the XML of an aggregate report. Values are
+// written as is, and empty optional elements are left out.
+func aggregateReportXML(metadata ReportMetadata, policy PolicyPublished, records ...Record) []byte {
+	var b strings.Builder
+	optional := func(indent, name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s<%s>%s</%s>\n", indent, name, value, name)
+		}
+	}
+
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<feedback>\n  <report_metadata>\n")
+	fmt.Fprintf(&b, "    <org_name>%s</org_name>\n", metadata.OrgName)
+	fmt.Fprintf(&b, "    <email>%s</email>\n", metadata.OrgEmail)
+	fmt.Fprintf(&b, "    <report_id>%s</report_id>\n", metadata.ReportID)
+	fmt.Fprintf(&b, "    <date_range><begin>%d</begin><end>%d</end></date_range>\n",
+		metadata.BeginDate.Unix(), metadata.EndDate.Unix())
+	b.WriteString("  </report_metadata>\n  <policy_published>\n")
+	fmt.Fprintf(&b, "    <domain>%s</domain>\n", policy.Domain)
+	optional("    ", "adkim", policy.ADKIM)
+	optional("    ", "aspf", policy.ASPF)
+	optional("    ", "p", policy.P)
+	optional("    ", "sp", policy.SP)
+	optional("    ", "pct", policy.PCT)
+	optional("    ", "fo", policy.FO)
+	b.WriteString("  </policy_published>\n")
+
+	for _, record := range records {
+		b.WriteString("  <record>\n    <row>\n")
+		fmt.Fprintf(&b, "      <source_ip>%s</source_ip>\n", record.Source.IPAddress)
+		fmt.Fprintf(&b, "      <count>%d</count>\n", record.Count)
+		evaluated := record.PolicyEvaluated
+		fmt.Fprintf(&b, "      <policy_evaluated><disposition>%s</disposition><dkim>%s</dkim><spf>%s</spf>",
+			evaluated.Disposition, evaluated.DKIM, evaluated.SPF)
+		for _, reason := range evaluated.PolicyOverrideReasons {
+			b.WriteString("<reason>")
+			if reason.Type != nil {
+				fmt.Fprintf(&b, "<type>%s</type>", *reason.Type)
+			}
+			if reason.Comment != nil {
+				fmt.Fprintf(&b, "<comment>%s</comment>", *reason.Comment)
+			}
+			b.WriteString("</reason>")
+		}
+		b.WriteString("</policy_evaluated>\n    </row>\n")
+		fmt.Fprintf(&b, "    <identifiers><header_from>%s</header_from></identifiers>\n", record.Identifiers.HeaderFrom)
+
+		if len(record.AuthResults.DKIM) > 0 || len(record.AuthResults.SPF) > 0 {
+			b.WriteString("    <auth_results>\n")
+			for _, dkim := range record.AuthResults.DKIM {
+				fmt.Fprintf(&b, "      <dkim><domain>%s</domain><selector>%s</selector><result>%s</result></dkim>\n",
+					dkim.Domain, dkim.Selector, dkim.Result)
+			}
+			for _, spf := range record.AuthResults.SPF {
+				fmt.Fprintf(&b, "      <spf><domain>%s</domain><scope>%s</scope><result>%s</result></spf>\n",
+					spf.Domain, spf.Scope, spf.Result)
+			}
+			b.WriteString("    </auth_results>\n")
+		}
+		b.WriteString("  </record>\n")
+	}
+
+	b.WriteString("</feedback>\n")
+	return []byte(b.String())
+}
+
+func TestParser_SMTPTLSReportWithoutContactInfo(t *testing.T) {
+	data := []byte(`{
+  "organization-name": "Example Inc.",
+  "date-range": {
+    "start-datetime": "2024-01-09T00:00:00Z",
+    "end-datetime": "2024-01-09T23:59:59Z"
+  },
+  "report-id": "2024-01-09T00:00:00Z_example.com",
+  "policies": [{
+    "policy": {
+      "policy-type": "sts",
+      "policy-domain": "example.com",
+      "mx-host": ["*.mail.example.com"]
+    },
+    "summary": {
+      "total-successful-session-count": 10,
+      "total-failure-session-count": 1
+    }
+  }]
+}`)
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+	parser.metrics = newTestParserMetrics()
+
+	report, err := parser.ParseSMTPTLSFromBytes(data)
+	if err != nil {
+		t.Fatalf("ParseSMTPTLSFromBytes() error = %v", err)
+	}
+	if report.ContactInfo != "" {
+		t.Errorf("Expected empty contact info, got %q", report.ContactInfo)
+	}
+	if report.OrganizationName != "Example Inc." || report.ReportID != "2024-01-09T00:00:00Z_example.com" {
+		t.Errorf("Unexpected report metadata %+v", report)
+	}
+	if len(report.Policies) != 1 || report.Policies[0].SuccessfulSessionCount != 10 ||
+		len(report.Policies[0].MXHostPatterns) != 1 {
+		t.Errorf("Unexpected policies %+v", report.Policies)
+	}
+
+	if err := parser.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+	if len(storage.smtpTLSReports) != 1 {
+		t.Fatalf("Expected 1 stored SMTP TLS report, got %d", len(storage.smtpTLSReports))
+	}
+	if storage.smtpTLSReports[0].ContactInfo != "" {
+		t.Errorf("Expected stored contact info to be empty, got %q", storage.smtpTLSReports[0].ContactInfo)
+	}
+
+	got := testutil.ToFloat64(parser.metrics.ParseWarningsTotal.WithLabelValues("smtp_tls", "missing_contact_info"))
+	if got != 2 {
+		t.Errorf("warnings{missing_contact_info} = %v, want 2", got)
+	}
+}
+
+// newTestParserMetrics creates parser metrics that are not registered with the
+// default Prometheus registry
+func newTestParserMetrics() *metrics.ParserMetrics {
+	return &metrics.ParserMetrics{
+		ParsedReportsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "test_reports_total"},
+			[]string{"type", "source"},
+		),
+		ParseFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "test_failures_total"},
+			[]string{"type", "source", "reason"},
+		),
+		ParseWarningsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "test_warnings_total"},
+			[]string{"type", "warning"},
+		),
+		ParseDurationSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "test_duration_seconds"},
+			[]string{"type", "source"},
+		),
+		ReportSizeBytes: prometheus.NewHistogram(
+			prometheus.HistogramOpts{Name: "test_report_size_bytes"},
+		),
+	}
+}
+
+func TestParser_ParseFailureReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		reason string
+	}{
+		{
+			name:   "Truncated zip",
+			data:   "PK\x03\x04\x14\x00\x00\x00\x08\x00",
+			reason: "extraction_failed",
+		},
+		{
+			name:   "Malformed XML",
+			data:   "<?xml version=\"1.0\"?>\n<feedback>\n  <report_metadata>\n    <org_name>Example</org_name>\n",
+			reason: "xml_syntax",
+		},
+		{
+			name:   "Invalid JSON",
+			data:   "{\"organization-name\": \"Example\", \"policies\": [",
+			reason: "json_syntax",
+		},
+		{
+			name:   "Email without feedback report",
+			data:   "From: a@example.com\r\nSubject: hello\r\n\r\nJust a regular message.\r\n",
+			reason: "mime_no_feedback",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := createTestParser(t)
+			parser.metrics = newTestParserMetrics()
+
+			if err := parser.ParseData([]byte(tt.data)); err == nil {
+				t.Fatal("ParseData() expected error, got nil")
+			}
+
+			got := testutil.ToFloat64(parser.metrics.ParseFailuresTotal.WithLabelValues("unknown", "http", tt.reason))
+			if got != 1 {
+				t.Errorf("failures{reason=%q} = %v, want 1", tt.reason, got)
+			}
+			if got := testutil.ToFloat64(parser.metrics.ParseFailuresTotal.WithLabelValues("unknown", "http", "unknown_format")); got != 0 {
+				t.Errorf("failures{reason=\"unknown_format\"} = %v, want 0", got)
+			}
+		})
+	}
+}
+
+// Benchmark tests
+func BenchmarkParser_ParseAggregateReport(b *testing.B) {
+	logger := zaptest.NewLogger(b)
+	cfg := config.ParserConfig{
+		Offline: true,
+	}
+
+	// Create parser with empty metrics to avoid registration conflicts
+	parser := &Parser{
+		config:  cfg,
+		storage: nil,
+		logger:  logger,
+		metrics: &metrics.ParserMetrics{},
+	}
+
+	samplePath := filepath.Join("../../samples/aggregate", "!example.com!1538204542!1538463818.xml")
+	data, err := os.ReadFile(samplePath)
+	if err != nil {
+		b.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := parser.ParseData(data)
+		if err != nil {
+			b.Fatalf("Parse error: %v", err)
+		}
+	}
+}
+
+func BenchmarkParser_ParseLargeAggregateReport(b *testing.B) {
+	logger := zaptest.NewLogger(b)
+	cfg := config.ParserConfig{
+		Offline: true,
+	}
+
+	// Create parser with empty metrics to avoid registration conflicts
+	parser := &Parser{
+		config:  cfg,
+		storage: nil,
+		logger:  logger,
+		metrics: &metrics.ParserMetrics{},
+	}
+
+	samplePath := filepath.Join("../../samples/aggregate", "!large-example.com!1711897200!1711983600.xml")
+	data, err := os.ReadFile(samplePath)
+	if err != nil {
+		b.Skipf("Large sample file not found: %v", err)
+		return
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := parser.ParseData(data)
+		if err != nil {
+			b.Fatalf("Parse error: %v", err)
+		}
+	}
+}
+
+func BenchmarkParser_EnrichLargeAggregateReport(b *testing.B) {
+	samplePath := filepath.Join("../../samples/aggregate", "!large-example.com!1711897200!1711983600.xml")
+	data, err := os.ReadFile(samplePath)
+	if err != nil {
+		b.Skipf("Large sample file not found: %v", err)
+		return
+	}
+
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			parser := &Parser{
+				config: config.ParserConfig{
+					Offline:               true,
+					EnrichmentConcurrency: concurrency,
+				},
+				logger: zap.NewNop(),
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := parser.ParseAggregateFromBytes(data); err != nil {
+					b.Fatalf("Parse error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// largeAggregateXML returns an aggregate report with n records
+func largeAggregateXML(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<feedback>
+  <report_metadata>
+    <org_name>example.net</org_name>
+    <email>dmarc@example.net</email>
+    <report_id>large</report_id>
+    <date_range><begin>1704067200</begin><end>1704153599</end></date_range>
+  </report_metadata>
+  <policy_published><domain>example.com</domain><p>none</p></policy_published>
+`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `  <record>
+    <row>
+      <source_ip>10.%d.%d.%d</source_ip>
+      <count>%d</count>
+      <policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>fail</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+    <auth_results>
+      <dkim><domain>example.com</domain><selector>s1</selector><result>pass</result></dkim>
+      <spf><domain>example.com</domain><result>fail</result></spf>
+    </auth_results>
+  </record>
+`, i>>16&0xff, i>>8&0xff, i&0xff, i%100+1)
+	}
+	buf.WriteString("</feedback>\n")
+	return buf.Bytes()
+}
+
+// peakHeap runs f and returns the highest heap size sampled meanwhile, above
+// the heap size before f
+func peakHeap(f func()) uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	base := stats.HeapAlloc
+
+	done := make(chan struct{})
+	sampled := make(chan uint64)
+	go func() {
+		var peak uint64
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapAlloc)
+			select {
+			case <-done:
+				sampled <- peak
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	f()
+	close(done)
+	peak := <-sampled
+	if peak < base {
+		return 0
+	}
+	return peak - base
+}
+
+func BenchmarkParser_StreamLargeAggregateReport(b *testing.B) {
+	parser := &Parser{
+		config: config.ParserConfig{Offline: true, EnrichmentConcurrency: 4},
+		logger: zap.NewNop(),
+	}
+	data := largeAggregateXML(100000)
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		var peak uint64
+		for i := 0; i < b.N; i++ {
+			peak = max(peak, peakHeap(func() {
+				if _, err := parser.parseAggregateXML(data); err != nil {
+					b.Fatalf("parseAggregateXML() error = %v", err)
+				}
+			}))
+		}
+		b.ReportMetric(float64(peak), "peak-heap-B")
+	})
+
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		var peak uint64
+		for i := 0; i < b.N; i++ {
+			peak = max(peak, peakHeap(func() {
+				_, err := parser.streamAggregateXML(bytes.NewReader(data), streamChunkSize,
+					func(report *AggregateReport, offset int, records []Record) error {
+						return nil
+					})
+				if err != nil {
+					b.Fatalf("streamAggregateXML() error = %v", err)
+				}
+			}))
+		}
+		b.ReportMetric(float64(peak), "peak-heap-B")
+	})
+}
+
+func TestParser_RecordSamplingFoldsLongTail(t *testing.T) {
+	var records strings.Builder
+	addRecord := func(ip string, count int, disposition, dkim, spf string) {
+		fmt.Fprintf(&records, `
+  <record>
+    <row>
+      <source_ip>%s</source_ip>
+      <count>%d</count>
+      <policy_evaluated>
+        <disposition>%s</disposition>
+        <dkim>%s</dkim>
+        <spf>%s</spf>
+      </policy_evaluated>
+    </row>
+    <identifiers>
+      <header_from>example.com</header_from>
+    </identifiers>
+  </record>`, ip, count, disposition, dkim, spf)
+	}
+
+	addRecord("192.0.2.1", 500, "none", "pass", "pass")
+	addRecord("192.0.2.2", 120, "reject", "fail", "fail")
+	for i := 0; i < 30; i++ {
+		addRecord(fmt.Sprintf("198.51.100.%d", i+1), 1, "none", "fail", "pass")
+	}
+	for i := 0; i < 20; i++ {
+		addRecord(fmt.Sprintf("203.0.113.%d", i+1), 2, "reject", "fail", "fail")
+	}
+
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feedback>
+  <report_metadata>
+    <org_name>Example Receiver</org_name>
+    <email>dmarc@receiver.example</email>
+    <report_id>sampling-1</report_id>
+    <date_range>
+      <begin>1700000000</begin>
+      <end>1700086399</end>
+    </date_range>
+  </report_metadata>
+  <policy_published>
+    <domain>example.com</domain>
+    <p>reject</p>
+  </policy_published>` + records.String() + `
+</feedback>`)
+
+	parser := createTestParser(t)
+	parser.config.RecordSamplingThreshold = 2
+	parser.config.RecordSamplingMinRecords = 10
+
+	report, err := parser.parseAggregateXML(data)
+	if err != nil {
+		t.Fatalf("Failed to parse report: %v", err)
+	}
+
+	if len(report.Records) != 4 {
+		t.Fatalf("Expected 2 kept records and 2 other records, got %d records", len(report.Records))
+	}
+
+	// High-count records are preserved as-is
+	if report.Records[0].Source.IPAddress != "192.0.2.1" || report.Records[0].Count != 500 {
+		t.Errorf("Unexpected first record: %+v", report.Records[0])
+	}
+	if report.Records[1].Source.IPAddress != "192.0.2.2" || report.Records[1].Count != 120 {
+		t.Errorf("Unexpected second record: %+v", report.Records[1])
+	}
+
+	// The tail is bucketed per disposition
+	expected := []struct {
+		disposition string
+		count       int
+		dkim        string
+		spf         string
+		spfAligned  bool
+	}{
+		{"none", 30, "fail", "pass", true},
+		{"reject", 40, "fail", "fail", false},
+	}
+	for i, want := range expected {
+		other := report.Records[2+i]
+		if other.Source.Name != OtherSourceName || other.Source.Type != OtherSourceName {
+			t.Errorf("Expected other source, got %+v", other.Source)
+		}
+		if other.PolicyEvaluated.Disposition != want.disposition {
+			t.Errorf("Expected disposition %s, got %s", want.disposition, other.PolicyEvaluated.Disposition)
+		}
+		if other.Count != want.count {
+			t.Errorf("Expected %s other count %d, got %d", want.disposition, want.count, other.Count)
+		}
+		if other.PolicyEvaluated.DKIM != want.dkim || other.PolicyEvaluated.SPF != want.spf {
+			t.Errorf("Expected dkim=%s spf=%s, got dkim=%s spf=%s", want.dkim, want.spf,
+				other.PolicyEvaluated.DKIM, other.PolicyEvaluated.SPF)
+		}
+		if other.Alignment.SPF != want.spfAligned {
+			t.Errorf("Expected SPF alignment %v, got %v", want.spfAligned, other.Alignment.SPF)
+		}
+		if other.Identifiers.HeaderFrom != "example.com" {
+			t.Errorf("Expected header_from example.com, got %s", other.Identifiers.HeaderFrom)
+		}
+	}
+
+	// Below the minimum record count nothing is sampled
+	parser.config.RecordSamplingMinRecords = 1000
+	report, err = parser.parseAggregateXML(data)
+	if err != nil {
+		t.Fatalf("Failed to parse report: %v", err)
+	}
+	if len(report.Records) != 52 {
+		t.Errorf("Expected all 52 records without sampling, got %d", len(report.Records))
+	}
+}
+
+func TestParser_ParseEmailReturnsAllReportTypes(t *testing.T) {
+	parser := createTestParser(t)
+
+	xmlData, err := os.ReadFile("../../samples/aggregate/!example.com!1538204542!1538463818.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	attachment := "--BOUNDARY\r\n" +
+		"Content-Type: application/xml\r\n" +
+		"Content-Disposition: attachment; filename=\"report.xml\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString(xmlData) + "\r\n"
+	email := strings.Replace(fmt.Sprintf(forensicEmailTemplate, ""),
+		"--BOUNDARY--\r\n", attachment+"--BOUNDARY--\r\n", 1)
+
+	reports, err := parser.ParseEmail([]byte(email))
+	if err != nil {
+		t.Fatalf("ParseEmail() error = %v", err)
+	}
+
+	if len(reports.ForensicReports) != 1 {
+		t.Fatalf("Expected 1 forensic report, got %d", len(reports.ForensicReports))
+	}
+	if reports.ForensicReports[0].ReportedDomain != "example.com" {
+		t.Errorf("Expected reported domain example.com, got %s", reports.ForensicReports[0].ReportedDomain)
+	}
+
+	if len(reports.AggregateReports) != 1 {
+		t.Fatalf("Expected 1 aggregate report, got %d", len(reports.AggregateReports))
+	}
+	if reports.AggregateReports[0].PolicyPublished.Domain != "example.com" {
+		t.Errorf("Expected policy domain example.com, got %s", reports.AggregateReports[0].PolicyPublished.Domain)
+	}
+
+	if len(reports.SMTPTLSReports) != 0 {
+		t.Errorf("Expected no SMTP TLS reports, got %d", len(reports.SMTPTLSReports))
+	}
+}
+
+func TestParser_ParseEmailReturnsAllAggregateAttachments(t *testing.T) {
+	parser := createTestParser(t)
+
+	report := func(reportID string) []byte {
+		return aggregateReportXML(testReportMetadata("google.com", "dmarc@google.com", reportID),
+			PolicyPublished{Domain: "example.com", P: "none"},
+			testAggregateRecord("192.0.2.1", 1, "example.com"))
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, reportID := range []string{"zip-1", "zip-2"} {
+		w, err := zw.Create("google.com!example.com!" + reportID + ".xml")
+		if err != nil {
+			t.Fatalf("Failed to create archive entry: %v", err)
+		}
+		if _, err := w.Write(report(reportID)); err != nil {
+			t.Fatalf("Failed to write archive entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	attachment := func(contentType, filename string, data []byte) string {
+		return "--BOUNDARY\r\n" +
+			"Content-Type: " + contentType + "\r\n" +
+			"Content-Disposition: attachment; filename=\"" + filename + "\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString(data) + "\r\n"
+	}
+	email := "From: noreply-dmarc-support@google.com\r\n" +
+		"To: dmarc@example.com\r\n" +
+		"Subject: Report domain: example.com Submitter: google.com\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"BOUNDARY\"\r\n" +
+		"\r\n" +
+		"--BOUNDARY\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Reports attached.\r\n" +
+		attachment("application/xml", "first.xml", report("xml-1")) +
+		attachment("application/xml", "second.xml", report("xml-2")) +
+		attachment("application/zip", "reports.zip", archive.Bytes()) +
+		"--BOUNDARY--\r\n"
+
+	reports, err := parser.ParseEmail([]byte(email))
+	if err != nil {
+		t.Fatalf("ParseEmail() error = %v", err)
+	}
+
+	var reportIDs []string
+	for _, report := range reports.AggregateReports {
+		reportIDs = append(reportIDs, report.ReportMetadata.ReportID)
+	}
+	if want := []string{"xml-1", "xml-2", "zip-1", "zip-2"}; !reflect.DeepEqual(reportIDs, want) {
+		t.Errorf("Expected aggregate reports %v, got %v", want, reportIDs)
+	}
+}
+
+func TestParser_ParseInlineXMLEmail(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/inline-xml.eml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	parser := createTestParser(t)
+	report, err := parser.parseAggregateFromEmail(data)
+	if err != nil {
+		t.Fatalf("Failed to parse inline XML email: %v", err)
+	}
+
+	if report.ReportMetadata.ReportID != "inline-xml-2024" {
+		t.Errorf("Expected report ID inline-xml-2024, got %s", report.ReportMetadata.ReportID)
+	}
+	if len(report.Records) != 1 || report.Records[0].Count != 3 {
+		t.Errorf("Expected one record with count 3, got %+v", report.Records)
+	}
+
+	// Inline parts can be ignored to only accept attachments
+	parser.config.IgnoreInlineXML = true
+	if _, err := parser.parseAggregateFromEmail(data); err == nil {
+		t.Error("Expected inline XML to be ignored when ignore_inline_xml is set")
+	}
+}
+
+func TestParser_ParseReportInsideRFC822Attachment(t *testing.T) {
+	parser := createTestParser(t)
+
+	data, err := os.ReadFile("../../samples/aggregate/forwarded-rfc822.eml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	reports, err := parser.ParseEmail(data)
+	if err != nil {
+		t.Fatalf("ParseEmail() error = %v", err)
+	}
+	if len(reports.AggregateReports) != 1 {
+		t.Fatalf("Expected 1 aggregate report, got %d", len(reports.AggregateReports))
+	}
+	if got := reports.AggregateReports[0].ReportMetadata.ReportID; got != "102675056" {
+		t.Errorf("Expected report ID 102675056, got %s", got)
+	}
+	if got := reports.AggregateReports[0].ReportMetadata.OrgName; got != "FastMail Pty Ltd" {
+		t.Errorf("Expected org FastMail Pty Ltd, got %s", got)
+	}
+
+	data, err = os.ReadFile("../../samples/smtp_tls/forwarded-rfc822.eml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	reports, err = parser.ParseEmail(data)
+	if err != nil {
+		t.Fatalf("ParseEmail() error = %v", err)
+	}
+	if len(reports.SMTPTLSReports) != 1 {
+		t.Fatalf("Expected 1 SMTP TLS report, got %d", len(reports.SMTPTLSReports))
+	}
+	if got := reports.SMTPTLSReports[0].ReportID; got != "5065427c-23d3-47ca-b6e0-946ea0e8c4be" {
+		t.Errorf("Expected report ID 5065427c-23d3-47ca-b6e0-946ea0e8c4be, got %s", got)
+	}
+}
+
+func TestParser_ParseEmailNoReports(t *testing.T) {
+	parser := createTestParser(t)
+
+	email := "From: a@example.com\r\nSubject: hello\r\nMIME-Version: 1.0\r\nContent-Type: text/plain\r\n\r\nhello\r\n"
+	if _, err := parser.ParseEmail([]byte(email)); err == nil {
+		t.Error("Expected error for email without reports")
+	}
+}
+
+func TestParser_TruncatesOversizedReportID(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	parser := createTestParser(t)
+	parser.logger = zap.New(core)
+	parser.metrics = newTestParserMetrics()
+	parser.config.MaxIdentifierLength = 64
+
+	longID := strings.Repeat("a", 1024*1024)
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feedback>
+  <report_metadata>
+    <org_name>Example &lt;script&gt;Receiver</org_name>
+    <email>dmarc@receiver.example</email>
+    <report_id>` + longID + `</report_id>
+    <date_range>
+      <begin>1700000000</begin>
+      <end>1700086399</end>
+    </date_range>
+  </report_metadata>
+  <policy_published>
+    <domain>example.com</domain>
+    <p>none</p>
+  </policy_published>
+  <record>
+    <row>
+      <source_ip>192.0.2.1</source_ip>
+      <count>1</count>
+      <policy_evaluated>
+        <disposition>none</disposition>
+        <dkim>pass</dkim>
+        <spf>pass</spf>
+      </policy_evaluated>
+    </row>
+    <identifiers>
+      <header_from>example.com</header_from>
+    </identifiers>
+  </record>
+</feedback>`)
+
+	report, err := parser.parseAggregateXML(data)
+	if err != nil {
+		t.Fatalf("Failed to parse report: %v", err)
+	}
+
+	if report.ReportMetadata.ReportID != longID[:64] {
+		t.Errorf("Expected report ID truncated to 64 bytes, got %d bytes", len(report.ReportMetadata.ReportID))
+	}
+	if report.ReportMetadata.OrgName != "Example scriptReceiver" {
+		t.Errorf("Expected sanitized org name, got %q", report.ReportMetadata.OrgName)
+	}
+	if report.PolicyPublished.Domain != "example.com" {
+		t.Errorf("Expected domain unchanged, got %q", report.PolicyPublished.Domain)
+	}
+
+	truncated := logs.FilterMessage("Truncated oversized report field").All()
+	if len(truncated) != 1 {
+		t.Fatalf("Expected 1 truncation warning, got %d", len(truncated))
+	}
+	if field := truncated[0].ContextMap()["field"]; field != "report_id" {
+		t.Errorf("Expected truncation warning for report_id, got %v", field)
+	}
+
+	if got := testutil.ToFloat64(parser.metrics.ParseWarningsTotal.WithLabelValues("aggregate", "report_id_truncated")); got != 1 {
+		t.Errorf("warnings{warning=report_id_truncated} = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(parser.metrics.ParseWarningsTotal.WithLabelValues("aggregate", "org_name_sanitized")); got != 1 {
+		t.Errorf("warnings{warning=org_name_sanitized} = %v, want 1", got)
+	}
+}
+
+func TestParser_StoreUnparsed(t *testing.T) {
+	data := []byte(`<?xml version="1.0"?><not-a-report><x>1</x></not-a-report>`)
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			storage := &mockStorage{}
+			parser := createTestParser(t)
+			parser.storage = storage
+			parser.config.StoreUnparsed = enabled
+			parser.config.UnparsedPreviewBytes = 16
+
+			if err := parser.ParseData(data); err == nil {
+				t.Fatal("ParseData() expected error, got nil")
+			}
+
+			if !enabled {
+				if len(storage.unparsedReports) != 0 {
+					t.Errorf("Expected no unparsed reports when disabled, got %d", len(storage.unparsedReports))
+				}
+				return
+			}
+
+			if len(storage.unparsedReports) != 1 {
+				t.Fatalf("Expected 1 unparsed report, got %d", len(storage.unparsedReports))
+			}
+			report := storage.unparsedReports[0]
+			if report.Source != "http" {
+				t.Errorf("Source = %q, want http", report.Source)
+			}
+			if report.Size != len(data) {
+				t.Errorf("Size = %d, want %d", report.Size, len(data))
+			}
+			if report.Reason != "xml_invalid" {
+				t.Errorf("Reason = %q, want xml_invalid", report.Reason)
+			}
+			if !strings.HasPrefix(report.ContentType, "text/xml") {
+				t.Errorf("ContentType = %q, want text/xml", report.ContentType)
+			}
+			if report.Preview != string(data[:16]) {
+				t.Errorf("Preview = %q, want %q", report.Preview, data[:16])
+			}
+			if report.Error == "" || report.ReceivedAt.IsZero() {
+				t.Errorf("Expected error and timestamp to be set, got %+v", report)
+			}
+		})
+	}
+}
+
+func TestParser_MissingRecordCountDefaultsToOne(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feedback>
+  <report_metadata>
+    <org_name>Example Receiver</org_name>
+    <email>dmarc@receiver.example</email>
+    <report_id>missing-count</report_id>
+    <date_range>
+      <begin>1700000000</begin>
+      <end>1700086399</end>
+    </date_range>
+  </report_metadata>
+  <policy_published>
+    <domain>example.com</domain>
+    <p>none</p>
+  </policy_published>
+  <record>
+    <row>
+      <source_ip>192.0.2.1</source_ip>
+      <policy_evaluated>
+        <disposition>none</disposition>
+        <dkim>pass</dkim>
+        <spf>pass</spf>
+      </policy_evaluated>
+    </row>
+    <identifiers>
+      <header_from>example.com</header_from>
+    </identifiers>
+  </record>
+  <record>
+    <row>
+      <source_ip>192.0.2.2</source_ip>
+      <count>7</count>
+      <policy_evaluated>
+        <disposition>none</disposition>
+        <dkim>pass</dkim>
+        <spf>pass</spf>
+      </policy_evaluated>
+    </row>
+    <identifiers>
+      <header_from>example.com</header_from>
+    </identifiers>
+  </record>
+</feedback>`)
+
+	tests := []struct {
+		name      string
+		enabled   bool
+		wantCount int
+		wantWarn  float64
+	}{
+		{"Defaults to 1", true, 1, 1},
+		{"Disabled keeps 0", false, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := createTestParser(t)
+			parser.metrics = newTestParserMetrics()
+			parser.config.DefaultMissingCount = tt.enabled
+
+			report, err := parser.parseAggregateXML(data)
+			if err != nil {
+				t.Fatalf("Failed to parse report: %v", err)
+			}
+
+			if len(report.Records) != 2 {
+				t.Fatalf("Expected 2 records, got %d", len(report.Records))
+			}
+			if report.Records[0].Count != tt.wantCount {
+				t.Errorf("Count = %d, want %d", report.Records[0].Count, tt.wantCount)
+			}
+			if report.Records[1].Count != 7 {
+				t.Errorf("Expected explicit count 7 unchanged, got %d", report.Records[1].Count)
+			}
+
+			got := testutil.ToFloat64(parser.metrics.ParseWarningsTotal.WithLabelValues("aggregate", "missing_count"))
+			if got != tt.wantWarn {
+				t.Errorf("warnings{warning=missing_count} = %v, want %v", got, tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestParser_SeparateRegistries(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feedback>
+  <report_metadata>
+    <org_name>Example Receiver</org_name>
+    <email>dmarc@receiver.example</email>
+    <report_id>separate-registries</report_id>
+    <date_range>
+      <begin>1700000000</begin>
+      <end>1700086399</end>
+    </date_range>
+  </report_metadata>
+  <policy_published>
+    <domain>example.com</domain>
+    <p>none</p>
+  </policy_published>
+  <record>
+    <row>
+      <source_ip>192.0.2.1</source_ip>
+      <count>1</count>
+      <policy_evaluated>
+        <disposition>none</disposition>
+        <dkim>pass</dkim>
+        <spf>pass</spf>
+      </policy_evaluated>
+    </row>
+    <identifiers>
+      <header_from>example.com</header_from>
+    </identifiers>
+  </record>
+</feedback>`)
+
+	cfg := config.ParserConfig{Offline: true}
+	registryA := prometheus.NewRegistry()
+	registryB := prometheus.NewRegistry()
+
+	// Registering the same metric names on separate registries must not panic
+	parserA := New(cfg, nil, zaptest.NewLogger(t), registryA)
+	parserB := New(cfg, nil, zaptest.NewLogger(t), registryB)
+
+	if err := parserA.ParseData(data); err != nil {
+		t.Fatalf("Failed to parse report: %v", err)
+	}
+
+	countA, err := testutil.GatherAndCount(registryA, "parsedmarc_parser_reports_total")
+	if err != nil {
+		t.Fatalf("Failed to gather registry A: %v", err)
+	}
+	if countA != 1 {
+		t.Errorf("Expected 1 series in registry A, got %d", countA)
+	}
+
+	countB, err := testutil.GatherAndCount(registryB, "parsedmarc_parser_reports_total")
+	if err != nil {
+		t.Fatalf("Failed to gather registry B: %v", err)
+	}
+	if countB != 0 {
+		t.Errorf("Expected no series in registry B, got %d", countB)
+	}
+
+	if parserB.metrics == parserA.metrics {
+		t.Error("Expected parsers to have independent metrics")
+	}
+}
+
+func TestParser_ParsePCT(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		want     int
+		wantWarn string
+	}{
+		{"Full enforcement", "100", 100, ""},
+		{"Partial enforcement", "50", 50, ""},
+		{"Not an integer", "abc", 100, "invalid_pct"},
+		{"Out of range", "150", 100, "pct_out_of_range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := createTestParser(t)
+			parser.metrics = newTestParserMetrics()
+
+			if got := parser.parsePCT("pct-test", tt.raw); got != tt.want {
+				t.Errorf("Expected pct %d, got %d", tt.want, got)
+			}
+
+			warnings := testutil.CollectAndCount(parser.metrics.ParseWarningsTotal)
+			if tt.wantWarn == "" {
+				if warnings != 0 {
+					t.Errorf("Expected no warnings, got %d", warnings)
+				}
+				return
+			}
+			got := testutil.ToFloat64(parser.metrics.ParseWarningsTotal.WithLabelValues("aggregate", tt.wantWarn))
+			if got != 1 {
+				t.Errorf("Expected 1 %s warning, got %v", tt.wantWarn, got)
+			}
+		})
+	}
+}
+
+func TestParser_StoresSMTPTLSReportThroughStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+	}{
+		{"JSON report", "rfc8460.json"},
+		{"Email report", "google.com_smtp_tls_report.eml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("../../samples/smtp_tls", tt.filename))
+			if err != nil {
+				t.Fatalf("Failed to read sample: %v", err)
+			}
+
+			// Use the generic interface so any backend receives the report
+			storage := &mockStorage{}
+			var backend Storage = storage
+
+			parser := createTestParser(t)
+			parser.storage = backend
+
+			if err := parser.ParseData(data); err != nil {
+				t.Fatalf("Failed to parse SMTP TLS report: %v", err)
+			}
+
+			if len(storage.smtpTLSReports) != 1 {
+				t.Fatalf("Expected 1 stored SMTP TLS report, got %d", len(storage.smtpTLSReports))
+			}
+			report := storage.smtpTLSReports[0]
+			if report.ReportID == "" || len(report.Policies) == 0 {
+				t.Errorf("Expected stored report with ID and policies, got %+v", report)
+			}
+			if len(storage.aggregateReports) != 0 || len(storage.forensicReports) != 0 {
+				t.Error("Expected no other report types to be stored")
+			}
+		})
+	}
+}
+
+func TestParser_AuditLogsEveryIngestionAttempt(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/!example.com!1538204542!1538463818.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	core, logs := observer.New(zap.InfoLevel)
+	parser := createTestParser(t)
+	parser.SetAuditLogger(zap.New(core))
+
+	if err := parser.ParseData(data); err != nil {
+		t.Fatalf("Failed to parse report: %v", err)
+	}
+	if err := parser.ParseData([]byte("not a report")); err == nil {
+		t.Fatal("Expected parse failure")
+	}
+
+	entries := logs.FilterMessage("report_ingestion").All()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit records, got %d", len(entries))
+	}
+
+	success := entries[0].ContextMap()
+	if success["outcome"] != AuditOutcomeSuccess || success["report_type"] != "aggregate" {
+		t.Errorf("Expected successful aggregate record, got %v", success)
+	}
+	if success["report_id"] == "" || success["source"] != "http" || success["error"] != "" {
+		t.Errorf("Unexpected success record fields: %v", success)
+	}
+
+	failure := entries[1].ContextMap()
+	if failure["outcome"] != AuditOutcomeFailure || failure["report_type"] != "unknown" {
+		t.Errorf("Expected failed unknown record, got %v", failure)
+	}
+	if failure["error"] == "" || failure["size_bytes"] != int64(len("not a report")) {
+		t.Errorf("Unexpected failure record fields: %v", failure)
+	}
+
+	// Both records share the same schema
+	for _, entry := range entries {
+		if len(entry.Context) != 7 {
+			t.Errorf("Expected 7 audit fields, got %d", len(entry.Context))
+		}
+	}
+}
+
+// failingStorage is a ReportStore whose stores and lookups all fail with err
+type failingStorage struct {
+	mockStorage
+	err error
+}
+
+func (s *failingStorage) StoreAggregateReport(report *AggregateReport) error { return s.err }
+func (s *failingStorage) StoreForensicReport(report *ForensicReport) error   { return s.err }
+func (s *failingStorage) StoreSMTPTLSReport(report *SMTPTLSReport) error     { return s.err }
+
+func (s *failingStorage) ReportExists(reportType, orgName, reportID string) (bool, error) {
+	return false, s.err
+}
+
+func (s *failingStorage) DeleteReport(reportType, orgName, reportID string) error {
+	return s.err
+}
+
+func TestParser_AuditsStorageFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		sample     string
+		mode       WriteMode
+		reportType string
+	}{
+		{"Aggregate store", "../../samples/aggregate/!example.com!1538204542!1538463818.xml", WriteModeAppend, "aggregate"},
+		{"Aggregate write mode", "../../samples/aggregate/!example.com!1538204542!1538463818.xml", WriteModeCreate, "aggregate"},
+		{"Forensic store", "../../samples/forensic/dmarc_ruf_report_linkedin.eml", WriteModeAppend, "forensic"},
+		{"SMTP TLS store", "../../samples/smtp_tls/mail.ru.json", WriteModeAppend, "smtp_tls"},
+		{"SMTP TLS write mode", "../../samples/smtp_tls/mail.ru.json", WriteModeReplace, "smtp_tls"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.sample)
+			if err != nil {
+				t.Fatalf("Failed to read sample: %v", err)
+			}
+
+			core, logs := observer.New(zap.InfoLevel)
+			parser := createTestParser(t)
+			parser.storage = &failingStorage{err: errors.New("connection refused")}
+			parser.SetAuditLogger(zap.New(core))
+
+			if err := parser.ParseDataWithMode(data, tt.mode); !errors.Is(err, ErrStorage) {
+				t.Fatalf("Expected a storage error, got %v", err)
+			}
+
+			entries := logs.FilterMessage("report_ingestion").All()
+			if len(entries) != 1 {
+				t.Fatalf("Expected 1 audit record, got %d", len(entries))
+			}
+			record := entries[0].ContextMap()
+			if record["outcome"] != AuditOutcomeFailure || record["report_type"] != tt.reportType {
+				t.Errorf("Expected a failed %s record, got %v", tt.reportType, record)
+			}
+			if !strings.Contains(record["error"].(string), "connection refused") {
+				t.Errorf("Expected the storage error in the audit record, got %v", record["error"])
+			}
+		})
+	}
+}
+
+func TestParser_StorageDuplicateIsNotStorageError(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/!example.com!1538204542!1538463818.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	core, logs := observer.New(zap.InfoLevel)
+	parser := createTestParser(t)
+	parser.storage = &failingStorage{err: fmt.Errorf("aggregate report 1: %w", ErrDuplicateReport)}
+	parser.SetAuditLogger(zap.New(core))
+
+	err = parser.ParseData(data)
+	if !errors.Is(err, ErrDuplicateReport) {
+		t.Fatalf("Expected a duplicate error, got %v", err)
+	}
+	// Retrying a duplicate would be rejected again, so it must not be retried
+	if errors.Is(err, ErrStorage) {
+		t.Errorf("Expected a duplicate not to match ErrStorage: %v", err)
+	}
+
+	entries := logs.FilterMessage("report_ingestion").All()
+	if len(entries) != 1 || entries[0].ContextMap()["outcome"] != AuditOutcomeDuplicate {
+		t.Errorf("Expected 1 duplicate audit record, got %v", entries)
+	}
+}
+
+func TestParser_ParsesEveryReportInZip(t *testing.T) {
+	samplePath := filepath.Join("../../samples/aggregate", "receiver.example!example.com!multi-report.zip")
+	data, err := os.ReadFile(samplePath)
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	wantIDs := []string{"example.com:1538463741", "inline-xml-2024"}
+
+	parse := map[string]func(p *Parser) error{
+		"ParseData": func(p *Parser) error { return p.ParseData(data) },
+		"ParseFile": func(p *Parser) error { return p.ParseFile(samplePath) },
+	}
+
+	for name, parseFn := range parse {
+		t.Run(name, func(t *testing.T) {
+			storage := &mockStorage{}
+			parser := createTestParser(t)
+			parser.storage = storage
+
+			if err := parseFn(parser); err != nil {
+				t.Fatalf("Failed to parse ZIP: %v", err)
+			}
+
+			if len(storage.aggregateReports) != len(wantIDs) {
+				t.Fatalf("Expected %d stored reports, got %d", len(wantIDs), len(storage.aggregateReports))
+			}
+			for i, want := range wantIDs {
+				if got := storage.aggregateReports[i].ReportMetadata.ReportID; got != want {
+					t.Errorf("Report %d: expected ID %s, got %s", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParser_ParseDataResultListsEveryReport(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("../../samples/aggregate", "receiver.example!example.com!multi-report.zip"))
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	parser := createTestParser(t)
+	result, err := parser.ParseDataResult(data, WriteModeAppend)
+	if err != nil {
+		t.Fatalf("ParseDataResult() error = %v", err)
+	}
+
+	wantIDs := []string{"example.com:1538463741", "inline-xml-2024"}
+	if len(result.Reports) != len(wantIDs) {
+		t.Fatalf("Expected %d results, got %d", len(wantIDs), len(result.Reports))
+	}
+	for i, want := range wantIDs {
+		report := result.Reports[i]
+		if report.ReportType != ReportTypeAggregate || report.ReportID != want {
+			t.Errorf("Result %d: expected aggregate report %s, got %s report %s", i, want, report.ReportType, report.ReportID)
+		}
+		if report.RecordCount == 0 {
+			t.Errorf("Result %d: expected a record count", i)
+		}
+	}
+}
+
+func TestParser_SkipsReportsOlderThanMaxReportAge(t *testing.T) {
+	aggregateReport := func(reportID string, end time.Time) []byte {
+		metadata := ReportMetadata{
+			OrgName:   "receiver.example",
+			OrgEmail:  "dmarc@receiver.example",
+			ReportID:  reportID,
+			BeginDate: end.Add(-24 * time.Hour),
+			EndDate:   end,
+		}
+		return aggregateReportXML(metadata, PolicyPublished{Domain: "example.com", P: "none"},
+			testAggregateRecord("192.0.2.1", 1, "example.com"))
+	}
+
+	twoYearsAgo := time.Now().AddDate(-2, 0, 0)
+	yesterday := time.Now().AddDate(0, 0, -1)
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+	parser.metrics = newTestParserMetrics()
+	parser.config.MaxReportAge = 365 * 24 * time.Hour
+
+	if err := parser.ParseData(aggregateReport("old-report", twoYearsAgo)); err != nil {
+		t.Fatalf("ParseData() of an old report error = %v", err)
+	}
+	if err := parser.ParseData(aggregateReport("recent-report", yesterday)); err != nil {
+		t.Fatalf("ParseData() of a recent report error = %v", err)
+	}
+
+	smtpTLSReport := fmt.Sprintf(`{
+  "organization-name": "Example Inc.",
+  "date-range": {"start-datetime": %q, "end-datetime": %q},
+  "contact-info": "tlsrpt@example.com",
+  "report-id": "old-tls-report",
+  "policies": [{
+    "policy": {"policy-type": "sts", "policy-domain": "example.com"},
+    "summary": {"total-successful-session-count": 1, "total-failure-session-count": 0}
+  }]
+}`, twoYearsAgo.Add(-24*time.Hour).UTC().Format(time.RFC3339), twoYearsAgo.UTC().Format(time.RFC3339))
+	if err := parser.ParseData([]byte(smtpTLSReport)); err != nil {
+		t.Fatalf("ParseData() of an old SMTP TLS report error = %v", err)
+	}
+
+	if len(storage.aggregateReports) != 1 || storage.aggregateReports[0].ReportMetadata.ReportID != "recent-report" {
+		t.Errorf("Expected only recent-report to be stored, got %d reports", len(storage.aggregateReports))
+	}
+	if len(storage.smtpTLSReports) != 0 {
+		t.Errorf("Expected old SMTP TLS report to be skipped, got %d stored", len(storage.smtpTLSReports))
+	}
+
+	for _, reportType := range []string{"aggregate", "smtp_tls"} {
+		if got := testutil.ToFloat64(parser.metrics.ParseFailuresTotal.WithLabelValues(reportType, "http", "too_old")); got != 1 {
+			t.Errorf("failures{type=%s,reason=too_old} = %v, want 1", reportType, got)
+		}
+	}
+}
+
+// forwarderTagger tags the records of a known forwarder and vetoes SMTP TLS
+// reports of an ignored organization
+type forwarderTagger struct {
+	NopReportProcessor
+	forwarderIP string
+	ignoredOrg  string
+}
+
+func (f forwarderTagger) ProcessAggregateReport(report *AggregateReport) bool {
+	for i := range report.Records {
+		if report.Records[i].Source.IPAddress == f.forwarderIP {
+			report.Records[i].Source.Name = "Known forwarder"
+			report.Records[i].Source.Type = "Forwarder"
+		}
+	}
+	return true
+}
+
+func (f forwarderTagger) ProcessSMTPTLSReport(report *SMTPTLSReport) bool {
+	return report.OrganizationName != f.ignoredOrg
+}
+
+func TestParser_ReportProcessors(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/example.net!example.com!1529366400!1529452799.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	tlsData, err := os.ReadFile("../../samples/smtp_tls/rfc8460.json")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	storage := &mockStorage{}
+	tagger := forwarderTagger{forwarderIP: "199.230.200.36", ignoredOrg: "Company-X"}
+	parser := New(config.ParserConfig{Offline: true}, storage, zaptest.NewLogger(t), prometheus.NewRegistry(),
+		NopReportProcessor{}, tagger)
+
+	if err := parser.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+	if len(storage.aggregateReports) != 1 || len(storage.aggregateReports[0].Records) == 0 {
+		t.Fatalf("Expected 1 stored aggregate report with records, got %d", len(storage.aggregateReports))
+	}
+	source := storage.aggregateReports[0].Records[0].Source
+	if source.IPAddress != tagger.forwarderIP || source.Name != "Known forwarder" || source.Type != "Forwarder" {
+		t.Errorf("Expected the forwarder's record to be tagged, got %+v", source)
+	}
+
+	// A vetoed report is skipped, not failed
+	result, err := parser.ParseDataResult(tlsData, WriteModeAppend)
+	if err != nil {
+		t.Fatalf("ParseDataResult() error = %v", err)
+	}
+	if len(result.Reports) != 1 || !result.Reports[0].Skipped {
+		t.Errorf("Expected the SMTP TLS report to be skipped, got %+v", result.Reports)
+	}
+	if len(storage.smtpTLSReports) != 0 {
+		t.Errorf("Expected the vetoed SMTP TLS report not to be stored, got %d", len(storage.smtpTLSReports))
+	}
+	if got := testutil.ToFloat64(parser.metrics.ParseFailuresTotal.WithLabelValues("smtp_tls", "http", "vetoed")); got != 1 {
+		t.Errorf("failures{type=smtp_tls,reason=vetoed} = %v, want 1", got)
+	}
+
+	// Processors run in file mode too
+	if err := parser.ParseFile("../../samples/smtp_tls/rfc8460.json"); err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	if len(storage.smtpTLSReports) != 0 {
+		t.Errorf("Expected the vetoed SMTP TLS file not to be stored, got %d", len(storage.smtpTLSReports))
+	}
+}
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policyStrings []string
+		want          *MTASTSPolicy
+	}{
+		{
+			name:          "One line per string",
+			policyStrings: []string{"version: STSv1", "mode: enforce", "mx: mx1.example.com", "mx: *.MX.example.com", "max_age: 604800"},
+			want:          &MTASTSPolicy{Version: "STSv1", Mode: "enforce", MX: []string{"mx1.example.com", "*.mx.example.com"}, MaxAge: 604800},
+		},
+		{
+			name:          "Whole policy in one string",
+			policyStrings: []string{"version: STSv1\r\nmode: testing\r\nmx: mx.example.com\r\nmax_age: 86400\r\n"},
+			want:          &MTASTSPolicy{Version: "STSv1", Mode: "testing", MX: []string{"mx.example.com"}, MaxAge: 86400},
+		},
+		{
+			name:          "Invalid max_age",
+			policyStrings: []string{"version: STSv1", "mode: none", "max_age: forever"},
+			want:          &MTASTSPolicy{Version: "STSv1", Mode: "none"},
+		},
+		{
+			name:          "No MTA-STS fields",
+			policyStrings: []string{"3 1 1 0c72ac70b745ac19998811b131d662c9ac69dbdbe7cb23e5b514b56664c5d3d6"},
+			want:          nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseMTASTSPolicy(tt.policyStrings)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMTASTSPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParser_SMTPTLSReportMTASTSPolicy(t *testing.T) {
+	data, err := os.ReadFile("../../samples/smtp_tls/rfc8460.json")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	parser := createTestParser(t)
+	report, err := parser.ParseSMTPTLSFromBytes(data)
+	if err != nil {
+		t.Fatalf("ParseSMTPTLSFromBytes() error = %v", err)
+	}
+
+	policy := report.Policies[0]
+	if len(policy.PolicyStrings) != 4 {
+		t.Errorf("Expected raw policy strings to be kept, got %v", policy.PolicyStrings)
+	}
+	if policy.MTASTSPolicy == nil {
+		t.Fatal("Expected structured MTA-STS policy")
+	}
+	if policy.MTASTSPolicy.Mode != "testing" {
+		t.Errorf("Expected mode testing, got %q", policy.MTASTSPolicy.Mode)
+	}
+	if !reflect.DeepEqual(policy.MTASTSPolicy.MX, []string{"*.mail.company-y.example"}) {
+		t.Errorf("Expected mx *.mail.company-y.example, got %v", policy.MTASTSPolicy.MX)
+	}
+	if policy.MTASTSPolicy.MaxAge != 86400 {
+		t.Errorf("Expected max_age 86400, got %d", policy.MTASTSPolicy.MaxAge)
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		hint string
+		want []string
+	}{
+		{"", []string{ReportTypeAggregate, ReportTypeForensic, ReportTypeSMTPTLS}},
+		{ReportTypeAggregate, []string{ReportTypeAggregate, ReportTypeForensic, ReportTypeSMTPTLS}},
+		{ReportTypeForensic, []string{ReportTypeForensic, ReportTypeAggregate, ReportTypeSMTPTLS}},
+		{ReportTypeSMTPTLS, []string{ReportTypeSMTPTLS, ReportTypeAggregate, ReportTypeForensic}},
+		{"unknown", []string{ReportTypeAggregate, ReportTypeForensic, ReportTypeSMTPTLS}},
+	}
+
+	for _, tt := range tests {
+		if got := parseOrder(tt.hint); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseOrder(%q) = %v, want %v", tt.hint, got, tt.want)
+		}
+	}
+}
+
+// fingerprintStorage is a mockStorage that implements ReportStore and
+// FingerprintStore over the reports it has stored
+type fingerprintStorage struct {
+	mockStorage
+	deleted []string // org_name/report_id of each DeleteReport call
+}
+
+func (s *fingerprintStorage) ReportExists(reportType, orgName, reportID string) (bool, error) {
+	for _, report := range s.aggregateReports {
+		if report.ReportMetadata.OrgName == orgName && report.ReportMetadata.ReportID == reportID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fingerprintStorage) DeleteReport(reportType, orgName, reportID string) error {
+	s.deleted = append(s.deleted, orgName+"/"+reportID)
+	return nil
+}
+
+func (s *fingerprintStorage) FingerprintExists(reportType, fingerprint string) (bool, error) {
+	for _, report := range s.aggregateReports {
+		if report.Fingerprint == fingerprint {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestParser_StoresParseMetadata(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/addisonfoods.com!example.com!1536105600!1536191999.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	tlsData, err := os.ReadFile("../../samples/smtp_tls/mail.ru.json")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+	parser.config.StoreParseMetadata = true
+	parser.SetVersion("1.2.3")
+
+	if err := parser.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+	if err := parser.ParseData(tlsData); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+	if len(storage.aggregateReports) != 1 || len(storage.smtpTLSReports) != 1 {
+		t.Fatalf("Expected one aggregate and one SMTP TLS report, got %d and %d",
+			len(storage.aggregateReports), len(storage.smtpTLSReports))
+	}
+	for _, info := range []ParseInfo{storage.aggregateReports[0].ParseInfo, storage.smtpTLSReports[0].ParseInfo} {
+		if info.ParserVersion != "1.2.3" || info.ParseDurationMS < 0 {
+			t.Errorf("Unexpected parse metadata: %+v", info)
+		}
+	}
+
+	// Without the option nothing is recorded
+	parser.config.StoreParseMetadata = false
+	if err := parser.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+	if info := storage.aggregateReports[1].ParseInfo; info != (ParseInfo{}) {
+		t.Errorf("Expected no parse metadata, got %+v", info)
+	}
+}
+
+func TestParser_StoresParseMetadataForFiles(t *testing.T) {
+	xmlData, err := os.ReadFile("../../samples/aggregate/addisonfoods.com!example.com!1536105600!1536191999.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	gzipPath := filepath.Join(t.TempDir(), "report.xml.gz")
+	if err := os.WriteFile(gzipPath, gzipBytes(t, xmlData), 0o644); err != nil {
+		t.Fatalf("Failed to write gzipped report: %v", err)
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+	parser.config.StoreParseMetadata = true
+	parser.config.StoreSourceTransport = true
+	parser.SetVersion("1.2.3")
+
+	for _, path := range []string{
+		"../../samples/aggregate/addisonfoods.com!example.com!1536105600!1536191999.xml",
+		gzipPath,
+		"../../samples/forensic/dmarc_ruf_report_linkedin.crlf.eml",
+		"../../samples/smtp_tls/mail.ru.json",
+	} {
+		if err := parser.ParseFile(path); err != nil {
+			t.Fatalf("ParseFile(%q) error = %v", path, err)
+		}
+	}
+	if len(storage.aggregateReports) != 2 || len(storage.forensicReports) != 1 || len(storage.smtpTLSReports) != 1 {
+		t.Fatalf("Expected two aggregate, one forensic and one SMTP TLS report, got %d, %d and %d",
+			len(storage.aggregateReports), len(storage.forensicReports), len(storage.smtpTLSReports))
+	}
+
+	for _, info := range []ParseInfo{
+		storage.aggregateReports[0].ParseInfo,
+		storage.aggregateReports[1].ParseInfo,
+		storage.forensicReports[0].ParseInfo,
+		storage.smtpTLSReports[0].ParseInfo,
+	} {
+		if info.ParserVersion != "1.2.3" || info.ParseDurationMS < 0 || info.SourceTransport != SourceFile {
+			t.Errorf("Unexpected parse metadata: %+v", info)
+		}
+	}
+}
+
+func TestParser_StoresSourceTransport(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/addisonfoods.com!example.com!1536105600!1536191999.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	tlsPath := "../../samples/smtp_tls/mail.ru.json"
+	tlsData, err := os.ReadFile(tlsPath)
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+	parser.config.StoreSourceTransport = true
+
+	if err := parser.ParseData(data); err != nil {
+		t.Fatalf("ParseData() error = %v", err)
+	}
+	if err := parser.ParseDataFromSource(data, SourceIMAP, ReportTypeAggregate); err != nil {
+		t.Fatalf("ParseDataFromSource() error = %v", err)
+	}
+	if err := parser.ParseDataFromSource(tlsData, SourceKafka, ""); err != nil {
+		t.Fatalf("ParseDataFromSource() error = %v", err)
+	}
+	if err := parser.ParseFile(tlsPath); err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	if len(storage.aggregateReports) != 2 || len(storage.smtpTLSReports) != 2 {
+		t.Fatalf("Expected two aggregate and two SMTP TLS reports, got %d and %d",
+			len(storage.aggregateReports), len(storage.smtpTLSReports))
+	}
+
+	for i, want := range []string{SourceHTTP, SourceIMAP} {
+		if got := storage.aggregateReports[i].SourceTransport; got != want {
+			t.Errorf("Aggregate report %d: expected source transport %q, got %q", i, want, got)
+		}
+	}
+	for i, want := range []string{SourceKafka, SourceFile} {
+		if got := storage.smtpTLSReports[i].SourceTransport; got != want {
+			t.Errorf("SMTP TLS report %d: expected source transport %q, got %q", i, want, got)
+		}
+	}
+	// Only the source transport is recorded without store_parse_metadata
+	if info := storage.aggregateReports[0].ParseInfo; info != (ParseInfo{SourceTransport: SourceHTTP}) {
+		t.Errorf("Unexpected parse metadata: %+v", info)
+	}
+
+	// Without the option nothing is recorded
+	parser.config.StoreSourceTransport = false
+	if err := parser.ParseDataFromSource(data, SourceIMAP, ""); err != nil {
+		t.Fatalf("ParseDataFromSource() error = %v", err)
+	}
+	if got := storage.aggregateReports[2].SourceTransport; got != "" {
+		t.Errorf("Expected no source transport, got %q", got)
+	}
+}
+
+func TestParser_AggregateReportFingerprint(t *testing.T) {
+	aggregateReport := func(metadata ReportMetadata) []byte {
+		return aggregateReportXML(metadata, PolicyPublished{Domain: "example.com", P: "none"},
+			testAggregateRecord("192.0.2.1", 1, "example.com"))
+	}
+	google := testReportMetadata("google.com", "dmarc@google.com", "12345")
+	yahoo := testReportMetadata("yahoo.com", "dmarc@yahoo.com", "12345")
+
+	storage := &fingerprintStorage{}
+	parser := createTestParser(t)
+	parser.config.Fingerprint = true
+	parser.storage = storage
+
+	if err := parser.ParseDataWithMode(aggregateReport(google), WriteModeCreate); err != nil {
+		t.Fatalf("ParseDataWithMode() error = %v", err)
+	}
+	// Same report ID from another organization is not a duplicate
+	if err := parser.ParseDataWithMode(aggregateReport(yahoo), WriteModeCreate); err != nil {
+		t.Fatalf("ParseDataWithMode() error = %v", err)
+	}
+	if len(storage.aggregateReports) != 2 {
+		t.Fatalf("Expected 2 stored reports, got %d", len(storage.aggregateReports))
+	}
+
+	first, second := storage.aggregateReports[0].Fingerprint, storage.aggregateReports[1].Fingerprint
+	if first == "" || second == "" {
+		t.Fatalf("Expected fingerprints to be set, got %q and %q", first, second)
+	}
+	if first == second {
+		t.Errorf("Expected distinct fingerprints for different orgs, both are %q", first)
+	}
+
+	err := parser.ParseDataWithMode(aggregateReport(google), WriteModeCreate)
+	if !errors.Is(err, ErrDuplicateReport) {
+		t.Errorf("Expected ErrDuplicateReport for a re-ingested report, got %v", err)
+	}
+
+	// Replacing deletes the stored copy only when its fingerprint matches:
+	// the same report ID for another period is a different report
+	nextPeriod := google
+	nextPeriod.BeginDate = time.Unix(1703980800, 0)
+	if err := parser.ParseDataWithMode(aggregateReport(nextPeriod), WriteModeReplace); err != nil {
+		t.Fatalf("ParseDataWithMode() error = %v", err)
+	}
+	if len(storage.deleted) != 0 {
+		t.Errorf("Expected no report to be deleted for another fingerprint, got %v", storage.deleted)
+	}
+	if err := parser.ParseDataWithMode(aggregateReport(google), WriteModeReplace); err != nil {
+		t.Fatalf("ParseDataWithMode() error = %v", err)
+	}
+	if !reflect.DeepEqual(storage.deleted, []string{"google.com/12345"}) {
+		t.Errorf("Expected the stored copy to be deleted, got %v", storage.deleted)
+	}
+
+	// Without the option no fingerprint is computed
+	parser.config.Fingerprint = false
+	report, err := parser.ParseAggregateFromBytes(aggregateReport(google))
+	if err != nil {
+		t.Fatalf("ParseAggregateFromBytes() error = %v", err)
+	}
+	if report.Fingerprint != "" {
+		t.Errorf("Expected no fingerprint when disabled, got %q", report.Fingerprint)
+	}
+}
+
+func TestParser_TrustedOrgs(t *testing.T) {
+	aggregateReport := func(email string) []byte {
+		return aggregateReportXML(testReportMetadata("Reporter", email, "12345"),
+			PolicyPublished{Domain: "example.com", P: "none"},
+			testAggregateRecord("192.0.2.1", 1, "example.com"))
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.config.TrustedOrgs = []string{"google.com", "Yahoo.com"}
+	parser.storage = storage
+
+	for _, email := range []string{"noreply-dmarc-support@google.com", "dmarc@mail.yahoo.com"} {
+		if err := parser.ParseData(aggregateReport(email)); err != nil {
+			t.Errorf("ParseData() for %s error = %v", email, err)
+		}
+	}
+	if len(storage.aggregateReports) != 2 {
+		t.Fatalf("Expected 2 stored reports, got %d", len(storage.aggregateReports))
+	}
+
+	for _, email := range []string{"dmarc@evil.example", "dmarc@notgoogle.com", ""} {
+		err := parser.ParseData(aggregateReport(email))
+		if !errors.Is(err, ErrUntrustedOrg) {
+			t.Errorf("ParseData() for %q error = %v, want ErrUntrustedOrg", email, err)
+		}
+	}
+	if len(storage.aggregateReports) != 2 {
+		t.Errorf("Expected untrusted reports not to be stored, got %d stored reports", len(storage.aggregateReports))
+	}
+}
+
+func TestParser_TrustedOrgsFiles(t *testing.T) {
+	data := aggregateReportXML(testReportMetadata("Reporter", "dmarc@evil.example", "12345"),
+		PolicyPublished{Domain: "example.com", P: "none"},
+		testAggregateRecord("192.0.2.1", 1, "example.com"))
+
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "report.xml")
+	if err := os.WriteFile(plain, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gzipped := filepath.Join(dir, "report.xml.gz")
+	if err := os.WriteFile(gzipped, gzipBytes(t, data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.config.TrustedOrgs = []string{"google.com"}
+	parser.storage = storage
+
+	for _, path := range []string{plain, gzipped} {
+		if err := parser.ParseFile(path); !errors.Is(err, ErrUntrustedOrg) {
+			t.Errorf("ParseFile(%s) error = %v, want ErrUntrustedOrg", filepath.Base(path), err)
+		}
+	}
+	if len(storage.aggregateReports) != 0 {
+		t.Errorf("Expected untrusted reports not to be stored, got %d stored reports", len(storage.aggregateReports))
+	}
+}
+
+func TestParser_ParseGzippedAggregateFileStreams(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/!large-example.com!1711897200!1711983600.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	want, err := createTestParser(t).parseAggregateXML(data)
+	if err != nil {
+		t.Fatalf("parseAggregateXML() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "large.xml.gz")
+	if err := os.WriteFile(path, gzipBytes(t, data), 0644); err != nil {
+		t.Fatalf("Failed to write gzipped sample: %v", err)
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+
+	parsed, err := parser.parseGzipAggregateFile(path, time.Now())
+	if !parsed || err != nil {
+		t.Fatalf("parseGzipAggregateFile() = %v, %v, want streamed report", parsed, err)
+	}
+	if len(storage.aggregateReports) != 1 {
+		t.Fatalf("Expected 1 stored report, got %d", len(storage.aggregateReports))
+	}
+
+	got := storage.aggregateReports[0]
+	if got.ReportMetadata.ReportID != want.ReportMetadata.ReportID || len(got.Records) != len(want.Records) {
+		t.Errorf("Streamed report %s with %d records, want %s with %d records",
+			got.ReportMetadata.ReportID, len(got.Records), want.ReportMetadata.ReportID, len(want.Records))
+	}
+}
+
+func TestParser_ParseGzippedSMTPTLSFileFallsBack(t *testing.T) {
+	data, err := os.ReadFile("../../samples/smtp_tls/rfc8460.json")
+	if err != nil {
+		t.Fatalf("Failed to read sample file: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json.gz")
+	if err := os.WriteFile(path, gzipBytes(t, data), 0644); err != nil {
+		t.Fatalf("Failed to write gzipped sample: %v", err)
+	}
+
+	storage := &mockStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+
+	if parsed, _ := parser.parseGzipAggregateFile(path, time.Now()); parsed {
+		t.Fatal("Expected an SMTP TLS report not to be streamed as aggregate report")
+	}
+	if err := parser.ParseFile(path); err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	if len(storage.smtpTLSReports) != 1 {
+		t.Errorf("Expected 1 stored SMTP TLS report, got %d", len(storage.smtpTLSReports))
+	}
+}
+
+func gzipBytes(t testing.TB, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatalf("Failed to gzip data: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to gzip data: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkParser_ParseGzippedLargeAggregateReport compares buffering the
+// decompressed report with streaming it into the XML decoder
+func BenchmarkParser_ParseGzippedLargeAggregateReport(b *testing.B) {
+	data, err := os.ReadFile("../../samples/aggregate/!large-example.com!1711897200!1711983600.xml")
+	if err != nil {
+		b.Skipf("Large sample file not found: %v", err)
+	}
+	compressed := gzipBytes(b, data)
+
+	parser := &Parser{
+		config: config.ParserConfig{Offline: true},
+		logger: zap.NewNop(),
+	}
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			extracted, err := parser.extractFromGzipData(compressed)
+			if err != nil {
+				b.Fatalf("Extract error: %v", err)
+			}
+			if _, err := parser.parseAggregateXML(extracted); err != nil {
+				b.Fatalf("Parse error: %v", err)
+			}
+		}
+	})
+
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			gzReader, err := newGzipReportReader(bytes.NewReader(compressed))
+			if err != nil {
+				b.Fatalf("Extract error: %v", err)
+			}
+			if _, err := parser.parseAggregateXMLReader(gzReader); err != nil {
+				b.Fatalf("Parse error: %v", err)
+			}
+			gzReader.Close()
+		}
+	})
+}
+
+func TestParser_PolicyDrift(t *testing.T) {
+	origGeo, origDNS, origDMARC := getGeoLocation, getReverseDNS, getDMARCRecord
+	defer func() { getGeoLocation, getReverseDNS, getDMARCRecord = origGeo, origDNS, origDMARC }()
+
+	getGeoLocation = func(ipAddress, dbPath string) (*utils.GeoLocation, error) {
+		return nil, fmt.Errorf("no GeoIP database")
+	}
+	getReverseDNS = func(ipAddress string, nameservers []string, timeoutSec int) (string, error) {
+		return "", fmt.Errorf("no PTR records found")
+	}
+	var lookups []string
+	getDMARCRecord = func(domain string, nameservers []string, timeoutSec int) (string, error) {
+		lookups = append(lookups, domain)
+		return "v=DMARC1; p=reject; rua=mailto:dmarc@example.com", nil
+	}
+
+	aggregateReport := func(domain string) []byte {
+		return aggregateReportXML(testReportMetadata("google.com", "noreply-dmarc-support@google.com", "12345"),
+			PolicyPublished{Domain: domain, ADKIM: "r", P: "none"},
+			testAggregateRecord("192.0.2.1", 1, domain))
+	}
+
+	parserMetrics := metrics.NewParserMetrics(prometheus.NewRegistry())
+	parser := createTestParser(t)
+	parser.metrics = parserMetrics
+	parser.config = config.ParserConfig{
+		Nameservers:      []string{"192.0.2.53"},
+		MonitoredDomains: []string{"Example.com"},
+		PolicyDrift:      true,
+	}
+
+	if _, err := parser.ParseAggregateFromBytes(aggregateReport("example.com")); err != nil {
+		t.Fatalf("ParseAggregateFromBytes() error = %v", err)
+	}
+	if _, err := parser.ParseAggregateFromBytes(aggregateReport("other.example")); err != nil {
+		t.Fatalf("ParseAggregateFromBytes() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(lookups, []string{"example.com"}) {
+		t.Errorf("Expected a DMARC lookup of the monitored domain only, got %v", lookups)
+	}
+
+	for tag, want := range map[string]float64{"p": 1, "sp": 1, "adkim": 0, "pct": 0} {
+		if got := testutil.ToFloat64(parserMetrics.PolicyDriftTotal.WithLabelValues("example.com", tag)); got != want {
+			t.Errorf("Expected %v drift of %s, got %v", want, tag, got)
+		}
+	}
+}
+
+func TestParser_DomainFailureMetrics(t *testing.T) {
+	aggregateReport := func(reportID, domain string) []byte {
+		failing := testAggregateRecord("192.0.2.2", 2, domain)
+		failing.PolicyEvaluated.DKIM, failing.PolicyEvaluated.SPF = "fail", "fail"
+		return aggregateReportXML(testReportMetadata("google.com", "noreply-dmarc-support@google.com", reportID),
+			PolicyPublished{Domain: domain, P: "none"},
+			testAggregateRecord("192.0.2.1", 3, domain), failing)
+	}
+
+	cfg := config.ParserConfig{
+		Offline:              true,
+		MonitoredDomains:     []string{"Example.com", "quiet.example"},
+		DomainFailureMetrics: true,
+	}
+	parser := New(cfg, &mockStorage{}, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	for i, domain := range []string{"example.com", "example.com", "other.example"} {
+		if err := parser.ParseData(aggregateReport(fmt.Sprintf("report-%d", i), domain)); err != nil {
+			t.Fatalf("ParseData() error = %v", err)
+		}
+	}
+
+	counter := parser.metrics.DomainFailingMessagesTotal
+	if got := testutil.ToFloat64(counter.WithLabelValues("example.com")); got != 4 {
+		t.Errorf("Expected 4 failing messages for example.com, got %v", got)
+	}
+	if got := testutil.ToFloat64(counter.WithLabelValues("quiet.example")); got != 0 {
+		t.Errorf("Expected 0 failing messages for quiet.example, got %v", got)
+	}
+	// Only monitored domains are exposed
+	if got := testutil.CollectAndCount(counter); got != 2 {
+		t.Errorf("Expected 2 domains in the counter, got %d", got)
+	}
+}
+
+// recordStore is a mockStorage that can store aggregate records in chunks
+type recordStore struct {
+	mockStorage
+	headers []*AggregateReport
+	offsets []int
+	records []Record
+}
+
+func (m *recordStore) StoreAggregateReportHeader(report *AggregateReport) error {
+	m.headers = append(m.headers, report)
+	return nil
+}
+
+func (m *recordStore) StoreAggregateRecords(report *AggregateReport, offset int, records []Record) error {
+	m.offsets = append(m.offsets, offset)
+	m.records = append(m.records, records...)
+	return nil
+}
+
+func TestParser_StreamAggregateXML(t *testing.T) {
+	parser := createTestParser(t)
+
+	for _, name := range []string{
+		"addisonfoods.com!example.com!1536105600!1536191999.xml",
+		"namespaced.example!example.com!1700000000!1700086399.xml",
+		"!large-example.com!1711897200!1711983600.xml",
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("../../samples/aggregate", name))
+			if err != nil {
+				t.Fatalf("Failed to read sample: %v", err)
+			}
+			want, err := parser.parseAggregateXML(data)
+			if err != nil {
+				t.Fatalf("parseAggregateXML() error = %v", err)
+			}
+
+			var offsets []int
+			var records []Record
+			header, err := parser.streamAggregateXML(bytes.NewReader(data), 2, func(report *AggregateReport, offset int, chunk []Record) error {
+				if len(chunk) > 2 {
+					t.Errorf("Expected chunks of at most 2 records, got %d", len(chunk))
+				}
+				offsets = append(offsets, offset)
+				records = append(records, chunk...)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("streamAggregateXML() error = %v", err)
+			}
+
+			for i, offset := range offsets {
+				if offset != 2*i {
+					t.Errorf("Expected chunk %d at offset %d, got %d", i, 2*i, offset)
+				}
+			}
+			if !reflect.DeepEqual(records, want.Records) {
+				t.Errorf("Streamed records differ from buffered records")
+			}
+			want.Records = nil
+			if !reflect.DeepEqual(header, want) {
+				t.Errorf("Streamed header = %+v, want %+v", header, want)
+			}
+		})
+	}
+
+	t.Run("empty report", func(t *testing.T) {
+		data := []byte(`<feedback>
+  <report_metadata>
+    <org_name>example.net</org_name>
+    <report_id>empty</report_id>
+    <date_range><begin>1704067200</begin><end>1704153599</end></date_range>
+  </report_metadata>
+  <policy_published><domain>example.com</domain><p>none</p></policy_published>
+</feedback>`)
+		calls := 0
+		if _, err := parser.streamAggregateXML(bytes.NewReader(data), 2, func(report *AggregateReport, offset int, chunk []Record) error {
+			calls++
+			if offset != 0 || len(chunk) != 0 || report.ReportMetadata.ReportID != "empty" {
+				t.Errorf("Unexpected chunk of %s at %d with %d records", report.ReportMetadata.ReportID, offset, len(chunk))
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("streamAggregateXML() error = %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("Expected one call for an empty report, got %d", calls)
+		}
+	})
+}
+
+func TestRecordAlignment(t *testing.T) {
+	dkim := func(domain, result string) []DKIMResult {
+		return []DKIMResult{{Domain: domain, Selector: "s1", Result: result}}
+	}
+	spf := func(domain, scope, result string) []SPFResult {
+		return []SPFResult{{Domain: domain, Scope: scope, Result: result}}
+	}
+
+	tests := []struct {
+		name        string
+		adkim, aspf string
+		evaluated   PolicyEvaluated
+		authResults AuthResults
+		want        Alignment
+	}{
+		{
+			name:        "strict DKIM rejects a subdomain the reporter passed",
+			adkim:       "s",
+			evaluated:   PolicyEvaluated{DKIM: "pass", SPF: "fail"},
+			authResults: AuthResults{DKIM: dkim("mail.example.com", "pass")},
+			want:        Alignment{},
+		},
+		{
+			name:        "relaxed DKIM accepts a subdomain",
+			adkim:       "r",
+			evaluated:   PolicyEvaluated{DKIM: "pass", SPF: "fail"},
+			authResults: AuthResults{DKIM: dkim("mail.example.com", "pass")},
+			want:        Alignment{DKIM: true, DMARC: true},
+		},
+		{
+			name:        "strict SPF rejects a subdomain",
+			aspf:        "s",
+			evaluated:   PolicyEvaluated{DKIM: "fail", SPF: "pass"},
+			authResults: AuthResults{SPF: spf("bounce.example.com", "mfrom", "pass")},
+			want:        Alignment{},
+		},
+		{
+			name:        "strict SPF accepts the same domain",
+			aspf:        "s",
+			evaluated:   PolicyEvaluated{DKIM: "fail", SPF: "fail"},
+			authResults: AuthResults{SPF: spf("Example.com.", "mfrom", "pass")},
+			want:        Alignment{SPF: true, DMARC: true},
+		},
+		{
+			name:        "passing SPF for an unrelated domain is not aligned",
+			evaluated:   PolicyEvaluated{DKIM: "fail", SPF: "pass"},
+			authResults: AuthResults{SPF: spf("esp.example.net", "mfrom", "pass")},
+			want:        Alignment{},
+		},
+		{
+			name:        "SPF HELO results do not count",
+			evaluated:   PolicyEvaluated{DKIM: "fail", SPF: "pass"},
+			authResults: AuthResults{SPF: spf("example.com", "helo", "pass")},
+			want:        Alignment{},
+		},
+		{
+			name:      "failing signatures are not aligned",
+			evaluated: PolicyEvaluated{DKIM: "pass", SPF: "fail"},
+			authResults: AuthResults{DKIM: []DKIMResult{
+				{Domain: "example.com", Selector: "s1", Result: "fail"},
+				{Domain: "esp.example.net", Selector: "s2", Result: "pass"},
+			}},
+			want: Alignment{},
+		},
+		{
+			name:        "relaxed alignment uses the public suffix list",
+			evaluated:   PolicyEvaluated{DKIM: "pass", SPF: "fail"},
+			authResults: AuthResults{DKIM: dkim("other.co.uk", "pass")},
+			want:        Alignment{},
+		},
+		{
+			name:      "auth results override a failing evaluation",
+			evaluated: PolicyEvaluated{DKIM: "fail", SPF: "fail"},
+			authResults: AuthResults{
+				DKIM: dkim("example.com", "pass"),
+				SPF:  spf("mail.example.com", "mfrom", "pass"),
+			},
+			want: Alignment{SPF: true, DKIM: true, DMARC: true},
+		},
+		{
+			name:      "without auth results the evaluation is used",
+			evaluated: PolicyEvaluated{DKIM: "fail", SPF: "pass"},
+			want:      Alignment{SPF: true, DMARC: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := Record{
+				PolicyEvaluated: tt.evaluated,
+				Identifiers:     Identifiers{HeaderFrom: "example.com"},
+				AuthResults:     tt.authResults,
+			}
+			policy := PolicyPublished{
+				Domain: "example.com",
+				ADKIM:  utils.DefaultString(tt.adkim, "r"),
+				ASPF:   utils.DefaultString(tt.aspf, "r"),
+			}
+			if got := recordAlignment(record, policy); got != tt.want {
+				t.Errorf("recordAlignment() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParser_AggregateAlignmentUsesPublishedModes(t *testing.T) {
+	parser := createTestParser(t)
+
+	report := func(adkim string) []byte {
+		record := testAggregateRecord("192.0.2.1", 4, "example.com")
+		record.AuthResults = AuthResults{
+			DKIM: []DKIMResult{{Domain: "news.example.com", Selector: "s1", Result: "pass"}},
+			SPF:  []SPFResult{{Domain: "bounces.esp.example.net", Scope: "mfrom", Result: "pass"}},
+		}
+		return aggregateReportXML(testReportMetadata("example.net", "dmarc@example.net", "alignment-"+adkim),
+			PolicyPublished{Domain: "example.com", ADKIM: adkim, P: "reject"}, record)
+	}
+
+	for _, tt := range []struct {
+		adkim string
+		want  Alignment
+	}{
+		{"r", Alignment{DKIM: true, DMARC: true}},
+		{"s", Alignment{}},
+	} {
+		parsed, err := parser.parseAggregateXML(report(tt.adkim))
+		if err != nil {
+			t.Fatalf("parseAggregateXML() error = %v", err)
+		}
+		if got := parsed.Records[0].Alignment; got != tt.want {
+			t.Errorf("adkim=%s: alignment = %+v, want %+v", tt.adkim, got, tt.want)
+		}
+	}
+}
+
+func TestParser_AggregateEffectivePolicy(t *testing.T) {
+	report := func(pct, headerFrom, reason string) []byte {
+		record := testAggregateRecord("192.0.2.1", 2, headerFrom)
+		record.PolicyEvaluated = PolicyEvaluated{Disposition: "quarantine", DKIM: "fail", SPF: "fail"}
+		if reason != "" {
+			comment := "pct"
+			record.PolicyEvaluated.PolicyOverrideReasons = []PolicyOverrideReason{{Type: &reason, Comment: &comment}}
+		}
+		return aggregateReportXML(testReportMetadata("example.net", "dmarc@example.net", "pct-"+pct),
+			PolicyPublished{Domain: "example.com", P: "reject", SP: "quarantine", PCT: pct}, record)
+	}
+
+	tests := []struct {
+		name           string
+		pct            string
+		headerFrom     string
+		reason         string
+		wantPolicy     string
+		wantSampledOut bool
+		wantWarnings   int
+	}{
+		{"sampled out at pct 50", "50", "example.com", "sampled_out", "quarantine", true, 0},
+		{"sampled out subdomain", "50", "news.example.com", "sampled_out", "none", true, 0},
+		{"not sampled out", "50", "example.com", "", "reject", false, 0},
+		{"other override reason", "50", "example.com", "forwarded", "reject", false, 0},
+		{"sampled out at pct 100", "100", "example.com", "sampled_out", "reject", false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			parser := createTestParser(t)
+			parser.logger = zap.New(core)
+
+			parsed, err := parser.parseAggregateXML(report(tt.pct, tt.headerFrom, tt.reason))
+			if err != nil {
+				t.Fatalf("parseAggregateXML() error = %v", err)
+			}
+			record := parsed.Records[0]
+			if record.EffectivePolicy != tt.wantPolicy || record.SampledOut != tt.wantSampledOut {
+				t.Errorf("effective policy = %q, sampled out = %v, want %q, %v",
+					record.EffectivePolicy, record.SampledOut, tt.wantPolicy, tt.wantSampledOut)
+			}
+			if got := logs.FilterMessage("Aggregate record is sampled out although pct is 100").Len(); got != tt.wantWarnings {
+				t.Errorf("Expected %d warnings, got %d", tt.wantWarnings, got)
+			}
+		})
+	}
+}
+
+func TestParser_RejectsReportsOverMaxRecords(t *testing.T) {
+	parser := createTestParser(t)
+	parser.config.MaxRecords = 2
+
+	var records strings.Builder
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&records, `
+  <record>
+    <row>
+      <source_ip>192.0.2.%d</source_ip>
+      <count>1</count>
+      <policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>pass</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+  </record>`, i+1)
+	}
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feedback>
+  <report_metadata>
+    <org_name>example.net</org_name>
+    <email>dmarc@example.net</email>
+    <report_id>too-many-records</report_id>
+    <date_range><begin>1704067200</begin><end>1704153599</end></date_range>
+  </report_metadata>
+  <policy_published><domain>example.com</domain><p>none</p></policy_published>` + records.String() + `
+</feedback>`)
+
+	if _, err := parser.parseAggregateXML(data); err == nil || !strings.Contains(err.Error(), "more than 2 records") {
+		t.Errorf("parseAggregateXML() error = %v, want a max_records error", err)
+	}
+	if _, err := parser.parseAggregateXMLReader(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "more than 2 records") {
+		t.Errorf("parseAggregateXMLReader() error = %v, want a max_records error", err)
+	}
+	_, err := parser.streamAggregateXML(bytes.NewReader(data), 1, func(report *AggregateReport, offset int, chunk []Record) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "more than 2 records") {
+		t.Errorf("streamAggregateXML() error = %v, want a max_records error", err)
+	}
+
+	parser.config.MaxRecords = 3
+	report, err := parser.parseAggregateXML(data)
+	if err != nil {
+		t.Fatalf("parseAggregateXML() error = %v", err)
+	}
+	if len(report.Records) != 3 {
+		t.Errorf("Expected 3 records, got %d", len(report.Records))
+	}
+}
+
+func TestParser_RejectsXMLDoctype(t *testing.T) {
+	parser := createTestParser(t)
+
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE feedback [
+  <!ENTITY lol "lol">
+  <!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+  <!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+]>
+<feedback>
+  <report_metadata>
+    <org_name>&lol2;</org_name>
+    <report_id>doctype</report_id>
+    <date_range><begin>1704067200</begin><end>1704153599</end></date_range>
+  </report_metadata>
+  <policy_published><domain>example.com</domain><p>none</p></policy_published>
+</feedback>`)
+
+	if _, err := parser.parseAggregateXML(data); !errors.Is(err, ErrUnsafeXML) {
+		t.Errorf("parseAggregateXML() error = %v, want %v", err, ErrUnsafeXML)
+	}
+	if _, err := parser.parseAggregateXMLReader(bytes.NewReader(data)); !errors.Is(err, ErrUnsafeXML) {
+		t.Errorf("parseAggregateXMLReader() error = %v, want %v", err, ErrUnsafeXML)
+	}
+	_, err := parser.streamAggregateXML(bytes.NewReader(data), 1, func(report *AggregateReport, offset int, chunk []Record) error {
+		return nil
+	})
+	if !errors.Is(err, ErrUnsafeXML) {
+		t.Errorf("streamAggregateXML() error = %v, want %v", err, ErrUnsafeXML)
+	}
+}
+
+func TestParser_RejectsXXE(t *testing.T) {
+	parser := createTestParser(t)
+
+	xxe := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE feedback [
+  <!ENTITY xxe SYSTEM "file:///etc/passwd">
+]>
+<feedback>
+  <report_metadata>
+    <org_name>&xxe;</org_name>
+    <email>dmarc@example.net</email>
+    <report_id>xxe</report_id>
+    <date_range><begin>1704067200</begin><end>1704153599</end></date_range>
+  </report_metadata>
+  <policy_published><domain>example.com</domain><p>none</p></policy_published>
+</feedback>`)
+
+	if err := parser.ParseData(xxe); err == nil || !strings.Contains(err.Error(), ErrUnsafeXML.Error()) {
+		t.Errorf("ParseData() error = %v, want %v", err, ErrUnsafeXML)
+	}
+	_, err := parser.parseAggregateXML(xxe)
+	if !errors.Is(err, ErrUnsafeXML) {
+		t.Errorf("parseAggregateXML() error = %v, want %v", err, ErrUnsafeXML)
+	}
+	if reason := parseFailureReason(xxe, err, nil, nil); reason != "xml_unsafe" {
+		t.Errorf("parseFailureReason() = %q, want xml_unsafe", reason)
+	}
+
+	// A lowercase declaration is rejected as well
+	lower := bytes.Replace(xxe, []byte("<!DOCTYPE"), []byte("<!doctype"), 1)
+	if _, err := parser.parseAggregateXML(lower); !errors.Is(err, ErrUnsafeXML) {
+		t.Errorf("parseAggregateXML() error = %v, want %v", err, ErrUnsafeXML)
+	}
+
+	// Reports without declarations still parse
+	normal, err := os.ReadFile("../../samples/aggregate/addisonfoods.com!example.com!1536105600!1536191999.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	if err := CheckXMLSafety(normal); err != nil {
+		t.Errorf("CheckXMLSafety() error = %v for a normal report", err)
+	}
+	if _, err := parser.parseAggregateXML(normal); err != nil {
+		t.Errorf("parseAggregateXML() error = %v for a normal report", err)
+	}
+}
+
+func TestParser_ParseFileStreamsLargeReports(t *testing.T) {
+	for _, name := range []string{
+		"!large-example.com!1711897200!1711983600.xml",
+		"fastmail.com!example.com!1516060800!1516147199!102675056.xml.gz",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join("../../samples/aggregate", name)
+
+			buffered := &mockStorage{}
+			parser := createTestParser(t)
+			parser.storage = buffered
+			if err := parser.ParseFile(path); err != nil {
+				t.Fatalf("ParseFile() error = %v", err)
+			}
+
+			store := &recordStore{}
+			parser.storage = store
+			parser.config.StreamThreshold = 1
+			if err := parser.ParseFile(path); err != nil {
+				t.Fatalf("ParseFile() error = %v", err)
+			}
+
+			if len(store.aggregateReports) != 0 || len(store.headers) != 1 {
+				t.Fatalf("Expected the report to be streamed, got %d reports and %d headers",
+					len(store.aggregateReports), len(store.headers))
+			}
+			if got, want := store.headers[0].ReportMetadata.ReportID, buffered.aggregateReports[0].ReportMetadata.ReportID; got != want {
+				t.Errorf("Expected report %s, got %s", want, got)
+			}
+			if !reflect.DeepEqual(store.records, buffered.aggregateReports[0].Records) {
+				t.Errorf("Expected %d streamed records, got %d",
+					len(buffered.aggregateReports[0].Records), len(store.records))
+			}
+		})
+	}
+
+	// Files below the threshold keep the buffered path
+	store := &recordStore{}
+	parser := createTestParser(t)
+	parser.storage = store
+	parser.config.StreamThreshold = 1 << 30
+	if err := parser.ParseFile("../../samples/aggregate/addisonfoods.com!example.com!1536105600!1536191999.xml"); err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	if len(store.aggregateReports) != 1 || len(store.headers) != 0 {
+		t.Errorf("Expected a buffered report, got %d reports and %d headers",
+			len(store.aggregateReports), len(store.headers))
+	}
+}
+
+// deletingRecordStore is a recordStore that records deleted reports
+type deletingRecordStore struct {
+	recordStore
+	exists  bool     // result of ReportExists
+	deleted []string // org_name/report_id of each DeleteReport call
+}
+
+func (m *deletingRecordStore) ReportExists(reportType, orgName, reportID string) (bool, error) {
+	return m.exists, nil
+}
+
+func (m *deletingRecordStore) DeleteReport(reportType, orgName, reportID string) error {
+	m.deleted = append(m.deleted, orgName+"/"+reportID)
+	return nil
+}
+
+func TestParser_ParseFileDeletesPartlyStreamedReports(t *testing.T) {
+	store := &deletingRecordStore{}
+	parser := createTestParser(t)
+	parser.storage = store
+	parser.config.StreamThreshold = 1
+	// The first chunk is stored before the limit is reached
+	parser.config.MaxRecords = streamChunkSize + 1
+
+	err := parser.ParseFile("../../samples/aggregate/!large-example.com!1711897200!1711983600.xml")
+	if err == nil || !strings.Contains(err.Error(), "max_records") {
+		t.Fatalf("Expected a max_records error, got %v", err)
+	}
+	if len(store.headers) != 1 || len(store.records) != streamChunkSize {
+		t.Fatalf("Expected a partly streamed report, got %d headers and %d records",
+			len(store.headers), len(store.records))
+	}
+	metadata := store.headers[0].ReportMetadata
+	if want := []string{metadata.OrgName + "/" + metadata.ReportID}; !reflect.DeepEqual(store.deleted, want) {
+		t.Errorf("Expected deleted reports %v, got %v", want, store.deleted)
+	}
+}
+
+func TestParser_ParseFileChecksStreamedReports(t *testing.T) {
+	const path = "../../samples/aggregate/!large-example.com!1711897200!1711983600.xml"
+
+	// A stored copy is neither overwritten nor deleted
+	store := &deletingRecordStore{exists: true}
+	parser := createTestParser(t)
+	parser.storage = store
+	parser.config.StreamThreshold = 1
+	parser.config.MaxRecords = streamChunkSize + 1
+
+	if err := parser.ParseFile(path); !errors.Is(err, ErrDuplicateReport) {
+		t.Fatalf("Expected ErrDuplicateReport, got %v", err)
+	}
+	if len(store.headers) != 0 || len(store.records) != 0 || len(store.deleted) != 0 {
+		t.Errorf("Expected the stored report to be kept, got %d headers, %d records and deleted reports %v",
+			len(store.headers), len(store.records), store.deleted)
+	}
+
+	// Reports of untrusted organizations are rejected
+	store = &deletingRecordStore{}
+	parser.storage = store
+	parser.config.TrustedOrgs = []string{"google.com"}
+
+	if err := parser.ParseFile(path); !errors.Is(err, ErrUntrustedOrg) {
+		t.Fatalf("Expected ErrUntrustedOrg, got %v", err)
+	}
+	if len(store.headers) != 0 || len(store.records) != 0 || len(store.deleted) != 0 {
+		t.Errorf("Expected nothing stored or deleted, got %d headers, %d records and deleted reports %v",
+			len(store.headers), len(store.records), store.deleted)
+	}
+}
+
+// concurrentStorage counts stored aggregate reports and the most concurrent
+// StoreAggregateReport calls
+type concurrentStorage struct {
+	mockStorage
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	aggregates  int
+}
+
+func (m *concurrentStorage) StoreAggregateReport(report *AggregateReport) error {
+	m.mu.Lock()
+	m.inFlight++
+	m.maxInFlight = max(m.maxInFlight, m.inFlight)
+	m.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	m.mu.Lock()
+	m.inFlight--
+	m.aggregates++
+	m.mu.Unlock()
+	return nil
+}
+
+func TestParser_ParseDirectoryConcurrency(t *testing.T) {
+	data, err := os.ReadFile("../../samples/aggregate/addisonfoods.com!example.com!1536105600!1536191999.xml")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	dir := t.TempDir()
+	for i := 0; i < 40; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("%d", i%4))
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("report-%d.xml", i)), data, 0o644); err != nil {
+			t.Fatalf("Failed to write report: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "invalid.xml"), []byte("not a report"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	storage := &concurrentStorage{}
+	parser := createTestParser(t)
+	parser.storage = storage
+	parser.config.Concurrency = 4
+
+	if err := parser.ParseFile(dir); err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+
+	if storage.aggregates != 40 {
+		t.Errorf("Expected 40 aggregate reports, got %d", storage.aggregates)
+	}
+	if storage.maxInFlight < 2 || storage.maxInFlight > 4 {
+		t.Errorf("Expected 2 to 4 files parsed at once, got %d", storage.maxInFlight)
+	}
+}
+
+func TestParser_ParseGzippedSMTPTLSReport(t *testing.T) {
+	data, err := os.ReadFile("../../samples/smtp_tls/rfc8460.json.gz")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, err := zw.Create("company-y.example!example.com!1455062400!1455148799.json.gz")
+	if err != nil {
+		t.Fatalf("Failed to create archive entry: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Failed to write archive entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	attachment := base64.StdEncoding.EncodeToString(data)
+	email := func(contentType string) []byte {
+		return []byte("From: tlsrpt@mail.company-y.example\r\n" +
+			"To: tlsrpt@example.com\r\n" +
+			"Subject: Report Domain: example.com Submitter: company-y.example\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: multipart/report; report-type=tlsrpt; boundary=\"b\"\r\n" +
+			"\r\n" +
+			"--b\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"\r\n" +
+			"This is an aggregate TLS report.\r\n" +
+			"--b\r\n" +
+			"Content-Type: " + contentType + "\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"Content-Disposition: attachment; filename=\"report.json.gz\"\r\n" +
+			"\r\n" +
+			attachment + "\r\n" +
+			"--b--\r\n")
+	}
+
+	tests := []struct {
+		name  string
+		parse func(parser *Parser, storage *mockStorage) (*SMTPTLSReport, error)
+	}{
+		{
+			name: "ParseSMTPTLSFromBytes",
+			parse: func(parser *Parser, storage *mockStorage) (*SMTPTLSReport, error) {
+				return parser.ParseSMTPTLSFromBytes(data)
+			},
+		},
+		{
+			name: "parseAsSMTPTLSReportWithMetrics",
+			parse: func(parser *Parser, storage *mockStorage) (*SMTPTLSReport, error) {
+				_, err := parser.parseAsSMTPTLSReportWithMetrics(data, "http", time.Now(), len(data), WriteModeAppend)
+				return lastSMTPTLSReport(storage), err
+			},
+		},
+		{
+			name: "ParseDataWithHint",
+			parse: func(parser *Parser, storage *mockStorage) (*SMTPTLSReport, error) {
+				err := parser.ParseDataWithHint(data, ReportTypeSMTPTLS)
+				return lastSMTPTLSReport(storage), err
+			},
+		},
+		{
+			name: "ParseFile",
+			parse: func(parser *Parser, storage *mockStorage) (*SMTPTLSReport, error) {
+				err := parser.ParseFile("../../samples/smtp_tls/rfc8460.json.gz")
+				return lastSMTPTLSReport(storage), err
+			},
+		},
+		{
+			name: "gzip in ZIP archive",
+			parse: func(parser *Parser, storage *mockStorage) (*SMTPTLSReport, error) {
+				err := parser.ParseData(archive.Bytes())
+				return lastSMTPTLSReport(storage), err
+			},
+		},
+		{
+			name: "tlsrpt+gzip attachment",
+			parse: func(parser *Parser, storage *mockStorage) (*SMTPTLSReport, error) {
+				err := parser.ParseData(email("application/tlsrpt+gzip"))
+				return lastSMTPTLSReport(storage), err
+			},
+		},
+		{
+			name: "octet-stream attachment",
+			parse: func(parser *Parser, storage *mockStorage) (*SMTPTLSReport, error) {
+				reports, err := parser.ParseEmail(email("application/octet-stream"))
+				if err != nil || len(reports.SMTPTLSReports) != 1 {
+					return nil, err
+				}
+				return reports.SMTPTLSReports[0], nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &mockStorage{}
+			parser := createTestParser(t)
+			parser.storage = storage
+
+			report, err := tt.parse(parser, storage)
+			if err != nil {
+				t.Fatalf("Parse error = %v", err)
+			}
+			if report == nil {
+				t.Fatal("Expected an SMTP TLS report")
+			}
+			if report.OrganizationName != "Company-X" || report.ReportID != "5065427c-23d3-47ca-b6e0-946ea0e8c4be" {
+				t.Errorf("Unexpected report %s from %s", report.ReportID, report.OrganizationName)
+			}
+		})
+	}
+}
+
+// lastSMTPTLSReport returns the last SMTP TLS report stored, if any
+func lastSMTPTLSReport(storage *mockStorage) *SMTPTLSReport {
+	if len(storage.smtpTLSReports) == 0 {
+		return nil
 	}
+	return storage.smtpTLSReports[len(storage.smtpTLSReports)-1]
 }
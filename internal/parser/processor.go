@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errReportVetoed marks reports skipped because a ReportProcessor vetoed them
+var errReportVetoed = errors.New("report vetoed by a report processor")
+
+// ReportProcessor post-processes parsed reports before they are stored, e.g.
+// to tag known forwarders or map sources to internal assets. Processors are
+// registered with New and run in order on every report that is about to be
+// stored. They may modify the report; returning false vetoes it, and it is
+// then neither stored nor passed to later processors. Processors must be safe
+// for concurrent use.
+type ReportProcessor interface {
+	ProcessAggregateReport(report *AggregateReport) bool
+	ProcessForensicReport(report *ForensicReport) bool
+	ProcessSMTPTLSReport(report *SMTPTLSReport) bool
+}
+
+// NopReportProcessor keeps every report unchanged. Embed it in processors
+// interested in some report types only.
+type NopReportProcessor struct{}
+
+// ProcessAggregateReport keeps report
+func (NopReportProcessor) ProcessAggregateReport(report *AggregateReport) bool { return true }
+
+// ProcessForensicReport keeps report
+func (NopReportProcessor) ProcessForensicReport(report *ForensicReport) bool { return true }
+
+// ProcessSMTPTLSReport keeps report
+func (NopReportProcessor) ProcessSMTPTLSReport(report *SMTPTLSReport) bool { return true }
+
+// vetoReport runs the report processors on report, an *AggregateReport,
+// *ForensicReport or *SMTPTLSReport, and reports whether one vetoed it
+func (p *Parser) vetoReport(report any) bool {
+	for _, processor := range p.processors {
+		var keep bool
+		switch r := report.(type) {
+		case *AggregateReport:
+			keep = processor.ProcessAggregateReport(r)
+		case *ForensicReport:
+			keep = processor.ProcessForensicReport(r)
+		case *SMTPTLSReport:
+			keep = processor.ProcessSMTPTLSReport(r)
+		}
+		if !keep {
+			return true
+		}
+	}
+	return false
+}
+
+// skipVetoed runs the report processors on report and reports whether one
+// vetoed it. Like reports older than max_report_age, vetoed reports are
+// counted with reason vetoed and audited, but neither stored nor treated as a
+// parse error.
+func (p *Parser) skipVetoed(source, reportType, reportID, orgName string, report any, start time.Time, size int) bool {
+	if !p.vetoReport(report) {
+		return false
+	}
+
+	if p.metrics != nil {
+		p.metrics.RecordParseFailure(reportType, source, "vetoed", time.Since(start).Seconds(), size)
+	}
+	p.audit(source, reportType, reportID, orgName, size, errReportVetoed)
+
+	p.logger.Info("Skipping report vetoed by a report processor",
+		zap.String("type", reportType),
+		zap.String("report_id", reportID),
+		zap.String("source", source),
+	)
+
+	return true
+}
@@ -5,6 +5,22 @@ import (
 	"time"
 )
 
+// Report types, as used in metric labels and parse hints
+const (
+	ReportTypeAggregate = "aggregate"
+	ReportTypeForensic  = "forensic"
+	ReportTypeSMTPTLS   = "smtp_tls"
+)
+
+// Source transports reports are received through, as used in metric labels
+// and stored with reports when store_source_transport is enabled
+const (
+	SourceHTTP  = "http"
+	SourceIMAP  = "imap"
+	SourceKafka = "kafka"
+	SourceFile  = "file"
+)
+
 // Storage interface for storing parsed reports
 type Storage interface {
 	StoreAggregateReport(report *AggregateReport) error
@@ -13,12 +29,143 @@ type Storage interface {
 	Close() error
 }
 
+// ReportStore is implemented by storages that can look up and remove
+// previously stored reports by reporting organization and report ID, as
+// report IDs are only unique per organization. Report types are "aggregate"
+// and "smtp_tls".
+type ReportStore interface {
+	ReportExists(reportType, orgName, reportID string) (bool, error)
+	DeleteReport(reportType, orgName, reportID string) error
+}
+
+// FingerprintStore is implemented by storages that can look up stored
+// reports by fingerprint, see AggregateReportFingerprint. It is preferred
+// over ReportStore.ReportExists for duplicate detection because report IDs
+// are only unique within the reporting organization.
+type FingerprintStore interface {
+	FingerprintExists(reportType, fingerprint string) (bool, error)
+}
+
+// Flusher is implemented by storages that buffer rows before inserting them,
+// see Parser.FlushStorage
+type Flusher interface {
+	Flush() error
+}
+
+// ReportQuerier is implemented by storages that can read stored aggregate
+// reports back
+type ReportQuerier interface {
+	QueryAggregateReports(filter AggregateReportFilter) ([]*AggregateReport, error)
+}
+
+// AggregateRecordStore is implemented by storages that can store an
+// aggregate report and its records in several calls, which lets large
+// report files be streamed, see stream_threshold. StoreAggregateReportHeader
+// ignores the records of report and returns an error wrapping
+// ErrDuplicateReport when it skips a stored report, whose records are then
+// not streamed; StoreAggregateRecords stores records of report, the first one
+// at index offset.
+type AggregateRecordStore interface {
+	StoreAggregateReportHeader(report *AggregateReport) error
+	StoreAggregateRecords(report *AggregateReport, offset int, records []Record) error
+}
+
+// AggregateReportFilter selects stored aggregate reports. Zero values do not
+// restrict the results. Matching reports are returned newest first.
+type AggregateReportFilter struct {
+	// Domain is the policy_published domain
+	Domain string
+	// From and To bound the report begin date: From <= begin_date < To
+	From time.Time
+	To   time.Time
+	// Limit and Offset select a page of the results
+	Limit  int
+	Offset int
+}
+
+// UnparsedStore is implemented by storages that can record metadata about
+// input that could not be parsed as any report type
+type UnparsedStore interface {
+	StoreUnparsedReport(report *UnparsedReport) error
+}
+
+// ReportWriter receives a copy of every successfully parsed report, see
+// Parser.SetTeeWriter. output.Writer implementations satisfy it.
+type ReportWriter interface {
+	WriteAggregateReport(report *AggregateReport) error
+	WriteForensicReport(report *ForensicReport) error
+	WriteSMTPTLSReport(report *SMTPTLSReport) error
+}
+
+// UnparsedReport describes input that could not be parsed
+type UnparsedReport struct {
+	ReceivedAt  time.Time `json:"received_at"`
+	Source      string    `json:"source"`
+	Size        int       `json:"size"`
+	ContentType string    `json:"content_type"`
+	Reason      string    `json:"reason"`
+	Error       string    `json:"error"`
+	Preview     string    `json:"preview"`
+}
+
+// WriteMode controls how a report is stored when one with the same report ID
+// already exists
+type WriteMode int
+
+const (
+	// WriteModeAppend stores every report, leaving duplicate handling to the storage
+	WriteModeAppend WriteMode = iota
+	// WriteModeCreate rejects a report whose ID is already stored
+	WriteModeCreate
+	// WriteModeReplace removes a previously stored report before storing the new one
+	WriteModeReplace
+)
+
+// ParseResult describes the reports found by Parser.ParseDataResult
+type ParseResult struct {
+	Reports []ReportResult `json:"reports"`
+}
+
+// ReportResult summarizes one parsed report. ReportID is the Message-ID for
+// forensic reports, RecordCount is only set for aggregate reports, and
+// Skipped marks a report that was older than max_report_age or vetoed by a
+// ReportProcessor, and not stored.
+type ReportResult struct {
+	ReportType  string `json:"report_type"`
+	OrgName     string `json:"org_name,omitempty"`
+	ReportID    string `json:"report_id,omitempty"`
+	RecordCount int    `json:"record_count,omitempty"`
+	Skipped     bool   `json:"skipped,omitempty"`
+}
+
+// EmailReports holds every report extracted from a single email
+type EmailReports struct {
+	AggregateReports []*AggregateReport `json:"aggregate_reports"`
+	ForensicReports  []*ForensicReport  `json:"forensic_reports"`
+	SMTPTLSReports   []*SMTPTLSReport   `json:"smtp_tls_reports"`
+}
+
 // AggregateReport represents a parsed DMARC aggregate report
 type AggregateReport struct {
 	XMLSchema       string          `json:"xml_schema"`
 	ReportMetadata  ReportMetadata  `json:"report_metadata"`
 	PolicyPublished PolicyPublished `json:"policy_published"`
 	Records         []Record        `json:"records"`
+	Fingerprint     string          `json:"fingerprint,omitempty"`
+	// RecordOffset is the index of the first of Records in the whole report,
+	// nonzero for the later chunks of a streamed report
+	RecordOffset int `json:"-"`
+	ParseInfo
+}
+
+// ParseInfo records how a report was parsed, for diagnosing performance
+// regressions and deciding which reports to reprocess. The duration and
+// version are only filled in when store_parse_metadata is enabled, the source
+// transport when store_source_transport is.
+type ParseInfo struct {
+	ParseDurationMS int64  `json:"parse_duration_ms,omitempty"`
+	ParserVersion   string `json:"parser_version,omitempty"`
+	SourceTransport string `json:"source_transport,omitempty"`
 }
 
 // ReportMetadata contains metadata about the report
@@ -41,6 +188,9 @@ type PolicyPublished struct {
 	SP     string `json:"sp"`
 	PCT    string `json:"pct"`
 	FO     string `json:"fo"`
+
+	// PCTValue is PCT as an integer in the range 0-100
+	PCTValue int `json:"pct_value"`
 }
 
 // Record represents a single record from the aggregate report
@@ -51,16 +201,34 @@ type Record struct {
 	PolicyEvaluated PolicyEvaluated `json:"policy_evaluated"`
 	Identifiers     Identifiers     `json:"identifiers"`
 	AuthResults     AuthResults     `json:"auth_results"`
+
+	// EffectivePolicy is the policy that applied to the record's messages:
+	// the published p (sp for subdomains of the policy domain), one step
+	// less strict when SampledOut
+	EffectivePolicy string `json:"effective_policy"`
+	// SampledOut is set when the reporter did not apply the policy because
+	// the message fell outside the published pct (a sampled_out override
+	// reason with pct below 100)
+	SampledOut bool `json:"sampled_out"`
 }
 
+// OtherSourceName is the source name and type of the synthetic records that
+// aggregate the low-count tail of a sampled report
+const OtherSourceName = "other"
+
 // Source contains information about the source IP
 type Source struct {
-	IPAddress  string `json:"ip_address"`
-	Country    string `json:"country"`
-	ReverseDNS string `json:"reverse_dns"`
-	BaseDomain string `json:"base_domain"`
-	Name       string `json:"name"`
-	Type       string `json:"type"`
+	IPAddress  string  `json:"ip_address"`
+	Country    string  `json:"country"`
+	City       string  `json:"city"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	ASN        uint    `json:"asn"`
+	ASNOrg     string  `json:"asn_org"`
+	ReverseDNS string  `json:"reverse_dns"`
+	BaseDomain string  `json:"base_domain"`
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
 }
 
 // Alignment indicates SPF, DKIM and overall DMARC alignment
@@ -113,26 +281,41 @@ type SPFResult struct {
 
 // ForensicReport represents a parsed DMARC forensic report
 type ForensicReport struct {
-	FeedbackType             string          `json:"feedback_type"`
-	UserAgent                *string         `json:"user_agent"`
-	Version                  *string         `json:"version"`
-	OriginalEnvelopeID       *string         `json:"original_envelope_id"`
-	OriginalMailFrom         *string         `json:"original_mail_from"`
-	OriginalRcptTo           *string         `json:"original_rcpt_to"`
-	ArrivalDate              time.Time       `json:"arrival_date"`
-	ArrivalDateUTC           time.Time       `json:"arrival_date_utc"`
-	Subject                  string          `json:"subject"`
-	MessageID                string          `json:"message_id"`
-	AuthenticationResults    string          `json:"authentication_results"`
-	DKIMDomain               *string         `json:"dkim_domain"`
-	Source                   Source          `json:"source"`
-	DeliveryResult           string          `json:"delivery_result"`
-	AuthFailure              []string        `json:"auth_failure"`
-	ReportedDomain           string          `json:"reported_domain"`
-	AuthenticationMechanisms []string        `json:"authentication_mechanisms"`
-	SampleHeadersOnly        bool            `json:"sample_headers_only"`
-	Sample                   string          `json:"sample"`
-	ParsedSample             json.RawMessage `json:"parsed_sample"`
+	FeedbackType                string                 `json:"feedback_type"`
+	UserAgent                   *string                `json:"user_agent"`
+	Version                     *string                `json:"version"`
+	OriginalEnvelopeID          *string                `json:"original_envelope_id"`
+	OriginalMailFrom            *string                `json:"original_mail_from"`
+	OriginalRcptTo              *string                `json:"original_rcpt_to"`
+	ArrivalDate                 time.Time              `json:"arrival_date"`
+	ArrivalDateUTC              time.Time              `json:"arrival_date_utc"`
+	Subject                     string                 `json:"subject"`
+	MessageID                   string                 `json:"message_id"`
+	AuthenticationResults       string                 `json:"authentication_results"`
+	ParsedAuthenticationResults []AuthenticationResult `json:"parsed_authentication_results,omitempty"`
+	DKIMDomain                  *string                `json:"dkim_domain"`
+	Source                      Source                 `json:"source"`
+	DeliveryResult              string                 `json:"delivery_result"`
+	AuthFailure                 []string               `json:"auth_failure"`
+	AuthFailureRaw              []string               `json:"auth_failure_raw,omitempty"`
+	ReportedDomain              string                 `json:"reported_domain"`
+	AdditionalReportedDomains   []string               `json:"additional_reported_domains,omitempty"`
+	AuthenticationMechanisms    []string               `json:"authentication_mechanisms"`
+	AuthenticationMechanismsRaw []string               `json:"authentication_mechanisms_raw,omitempty"`
+	SampleHeadersOnly           bool                   `json:"sample_headers_only"`
+	Sample                      string                 `json:"sample"`
+	ParsedSample                json.RawMessage        `json:"parsed_sample"`
+	ParseInfo
+}
+
+// AuthenticationResult is one method result of an Authentication-Results
+// header (RFC 8601), e.g. "dkim=pass header.d=example.com". Properties are
+// keyed by ptype.property in lowercase, e.g. "header.d".
+type AuthenticationResult struct {
+	Method     string            `json:"method"`
+	Result     string            `json:"result"`
+	Reason     string            `json:"reason,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
 }
 
 // SMTPTLSReport represents a parsed SMTP TLS report
@@ -143,6 +326,7 @@ type SMTPTLSReport struct {
 	ContactInfo      string          `json:"contact_info"`
 	ReportID         string          `json:"report_id"`
 	Policies         []SMTPTLSPolicy `json:"policies"`
+	ParseInfo
 }
 
 // SMTPTLSPolicy represents a policy in SMTP TLS report
@@ -150,12 +334,22 @@ type SMTPTLSPolicy struct {
 	PolicyDomain           string                  `json:"policy_domain"`
 	PolicyType             string                  `json:"policy_type"`
 	PolicyStrings          []string                `json:"policy_strings,omitempty"`
+	MTASTSPolicy           *MTASTSPolicy           `json:"mta_sts_policy,omitempty"`
 	MXHostPatterns         []string                `json:"mx_host_patterns,omitempty"`
 	SuccessfulSessionCount int                     `json:"successful_session_count"`
 	FailedSessionCount     int                     `json:"failed_session_count"`
 	FailureDetails         []SMTPTLSFailureDetails `json:"failure_details,omitempty"`
 }
 
+// MTASTSPolicy is the structured form of an MTA-STS policy (RFC 8461)
+// reported in the policy strings of an "sts" policy
+type MTASTSPolicy struct {
+	Version string   `json:"version"`
+	Mode    string   `json:"mode"`
+	MX      []string `json:"mx"`
+	MaxAge  int      `json:"max_age"`
+}
+
 // SMTPTLSFailureDetails contains details about TLS failures
 type SMTPTLSFailureDetails struct {
 	ResultType          string  `json:"result_type"`
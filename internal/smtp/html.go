@@ -0,0 +1,122 @@
+package smtp
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+
+	"parsedmarc-go/internal/parser"
+)
+
+// htmlStyle is shared by the HTML summaries of every report type
+const htmlStyle = `<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 16px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f0f0f0; }
+.fail { color: #b00020; }
+</style>`
+
+var aggregateTemplate = template.Must(template.New("aggregate").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8">` + htmlStyle + `</head>
+<body>
+<h2>DMARC Aggregate Report for {{.Report.PolicyPublished.Domain}}</h2>
+<table>
+<tr><th>Organization</th><td>{{.Report.ReportMetadata.OrgName}}</td></tr>
+<tr><th>Domain</th><td>{{.Report.PolicyPublished.Domain}}</td></tr>
+<tr><th>Report ID</th><td>{{.Report.ReportMetadata.ReportID}}</td></tr>
+<tr><th>Date Range</th><td>{{.Report.ReportMetadata.BeginDate.Format "2006-01-02 15:04"}} to {{.Report.ReportMetadata.EndDate.Format "2006-01-02 15:04"}} UTC</td></tr>
+<tr><th>Policy</th><td>p={{.Report.PolicyPublished.P}} sp={{.Report.PolicyPublished.SP}} pct={{.Report.PolicyPublished.PCT}}</td></tr>
+<tr><th>Records</th><td>{{len .Report.Records}}</td></tr>
+<tr><th>Messages</th><td>{{.Messages}}</td></tr>
+<tr><th>DMARC Pass</th><td>{{.Passed}}</td></tr>
+<tr><th>DMARC Fail</th><td{{if .Failed}} class="fail"{{end}}>{{.Failed}}</td></tr>
+</table>
+<table>
+<tr><th>Source IP</th><th>Reverse DNS</th><th>Country</th><th>Count</th><th>Disposition</th><th>DKIM</th><th>SPF</th><th>DMARC</th></tr>
+{{- range .Report.Records}}
+<tr><td>{{.Source.IPAddress}}</td><td>{{.Source.ReverseDNS}}</td><td>{{.Source.Country}}</td><td>{{.Count}}</td><td>{{.PolicyEvaluated.Disposition}}</td><td>{{.PolicyEvaluated.DKIM}}</td><td>{{.PolicyEvaluated.SPF}}</td><td{{if not .Alignment.DMARC}} class="fail"{{end}}>{{if .Alignment.DMARC}}pass{{else}}fail{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+var forensicTemplate = template.Must(template.New("forensic").Funcs(template.FuncMap{"join": strings.Join}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8">` + htmlStyle + `</head>
+<body>
+<h2>DMARC Forensic Report for {{.ReportedDomain}}</h2>
+<table>
+<tr><th>Domain</th><td>{{.ReportedDomain}}</td></tr>
+<tr><th>Arrival Date</th><td>{{.ArrivalDateUTC.Format "2006-01-02 15:04"}} UTC</td></tr>
+<tr><th>Subject</th><td>{{.Subject}}</td></tr>
+<tr><th>Message ID</th><td>{{.MessageID}}</td></tr>
+<tr><th>Source IP</th><td>{{.Source.IPAddress}}</td></tr>
+<tr><th>Reverse DNS</th><td>{{.Source.ReverseDNS}}</td></tr>
+<tr><th>Delivery Result</th><td>{{.DeliveryResult}}</td></tr>
+<tr><th>Auth Failure</th><td class="fail">{{join .AuthFailure ", "}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+var smtpTLSTemplate = template.Must(template.New("smtp_tls").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8">` + htmlStyle + `</head>
+<body>
+<h2>SMTP TLS Report from {{.OrganizationName}}</h2>
+<table>
+<tr><th>Organization</th><td>{{.OrganizationName}}</td></tr>
+<tr><th>Report ID</th><td>{{.ReportID}}</td></tr>
+<tr><th>Date Range</th><td>{{.BeginDate.Format "2006-01-02 15:04"}} to {{.EndDate.Format "2006-01-02 15:04"}} UTC</td></tr>
+</table>
+<table>
+<tr><th>Policy Domain</th><th>Policy Type</th><th>Successful Sessions</th><th>Failed Sessions</th></tr>
+{{- range .Policies}}
+<tr><td>{{.PolicyDomain}}</td><td>{{.PolicyType}}</td><td>{{.SuccessfulSessionCount}}</td><td{{if .FailedSessionCount}} class="fail"{{end}}>{{.FailedSessionCount}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// aggregateHTML renders the HTML summary of an aggregate report
+func aggregateHTML(report *parser.AggregateReport) (string, error) {
+	data := struct {
+		Report   *parser.AggregateReport
+		Messages int
+		Passed   int
+		Failed   int
+	}{Report: report}
+
+	for _, record := range report.Records {
+		data.Messages += record.Count
+		if record.Alignment.DMARC {
+			data.Passed += record.Count
+		} else {
+			data.Failed += record.Count
+		}
+	}
+
+	return renderHTML(aggregateTemplate, data)
+}
+
+// forensicHTML renders the HTML summary of a forensic report
+func forensicHTML(report *parser.ForensicReport) (string, error) {
+	return renderHTML(forensicTemplate, report)
+}
+
+// smtpTLSHTML renders the HTML summary of an SMTP TLS report
+func smtpTLSHTML(report *parser.SMTPTLSReport) (string, error) {
+	return renderHTML(smtpTLSTemplate, report)
+}
+
+func renderHTML(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
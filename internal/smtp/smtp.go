@@ -2,36 +2,50 @@ package smtp
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/smtp"
+	"net/textproto"
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/metrics"
 	"parsedmarc-go/internal/parser"
 )
 
 // Client represents an SMTP client for sending email reports
 type Client struct {
-	config *config.SMTPConfig
-	logger *zap.Logger
+	config  *config.SMTPConfig
+	logger  *zap.Logger
+	metrics *metrics.SenderMetrics
 }
 
-// New creates a new SMTP client
-func New(cfg *config.SMTPConfig, logger *zap.Logger) *Client {
+// New creates a new SMTP client. Metrics are registered with registry, or
+// with the global default registerer when registry is nil.
+func New(cfg *config.SMTPConfig, logger *zap.Logger, registry *prometheus.Registry) *Client {
 	return &Client{
-		config: cfg,
-		logger: logger,
+		config:  cfg,
+		logger:  logger,
+		metrics: metrics.NewSenderMetrics(registry),
 	}
 }
 
+// sink is the sink label of the SMTP sender metrics
+const sink = "smtp"
+
 // SendAggregateReport sends an aggregate DMARC report via email
-func (c *Client) SendAggregateReport(report *parser.AggregateReport) error {
+func (c *Client) SendAggregateReport(report *parser.AggregateReport) (err error) {
 	if !c.config.Enabled {
 		return nil
 	}
+	defer func() { c.metrics.RecordSend(sink, parser.ReportTypeAggregate, err == nil) }()
 
 	// Marshal report to JSON
 	reportData, err := json.MarshalIndent(report, "", "  ")
@@ -55,14 +69,23 @@ func (c *Client) SendAggregateReport(report *parser.AggregateReport) error {
 		)
 	}
 
-	return c.sendEmail(subject, body, reportData, "dmarc-aggregate.json")
+	var htmlBody string
+	if c.config.HTML {
+		htmlBody, err = aggregateHTML(report)
+		if err != nil {
+			return fmt.Errorf("failed to render HTML summary: %w", err)
+		}
+	}
+
+	return c.sendEmail(subject, body, htmlBody, reportData, "dmarc-aggregate.json")
 }
 
 // SendForensicReport sends a forensic DMARC report via email
-func (c *Client) SendForensicReport(report *parser.ForensicReport) error {
+func (c *Client) SendForensicReport(report *parser.ForensicReport) (err error) {
 	if !c.config.Enabled {
 		return nil
 	}
+	defer func() { c.metrics.RecordSend(sink, parser.ReportTypeForensic, err == nil) }()
 
 	// Marshal report to JSON
 	reportData, err := json.MarshalIndent(report, "", "  ")
@@ -86,14 +109,23 @@ func (c *Client) SendForensicReport(report *parser.ForensicReport) error {
 		)
 	}
 
-	return c.sendEmail(subject, body, reportData, "dmarc-forensic.json")
+	var htmlBody string
+	if c.config.HTML {
+		htmlBody, err = forensicHTML(report)
+		if err != nil {
+			return fmt.Errorf("failed to render HTML summary: %w", err)
+		}
+	}
+
+	return c.sendEmail(subject, body, htmlBody, reportData, "dmarc-forensic.json")
 }
 
 // SendSMTPTLSReport sends an SMTP TLS report via email
-func (c *Client) SendSMTPTLSReport(report *parser.SMTPTLSReport) error {
+func (c *Client) SendSMTPTLSReport(report *parser.SMTPTLSReport) (err error) {
 	if !c.config.Enabled {
 		return nil
 	}
+	defer func() { c.metrics.RecordSend(sink, parser.ReportTypeSMTPTLS, err == nil) }()
 
 	// Marshal report to JSON
 	reportData, err := json.MarshalIndent(report, "", "  ")
@@ -116,11 +148,21 @@ func (c *Client) SendSMTPTLSReport(report *parser.SMTPTLSReport) error {
 		)
 	}
 
-	return c.sendEmail(subject, body, reportData, "smtp-tls.json")
+	var htmlBody string
+	if c.config.HTML {
+		htmlBody, err = smtpTLSHTML(report)
+		if err != nil {
+			return fmt.Errorf("failed to render HTML summary: %w", err)
+		}
+	}
+
+	return c.sendEmail(subject, body, htmlBody, reportData, "smtp-tls.json")
 }
 
-// sendEmail sends an email with the specified subject, body, and attachment
-func (c *Client) sendEmail(subject, body string, attachment []byte, filename string) error {
+// sendEmail sends an email with the specified subject, body, and attachment.
+// When htmlBody is set, the body is sent as multipart/alternative with both
+// the text and the HTML version.
+func (c *Client) sendEmail(subject, body, htmlBody string, attachment []byte, filename string) error {
 	if len(c.config.To) == 0 {
 		return fmt.Errorf("no recipients configured")
 	}
@@ -140,13 +182,22 @@ func (c *Client) sendEmail(subject, body string, attachment []byte, filename str
 	msg.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=%s\r\n", boundary))
 	msg.WriteString("\r\n")
 
-	// Text part
+	// Text part, with the HTML alternative if any
 	msg.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
-	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
-	msg.WriteString("\r\n")
-	msg.WriteString(body)
-	msg.WriteString("\r\n\r\n")
+	if htmlBody != "" {
+		altBoundary := "alt-" + boundary
+		msg.WriteString(fmt.Sprintf("Content-Type: multipart/alternative; boundary=%s\r\n", altBoundary))
+		msg.WriteString("\r\n")
+
+		msg.WriteString(fmt.Sprintf("--%s\r\n", altBoundary))
+		writeTextPart(&msg, "text/plain", body)
+		msg.WriteString(fmt.Sprintf("--%s\r\n", altBoundary))
+		writeTextPart(&msg, "text/html", htmlBody)
+		msg.WriteString(fmt.Sprintf("--%s--\r\n", altBoundary))
+		msg.WriteString("\r\n")
+	} else {
+		writeTextPart(&msg, "text/plain", body)
+	}
 
 	// Attachment part
 	if len(attachment) > 0 && filename != "" {
@@ -180,7 +231,150 @@ func (c *Client) sendEmail(subject, body string, attachment []byte, filename str
 		zap.String("subject", subject),
 	)
 
-	return smtp.SendMail(addr, auth, c.config.From, c.config.To, msg.Bytes())
+	return c.sendWithRetry(addr, auth, msg.Bytes())
+}
+
+// writeTextPart writes the headers and content of a text part of a
+// multipart message
+func writeTextPart(msg *bytes.Buffer, contentType, content string) {
+	msg.WriteString(fmt.Sprintf("Content-Type: %s; charset=utf-8\r\n", contentType))
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(content)
+	msg.WriteString("\r\n\r\n")
+}
+
+// maxRetryDelay caps the backoff between delivery attempts
+const maxRetryDelay = 5 * time.Minute
+
+// sendWithRetry delivers the message, retrying transient failures (4xx
+// replies such as greylisting, and connection errors) with exponential
+// backoff. Permanent failures (5xx replies) are returned immediately.
+func (c *Client) sendWithRetry(addr string, auth smtp.Auth, msg []byte) error {
+	attempts := c.config.SendAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := time.Duration(c.config.RetryDelay) * time.Second
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = c.deliver(addr, auth, msg)
+		if err == nil {
+			return nil
+		}
+
+		if !isTransient(err) {
+			return fmt.Errorf("permanent SMTP failure: %w", err)
+		}
+
+		if attempt == attempts {
+			break
+		}
+
+		c.logger.Warn("Transient SMTP failure, retrying",
+			zap.String("host", c.config.Host),
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", attempts),
+			zap.Duration("retry_in", delay),
+			zap.Error(err),
+		)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+
+	return fmt.Errorf("SMTP delivery failed after %d attempts: %w", attempts, err)
+}
+
+// dialTimeout bounds connecting to the SMTP server
+const dialTimeout = 30 * time.Second
+
+// deliver sends msg in one SMTP session. With ssl the connection uses
+// implicit TLS (SMTPS); otherwise it starts in plaintext and is upgraded with
+// STARTTLS when the server advertises it.
+func (c *Client) deliver(addr string, auth smtp.Auth, msg []byte) error {
+	tlsConfig := &tls.Config{
+		ServerName:         c.config.Host,
+		InsecureSkipVerify: c.config.SkipVerify,
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var conn net.Conn
+	var err error
+	if c.config.SSL {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, c.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !c.config.SSL {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS failed: %w", err)
+			}
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("server does not support authentication")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(c.config.From); err != nil {
+		return err
+	}
+	for _, to := range c.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// isTransient reports whether an SMTP error may succeed on retry: 4xx
+// replies and network errors are transient, 5xx replies are permanent
+func isTransient(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
 }
 
 // encodeBase64 encodes data in base64 with line breaks
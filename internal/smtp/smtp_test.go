@@ -1,10 +1,27 @@
 package smtp
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap/zaptest"
 	"parsedmarc-go/internal/config"
 	"parsedmarc-go/internal/parser"
@@ -28,7 +45,7 @@ func TestSMTPClient_SendAggregateReport(t *testing.T) {
 		Message:    "Test message",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create test aggregate report
 	report := &parser.AggregateReport{
@@ -95,7 +112,7 @@ func TestSMTPClient_SendForensicReport(t *testing.T) {
 		Subject: "Test Forensic Report",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create test forensic report
 	report := &parser.ForensicReport{
@@ -138,7 +155,7 @@ func TestSMTPClient_SendSMTPTLSReport(t *testing.T) {
 		Subject: "Test SMTP TLS Report",
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create test SMTP TLS report
 	report := &parser.SMTPTLSReport{
@@ -182,7 +199,7 @@ func TestSMTPClient_DisabledClient(t *testing.T) {
 		To:      []string{"recipient@example.com"},
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create a dummy report
 	report := &parser.AggregateReport{
@@ -212,7 +229,7 @@ func TestSMTPClient_NoRecipients(t *testing.T) {
 		To:      []string{}, // Empty recipients
 	}
 
-	client := New(cfg, logger)
+	client := New(cfg, logger, prometheus.NewRegistry())
 
 	// Create a dummy report
 	report := &parser.AggregateReport{
@@ -276,3 +293,422 @@ func TestEncodeBase64(t *testing.T) {
 func stringPtr(s string) *string {
 	return &s
 }
+
+// mockSMTPServer is a minimal SMTP server that answers MAIL FROM with the
+// queued replies (one per connection) and accepts every message afterwards.
+// With starttls set, it advertises and accepts STARTTLS.
+type mockSMTPServer struct {
+	listener    net.Listener
+	mailReplies []string
+	starttls    *tls.Config
+
+	mu          sync.Mutex
+	connections int
+	messages    []string
+	tlsMessages int
+}
+
+func newMockSMTPServer(t *testing.T, mailReplies ...string) *mockSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock SMTP server: %v", err)
+	}
+
+	return startMockSMTPServer(t, &mockSMTPServer{listener: listener, mailReplies: mailReplies})
+}
+
+// newMockSTARTTLSServer starts a mock SMTP server advertising STARTTLS with a
+// self-signed certificate
+func newMockSTARTTLSServer(t *testing.T) *mockSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock SMTP server: %v", err)
+	}
+
+	return startMockSMTPServer(t, &mockSMTPServer{listener: listener, starttls: newTestTLSConfig(t)})
+}
+
+// newMockSMTPSServer starts a mock SMTP server speaking implicit TLS with a
+// self-signed certificate
+func newMockSMTPSServer(t *testing.T) *mockSMTPServer {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", newTestTLSConfig(t))
+	if err != nil {
+		t.Fatalf("Failed to start mock SMTPS server: %v", err)
+	}
+
+	return startMockSMTPServer(t, &mockSMTPServer{listener: listener})
+}
+
+func startMockSMTPServer(t *testing.T, server *mockSMTPServer) *mockSMTPServer {
+	go server.serve()
+	t.Cleanup(func() { server.listener.Close() })
+
+	return server
+}
+
+// newTestTLSConfig returns a server TLS configuration with a self-signed
+// certificate for 127.0.0.1
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mock.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
+
+func (s *mockSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		mailReply := "250 OK"
+		if s.connections < len(s.mailReplies) {
+			mailReply = s.mailReplies[s.connections]
+		}
+		s.connections++
+		s.mu.Unlock()
+
+		go s.handle(conn, mailReply)
+	}
+}
+
+func (s *mockSMTPServer) handle(conn net.Conn, mailReply string) {
+	defer func() { conn.Close() }()
+
+	_, isTLS := conn.(*tls.Conn)
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 mock.example.com ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			if s.starttls != nil && !isTLS {
+				tp.PrintfLine("250-mock.example.com")
+				tp.PrintfLine("250 STARTTLS")
+			} else {
+				tp.PrintfLine("250 mock.example.com")
+			}
+		case command == "STARTTLS" && s.starttls != nil && !isTLS:
+			tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.starttls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, isTLS = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case strings.HasPrefix(command, "MAIL FROM"):
+			tp.PrintfLine("%s", mailReply)
+		case strings.HasPrefix(command, "RCPT TO"):
+			tp.PrintfLine("250 OK")
+		case command == "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(data))
+			if isTLS {
+				s.tlsMessages++
+			}
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK: queued")
+		case command == "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *mockSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *mockSMTPServer) stats() (connections, messages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections, len(s.messages)
+}
+
+func (s *mockSMTPServer) tlsStats() (messages, tlsMessages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages), s.tlsMessages
+}
+
+func newRetryTestConfig(port int) *config.SMTPConfig {
+	return &config.SMTPConfig{
+		Enabled:      true,
+		Host:         "127.0.0.1",
+		Port:         port,
+		From:         "test@example.com",
+		To:           []string{"recipient@example.com"},
+		Subject:      "Test DMARC Report",
+		SendAttempts: 3,
+		RetryDelay:   0,
+	}
+}
+
+func TestSMTPClient_RetriesGreylisting(t *testing.T) {
+	server := newMockSMTPServer(t, "451 4.7.1 Greylisted, try again later")
+	client := New(newRetryTestConfig(server.port()), zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "Test Org", ReportID: "test-123"},
+		PolicyPublished: parser.PolicyPublished{
+			Domain: "example.com",
+		},
+	}
+
+	if err := client.SendAggregateReport(report); err != nil {
+		t.Fatalf("Expected eventual delivery, got error: %v", err)
+	}
+
+	connections, messages := server.stats()
+	if connections != 2 {
+		t.Errorf("Expected 2 connections (greylisted then accepted), got %d", connections)
+	}
+	if messages != 1 {
+		t.Errorf("Expected 1 delivered message, got %d", messages)
+	}
+}
+
+func TestSMTPClient_PermanentFailureNotRetried(t *testing.T) {
+	server := newMockSMTPServer(t, "550 5.7.1 Rejected")
+	client := New(newRetryTestConfig(server.port()), zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "Test Org", ReportID: "test-123"},
+	}
+
+	err := client.SendAggregateReport(report)
+	if err == nil {
+		t.Fatal("Expected error for permanent failure")
+	}
+	if !strings.Contains(err.Error(), "permanent") {
+		t.Errorf("Expected permanent failure error, got %v", err)
+	}
+
+	if connections, _ := server.stats(); connections != 1 {
+		t.Errorf("Expected a single attempt for a 5xx reply, got %d", connections)
+	}
+}
+
+func TestSMTPClient_SenderMetrics(t *testing.T) {
+	server := newMockSMTPServer(t, "550 5.7.1 Rejected")
+	client := New(newRetryTestConfig(server.port()), zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	report := &parser.ForensicReport{ReportedDomain: "example.com"}
+	if err := client.SendForensicReport(report); err == nil {
+		t.Fatal("Expected error for a rejected message")
+	}
+	if err := client.SendForensicReport(report); err != nil {
+		t.Fatalf("SendForensicReport failed: %v", err)
+	}
+
+	sent := client.metrics.ReportsSentTotal
+	if got := testutil.ToFloat64(sent.WithLabelValues("smtp", "forensic", "failure")); got != 1 {
+		t.Errorf("Expected 1 failed send, got %v", got)
+	}
+	if got := testutil.ToFloat64(sent.WithLabelValues("smtp", "forensic", "success")); got != 1 {
+		t.Errorf("Expected 1 successful send, got %v", got)
+	}
+}
+
+func TestSMTPClient_ImplicitTLS(t *testing.T) {
+	server := newMockSMTPSServer(t)
+	cfg := newRetryTestConfig(server.port())
+	cfg.SSL = true
+	cfg.SkipVerify = true
+	client := New(cfg, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "Test Org", ReportID: "test-123"},
+	}
+	if err := client.SendAggregateReport(report); err != nil {
+		t.Fatalf("SendAggregateReport failed: %v", err)
+	}
+
+	if messages, tlsMessages := server.tlsStats(); messages != 1 || tlsMessages != 1 {
+		t.Errorf("Expected 1 message delivered over TLS, got %d messages, %d over TLS", messages, tlsMessages)
+	}
+}
+
+func TestSMTPClient_ImplicitTLSVerifiesCertificate(t *testing.T) {
+	server := newMockSMTPSServer(t)
+	cfg := newRetryTestConfig(server.port())
+	cfg.SSL = true
+	client := New(cfg, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "Test Org", ReportID: "test-123"},
+	}
+	if err := client.SendAggregateReport(report); err == nil {
+		t.Fatal("Expected error for a self-signed certificate without skip_verify")
+	}
+
+	if messages, _ := server.tlsStats(); messages != 0 {
+		t.Errorf("Expected no delivered message, got %d", messages)
+	}
+}
+
+func TestSMTPClient_STARTTLS(t *testing.T) {
+	server := newMockSTARTTLSServer(t)
+	cfg := newRetryTestConfig(server.port())
+	cfg.SkipVerify = true
+	client := New(cfg, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "Test Org", ReportID: "test-123"},
+	}
+	if err := client.SendAggregateReport(report); err != nil {
+		t.Fatalf("SendAggregateReport failed: %v", err)
+	}
+
+	if messages, tlsMessages := server.tlsStats(); messages != 1 || tlsMessages != 1 {
+		t.Errorf("Expected 1 message delivered after STARTTLS, got %d messages, %d over TLS", messages, tlsMessages)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Greylisting", &textproto.Error{Code: 451, Msg: "try again later"}, true},
+		{"Mailbox full", &textproto.Error{Code: 452, Msg: "insufficient storage"}, true},
+		{"Rejected", &textproto.Error{Code: 550, Msg: "rejected"}, false},
+		{"Connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"Other error", errors.New("no recipients configured"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSMTPClient_HTMLSummary(t *testing.T) {
+	server := newMockSMTPServer(t)
+	cfg := newRetryTestConfig(server.port())
+	cfg.HTML = true
+	client := New(cfg, zaptest.NewLogger(t), prometheus.NewRegistry())
+
+	record := func(ip string, count int, aligned bool) parser.Record {
+		return parser.Record{
+			Source:    parser.Source{IPAddress: ip},
+			Count:     count,
+			Alignment: parser.Alignment{DMARC: aligned},
+		}
+	}
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{
+			OrgName:   "Test Org",
+			ReportID:  "test-123",
+			BeginDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2024, 1, 1, 23, 59, 59, 0, time.UTC),
+		},
+		PolicyPublished: parser.PolicyPublished{Domain: "monitored.example", P: "reject"},
+		Records: []parser.Record{
+			record("192.0.2.1", 5, true),
+			record("192.0.2.2", 2, false),
+			record("192.0.2.3", 1, false),
+		},
+	}
+
+	if err := client.SendAggregateReport(report); err != nil {
+		t.Fatalf("SendAggregateReport failed: %v", err)
+	}
+
+	server.mu.Lock()
+	message := server.messages[0]
+	server.mu.Unlock()
+
+	msg, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	parts := map[string]string{}
+	collectParts(t, msg.Header.Get("Content-Type"), msg.Body, parts)
+
+	if !strings.Contains(parts["text/plain"], "monitored.example") {
+		t.Errorf("Expected text part to be kept, got %q", parts["text/plain"])
+	}
+	if _, ok := parts["application/json"]; !ok {
+		t.Error("Expected JSON attachment to be kept")
+	}
+
+	html := parts["text/html"]
+	for _, want := range []string{
+		"<td>monitored.example</td>",
+		"<tr><th>Records</th><td>3</td></tr>",
+		"<tr><th>DMARC Pass</th><td>5</td></tr>",
+		`<tr><th>DMARC Fail</th><td class="fail">3</td></tr>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Expected HTML part to contain %q, got:\n%s", want, html)
+		}
+	}
+}
+
+// collectParts walks a MIME body, recording the content of each leaf part by
+// content type
+func collectParts(t *testing.T, contentType string, body io.Reader, parts map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Invalid content type %q: %v", contentType, err)
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		content, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("Failed to read %s part: %v", mediaType, err)
+		}
+		parts[mediaType] = string(content)
+		return
+	}
+
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("Failed to read %s part: %v", mediaType, err)
+		}
+		collectParts(t, part.Header.Get("Content-Type"), part, parts)
+	}
+}
@@ -3,26 +3,172 @@ package clickhouse
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"go.uber.org/zap"
 	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/metrics"
 	"parsedmarc-go/internal/parser"
 )
 
+// Duplicate handling modes for clickhouse.duplicate_handling
+const (
+	// DuplicateKeep stores every inserted copy of a report (MergeTree)
+	DuplicateKeep = "keep"
+	// DuplicateReplace collapses copies sharing a natural key (ReplacingMergeTree)
+	DuplicateReplace = "replace"
+)
+
 // Storage implements ClickHouse storage for DMARC reports
 type Storage struct {
-	conn   driver.Conn
-	logger *zap.Logger
+	conn    driver.Conn
+	config  config.ClickHouseConfig
+	logger  *zap.Logger
+	metrics *metrics.StorageMetrics
+
+	// With batch_size set, forensic report and SMTP TLS report rows are
+	// buffered and inserted with one batch per table, see Flush
+	bufferMu sync.Mutex
+	buffer   rowBuffer
+
+	stop chan struct{}
+	done chan struct{}
 }
 
+// Connection protocols for clickhouse.protocol
+const (
+	// ProtocolNative uses the native TCP interface (default port 9000, 9440 with TLS)
+	ProtocolNative = "native"
+	// ProtocolHTTP uses the HTTP interface (default port 8123, 8443 with TLS)
+	ProtocolHTTP = "http"
+)
+
 // New creates a new ClickHouse storage instance
 func New(cfg config.ClickHouseConfig, logger *zap.Logger) (*Storage, error) {
+	switch cfg.DuplicateHandling {
+	case "", DuplicateKeep, DuplicateReplace:
+	default:
+		return nil, fmt.Errorf("invalid duplicate_handling %q: must be %q or %q",
+			cfg.DuplicateHandling, DuplicateKeep, DuplicateReplace)
+	}
+
+	options, err := buildOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := connect(cfg, options, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &Storage{
+		conn:    conn,
+		config:  cfg,
+		logger:  logger,
+		metrics: metrics.NewStorageMetrics(nil),
+	}
+
+	// Create tables if they don't exist
+	if err := storage.createTables(); err != nil {
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+
+	if cfg.BatchSize > 0 && cfg.FlushInterval > 0 {
+		storage.stop = make(chan struct{})
+		storage.done = make(chan struct{})
+		go storage.flushPeriodically(time.Duration(cfg.FlushInterval) * time.Second)
+	}
+
+	return storage, nil
+}
+
+// openConn opens a ClickHouse connection, replaceable in tests
+var openConn = clickhouse.Open
+
+// maxConnectRetryDelay caps the backoff between startup connection attempts
+const maxConnectRetryDelay = 60 * time.Second
+
+// connect opens the connection and pings it, retrying with exponential
+// backoff so that a daemon started before ClickHouse is ready waits for it
+func connect(cfg config.ClickHouseConfig, options *clickhouse.Options, logger *zap.Logger) (driver.Conn, error) {
+	attempts := cfg.ConnectAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := time.Duration(cfg.ConnectRetryDelay) * time.Second
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		conn, err := openConn(options)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to connect to ClickHouse: %w", err)
+		} else if err := conn.Ping(context.Background()); err != nil {
+			conn.Close()
+			lastErr = fmt.Errorf("failed to ping ClickHouse: %w", err)
+		} else {
+			return conn, nil
+		}
+
+		if attempt == attempts {
+			break
+		}
+
+		logger.Warn("ClickHouse not ready, retrying",
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", attempts),
+			zap.Duration("retry_in", delay),
+			zap.Error(lastErr),
+		)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxConnectRetryDelay {
+			delay = maxConnectRetryDelay
+		}
+	}
+
+	return nil, lastErr
+}
+
+// buildOptions converts the configuration to clickhouse-go connection options,
+// selecting the protocol and its default port when none is configured
+func buildOptions(cfg config.ClickHouseConfig) (*clickhouse.Options, error) {
+	var protocol clickhouse.Protocol
+	port := cfg.Port
+
+	switch strings.ToLower(cfg.Protocol) {
+	case "", ProtocolNative:
+		protocol = clickhouse.Native
+		if port == 0 {
+			port = 9000
+			if cfg.TLS {
+				port = 9440
+			}
+		}
+	case ProtocolHTTP:
+		protocol = clickhouse.HTTP
+		if port == 0 {
+			port = 8123
+			if cfg.TLS {
+				port = 8443
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid protocol %q: must be %q or %q",
+			cfg.Protocol, ProtocolNative, ProtocolHTTP)
+	}
+
 	options := &clickhouse.Options{
-		Addr: []string{fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)},
+		Protocol: protocol,
+		Addr:     []string{fmt.Sprintf("%s:%d", cfg.Host, port)},
 		Auth: clickhouse.Auth{
 			Database: cfg.Database,
 			Username: cfg.Username,
@@ -41,42 +187,226 @@ func New(cfg config.ClickHouseConfig, logger *zap.Logger) (*Storage, error) {
 		}
 	}
 
-	conn, err := clickhouse.Open(options)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to ClickHouse: %w", err)
+	return options, nil
+}
+
+// Ping checks that the ClickHouse server is reachable
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.conn.Ping(ctx)
+}
+
+// Close inserts the buffered rows and closes the ClickHouse connection
+func (s *Storage) Close() error {
+	if s.stop != nil {
+		select {
+		case <-s.stop:
+		default:
+			close(s.stop)
+		}
+		<-s.done
 	}
 
-	if err := conn.Ping(context.Background()); err != nil {
-		return nil, fmt.Errorf("failed to ping ClickHouse: %w", err)
+	if s.conn == nil {
+		return nil
 	}
+	flushErr := s.Flush()
+	return errors.Join(flushErr, s.conn.Close())
+}
 
-	storage := &Storage{
-		conn:   conn,
-		logger: logger,
+// Flush inserts the buffered forensic and SMTP TLS report rows. Sources that
+// acknowledge a report once it is stored, such as IMAP and Kafka, flush
+// before doing so, see parser.Parser.FlushStorage.
+func (s *Storage) Flush() error {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+
+	return s.flushRows()
+}
+
+// flushPeriodically flushes every interval until Close
+func (s *Storage) flushPeriodically(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				s.logger.Error("Failed to flush buffered rows to ClickHouse", zap.Error(err))
+			}
+		}
 	}
+}
 
-	// Create tables if they don't exist
-	if err := storage.createTables(); err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+// rowBuffer holds the rows buffered with batch_size, per table
+type rowBuffer struct {
+	forensic        [][]any
+	smtpTLSReports  [][]any
+	smtpTLSFailures [][]any
+}
+
+// len returns the number of buffered rows
+func (b rowBuffer) len() int {
+	return len(b.forensic) + len(b.smtpTLSReports) + len(b.smtpTLSFailures)
+}
+
+// Columns of the buffered rows holding the natural key of their report, by
+// position in forensicReportValues, smtpTLSReportValues and the SMTP TLS
+// failure rows
+const (
+	forensicMessageIDColumn = 9
+	smtpTLSReportOrgColumn  = 0
+	smtpTLSReportIDColumn   = 4
+	smtpTLSFailureIDColumn  = 0
+	smtpTLSFailureOrgColumn = 1
+)
+
+// rowMatches reports whether row holds reportID in column idColumn and, when
+// orgColumn is not negative, orgName in column orgColumn
+func rowMatches(row []any, orgColumn, idColumn int, orgName, reportID string) bool {
+	if row[idColumn] != reportID {
+		return false
 	}
+	return orgColumn < 0 || row[orgColumn] == orgName
+}
 
-	return storage, nil
+// has reports whether a row of the report of the organization with the given
+// ID is buffered
+func (b rowBuffer) has(reportType, orgName, reportID string) bool {
+	switch reportType {
+	case parser.ReportTypeForensic:
+		for _, row := range b.forensic {
+			if rowMatches(row, -1, forensicMessageIDColumn, orgName, reportID) {
+				return true
+			}
+		}
+	case parser.ReportTypeSMTPTLS:
+		for _, row := range b.smtpTLSReports {
+			if rowMatches(row, smtpTLSReportOrgColumn, smtpTLSReportIDColumn, orgName, reportID) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-// Close closes the ClickHouse connection
-func (s *Storage) Close() error {
-	if s.conn != nil {
-		return s.conn.Close()
+// remove drops the buffered rows of the report of the organization with the
+// given ID
+func (b *rowBuffer) remove(reportType, orgName, reportID string) {
+	keep := func(rows [][]any, orgColumn, idColumn int) [][]any {
+		kept := rows[:0]
+		for _, row := range rows {
+			if !rowMatches(row, orgColumn, idColumn, orgName, reportID) {
+				kept = append(kept, row)
+			}
+		}
+		return kept
+	}
+
+	switch reportType {
+	case parser.ReportTypeForensic:
+		b.forensic = keep(b.forensic, -1, forensicMessageIDColumn)
+	case parser.ReportTypeSMTPTLS:
+		b.smtpTLSReports = keep(b.smtpTLSReports, smtpTLSReportOrgColumn, smtpTLSReportIDColumn)
+		b.smtpTLSFailures = keep(b.smtpTLSFailures, smtpTLSFailureOrgColumn, smtpTLSFailureIDColumn)
+	}
+}
+
+// bufferRows appends rows to the buffers, and inserts them once batch_size
+// rows are buffered
+func (s *Storage) bufferRows(rows rowBuffer) error {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+
+	s.buffer.forensic = append(s.buffer.forensic, rows.forensic...)
+	s.buffer.smtpTLSReports = append(s.buffer.smtpTLSReports, rows.smtpTLSReports...)
+	s.buffer.smtpTLSFailures = append(s.buffer.smtpTLSFailures, rows.smtpTLSFailures...)
+
+	if s.buffer.len() >= s.config.BatchSize {
+		return s.flushRows()
 	}
 	return nil
 }
 
+// flushRows inserts the buffered rows. They are dropped whether or not the
+// inserts succeed, so that a failing server does not make the buffers grow
+// without bounds. s.bufferMu must be held.
+func (s *Storage) flushRows() error {
+	rows := s.buffer
+	s.buffer = rowBuffer{}
+
+	var errs []error
+	if err := s.sendBatch(forensicReportInsert, rows.forensic); err != nil {
+		errs = append(errs, fmt.Errorf("failed to insert %d forensic reports: %w", len(rows.forensic), err))
+	}
+	if err := s.sendBatch(smtpTLSReportInsert, rows.smtpTLSReports); err != nil {
+		errs = append(errs, fmt.Errorf("failed to insert %d SMTP TLS reports: %w", len(rows.smtpTLSReports), err))
+	}
+	if err := s.sendBatch(smtpTLSFailureInsert, rows.smtpTLSFailures); err != nil {
+		errs = append(errs, fmt.Errorf("failed to insert %d SMTP TLS failure details: %w", len(rows.smtpTLSFailures), err))
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if rows.len() > 0 {
+		s.logger.Debug("Flushed buffered rows to ClickHouse",
+			zap.Int("forensic_reports", len(rows.forensic)),
+			zap.Int("smtp_tls_reports", len(rows.smtpTLSReports)),
+			zap.Int("smtp_tls_failures", len(rows.smtpTLSFailures)),
+		)
+	}
+	return nil
+}
+
+// sendBatch inserts rows with one prepared batch
+func (s *Storage) sendBatch(query string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	batch, err := s.conn.PrepareBatch(context.Background(), query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+	for _, row := range rows {
+		if err := batch.Append(row...); err != nil {
+			return fmt.Errorf("failed to append row to batch: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+	return nil
+}
+
+// tableEngine returns the ENGINE and ORDER BY clauses for a table. In
+// "replace" mode the natural key becomes the sorting key of a
+// ReplacingMergeTree, so copies of the same report inserted concurrently
+// (e.g. via HTTP and IMAP) collapse into one row when parts are merged.
+func (s *Storage) tableEngine(orderBy, naturalKey string) string {
+	if s.config.DuplicateHandling == DuplicateReplace {
+		return fmt.Sprintf("ENGINE = ReplacingMergeTree(created_at)\n\tORDER BY (%s)", naturalKey)
+	}
+	return fmt.Sprintf("ENGINE = MergeTree()\n\tORDER BY (%s)", orderBy)
+}
+
+// forensicNaturalKey is the natural key of dmarc_forensic_reports. The arrival
+// date is not part of it: reports without an Arrival-Date get the time they
+// are parsed, which differs on every ingestion. Reports without a Message-ID
+// are keyed by their row ID so that they never collapse, as with deduplicate.
+const forensicNaturalKey = "message_id, if(message_id = '', toString(id), '')"
+
 // createTables creates the necessary tables for storing DMARC reports
 func (s *Storage) createTables() error {
 	ctx := context.Background()
 
 	// Create aggregate reports table
-	aggregateTableSQL := `
+	aggregateTableSQL := fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS dmarc_aggregate_reports (
 		id UUID DEFAULT generateUUIDv4(),
 		xml_schema String,
@@ -93,24 +423,35 @@ func (s *Storage) createTables() error {
 		p String,
 		sp String,
 		pct String,
+		pct_value UInt8,
 		fo String,
+		fingerprint String,
+		parse_duration_ms UInt32,
+		parser_version String,
+		source_transport String,
 		created_at DateTime DEFAULT now()
-	) ENGINE = MergeTree()
-	ORDER BY (org_name, report_id, begin_date)
-	PARTITION BY toYYYYMM(begin_date)`
+	) %s
+	PARTITION BY toYYYYMM(begin_date)`,
+		s.tableEngine("org_name, report_id, begin_date", "org_name, report_id"))
 
 	if err := s.conn.Exec(ctx, aggregateTableSQL); err != nil {
 		return fmt.Errorf("failed to create aggregate reports table: %w", err)
 	}
 
 	// Create records table
-	recordsTableSQL := `
+	recordsTableSQL := fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS dmarc_aggregate_records (
 		id UUID DEFAULT generateUUIDv4(),
 		report_id String,
 		org_name String,
+		record_index UInt32,
 		source_ip_address String,
 		source_country String,
+		source_city String,
+		source_latitude Float64,
+		source_longitude Float64,
+		source_asn UInt32,
+		source_asn_org String,
 		source_reverse_dns String,
 		source_base_domain String,
 		source_name String,
@@ -120,6 +461,8 @@ func (s *Storage) createTables() error {
 		dkim_aligned UInt8,
 		dmarc_aligned UInt8,
 		disposition String,
+		effective_policy String,
+		sampled_out UInt8,
 		policy_override_reasons Array(String),
 		policy_override_comments Array(String),
 		envelope_from Nullable(String),
@@ -133,16 +476,16 @@ func (s *Storage) createTables() error {
 		spf_results Array(String),
 		begin_date DateTime,
 		created_at DateTime DEFAULT now()
-	) ENGINE = MergeTree()
-	ORDER BY (org_name, report_id, source_ip_address, begin_date)
-	PARTITION BY toYYYYMM(begin_date)`
+	) %s
+	PARTITION BY toYYYYMM(begin_date)`,
+		s.tableEngine("org_name, report_id, source_ip_address, begin_date", "org_name, report_id, record_index"))
 
 	if err := s.conn.Exec(ctx, recordsTableSQL); err != nil {
 		return fmt.Errorf("failed to create records table: %w", err)
 	}
 
 	// Create forensic reports table
-	forensicTableSQL := `
+	forensicTableSQL := fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS dmarc_forensic_reports (
 		id UUID DEFAULT generateUUIDv4(),
 		feedback_type String,
@@ -156,31 +499,42 @@ func (s *Storage) createTables() error {
 		subject String,
 		message_id String,
 		authentication_results String,
+		parsed_authentication_results String,
 		dkim_domain Nullable(String),
 		source_ip_address String,
 		source_country String,
+		source_city String,
+		source_latitude Float64,
+		source_longitude Float64,
+		source_asn UInt32,
+		source_asn_org String,
 		source_reverse_dns String,
 		source_base_domain String,
 		source_name String,
 		source_type String,
 		delivery_result String,
 		auth_failure Array(String),
+		auth_failure_raw Array(String),
 		reported_domain String,
 		authentication_mechanisms Array(String),
+		authentication_mechanisms_raw Array(String),
 		sample_headers_only UInt8,
 		sample String,
 		parsed_sample String,
+		parse_duration_ms UInt32,
+		parser_version String,
+		source_transport String,
 		created_at DateTime DEFAULT now()
-	) ENGINE = MergeTree()
-	ORDER BY (arrival_date, source_ip_address)
-	PARTITION BY toYYYYMM(arrival_date)`
+	) %s
+	PARTITION BY toYYYYMM(arrival_date)`,
+		s.tableEngine("arrival_date, source_ip_address", forensicNaturalKey))
 
 	if err := s.conn.Exec(ctx, forensicTableSQL); err != nil {
 		return fmt.Errorf("failed to create forensic reports table: %w", err)
 	}
 
 	// Create SMTP TLS reports table
-	smtpTLSTableSQL := `
+	smtpTLSTableSQL := fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS dmarc_smtp_tls_reports (
 		id UUID DEFAULT generateUUIDv4(),
 		organization_name String,
@@ -191,27 +545,34 @@ func (s *Storage) createTables() error {
 		policy_domain String,
 		policy_type String,
 		policy_strings Array(String),
+		mta_sts_policy String,
 		mx_host_patterns Array(String),
 		successful_session_count UInt64,
 		failed_session_count UInt64,
+		parse_duration_ms UInt32,
+		parser_version String,
+		source_transport String,
 		created_at DateTime DEFAULT now(),
 		INDEX idx_report_id report_id TYPE bloom_filter GRANULARITY 1,
 		INDEX idx_org_name organization_name TYPE bloom_filter GRANULARITY 1,
 		INDEX idx_policy_domain policy_domain TYPE bloom_filter GRANULARITY 1
-	) ENGINE = MergeTree()
-	ORDER BY (begin_date, organization_name)
-	PARTITION BY toYYYYMM(begin_date)`
+	) %s
+	PARTITION BY toYYYYMM(begin_date)`,
+		s.tableEngine("begin_date, organization_name", "organization_name, report_id"))
 
 	if err := s.conn.Exec(ctx, smtpTLSTableSQL); err != nil {
 		return fmt.Errorf("failed to create SMTP TLS reports table: %w", err)
 	}
 
 	// Create SMTP TLS failure details table
-	smtpTLSFailuresTableSQL := `
+	smtpTLSFailuresTableSQL := fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS dmarc_smtp_tls_failures (
 		id UUID DEFAULT generateUUIDv4(),
 		report_id String,
+		organization_name String,
+		begin_date DateTime,
 		policy_domain String,
+		failure_index UInt32,
 		result_type String,
 		failed_session_count UInt64,
 		sending_mta_ip Nullable(String),
@@ -223,30 +584,318 @@ func (s *Storage) createTables() error {
 		created_at DateTime DEFAULT now(),
 		INDEX idx_report_id report_id TYPE bloom_filter GRANULARITY 1,
 		INDEX idx_policy_domain policy_domain TYPE bloom_filter GRANULARITY 1
-	) ENGINE = MergeTree()
-	ORDER BY (report_id, result_type)
-	PARTITION BY toYYYYMM(created_at)`
+	) %s
+	PARTITION BY toYYYYMM(begin_date)`,
+		s.tableEngine("report_id, result_type", "organization_name, report_id, policy_domain, failure_index"))
 
 	if err := s.conn.Exec(ctx, smtpTLSFailuresTableSQL); err != nil {
 		return fmt.Errorf("failed to create SMTP TLS failures table: %w", err)
 	}
 
-	s.logger.Info("ClickHouse tables created successfully")
+	// Create unparsed reports table (metadata of input no parser accepted)
+	unparsedTableSQL := `
+	CREATE TABLE IF NOT EXISTS dmarc_unparsed_reports (
+		id UUID DEFAULT generateUUIDv4(),
+		received_at DateTime,
+		source String,
+		size UInt64,
+		content_type String,
+		reason String,
+		error String,
+		preview String,
+		created_at DateTime DEFAULT now()
+	) ENGINE = MergeTree()
+	ORDER BY (received_at, source)
+	PARTITION BY toYYYYMM(received_at)`
+
+	if err := s.conn.Exec(ctx, unparsedTableSQL); err != nil {
+		return fmt.Errorf("failed to create unparsed reports table: %w", err)
+	}
+
+	if err := s.migrateTables(ctx); err != nil {
+		return err
+	}
+
+	s.logger.Info("ClickHouse tables created successfully",
+		zap.String("duplicate_handling", s.config.DuplicateHandling),
+	)
 	return nil
 }
 
-// StoreAggregateReport stores an aggregate DMARC report in ClickHouse
-func (s *Storage) StoreAggregateReport(report *parser.AggregateReport) error {
-	ctx := context.Background()
+// migrateTables adds columns introduced after the initial schema to tables
+// created by older versions. The table engine of an existing table is never
+// changed; switching duplicate_handling requires recreating the tables.
+func (s *Storage) migrateTables(ctx context.Context) error {
+	migrations := []string{
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS record_index UInt32 AFTER org_name`,
+		`ALTER TABLE dmarc_smtp_tls_failures ADD COLUMN IF NOT EXISTS failure_index UInt32 AFTER policy_domain`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS auth_failure_raw Array(String) AFTER auth_failure`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS authentication_mechanisms_raw Array(String) AFTER authentication_mechanisms`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS pct_value UInt8 AFTER pct`,
+		`ALTER TABLE dmarc_smtp_tls_reports ADD COLUMN IF NOT EXISTS mta_sts_policy String AFTER policy_strings`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS fingerprint String AFTER fo`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_city String AFTER source_country`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_latitude Float64 AFTER source_city`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_longitude Float64 AFTER source_latitude`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_city String AFTER source_country`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_latitude Float64 AFTER source_city`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_longitude Float64 AFTER source_latitude`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_asn UInt32 AFTER source_longitude`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_asn_org String AFTER source_asn`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_asn UInt32 AFTER source_longitude`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_asn_org String AFTER source_asn`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS parse_duration_ms UInt32 AFTER fingerprint`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS parser_version String AFTER parse_duration_ms`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS parse_duration_ms UInt32 AFTER parsed_sample`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS parser_version String AFTER parse_duration_ms`,
+		`ALTER TABLE dmarc_smtp_tls_reports ADD COLUMN IF NOT EXISTS parse_duration_ms UInt32 AFTER failed_session_count`,
+		`ALTER TABLE dmarc_smtp_tls_reports ADD COLUMN IF NOT EXISTS parser_version String AFTER parse_duration_ms`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS source_transport String AFTER parser_version`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_transport String AFTER parser_version`,
+		`ALTER TABLE dmarc_smtp_tls_reports ADD COLUMN IF NOT EXISTS source_transport String AFTER parser_version`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS effective_policy String AFTER disposition`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS sampled_out UInt8 AFTER effective_policy`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS parsed_authentication_results String AFTER authentication_results`,
+		`ALTER TABLE dmarc_smtp_tls_failures ADD COLUMN IF NOT EXISTS organization_name String AFTER report_id`,
+		`ALTER TABLE dmarc_smtp_tls_failures ADD COLUMN IF NOT EXISTS begin_date DateTime AFTER organization_name`,
+	}
+
+	for _, migration := range migrations {
+		if err := s.conn.Exec(ctx, migration); err != nil {
+			return fmt.Errorf("failed to migrate tables: %w", err)
+		}
+	}
 
-	// Store the main report record
-	reportSQL := `
+	return nil
+}
+
+// aggregateReportInsert, aggregateRecordInsert, forensicReportInsert and
+// smtpTLSFailureInsert are the INSERT statements of aggregate reports, their
+// records, forensic reports and SMTP TLS failure details, used with prepared
+// batches
+const (
+	aggregateReportInsert = `
 	INSERT INTO dmarc_aggregate_reports (
 		xml_schema, org_name, org_email, org_extra_contact_info, report_id,
-		begin_date, end_date, errors, domain, adkim, aspf, p, sp, pct, fo
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		begin_date, end_date, errors, domain, adkim, aspf, p, sp, pct, pct_value, fo,
+		fingerprint, parse_duration_ms, parser_version, source_transport
+	)`
+
+	aggregateRecordInsert = `
+	INSERT INTO dmarc_aggregate_records (
+		report_id, org_name, record_index, source_ip_address, source_country, source_city,
+		source_latitude, source_longitude, source_asn, source_asn_org, source_reverse_dns,
+		source_base_domain, source_name, source_type, count, spf_aligned,
+		dkim_aligned, dmarc_aligned, disposition, effective_policy, sampled_out,
+		policy_override_reasons, policy_override_comments, envelope_from, header_from,
+		envelope_to, dkim_domains, dkim_selectors, dkim_results, spf_domains, spf_scopes,
+		spf_results, begin_date
+	)`
+
+	forensicReportInsert = `
+	INSERT INTO dmarc_forensic_reports (
+		feedback_type, user_agent, version, original_envelope_id, original_mail_from,
+		original_rcpt_to, arrival_date, arrival_date_utc, subject, message_id,
+		authentication_results, parsed_authentication_results, dkim_domain,
+		source_ip_address, source_country, source_city, source_latitude,
+		source_longitude, source_asn, source_asn_org, source_reverse_dns,
+		source_base_domain, source_name, source_type, delivery_result, auth_failure,
+		auth_failure_raw, reported_domain, authentication_mechanisms,
+		authentication_mechanisms_raw, sample_headers_only, sample, parsed_sample,
+		parse_duration_ms, parser_version, source_transport
+	)`
+
+	smtpTLSReportInsert = `
+	INSERT INTO dmarc_smtp_tls_reports (
+		organization_name, begin_date, end_date, contact_info, report_id,
+		policy_domain, policy_type, policy_strings, mta_sts_policy, mx_host_patterns,
+		successful_session_count, failed_session_count, parse_duration_ms, parser_version,
+		source_transport
+	)`
+
+	smtpTLSFailureInsert = `
+	INSERT INTO dmarc_smtp_tls_failures (
+		report_id, organization_name, begin_date, policy_domain, failure_index, result_type,
+		failed_session_count, sending_mta_ip, receiving_ip, receiving_mx_hostname,
+		receiving_mx_helo, additional_info_uri, failure_reason_code
+	)`
+)
+
+// StoreAggregateReport stores an aggregate DMARC report in ClickHouse
+func (s *Storage) StoreAggregateReport(report *parser.AggregateReport) (err error) {
+	start := time.Now()
+	defer func() { s.recordInsert(parser.ReportTypeAggregate, start, err) }()
+
+	if err := s.checkDuplicate(parser.ReportTypeAggregate, report.ReportMetadata.OrgName, report.ReportMetadata.ReportID); err != nil {
+		return err
+	}
+
+	if err := s.insertAggregateReport(report); err != nil {
+		return err
+	}
+
+	// Store individual records
+	if err := s.insertAggregateRecords(report, 0, report.Records); err != nil {
+		return err
+	}
+
+	s.logger.Info("Stored aggregate report in ClickHouse",
+		zap.String("org", report.ReportMetadata.OrgName),
+		zap.String("report_id", report.ReportMetadata.ReportID),
+		zap.Int("records", len(report.Records)),
+	)
+
+	return nil
+}
+
+// StoreAggregateReportHeader stores an aggregate DMARC report without its
+// records, which are stored by StoreAggregateRecords. Like
+// StoreAggregateReport it skips a report already stored when deduplicate is
+// enabled, so that the caller skips its records too.
+func (s *Storage) StoreAggregateReportHeader(report *parser.AggregateReport) (err error) {
+	start := time.Now()
+	defer func() { s.recordInsert(parser.ReportTypeAggregate, start, err) }()
+
+	if err := s.checkDuplicate(parser.ReportTypeAggregate, report.ReportMetadata.OrgName, report.ReportMetadata.ReportID); err != nil {
+		return err
+	}
+
+	return s.insertAggregateReport(report)
+}
+
+// StoreAggregateRecords stores records of an aggregate DMARC report, the
+// first one at index offset
+func (s *Storage) StoreAggregateRecords(report *parser.AggregateReport, offset int, records []parser.Record) (err error) {
+	start := time.Now()
+	defer func() { s.recordInsert(parser.ReportTypeAggregate, start, err) }()
+
+	if err := s.insertAggregateRecords(report, offset, records); err != nil {
+		return err
+	}
+
+	s.logger.Debug("Stored aggregate records in ClickHouse",
+		zap.String("org", report.ReportMetadata.OrgName),
+		zap.String("report_id", report.ReportMetadata.ReportID),
+		zap.Int("offset", offset),
+		zap.Int("records", len(records)),
+	)
+
+	return nil
+}
+
+// insertAggregateReport inserts the dmarc_aggregate_reports row of report
+func (s *Storage) insertAggregateReport(report *parser.AggregateReport) error {
+	reportSQL := aggregateReportInsert + `
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	if err := s.conn.Exec(context.Background(), reportSQL, aggregateReportValues(report)...); err != nil {
+		return fmt.Errorf("failed to insert aggregate report: %w", err)
+	}
+	return nil
+}
+
+// insertAggregateRecords inserts records of report with one batch
+func (s *Storage) insertAggregateRecords(report *parser.AggregateReport, offset int, records []parser.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	batch, err := s.conn.PrepareBatch(context.Background(), aggregateRecordInsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+
+	if err := s.appendAggregateRecords(batch, report, offset, records); err != nil {
+		return err
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+	return nil
+}
+
+// StoreAggregateReports stores several aggregate reports with one INSERT for
+// the reports and one for all their records, so that a batch creates a
+// single part per table
+func (s *Storage) StoreAggregateReports(reports []*parser.AggregateReport) (err error) {
+	start := time.Now()
+	defer func() { s.recordInsert(parser.ReportTypeAggregate, start, err) }()
+
+	ctx := context.Background()
+
+	if s.config.Deduplicate {
+		// Reports already stored or earlier in the batch are skipped
+		seen := make(map[[2]string]bool, len(reports))
+		unique := make([]*parser.AggregateReport, 0, len(reports))
+		for _, report := range reports {
+			metadata := report.ReportMetadata
+			key := [2]string{metadata.OrgName, metadata.ReportID}
+			if seen[key] {
+				s.logger.Debug("Skipping aggregate report repeated in the batch",
+					zap.String("org", metadata.OrgName),
+					zap.String("report_id", metadata.ReportID),
+				)
+				continue
+			}
+			seen[key] = true
+
+			err := s.checkDuplicate(parser.ReportTypeAggregate, metadata.OrgName, metadata.ReportID)
+			if errors.Is(err, parser.ErrDuplicateReport) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			unique = append(unique, report)
+		}
+		if len(unique) == 0 {
+			return nil
+		}
+		reports = unique
+	}
+
+	reportBatch, err := s.conn.PrepareBatch(ctx, aggregateReportInsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+	recordBatch, err := s.conn.PrepareBatch(ctx, aggregateRecordInsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
 
-	err := s.conn.Exec(ctx, reportSQL,
+	records := 0
+	for _, report := range reports {
+		if err := reportBatch.Append(aggregateReportValues(report)...); err != nil {
+			return fmt.Errorf("failed to append report to batch: %w", err)
+		}
+		if err := s.appendAggregateRecords(recordBatch, report, 0, report.Records); err != nil {
+			return err
+		}
+		records += len(report.Records)
+	}
+
+	if err := reportBatch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+	if records > 0 {
+		if err := recordBatch.Send(); err != nil {
+			return fmt.Errorf("failed to send batch: %w", err)
+		}
+	}
+
+	s.logger.Info("Stored aggregate reports in ClickHouse",
+		zap.Int("reports", len(reports)),
+		zap.Int("records", records),
+	)
+
+	return nil
+}
+
+// aggregateReportValues returns the dmarc_aggregate_reports column values of
+// report, in insert order
+func aggregateReportValues(report *parser.AggregateReport) []any {
+	return []any{
 		report.XMLSchema,
 		report.ReportMetadata.OrgName,
 		report.ReportMetadata.OrgEmail,
@@ -261,119 +910,182 @@ func (s *Storage) StoreAggregateReport(report *parser.AggregateReport) error {
 		report.PolicyPublished.P,
 		report.PolicyPublished.SP,
 		report.PolicyPublished.PCT,
+		uint8(report.PolicyPublished.PCTValue),
 		report.PolicyPublished.FO,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to insert aggregate report: %w", err)
+		report.Fingerprint,
+		uint32(report.ParseDurationMS),
+		report.ParserVersion,
+		report.SourceTransport,
 	}
+}
 
-	// Store individual records
-	if len(report.Records) > 0 {
-		batch, err := s.conn.PrepareBatch(ctx, `
-		INSERT INTO dmarc_aggregate_records (
-			report_id, org_name, source_ip_address, source_country, source_reverse_dns,
-			source_base_domain, source_name, source_type, count, spf_aligned,
-			dkim_aligned, dmarc_aligned, disposition, policy_override_reasons,
-			policy_override_comments, envelope_from, header_from, envelope_to,
-			dkim_domains, dkim_selectors, dkim_results, spf_domains, spf_scopes,
-			spf_results, begin_date
-		)`)
-		if err != nil {
-			return fmt.Errorf("failed to prepare batch: %w", err)
-		}
-
-		for _, record := range report.Records {
-			// Convert policy override reasons
-			var reasons, comments []string
-			for _, reason := range record.PolicyEvaluated.PolicyOverrideReasons {
-				if reason.Type != nil {
-					reasons = append(reasons, *reason.Type)
-				} else {
-					reasons = append(reasons, "none")
-				}
-				if reason.Comment != nil {
-					comments = append(comments, *reason.Comment)
-				} else {
-					comments = append(comments, "none")
-				}
-			}
+// appendAggregateRecords appends records of report to a batch prepared with
+// aggregateRecordInsert, the first one at index offset
+func (s *Storage) appendAggregateRecords(batch driver.Batch, report *parser.AggregateReport, offset int, records []parser.Record) error {
+	for j, record := range records {
+		i := offset + j
 
-			// Convert auth results
-			var dkimDomains, dkimSelectors, dkimResults []string
-			for _, dkim := range record.AuthResults.DKIM {
-				dkimDomains = append(dkimDomains, dkim.Domain)
-				dkimSelectors = append(dkimSelectors, dkim.Selector)
-				dkimResults = append(dkimResults, dkim.Result)
+		// Convert policy override reasons
+		var reasons, comments []string
+		for _, reason := range record.PolicyEvaluated.PolicyOverrideReasons {
+			if reason.Type != nil {
+				reasons = append(reasons, *reason.Type)
+			} else {
+				reasons = append(reasons, "none")
 			}
-
-			var spfDomains, spfScopes, spfResults []string
-			for _, spf := range record.AuthResults.SPF {
-				spfDomains = append(spfDomains, spf.Domain)
-				spfScopes = append(spfScopes, spf.Scope)
-				spfResults = append(spfResults, spf.Result)
+			if reason.Comment != nil {
+				comments = append(comments, *reason.Comment)
+			} else {
+				comments = append(comments, "none")
 			}
+		}
 
-			err := batch.Append(
-				report.ReportMetadata.ReportID,
-				report.ReportMetadata.OrgName,
-				record.Source.IPAddress,
-				record.Source.Country,
-				record.Source.ReverseDNS,
-				record.Source.BaseDomain,
-				record.Source.Name,
-				record.Source.Type,
-				record.Count,
-				boolToUint8(record.Alignment.SPF),
-				boolToUint8(record.Alignment.DKIM),
-				boolToUint8(record.Alignment.DMARC),
-				record.PolicyEvaluated.Disposition,
-				reasons,
-				comments,
-				record.Identifiers.EnvelopeFrom,
-				record.Identifiers.HeaderFrom,
-				record.Identifiers.EnvelopeTo,
-				dkimDomains,
-				dkimSelectors,
-				dkimResults,
-				spfDomains,
-				spfScopes,
-				spfResults,
-				report.ReportMetadata.BeginDate,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to append record to batch: %w", err)
-			}
+		// Convert auth results
+		authResults := s.capAuthResults(report, i, record.AuthResults)
+
+		var dkimDomains, dkimSelectors, dkimResults []string
+		for _, dkim := range authResults.DKIM {
+			dkimDomains = append(dkimDomains, dkim.Domain)
+			dkimSelectors = append(dkimSelectors, dkim.Selector)
+			dkimResults = append(dkimResults, dkim.Result)
 		}
 
-		if err := batch.Send(); err != nil {
-			return fmt.Errorf("failed to send batch: %w", err)
+		var spfDomains, spfScopes, spfResults []string
+		for _, spf := range authResults.SPF {
+			spfDomains = append(spfDomains, spf.Domain)
+			spfScopes = append(spfScopes, spf.Scope)
+			spfResults = append(spfResults, spf.Result)
+		}
+
+		err := batch.Append(
+			report.ReportMetadata.ReportID,
+			report.ReportMetadata.OrgName,
+			uint32(i),
+			record.Source.IPAddress,
+			record.Source.Country,
+			record.Source.City,
+			record.Source.Latitude,
+			record.Source.Longitude,
+			uint32(record.Source.ASN),
+			record.Source.ASNOrg,
+			record.Source.ReverseDNS,
+			record.Source.BaseDomain,
+			record.Source.Name,
+			record.Source.Type,
+			record.Count,
+			boolToUint8(record.Alignment.SPF),
+			boolToUint8(record.Alignment.DKIM),
+			boolToUint8(record.Alignment.DMARC),
+			record.PolicyEvaluated.Disposition,
+			record.EffectivePolicy,
+			boolToUint8(record.SampledOut),
+			reasons,
+			comments,
+			record.Identifiers.EnvelopeFrom,
+			record.Identifiers.HeaderFrom,
+			record.Identifiers.EnvelopeTo,
+			dkimDomains,
+			dkimSelectors,
+			dkimResults,
+			spfDomains,
+			spfScopes,
+			spfResults,
+			report.ReportMetadata.BeginDate,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to append record to batch: %w", err)
 		}
 	}
+	return nil
+}
 
-	s.logger.Info("Stored aggregate report in ClickHouse",
+// capAuthResults truncates the DKIM and SPF results of a record to
+// max_auth_results, so that a record with thousands of results does not
+// produce array columns too large to insert
+func (s *Storage) capAuthResults(report *parser.AggregateReport, index int, results parser.AuthResults) parser.AuthResults {
+	limit := s.config.MaxAuthResults
+	if limit <= 0 || (len(results.DKIM) <= limit && len(results.SPF) <= limit) {
+		return results
+	}
+
+	s.logger.Warn("Truncating auth results of aggregate record",
 		zap.String("org", report.ReportMetadata.OrgName),
 		zap.String("report_id", report.ReportMetadata.ReportID),
-		zap.Int("records", len(report.Records)),
+		zap.Int("record_index", index),
+		zap.Int("dkim_results", len(results.DKIM)),
+		zap.Int("spf_results", len(results.SPF)),
+		zap.Int("max_auth_results", limit),
 	)
 
-	return nil
+	if len(results.DKIM) > limit {
+		results.DKIM = results.DKIM[:limit]
+	}
+	if len(results.SPF) > limit {
+		results.SPF = results.SPF[:limit]
+	}
+	return results
 }
 
 // StoreForensicReport stores a forensic DMARC report in ClickHouse
-func (s *Storage) StoreForensicReport(report *parser.ForensicReport) error {
+func (s *Storage) StoreForensicReport(report *parser.ForensicReport) (err error) {
+	start := time.Now()
+	defer func() { s.recordInsert(parser.ReportTypeForensic, start, err) }()
+
 	ctx := context.Background()
 
-	reportSQL := `
-	INSERT INTO dmarc_forensic_reports (
-		feedback_type, user_agent, version, original_envelope_id, original_mail_from,
-		original_rcpt_to, arrival_date, arrival_date_utc, subject, message_id,
-		authentication_results, dkim_domain, source_ip_address, source_country,
-		source_reverse_dns, source_base_domain, source_name, source_type,
-		delivery_result, auth_failure, reported_domain, authentication_mechanisms,
-		sample_headers_only, sample, parsed_sample
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	// Without a Message-ID there is no natural key to look up
+	if report.MessageID != "" {
+		if err := s.checkDuplicate(parser.ReportTypeForensic, "", report.MessageID); err != nil {
+			return err
+		}
+	}
+
+	values, err := forensicReportValues(report)
+	if err != nil {
+		return err
+	}
+	if s.config.BatchSize > 0 {
+		if err := s.bufferRows(rowBuffer{forensic: [][]any{values}}); err != nil {
+			return err
+		}
+
+		s.logger.Debug("Buffered forensic report for ClickHouse",
+			zap.String("subject", report.Subject),
+			zap.String("source_ip", report.Source.IPAddress),
+		)
+		return nil
+	}
+
+	reportSQL := forensicReportInsert + `
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+		?, ?, ?, ?, ?, ?)`
+
+	if err := s.conn.Exec(ctx, reportSQL, values...); err != nil {
+		return fmt.Errorf("failed to insert forensic report: %w", err)
+	}
 
-	err := s.conn.Exec(ctx, reportSQL,
+	s.logger.Info("Stored forensic report in ClickHouse",
+		zap.String("subject", report.Subject),
+		zap.String("source_ip", report.Source.IPAddress),
+	)
+
+	return nil
+}
+
+// forensicReportValues returns the dmarc_forensic_reports column values of
+// report, in insert order
+func forensicReportValues(report *parser.ForensicReport) ([]any, error) {
+	// Stored as JSON text, empty when there are no parsed results
+	var authResults string
+	if len(report.ParsedAuthenticationResults) > 0 {
+		data, err := json.Marshal(report.ParsedAuthenticationResults)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal authentication results: %w", err)
+		}
+		authResults = string(data)
+	}
+
+	return []any{
 		report.FeedbackType,
 		report.UserAgent,
 		report.Version,
@@ -385,48 +1097,109 @@ func (s *Storage) StoreForensicReport(report *parser.ForensicReport) error {
 		report.Subject,
 		report.MessageID,
 		report.AuthenticationResults,
+		authResults,
 		report.DKIMDomain,
 		report.Source.IPAddress,
 		report.Source.Country,
+		report.Source.City,
+		report.Source.Latitude,
+		report.Source.Longitude,
+		uint32(report.Source.ASN),
+		report.Source.ASNOrg,
 		report.Source.ReverseDNS,
 		report.Source.BaseDomain,
 		report.Source.Name,
 		report.Source.Type,
 		report.DeliveryResult,
 		report.AuthFailure,
+		report.AuthFailureRaw,
 		report.ReportedDomain,
 		report.AuthenticationMechanisms,
+		report.AuthenticationMechanismsRaw,
 		boolToUint8(report.SampleHeadersOnly),
 		report.Sample,
 		string(report.ParsedSample),
-	)
+		uint32(report.ParseDurationMS),
+		report.ParserVersion,
+		report.SourceTransport,
+	}, nil
+}
+
+// StoreSMTPTLSReport stores an SMTP TLS report in ClickHouse
+func (s *Storage) StoreSMTPTLSReport(report *parser.SMTPTLSReport) (err error) {
+	start := time.Now()
+	defer func() { s.recordInsert(parser.ReportTypeSMTPTLS, start, err) }()
+
+	if err := s.checkDuplicate(parser.ReportTypeSMTPTLS, report.OrganizationName, report.ReportID); err != nil {
+		return err
+	}
+
+	values, err := smtpTLSReportValues(report)
 	if err != nil {
-		return fmt.Errorf("failed to insert forensic report: %w", err)
+		return err
 	}
 
-	s.logger.Info("Stored forensic report in ClickHouse",
-		zap.String("subject", report.Subject),
-		zap.String("source_ip", report.Source.IPAddress),
+	// Insert failure details for all policies
+	var failureRows [][]any
+	for _, policy := range report.Policies {
+		for i, failure := range policy.FailureDetails {
+			failureRows = append(failureRows, []any{
+				report.ReportID,
+				report.OrganizationName,
+				report.BeginDate,
+				policy.PolicyDomain,
+				uint32(i),
+				failure.ResultType,
+				failure.FailedSessionCount,
+				failure.SendingMTAIP,
+				failure.ReceivingIP,
+				failure.ReceivingMXHostname,
+				failure.ReceivingMXHelo,
+				failure.AdditionalInfoURI,
+				failure.FailureReasonCode,
+			})
+		}
+	}
+
+	// The report row is buffered with its failure rows so that a flush
+	// inserts the whole report
+	if s.config.BatchSize > 0 {
+		if err := s.bufferRows(rowBuffer{smtpTLSReports: [][]any{values}, smtpTLSFailures: failureRows}); err != nil {
+			return err
+		}
+
+		s.logger.Debug("Buffered SMTP TLS report for ClickHouse",
+			zap.String("org", report.OrganizationName),
+			zap.String("report_id", report.ReportID),
+		)
+		return nil
+	}
+
+	reportSQL := smtpTLSReportInsert + `
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	if err := s.conn.Exec(context.Background(), reportSQL, values...); err != nil {
+		return fmt.Errorf("failed to insert SMTP TLS report: %w", err)
+	}
+	if err := s.sendBatch(smtpTLSFailureInsert, failureRows); err != nil {
+		return fmt.Errorf("failed to insert SMTP TLS failure details: %w", err)
+	}
+
+	s.logger.Info("Stored SMTP TLS report in ClickHouse",
+		zap.String("org", report.OrganizationName),
+		zap.String("report_id", report.ReportID),
+		zap.Int("policies", len(report.Policies)),
 	)
 
 	return nil
 }
 
-// StoreSMTPTLSReport stores an SMTP TLS report in ClickHouse
-func (s *Storage) StoreSMTPTLSReport(report *parser.SMTPTLSReport) error {
-	ctx := context.Background()
-
-	// Insert main report
-	reportSQL := `
-	INSERT INTO dmarc_smtp_tls_reports (
-		organization_name, begin_date, end_date, contact_info, report_id,
-		policy_domain, policy_type, policy_strings, mx_host_patterns,
-		successful_session_count, failed_session_count
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
+// smtpTLSReportValues returns the dmarc_smtp_tls_reports column values of
+// report, in insert order
+func smtpTLSReportValues(report *parser.SMTPTLSReport) ([]any, error) {
 	// For simplicity, we'll store the first policy's data in the main table
 	// In a production system, you might want separate tables for policies
-	var policyDomain, policyType string
+	var policyDomain, policyType, mtaSTSPolicy string
 	var policyStrings, mxHostPatterns []string
 	var successfulCount, failedCount int
 
@@ -438,9 +1211,18 @@ func (s *Storage) StoreSMTPTLSReport(report *parser.SMTPTLSReport) error {
 		mxHostPatterns = policy.MXHostPatterns
 		successfulCount = policy.SuccessfulSessionCount
 		failedCount = policy.FailedSessionCount
+
+		// Stored as JSON text, empty when the policy is not MTA-STS
+		if policy.MTASTSPolicy != nil {
+			data, err := json.Marshal(policy.MTASTSPolicy)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal MTA-STS policy: %w", err)
+			}
+			mtaSTSPolicy = string(data)
+		}
 	}
 
-	err := s.conn.Exec(ctx, reportSQL,
+	return []any{
 		report.OrganizationName,
 		report.BeginDate,
 		report.EndDate,
@@ -449,53 +1231,424 @@ func (s *Storage) StoreSMTPTLSReport(report *parser.SMTPTLSReport) error {
 		policyDomain,
 		policyType,
 		policyStrings,
+		mtaSTSPolicy,
 		mxHostPatterns,
 		successfulCount,
 		failedCount,
+		uint32(report.ParseDurationMS),
+		report.ParserVersion,
+		report.SourceTransport,
+	}, nil
+}
+
+// StoreUnparsedReport records metadata about input that could not be parsed
+func (s *Storage) StoreUnparsedReport(report *parser.UnparsedReport) error {
+	insertSQL := `
+	INSERT INTO dmarc_unparsed_reports (
+		received_at, source, size, content_type, reason, error, preview
+	) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	err := s.conn.Exec(context.Background(), insertSQL,
+		report.ReceivedAt,
+		report.Source,
+		uint64(report.Size),
+		report.ContentType,
+		report.Reason,
+		report.Error,
+		report.Preview,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to insert SMTP TLS report: %w", err)
+		return fmt.Errorf("failed to insert unparsed report: %w", err)
 	}
 
-	// Insert failure details for all policies
-	if len(report.Policies) > 0 {
-		failureSQL := `
-		INSERT INTO dmarc_smtp_tls_failures (
-			report_id, policy_domain, result_type, failed_session_count,
-			sending_mta_ip, receiving_ip, receiving_mx_hostname, receiving_mx_helo,
-			additional_info_uri, failure_reason_code
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
-		for _, policy := range report.Policies {
-			for _, failure := range policy.FailureDetails {
-				err := s.conn.Exec(ctx, failureSQL,
-					report.ReportID,
-					policy.PolicyDomain,
-					failure.ResultType,
-					failure.FailedSessionCount,
-					failure.SendingMTAIP,
-					failure.ReceivingIP,
-					failure.ReceivingMXHostname,
-					failure.ReceivingMXHelo,
-					failure.AdditionalInfoURI,
-					failure.FailureReasonCode,
-				)
-				if err != nil {
-					return fmt.Errorf("failed to insert SMTP TLS failure detail: %w", err)
-				}
-			}
+	s.logger.Debug("Stored unparsed report metadata in ClickHouse",
+		zap.String("source", report.Source),
+		zap.String("reason", report.Reason),
+		zap.Int("size", report.Size),
+	)
+
+	return nil
+}
+
+// recordInsert records the duration and the outcome of a store started at
+// start. Storages built without metrics, and reports skipped as duplicates,
+// record nothing.
+func (s *Storage) recordInsert(reportType string, start time.Time, err error) {
+	if s.metrics != nil && !errors.Is(err, parser.ErrDuplicateReport) {
+		s.metrics.RecordInsert("clickhouse", reportType, time.Since(start).Seconds(), err == nil)
+	}
+}
+
+// checkDuplicate returns an error wrapping parser.ErrDuplicateReport when
+// deduplicate is enabled and ReportExists finds the report
+func (s *Storage) checkDuplicate(reportType, orgName, reportID string) error {
+	if !s.config.Deduplicate {
+		return nil
+	}
+
+	exists, err := s.ReportExists(reportType, orgName, reportID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		s.logger.Debug("Skipping report already stored in ClickHouse",
+			zap.String("type", reportType),
+			zap.String("org", orgName),
+			zap.String("report_id", reportID),
+		)
+		return fmt.Errorf("%s report %s: %w", reportType, reportID, parser.ErrDuplicateReport)
+	}
+	return nil
+}
+
+// reportTable is a table holding rows of a report type, with the column of
+// the reporting organization and the column of the report ID
+type reportTable struct {
+	name      string
+	orgColumn string
+	idColumn  string
+}
+
+// condition returns the WHERE condition matching the rows of a report and
+// its arguments. Tables without an organization column, such as forensic
+// reports keyed by Message-ID, match on the report ID alone.
+func (t reportTable) condition(orgName, reportID string) (string, []any) {
+	if t.orgColumn == "" {
+		return t.idColumn + " = ?", []any{reportID}
+	}
+	return t.orgColumn + " = ? AND " + t.idColumn + " = ?", []any{orgName, reportID}
+}
+
+// reportTables lists the tables holding rows of each report type, the
+// report table first
+var reportTables = map[string][]reportTable{
+	"aggregate": {
+		{"dmarc_aggregate_reports", "org_name", "report_id"},
+		{"dmarc_aggregate_records", "org_name", "report_id"},
+	},
+	"forensic": {
+		{"dmarc_forensic_reports", "", "message_id"},
+	},
+	"smtp_tls": {
+		{"dmarc_smtp_tls_reports", "organization_name", "report_id"},
+		{"dmarc_smtp_tls_failures", "organization_name", "report_id"},
+	},
+}
+
+// ReportExists reports whether a report of the organization with the given ID
+// is already stored or buffered for insertion. Report IDs are only unique per
+// organization; forensic reports are looked up by Message-ID.
+func (s *Storage) ReportExists(reportType, orgName, reportID string) (bool, error) {
+	tables, ok := reportTables[reportType]
+	if !ok {
+		return false, fmt.Errorf("unsupported report type: %s", reportType)
+	}
+
+	s.bufferMu.Lock()
+	buffered := s.buffer.has(reportType, orgName, reportID)
+	s.bufferMu.Unlock()
+	if buffered {
+		return true, nil
+	}
+
+	var count uint64
+	condition, args := tables[0].condition(orgName, reportID)
+	query := fmt.Sprintf("SELECT count() FROM %s WHERE %s", tables[0].name, condition)
+	if err := s.conn.QueryRow(context.Background(), query, args...).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to look up %s report: %w", reportType, err)
+	}
+
+	return count > 0, nil
+}
+
+// FingerprintExists reports whether a report with the given fingerprint is
+// already stored. Only aggregate reports carry a fingerprint.
+func (s *Storage) FingerprintExists(reportType, fingerprint string) (bool, error) {
+	if reportType != "aggregate" {
+		return false, fmt.Errorf("unsupported report type: %s", reportType)
+	}
+
+	var count uint64
+	query := "SELECT count() FROM dmarc_aggregate_reports WHERE fingerprint = ?"
+	if err := s.conn.QueryRow(context.Background(), query, fingerprint).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to look up %s report: %w", reportType, err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteReport removes every row of the report of the organization with the
+// given ID, buffered rows included. The mutation runs synchronously so that a
+// report inserted right after is not deleted with it.
+func (s *Storage) DeleteReport(reportType, orgName, reportID string) error {
+	tables, ok := reportTables[reportType]
+	if !ok {
+		return fmt.Errorf("unsupported report type: %s", reportType)
+	}
+
+	s.bufferMu.Lock()
+	s.buffer.remove(reportType, orgName, reportID)
+	s.bufferMu.Unlock()
+
+	ctx := clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+		"mutations_sync": 1,
+	}))
+
+	for _, table := range tables {
+		condition, args := table.condition(orgName, reportID)
+		query := fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s", table.name, condition)
+		if err := s.conn.Exec(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to delete from %s: %w", table.name, err)
 		}
 	}
 
-	s.logger.Info("Stored SMTP TLS report in ClickHouse",
-		zap.String("org", report.OrganizationName),
-		zap.String("report_id", report.ReportID),
-		zap.Int("policies", len(report.Policies)),
+	s.logger.Info("Deleted report from ClickHouse",
+		zap.String("type", reportType),
+		zap.String("org", orgName),
+		zap.String("report_id", reportID),
 	)
 
 	return nil
 }
 
+// aggregateReportQuery builds the SELECT for QueryAggregateReports. In
+// "replace" mode FINAL collapses copies that have not been merged yet.
+func (s *Storage) aggregateReportQuery(filter parser.AggregateReportFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Domain != "" {
+		conditions = append(conditions, "domain = ?")
+		args = append(args, filter.Domain)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "begin_date >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "begin_date < ?")
+		args = append(args, filter.To)
+	}
+
+	final := ""
+	if s.config.DuplicateHandling == DuplicateReplace {
+		final = " FINAL"
+	}
+
+	query := `
+	SELECT xml_schema, org_name, org_email, org_extra_contact_info, report_id,
+		begin_date, end_date, errors, domain, adkim, aspf, p, sp, pct, pct_value, fo
+	FROM dmarc_aggregate_reports` + final
+
+	if len(conditions) > 0 {
+		query += "\n\tWHERE " + strings.Join(conditions, " AND ")
+	}
+	query += "\n\tORDER BY begin_date DESC, org_name, report_id"
+
+	if filter.Limit > 0 {
+		query += "\n\tLIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	} else if filter.Offset > 0 {
+		query += "\n\tOFFSET ?"
+		args = append(args, filter.Offset)
+	}
+
+	return query, args
+}
+
+// QueryAggregateReports returns the stored aggregate reports matching filter,
+// with their records. Policy evaluated DKIM and SPF results are not stored
+// and are left empty.
+func (s *Storage) QueryAggregateReports(filter parser.AggregateReportFilter) ([]*parser.AggregateReport, error) {
+	ctx := context.Background()
+
+	query, args := s.aggregateReportQuery(filter)
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query aggregate reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []*parser.AggregateReport
+	for rows.Next() {
+		var report parser.AggregateReport
+		var pctValue uint8
+		err := rows.Scan(
+			&report.XMLSchema,
+			&report.ReportMetadata.OrgName,
+			&report.ReportMetadata.OrgEmail,
+			&report.ReportMetadata.OrgExtraContactInfo,
+			&report.ReportMetadata.ReportID,
+			&report.ReportMetadata.BeginDate,
+			&report.ReportMetadata.EndDate,
+			&report.ReportMetadata.Errors,
+			&report.PolicyPublished.Domain,
+			&report.PolicyPublished.ADKIM,
+			&report.PolicyPublished.ASPF,
+			&report.PolicyPublished.P,
+			&report.PolicyPublished.SP,
+			&report.PolicyPublished.PCT,
+			&pctValue,
+			&report.PolicyPublished.FO,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan aggregate report: %w", err)
+		}
+		report.PolicyPublished.PCTValue = int(pctValue)
+		reports = append(reports, &report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read aggregate reports: %w", err)
+	}
+
+	if len(reports) > 0 {
+		if err := s.loadAggregateRecords(ctx, reports); err != nil {
+			return nil, err
+		}
+	}
+
+	return reports, nil
+}
+
+// loadAggregateRecords fills in the records of reports
+func (s *Storage) loadAggregateRecords(ctx context.Context, reports []*parser.AggregateReport) error {
+	type reportKey struct{ orgName, reportID string }
+
+	byKey := make(map[reportKey]*parser.AggregateReport, len(reports))
+	reportIDs := make([]string, 0, len(reports))
+	for _, report := range reports {
+		byKey[reportKey{report.ReportMetadata.OrgName, report.ReportMetadata.ReportID}] = report
+		reportIDs = append(reportIDs, report.ReportMetadata.ReportID)
+	}
+
+	final := ""
+	if s.config.DuplicateHandling == DuplicateReplace {
+		final = " FINAL"
+	}
+
+	rows, err := s.conn.Query(ctx, `
+	SELECT org_name, report_id, source_ip_address, source_country, source_city,
+		source_latitude, source_longitude, source_asn, source_asn_org, source_reverse_dns,
+		source_base_domain, source_name, source_type, count, spf_aligned,
+		dkim_aligned, dmarc_aligned, disposition, effective_policy, sampled_out,
+		policy_override_reasons, policy_override_comments, envelope_from, header_from,
+		envelope_to, dkim_domains, dkim_selectors, dkim_results, spf_domains, spf_scopes,
+		spf_results
+	FROM dmarc_aggregate_records`+final+`
+	WHERE has(?, report_id)
+	ORDER BY org_name, report_id, record_index`, reportIDs)
+	if err != nil {
+		return fmt.Errorf("failed to query aggregate records: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key                                     reportKey
+			record                                  parser.Record
+			count, asn                              uint32
+			spfAligned, dkimAligned, dmarcAligned   uint8
+			sampledOut                              uint8
+			reasons, comments                       []string
+			dkimDomains, dkimSelectors, dkimResults []string
+			spfDomains, spfScopes, spfResults       []string
+		)
+		err := rows.Scan(
+			&key.orgName,
+			&key.reportID,
+			&record.Source.IPAddress,
+			&record.Source.Country,
+			&record.Source.City,
+			&record.Source.Latitude,
+			&record.Source.Longitude,
+			&asn,
+			&record.Source.ASNOrg,
+			&record.Source.ReverseDNS,
+			&record.Source.BaseDomain,
+			&record.Source.Name,
+			&record.Source.Type,
+			&count,
+			&spfAligned,
+			&dkimAligned,
+			&dmarcAligned,
+			&record.PolicyEvaluated.Disposition,
+			&record.EffectivePolicy,
+			&sampledOut,
+			&reasons,
+			&comments,
+			&record.Identifiers.EnvelopeFrom,
+			&record.Identifiers.HeaderFrom,
+			&record.Identifiers.EnvelopeTo,
+			&dkimDomains,
+			&dkimSelectors,
+			&dkimResults,
+			&spfDomains,
+			&spfScopes,
+			&spfResults,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to scan aggregate record: %w", err)
+		}
+
+		report, ok := byKey[key]
+		if !ok {
+			// Same report ID from another organization
+			continue
+		}
+
+		record.Count = int(count)
+		record.Source.ASN = uint(asn)
+		record.Alignment = parser.Alignment{
+			SPF:   spfAligned == 1,
+			DKIM:  dkimAligned == 1,
+			DMARC: dmarcAligned == 1,
+		}
+		record.SampledOut = sampledOut == 1
+
+		// StoreAggregateReport writes "none" for missing override fields
+		for i := range reasons {
+			var reason parser.PolicyOverrideReason
+			if reasons[i] != "none" {
+				reason.Type = &reasons[i]
+			}
+			if i < len(comments) && comments[i] != "none" {
+				reason.Comment = &comments[i]
+			}
+			record.PolicyEvaluated.PolicyOverrideReasons = append(record.PolicyEvaluated.PolicyOverrideReasons, reason)
+		}
+
+		for i := range dkimDomains {
+			record.AuthResults.DKIM = append(record.AuthResults.DKIM, parser.DKIMResult{
+				Domain:   dkimDomains[i],
+				Selector: elementAt(dkimSelectors, i),
+				Result:   elementAt(dkimResults, i),
+			})
+		}
+		for i := range spfDomains {
+			record.AuthResults.SPF = append(record.AuthResults.SPF, parser.SPFResult{
+				Domain: spfDomains[i],
+				Scope:  elementAt(spfScopes, i),
+				Result: elementAt(spfResults, i),
+			})
+		}
+
+		report.Records = append(report.Records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read aggregate records: %w", err)
+	}
+
+	return nil
+}
+
+// elementAt returns values[i], or "" when values is too short
+func elementAt(values []string, i int) string {
+	if i < len(values) {
+		return values[i]
+	}
+	return ""
+}
+
 // boolToUint8 converts boolean to uint8 for ClickHouse
 func boolToUint8(b bool) uint8 {
 	if b {
@@ -1,11 +1,24 @@
 package clickhouse
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
 	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/metrics"
 	"parsedmarc-go/internal/parser"
 )
 
@@ -225,3 +238,914 @@ func BenchmarkPrepareAggregateReport(b *testing.B) {
 		_ = len(report.Records)
 	}
 }
+
+// fakeConn records statements issued through the driver.Conn interface
+type fakeConn struct {
+	driver.Conn
+
+	mu      sync.Mutex
+	execs   []fakeExec
+	batches []*fakeBatch
+	queries []fakeExec
+	count   uint64 // returned by every QueryRow
+	pingErr error
+	execErr error // returned by every Exec
+}
+
+type fakeExec struct {
+	query string
+	args  []interface{}
+}
+
+func (c *fakeConn) Exec(_ context.Context, query string, args ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.execs = append(c.execs, fakeExec{query: query, args: args})
+	return c.execErr
+}
+
+func (c *fakeConn) PrepareBatch(_ context.Context, query string, _ ...driver.PrepareBatchOption) (driver.Batch, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	batch := &fakeBatch{query: query}
+	c.batches = append(c.batches, batch)
+	return batch, nil
+}
+
+func (c *fakeConn) QueryRow(_ context.Context, query string, args ...interface{}) driver.Row {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queries = append(c.queries, fakeExec{query: query, args: args})
+	return &fakeRow{count: c.count}
+}
+
+func (c *fakeConn) Ping(context.Context) error {
+	return c.pingErr
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+// fakeBatch records rows appended to a batch
+type fakeBatch struct {
+	driver.Batch
+
+	query string
+	rows  [][]interface{}
+	sent  bool
+}
+
+func (b *fakeBatch) Append(v ...interface{}) error {
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *fakeBatch) Send() error {
+	b.sent = true
+	return nil
+}
+
+// fakeRow is a single count() result
+type fakeRow struct {
+	driver.Row
+
+	count uint64
+}
+
+func (r *fakeRow) Scan(dest ...interface{}) error {
+	*dest[0].(*uint64) = r.count
+	return nil
+}
+
+func TestClickHouse_Deduplicate(t *testing.T) {
+	aggregate := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "google.com", ReportID: "report-1"},
+		Records:        []parser.Record{{Source: parser.Source{IPAddress: "192.0.2.1"}, Count: 1}},
+	}
+	forensic := &parser.ForensicReport{MessageID: "<msg-1@example.com>"}
+	smtpTLS := &parser.SMTPTLSReport{OrganizationName: "Company-X", ReportID: "tls-1"}
+
+	store := func(t *testing.T, storage *Storage, want error) {
+		t.Helper()
+		if err := storage.StoreAggregateReport(aggregate); !errors.Is(err, want) {
+			t.Fatalf("StoreAggregateReport returned %v, want %v", err, want)
+		}
+		if err := storage.StoreForensicReport(forensic); !errors.Is(err, want) {
+			t.Fatalf("StoreForensicReport returned %v, want %v", err, want)
+		}
+		if err := storage.StoreSMTPTLSReport(smtpTLS); !errors.Is(err, want) {
+			t.Fatalf("StoreSMTPTLSReport returned %v, want %v", err, want)
+		}
+	}
+
+	expectedQueries := []fakeExec{
+		{
+			query: "SELECT count() FROM dmarc_aggregate_reports WHERE org_name = ? AND report_id = ?",
+			args:  []interface{}{"google.com", "report-1"},
+		},
+		{
+			query: "SELECT count() FROM dmarc_forensic_reports WHERE message_id = ?",
+			args:  []interface{}{"<msg-1@example.com>"},
+		},
+		{
+			query: "SELECT count() FROM dmarc_smtp_tls_reports WHERE organization_name = ? AND report_id = ?",
+			args:  []interface{}{"Company-X", "tls-1"},
+		},
+	}
+
+	t.Run("Already stored", func(t *testing.T) {
+		conn := &fakeConn{count: 1}
+		storage := &Storage{
+			conn:   conn,
+			config: config.ClickHouseConfig{Deduplicate: true},
+			logger: zaptest.NewLogger(t),
+		}
+		store(t, storage, parser.ErrDuplicateReport)
+
+		if !reflect.DeepEqual(conn.queries, expectedQueries) {
+			t.Errorf("Unexpected existence checks:\n%v\nwant\n%v", conn.queries, expectedQueries)
+		}
+		if len(conn.execs) != 0 || len(conn.batches) != 0 {
+			t.Errorf("Expected no inserts, got %d statements and %d batches", len(conn.execs), len(conn.batches))
+		}
+	})
+
+	t.Run("Not stored yet", func(t *testing.T) {
+		conn := &fakeConn{}
+		storage := &Storage{
+			conn:   conn,
+			config: config.ClickHouseConfig{Deduplicate: true},
+			logger: zaptest.NewLogger(t),
+		}
+		store(t, storage, nil)
+
+		if len(conn.queries) != 3 {
+			t.Errorf("Expected 3 existence checks, got %d", len(conn.queries))
+		}
+		if len(conn.execs) != 3 || len(conn.batches) != 1 {
+			t.Errorf("Expected every report to be inserted, got %d statements and %d batches", len(conn.execs), len(conn.batches))
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		conn := &fakeConn{count: 1}
+		storage := &Storage{
+			conn:   conn,
+			logger: zaptest.NewLogger(t),
+		}
+		store(t, storage, nil)
+
+		if len(conn.queries) != 0 {
+			t.Errorf("Expected no existence checks, got %d", len(conn.queries))
+		}
+		if len(conn.execs) != 3 {
+			t.Errorf("Expected every report to be inserted, got %d statements", len(conn.execs))
+		}
+	})
+}
+
+func TestClickHouse_CreateTablesDuplicateHandling(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		expected string
+		forensic string
+	}{
+		{
+			name:     "Keep duplicates",
+			mode:     DuplicateKeep,
+			expected: "ENGINE = MergeTree()\n\tORDER BY (org_name, report_id, begin_date)",
+			forensic: "ENGINE = MergeTree()\n\tORDER BY (arrival_date, source_ip_address)",
+		},
+		{
+			name:     "Replace duplicates",
+			mode:     DuplicateReplace,
+			expected: "ENGINE = ReplacingMergeTree(created_at)\n\tORDER BY (org_name, report_id)",
+			forensic: "ENGINE = ReplacingMergeTree(created_at)\n\tORDER BY (message_id, if(message_id = '', toString(id), ''))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			storage := &Storage{
+				conn:   conn,
+				config: config.ClickHouseConfig{DuplicateHandling: tt.mode},
+				logger: zaptest.NewLogger(t),
+			}
+
+			if err := storage.createTables(); err != nil {
+				t.Fatalf("createTables() error = %v", err)
+			}
+
+			if len(conn.execs) == 0 {
+				t.Fatal("createTables() issued no statements")
+			}
+			if !strings.Contains(conn.execs[0].query, tt.expected) {
+				t.Errorf("aggregate table DDL missing %q:\n%s", tt.expected, conn.execs[0].query)
+			}
+			// Forensic reports without an Arrival-Date are dated at ingestion,
+			// so the arrival date must not be part of their natural key
+			for _, exec := range conn.execs {
+				if strings.Contains(exec.query, "CREATE TABLE IF NOT EXISTS dmarc_forensic_reports") &&
+					!strings.Contains(exec.query, tt.forensic) {
+					t.Errorf("forensic table DDL missing %q:\n%s", tt.forensic, exec.query)
+				}
+			}
+			// Duplicates only collapse within a partition, so copies of a
+			// report must land in the same one whatever their insert time
+			for _, exec := range conn.execs {
+				if strings.Contains(exec.query, "PARTITION BY toYYYYMM(created_at)") {
+					t.Errorf("table partitioned by insert time:\n%s", exec.query)
+				}
+			}
+		})
+	}
+}
+
+func TestClickHouse_ConcurrentDuplicateInserts(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:   conn,
+		config: config.ClickHouseConfig{DuplicateHandling: DuplicateReplace},
+		logger: zaptest.NewLogger(t),
+	}
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{
+			OrgName:   "google.com",
+			ReportID:  "dup-12345",
+			BeginDate: time.Unix(1700000000, 0),
+			EndDate:   time.Unix(1700086400, 0),
+		},
+		PolicyPublished: parser.PolicyPublished{Domain: "example.com"},
+		Records: []parser.Record{
+			{Source: parser.Source{IPAddress: "192.0.2.1"}, Count: 1},
+			{Source: parser.Source{IPAddress: "192.0.2.2"}, Count: 2},
+		},
+	}
+
+	// Simulate the same report arriving via HTTP and IMAP at the same time
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- storage.StoreAggregateReport(report)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("StoreAggregateReport() error = %v", err)
+		}
+	}
+
+	// Both copies must carry identical natural keys so ReplacingMergeTree
+	// collapses them into a single logical report and record set.
+	if len(conn.execs) != 2 {
+		t.Fatalf("expected 2 report inserts, got %d", len(conn.execs))
+	}
+	for _, exec := range conn.execs {
+		if exec.args[1] != "google.com" || exec.args[4] != "dup-12345" {
+			t.Errorf("report insert key = (%v, %v), want (google.com, dup-12345)", exec.args[1], exec.args[4])
+		}
+	}
+
+	if len(conn.batches) != 2 {
+		t.Fatalf("expected 2 record batches, got %d", len(conn.batches))
+	}
+	for _, batch := range conn.batches {
+		if !batch.sent {
+			t.Error("record batch was not sent")
+		}
+		if len(batch.rows) != len(report.Records) {
+			t.Fatalf("expected %d rows, got %d", len(report.Records), len(batch.rows))
+		}
+		for i, row := range batch.rows {
+			if row[1] != "google.com" || row[0] != "dup-12345" || row[2] != uint32(i) {
+				t.Errorf("record key = (%v, %v, %v), want (google.com, dup-12345, %d)", row[1], row[0], row[2], i)
+			}
+		}
+	}
+}
+
+func TestBuildOptions_Protocol(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.ClickHouseConfig
+		wantProtocol clickhouse.Protocol
+		wantAddr     string
+		wantErr      bool
+	}{
+		{
+			name:         "Native default port",
+			cfg:          config.ClickHouseConfig{Host: "localhost"},
+			wantProtocol: clickhouse.Native,
+			wantAddr:     "localhost:9000",
+		},
+		{
+			name:         "Native TLS default port",
+			cfg:          config.ClickHouseConfig{Host: "localhost", Protocol: ProtocolNative, TLS: true},
+			wantProtocol: clickhouse.Native,
+			wantAddr:     "localhost:9440",
+		},
+		{
+			name:         "HTTP default port",
+			cfg:          config.ClickHouseConfig{Host: "localhost", Protocol: ProtocolHTTP},
+			wantProtocol: clickhouse.HTTP,
+			wantAddr:     "localhost:8123",
+		},
+		{
+			name:         "HTTPS default port",
+			cfg:          config.ClickHouseConfig{Host: "ch.example.com", Protocol: "HTTP", TLS: true},
+			wantProtocol: clickhouse.HTTP,
+			wantAddr:     "ch.example.com:8443",
+		},
+		{
+			name:         "Explicit port",
+			cfg:          config.ClickHouseConfig{Host: "localhost", Protocol: ProtocolHTTP, Port: 18123},
+			wantProtocol: clickhouse.HTTP,
+			wantAddr:     "localhost:18123",
+		},
+		{
+			name:    "Invalid protocol",
+			cfg:     config.ClickHouseConfig{Host: "localhost", Protocol: "grpc"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := buildOptions(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if options.Protocol != tt.wantProtocol {
+				t.Errorf("Protocol = %v, want %v", options.Protocol, tt.wantProtocol)
+			}
+			if len(options.Addr) != 1 || options.Addr[0] != tt.wantAddr {
+				t.Errorf("Addr = %v, want [%s]", options.Addr, tt.wantAddr)
+			}
+			if (options.TLS != nil) != tt.cfg.TLS {
+				t.Errorf("TLS configured = %v, want %v", options.TLS != nil, tt.cfg.TLS)
+			}
+		})
+	}
+}
+
+func TestClickHouse_DeleteReport(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:   conn,
+		logger: zaptest.NewLogger(t),
+	}
+
+	if err := storage.DeleteReport("aggregate", "google.com", "report-1"); err != nil {
+		t.Fatalf("DeleteReport failed: %v", err)
+	}
+
+	if len(conn.execs) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(conn.execs))
+	}
+	for i, table := range []string{"dmarc_aggregate_reports", "dmarc_aggregate_records"} {
+		exec := conn.execs[i]
+		if !strings.Contains(exec.query, "ALTER TABLE "+table+" DELETE WHERE org_name = ? AND report_id = ?") {
+			t.Errorf("Unexpected statement: %s", exec.query)
+		}
+		if !reflect.DeepEqual(exec.args, []any{"google.com", "report-1"}) {
+			t.Errorf("Expected organization and report ID arguments, got %v", exec.args)
+		}
+	}
+
+	if err := storage.DeleteReport("unknown", "google.com", "report-1"); err == nil {
+		t.Error("Expected error for unsupported report type")
+	}
+
+	// Report IDs are only unique per organization
+	if _, err := storage.ReportExists("smtp_tls", "Company-X", "tls-1"); err != nil {
+		t.Fatalf("ReportExists failed: %v", err)
+	}
+	want := []fakeExec{{
+		query: "SELECT count() FROM dmarc_smtp_tls_reports WHERE organization_name = ? AND report_id = ?",
+		args:  []any{"Company-X", "tls-1"},
+	}}
+	if !reflect.DeepEqual(conn.queries, want) {
+		t.Errorf("Unexpected lookup: %+v", conn.queries)
+	}
+}
+
+func TestConnect_RetriesUntilReady(t *testing.T) {
+	origOpen := openConn
+	defer func() { openConn = origOpen }()
+
+	attempts := 0
+	openConn = func(*clickhouse.Options) (driver.Conn, error) {
+		attempts++
+		switch attempts {
+		case 1:
+			return nil, errors.New("connection refused")
+		case 2:
+			return &fakeConn{pingErr: errors.New("server starting")}, nil
+		default:
+			return &fakeConn{}, nil
+		}
+	}
+
+	cfg := config.ClickHouseConfig{ConnectAttempts: 5}
+	conn, err := connect(cfg, &clickhouse.Options{}, zaptest.NewLogger(t))
+	if err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	if conn == nil {
+		t.Fatal("Expected a connection")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestConnect_GivesUpAfterMaxAttempts(t *testing.T) {
+	origOpen := openConn
+	defer func() { openConn = origOpen }()
+
+	attempts := 0
+	openConn = func(*clickhouse.Options) (driver.Conn, error) {
+		attempts++
+		return nil, errors.New("connection refused")
+	}
+
+	cfg := config.ClickHouseConfig{ConnectAttempts: 2}
+	if _, err := connect(cfg, &clickhouse.Options{}, zaptest.NewLogger(t)); err == nil {
+		t.Fatal("Expected error after exhausting attempts")
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestClickHouse_AggregateReportQuery(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		mode       string
+		filter     parser.AggregateReportFilter
+		contains   []string
+		excludes   []string
+		expectArgs []interface{}
+	}{
+		{
+			name:       "No filter",
+			filter:     parser.AggregateReportFilter{},
+			contains:   []string{"FROM dmarc_aggregate_reports\n", "ORDER BY begin_date DESC"},
+			excludes:   []string{"WHERE", "LIMIT", "OFFSET", "FINAL"},
+			expectArgs: nil,
+		},
+		{
+			name:       "Domain, date range and page",
+			filter:     parser.AggregateReportFilter{Domain: "example.com", From: from, To: to, Limit: 50, Offset: 100},
+			contains:   []string{"WHERE domain = ? AND begin_date >= ? AND begin_date < ?", "LIMIT ? OFFSET ?"},
+			expectArgs: []interface{}{"example.com", from, to, 50, 100},
+		},
+		{
+			name:       "Replace mode reads collapsed rows",
+			mode:       DuplicateReplace,
+			filter:     parser.AggregateReportFilter{Domain: "example.com"},
+			contains:   []string{"FROM dmarc_aggregate_reports FINAL", "WHERE domain = ?"},
+			expectArgs: []interface{}{"example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &Storage{config: config.ClickHouseConfig{DuplicateHandling: tt.mode}}
+
+			query, args := storage.aggregateReportQuery(tt.filter)
+			for _, want := range tt.contains {
+				if !strings.Contains(query, want) {
+					t.Errorf("Query does not contain %q:\n%s", want, query)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(query, unwanted) {
+					t.Errorf("Query unexpectedly contains %q:\n%s", unwanted, query)
+				}
+			}
+			if !reflect.DeepEqual(args, tt.expectArgs) {
+				t.Errorf("Args = %v, want %v", args, tt.expectArgs)
+			}
+		})
+	}
+}
+
+func TestClickHouse_StoreAggregateReports(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:   conn,
+		logger: zaptest.NewLogger(t),
+	}
+
+	reports := []*parser.AggregateReport{
+		{
+			ReportMetadata: parser.ReportMetadata{OrgName: "google.com", ReportID: "report-1"},
+			Records: []parser.Record{
+				{Source: parser.Source{IPAddress: "192.0.2.1"}, Count: 1},
+				{Source: parser.Source{IPAddress: "192.0.2.2"}, Count: 2},
+			},
+		},
+		{
+			ReportMetadata: parser.ReportMetadata{OrgName: "yahoo.com", ReportID: "report-2"},
+			Records: []parser.Record{
+				{Source: parser.Source{IPAddress: "192.0.2.3"}, Count: 3},
+			},
+		},
+	}
+
+	if err := storage.StoreAggregateReports(reports); err != nil {
+		t.Fatalf("StoreAggregateReports failed: %v", err)
+	}
+
+	if len(conn.execs) != 0 {
+		t.Errorf("Expected no single-row inserts, got %d", len(conn.execs))
+	}
+	if len(conn.batches) != 2 {
+		t.Fatalf("Expected 2 batches, got %d", len(conn.batches))
+	}
+
+	reportBatch, recordBatch := conn.batches[0], conn.batches[1]
+	if !strings.Contains(reportBatch.query, "dmarc_aggregate_reports") || len(reportBatch.rows) != 2 || !reportBatch.sent {
+		t.Errorf("Expected one sent batch of 2 reports, got %d rows (sent %v)", len(reportBatch.rows), reportBatch.sent)
+	}
+	if !strings.Contains(recordBatch.query, "dmarc_aggregate_records") || len(recordBatch.rows) != 3 || !recordBatch.sent {
+		t.Errorf("Expected one sent batch of 3 records, got %d rows (sent %v)", len(recordBatch.rows), recordBatch.sent)
+	}
+	if recordBatch.rows[2][0] != "report-2" || recordBatch.rows[2][2] != uint32(0) {
+		t.Errorf("Unexpected last record row: %v", recordBatch.rows[2][:3])
+	}
+}
+
+func TestClickHouse_StoreAggregateReportsDeduplicate(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:   conn,
+		config: config.ClickHouseConfig{Deduplicate: true},
+		logger: zaptest.NewLogger(t),
+	}
+
+	report := func(orgName string) *parser.AggregateReport {
+		return &parser.AggregateReport{
+			ReportMetadata: parser.ReportMetadata{OrgName: orgName, ReportID: "report-1"},
+			Records:        []parser.Record{{Source: parser.Source{IPAddress: "192.0.2.1"}, Count: 1}},
+		}
+	}
+
+	// The second report repeats the first; the third has the same ID from
+	// another organization
+	reports := []*parser.AggregateReport{report("google.com"), report("google.com"), report("yahoo.com")}
+	if err := storage.StoreAggregateReports(reports); err != nil {
+		t.Fatalf("StoreAggregateReports failed: %v", err)
+	}
+
+	if len(conn.queries) != 2 {
+		t.Errorf("Expected 2 existence checks, got %d", len(conn.queries))
+	}
+	if len(conn.batches) != 2 {
+		t.Fatalf("Expected 2 batches, got %d", len(conn.batches))
+	}
+	if rows := conn.batches[0].rows; len(rows) != 2 {
+		t.Errorf("Expected 2 reports to be inserted, got %d", len(rows))
+	}
+	if rows := conn.batches[1].rows; len(rows) != 2 {
+		t.Errorf("Expected 2 records to be inserted, got %d", len(rows))
+	}
+}
+
+func TestClickHouse_StoreAggregateRecords(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:   conn,
+		logger: zaptest.NewLogger(t),
+	}
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "google.com", ReportID: "report-1"},
+	}
+	if err := storage.StoreAggregateReportHeader(report); err != nil {
+		t.Fatalf("StoreAggregateReportHeader failed: %v", err)
+	}
+	records := []parser.Record{
+		{Source: parser.Source{IPAddress: "192.0.2.1"}, Count: 1},
+		{Source: parser.Source{IPAddress: "192.0.2.2"}, Count: 2},
+	}
+	if err := storage.StoreAggregateRecords(report, 1000, records); err != nil {
+		t.Fatalf("StoreAggregateRecords failed: %v", err)
+	}
+
+	if len(conn.execs) != 1 || !strings.Contains(conn.execs[0].query, "dmarc_aggregate_reports") {
+		t.Fatalf("Expected one report insert, got %d statements", len(conn.execs))
+	}
+	if len(conn.batches) != 1 || len(conn.batches[0].rows) != 2 || !conn.batches[0].sent {
+		t.Fatalf("Expected one sent batch of 2 records, got %d batches", len(conn.batches))
+	}
+	for i, row := range conn.batches[0].rows {
+		if row[2] != uint32(1000+i) {
+			t.Errorf("Expected record index %d, got %v", 1000+i, row[2])
+		}
+	}
+}
+
+func TestClickHouse_StoreAggregateReportHeaderDeduplicate(t *testing.T) {
+	conn := &fakeConn{count: 1}
+	storage := &Storage{
+		conn:   conn,
+		config: config.ClickHouseConfig{Deduplicate: true},
+		logger: zaptest.NewLogger(t),
+	}
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "google.com", ReportID: "report-1"},
+	}
+	if err := storage.StoreAggregateReportHeader(report); !errors.Is(err, parser.ErrDuplicateReport) {
+		t.Fatalf("StoreAggregateReportHeader returned %v, want ErrDuplicateReport", err)
+	}
+	if len(conn.queries) != 1 {
+		t.Errorf("Expected 1 existence check, got %d", len(conn.queries))
+	}
+	if len(conn.execs) != 0 {
+		t.Errorf("Expected no insert, got %d statements", len(conn.execs))
+	}
+}
+
+func TestClickHouse_BufferedForensicAndFailureRows(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:   conn,
+		config: config.ClickHouseConfig{BatchSize: 5},
+		logger: zaptest.NewLogger(t),
+	}
+
+	authResults := []parser.AuthenticationResult{
+		{Method: "dmarc", Result: "fail", Properties: map[string]string{"header.from": "example.com"}},
+	}
+	for _, id := range []string{"<msg-1@example.com>", "<msg-2@example.com>"} {
+		report := &parser.ForensicReport{MessageID: id, ParsedAuthenticationResults: authResults}
+		if err := storage.StoreForensicReport(report); err != nil {
+			t.Fatalf("StoreForensicReport failed: %v", err)
+		}
+	}
+	smtpTLS := &parser.SMTPTLSReport{
+		OrganizationName: "Company-X",
+		ReportID:         "tls-1",
+		BeginDate:        time.Unix(1700000000, 0),
+		Policies: []parser.SMTPTLSPolicy{{
+			PolicyDomain: "example.com",
+			FailureDetails: []parser.SMTPTLSFailureDetails{
+				{ResultType: "certificate-expired", FailedSessionCount: 1},
+				{ResultType: "starttls-not-supported", FailedSessionCount: 2},
+			},
+		}},
+	}
+	if err := storage.StoreSMTPTLSReport(smtpTLS); err != nil {
+		t.Fatalf("StoreSMTPTLSReport failed: %v", err)
+	}
+
+	// The 2 forensic rows, the SMTP TLS report row and its 2 failure rows
+	// fill a batch
+	if len(conn.execs) != 0 {
+		t.Fatalf("Expected no row to be inserted directly, got %d statements", len(conn.execs))
+	}
+	if len(conn.batches) != 3 {
+		t.Fatalf("Expected one batch per table, got %d", len(conn.batches))
+	}
+	forensicBatch, reportBatch, failureBatch := conn.batches[0], conn.batches[1], conn.batches[2]
+	if !strings.Contains(forensicBatch.query, "dmarc_forensic_reports") || len(forensicBatch.rows) != 2 || !forensicBatch.sent {
+		t.Errorf("Expected one sent batch of 2 forensic reports, got %d rows (sent %v)", len(forensicBatch.rows), forensicBatch.sent)
+	}
+	if !strings.Contains(reportBatch.query, "dmarc_smtp_tls_reports") || len(reportBatch.rows) != 1 || !reportBatch.sent {
+		t.Errorf("Expected one sent batch of 1 SMTP TLS report, got %d rows (sent %v)", len(reportBatch.rows), reportBatch.sent)
+	}
+	if !strings.Contains(failureBatch.query, "dmarc_smtp_tls_failures") || len(failureBatch.rows) != 2 || !failureBatch.sent {
+		t.Errorf("Expected one sent batch of 2 failure details, got %d rows (sent %v)", len(failureBatch.rows), failureBatch.sent)
+	}
+	wantAuthResults := `[{"method":"dmarc","result":"fail","properties":{"header.from":"example.com"}}]`
+	if got := forensicBatch.rows[0][11]; got != wantAuthResults {
+		t.Errorf("Expected the parsed authentication results as JSON, got %v", got)
+	}
+	if failureBatch.rows[1][1] != "Company-X" || failureBatch.rows[1][2] != smtpTLS.BeginDate || failureBatch.rows[1][4] != uint32(1) {
+		t.Errorf("Expected the organization, begin date and failure index 1, got %v", failureBatch.rows[1])
+	}
+
+	// A partial batch is inserted on close
+	if err := storage.StoreForensicReport(&parser.ForensicReport{MessageID: "<msg-3@example.com>"}); err != nil {
+		t.Fatalf("StoreForensicReport failed: %v", err)
+	}
+	if len(conn.batches) != 3 {
+		t.Fatalf("Expected the partial batch to be buffered, got %d batches", len(conn.batches))
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(conn.batches) != 4 || len(conn.batches[3].rows) != 1 || !conn.batches[3].sent {
+		t.Errorf("Expected the partial batch to be inserted on close, got %d batches", len(conn.batches))
+	}
+}
+
+func TestClickHouse_BufferedRowsDuplicatesAndDeletes(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:   conn,
+		config: config.ClickHouseConfig{BatchSize: 100, Deduplicate: true},
+		logger: zaptest.NewLogger(t),
+	}
+
+	forensic := &parser.ForensicReport{MessageID: "<msg-1@example.com>"}
+	smtpTLS := &parser.SMTPTLSReport{
+		OrganizationName: "Company-X",
+		ReportID:         "tls-1",
+		Policies: []parser.SMTPTLSPolicy{{
+			PolicyDomain:   "example.com",
+			FailureDetails: []parser.SMTPTLSFailureDetails{{ResultType: "certificate-expired"}},
+		}},
+	}
+	if err := storage.StoreForensicReport(forensic); err != nil {
+		t.Fatalf("StoreForensicReport failed: %v", err)
+	}
+	if err := storage.StoreSMTPTLSReport(smtpTLS); err != nil {
+		t.Fatalf("StoreSMTPTLSReport failed: %v", err)
+	}
+
+	// Copies of buffered reports are duplicates before the buffer is flushed
+	if err := storage.StoreForensicReport(forensic); !errors.Is(err, parser.ErrDuplicateReport) {
+		t.Errorf("Expected a duplicate forensic report, got %v", err)
+	}
+	if err := storage.StoreSMTPTLSReport(smtpTLS); !errors.Is(err, parser.ErrDuplicateReport) {
+		t.Errorf("Expected a duplicate SMTP TLS report, got %v", err)
+	}
+	// Report IDs are only unique per organization
+	if exists, err := storage.ReportExists("smtp_tls", "Company-Y", "tls-1"); err != nil || exists {
+		t.Errorf("ReportExists() = %v, %v for another organization, want false", exists, err)
+	}
+
+	// Deleting a report drops its buffered rows
+	if err := storage.DeleteReport("smtp_tls", "Company-X", "tls-1"); err != nil {
+		t.Fatalf("DeleteReport failed: %v", err)
+	}
+	if err := storage.DeleteReport("forensic", "", "<msg-1@example.com>"); err != nil {
+		t.Fatalf("DeleteReport failed: %v", err)
+	}
+	if n := storage.buffer.len(); n != 0 {
+		t.Errorf("Expected deleted reports to leave the buffer, %d rows left", n)
+	}
+	if err := storage.StoreSMTPTLSReport(smtpTLS); err != nil {
+		t.Errorf("Expected a replaced SMTP TLS report to be stored again, got %v", err)
+	}
+}
+
+func TestClickHouse_InsertMetrics(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:    conn,
+		logger:  zaptest.NewLogger(t),
+		metrics: metrics.NewStorageMetrics(prometheus.NewRegistry()),
+	}
+	failures := storage.metrics.InsertFailuresTotal
+
+	if err := storage.StoreForensicReport(&parser.ForensicReport{MessageID: "<msg-1@example.com>"}); err != nil {
+		t.Fatalf("StoreForensicReport failed: %v", err)
+	}
+	if got := testutil.ToFloat64(failures.WithLabelValues("clickhouse", "forensic")); got != 0 {
+		t.Errorf("Expected no failure after a successful store, got %v", got)
+	}
+
+	conn.execErr = errors.New("connection reset by peer")
+	if err := storage.StoreForensicReport(&parser.ForensicReport{MessageID: "<msg-2@example.com>"}); err == nil {
+		t.Fatal("Expected StoreForensicReport to fail")
+	}
+	if err := storage.StoreSMTPTLSReport(&parser.SMTPTLSReport{OrganizationName: "Company-X", ReportID: "tls-1"}); err == nil {
+		t.Fatal("Expected StoreSMTPTLSReport to fail")
+	}
+	if got := testutil.ToFloat64(failures.WithLabelValues("clickhouse", "forensic")); got != 1 {
+		t.Errorf("Expected 1 forensic failure, got %v", got)
+	}
+	if got := testutil.ToFloat64(failures.WithLabelValues("clickhouse", "smtp_tls")); got != 1 {
+		t.Errorf("Expected 1 SMTP TLS failure, got %v", got)
+	}
+
+	// Every store is timed, whether or not it succeeded
+	if got := testutil.CollectAndCount(storage.metrics.InsertDuration); got != 2 {
+		t.Errorf("Expected durations for 2 report types, got %d series", got)
+	}
+}
+
+func TestClickHouse_StoreAggregateReportParseInfo(t *testing.T) {
+	conn := &fakeConn{}
+	storage := &Storage{
+		conn:   conn,
+		logger: zaptest.NewLogger(t),
+	}
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "google.com", ReportID: "report-1"},
+		ParseInfo:      parser.ParseInfo{ParseDurationMS: 42, ParserVersion: "1.2.3", SourceTransport: "imap"},
+	}
+
+	if err := storage.StoreAggregateReport(report); err != nil {
+		t.Fatalf("StoreAggregateReport failed: %v", err)
+	}
+
+	if len(conn.execs) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(conn.execs))
+	}
+	exec := conn.execs[0]
+	if !strings.Contains(exec.query, "fingerprint, parse_duration_ms, parser_version, source_transport") {
+		t.Errorf("Expected parse metadata columns in insert: %s", exec.query)
+	}
+	if placeholders := strings.Count(exec.query, "?"); placeholders != len(exec.args) {
+		t.Fatalf("Insert has %d placeholders for %d values", placeholders, len(exec.args))
+	}
+	n := len(exec.args)
+	if exec.args[n-3] != uint32(42) || exec.args[n-2] != "1.2.3" || exec.args[n-1] != "imap" {
+		t.Errorf("Expected parse duration, parser version and source transport values, got %v", exec.args[n-3:])
+	}
+}
+
+func TestClickHouse_StoreAggregateReportCapsAuthResults(t *testing.T) {
+	conn := &fakeConn{}
+	core, logs := observer.New(zap.WarnLevel)
+	storage := &Storage{
+		conn:   conn,
+		config: config.ClickHouseConfig{MaxAuthResults: 2},
+		logger: zap.New(core),
+	}
+
+	var manyDKIM []parser.DKIMResult
+	for i := 0; i < 5; i++ {
+		manyDKIM = append(manyDKIM, parser.DKIMResult{
+			Domain:   fmt.Sprintf("d%d.example.com", i),
+			Selector: "selector",
+			Result:   "pass",
+		})
+	}
+
+	report := &parser.AggregateReport{
+		ReportMetadata: parser.ReportMetadata{OrgName: "google.com", ReportID: "report-1"},
+		Records: []parser.Record{
+			{
+				Source: parser.Source{IPAddress: "192.0.2.1"},
+				Count:  1,
+				AuthResults: parser.AuthResults{
+					DKIM: manyDKIM,
+					SPF:  []parser.SPFResult{{Domain: "example.com", Scope: "mfrom", Result: "pass"}},
+				},
+			},
+			{
+				Source: parser.Source{IPAddress: "192.0.2.2"},
+				Count:  1,
+				AuthResults: parser.AuthResults{
+					DKIM: manyDKIM[:1],
+				},
+			},
+		},
+	}
+
+	if err := storage.StoreAggregateReport(report); err != nil {
+		t.Fatalf("StoreAggregateReport failed: %v", err)
+	}
+
+	if len(conn.batches) != 1 || len(conn.batches[0].rows) != 2 {
+		t.Fatalf("Expected one batch with 2 rows, got %d batches", len(conn.batches))
+	}
+
+	// Columns 26-31 are the DKIM and SPF domain, selector/scope and result arrays
+	wantLengths := [][]int{
+		{2, 2, 2, 1, 1, 1},
+		{1, 1, 1, 0, 0, 0},
+	}
+	for i, row := range conn.batches[0].rows {
+		for j, want := range wantLengths[i] {
+			if got := len(row[26+j].([]string)); got != want {
+				t.Errorf("Row %d column %d has %d values, want %d", i, 26+j, got, want)
+			}
+		}
+	}
+	if domains := conn.batches[0].rows[0][26].([]string); domains[0] != "d0.example.com" || domains[1] != "d1.example.com" {
+		t.Errorf("Expected the first DKIM results to be kept, got %v", domains)
+	}
+
+	if logs.FilterMessage("Truncating auth results of aggregate record").Len() != 1 {
+		t.Errorf("Expected one truncation warning, got %d", logs.Len())
+	}
+	if len(report.Records[0].AuthResults.DKIM) != 5 {
+		t.Error("Expected the report itself not to be modified")
+	}
+}
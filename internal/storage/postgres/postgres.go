@@ -0,0 +1,892 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" database/sql driver
+	"go.uber.org/zap"
+	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/parser"
+)
+
+// maxParameters is the maximum number of bind parameters PostgreSQL accepts
+// in a single statement
+const maxParameters = 65535
+
+// Storage implements PostgreSQL storage for DMARC reports
+type Storage struct {
+	db     *sql.DB
+	config config.PostgresConfig
+	logger *zap.Logger
+}
+
+// New creates a new PostgreSQL storage instance
+func New(cfg config.PostgresConfig, logger *zap.Logger) (*Storage, error) {
+	db, err := sql.Open("pgx", buildDSN(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PostgreSQL connection: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping PostgreSQL: %w", err)
+	}
+
+	storage := &Storage{
+		db:     db,
+		config: cfg,
+		logger: logger,
+	}
+
+	// Create tables if they don't exist
+	if err := storage.createTables(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+
+	logger.Info("Connected to PostgreSQL",
+		zap.String("host", cfg.Host),
+		zap.Int("port", cfg.Port),
+		zap.String("database", cfg.Database),
+	)
+
+	return storage, nil
+}
+
+// buildDSN builds a postgres:// connection URL from the configuration
+func buildDSN(cfg config.PostgresConfig) string {
+	port := cfg.Port
+	if port == 0 {
+		port = 5432
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		Host:   fmt.Sprintf("%s:%d", cfg.Host, port),
+		Path:   "/" + cfg.Database,
+	}
+	if cfg.Username != "" {
+		dsn.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+	if cfg.SSLMode != "" {
+		dsn.RawQuery = url.Values{"sslmode": {cfg.SSLMode}}.Encode()
+	}
+
+	return dsn.String()
+}
+
+// Ping checks that the database is reachable
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the PostgreSQL connection pool
+func (s *Storage) Close() error {
+	if s.db != nil {
+		return s.db.Close()
+	}
+	return nil
+}
+
+// createTables creates the necessary tables for storing DMARC reports
+func (s *Storage) createTables() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS dmarc_aggregate_reports (
+			id BIGSERIAL PRIMARY KEY,
+			xml_schema TEXT NOT NULL DEFAULT '',
+			org_name TEXT NOT NULL,
+			org_email TEXT NOT NULL DEFAULT '',
+			org_extra_contact_info TEXT,
+			report_id TEXT NOT NULL,
+			begin_date TIMESTAMPTZ NOT NULL,
+			end_date TIMESTAMPTZ NOT NULL,
+			errors TEXT[] NOT NULL DEFAULT '{}',
+			domain TEXT NOT NULL,
+			adkim TEXT NOT NULL DEFAULT '',
+			aspf TEXT NOT NULL DEFAULT '',
+			p TEXT NOT NULL DEFAULT '',
+			sp TEXT NOT NULL DEFAULT '',
+			pct TEXT NOT NULL DEFAULT '',
+			pct_value SMALLINT NOT NULL DEFAULT 100,
+			fo TEXT NOT NULL DEFAULT '',
+			fingerprint TEXT NOT NULL DEFAULT '',
+			parse_duration_ms BIGINT NOT NULL DEFAULT 0,
+			parser_version TEXT NOT NULL DEFAULT '',
+			source_transport TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS fingerprint TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS parse_duration_ms BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS parser_version TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_aggregate_reports ADD COLUMN IF NOT EXISTS source_transport TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_aggregate_reports_report_id ON dmarc_aggregate_reports (report_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_aggregate_reports_fingerprint ON dmarc_aggregate_reports (fingerprint)`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_aggregate_reports_begin_date ON dmarc_aggregate_reports (begin_date)`,
+
+		`CREATE TABLE IF NOT EXISTS dmarc_aggregate_records (
+			id BIGSERIAL PRIMARY KEY,
+			report_id TEXT NOT NULL,
+			org_name TEXT NOT NULL,
+			record_index INTEGER NOT NULL,
+			source_ip_address TEXT NOT NULL,
+			source_country TEXT NOT NULL DEFAULT '',
+			source_city TEXT NOT NULL DEFAULT '',
+			source_latitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+			source_longitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+			source_asn BIGINT NOT NULL DEFAULT 0,
+			source_asn_org TEXT NOT NULL DEFAULT '',
+			source_reverse_dns TEXT NOT NULL DEFAULT '',
+			source_base_domain TEXT NOT NULL DEFAULT '',
+			source_name TEXT NOT NULL DEFAULT '',
+			source_type TEXT NOT NULL DEFAULT '',
+			count INTEGER NOT NULL,
+			spf_aligned BOOLEAN NOT NULL,
+			dkim_aligned BOOLEAN NOT NULL,
+			dmarc_aligned BOOLEAN NOT NULL,
+			disposition TEXT NOT NULL DEFAULT '',
+			effective_policy TEXT NOT NULL DEFAULT '',
+			sampled_out BOOLEAN NOT NULL DEFAULT false,
+			policy_override_reasons TEXT[] NOT NULL DEFAULT '{}',
+			policy_override_comments TEXT[] NOT NULL DEFAULT '{}',
+			envelope_from TEXT,
+			header_from TEXT NOT NULL DEFAULT '',
+			envelope_to TEXT,
+			dkim_domains TEXT[] NOT NULL DEFAULT '{}',
+			dkim_selectors TEXT[] NOT NULL DEFAULT '{}',
+			dkim_results TEXT[] NOT NULL DEFAULT '{}',
+			spf_domains TEXT[] NOT NULL DEFAULT '{}',
+			spf_scopes TEXT[] NOT NULL DEFAULT '{}',
+			spf_results TEXT[] NOT NULL DEFAULT '{}',
+			begin_date TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_city TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_latitude DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_longitude DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_asn BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS source_asn_org TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS effective_policy TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_aggregate_records ADD COLUMN IF NOT EXISTS sampled_out BOOLEAN NOT NULL DEFAULT false`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_aggregate_records_report_id ON dmarc_aggregate_records (report_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_aggregate_records_begin_date ON dmarc_aggregate_records (begin_date)`,
+
+		`CREATE TABLE IF NOT EXISTS dmarc_forensic_reports (
+			id BIGSERIAL PRIMARY KEY,
+			feedback_type TEXT NOT NULL DEFAULT '',
+			user_agent TEXT,
+			version TEXT,
+			original_envelope_id TEXT,
+			original_mail_from TEXT,
+			original_rcpt_to TEXT,
+			arrival_date TIMESTAMPTZ NOT NULL,
+			arrival_date_utc TIMESTAMPTZ NOT NULL,
+			subject TEXT NOT NULL DEFAULT '',
+			message_id TEXT NOT NULL DEFAULT '',
+			authentication_results TEXT NOT NULL DEFAULT '',
+			parsed_authentication_results JSONB,
+			dkim_domain TEXT,
+			source_ip_address TEXT NOT NULL DEFAULT '',
+			source_country TEXT NOT NULL DEFAULT '',
+			source_city TEXT NOT NULL DEFAULT '',
+			source_latitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+			source_longitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+			source_asn BIGINT NOT NULL DEFAULT 0,
+			source_asn_org TEXT NOT NULL DEFAULT '',
+			source_reverse_dns TEXT NOT NULL DEFAULT '',
+			source_base_domain TEXT NOT NULL DEFAULT '',
+			source_name TEXT NOT NULL DEFAULT '',
+			source_type TEXT NOT NULL DEFAULT '',
+			delivery_result TEXT NOT NULL DEFAULT '',
+			auth_failure TEXT[] NOT NULL DEFAULT '{}',
+			auth_failure_raw TEXT[] NOT NULL DEFAULT '{}',
+			reported_domain TEXT NOT NULL DEFAULT '',
+			authentication_mechanisms TEXT[] NOT NULL DEFAULT '{}',
+			authentication_mechanisms_raw TEXT[] NOT NULL DEFAULT '{}',
+			sample_headers_only BOOLEAN NOT NULL DEFAULT false,
+			sample TEXT NOT NULL DEFAULT '',
+			parsed_sample JSONB,
+			parse_duration_ms BIGINT NOT NULL DEFAULT 0,
+			parser_version TEXT NOT NULL DEFAULT '',
+			source_transport TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_city TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_latitude DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_longitude DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_asn BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_asn_org TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS parse_duration_ms BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS parser_version TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS source_transport TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_forensic_reports ADD COLUMN IF NOT EXISTS parsed_authentication_results JSONB`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_forensic_reports_arrival_date ON dmarc_forensic_reports (arrival_date)`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_forensic_reports_reported_domain ON dmarc_forensic_reports (reported_domain)`,
+
+		`CREATE TABLE IF NOT EXISTS dmarc_smtp_tls_reports (
+			id BIGSERIAL PRIMARY KEY,
+			organization_name TEXT NOT NULL,
+			begin_date TIMESTAMPTZ NOT NULL,
+			end_date TIMESTAMPTZ NOT NULL,
+			contact_info TEXT NOT NULL DEFAULT '',
+			report_id TEXT NOT NULL,
+			policy_domain TEXT NOT NULL DEFAULT '',
+			policy_type TEXT NOT NULL DEFAULT '',
+			policy_strings TEXT[] NOT NULL DEFAULT '{}',
+			mta_sts_policy JSONB,
+			mx_host_patterns TEXT[] NOT NULL DEFAULT '{}',
+			successful_session_count BIGINT NOT NULL DEFAULT 0,
+			failed_session_count BIGINT NOT NULL DEFAULT 0,
+			parse_duration_ms BIGINT NOT NULL DEFAULT 0,
+			parser_version TEXT NOT NULL DEFAULT '',
+			source_transport TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`ALTER TABLE dmarc_smtp_tls_reports ADD COLUMN IF NOT EXISTS parse_duration_ms BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE dmarc_smtp_tls_reports ADD COLUMN IF NOT EXISTS parser_version TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE dmarc_smtp_tls_reports ADD COLUMN IF NOT EXISTS source_transport TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_smtp_tls_reports_report_id ON dmarc_smtp_tls_reports (report_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_smtp_tls_reports_begin_date ON dmarc_smtp_tls_reports (begin_date)`,
+
+		`CREATE TABLE IF NOT EXISTS dmarc_smtp_tls_failures (
+			id BIGSERIAL PRIMARY KEY,
+			report_id TEXT NOT NULL,
+			organization_name TEXT NOT NULL DEFAULT '',
+			policy_domain TEXT NOT NULL DEFAULT '',
+			failure_index INTEGER NOT NULL,
+			result_type TEXT NOT NULL DEFAULT '',
+			failed_session_count BIGINT NOT NULL DEFAULT 0,
+			sending_mta_ip TEXT,
+			receiving_ip TEXT,
+			receiving_mx_hostname TEXT,
+			receiving_mx_helo TEXT,
+			additional_info_uri TEXT,
+			failure_reason_code TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`ALTER TABLE dmarc_smtp_tls_failures ADD COLUMN IF NOT EXISTS organization_name TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_smtp_tls_failures_report_id ON dmarc_smtp_tls_failures (report_id)`,
+
+		`CREATE TABLE IF NOT EXISTS dmarc_unparsed_reports (
+			id BIGSERIAL PRIMARY KEY,
+			received_at TIMESTAMPTZ NOT NULL,
+			source TEXT NOT NULL DEFAULT '',
+			size BIGINT NOT NULL DEFAULT 0,
+			content_type TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			preview TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_dmarc_unparsed_reports_received_at ON dmarc_unparsed_reports (received_at)`,
+	}
+
+	ctx := context.Background()
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to execute %q: %w", firstLine(statement), err)
+		}
+	}
+
+	s.logger.Info("PostgreSQL tables created successfully")
+	return nil
+}
+
+// aggregateRecordColumns lists the columns of dmarc_aggregate_records filled
+// by StoreAggregateReport, in insert order
+var aggregateRecordColumns = []string{
+	"report_id", "org_name", "record_index", "source_ip_address", "source_country",
+	"source_city", "source_latitude", "source_longitude", "source_asn", "source_asn_org", "source_reverse_dns", "source_base_domain", "source_name", "source_type", "count",
+	"spf_aligned", "dkim_aligned", "dmarc_aligned", "disposition", "effective_policy", "sampled_out",
+	"policy_override_reasons", "policy_override_comments", "envelope_from", "header_from", "envelope_to",
+	"dkim_domains", "dkim_selectors", "dkim_results", "spf_domains", "spf_scopes",
+	"spf_results", "begin_date",
+}
+
+// smtpTLSFailureColumns lists the columns of dmarc_smtp_tls_failures filled by
+// StoreSMTPTLSReport, in insert order
+var smtpTLSFailureColumns = []string{
+	"report_id", "organization_name", "policy_domain", "failure_index", "result_type", "failed_session_count",
+	"sending_mta_ip", "receiving_ip", "receiving_mx_hostname", "receiving_mx_helo",
+	"additional_info_uri", "failure_reason_code",
+}
+
+// StoreAggregateReport stores an aggregate DMARC report in PostgreSQL. The
+// report and its records are written in a single transaction.
+func (s *Storage) StoreAggregateReport(report *parser.AggregateReport) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertAggregateReport(ctx, tx, report); err != nil {
+		return err
+	}
+	if err := insertAggregateRecords(ctx, tx, report, 0, report.Records); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit aggregate report: %w", err)
+	}
+
+	s.logger.Info("Stored aggregate report in PostgreSQL",
+		zap.String("org", report.ReportMetadata.OrgName),
+		zap.String("report_id", report.ReportMetadata.ReportID),
+		zap.Int("records", len(report.Records)),
+	)
+
+	return nil
+}
+
+// StoreAggregateReportHeader stores an aggregate DMARC report without its
+// records, which are stored by StoreAggregateRecords
+func (s *Storage) StoreAggregateReportHeader(report *parser.AggregateReport) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertAggregateReport(ctx, tx, report); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit aggregate report: %w", err)
+	}
+	return nil
+}
+
+// StoreAggregateRecords stores records of an aggregate DMARC report in a
+// single transaction, the first one at index offset
+func (s *Storage) StoreAggregateRecords(report *parser.AggregateReport, offset int, records []parser.Record) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertAggregateRecords(ctx, tx, report, offset, records); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit aggregate records: %w", err)
+	}
+
+	s.logger.Debug("Stored aggregate records in PostgreSQL",
+		zap.String("org", report.ReportMetadata.OrgName),
+		zap.String("report_id", report.ReportMetadata.ReportID),
+		zap.Int("offset", offset),
+		zap.Int("records", len(records)),
+	)
+
+	return nil
+}
+
+// insertAggregateReport inserts the dmarc_aggregate_reports row of report
+func insertAggregateReport(ctx context.Context, tx *sql.Tx, report *parser.AggregateReport) error {
+	reportSQL := `
+	INSERT INTO dmarc_aggregate_reports (
+		xml_schema, org_name, org_email, org_extra_contact_info, report_id,
+		begin_date, end_date, errors, domain, adkim, aspf, p, sp, pct, pct_value, fo,
+		fingerprint, parse_duration_ms, parser_version, source_transport
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
+		$18, $19, $20)`
+
+	_, err := tx.ExecContext(ctx, reportSQL,
+		report.XMLSchema,
+		report.ReportMetadata.OrgName,
+		report.ReportMetadata.OrgEmail,
+		report.ReportMetadata.OrgExtraContactInfo,
+		report.ReportMetadata.ReportID,
+		report.ReportMetadata.BeginDate,
+		report.ReportMetadata.EndDate,
+		nonNil(report.ReportMetadata.Errors),
+		report.PolicyPublished.Domain,
+		report.PolicyPublished.ADKIM,
+		report.PolicyPublished.ASPF,
+		report.PolicyPublished.P,
+		report.PolicyPublished.SP,
+		report.PolicyPublished.PCT,
+		report.PolicyPublished.PCTValue,
+		report.PolicyPublished.FO,
+		report.Fingerprint,
+		report.ParseDurationMS,
+		report.ParserVersion,
+		report.SourceTransport,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert aggregate report: %w", err)
+	}
+	return nil
+}
+
+// insertAggregateRecords inserts records of report, the first one at index
+// offset
+func insertAggregateRecords(ctx context.Context, tx *sql.Tx, report *parser.AggregateReport, offset int, records []parser.Record) error {
+	rows := make([][]any, 0, len(records))
+	for j, record := range records {
+		i := offset + j
+		// Convert policy override reasons
+		reasons, comments := []string{}, []string{}
+		for _, reason := range record.PolicyEvaluated.PolicyOverrideReasons {
+			if reason.Type != nil {
+				reasons = append(reasons, *reason.Type)
+			} else {
+				reasons = append(reasons, "none")
+			}
+			if reason.Comment != nil {
+				comments = append(comments, *reason.Comment)
+			} else {
+				comments = append(comments, "none")
+			}
+		}
+
+		// Convert auth results
+		dkimDomains, dkimSelectors, dkimResults := []string{}, []string{}, []string{}
+		for _, dkim := range record.AuthResults.DKIM {
+			dkimDomains = append(dkimDomains, dkim.Domain)
+			dkimSelectors = append(dkimSelectors, dkim.Selector)
+			dkimResults = append(dkimResults, dkim.Result)
+		}
+
+		spfDomains, spfScopes, spfResults := []string{}, []string{}, []string{}
+		for _, spf := range record.AuthResults.SPF {
+			spfDomains = append(spfDomains, spf.Domain)
+			spfScopes = append(spfScopes, spf.Scope)
+			spfResults = append(spfResults, spf.Result)
+		}
+
+		rows = append(rows, []any{
+			report.ReportMetadata.ReportID,
+			report.ReportMetadata.OrgName,
+			i,
+			record.Source.IPAddress,
+			record.Source.Country,
+			record.Source.City,
+			record.Source.Latitude,
+			record.Source.Longitude,
+			int64(record.Source.ASN),
+			record.Source.ASNOrg,
+			record.Source.ReverseDNS,
+			record.Source.BaseDomain,
+			record.Source.Name,
+			record.Source.Type,
+			record.Count,
+			record.Alignment.SPF,
+			record.Alignment.DKIM,
+			record.Alignment.DMARC,
+			record.PolicyEvaluated.Disposition,
+			record.EffectivePolicy,
+			record.SampledOut,
+			reasons,
+			comments,
+			record.Identifiers.EnvelopeFrom,
+			record.Identifiers.HeaderFrom,
+			record.Identifiers.EnvelopeTo,
+			dkimDomains,
+			dkimSelectors,
+			dkimResults,
+			spfDomains,
+			spfScopes,
+			spfResults,
+			report.ReportMetadata.BeginDate,
+		})
+	}
+
+	if err := insertRows(ctx, tx, "dmarc_aggregate_records", aggregateRecordColumns, rows); err != nil {
+		return fmt.Errorf("failed to insert aggregate records: %w", err)
+	}
+	return nil
+}
+
+// StoreForensicReport stores a forensic DMARC report in PostgreSQL
+func (s *Storage) StoreForensicReport(report *parser.ForensicReport) error {
+	reportSQL := `
+	INSERT INTO dmarc_forensic_reports (
+		feedback_type, user_agent, version, original_envelope_id, original_mail_from,
+		original_rcpt_to, arrival_date, arrival_date_utc, subject, message_id,
+		authentication_results, parsed_authentication_results, dkim_domain,
+		source_ip_address, source_country, source_city, source_latitude,
+		source_longitude, source_asn, source_asn_org, source_reverse_dns,
+		source_base_domain, source_name, source_type, delivery_result, auth_failure,
+		auth_failure_raw, reported_domain, authentication_mechanisms,
+		authentication_mechanisms_raw, sample_headers_only, sample, parsed_sample,
+		parse_duration_ms, parser_version, source_transport
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
+		$18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30,
+		$31, $32, $33, $34, $35, $36)`
+
+	// NULL when there are no parsed results
+	var authResults any
+	if len(report.ParsedAuthenticationResults) > 0 {
+		data, err := json.Marshal(report.ParsedAuthenticationResults)
+		if err != nil {
+			return fmt.Errorf("failed to marshal authentication results: %w", err)
+		}
+		authResults = string(data)
+	}
+
+	var parsedSample any
+	if len(report.ParsedSample) > 0 {
+		parsedSample = string(report.ParsedSample)
+	}
+
+	_, err := s.db.ExecContext(context.Background(), reportSQL,
+		report.FeedbackType,
+		report.UserAgent,
+		report.Version,
+		report.OriginalEnvelopeID,
+		report.OriginalMailFrom,
+		report.OriginalRcptTo,
+		report.ArrivalDate,
+		report.ArrivalDateUTC,
+		report.Subject,
+		report.MessageID,
+		report.AuthenticationResults,
+		authResults,
+		report.DKIMDomain,
+		report.Source.IPAddress,
+		report.Source.Country,
+		report.Source.City,
+		report.Source.Latitude,
+		report.Source.Longitude,
+		int64(report.Source.ASN),
+		report.Source.ASNOrg,
+		report.Source.ReverseDNS,
+		report.Source.BaseDomain,
+		report.Source.Name,
+		report.Source.Type,
+		report.DeliveryResult,
+		nonNil(report.AuthFailure),
+		nonNil(report.AuthFailureRaw),
+		report.ReportedDomain,
+		nonNil(report.AuthenticationMechanisms),
+		nonNil(report.AuthenticationMechanismsRaw),
+		report.SampleHeadersOnly,
+		report.Sample,
+		parsedSample,
+		report.ParseDurationMS,
+		report.ParserVersion,
+		report.SourceTransport,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert forensic report: %w", err)
+	}
+
+	s.logger.Info("Stored forensic report in PostgreSQL",
+		zap.String("subject", report.Subject),
+		zap.String("source_ip", report.Source.IPAddress),
+	)
+
+	return nil
+}
+
+// StoreSMTPTLSReport stores an SMTP TLS report in PostgreSQL. The report and
+// its failure details are written in a single transaction.
+func (s *Storage) StoreSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	reportSQL := `
+	INSERT INTO dmarc_smtp_tls_reports (
+		organization_name, begin_date, end_date, contact_info, report_id,
+		policy_domain, policy_type, policy_strings, mta_sts_policy, mx_host_patterns,
+		successful_session_count, failed_session_count, parse_duration_ms, parser_version,
+		source_transport
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+
+	// As in ClickHouse, the first policy's data is stored in the main table
+	var policyDomain, policyType string
+	var mtaSTSPolicy *string
+	policyStrings, mxHostPatterns := []string{}, []string{}
+	var successfulCount, failedCount int
+
+	if len(report.Policies) > 0 {
+		policy := report.Policies[0]
+		policyDomain = policy.PolicyDomain
+		policyType = policy.PolicyType
+		policyStrings = nonNil(policy.PolicyStrings)
+		mxHostPatterns = nonNil(policy.MXHostPatterns)
+		successfulCount = policy.SuccessfulSessionCount
+		failedCount = policy.FailedSessionCount
+
+		// NULL when the policy is not MTA-STS
+		if policy.MTASTSPolicy != nil {
+			data, err := json.Marshal(policy.MTASTSPolicy)
+			if err != nil {
+				return fmt.Errorf("failed to marshal MTA-STS policy: %w", err)
+			}
+			policyJSON := string(data)
+			mtaSTSPolicy = &policyJSON
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, reportSQL,
+		report.OrganizationName,
+		report.BeginDate,
+		report.EndDate,
+		report.ContactInfo,
+		report.ReportID,
+		policyDomain,
+		policyType,
+		policyStrings,
+		mtaSTSPolicy,
+		mxHostPatterns,
+		successfulCount,
+		failedCount,
+		report.ParseDurationMS,
+		report.ParserVersion,
+		report.SourceTransport,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert SMTP TLS report: %w", err)
+	}
+
+	// Insert failure details for all policies
+	var rows [][]any
+	for _, policy := range report.Policies {
+		for i, failure := range policy.FailureDetails {
+			rows = append(rows, []any{
+				report.ReportID,
+				report.OrganizationName,
+				policy.PolicyDomain,
+				i,
+				failure.ResultType,
+				failure.FailedSessionCount,
+				failure.SendingMTAIP,
+				failure.ReceivingIP,
+				failure.ReceivingMXHostname,
+				failure.ReceivingMXHelo,
+				failure.AdditionalInfoURI,
+				failure.FailureReasonCode,
+			})
+		}
+	}
+
+	if err := insertRows(ctx, tx, "dmarc_smtp_tls_failures", smtpTLSFailureColumns, rows); err != nil {
+		return fmt.Errorf("failed to insert SMTP TLS failure details: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit SMTP TLS report: %w", err)
+	}
+
+	s.logger.Info("Stored SMTP TLS report in PostgreSQL",
+		zap.String("org", report.OrganizationName),
+		zap.String("report_id", report.ReportID),
+		zap.Int("policies", len(report.Policies)),
+	)
+
+	return nil
+}
+
+// StoreUnparsedReport records metadata about input that could not be parsed
+func (s *Storage) StoreUnparsedReport(report *parser.UnparsedReport) error {
+	insertSQL := `
+	INSERT INTO dmarc_unparsed_reports (
+		received_at, source, size, content_type, reason, error, preview
+	) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := s.db.ExecContext(context.Background(), insertSQL,
+		report.ReceivedAt,
+		report.Source,
+		report.Size,
+		report.ContentType,
+		report.Reason,
+		report.Error,
+		report.Preview,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert unparsed report: %w", err)
+	}
+
+	s.logger.Debug("Stored unparsed report metadata in PostgreSQL",
+		zap.String("source", report.Source),
+		zap.String("reason", report.Reason),
+		zap.Int("size", report.Size),
+	)
+
+	return nil
+}
+
+// reportTable is a table holding rows of a report type, with the column of
+// the reporting organization
+type reportTable struct {
+	name      string
+	orgColumn string
+}
+
+// reportCondition is the WHERE condition matching the rows of one report,
+// taking the organization and the report ID as parameters
+func (t reportTable) reportCondition() string {
+	return t.orgColumn + " = $1 AND report_id = $2"
+}
+
+// reportTables lists the tables holding rows of each report type, the
+// report table first
+var reportTables = map[string][]reportTable{
+	"aggregate": {
+		{"dmarc_aggregate_reports", "org_name"},
+		{"dmarc_aggregate_records", "org_name"},
+	},
+	"smtp_tls": {
+		{"dmarc_smtp_tls_reports", "organization_name"},
+		{"dmarc_smtp_tls_failures", "organization_name"},
+	},
+}
+
+// ReportExists reports whether a report of the organization with the given ID
+// is already stored. Report IDs are only unique per organization.
+func (s *Storage) ReportExists(reportType, orgName, reportID string) (bool, error) {
+	tables, ok := reportTables[reportType]
+	if !ok {
+		return false, fmt.Errorf("unsupported report type: %s", reportType)
+	}
+
+	var exists bool
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s)", tables[0].name, tables[0].reportCondition())
+	if err := s.db.QueryRowContext(context.Background(), query, orgName, reportID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to look up %s report: %w", reportType, err)
+	}
+
+	return exists, nil
+}
+
+// FingerprintExists reports whether a report with the given fingerprint is
+// already stored. Only aggregate reports carry a fingerprint.
+func (s *Storage) FingerprintExists(reportType, fingerprint string) (bool, error) {
+	if reportType != "aggregate" {
+		return false, fmt.Errorf("unsupported report type: %s", reportType)
+	}
+
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM dmarc_aggregate_reports WHERE fingerprint = $1)"
+	if err := s.db.QueryRowContext(context.Background(), query, fingerprint).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to look up %s report: %w", reportType, err)
+	}
+
+	return exists, nil
+}
+
+// DeleteReport removes every row of the report of the organization with the
+// given ID
+func (s *Storage) DeleteReport(reportType, orgName, reportID string) error {
+	tables, ok := reportTables[reportType]
+	if !ok {
+		return fmt.Errorf("unsupported report type: %s", reportType)
+	}
+
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s", table.name, table.reportCondition())
+		if _, err := tx.ExecContext(ctx, query, orgName, reportID); err != nil {
+			return fmt.Errorf("failed to delete from %s: %w", table.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit report deletion: %w", err)
+	}
+
+	s.logger.Info("Deleted report from PostgreSQL",
+		zap.String("type", reportType),
+		zap.String("org", orgName),
+		zap.String("report_id", reportID),
+	)
+
+	return nil
+}
+
+// insertRows inserts rows with multi-row INSERT statements, splitting them
+// so that no statement exceeds PostgreSQL's bind parameter limit
+func insertRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	rowsPerStatement := maxParameters / len(columns)
+
+	for start := 0; start < len(rows); start += rowsPerStatement {
+		end := min(start+rowsPerStatement, len(rows))
+		chunk := rows[start:end]
+
+		args := make([]any, 0, len(chunk)*len(columns))
+		for _, row := range chunk {
+			args = append(args, row...)
+		}
+
+		if _, err := tx.ExecContext(ctx, buildMultiRowInsert(table, columns, len(chunk)), args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildMultiRowInsert builds an INSERT statement with numbered placeholders
+// for rowCount rows
+func buildMultiRowInsert(table string, columns []string, rowCount int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+
+	param := 1
+	for row := 0; row < rowCount; row++ {
+		if row > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for col := range columns {
+			if col > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(param))
+			param++
+		}
+		b.WriteByte(')')
+	}
+
+	return b.String()
+}
+
+// nonNil returns an empty slice for nil so that NOT NULL array columns
+// receive '{}' instead of NULL
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// firstLine returns the first line of a SQL statement for error messages
+func firstLine(statement string) string {
+	line, _, _ := strings.Cut(statement, "\n")
+	return strings.TrimSpace(line)
+}
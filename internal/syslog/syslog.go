@@ -0,0 +1,371 @@
+package syslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/parser"
+)
+
+// facilities maps syslog facility names to their RFC 5424 codes
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// severities maps syslog severity names to their RFC 5424 codes
+var severities = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"warning": 4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+// sdID is the SD-ID of the structured data element carrying the key fields
+// of a report, under the enterprise number reserved for documentation
+// (RFC 5612)
+const sdID = "parsedmarc@32473"
+
+// sdParam is a structured data parameter
+type sdParam struct {
+	name  string
+	value string
+}
+
+// Client represents a syslog client for sending reports
+type Client struct {
+	config *config.SyslogConfig
+	logger *zap.Logger
+}
+
+// New creates a new syslog client
+func New(cfg *config.SyslogConfig, logger *zap.Logger) *Client {
+	return &Client{
+		config: cfg,
+		logger: logger,
+	}
+}
+
+// SendAggregateReport sends an aggregate DMARC report to syslog. Over
+// datagram transports a report too large for one message is sent as one
+// message per record.
+func (c *Client) SendAggregateReport(report *parser.AggregateReport) error {
+	if !c.config.Enabled {
+		return nil
+	}
+
+	c.logger.Debug("Sending aggregate report to syslog",
+		zap.String("address", c.config.Address),
+		zap.String("report_id", report.ReportMetadata.ReportID),
+	)
+
+	message, err := c.aggregateMessage(report, -1)
+	if err != nil {
+		return err
+	}
+	if !c.datagram() || len(message) <= c.maxDatagramSize() || len(report.Records) <= 1 {
+		return c.writeMessage(message)
+	}
+
+	c.logger.Debug("Splitting aggregate report into one syslog message per record",
+		zap.String("report_id", report.ReportMetadata.ReportID),
+		zap.Int("size", len(message)),
+		zap.Int("records", len(report.Records)),
+	)
+
+	for i := range report.Records {
+		message, err := c.aggregateMessage(report, i)
+		if err != nil {
+			return err
+		}
+		if err := c.writeMessage(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aggregateMessage formats an aggregate report as a syslog message, or only
+// the record at recordIndex with the report metadata unless it is negative
+func (c *Client) aggregateMessage(report *parser.AggregateReport, recordIndex int) ([]byte, error) {
+	if recordIndex >= 0 {
+		single := *report
+		single.Records = report.Records[recordIndex : recordIndex+1]
+		report = &single
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal aggregate report: %w", err)
+	}
+
+	messages := 0
+	for _, record := range report.Records {
+		messages += record.Count
+	}
+
+	params := []sdParam{
+		{"report_id", report.ReportMetadata.ReportID},
+		{"org_name", report.ReportMetadata.OrgName},
+		{"domain", report.PolicyPublished.Domain},
+		{"begin_date", report.ReportMetadata.BeginDate.UTC().Format(time.RFC3339)},
+		{"end_date", report.ReportMetadata.EndDate.UTC().Format(time.RFC3339)},
+		{"messages", strconv.Itoa(messages)},
+	}
+	if recordIndex >= 0 {
+		params = append(params, sdParam{"record_index", strconv.Itoa(recordIndex)})
+	}
+
+	return c.formatReportMessage("aggregate", params, data)
+}
+
+// SendForensicReport sends a forensic DMARC report to syslog
+func (c *Client) SendForensicReport(report *parser.ForensicReport) error {
+	if !c.config.Enabled {
+		return nil
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal forensic report: %w", err)
+	}
+
+	c.logger.Debug("Sending forensic report to syslog",
+		zap.String("address", c.config.Address),
+		zap.String("domain", report.ReportedDomain),
+	)
+
+	// Reports without an Arrival-Date leave it out
+	arrivalDate := ""
+	if !report.ArrivalDateUTC.IsZero() {
+		arrivalDate = report.ArrivalDateUTC.UTC().Format(time.RFC3339)
+	}
+
+	return c.sendMessage("forensic", []sdParam{
+		{"reported_domain", report.ReportedDomain},
+		{"source_ip", report.Source.IPAddress},
+		{"feedback_type", report.FeedbackType},
+		{"delivery_result", report.DeliveryResult},
+		{"auth_failure", strings.Join(report.AuthFailure, ",")},
+		{"arrival_date", arrivalDate},
+	}, data)
+}
+
+// SendSMTPTLSReport sends an SMTP TLS report to syslog
+func (c *Client) SendSMTPTLSReport(report *parser.SMTPTLSReport) error {
+	if !c.config.Enabled {
+		return nil
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SMTP TLS report: %w", err)
+	}
+
+	c.logger.Debug("Sending SMTP TLS report to syslog",
+		zap.String("address", c.config.Address),
+		zap.String("report_id", report.ReportID),
+	)
+
+	successful, failed := 0, 0
+	for _, policy := range report.Policies {
+		successful += policy.SuccessfulSessionCount
+		failed += policy.FailedSessionCount
+	}
+
+	return c.sendMessage("smtp_tls", []sdParam{
+		{"report_id", report.ReportID},
+		{"organization_name", report.OrganizationName},
+		{"successful_sessions", strconv.Itoa(successful)},
+		{"failed_sessions", strconv.Itoa(failed)},
+	}, data)
+}
+
+// sendMessage formats the payload and the key fields of the report in params
+// as an RFC 5424 message and writes it to the configured endpoint
+func (c *Client) sendMessage(msgID string, params []sdParam, payload []byte) error {
+	message, err := c.formatReportMessage(msgID, params, payload)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(message)
+}
+
+// formatReportMessage formats the payload and the key fields of the report
+// in params as an RFC 5424 message
+func (c *Client) formatReportMessage(msgID string, params []sdParam, payload []byte) ([]byte, error) {
+	priority, err := c.priority()
+	if err != nil {
+		return nil, err
+	}
+	return c.formatMessage(priority, msgID, structuredData(params), payload, time.Now()), nil
+}
+
+// network returns the configured transport, udp by default
+func (c *Client) network() string {
+	if network := strings.ToLower(c.config.Network); network != "" {
+		return network
+	}
+	return "udp"
+}
+
+// datagram reports whether the configured transport sends each message as a
+// single datagram
+func (c *Client) datagram() bool {
+	switch c.network() {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// maxDatagramSize returns the largest message sent over datagram transports,
+// by default the largest UDP payload over IPv4
+func (c *Client) maxDatagramSize() int {
+	if c.config.MaxDatagramSize > 0 {
+		return c.config.MaxDatagramSize
+	}
+	return 65507
+}
+
+// writeMessage writes a formatted message to the configured endpoint.
+// Messages too large for a datagram are truncated rather than dropped.
+func (c *Client) writeMessage(message []byte) error {
+	network := c.network()
+
+	if c.datagram() && len(message) > c.maxDatagramSize() {
+		c.logger.Warn("Truncating syslog message larger than a datagram",
+			zap.Int("size", len(message)),
+			zap.Int("max_datagram_size", c.maxDatagramSize()),
+		)
+		message = truncateUTF8(message, c.maxDatagramSize())
+	}
+
+	conn, err := net.DialTimeout(network, c.config.Address, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog %s://%s: %w", network, c.config.Address, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return fmt.Errorf("failed to set syslog write deadline: %w", err)
+	}
+
+	// Stream transports use octet-counting framing (RFC 6587 section 3.4.1)
+	if network == "tcp" || network == "tcp4" || network == "tcp6" || network == "unix" {
+		message = append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	}
+
+	if _, err := conn.Write(message); err != nil {
+		return fmt.Errorf("failed to send message to syslog: %w", err)
+	}
+
+	return nil
+}
+
+// truncateUTF8 cuts message to at most size bytes without splitting a UTF-8
+// sequence
+func truncateUTF8(message []byte, size int) []byte {
+	for size > 0 && !utf8.RuneStart(message[size]) {
+		size--
+	}
+	return message[:size]
+}
+
+// priority computes the PRI value from the configured facility and severity
+func (c *Client) priority() (int, error) {
+	facility, ok := facilities[strings.ToLower(c.config.Facility)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility: %s", c.config.Facility)
+	}
+
+	severity, ok := severities[strings.ToLower(c.config.Severity)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog severity: %s", c.config.Severity)
+	}
+
+	return facility*8 + severity, nil
+}
+
+// formatMessage builds an RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (c *Client) formatMessage(priority int, msgID, structuredData string, payload []byte, timestamp time.Time) []byte {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	appName := c.config.Tag
+	if appName == "" {
+		appName = "parsedmarc"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d %s %s ",
+		priority,
+		timestamp.UTC().Format(time.RFC3339Nano),
+		hostname,
+		appName,
+		os.Getpid(),
+		msgID,
+		structuredData,
+	)
+	buf.Write(payload)
+
+	return buf.Bytes()
+}
+
+// sdValueEscaper escapes the characters RFC 5424 requires to be escaped in
+// structured data parameter values
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// structuredData formats params as a single SD-ELEMENT, leaving out empty
+// values, or as the NILVALUE "-" without any
+func structuredData(params []sdParam) string {
+	var buf strings.Builder
+	for _, param := range params {
+		if param.value == "" {
+			continue
+		}
+		if buf.Len() == 0 {
+			buf.WriteString("[" + sdID)
+		}
+		fmt.Fprintf(&buf, ` %s="%s"`, param.name, sdValueEscaper.Replace(param.value))
+	}
+	if buf.Len() == 0 {
+		return "-"
+	}
+	buf.WriteString("]")
+	return buf.String()
+}
@@ -0,0 +1,370 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/zaptest"
+	"parsedmarc-go/internal/config"
+	"parsedmarc-go/internal/parser"
+)
+
+var rfc5424Pattern = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\d+) (\S+) (-|\[(?:[^\]\\]|\\.)*\]) (.*)$`)
+
+func testAggregateReport() *parser.AggregateReport {
+	return &parser.AggregateReport{
+		XMLSchema: "1.0",
+		ReportMetadata: parser.ReportMetadata{
+			OrgName:   "Test Org",
+			OrgEmail:  "test@example.com",
+			ReportID:  "test-123",
+			BeginDate: time.Now().Add(-24 * time.Hour),
+			EndDate:   time.Now(),
+		},
+		PolicyPublished: parser.PolicyPublished{
+			Domain: "example.com",
+			P:      "none",
+		},
+	}
+}
+
+// assertAggregateMessage checks that msg is a well-formed RFC 5424 message
+// carrying the aggregate report as JSON
+func assertAggregateMessage(t *testing.T, msg string, wantPRI int) {
+	t.Helper()
+
+	m := rfc5424Pattern.FindStringSubmatch(msg)
+	if m == nil {
+		t.Fatalf("Message is not RFC 5424: %q", msg)
+	}
+
+	if pri, _ := strconv.Atoi(m[1]); pri != wantPRI {
+		t.Errorf("Expected PRI %d, got %d", wantPRI, pri)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, m[2]); err != nil {
+		t.Errorf("Invalid timestamp %q: %v", m[2], err)
+	}
+	if m[4] != "parsedmarc" {
+		t.Errorf("Expected APP-NAME parsedmarc, got %s", m[4])
+	}
+	if m[6] != "aggregate" {
+		t.Errorf("Expected MSGID aggregate, got %s", m[6])
+	}
+
+	if !strings.Contains(m[7], `report_id="test-123"`) || !strings.Contains(m[7], `domain="example.com"`) {
+		t.Errorf("Expected key fields in structured data, got %s", m[7])
+	}
+
+	var report parser.AggregateReport
+	if err := json.Unmarshal([]byte(m[8]), &report); err != nil {
+		t.Fatalf("Message body is not valid JSON: %v", err)
+	}
+	if report.ReportMetadata.ReportID != "test-123" {
+		t.Errorf("Expected report ID test-123, got %s", report.ReportMetadata.ReportID)
+	}
+	if strings.Contains(m[8], "\n") {
+		t.Error("Expected compact JSON without newlines")
+	}
+}
+
+func TestSyslogClient_SendAggregateReportUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock syslog listener: %v", err)
+	}
+	defer listener.Close()
+
+	cfg := &config.SyslogConfig{
+		Enabled:  true,
+		Network:  "udp",
+		Address:  listener.LocalAddr().String(),
+		Facility: "local0",
+		Severity: "info",
+		Tag:      "parsedmarc",
+	}
+
+	client := New(cfg, zaptest.NewLogger(t))
+	if err := client.SendAggregateReport(testAggregateReport()); err != nil {
+		t.Fatalf("SendAggregateReport failed: %v", err)
+	}
+
+	buf := make([]byte, 65536)
+	if err := listener.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("Failed to set read deadline: %v", err)
+	}
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read syslog message: %v", err)
+	}
+
+	// local0 (16) * 8 + info (6)
+	assertAggregateMessage(t, string(buf[:n]), 134)
+}
+
+func TestSyslogClient_SendAggregateReportTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock syslog listener: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Octet-counting framing: "LEN SP MSG"
+		reader := bufio.NewReader(conn)
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(reader, msg); err != nil {
+			return
+		}
+		received <- string(msg)
+	}()
+
+	cfg := &config.SyslogConfig{
+		Enabled:  true,
+		Network:  "tcp",
+		Address:  listener.Addr().String(),
+		Facility: "mail",
+		Severity: "notice",
+	}
+
+	client := New(cfg, zaptest.NewLogger(t))
+	if err := client.SendAggregateReport(testAggregateReport()); err != nil {
+		t.Fatalf("SendAggregateReport failed: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		// mail (2) * 8 + notice (5)
+		assertAggregateMessage(t, msg, 21)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for syslog message")
+	}
+}
+
+func TestSyslogClient_SendForensicReportUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock syslog listener: %v", err)
+	}
+	defer listener.Close()
+
+	cfg := &config.SyslogConfig{
+		Enabled:  true,
+		Network:  "udp",
+		Address:  listener.LocalAddr().String(),
+		Facility: "local0",
+		Severity: "warning",
+	}
+
+	report := &parser.ForensicReport{
+		FeedbackType:   "auth-failure",
+		ReportedDomain: "example.com",
+		Source:         parser.Source{IPAddress: "192.0.2.1"},
+		DeliveryResult: "reject",
+		AuthFailure:    []string{"dmarc"},
+		Subject:        `Quoted "subject" [with] brackets`,
+	}
+
+	client := New(cfg, zaptest.NewLogger(t))
+	if err := client.SendForensicReport(report); err != nil {
+		t.Fatalf("SendForensicReport failed: %v", err)
+	}
+
+	buf := make([]byte, 65536)
+	if err := listener.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("Failed to set read deadline: %v", err)
+	}
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read syslog message: %v", err)
+	}
+
+	m := rfc5424Pattern.FindStringSubmatch(string(buf[:n]))
+	if m == nil {
+		t.Fatalf("Message is not RFC 5424: %q", buf[:n])
+	}
+	if m[6] != "forensic" {
+		t.Errorf("Expected MSGID forensic, got %s", m[6])
+	}
+	for _, param := range []string{`reported_domain="example.com"`, `source_ip="192.0.2.1"`, `delivery_result="reject"`} {
+		if !strings.Contains(m[7], param) {
+			t.Errorf("Expected %s in structured data, got %s", param, m[7])
+		}
+	}
+	if strings.Contains(m[7], "arrival_date") {
+		t.Errorf("Expected no arrival_date for a report without one, got %s", m[7])
+	}
+
+	var received parser.ForensicReport
+	if err := json.Unmarshal([]byte(m[8]), &received); err != nil {
+		t.Fatalf("Message body is not valid JSON: %v", err)
+	}
+	if received.ReportedDomain != "example.com" || received.Subject != report.Subject {
+		t.Errorf("Unexpected report in message body: %+v", received)
+	}
+}
+
+func TestSyslogClient_SplitsLargeAggregateReportUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock syslog listener: %v", err)
+	}
+	defer listener.Close()
+
+	cfg := &config.SyslogConfig{
+		Enabled:         true,
+		Network:         "udp",
+		Address:         listener.LocalAddr().String(),
+		Facility:        "local0",
+		Severity:        "info",
+		MaxDatagramSize: 2048,
+	}
+
+	report := testAggregateReport()
+	for i := 0; i < 20; i++ {
+		report.Records = append(report.Records, parser.Record{
+			Source: parser.Source{IPAddress: "192.0.2." + strconv.Itoa(i)},
+			Count:  i + 1,
+		})
+	}
+
+	client := New(cfg, zaptest.NewLogger(t))
+	if err := client.SendAggregateReport(report); err != nil {
+		t.Fatalf("SendAggregateReport failed: %v", err)
+	}
+
+	buf := make([]byte, 65536)
+	for i := range report.Records {
+		if err := listener.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatalf("Failed to set read deadline: %v", err)
+		}
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Failed to read syslog message %d: %v", i, err)
+		}
+		if n > cfg.MaxDatagramSize {
+			t.Errorf("Message %d is %d bytes, over the %d byte limit", i, n, cfg.MaxDatagramSize)
+		}
+
+		assertAggregateMessage(t, string(buf[:n]), 134)
+		m := rfc5424Pattern.FindStringSubmatch(string(buf[:n]))
+		for _, param := range []string{`record_index="` + strconv.Itoa(i) + `"`, `messages="` + strconv.Itoa(i+1) + `"`} {
+			if !strings.Contains(m[7], param) {
+				t.Errorf("Expected %s in structured data, got %s", param, m[7])
+			}
+		}
+		var received parser.AggregateReport
+		if err := json.Unmarshal([]byte(m[8]), &received); err != nil {
+			t.Fatalf("Message body is not valid JSON: %v", err)
+		}
+		if len(received.Records) != 1 || received.Records[0].Source.IPAddress != report.Records[i].Source.IPAddress {
+			t.Errorf("Expected record %d alone in message, got %+v", i, received.Records)
+		}
+	}
+}
+
+func TestSyslogClient_TruncatesOversizedDatagram(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock syslog listener: %v", err)
+	}
+	defer listener.Close()
+
+	cfg := &config.SyslogConfig{
+		Enabled:         true,
+		Network:         "udp",
+		Address:         listener.LocalAddr().String(),
+		Facility:        "local0",
+		Severity:        "info",
+		MaxDatagramSize: 1024,
+	}
+
+	report := &parser.ForensicReport{
+		ReportedDomain: "example.com",
+		Sample:         strings.Repeat("é", 2048),
+	}
+
+	client := New(cfg, zaptest.NewLogger(t))
+	if err := client.SendForensicReport(report); err != nil {
+		t.Fatalf("SendForensicReport failed: %v", err)
+	}
+
+	buf := make([]byte, 65536)
+	if err := listener.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("Failed to set read deadline: %v", err)
+	}
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read syslog message: %v", err)
+	}
+	if n > cfg.MaxDatagramSize || n < cfg.MaxDatagramSize-utf8.UTFMax {
+		t.Errorf("Expected the message cut to the %d byte limit, got %d bytes", cfg.MaxDatagramSize, n)
+	}
+	if !utf8.Valid(buf[:n]) {
+		t.Error("Expected the message not to be cut inside a UTF-8 sequence")
+	}
+}
+
+func TestStructuredData(t *testing.T) {
+	tests := []struct {
+		params []sdParam
+		want   string
+	}{
+		{nil, "-"},
+		{[]sdParam{{"domain", ""}}, "-"},
+		{[]sdParam{{"domain", "example.com"}, {"org_name", ""}}, `[parsedmarc@32473 domain="example.com"]`},
+		{[]sdParam{{"org_name", `a "b" [c] \d`}}, `[parsedmarc@32473 org_name="a \"b\" [c\] \\d"]`},
+	}
+
+	for _, tt := range tests {
+		if got := structuredData(tt.params); got != tt.want {
+			t.Errorf("structuredData(%v) = %s, want %s", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestSyslogClient_InvalidFacility(t *testing.T) {
+	cfg := &config.SyslogConfig{
+		Enabled:  true,
+		Network:  "udp",
+		Address:  "127.0.0.1:514",
+		Facility: "bogus",
+		Severity: "info",
+	}
+
+	client := New(cfg, zaptest.NewLogger(t))
+	if err := client.SendAggregateReport(testAggregateReport()); err == nil {
+		t.Error("Expected error for unknown facility")
+	}
+}
+
+func TestSyslogClient_Disabled(t *testing.T) {
+	cfg := &config.SyslogConfig{Enabled: false}
+
+	client := New(cfg, zaptest.NewLogger(t))
+	if err := client.SendAggregateReport(testAggregateReport()); err != nil {
+		t.Errorf("Expected nil error when disabled, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DNSCache is an in-memory LRU cache of reverse DNS lookups keyed by IP
+// address. Entries expire after the TTL; failed lookups are cached as well so
+// that addresses without a PTR record are not queried again for every
+// record. It is safe for concurrent use.
+type DNSCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // front is the most recently used entry
+	now     func() time.Time
+}
+
+type dnsCacheEntry struct {
+	ipAddress string
+	hostname  string
+	err       error
+	expires   time.Time
+}
+
+// NewDNSCache creates a cache holding at most size entries for ttl each
+func NewDNSCache(size int, ttl time.Duration) *DNSCache {
+	return &DNSCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Lookup returns the cached result for ipAddress, or calls lookup and caches
+// its result. Concurrent lookups of an address missing from the cache are not
+// coalesced.
+func (c *DNSCache) Lookup(ipAddress string, lookup func(ipAddress string) (string, error)) (string, error) {
+	if hostname, err, ok := c.get(ipAddress); ok {
+		return hostname, err
+	}
+
+	hostname, err := lookup(ipAddress)
+	c.put(ipAddress, hostname, err)
+	return hostname, err
+}
+
+// Len returns the number of cached entries, including expired ones not yet
+// evicted
+func (c *DNSCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *DNSCache) get(ipAddress string) (string, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[ipAddress]
+	if !ok {
+		return "", nil, false
+	}
+
+	entry := elem.Value.(*dnsCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, ipAddress)
+		return "", nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.hostname, entry.err, true
+}
+
+func (c *DNSCache) put(ipAddress, hostname string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &dnsCacheEntry{
+		ipAddress: ipAddress,
+		hostname:  hostname,
+		err:       err,
+		expires:   c.now().Add(c.ttl),
+	}
+
+	if elem, ok := c.entries[ipAddress]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[ipAddress] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsCacheEntry).ipAddress)
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// dangerousSequences are substrings rejected in identifiers
+var dangerousSequences = []string{
+	"<", ">", "&", "\"", "'",
+	"\x00", "\r", "\n",
+	"../", "..\\",
+	"<script", "javascript:",
+	"<?php", "<%",
+}
+
+// dangerousPattern matches any dangerous sequence, case-insensitively
+var dangerousPattern = func() *regexp.Regexp {
+	quoted := make([]string, len(dangerousSequences))
+	for i, sequence := range dangerousSequences {
+		quoted[i] = regexp.QuoteMeta(sequence)
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}()
+
+// ContainsDangerousSequence reports whether input contains a dangerous
+// sequence, see SanitizeIdentifier
+func ContainsDangerousSequence(input string) bool {
+	return dangerousPattern.MatchString(input)
+}
+
+// SanitizeIdentifier removes dangerous sequences from an identifier and
+// truncates it to maxLength bytes (without splitting a UTF-8 character).
+// A maxLength of zero or less disables truncation. It reports whether the
+// value was sanitized and whether it was truncated.
+func SanitizeIdentifier(input string, maxLength int) (result string, sanitized, truncated bool) {
+	result = input
+	for dangerousPattern.MatchString(result) {
+		result = dangerousPattern.ReplaceAllString(result, "")
+		sanitized = true
+	}
+
+	if maxLength > 0 && len(result) > maxLength {
+		cut := maxLength
+		for cut > 0 && !utf8.RuneStart(result[cut]) {
+			cut--
+		}
+		result = result[:cut]
+		truncated = true
+	}
+
+	return result, sanitized, truncated
+}
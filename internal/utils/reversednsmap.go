@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReverseDNSService is the sending service a reverse DNS base domain belongs
+// to, e.g. "Google" of type "Email Provider" for google.com
+type ReverseDNSService struct {
+	Name string
+	Type string
+}
+
+// ReverseDNSMap maps lowercase reverse DNS base domains to their service
+type ReverseDNSMap map[string]ReverseDNSService
+
+// Lookup returns the service using baseDomain as reverse DNS base domain
+func (m ReverseDNSMap) Lookup(baseDomain string) (ReverseDNSService, bool) {
+	service, ok := m[strings.ToLower(baseDomain)]
+	return service, ok
+}
+
+// reverseDNSMapClient downloads reverse DNS maps
+var reverseDNSMapClient = &http.Client{Timeout: 30 * time.Second}
+
+// ReadReverseDNSMapFile reads a reverse DNS map from the file at path
+func ReadReverseDNSMapFile(path string) (ReverseDNSMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open reverse DNS map: %w", err)
+	}
+	defer f.Close()
+
+	return ParseReverseDNSMap(f)
+}
+
+// DownloadReverseDNSMap downloads a reverse DNS map from url
+func DownloadReverseDNSMap(url string) (ReverseDNSMap, error) {
+	resp, err := reverseDNSMapClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download reverse DNS map: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download reverse DNS map: %s", resp.Status)
+	}
+
+	return ParseReverseDNSMap(resp.Body)
+}
+
+// ParseReverseDNSMap reads a reverse DNS map in the CSV format of
+// parsedmarc's base_reverse_dns_map.csv: base_reverse_dns,name,type with an
+// optional header row. The type column may be omitted.
+func ParseReverseDNSMap(r io.Reader) (ReverseDNSMap, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	m := make(ReverseDNSMap)
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse reverse DNS map: %w", err)
+		}
+
+		if line == 1 && strings.EqualFold(row[0], "base_reverse_dns") {
+			continue
+		}
+		if len(row) < 2 {
+			return nil, fmt.Errorf("failed to parse reverse DNS map: line %d has no name", line)
+		}
+
+		baseDomain := strings.ToLower(strings.TrimSpace(row[0]))
+		if baseDomain == "" {
+			continue
+		}
+		service := ReverseDNSService{Name: strings.TrimSpace(row[1])}
+		if len(row) > 2 {
+			service.Type = strings.TrimSpace(row[2])
+		}
+		m[baseDomain] = service
+	}
+
+	return m, nil
+}
@@ -4,12 +4,14 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/oschwald/geoip2-golang"
+	"golang.org/x/net/publicsuffix"
 )
 
 // DefaultString returns the default value if the string is empty
@@ -31,10 +33,12 @@ func ParseTimestamp(timestamp string) (time.Time, error) {
 
 // GeoLocation represents geolocation information
 type GeoLocation struct {
-	Country string
-	City    string
-	ASN     uint
-	ISP     string
+	Country   string
+	City      string
+	Latitude  float64
+	Longitude float64
+	ASN       uint
+	ISP       string
 }
 
 // GetGeoLocation gets geolocation information for an IP address
@@ -45,7 +49,7 @@ func GetGeoLocation(ipAddress, dbPath string) (*GeoLocation, error) {
 	}
 	defer db.Close()
 
-	ip := net.ParseIP(ipAddress)
+	ip := parseIP(ipAddress)
 	if ip == nil {
 		return nil, fmt.Errorf("invalid IP address: %s", ipAddress)
 	}
@@ -56,8 +60,10 @@ func GetGeoLocation(ipAddress, dbPath string) (*GeoLocation, error) {
 	}
 
 	geo := &GeoLocation{
-		Country: city.Country.Names["en"],
-		City:    city.City.Names["en"],
+		Country:   city.Country.Names["en"],
+		City:      city.City.Names["en"],
+		Latitude:  city.Location.Latitude,
+		Longitude: city.Location.Longitude,
 	}
 
 	// Try to get ISP info if available
@@ -70,6 +76,37 @@ func GetGeoLocation(ipAddress, dbPath string) (*GeoLocation, error) {
 	return geo, nil
 }
 
+// ASNInfo is the autonomous system an IP address belongs to
+type ASNInfo struct {
+	ASN uint
+	Org string
+}
+
+// GetASN looks up the autonomous system of an IP address in a GeoLite2-ASN
+// (or GeoIP2-ISP) database
+func GetASN(ipAddress, dbPath string) (*ASNInfo, error) {
+	db, err := geoip2.Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ASN database: %w", err)
+	}
+	defer db.Close()
+
+	ip := parseIP(ipAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ipAddress)
+	}
+
+	asn, err := db.ASN(ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup IP: %w", err)
+	}
+
+	return &ASNInfo{
+		ASN: asn.AutonomousSystemNumber,
+		Org: asn.AutonomousSystemOrganization,
+	}, nil
+}
+
 // GetReverseDNS performs reverse DNS lookup
 func GetReverseDNS(ipAddress string, nameservers []string, timeoutSec int) (string, error) {
 	c := dns.Client{
@@ -77,7 +114,7 @@ func GetReverseDNS(ipAddress string, nameservers []string, timeoutSec int) (stri
 	}
 
 	// Create reverse DNS query
-	addr, err := dns.ReverseAddr(ipAddress)
+	addr, err := dns.ReverseAddr(NormalizeIPAddress(ipAddress))
 	if err != nil {
 		return "", fmt.Errorf("failed to create reverse address: %w", err)
 	}
@@ -87,12 +124,7 @@ func GetReverseDNS(ipAddress string, nameservers []string, timeoutSec int) (stri
 
 	// Try each nameserver
 	for _, ns := range nameservers {
-		server := ns
-		if !strings.Contains(server, ":") {
-			server = server + ":53"
-		}
-
-		r, _, err := c.Exchange(m, server)
+		r, _, err := c.Exchange(m, nameserverAddress(ns))
 		if err != nil {
 			continue
 		}
@@ -112,41 +144,165 @@ func GetReverseDNS(ipAddress string, nameservers []string, timeoutSec int) (stri
 	return "", fmt.Errorf("no PTR records found")
 }
 
-// GetBaseDomain extracts base domain from hostname
-func GetBaseDomain(hostname string) string {
-	if hostname == "" {
-		return ""
+// GetDMARCRecord returns the DMARC record published in DNS for domain, the
+// TXT record of _dmarc.<domain> starting with v=DMARC1
+func GetDMARCRecord(domain string, nameservers []string, timeoutSec int) (string, error) {
+	c := dns.Client{
+		Timeout: time.Duration(timeoutSec) * time.Second,
 	}
 
-	parts := strings.Split(hostname, ".")
-	if len(parts) < 2 {
-		return hostname
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn("_dmarc."+domain), dns.TypeTXT)
+
+	// Try each nameserver
+	for _, ns := range nameservers {
+		r, _, err := c.Exchange(m, nameserverAddress(ns))
+		if err != nil {
+			continue
+		}
+
+		if r.Rcode == dns.RcodeNameError {
+			break
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			continue
+		}
+
+		for _, ans := range r.Answer {
+			if txt, ok := ans.(*dns.TXT); ok {
+				record := strings.Join(txt.Txt, "")
+				if strings.HasPrefix(strings.ToLower(record), "v=dmarc1") {
+					return record, nil
+				}
+			}
+		}
+		break
 	}
 
-	// Handle special cases like Akamai CDN (e.g., "e3191.c.akamaiedge.net" -> "c.akamaiedge.net")
-	if len(parts) >= 3 && parts[len(parts)-2] == "akamaiedge" {
-		return strings.Join(parts[len(parts)-3:], ".")
+	return "", fmt.Errorf("no DMARC record found for %s", domain)
+}
+
+// ParseDMARCRecord returns the tags of a DMARC record, with lowercase names
+// and values, e.g. {"v": "dmarc1", "p": "reject"}
+func ParseDMARCRecord(record string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(record, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			tags[name] = strings.ToLower(strings.TrimSpace(value))
+		}
 	}
+	return tags
+}
 
-	// Handle other special CDN cases
-	specialCases := map[string]int{
-		"cloudfront.net": 3, // xxx.cloudfront.net
-		"fastly.com":     3, // xxx.fastly.com
-		"herokuapp.com":  3, // xxx.herokuapp.com
+// nameserverAddress adds the default DNS port to a nameserver given without
+// one. IPv6 literals are bracketed, so "2606:4700:4700::1111" becomes
+// "[2606:4700:4700::1111]:53".
+func nameserverAddress(ns string) string {
+	if _, _, err := net.SplitHostPort(ns); err == nil {
+		return ns
 	}
+	return net.JoinHostPort(strings.Trim(ns, "[]"), "53")
+}
 
-	domain := strings.Join(parts[len(parts)-2:], ".")
-	if extraParts, exists := specialCases[domain]; exists && len(parts) >= extraParts {
-		return strings.Join(parts[len(parts)-extraParts:], ".")
+// GetBaseDomain extracts the base domain (eTLD+1) from hostname using the
+// public suffix list, so "mail.example.co.uk" becomes "example.co.uk" and
+// "d111111abcdef8.cloudfront.net" is kept whole. Single-label hosts, public
+// suffixes and IP addresses are returned unchanged.
+func GetBaseDomain(hostname string) string {
+	if hostname == "" || !strings.Contains(hostname, ".") || net.ParseIP(hostname) != nil {
+		return hostname
 	}
 
-	// Return last two parts (e.g., "example.com" from "mail.example.com")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(strings.TrimSuffix(hostname, ".")))
+	if err != nil {
+		return hostname
+	}
 	return domain
 }
 
 // IsValidIPAddress checks if string is a valid IP address
 func IsValidIPAddress(ip string) bool {
-	return net.ParseIP(ip) != nil
+	return parseIP(ip) != nil
+}
+
+// NormalizeIPAddress returns the canonical text form of an IP address:
+// surrounding whitespace, brackets and IPv6 zones are removed, IPv6 addresses
+// are compressed and lowercased (RFC 5952) and IPv4-mapped IPv6 addresses
+// become plain IPv4. Anything that is not an IP address is only trimmed.
+func NormalizeIPAddress(ipAddress string) string {
+	ipAddress = strings.TrimSpace(ipAddress)
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(ipAddress, "["), "]")
+
+	addr, err := netip.ParseAddr(trimmed)
+	if err != nil {
+		return ipAddress
+	}
+	return addr.WithZone("").Unmap().String()
+}
+
+// parseIP parses an IP address after normalizing it, returning nil when it is
+// not valid
+func parseIP(ipAddress string) net.IP {
+	return net.ParseIP(NormalizeIPAddress(ipAddress))
+}
+
+// reservedNetworks lists special-purpose ranges (RFC 6890) that are neither
+// RFC 1918/4193 private nor routable on the public internet
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // shared address space (CGNAT)
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"240.0.0.0/4",     // reserved for future use
+	"2001:db8::/32",   // documentation
+)
+
+// mustParseCIDRs parses a list of CIDR networks, panicking on invalid input
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// ClassifyIPAddress returns "private" for RFC 1918/4193 addresses, "reserved"
+// for loopback, link-local, multicast, unspecified and other special-purpose
+// ranges, and "" for public or invalid addresses
+func ClassifyIPAddress(ipAddress string) string {
+	ip := parseIP(ipAddress)
+	if ip == nil {
+		return ""
+	}
+
+	if ip.IsPrivate() {
+		return "private"
+	}
+
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return "reserved"
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return "reserved"
+		}
+	}
+
+	return ""
 }
 
 // NormalizeEmail converts email to lowercase and trims spaces
@@ -1,8 +1,22 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
 func TestDecodeBase64(t *testing.T) {
@@ -87,6 +101,36 @@ func TestGetBaseDomain(t *testing.T) {
 			input:    "e3191.c.akamaiedge.net",
 			expected: "c.akamaiedge.net",
 		},
+		{
+			name:     "Multi-label public suffix",
+			input:    "foo.example.co.uk",
+			expected: "example.co.uk",
+		},
+		{
+			name:     "Australian domain",
+			input:    "mail.example.com.au",
+			expected: "example.com.au",
+		},
+		{
+			name:     "Private public suffix",
+			input:    "d111111abcdef8.cloudfront.net",
+			expected: "d111111abcdef8.cloudfront.net",
+		},
+		{
+			name:     "Uppercase with trailing dot",
+			input:    "Mail.Example.CO.UK.",
+			expected: "example.co.uk",
+		},
+		{
+			name:     "Public suffix only",
+			input:    "co.uk",
+			expected: "co.uk",
+		},
+		{
+			name:     "IP address",
+			input:    "192.0.2.1",
+			expected: "192.0.2.1",
+		},
 		{
 			name:     "Already base domain",
 			input:    "example.com",
@@ -156,3 +200,542 @@ func TestNormalizeHost(t *testing.T) {
 		})
 	}
 }
+
+func TestClassifyIPAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "RFC 1918",
+			input:    "10.1.2.3",
+			expected: "private",
+		},
+		{
+			name:     "IPv6 unique local",
+			input:    "fd00::1",
+			expected: "private",
+		},
+		{
+			name:     "Loopback",
+			input:    "127.0.0.1",
+			expected: "reserved",
+		},
+		{
+			name:     "Link-local",
+			input:    "169.254.10.1",
+			expected: "reserved",
+		},
+		{
+			name:     "Documentation range",
+			input:    "2001:db8::1",
+			expected: "reserved",
+		},
+		{
+			name:     "Shared address space",
+			input:    "100.64.0.1",
+			expected: "reserved",
+		},
+		{
+			name:     "Public IPv4",
+			input:    "8.8.8.8",
+			expected: "",
+		},
+		{
+			name:     "Public IPv6",
+			input:    "2607:f8b0:4004:800::200e",
+			expected: "",
+		},
+		{
+			name:     "IPv4-mapped private",
+			input:    "::ffff:10.1.2.3",
+			expected: "private",
+		},
+		{
+			name:     "Expanded IPv6 loopback",
+			input:    " 0000:0000:0000:0000:0000:0000:0000:0001 ",
+			expected: "reserved",
+		},
+		{
+			name:     "Invalid",
+			input:    "not-an-ip",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ClassifyIPAddress(tt.input)
+			if result != tt.expected {
+				t.Errorf("ClassifyIPAddress(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeIPAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"8.8.8.8", "8.8.8.8"},
+		{" 8.8.8.8\n", "8.8.8.8"},
+		{"2a00:1450:4001:80b::200e", "2a00:1450:4001:80b::200e"},
+		{"2A00:1450:4001:080B:0000:0000:0000:200E", "2a00:1450:4001:80b::200e"},
+		{"\n    2001:4860:0:0::8888\n  ", "2001:4860::8888"},
+		{"[2001:4860::8888]", "2001:4860::8888"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"::ffff:8.8.8.8", "8.8.8.8"},
+		{"::FFFF:0808:0808", "8.8.8.8"},
+		{" not-an-ip ", "not-an-ip"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if result := NormalizeIPAddress(tt.input); result != tt.expected {
+			t.Errorf("NormalizeIPAddress(%q) = %q, want %q", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestNameserverAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.1.1.1", "1.1.1.1:53"},
+		{"1.1.1.1:5353", "1.1.1.1:5353"},
+		{"2606:4700:4700::1111", "[2606:4700:4700::1111]:53"},
+		{"[2606:4700:4700::1111]", "[2606:4700:4700::1111]:53"},
+		{"[::1]:5353", "[::1]:5353"},
+		{"dns.example.com", "dns.example.com:53"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := nameserverAddress(tt.input); got != tt.expected {
+				t.Errorf("nameserverAddress(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+// startMockDNSServer serves PTR answers from ptrs and TXT answers from txts,
+// keyed by query name, and returns the server address
+func startMockDNSServer(t *testing.T, ptrs, txts map[string]string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock DNS server: %v", err)
+	}
+
+	server := &dns.Server{
+		PacketConn: conn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			question := r.Question[0]
+			if target, ok := ptrs[question.Name]; ok && question.Qtype == dns.TypePTR {
+				m.Answer = append(m.Answer, &dns.PTR{
+					Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60},
+					Ptr: target,
+				})
+			} else if txt, ok := txts[question.Name]; ok && question.Qtype == dns.TypeTXT {
+				m.Answer = append(m.Answer, &dns.TXT{
+					Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+					Txt: []string{txt},
+				})
+			} else {
+				m.Rcode = dns.RcodeNameError
+			}
+			_ = w.WriteMsg(m)
+		}),
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return conn.LocalAddr().String()
+}
+
+func TestGetReverseDNS(t *testing.T) {
+	nameserver := startMockDNSServer(t, map[string]string{
+		"8.8.8.8.in-addr.arpa.": "dns.google.",
+		"e.0.0.2.0.0.0.0.0.0.0.0.0.0.0.0.b.0.8.0.1.0.0.4.0.5.4.1.0.0.a.2.ip6.arpa.": "fra16s56-in-x0e.1e100.net.",
+	}, nil)
+
+	tests := []struct {
+		name     string
+		ip       string
+		expected string
+	}{
+		{"IPv4", "8.8.8.8", "dns.google"},
+		{"IPv6", "2a00:1450:4001:80b::200e", "fra16s56-in-x0e.1e100.net"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetReverseDNS(tt.ip, []string{nameserver}, 2)
+			if err != nil {
+				t.Fatalf("GetReverseDNS(%s) error = %v", tt.ip, err)
+			}
+			if got != tt.expected {
+				t.Errorf("GetReverseDNS(%s) = %q, want %q", tt.ip, got, tt.expected)
+			}
+		})
+	}
+
+	if _, err := GetReverseDNS("2001:4860:4860::8888", []string{nameserver}, 2); err == nil {
+		t.Error("Expected error for address without PTR record")
+	}
+}
+
+func TestGetDMARCRecord(t *testing.T) {
+	nameserver := startMockDNSServer(t, nil, map[string]string{
+		"_dmarc.example.com.": "v=DMARC1; p=reject; rua=mailto:dmarc@example.com",
+		"_dmarc.example.org.": "v=spf1 -all",
+	})
+
+	record, err := GetDMARCRecord("example.com", []string{nameserver}, 2)
+	if err != nil {
+		t.Fatalf("GetDMARCRecord() error = %v", err)
+	}
+	if record != "v=DMARC1; p=reject; rua=mailto:dmarc@example.com" {
+		t.Errorf("GetDMARCRecord() = %q", record)
+	}
+
+	for _, domain := range []string{"example.org", "example.net"} {
+		if _, err := GetDMARCRecord(domain, []string{nameserver}, 2); err == nil {
+			t.Errorf("Expected error for %s without DMARC record", domain)
+		}
+	}
+}
+
+func TestParseDMARCRecord(t *testing.T) {
+	got := ParseDMARCRecord("v=DMARC1; p=Reject;sp=none; pct = 50; rua=mailto:dmarc@example.com;")
+	want := map[string]string{
+		"v":   "dmarc1",
+		"p":   "reject",
+		"sp":  "none",
+		"pct": "50",
+		"rua": "mailto:dmarc@example.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDMARCRecord() = %v, want %v", got, want)
+	}
+}
+
+func TestGetGeoLocation(t *testing.T) {
+	dbPath := writeTestGeoIPDB(t)
+
+	tests := []struct {
+		name     string
+		ip       string
+		expected GeoLocation
+	}{
+		{"IPv4", "8.8.8.8", GeoLocation{
+			Country:   "IPv4 Land",
+			City:      "Mountain View",
+			Latitude:  37.386,
+			Longitude: -122.0838,
+		}},
+		{"IPv6", "2a00:1450:4001:80b::200e", GeoLocation{Country: "IPv6 Land"}},
+		{"IPv6 expanded", " 2A00:1450:4001:080B:0000:0000:0000:200E\n", GeoLocation{Country: "IPv6 Land"}},
+		{"IPv6 bracketed", "[2a00:1450:4001:80b::200e]", GeoLocation{Country: "IPv6 Land"}},
+		{"IPv4-mapped", "::ffff:8.8.8.8", GeoLocation{
+			Country:   "IPv4 Land",
+			City:      "Mountain View",
+			Latitude:  37.386,
+			Longitude: -122.0838,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			geo, err := GetGeoLocation(tt.ip, dbPath)
+			if err != nil {
+				t.Fatalf("GetGeoLocation(%s) error = %v", tt.ip, err)
+			}
+			if *geo != tt.expected {
+				t.Errorf("GetGeoLocation(%s) = %+v, want %+v", tt.ip, *geo, tt.expected)
+			}
+		})
+	}
+
+	if _, err := GetGeoLocation("not-an-ip", dbPath); err == nil {
+		t.Error("Expected error for invalid IP address")
+	}
+}
+
+func TestGetASN(t *testing.T) {
+	dbPath := writeTestASNDB(t)
+
+	tests := []struct {
+		name     string
+		ip       string
+		expected ASNInfo
+	}{
+		{"IPv4", "8.8.8.8", ASNInfo{ASN: 15169, Org: "GOOGLE"}},
+		{"IPv6", "2003::1", ASNInfo{ASN: 3320, Org: "Deutsche Telekom AG"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asn, err := GetASN(tt.ip, dbPath)
+			if err != nil {
+				t.Fatalf("GetASN(%s) error = %v", tt.ip, err)
+			}
+			if *asn != tt.expected {
+				t.Errorf("GetASN(%s) = %+v, want %+v", tt.ip, *asn, tt.expected)
+			}
+		})
+	}
+
+	// A City database cannot answer ASN lookups
+	if _, err := GetASN("8.8.8.8", writeTestGeoIPDB(t)); err == nil {
+		t.Error("Expected error for a database without ASN data")
+	}
+}
+
+// writeTestGeoIPDB writes a minimal IPv6 GeoLite2-City database and returns
+// its path. IPv4 addresses (::/3, which holds the IPv4 subtree) resolve to
+// "IPv4 Land" with a Mountain View city record and global unicast IPv6
+// addresses (2000::/3) to "IPv6 Land" without one.
+func writeTestGeoIPDB(t *testing.T) string {
+	t.Helper()
+
+	names := func(name string) []byte {
+		return mmdbMap(mmdbString("names"), mmdbMap(mmdbString("en"), mmdbString(name)))
+	}
+	v4Data := mmdbMap(
+		mmdbString("city"), names("Mountain View"),
+		mmdbString("country"), names("IPv4 Land"),
+		mmdbString("location"), mmdbMap(
+			mmdbString("latitude"), mmdbDouble(37.386),
+			mmdbString("longitude"), mmdbDouble(-122.0838),
+		),
+	)
+	v6Data := mmdbMap(mmdbString("country"), names("IPv6 Land"))
+
+	return writeTestMMDB(t, "GeoLite2-City", v4Data, v6Data)
+}
+
+// writeTestASNDB writes a minimal IPv6 GeoLite2-ASN database and returns its
+// path. IPv4 addresses belong to AS15169 and global unicast IPv6 addresses to
+// AS3320.
+func writeTestASNDB(t *testing.T) string {
+	t.Helper()
+
+	as := func(number uint32, org string) []byte {
+		return mmdbMap(
+			mmdbString("autonomous_system_number"), mmdbUint32(number),
+			mmdbString("autonomous_system_organization"), mmdbString(org),
+		)
+	}
+	return writeTestMMDB(t, "GeoLite2-ASN", as(15169, "GOOGLE"), as(3320, "Deutsche Telekom AG"))
+}
+
+// writeTestMMDB writes an IPv6 MaxMind database of databaseType in which
+// IPv4 addresses (::/3, which holds the IPv4 subtree) resolve to v4Data and
+// global unicast IPv6 addresses (2000::/3) to v6Data, and returns its path
+func writeTestMMDB(t *testing.T, databaseType string, v4Data, v6Data []byte) string {
+	t.Helper()
+
+	// Search tree of 24-bit records: bits 0 and 1 must be 0, bit 2 selects
+	// the IPv4 or IPv6 record. A record equal to nodeCount means no data.
+	const nodeCount = 3
+	dataRecord := func(offset int) uint32 { return uint32(nodeCount + 16 + offset) }
+	nodes := [nodeCount][2]uint32{
+		{1, nodeCount},
+		{2, nodeCount},
+		{dataRecord(0), dataRecord(len(v4Data))},
+	}
+
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		for _, record := range node {
+			buf.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	buf.Write(make([]byte, 16)) // data section separator
+	buf.Write(v4Data)
+	buf.Write(v6Data)
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+	buf.Write(mmdbMap(
+		mmdbString("binary_format_major_version"), mmdbUint16(2),
+		mmdbString("binary_format_minor_version"), mmdbUint16(0),
+		mmdbString("build_epoch"), mmdbUint32(1700000000),
+		mmdbString("database_type"), mmdbString(databaseType),
+		mmdbString("description"), mmdbMap(mmdbString("en"), mmdbString("Test database")),
+		mmdbString("ip_version"), mmdbUint16(6),
+		mmdbString("languages"), mmdbArray(mmdbString("en")),
+		mmdbString("node_count"), mmdbUint32(nodeCount),
+		mmdbString("record_size"), mmdbUint16(24),
+	))
+
+	path := filepath.Join(t.TempDir(), databaseType+".mmdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write test MaxMind database: %v", err)
+	}
+	return path
+}
+
+// MaxMind DB data section encoders for the small values used in tests
+// (strings up to 284 bytes, other sizes below 29)
+
+func mmdbString(s string) []byte {
+	if len(s) >= 29 {
+		// Sizes 29 to 284 are stored as 29 plus the remainder in the next byte
+		return append([]byte{2<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func mmdbUint16(v uint16) []byte {
+	return []byte{5<<5 | 2, byte(v >> 8), byte(v)}
+}
+
+func mmdbUint32(v uint32) []byte {
+	return []byte{6<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func mmdbDouble(v float64) []byte {
+	out := []byte{3<<5 | 8}
+	return binary.BigEndian.AppendUint64(out, math.Float64bits(v))
+}
+
+func mmdbMap(pairs ...[]byte) []byte {
+	out := []byte{7<<5 | byte(len(pairs)/2)}
+	for _, field := range pairs {
+		out = append(out, field...)
+	}
+	return out
+}
+
+func mmdbArray(items ...[]byte) []byte {
+	// Arrays are an extended type: type 11 is stored as 11-7 in the next byte
+	out := []byte{byte(len(items)), 11 - 7}
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func TestParseReverseDNSMap(t *testing.T) {
+	data := `base_reverse_dns,name,type
+google.com,Google,Email Provider
+Mcsv.net , Intuit Mailchimp,Marketing
+"example.net","Example, Inc."
+`
+	m, err := ParseReverseDNSMap(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseReverseDNSMap() error = %v", err)
+	}
+
+	want := ReverseDNSMap{
+		"google.com":  {Name: "Google", Type: "Email Provider"},
+		"mcsv.net":    {Name: "Intuit Mailchimp", Type: "Marketing"},
+		"example.net": {Name: "Example, Inc."},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("ParseReverseDNSMap() = %v, want %v", m, want)
+	}
+
+	if service, ok := m.Lookup("Google.com"); !ok || service.Name != "Google" {
+		t.Errorf("Lookup(Google.com) = %v, %v", service, ok)
+	}
+	if _, ok := m.Lookup("unknown.example"); ok {
+		t.Error("Expected no service for an unmapped domain")
+	}
+
+	if _, err := ParseReverseDNSMap(strings.NewReader("google.com\n")); err == nil {
+		t.Error("Expected error for a line without a name")
+	}
+}
+
+func TestReverseDNSMapSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "base_reverse_dns_map.csv")
+	if err := os.WriteFile(path, []byte("google.com,Google,Email Provider\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write map: %v", err)
+	}
+	m, err := ReadReverseDNSMapFile(path)
+	if err != nil || m["google.com"].Name != "Google" {
+		t.Errorf("ReadReverseDNSMapFile() = %v, %v", m, err)
+	}
+	if _, err := ReadReverseDNSMapFile(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/map.csv" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("base_reverse_dns,name,type\noutlook.com,Microsoft,Email Provider\n"))
+	}))
+	defer server.Close()
+
+	m, err = DownloadReverseDNSMap(server.URL + "/map.csv")
+	if err != nil || m["outlook.com"].Name != "Microsoft" {
+		t.Errorf("DownloadReverseDNSMap() = %v, %v", m, err)
+	}
+	if _, err := DownloadReverseDNSMap(server.URL + "/missing.csv"); err == nil {
+		t.Error("Expected error for a 404 response")
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	var queries []string
+	resolver := func(ipAddress string) (string, error) {
+		queries = append(queries, ipAddress)
+		if ipAddress == "192.0.2.1" {
+			return "", errors.New("no PTR records found")
+		}
+		return "host-" + ipAddress + ".example.com", nil
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewDNSCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		hostname, err := cache.Lookup("8.8.8.8", resolver)
+		if err != nil || hostname != "host-8.8.8.8.example.com" {
+			t.Fatalf("Lookup(8.8.8.8) = %q, %v", hostname, err)
+		}
+	}
+	if len(queries) != 1 {
+		t.Fatalf("Expected a second lookup to be served from cache, got queries %v", queries)
+	}
+
+	// Failed lookups are cached too
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Lookup("192.0.2.1", resolver); err == nil {
+			t.Fatal("Expected cached lookup error")
+		}
+	}
+	if len(queries) != 2 {
+		t.Fatalf("Expected a failed lookup to be cached, got queries %v", queries)
+	}
+
+	// 192.0.2.1 is now the least recently used entry and is evicted
+	cache.Lookup("8.8.8.8", resolver)
+	cache.Lookup("1.1.1.1", resolver)
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+	cache.Lookup("8.8.8.8", resolver)
+	cache.Lookup("192.0.2.1", resolver)
+	want := []string{"8.8.8.8", "192.0.2.1", "1.1.1.1", "192.0.2.1"}
+	if !reflect.DeepEqual(queries, want) {
+		t.Errorf("queries = %v, want %v", queries, want)
+	}
+
+	// Entries are looked up again once expired
+	now = now.Add(time.Minute)
+	cache.Lookup("8.8.8.8", resolver)
+	if got := queries[len(queries)-1]; len(queries) != 5 || got != "8.8.8.8" {
+		t.Errorf("Expected an expired entry to be looked up again, got queries %v", queries)
+	}
+}
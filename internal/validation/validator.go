@@ -1,16 +1,22 @@
 package validation
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"net"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"go.uber.org/zap"
+	"parsedmarc-go/internal/parser"
+	"parsedmarc-go/internal/utils"
 )
 
 // Validator handles validation of DMARC reports and related data
@@ -36,6 +42,13 @@ type ValidationResult struct {
 func (v *Validator) ValidateXMLReport(data []byte) *ValidationResult {
 	result := &ValidationResult{Valid: true}
 
+	// Reject declarations used by XXE attacks and XML bombs before decoding
+	if err := parser.CheckXMLSafety(data); err != nil {
+		result.Valid = false
+		result.Errors = append(result.Errors, "Unsafe XML: "+err.Error())
+		return result
+	}
+
 	// Check if data is valid XML
 	if !v.isValidXML(data) {
 		result.Valid = false
@@ -71,7 +84,7 @@ func (v *Validator) ValidateXMLReport(data []byte) *ValidationResult {
 		} `xml:"record"`
 	}
 
-	if err := xml.Unmarshal(data, &feedback); err != nil {
+	if err := unmarshalXML(data, &feedback); err != nil {
 		result.Valid = false
 		result.Errors = append(result.Errors, fmt.Sprintf("Failed to parse XML: %v", err))
 		return result
@@ -153,6 +166,146 @@ func (v *Validator) ValidateJSONReport(data []byte) *ValidationResult {
 	return result
 }
 
+// smtpTLSPolicyTypes are the policy-type values defined by RFC 8460
+var smtpTLSPolicyTypes = []string{"tlsa", "sts", "no-policy-found"}
+
+// ValidateSMTPTLSReport validates an SMTP TLS JSON report against the
+// required fields of RFC 8460 section 4
+func (v *Validator) ValidateSMTPTLSReport(data []byte) *ValidationResult {
+	result := &ValidationResult{Valid: true}
+
+	var report struct {
+		OrganizationName string `json:"organization-name"`
+		DateRange        struct {
+			StartDatetime string `json:"start-datetime"`
+			EndDatetime   string `json:"end-datetime"`
+		} `json:"date-range"`
+		ContactInfo string `json:"contact-info"`
+		ReportID    string `json:"report-id"`
+		Policies    []struct {
+			Policy struct {
+				PolicyType   string `json:"policy-type"`
+				PolicyDomain string `json:"policy-domain"`
+			} `json:"policy"`
+			Summary struct {
+				TotalSuccessfulSessionCount int `json:"total-successful-session-count"`
+				TotalFailureSessionCount    int `json:"total-failure-session-count"`
+			} `json:"summary"`
+		} `json:"policies"`
+	}
+
+	if err := json.Unmarshal(data, &report); err != nil {
+		result.Valid = false
+		result.Errors = append(result.Errors, fmt.Sprintf("Failed to parse JSON: %v", err))
+		return result
+	}
+
+	// Validate required fields
+	if report.OrganizationName == "" {
+		result.Valid = false
+		result.Errors = append(result.Errors, "Missing organization-name")
+	}
+
+	if report.ReportID == "" {
+		result.Valid = false
+		result.Errors = append(result.Errors, "Missing report-id")
+	}
+
+	if report.ContactInfo == "" {
+		result.Warnings = append(result.Warnings, "Missing contact-info")
+	}
+
+	// Validate date range
+	if report.DateRange.StartDatetime == "" || report.DateRange.EndDatetime == "" {
+		result.Valid = false
+		result.Errors = append(result.Errors, "Missing date-range start-datetime or end-datetime")
+	} else {
+		begin, beginErr := time.Parse(time.RFC3339, report.DateRange.StartDatetime)
+		end, endErr := time.Parse(time.RFC3339, report.DateRange.EndDatetime)
+		switch {
+		case beginErr != nil:
+			result.Valid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("Invalid date range: invalid start-datetime: %s", report.DateRange.StartDatetime))
+		case endErr != nil:
+			result.Valid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("Invalid date range: invalid end-datetime: %s", report.DateRange.EndDatetime))
+		case end.Before(begin):
+			result.Valid = false
+			result.Errors = append(result.Errors, "Invalid date range: end-datetime is before start-datetime")
+		}
+	}
+
+	// Validate policies
+	if len(report.Policies) == 0 {
+		result.Warnings = append(result.Warnings, "No policies found in report")
+	}
+	for i, policy := range report.Policies {
+		if !slices.Contains(smtpTLSPolicyTypes, policy.Policy.PolicyType) {
+			result.Valid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("Policy %d has invalid policy-type: %q", i+1, policy.Policy.PolicyType))
+		}
+
+		if policy.Policy.PolicyDomain == "" {
+			result.Valid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("Policy %d missing policy-domain", i+1))
+		}
+
+		if policy.Summary.TotalSuccessfulSessionCount < 0 || policy.Summary.TotalFailureSessionCount < 0 {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Policy %d has negative session counts", i+1))
+		}
+	}
+
+	return result
+}
+
+// forensicFeedbackTypes are the ARF feedback types of RFC 5965, RFC 6430
+// and RFC 6591
+var forensicFeedbackTypes = []string{"abuse", "auth-failure", "fraud", "not-spam", "other", "virus"}
+
+// forensicAuthFailures are the auth-failure values of RFC 6591 section
+// 3.2.2, plus "dkim" and "dmarc" as emitted by RFC 7489 reporters
+var forensicAuthFailures = []string{"adsp", "bodyhash", "revoked", "signature", "spf", "dkim", "dmarc"}
+
+// ValidateForensicReport validates a parsed DMARC forensic report
+func (v *Validator) ValidateForensicReport(report *parser.ForensicReport) *ValidationResult {
+	result := &ValidationResult{Valid: true}
+
+	if !slices.Contains(forensicFeedbackTypes, strings.ToLower(report.FeedbackType)) {
+		result.Valid = false
+		result.Errors = append(result.Errors, fmt.Sprintf("Invalid feedback type: %q", report.FeedbackType))
+	}
+
+	if !v.isValidIP(report.Source.IPAddress) {
+		result.Valid = false
+		result.Errors = append(result.Errors, fmt.Sprintf("Invalid source IP: %s", report.Source.IPAddress))
+	}
+
+	if report.ReportedDomain == "" {
+		result.Valid = false
+		result.Errors = append(result.Errors, "Missing reported domain")
+	} else if !v.isValidDomain(report.ReportedDomain) {
+		result.Valid = false
+		result.Errors = append(result.Errors, fmt.Sprintf("Invalid reported domain: %s", report.ReportedDomain))
+	}
+
+	// Allow for clock skew as for aggregate report date ranges
+	if report.ArrivalDateUTC.IsZero() {
+		result.Warnings = append(result.Warnings, "Missing arrival date")
+	} else if report.ArrivalDateUTC.After(time.Now().UTC().Add(24 * time.Hour)) {
+		result.Valid = false
+		result.Errors = append(result.Errors, "Arrival date is too far in the future")
+	}
+
+	for _, failure := range report.AuthFailure {
+		if !slices.Contains(forensicAuthFailures, strings.ToLower(failure)) {
+			result.Valid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("Invalid auth failure: %q", failure))
+		}
+	}
+
+	return result
+}
+
 // ValidateBase64Content validates base64 encoded content
 func (v *Validator) ValidateBase64Content(content string) *ValidationResult {
 	result := &ValidationResult{Valid: true}
@@ -187,6 +340,10 @@ func (v *Validator) ValidateReportSize(size int64, maxSize int64) *ValidationRes
 	return result
 }
 
+// MaxIdentifierLength is the maximum length of report identifiers such as
+// report_id, org_name and domain
+const MaxIdentifierLength = 255
+
 // ValidateReportID checks if report ID follows expected format
 func (v *Validator) ValidateReportID(reportID string) *ValidationResult {
 	result := &ValidationResult{Valid: true}
@@ -198,9 +355,9 @@ func (v *Validator) ValidateReportID(reportID string) *ValidationResult {
 	}
 
 	// Report ID should be reasonable length
-	if len(reportID) > 255 {
+	if len(reportID) > MaxIdentifierLength {
 		result.Valid = false
-		result.Errors = append(result.Errors, "Report ID too long (max 255 characters)")
+		result.Errors = append(result.Errors, fmt.Sprintf("Report ID too long (max %d characters)", MaxIdentifierLength))
 	}
 
 	// Check for potentially dangerous characters
@@ -215,7 +372,17 @@ func (v *Validator) ValidateReportID(reportID string) *ValidationResult {
 // Helper validation methods
 
 func (v *Validator) isValidXML(data []byte) bool {
-	return xml.Unmarshal(data, &struct{}{}) == nil
+	return unmarshalXML(data, &struct{}{}) == nil
+}
+
+// unmarshalXML decodes data like the parser does, reading documents that
+// declare a non-UTF-8 encoding as they are rather than failing
+func unmarshalXML(data []byte, v any) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder.Decode(v)
 }
 
 func (v *Validator) isValidJSON(data []byte) bool {
@@ -312,22 +479,7 @@ func (v *Validator) parseTimestamp(timestamp string) (time.Time, error) {
 }
 
 func (v *Validator) containsDangerousChars(input string) bool {
-	dangerousChars := []string{
-		"<", ">", "&", "\"", "'",
-		"\x00", "\r", "\n",
-		"../", "..\\",
-		"<script", "javascript:",
-		"<?php", "<%",
-	}
-
-	inputLower := strings.ToLower(input)
-	for _, dangerous := range dangerousChars {
-		if strings.Contains(inputLower, dangerous) {
-			return true
-		}
-	}
-
-	return false
+	return utils.ContainsDangerousSequence(input)
 }
 
 // SanitizeInput sanitizes input string for safe processing
@@ -347,19 +499,29 @@ func (v *Validator) SanitizeInput(input string) string {
 	return strings.TrimSpace(result)
 }
 
-// ValidateBatch validates multiple reports in batch
-func (v *Validator) ValidateBatch(reports [][]byte, maxReports int) *ValidationResult {
+// ValidateBatchSize checks that a batch holds between 1 and maxReports
+// reports. A maxReports of 0 or less allows any number.
+func (v *Validator) ValidateBatchSize(count, maxReports int) *ValidationResult {
 	result := &ValidationResult{Valid: true}
 
-	if len(reports) == 0 {
+	if count == 0 {
 		result.Valid = false
 		result.Errors = append(result.Errors, "No reports to validate")
 		return result
 	}
 
-	if maxReports > 0 && len(reports) > maxReports {
+	if maxReports > 0 && count > maxReports {
 		result.Valid = false
-		result.Errors = append(result.Errors, fmt.Sprintf("Too many reports (%d), maximum allowed is %d", len(reports), maxReports))
+		result.Errors = append(result.Errors, fmt.Sprintf("Too many reports (%d), maximum allowed is %d", count, maxReports))
+	}
+
+	return result
+}
+
+// ValidateBatch validates multiple reports in batch
+func (v *Validator) ValidateBatch(reports [][]byte, maxReports int) *ValidationResult {
+	result := v.ValidateBatchSize(len(reports), maxReports)
+	if !result.Valid {
 		return result
 	}
 
@@ -0,0 +1,205 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+	"parsedmarc-go/internal/parser"
+)
+
+const validSMTPTLSReport = `{
+	"organization-name": "Company-X",
+	"date-range": {
+		"start-datetime": "2016-04-01T00:00:00Z",
+		"end-datetime": "2016-04-01T23:59:59Z"
+	},
+	"contact-info": "sts-reporting@company-x.example",
+	"report-id": "5065427c-23d3-47ca-b6e0-946ea0e8c4be",
+	"policies": [{
+		"policy": {
+			"policy-type": "sts",
+			"policy-string": ["version: STSv1", "mode: testing", "mx: *.mail.company-y.example", "max_age: 86400"],
+			"policy-domain": "company-y.example",
+			"mx-host": ["*.mail.company-y.example"]
+		},
+		"summary": {
+			"total-successful-session-count": 5326,
+			"total-failure-session-count": 303
+		}
+	}]
+}`
+
+func TestValidateSMTPTLSReport(t *testing.T) {
+	v := New(zaptest.NewLogger(t))
+
+	tests := []struct {
+		name     string
+		data     string
+		valid    bool
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:  "Valid report",
+			data:  validSMTPTLSReport,
+			valid: true,
+		},
+		{
+			name: "Missing required fields",
+			data: `{
+				"date-range": {"start-datetime": "2016-04-01T00:00:00Z"},
+				"policies": [{"policy": {"policy-type": "sts", "policy-domain": "company-y.example"}}]
+			}`,
+			errors: []string{
+				"Missing organization-name",
+				"Missing report-id",
+				"Missing date-range start-datetime or end-datetime",
+			},
+			warnings: []string{"Missing contact-info"},
+		},
+		{
+			name: "Invalid policies",
+			data: `{
+				"organization-name": "Company-X",
+				"date-range": {"start-datetime": "2016-04-02T00:00:00Z", "end-datetime": "2016-04-01T00:00:00Z"},
+				"contact-info": "sts-reporting@company-x.example",
+				"report-id": "report-1",
+				"policies": [
+					{"policy": {"policy-type": "dane", "policy-domain": "company-y.example"}},
+					{"policy": {"policy-type": "no-policy-found"}}
+				]
+			}`,
+			errors: []string{
+				"Invalid date range: end-datetime is before start-datetime",
+				`Policy 1 has invalid policy-type: "dane"`,
+				"Policy 2 missing policy-domain",
+			},
+		},
+		{
+			name: "No policies",
+			data: `{
+				"organization-name": "Company-X",
+				"date-range": {"start-datetime": "2016-04-01T00:00:00Z", "end-datetime": "2016-04-01T23:59:59Z"},
+				"contact-info": "sts-reporting@company-x.example",
+				"report-id": "report-1"
+			}`,
+			valid:    true,
+			warnings: []string{"No policies found in report"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := v.ValidateSMTPTLSReport([]byte(tt.data))
+
+			if result.Valid != tt.valid {
+				t.Errorf("Valid = %v, want %v (errors: %v)", result.Valid, tt.valid, result.Errors)
+			}
+			if !reflect.DeepEqual(result.Errors, tt.errors) {
+				t.Errorf("Errors = %q, want %q", result.Errors, tt.errors)
+			}
+			if !reflect.DeepEqual(result.Warnings, tt.warnings) {
+				t.Errorf("Warnings = %q, want %q", result.Warnings, tt.warnings)
+			}
+		})
+	}
+
+	if result := v.ValidateSMTPTLSReport([]byte("{not json")); result.Valid || len(result.Errors) != 1 {
+		t.Errorf("Expected a single parse error for invalid JSON, got %+v", result)
+	}
+}
+
+func TestValidateXMLReportRejectsUnsafeXML(t *testing.T) {
+	v := New(zaptest.NewLogger(t))
+
+	data := `<?xml version="1.0"?>
+<!DOCTYPE feedback [<!ENTITY xxe SYSTEM "file:///etc/passwd">]>
+<feedback><report_metadata><org_name>&xxe;</org_name></report_metadata></feedback>`
+
+	result := v.ValidateXMLReport([]byte(data))
+	want := []string{"Unsafe XML: " + parser.ErrUnsafeXML.Error()}
+	if result.Valid || !reflect.DeepEqual(result.Errors, want) {
+		t.Errorf("ValidateXMLReport() = %+v, want errors %q", result, want)
+	}
+}
+
+func TestValidateForensicReport(t *testing.T) {
+	v := New(zaptest.NewLogger(t))
+
+	validReport := func() *parser.ForensicReport {
+		return &parser.ForensicReport{
+			FeedbackType:   "auth-failure",
+			ArrivalDateUTC: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+			Source:         parser.Source{IPAddress: "192.0.2.1"},
+			ReportedDomain: "example.com",
+			AuthFailure:    []string{"dmarc", "spf"},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(report *parser.ForensicReport)
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:   "Valid report",
+			modify: func(report *parser.ForensicReport) {},
+		},
+		{
+			name:   "Unknown feedback type",
+			modify: func(report *parser.ForensicReport) { report.FeedbackType = "spam" },
+			errors: []string{`Invalid feedback type: "spam"`},
+		},
+		{
+			name:   "Invalid source IP",
+			modify: func(report *parser.ForensicReport) { report.Source.IPAddress = "192.0.2.300" },
+			errors: []string{"Invalid source IP: 192.0.2.300"},
+		},
+		{
+			name:   "Missing reported domain",
+			modify: func(report *parser.ForensicReport) { report.ReportedDomain = "" },
+			errors: []string{"Missing reported domain"},
+		},
+		{
+			name:   "Invalid reported domain",
+			modify: func(report *parser.ForensicReport) { report.ReportedDomain = "example..com" },
+			errors: []string{"Invalid reported domain: example..com"},
+		},
+		{
+			name:   "Arrival date in the future",
+			modify: func(report *parser.ForensicReport) { report.ArrivalDateUTC = time.Now().Add(48 * time.Hour) },
+			errors: []string{"Arrival date is too far in the future"},
+		},
+		{
+			name:     "Missing arrival date",
+			modify:   func(report *parser.ForensicReport) { report.ArrivalDateUTC = time.Time{} },
+			warnings: []string{"Missing arrival date"},
+		},
+		{
+			name:   "Unknown auth failure",
+			modify: func(report *parser.ForensicReport) { report.AuthFailure = []string{"dmarc", "arc"} },
+			errors: []string{`Invalid auth failure: "arc"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := validReport()
+			tt.modify(report)
+			result := v.ValidateForensicReport(report)
+
+			if result.Valid != (len(tt.errors) == 0) {
+				t.Errorf("Valid = %v, want %v", result.Valid, len(tt.errors) == 0)
+			}
+			if !reflect.DeepEqual(result.Errors, tt.errors) {
+				t.Errorf("Errors = %q, want %q", result.Errors, tt.errors)
+			}
+			if !reflect.DeepEqual(result.Warnings, tt.warnings) {
+				t.Errorf("Warnings = %q, want %q", result.Warnings, tt.warnings)
+			}
+		})
+	}
+}
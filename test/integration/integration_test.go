@@ -141,7 +141,7 @@ func testClickHouseIntegration(t *testing.T, cfg config.ClickHouseConfig, logger
 
 // testKafkaIntegration tests Kafka integration
 func testKafkaIntegration(t *testing.T, cfg config.KafkaConfig, logger *zap.Logger) {
-	kafkaClient := kafka.New(&cfg, logger)
+	kafkaClient := kafka.New(&cfg, logger, nil)
 
 	// Test sending an aggregate report
 	report := createTestAggregateReport()
@@ -152,8 +152,8 @@ func testKafkaIntegration(t *testing.T, cfg config.KafkaConfig, logger *zap.Logg
 // testIMAPIntegration tests IMAP integration
 func testIMAPIntegration(t *testing.T, cfg config.IMAPConfig, logger *zap.Logger) {
 	// Create parser for IMAP client
-	parser := parser.New(config.ParserConfig{}, nil, logger)
-	imapClient := imap.New(cfg, parser, logger)
+	parser := parser.New(config.ParserConfig{}, nil, logger, nil)
+	imapClient := imap.New(cfg, parser, logger, nil)
 
 	// Test connection
 	err := imapClient.Connect()
@@ -174,7 +174,7 @@ func testIMAPIntegration(t *testing.T, cfg config.IMAPConfig, logger *zap.Logger
 
 // testSMTPIntegration tests SMTP integration
 func testSMTPIntegration(t *testing.T, cfg config.SMTPConfig, logger *zap.Logger) {
-	smtpClient := smtp.New(&cfg, logger)
+	smtpClient := smtp.New(&cfg, logger, nil)
 
 	// Test sending aggregate report via email
 	report := createTestAggregateReport()
@@ -185,8 +185,8 @@ func testSMTPIntegration(t *testing.T, cfg config.SMTPConfig, logger *zap.Logger
 // testHTTPIntegration tests HTTP server integration
 func testHTTPIntegration(t *testing.T, cfg config.HTTPConfig, logger *zap.Logger) {
 	// Create parser for HTTP server
-	parser := parser.New(config.ParserConfig{}, nil, logger)
-	httpServer := http.New(cfg, parser, logger)
+	parser := parser.New(config.ParserConfig{}, nil, logger, nil)
+	httpServer := http.New(cfg, parser, logger, nil)
 
 	// Start server in goroutine
 	ctx, cancel := context.WithCancel(context.Background())
@@ -218,10 +218,10 @@ func testEndToEndIntegration(t *testing.T, cfg *TestConfig, logger *zap.Logger)
 	defer storage.Close()
 
 	// Create parser with storage
-	_ = parser.New(config.ParserConfig{}, storage, logger)
+	_ = parser.New(config.ParserConfig{}, storage, logger, nil)
 
 	// Create Kafka client
-	kafkaClient := kafka.New(&cfg.Kafka, logger)
+	kafkaClient := kafka.New(&cfg.Kafka, logger, nil)
 
 	// Test full pipeline: Parse -> Store -> Send to Kafka
 	report := createTestAggregateReport()